@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runActions handles the actions command, which shows the audit log of dedupe
+func (c *CLI) runActions(args []string) error {
+	fs := c.newFlagSet("actions")
+	output := addOutputFlag(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	records, err := c.indexer.Actions(context.Background())
+	if err != nil {
+		return fmt.Errorf("error reading actions: %v", err)
+	}
+	if *output == outputJSON {
+		if records == nil {
+			records = []models.ActionRecord{}
+		}
+		return writeJSON(records)
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No dedupe actions recorded.")
+		return nil
+	}
+	// Duplicates moved into the trash or a quarantine directory still take
+	// their space until they are removed from there, and undoing puts them
+	// back, so they are totalled apart from those linked or deleted
+	var reclaimed, moved int64
+	for _, record := range records {
+		switch {
+		case record.Action == "undo":
+			moved -= record.FileSize
+		case record.Trash != "":
+			moved += record.FileSize
+		default:
+			reclaimed += record.FileSize
+		}
+		path := record.Path
+		if record.Host != "" && record.Host != c.indexer.Host() {
+			path = record.Host + ":" + path
+		}
+		runID := record.RunID
+		if runID == "" {
+			runID = "-"
+		}
+		fmt.Printf("%s %-22s %-8s %s -> %s (%s)\n", record.PerformedAt.Local().Format("2006-01-02 15:04:05"), runID, record.Action,
+			path, record.Original, models.FormatSize(record.FileSize))
+	}
+	fmt.Printf("\n%d actions, %s reclaimed\n", len(records), models.FormatSize(reclaimed))
+	if moved > 0 {
+		fmt.Printf("%s moved to the trash or quarantine, reclaimed once removed from there\n", models.FormatSize(moved))
+	}
+	fmt.Println("Deletions moved to the trash and moves can be undone with dedupe undo RUN-ID")
+	return nil
+}
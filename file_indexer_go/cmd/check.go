@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runCheck handles the check command, which runs the named check on the index
+func (c *CLI) runCheck(args []string) error {
+	fs := c.newFlagSet("check")
+	output := addOutputFlag(fs)
+	var directories stringList
+	fs.Var(&directories, "dir", "Directory to check (repeatable; default: the whole index)")
+	kind := fs.String("kind", string(models.HashSetBlock), "Hash sets known-hashes matches against: block, allow or any")
+	var detectors stringList
+	fs.Var(&detectors, "detector", "Only report the secrets findings of this detector, e.g. aws-access-key (repeatable)")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("the check command requires exactly one check: case-collisions, known-hashes or secrets")
+	}
+	if !slices.Contains([]string{"case-collisions", "known-hashes", "secrets"}, positional[0]) {
+		return fmt.Errorf("unknown check %q (supported: case-collisions, known-hashes, secrets)", positional[0])
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	if len(directories) == 0 {
+		directories = append(directories, "")
+	}
+	if positional[0] == "known-hashes" {
+		return c.checkKnownHashes(directories, *kind, *output)
+	}
+	if positional[0] == "secrets" {
+		return c.checkSecrets(directories, detectors, *output)
+	}
+
+	ctx := context.Background()
+	collisions := []models.CaseCollision{}
+	for _, dir := range directories {
+		dirCollisions, err := c.indexer.CaseCollisions(ctx, dir)
+		if err != nil {
+			return fmt.Errorf("error checking case collisions: %v", err)
+		}
+		collisions = append(collisions, dirCollisions...)
+	}
+
+	if *output == outputJSON {
+		if err := writeJSON(collisions); err != nil {
+			return err
+		}
+	} else if len(collisions) == 0 {
+		fmt.Println("No case collisions found.")
+	} else {
+		for n, collision := range collisions {
+			fmt.Printf("\n--- Collision %d ---\n", n+1)
+			for _, file := range collision.Files {
+				fmt.Printf("  %s (%s)\n", c.location(file), models.FormatSize(file.FileSize))
+			}
+		}
+		fmt.Println()
+	}
+
+	// Fail like verify, so scripts can check a tree before copying it
+	if len(collisions) > 0 {
+		return fmt.Errorf("%d case collisions found", len(collisions))
+	}
+	return nil
+}
+
+// checkKnownHashes reports the indexed files whose checksums are in known
+// hash sets of a kind and fails if there are any, so a blocklist check can
+// gate scripts
+func (c *CLI) checkKnownHashes(directories []string, kind, output string) error {
+	switch kind {
+	case "any":
+		kind = ""
+	case string(models.HashSetAllow), string(models.HashSetBlock):
+	default:
+		return fmt.Errorf("unknown hash set kind %q (supported: block, allow, any)", kind)
+	}
+
+	ctx := context.Background()
+	matches := []models.KnownHashMatch{}
+	for _, dir := range directories {
+		dirMatches, err := c.indexer.KnownHashMatches(ctx, models.HashSetKind(kind), dir)
+		if err != nil {
+			return fmt.Errorf("error matching known hashes: %v", err)
+		}
+		matches = append(matches, dirMatches...)
+	}
+
+	if output == outputJSON {
+		if err := writeJSON(matches); err != nil {
+			return err
+		}
+	} else if len(matches) == 0 {
+		fmt.Println("No files match the known hash sets.")
+	} else {
+		for _, match := range matches {
+			fmt.Printf("[%s] %s (%s, %s checksum in %s)\n", strings.ToUpper(string(match.Kind)), c.location(match.File), models.FormatSize(match.File.FileSize), match.Algorithm, match.Set)
+		}
+	}
+
+	if len(matches) > 0 {
+		return fmt.Errorf("%d files match known hash sets", len(matches))
+	}
+	return nil
+}
+
+// checkSecrets reports the indexed files in which a scan with -secrets found
+// secrets or personal data, optionally only those of some detectors, and
+// fails if there are any
+func (c *CLI) checkSecrets(directories, detectors []string, output string) error {
+	ctx := context.Background()
+	files := []models.FileSecrets{}
+	findings := 0
+	for _, dir := range directories {
+		dirFiles, err := c.indexer.Secrets(ctx, dir)
+		if err != nil {
+			return fmt.Errorf("error listing secrets: %v", err)
+		}
+		for _, file := range dirFiles {
+			if len(detectors) > 0 {
+				file.Findings = slices.DeleteFunc(file.Findings, func(finding models.SecretFinding) bool {
+					return !slices.Contains(detectors, finding.Detector)
+				})
+				if len(file.Findings) == 0 {
+					continue
+				}
+			}
+			files = append(files, file)
+			findings += len(file.Findings)
+		}
+	}
+
+	if output == outputJSON {
+		if err := writeJSON(files); err != nil {
+			return err
+		}
+	} else if len(files) == 0 {
+		fmt.Println("No secrets found.")
+	} else {
+		for _, file := range files {
+			fmt.Printf("%s (%d findings)\n", c.location(file.File), len(file.Findings))
+			for _, finding := range file.Findings {
+				fmt.Printf("  line %d: %s %s\n", finding.Line, finding.Detector, finding.Match)
+			}
+		}
+	}
+
+	if len(files) > 0 {
+		return fmt.Errorf("%d secrets found in %d files", findings, len(files))
+	}
+	return nil
+}
@@ -1,215 +1,329 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
-	"file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
 )
 
 // CLI handles command-line interface operations
 type CLI struct {
+	global  GlobalOptions
 	indexer *indexer.Indexer
 }
 
+// GlobalOptions holds the options shared by all subcommands
+type GlobalOptions struct {
+	IndexPath string
+	UseDB     bool
+	Host      string // name of this machine stored with its files (empty = hostname)
+	ReadOnly  bool
+	DBTuning  db.Tuning // DuckDB's threads, memory limit and temp directory
+}
+
+// command describes a CLI subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(c *CLI, args []string) error
+}
+
+// commands lists all available subcommands in the order they are shown in help
+var commands []command
+
+func init() {
+	commands = []command{
+		{"index", "-dir DIR [-dir DIR...] [options]", "Index one or more directories", (*CLI).runIndex},
+		{"watch", "-dir DIR [options]", "Index a directory and keep the index updated as files change", (*CLI).runWatch},
+		{"daemon", "-schedule CRON -dir DIR [options]", "Index directories on a cron schedule, without overlapping runs", (*CLI).runDaemon},
+		{"presets", "[-output json]", "List the exclude presets of -preset, such as dev for node_modules and .venv", (*CLI).runPresets},
+		{"search", "[options] QUERY", "Search indexed files by name or path", (*CLI).runSearch},
+		{"list", "[options]", "List all indexed files", (*CLI).runList},
+		{"stats", "[options]", "Show index statistics", (*CLI).runStats},
+		{"du", "[-dir DIR] [-depth N]", "Show file counts and sizes per directory, like du", (*CLI).runDu},
+		{"languages", "[-dir DIR]", "Show the files and lines of code per programming language, from scans with -code-metrics", (*CLI).runLanguages},
+		{"duplicates", "[options]", "Find duplicate files (same size and checksum)", (*CLI).runDuplicates},
+		{"duplicate-dirs", "[-min-similarity PERCENT] [-dir DIR]", "Find directories whose trees hold the same files", (*CLI).runDuplicateDirs},
+		{"dedupe", "-action delete|hardlink|symlink|reflink|move [-force] | undo RUN-ID", "Remove, move or link duplicate files (dry run unless -force), or undo a run", (*CLI).runDedupe},
+		{"restore", "-from DIR [-dry-run]", "Move duplicates quarantined by dedupe -move-to back", (*CLI).runRestore},
+		{"similar-images", "[-distance N] [-dir DIR]", "Find resized and re-exported copies of photos indexed with -image-hash", (*CLI).runSimilarImages},
+		{"similar-text", "[-min-similarity PERCENT] [-dir DIR]", "Find edited copies of text files indexed with -text-hash", (*CLI).runSimilarText},
+		{"review", "[options]", "Walk through duplicate groups and choose which copies to delete", (*CLI).runReview},
+		{"tag", "add|remove TAG PATH... | list [TAG]", "Tag indexed files, e.g. keep or reviewed, by checksum so tags survive moves", (*CLI).runTag},
+		{"hash-set", "import -kind allow|block FILE | list | remove NAME", "Import known hash sets such as the NSRL or blocklists (database mode only)", (*CLI).runHashSet},
+		{"actions", "[-output json]", "Show the duplicates that dedupe and review deleted or linked, by run", (*CLI).runActions},
+		{"diff", "[options] OLD NEW", "Compare two indexes (JSON or DuckDB) and list the changed files", (*CLI).runDiff},
+		{"sync-plan", "[-source-dir DIR] [-target-dir DIR] SOURCE TARGET", "Plan copying a directory to another from their indexes, like a dry run of rsync", (*CLI).runSyncPlan},
+		{"history", "[-scan ID] [-after TIME] [-before TIME]", "Show scans recorded with -history and the files they changed", (*CLI).runHistory},
+		{"verify", "[-percent N] [-resume FILE]", "Re-hash indexed files to detect corruption and missing files", (*CLI).runVerify},
+		{"rehash", "[-missing-only] [-dir DIR]", "Re-hash indexed files, e.g. those whose hashing failed, and report why any still fail", (*CLI).runRehash},
+		{"check", "case-collisions | known-hashes [-kind KIND] | secrets [-detector NAME] [-dir DIR]", "Check the index for paths that differ only by case, files in known hash sets, or secrets in their content", (*CLI).runCheck},
+		{"export", "[options]", "Export the index to CSV", (*CLI).runExport},
+		{"import", "-checksums FILE [-verify-percent N]", "Seed the index with the checksums of md5sum or sha256sum manifests or a CSV file", (*CLI).runImport},
+		{"convert", "-from INDEX -to INDEX", "Convert between JSON and DuckDB indexes", (*CLI).runConvert},
+		{"merge", "-out INDEX INDEX...", "Merge several indexes, e.g. of different machines, into one", (*CLI).runMerge},
+		{"serve", "[-addr HOST:PORT]", "Browse the index, search, duplicates and statistics in a web browser", (*CLI).runServe},
+		{"db", "check | backup -out FILE | restore -from FILE [-force]", "Check the database for problems with repair guidance, or back it up and restore it (database mode only)", (*CLI).runDB},
+		{"sql", "[-arg VALUE]... [-sql-output FORMAT] [QUERY]", "Execute custom SQL query, or start a SQL shell without one (database mode only)", (*CLI).runSQL},
+		{"help", "[COMMAND]", "Show help for a command", (*CLI).runHelp},
+	}
+}
+
 // NewCLI creates a new CLI instance
-func NewCLI(indexer *indexer.Indexer) *CLI {
+func NewCLI() *CLI {
+	setLogFormat("text")
 	return &CLI{
-		indexer: indexer,
-	}
-}
-
-// Config holds the CLI configuration
-type Config struct {
-	IndexPath   string
-	Directory   string
-	SearchQuery string
-	ListFiles   bool
-	ShowStats   bool
-	MaxFileSize int64
-	UseDB       bool
-	SQLQuery    string
-}
-
-// ParseFlags parses command-line flags and returns configuration
-func ParseFlags() *Config {
-	var (
-		indexPath   = flag.String("index", "file_index.json", "Path to the index file")
-		directory   = flag.String("dir", "", "Directory to index")
-		searchQuery = flag.String("search", "", "Search query")
-		listFiles   = flag.Bool("list", false, "List all indexed files")
-		showStats   = flag.Bool("stats", false, "Show index statistics")
-		maxFileSize = flag.Int64("max-size", 0, "Maximum file size to index (in bytes, 0 = no limit)")
-		useDB       = flag.Bool("db", false, "Use DuckDB database backend")
-		sqlQuery    = flag.String("sql", "", "Execute custom SQL query (database mode only)")
-	)
-	flag.Parse()
-
-	// Adjust file path for database mode
-	actualIndexPath := *indexPath
-	if *useDB {
-		// Change extension to .db for database files
-		if strings.HasSuffix(actualIndexPath, ".json") {
-			actualIndexPath = strings.TrimSuffix(actualIndexPath, ".json") + ".db"
-		} else if !strings.HasSuffix(actualIndexPath, ".db") {
-			actualIndexPath = actualIndexPath + ".db"
+		global: GlobalOptions{
+			IndexPath: "file_index.json",
+		},
+	}
+}
+
+// Run parses the global options and dispatches to the requested subcommand
+func (c *CLI) Run(args []string) error {
+	global := flag.NewFlagSet("file-indexer", flag.ContinueOnError)
+	c.addGlobalFlags(global)
+	global.Usage = ShowHelp
+
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
 		}
+		return err
 	}
 
-	return &Config{
-		IndexPath:   actualIndexPath,
-		Directory:   *directory,
-		SearchQuery: *searchQuery,
-		ListFiles:   *listFiles,
-		ShowStats:   *showStats,
-		MaxFileSize: *maxFileSize,
-		UseDB:       *useDB,
-		SQLQuery:    *sqlQuery,
+	rest := global.Args()
+	if len(rest) == 0 {
+		ShowHelp()
+		return nil
 	}
-}
 
-// ShowHelp displays the help message
-func ShowHelp() {
-	fmt.Println("File Indexer Tool")
-	fmt.Println("=================")
-	fmt.Println()
-	fmt.Println("Usage:")
-	fmt.Println("  Index a directory:")
-	fmt.Println("    ./file-indexer -dir /path/to/directory [-content] [-max-size SIZE] [-db]")
-	fmt.Println()
-	fmt.Println("  Search for files:")
-	fmt.Println("    ./file-indexer -search 'query' [-db]")
-	fmt.Println()
-	fmt.Println("  List all indexed files:")
-	fmt.Println("    ./file-indexer -list [-db]")
-	fmt.Println()
-	fmt.Println("  Show statistics:")
-	fmt.Println("    ./file-indexer -stats [-db]")
-	fmt.Println()
-	fmt.Println("  Execute SQL query (database mode only):")
-	fmt.Println("    ./file-indexer -sql 'SELECT * FROM files LIMIT 10' -db")
-	fmt.Println()
-	fmt.Println("  Examples:")
-	fmt.Println("    # Index with JSON storage (default)")
-	fmt.Println("    ./file-indexer -dir /path/to/directory -content")
-	fmt.Println()
-	fmt.Println("    # Index with DuckDB database")
-	fmt.Println("    ./file-indexer -dir /path/to/directory -content -db")
-	fmt.Println()
-	fmt.Println("    # Search in database")
-	fmt.Println("    ./file-indexer -search 'TODO' -db")
-	fmt.Println()
-	fmt.Println("    # Custom SQL query")
-	fmt.Println("    ./file-indexer -sql \"SELECT name, size FROM files WHERE size > 1000\" -db")
-	fmt.Println()
-	fmt.Println("Options:")
-	flag.PrintDefaults()
+	cmd := findCommand(rest[0])
+	if cmd == nil {
+		ShowHelp()
+		return fmt.Errorf("unknown command: %s", rest[0])
+	}
+
+	err := cmd.run(c, rest[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
 }
 
-// Run executes the CLI based on the provided configuration
-func (c *CLI) Run(config *Config) error {
-	// Initialize database if needed
-	if config.UseDB {
-		if err := c.indexer.InitDatabase(); err != nil {
-			return fmt.Errorf("error initializing database: %v", err)
+// findCommand looks up a subcommand by name
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
 		}
-		defer c.indexer.CloseDatabase()
 	}
+	return nil
+}
 
-	// Load existing index if it exists and no specific action is requested
-	if config.Directory == "" {
-		if _, err := os.Stat(config.IndexPath); err == nil {
-			if err := c.indexer.LoadIndex(); err != nil {
-				log.Printf("Warning: Could not load existing index: %v", err)
-			}
-		}
+// addGlobalFlags registers the shared options on a flag set. The current
+// values are used as defaults so options given before the subcommand survive
+// being registered again on the subcommand's flag set.
+func (c *CLI) addGlobalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.global.IndexPath, "index", c.global.IndexPath, "Path to the index file")
+	fs.BoolVar(&c.global.UseDB, "db", c.global.UseDB, "Use DuckDB database backend, or PostgreSQL if -index is a postgres:// URL")
+	fs.StringVar(&c.global.Host, "host", c.global.Host, "Name of this machine, stored with the files it indexes and scoping a shared PostgreSQL index (default: the hostname)")
+	fs.BoolVar(&c.global.ReadOnly, "read-only", c.global.ReadOnly, "Open the index read-only and refuse commands that would change it")
+	fs.IntVar(&c.global.DBTuning.Threads, "db-threads", c.global.DBTuning.Threads, "Threads DuckDB uses for queries (default: one per CPU core)")
+	fs.StringVar(&c.global.DBTuning.MemoryLimit, "db-memory-limit", c.global.DBTuning.MemoryLimit, "Memory DuckDB may use, e.g. 4GB (default: 80% of the RAM)")
+	fs.StringVar(&c.global.DBTuning.TempDir, "db-temp-dir", c.global.DBTuning.TempDir, "Directory where DuckDB spills queries that exceed the memory limit")
+	fs.Func("log-level", "Minimum level of log messages: debug, info, warn or error (default info)", setLogLevel)
+	fs.Func("log-format", "Log format: text or json (default text)", setLogFormat)
+}
+
+// newFlagSet creates the flag set for a subcommand, including the global options
+func (c *CLI) newFlagSet(name string) *flag.FlagSet {
+	cmd := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: file-indexer %s %s\n\n", cmd.name, cmd.usage)
+		fmt.Fprintf(fs.Output(), "%s\n\nOptions:\n", cmd.summary)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	// Index directory
-	if config.Directory != "" {
-		if err := c.indexer.IndexDirectory(config.Directory, config.MaxFileSize); err != nil {
-			return fmt.Errorf("error indexing directory: %v", err)
+// parseFlags parses flags that may be interspersed with positional arguments
+// and returns the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
 		}
-
-		if err := c.indexer.SaveIndex(); err != nil {
-			return fmt.Errorf("error saving index: %v", err)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
 		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
+}
 
-	// Execute SQL query
-	if config.SQLQuery != "" {
-		if err := c.indexer.ExecuteSQL(config.SQLQuery); err != nil {
-			return fmt.Errorf("error executing SQL: %v", err)
-		}
+// stringList is a flag value that collects repeated occurrences of a flag
+type stringList []string
+
+// String returns the collected values
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends a value
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// commaList splits the comma-separated values of a repeatable flag
+func commaList(values stringList) []string {
+	var list []string
+	for _, value := range values {
+		list = append(list, strings.Split(value, ",")...)
 	}
+	return list
+}
 
-	// Search
-	if config.SearchQuery != "" {
-		return c.handleSearch(config.SearchQuery)
+// indexPath returns the index path adjusted for the selected backend
+func (c *CLI) indexPath() string {
+	actualIndexPath := c.global.IndexPath
+	if c.global.UseDB && !db.IsPostgresURL(actualIndexPath) {
+		// Change extension to .db for database files
+		for _, ext := range []string{".gz", ".zst", ".zstd"} {
+			if trimmed, ok := strings.CutSuffix(actualIndexPath, ".json"+ext); ok {
+				actualIndexPath = trimmed + ".json"
+			}
+		}
+		if strings.HasSuffix(actualIndexPath, ".json") {
+			actualIndexPath = strings.TrimSuffix(actualIndexPath, ".json") + ".db"
+		} else if !strings.HasSuffix(actualIndexPath, ".db") {
+			actualIndexPath = actualIndexPath + ".db"
+		}
 	}
+	return actualIndexPath
+}
+
+// isDatabasePath reports whether a path names a DuckDB or PostgreSQL index
+func isDatabasePath(path string) bool {
+	return strings.HasSuffix(path, ".db") || strings.HasSuffix(path, ".duckdb") || db.IsPostgresURL(path)
+}
 
-	// List files
-	if config.ListFiles {
-		return c.handleListFiles()
+// openIndex creates the indexer for the global options, initializes the
+// database if needed and optionally loads an existing index. The returned
+// function releases the underlying resources.
+func (c *CLI) openIndex(load bool) (func(), error) {
+	path := c.indexPath()
+	c.indexer = indexer.NewIndexer(path, c.global.UseDB)
+	c.indexer.SetHost(c.global.Host)
+	c.indexer.SetReadOnly(c.global.ReadOnly)
+	c.indexer.SetDBTuning(c.global.DBTuning)
+
+	if err := c.indexer.InitDatabase(context.Background()); err != nil {
+		return nil, fmt.Errorf("error opening index: %v", err)
 	}
 
-	// Show statistics
-	if config.ShowStats {
-		return c.handleShowStats()
+	if load {
+		if _, err := os.Stat(path); err == nil {
+			if err := c.indexer.LoadIndex(); err != nil {
+				slog.Warn("Could not load existing index", "err", err)
+			}
+		}
 	}
 
-	return nil
+	return func() { c.indexer.CloseDatabase() }, nil
 }
 
-// handleSearch handles the search operation
-func (c *CLI) handleSearch(query string) error {
-	results := c.indexer.Search(query)
-	fmt.Printf("Search results for '%s':\n", query)
-	fmt.Printf("Found %d files:\n\n", len(results))
-
-	for i, file := range results {
-		fmt.Printf("%d. %s", i+1, file.Path)
-		fmt.Printf(" (%d bytes)", file.FileSize)
-		fmt.Println()
+// openQueryIndex opens the index for search and list. The files of an
+// existing JSON index are streamed from its file rather than loaded, so
+// queries on large indexes only hold the matching files in memory.
+func (c *CLI) openQueryIndex() (func(), error) {
+	closeIndex, err := c.openIndex(false)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if _, err := os.Stat(c.indexPath()); err == nil {
+		c.indexer.StreamIndex()
+	}
+	return closeIndex, nil
 }
 
-// handleListFiles handles the list files operation
-func (c *CLI) handleListFiles() error {
-	files := c.indexer.ListFiles()
-	fmt.Printf("Indexed files (%d total):\n\n", len(files))
+// interruptible returns a context that is cancelled on Ctrl-C or SIGTERM, so
+// long-running commands can stop cleanly. A second signal kills the process.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
 
-	for i, file := range files {
-		fmt.Printf("%d. %s", i+1, file.Path)
-		fmt.Printf(" (%d bytes)", file.FileSize)
-		fmt.Println()
+// ShowHelp displays the help message
+func ShowHelp() {
+	fmt.Println("File Indexer Tool")
+	fmt.Println("=================")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  file-indexer [-db] [-index PATH] COMMAND [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-12s %s\n", cmd.name, cmd.summary)
 	}
-	return nil
+	fmt.Println()
+	fmt.Println("Global options (accepted before or after the command):")
+	fmt.Println("  -db                    Use DuckDB database backend, or PostgreSQL for postgres:// index URLs")
+	fmt.Println("  -index PATH            Path to the index file (default \"file_index.json\")")
+	fmt.Println("  -host NAME             Name of this machine, stored with its files (default: hostname)")
+	fmt.Println("  -read-only             Open the index read-only and refuse commands that would change it")
+	fmt.Println("  -db-threads N          Threads DuckDB uses for queries (default: one per CPU core)")
+	fmt.Println("  -db-memory-limit SIZE  Memory DuckDB may use, e.g. 4GB (default: 80% of the RAM)")
+	fmt.Println("  -db-temp-dir DIR       Directory where DuckDB spills queries over the memory limit")
+	fmt.Println("  -log-level LEVEL       Minimum log level: debug, info, warn or error (default info)")
+	fmt.Println("  -log-format FORMAT     Log format: text or json (default text)")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  # Index with JSON storage (default)")
+	fmt.Println("  ./file-indexer index -dir /path/to/directory -content")
+	fmt.Println()
+	fmt.Println("  # Index with DuckDB database")
+	fmt.Println("  ./file-indexer index -dir /path/to/directory -content -db")
+	fmt.Println()
+	fmt.Println("  # Search in database")
+	fmt.Println("  ./file-indexer search 'report' -db")
+	fmt.Println()
+	fmt.Println("  # Custom SQL query")
+	fmt.Println("  ./file-indexer sql \"SELECT filename, file_size FROM files WHERE file_size > 1000\" -db")
+	fmt.Println()
+	fmt.Println("Run 'file-indexer help COMMAND' for the options of a command.")
 }
 
-// handleShowStats handles the show statistics operation
-func (c *CLI) handleShowStats() error {
-	stats := c.indexer.GetStats()
-	fmt.Println("Index Statistics:")
-	fmt.Println("=================")
-	fmt.Printf("Total files: %v\n", stats["total_files"])
-	fmt.Printf("Total size: %v bytes\n", stats["total_size"])
-	fmt.Printf("Indexed time: %v\n", stats["indexed_time"])
-	fmt.Printf("Root path: %v\n", stats["root_path"])
-
-	if fileTypes, ok := stats["file_types"].(map[string]int); ok {
-		fmt.Println("\nFile types:")
-		for ext, count := range fileTypes {
-			if ext == "" {
-				fmt.Printf("  No extension: %d\n", count)
-			} else {
-				fmt.Printf("  %s: %d\n", ext, count)
-			}
-		}
+// runHelp shows the general help or the options of a single command
+func (c *CLI) runHelp(args []string) error {
+	if len(args) == 0 {
+		ShowHelp()
+		return nil
 	}
-	return nil
+
+	cmd := findCommand(args[0])
+	if cmd == nil || cmd.name == "help" {
+		ShowHelp()
+		return nil
+	}
+	return cmd.run(c, []string{"-h"})
 }
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+)
+
+// runConvert handles the convert command
+func (c *CLI) runConvert(args []string) error {
+	fs := c.newFlagSet("convert")
+	from := fs.String("from", "", "Source index (.json or .db)")
+	to := fs.String("to", "", "Target index (.json or .db)")
+	force := fs.Bool("force", false, "Overwrite the target if it already exists")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return fmt.Errorf("the convert command requires -from and -to")
+	}
+	if _, err := os.Stat(*from); err != nil {
+		return fmt.Errorf("source index not found: %v", err)
+	}
+	if _, err := os.Stat(*to); err == nil && !*force {
+		return fmt.Errorf("target %s already exists (use -force to overwrite)", *to)
+	}
+
+	source, closeSource, err := openIndexAt(*from)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	index, err := source.ExportIndex(context.Background())
+	if err != nil {
+		return fmt.Errorf("error reading source index: %v", err)
+	}
+
+	target, closeTarget, err := createIndexAt(*to)
+	if err != nil {
+		return err
+	}
+	defer closeTarget()
+
+	if err := target.ImportIndex(context.Background(), index); err != nil {
+		return fmt.Errorf("error writing target index: %v", err)
+	}
+	if err := target.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving target index: %v", err)
+	}
+
+	slog.Info("Converted index", "files", len(index.Files), "from", *from, "to", *to)
+	return nil
+}
+
+// openIndexAt opens an existing index, choosing the backend from the file
+// extension, and loads it
+func openIndexAt(path string) (*indexer.Indexer, func(), error) {
+	idx, closeIndex, err := createIndexAt(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := idx.LoadIndex(); err != nil {
+		closeIndex()
+		return nil, nil, fmt.Errorf("error loading index %s: %v", path, err)
+	}
+	return idx, closeIndex, nil
+}
+
+// createIndexAt creates an indexer for a path, choosing the backend from the
+// file extension, without loading existing contents
+func createIndexAt(path string) (*indexer.Indexer, func(), error) {
+	idx := indexer.NewIndexer(path, isDatabasePath(path))
+	if err := idx.InitDatabase(context.Background()); err != nil {
+		return nil, nil, fmt.Errorf("error initializing database %s: %v", path, err)
+	}
+	return idx, func() { idx.CloseDatabase() }, nil
+}
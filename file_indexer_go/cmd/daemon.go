@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/schedule"
+)
+
+// maxDaemonRuns is the number of past runs kept for the status endpoint
+const maxDaemonRuns = 20
+
+// daemonRun is a scheduled scan carried out by the daemon
+type daemonRun struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	Files    int       `json:"files,omitempty"` // files in the index after the run
+	Error    string    `json:"error,omitempty"`
+}
+
+// daemonStatus is what the status endpoint of the daemon reports
+type daemonStatus struct {
+	mu sync.Mutex
+
+	Schedule    string      `json:"schedule"`
+	Dirs        []string    `json:"dirs"`
+	Index       string      `json:"index"`
+	Since       time.Time   `json:"since"`
+	Running     *daemonRun  `json:"running,omitempty"`
+	NextRun     time.Time   `json:"next_run,omitzero"`
+	Skipped     int         `json:"skipped"` // scheduled times missed because a run was still going
+	Runs        []daemonRun `json:"runs"`    // the latest runs, newest first
+	LastSuccess time.Time   `json:"last_success,omitzero"`
+}
+
+// ServeHTTP writes the status as JSON
+func (s *daemonStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(s)
+}
+
+// runDaemon handles the daemon command, which indexes directories on a cron
+// schedule until interrupted
+func (c *CLI) runDaemon(args []string) error {
+	fs := c.newFlagSet("daemon")
+	var directories stringList
+	fs.Var(&directories, "dir", "Directory to index on every run (repeatable)")
+	scheduleExpr := fs.String("schedule", "", "Cron expression of the runs, e.g. '0 3 * * *' for 3:00 every night, or @hourly or @daily")
+	runNow := fs.Bool("run-now", false, "Also run a scan right away instead of waiting for the first scheduled time")
+	statusAddr := fs.String("status-addr", "", "Serve the daemon's status as JSON at http://ADDR/status, e.g. 127.0.0.1:8089")
+	logFile := fs.String("log-file", "", "Also append the log to this file")
+	scanOptions := addScanFlags(fs)
+	hookOptions := addHookFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if len(directories) == 0 || *scheduleExpr == "" {
+		fs.Usage()
+		return fmt.Errorf("the daemon command requires -dir and -schedule")
+	}
+	cron, err := schedule.Parse(*scheduleExpr)
+	if err != nil {
+		return err
+	}
+	opts, err := scanOptions()
+	if err != nil {
+		return err
+	}
+	hooks, err := hookOptions(c.indexPath())
+	if err != nil {
+		return err
+	}
+	if *logFile != "" {
+		closeLog, err := appendLogFile(*logFile)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+	}
+
+	status := &daemonStatus{Schedule: cron.String(), Dirs: directories, Index: redactIndexPath(c.indexPath()),
+		Since: time.Now(), Runs: []daemonRun{}}
+
+	// Run until interrupted; a scan in progress is saved and stopped
+	ctx, stop := interruptible()
+	defer stop()
+
+	if *statusAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/status", status)
+		httpServer := &http.Server{Addr: *statusAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			httpServer.Close()
+		}()
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Error serving daemon status", "addr", *statusAddr, "err", err)
+			}
+		}()
+		slog.Info("Serving daemon status", "url", "http://"+*statusAddr+"/status")
+	}
+
+	next := time.Now()
+	if !*runNow {
+		next = cron.Next(next)
+	}
+	for {
+		if next.IsZero() {
+			return fmt.Errorf("schedule %q never matches", cron)
+		}
+		status.mu.Lock()
+		status.NextRun = next
+		status.mu.Unlock()
+		slog.Info("Next scheduled scan", "at", next.Format(time.RFC3339), "dirs", []string(directories))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Info("Daemon stopped")
+			return nil
+		case <-timer.C:
+		}
+
+		// Runs never overlap: times that pass while a scan is running are
+		// skipped rather than queued
+		scheduled := next
+		c.daemonScan(ctx, status, directories, opts, hooks)
+		if ctx.Err() != nil {
+			return fmt.Errorf("scan interrupted; the files indexed so far were saved")
+		}
+		next = cron.Next(time.Now())
+		skipped := 0
+		for t := cron.Next(scheduled); !t.IsZero() && t.Before(next); t = cron.Next(t) {
+			skipped++
+		}
+		if skipped > 0 {
+			slog.Warn("Skipped scheduled scans while the previous one was running", "skipped", skipped)
+			status.mu.Lock()
+			status.Skipped += skipped
+			status.mu.Unlock()
+		}
+	}
+}
+
+// daemonScan runs one scheduled scan of the daemon and records it in its
+// status. The index is only opened for the scan, so other commands can use
+// it between runs.
+func (c *CLI) daemonScan(ctx context.Context, status *daemonStatus, directories []string, opts indexer.ScanOptions, hooks *indexer.ChangeHooks) {
+	run := daemonRun{Started: time.Now()}
+	status.mu.Lock()
+	status.Running = &daemonRun{Started: run.Started}
+	status.mu.Unlock()
+
+	slog.Info("Starting scheduled scan", "dirs", directories)
+	files, err := c.scanOnce(ctx, directories, opts, hooks)
+	run.Finished, run.Files = time.Now(), files
+	if err != nil {
+		run.Error = err.Error()
+		slog.Error("Scheduled scan failed", "err", err, "duration", run.Finished.Sub(run.Started).Round(time.Second))
+	} else {
+		slog.Info("Scheduled scan completed", "files", files, "duration", run.Finished.Sub(run.Started).Round(time.Second))
+	}
+
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.Running = nil
+	status.Runs = append([]daemonRun{run}, status.Runs...)
+	if len(status.Runs) > maxDaemonRuns {
+		status.Runs = status.Runs[:maxDaemonRuns]
+	}
+	if err == nil {
+		status.LastSuccess = run.Finished
+	}
+}
+
+// scanOnce opens the index, indexes the directories, saves the index, fires
+// the hooks for the changes and returns the number of files it holds
+func (c *CLI) scanOnce(ctx context.Context, directories []string, opts indexer.ScanOptions, hooks *indexer.ChangeHooks) (int, error) {
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return 0, err
+	}
+	defer closeIndex()
+
+	// The first run's changes are those since the daemon started
+	if hooks != nil && !hooks.Primed() {
+		c.checkHooks(ctx, hooks)
+	}
+
+	scanErr := c.indexer.IndexDirectories(ctx, directories, opts)
+	if scanErr != nil && !errors.Is(scanErr, context.Canceled) {
+		return 0, fmt.Errorf("error indexing directory: %v", scanErr)
+	}
+	if err := c.indexer.SaveIndex(); err != nil {
+		return 0, fmt.Errorf("error saving index: %v", err)
+	}
+	if scanErr != nil {
+		return 0, fmt.Errorf("scan interrupted")
+	}
+	c.checkHooks(ctx, hooks)
+
+	stats, err := c.indexer.GetStats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	files, _ := stats["total_files"].(int)
+	return files, nil
+}
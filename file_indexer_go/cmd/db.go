@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runDB handles the db command, which maintains the database of an index
+func (c *CLI) runDB(args []string) error {
+	fs := c.newFlagSet("db")
+	out := fs.String("out", "", "File db backup writes the backup to, compressed with zstd for .zst and gzip for .gz")
+	from := fs.String("from", "", "Backup db restore restores the index from")
+	force := fs.Bool("force", false, "Let db restore replace an existing index")
+	output := addOutputFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("the db command requires an action: check, backup or restore")
+	}
+
+	switch positional[0] {
+	case "check":
+		return c.checkDatabase(*output)
+	case "backup":
+		if *out == "" {
+			return fmt.Errorf("db backup requires -out FILE")
+		}
+		return c.backupDatabase(*out)
+	case "restore":
+		if *from == "" {
+			return fmt.Errorf("db restore requires -from FILE")
+		}
+		return c.restoreDatabase(*from, *force)
+	}
+	return fmt.Errorf("unknown db action %q (supported: check, backup, restore)", positional[0])
+}
+
+// checkDatabase runs the integrity checks of the database and reports them
+func (c *CLI) checkDatabase(output string) error {
+	closeIndex, err := c.openIndex(false)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	report, err := c.indexer.CheckDatabase(context.Background())
+	if err != nil {
+		return fmt.Errorf("error checking the database: %v", err)
+	}
+	if output == outputJSON {
+		if err := writeJSON(report); err != nil {
+			return err
+		}
+	} else {
+		printHealthReport(report)
+	}
+	if !report.Healthy {
+		return fmt.Errorf("the database has problems; see the repair guidance of the failed checks")
+	}
+	return nil
+}
+
+// backupDatabase writes a backup of the index. The index is opened
+// read-only, so other readers can keep it open.
+func (c *CLI) backupDatabase(out string) error {
+	if !c.global.UseDB {
+		return fmt.Errorf("db backup requires the DuckDB backend (-db)")
+	}
+	c.global.ReadOnly = true
+	closeIndex, err := c.openIndex(false)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx, stop := interruptible()
+	defer stop()
+	if err := c.indexer.Backup(ctx, out); err != nil {
+		return fmt.Errorf("error backing up the index: %v", err)
+	}
+	fmt.Printf("Backed up %s to %s\n", c.indexPath(), out)
+	return nil
+}
+
+// restoreDatabase replaces the index with a backup
+func (c *CLI) restoreDatabase(from string, force bool) error {
+	if !c.global.UseDB {
+		return fmt.Errorf("db restore requires the DuckDB backend (-db)")
+	}
+	if c.global.ReadOnly {
+		return indexer.ErrReadOnly
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	if err := indexer.RestoreBackup(ctx, from, c.indexPath(), force); err != nil {
+		return fmt.Errorf("error restoring the index: %v", err)
+	}
+	fmt.Printf("Restored %s from %s\n", c.indexPath(), from)
+	return nil
+}
+
+// printHealthReport lists the checks of a database, with the rows and repair
+// guidance of those that found something
+func printHealthReport(report models.HealthReport) {
+	fmt.Printf("Schema version: %d (this program: %d)\n\n", report.StoredSchemaVersion, report.SchemaVersion)
+	counts := make(map[models.HealthStatus]int)
+	for _, check := range report.Checks {
+		counts[check.Status]++
+		fmt.Printf("[%-7s] %s: %s\n", check.Status, check.Name, check.Message)
+		for _, example := range check.Examples {
+			fmt.Printf("            %s\n", example)
+		}
+		if check.Repair != "" {
+			fmt.Printf("            Repair: %s\n", check.Repair)
+		}
+	}
+	fmt.Printf("\n%d checks: %d ok, %d warnings, %d errors\n", len(report.Checks),
+		counts[models.HealthOK], counts[models.HealthWarning], counts[models.HealthError])
+}
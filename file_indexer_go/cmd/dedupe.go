@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runDedupe handles the dedupe command
+func (c *CLI) runDedupe(args []string) error {
+	fs := c.newFlagSet("dedupe")
+	actionName := fs.String("action", "", "What to do with duplicates: delete, hardlink, symlink, reflink or move")
+	moveTo := fs.String("move-to", "", "Move duplicates into this quarantine directory (implies -action move)")
+	dryRun := fs.Bool("dry-run", true, "Only show what would be done (the default unless -force is given)")
+	force := fs.Bool("force", false, "Carry out the action instead of a dry run")
+	scriptPath := fs.String("emit-script", "", "Write a shell script that carries out the action to this file instead")
+	originalPolicy := addOriginalFlags(fs)
+	trashOptions := c.addTrashFlags(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	policy, err := originalPolicy()
+	if err != nil {
+		return err
+	}
+	trash, err := trashOptions()
+	if err != nil {
+		return err
+	}
+
+	// -force turns off the dry run, unless -dry-run was given explicitly
+	dryRunSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "dry-run" {
+			dryRunSet = true
+		}
+	})
+	if len(positional) > 0 {
+		if positional[0] != "undo" || len(positional) != 2 {
+			return fmt.Errorf("usage: dedupe undo RUN-ID (see the actions command for the IDs of runs)")
+		}
+		return c.undoDedupe(positional[1], dryRunSet && *dryRun)
+	}
+
+	if *actionName == "" && *moveTo != "" {
+		*actionName = string(indexer.DedupeMove)
+	}
+	if *actionName == "" {
+		fs.Usage()
+		return fmt.Errorf("the dedupe command requires -action or -move-to")
+	}
+	action, err := indexer.ParseDedupeAction(*actionName)
+	if err != nil {
+		return err
+	}
+	if (action == indexer.DedupeMove) != (*moveTo != "") {
+		return fmt.Errorf("-move-to and -action move must be given together")
+	}
+
+	if *force && !dryRunSet {
+		*dryRun = false
+	}
+	if *scriptPath != "" {
+		if *force {
+			return fmt.Errorf("-emit-script writes a script instead of changing files and cannot be combined with -force")
+		}
+		if action == indexer.DedupeMove {
+			return fmt.Errorf("-emit-script does not support -move-to, whose manifest only dedupe can write")
+		}
+		*dryRun = true
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+	c.indexer.SetProtected(policy)
+
+	ctx, stop := interruptible()
+	defer stop()
+	if err := c.checkHashed(ctx, "duplicates cannot be found"); err != nil {
+		return err
+	}
+	results, err := c.indexer.Dedupe(ctx, indexer.DedupeOptions{Action: action, DryRun: *dryRun, Policy: policy, MoveTo: *moveTo,
+		Trash: trash})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("error deduplicating: %v", err)
+	}
+
+	if *scriptPath != "" {
+		return writeScriptFile(*scriptPath, action, c.indexPath(), results)
+	}
+
+	verb := map[indexer.DedupeAction]string{
+		indexer.DedupeDelete:   "delete",
+		indexer.DedupeHardlink: "hardlink",
+		indexer.DedupeSymlink:  "symlink",
+		indexer.DedupeReflink:  "reflink",
+		indexer.DedupeMove:     "move",
+	}[action]
+
+	var done, skipped int
+	var reclaimed int64
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			skipped++
+			fmt.Printf("[SKIPPED] %s: %v\n", result.Duplicate, result.Err)
+		case *dryRun:
+			reclaimed += result.Size
+			fmt.Printf("[DRY RUN] would %s %s -> %s (%s)\n", verb, result.Duplicate, result.Original, models.FormatSize(result.Size))
+		default:
+			done++
+			reclaimed += result.Size
+			fmt.Printf("[DONE] %s %s -> %s (%s)\n", verb, result.Duplicate, result.Original, models.FormatSize(result.Size))
+		}
+	}
+
+	fmt.Println("\n=== SUMMARY ===")
+	if *dryRun {
+		fmt.Printf("Duplicates that would be processed: %d\n", len(results)-skipped)
+		fmt.Printf("Skipped: %d\n", skipped)
+		fmt.Printf("Space that would be reclaimed: %s\n", models.FormatSize(reclaimed))
+		fmt.Println("Dry run only; use -force to apply")
+		return nil
+	}
+	fmt.Printf("Duplicates processed: %d\n", done)
+	fmt.Printf("Skipped: %d\n", skipped)
+	if action == indexer.DedupeDelete && trash.Dir != "" {
+		printTrashSummary(results, reclaimed, trash)
+	} else {
+		fmt.Printf("Space reclaimed: %s\n", models.FormatSize(reclaimed))
+		if action == indexer.DedupeMove && done > 0 {
+			fmt.Printf("Undo with: dedupe undo %s\n", results[0].RunID)
+		}
+	}
+
+	// Save the changes made before an interruption too
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("dedupe interrupted")
+	}
+	return nil
+}
+
+// addTrashFlags registers the options of the trash that deleted duplicates
+// are moved into and returns a function that builds them once the flags are
+// parsed
+func (c *CLI) addTrashFlags(fs *flag.FlagSet) func() (indexer.TrashOptions, error) {
+	dir := fs.String("trash", "", "Trash directory deleted duplicates are moved into, so dedupe undo can put them back (default: INDEX.trash next to the index, for the duplicates on its filesystem)")
+	noTrash := fs.Bool("no-trash", false, "Delete duplicates for good instead of moving them into the trash")
+	expiry := fs.String("trash-expire", "30d", "Empty the runs in the trash older than this age, e.g. 7d (0 = never)")
+
+	return func() (indexer.TrashOptions, error) {
+		if *noTrash {
+			if *dir != "" {
+				return indexer.TrashOptions{}, fmt.Errorf("-trash and -no-trash cannot be combined")
+			}
+			return indexer.TrashOptions{}, nil
+		}
+		age, err := parseAge(*expiry)
+		if err != nil {
+			return indexer.TrashOptions{}, fmt.Errorf("invalid -trash-expire: %v", err)
+		}
+		options := indexer.TrashOptions{Dir: *dir, Expiry: age}
+		// Duplicates are only moved into the default trash from its own
+		// filesystem, as elsewhere they would be copied and still take
+		// their space
+		if options.Dir == "" {
+			options.Dir, options.SameFilesystem = c.defaultTrash(), true
+		}
+		return options, nil
+	}
+}
+
+// defaultTrash returns the trash of the index: next to a local index, and in
+// the home directory for PostgreSQL
+func (c *CLI) defaultTrash() string {
+	if !db.IsPostgresURL(c.indexPath()) {
+		return c.indexPath() + ".trash"
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".file_indexer_trash"
+	}
+	return filepath.Join(home, ".file_indexer_trash")
+}
+
+// printTrashSummary reports the duplicates moved into the trash and how to
+// put them back
+func printTrashSummary(results []indexer.DedupeResult, size int64, trash indexer.TrashOptions) {
+	fmt.Printf("Moved to the trash: %s\n", models.FormatSize(size))
+	for _, result := range results {
+		if result.Done {
+			if trash.Expiry > 0 {
+				fmt.Printf("The space is reclaimed when the run expires from the trash in %s\n", formatAge(trash.Expiry))
+			} else {
+				fmt.Printf("The space is reclaimed when the run is removed from %s\n", trash.Dir)
+			}
+			fmt.Printf("Undo with: dedupe undo %s\n", result.RunID)
+			return
+		}
+	}
+}
+
+// undoDedupe handles dedupe undo, which puts the duplicates of a run back
+func (c *CLI) undoDedupe(runID string, dryRun bool) error {
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx, stop := interruptible()
+	defer stop()
+	results, undoErr := c.indexer.UndoRun(ctx, runID, dryRun)
+	if undoErr != nil && !errors.Is(undoErr, context.Canceled) && len(results) == 0 {
+		return fmt.Errorf("error undoing run %s: %v", runID, undoErr)
+	}
+	if !printRestoreResults(results, dryRun) {
+		return nil
+	}
+
+	// Save the files put back before an error or interruption too
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+	switch {
+	case errors.Is(undoErr, context.Canceled):
+		return fmt.Errorf("undo interrupted")
+	case undoErr != nil:
+		return fmt.Errorf("error undoing run %s: %v", runID, undoErr)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// changeMarkers are the short labels printed in front of each change
+var changeMarkers = map[indexer.ChangeKind]string{
+	indexer.ChangeAdded:    "A",
+	indexer.ChangeRemoved:  "D",
+	indexer.ChangeModified: "M",
+	indexer.ChangeMoved:    "R",
+}
+
+// runDiff handles the diff command
+func (c *CLI) runDiff(args []string) error {
+	fs := c.newFlagSet("diff")
+	output := addOutputFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	if len(positional) != 2 {
+		fs.Usage()
+		return fmt.Errorf("the diff command requires an old and a new index")
+	}
+	for _, path := range positional {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("index not found: %v", err)
+		}
+	}
+
+	oldIndex, closeOld, err := openIndexAt(positional[0])
+	if err != nil {
+		return err
+	}
+	defer closeOld()
+
+	newIndex, closeNew, err := openIndexAt(positional[1])
+	if err != nil {
+		return err
+	}
+	defer closeNew()
+
+	ctx := context.Background()
+	oldAlgorithm, err := oldIndex.HashAlgorithm(ctx)
+	if err != nil {
+		return err
+	}
+	newAlgorithm, err := newIndex.HashAlgorithm(ctx)
+	if err != nil {
+		return err
+	}
+	if oldAlgorithm != newAlgorithm {
+		slog.Warn("The indexes use different checksum algorithms; checksums are not compared and moves are not detected",
+			"old", oldAlgorithm, "new", newAlgorithm)
+	}
+
+	changes, err := indexer.DiffIndexes(ctx, oldIndex, newIndex)
+	if err != nil {
+		return fmt.Errorf("error comparing indexes: %v", err)
+	}
+	if *output == outputJSON {
+		if changes == nil {
+			changes = []indexer.FileChange{}
+		}
+		return writeJSON(changes)
+	}
+
+	counts := make(map[indexer.ChangeKind]int)
+	for _, change := range changes {
+		counts[change.Kind]++
+		switch change.Kind {
+		case indexer.ChangeMoved:
+			fmt.Printf("%s %s -> %s\n", changeMarkers[change.Kind], change.Old.Path, change.Path)
+		case indexer.ChangeModified:
+			details := make([]string, 0, len(change.Fields))
+			for _, field := range change.Fields {
+				if field == "size" {
+					field = fmt.Sprintf("size %s -> %s", models.FormatSize(change.Old.FileSize), models.FormatSize(change.New.FileSize))
+				}
+				details = append(details, field)
+			}
+			fmt.Printf("%s %s (%s)\n", changeMarkers[change.Kind], change.Path, strings.Join(details, ", "))
+		default:
+			fmt.Printf("%s %s\n", changeMarkers[change.Kind], change.Path)
+		}
+	}
+
+	if len(changes) > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d added, %d removed, %d modified, %d moved\n",
+		counts[indexer.ChangeAdded], counts[indexer.ChangeRemoved], counts[indexer.ChangeModified], counts[indexer.ChangeMoved])
+	return nil
+}
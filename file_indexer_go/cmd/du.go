@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runDu handles the du command
+func (c *CLI) runDu(args []string) error {
+	fs := c.newFlagSet("du")
+	output := addOutputFlag(fs)
+	var directories stringList
+	fs.Var(&directories, "dir", "Directory to report on (repeatable; default: every indexed root)")
+	depth := fs.Int("depth", 1, "Number of directory levels to show below each directory (-1 = no limit)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx := context.Background()
+	if len(directories) == 0 {
+		roots, err := c.indexer.Roots(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing roots: %v", err)
+		}
+		for _, root := range roots {
+			directories = append(directories, root.Path)
+		}
+	}
+
+	usage := []models.DirectoryUsage{}
+	for _, dir := range directories {
+		dirUsage, err := c.indexer.DirectoryUsage(ctx, dir, *depth)
+		if err != nil {
+			return fmt.Errorf("error getting directory usage: %v", err)
+		}
+		usage = append(usage, dirUsage...)
+	}
+	if *output == outputJSON {
+		return writeJSON(usage)
+	}
+
+	if len(usage) == 0 {
+		fmt.Println("No indexed files found.")
+		return nil
+	}
+	printUsageTree(usage)
+	return nil
+}
+
+// printUsageTree renders directory usage as a tree, listing the largest
+// subdirectories of each directory first
+func printUsageTree(usage []models.DirectoryUsage) {
+	children := make(map[string][]models.DirectoryUsage)
+	var roots []models.DirectoryUsage
+	for _, entry := range usage {
+		if entry.Depth == 0 {
+			roots = append(roots, entry)
+		} else {
+			parent := filepath.Dir(entry.Path)
+			children[parent] = append(children[parent], entry)
+		}
+	}
+
+	var printChildren func(dir, indent string)
+	printChildren = func(dir, indent string) {
+		entries := children[dir]
+		sort.Slice(entries, func(a, b int) bool {
+			if entries[a].TotalSize != entries[b].TotalSize {
+				return entries[a].TotalSize > entries[b].TotalSize
+			}
+			return entries[a].Path < entries[b].Path
+		})
+
+		for n, entry := range entries {
+			branch, next := "├── ", "│   "
+			if n == len(entries)-1 {
+				branch, next = "└── ", "    "
+			}
+			fmt.Printf("%s%s%s\n", indent, branch, formatUsage(filepath.Base(entry.Path), entry))
+			printChildren(entry.Path, indent+next)
+		}
+	}
+
+	for n, root := range roots {
+		if n > 0 {
+			fmt.Println()
+		}
+		fmt.Println(formatUsage(root.Path, root))
+		printChildren(root.Path, "")
+	}
+}
+
+// formatUsage formats the name, size and file count of a directory
+func formatUsage(name string, entry models.DirectoryUsage) string {
+	return fmt.Sprintf("%s (%s, %d files)", name, models.FormatSize(entry.TotalSize), entry.FileCount)
+}
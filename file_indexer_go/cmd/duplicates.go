@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// addOriginalFlags registers the options that choose the original of each
+// duplicate group and returns a function that builds the policy once the
+// flags are parsed
+func addOriginalFlags(fs *flag.FlagSet) func() (indexer.OriginalPolicy, error) {
+	rules := fs.String("original", "path", "Comma-separated rules choosing the original: oldest, shortest, prefix, first-indexed, path")
+	var prefixes stringList
+	fs.Var(&prefixes, "prefer", "Path prefix whose files are preferred as originals (repeatable, earlier wins; implies the prefix rule)")
+	rulesFile := fs.String("rules", "", "File of keep, protect and prefer rules, in priority order (replaces -original and -prefer)")
+	var protect stringList
+	fs.Var(&protect, "protect", "Path or glob pattern such as '**/*.photoslibrary/**' whose files are never deleted, moved or linked (repeatable)")
+
+	return func() (indexer.OriginalPolicy, error) {
+		policy, err := loadOriginalPolicy(fs, *rules, prefixes, *rulesFile)
+		if err != nil {
+			return indexer.OriginalPolicy{}, err
+		}
+		for _, arg := range protect {
+			if err := policy.AddProtect(arg); err != nil {
+				return indexer.OriginalPolicy{}, fmt.Errorf("invalid -protect: %v", err)
+			}
+		}
+		return policy, nil
+	}
+}
+
+// loadOriginalPolicy builds the policy of -original and -prefer, or reads the
+// one of a -rules file
+func loadOriginalPolicy(fs *flag.FlagSet, rules string, prefixes []string, rulesFile string) (indexer.OriginalPolicy, error) {
+	if rulesFile == "" {
+		return indexer.ParseOriginalPolicy(rules, prefixes)
+	}
+	combined := false
+	fs.Visit(func(f *flag.Flag) {
+		combined = combined || f.Name == "original" || f.Name == "prefer"
+	})
+	if combined {
+		return indexer.OriginalPolicy{}, fmt.Errorf("-rules cannot be combined with -original or -prefer")
+	}
+	return indexer.LoadKeepRules(rulesFile)
+}
+
+// checkHashed fails if none of the indexed files has a checksum, as after
+// scans with -no-checksum, and warns about the files left out if only some
+// have none. What names what cannot be done without checksums.
+func (c *CLI) checkHashed(ctx context.Context, what string) error {
+	unhashed, total, err := c.indexer.Unhashed(ctx)
+	if err != nil {
+		return err
+	}
+	if unhashed > 0 && unhashed == total {
+		return fmt.Errorf("none of the %d indexed files has a checksum, so %s; hash them with index -calculate-checksums", total, what)
+	}
+	if unhashed > 0 {
+		slog.Warn("Files without a checksum are left out; hash them with index -calculate-checksums", "files", unhashed, "total", total)
+	}
+	return nil
+}
+
+// runDuplicates handles the duplicates command
+func (c *CLI) runDuplicates(args []string) error {
+	fs := c.newFlagSet("duplicates")
+	originalPolicy := addOriginalFlags(fs)
+	output := fs.String("output", outputText, "Output format: text, json, csv or html")
+	outPath := fs.String("out", "", "Write the report to this file (default: stdout)")
+	sampled := fs.Bool("sampled", false, "Also list candidate duplicates that only have sampled hashes (see index -sample-hash)")
+	by := fs.String("by", "checksum", "Group files by checksum, or by photo: the same EXIF capture time, dimensions and camera")
+	excludeKnown := fs.Bool("exclude-known", false, "Leave out groups of files in allow hash sets, such as operating system files of the NSRL (requires -db)")
+	minSize := fs.String("min-size", "", "Leave out groups of files smaller than this, such as icons and thumbnails (bytes, or with a K, M, G or T suffix)")
+	minWasted := fs.String("min-wasted", "", "Leave out groups that waste less space than this, e.g. 100M")
+	minGroupSize := fs.Int("min-group-size", 0, "Leave out groups of fewer files than this, e.g. 3 to find files copied again and again")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	var filter models.DuplicateFilter
+	var err error
+	if filter.MinFileSize, err = parseSize(*minSize); err != nil {
+		return fmt.Errorf("invalid -min-size: %v", err)
+	}
+	if filter.MinWasted, err = parseSize(*minWasted); err != nil {
+		return fmt.Errorf("invalid -min-wasted: %v", err)
+	}
+	if *minGroupSize < 0 {
+		return fmt.Errorf("-min-group-size must not be negative")
+	}
+	filter.MinGroupSize = *minGroupSize
+	switch *by {
+	case "checksum":
+	case "photo":
+		if *outPath != "" {
+			return fmt.Errorf("-out is not supported with -by photo")
+		}
+		return c.photoDuplicates(*output)
+	default:
+		return fmt.Errorf("unknown grouping %q (supported: checksum, photo)", *by)
+	}
+	switch *output {
+	case outputText, outputJSON, outputCSV, outputHTML:
+	default:
+		return fmt.Errorf("unknown output format %q (supported: text, json, csv, html)", *output)
+	}
+	policy, err := originalPolicy()
+	if err != nil {
+		return err
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	if err := c.checkHashed(context.Background(), "duplicates cannot be found"); err != nil {
+		return err
+	}
+	if *output == outputText && *outPath == "" {
+		fmt.Println("Searching for duplicate files...")
+	}
+	groups, err := c.indexer.FindFilteredDuplicates(context.Background(), policy, filter)
+	if err != nil {
+		return fmt.Errorf("error finding duplicates: %v", err)
+	}
+	if *sampled {
+		candidates, err := c.indexer.FindSampledDuplicates(context.Background(), policy)
+		if err != nil {
+			return fmt.Errorf("error finding sampled duplicates: %v", err)
+		}
+		candidates = slices.DeleteFunc(candidates, func(group models.DuplicateGroup) bool { return !filter.Matches(group) })
+		groups = append(groups, candidates...)
+	}
+	if *excludeKnown {
+		if groups, err = c.indexer.WithoutKnownGood(context.Background(), groups); err != nil {
+			return fmt.Errorf("error matching known hashes: %v", err)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		file, err := os.Create(*outPath)
+		if err != nil {
+			return fmt.Errorf("error creating report file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	report := newDuplicateReport(c.indexPath(), c.indexer.Host(), policy, groups)
+	switch *output {
+	case outputJSON:
+		err = encodeJSON(out, report)
+	case outputCSV:
+		err = writeReportCSV(out, report)
+	case outputHTML:
+		err = writeReportHTML(out, report)
+	default:
+		printDuplicates(out, groups, policy, c.indexer.Host())
+	}
+	if err != nil {
+		return err
+	}
+	if *outPath != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d duplicate groups to %s\n", report.GroupCount, *outPath)
+	}
+	return nil
+}
+
+// photoDuplicates lists the groups of photos of the same shot, such as the
+// edited and re-exported copies of a photo whose checksums differ
+func (c *CLI) photoDuplicates(output string) error {
+	if err := checkOutputFormat(output); err != nil {
+		return err
+	}
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	groups, err := c.indexer.SamePhotos(context.Background())
+	if err != nil {
+		return fmt.Errorf("error finding photos of the same shot: %v", err)
+	}
+	if output == outputJSON {
+		if groups == nil {
+			groups = []models.PhotoGroup{}
+		}
+		return writeJSON(groups)
+	}
+	if len(groups) == 0 {
+		fmt.Println("No photos of the same shot found (only files indexed with -exif are compared)")
+		return nil
+	}
+	var files int
+	for n, group := range groups {
+		files += len(group.Files)
+		camera := group.CameraModel
+		if camera == "" {
+			camera = "unknown camera"
+		}
+		if group.CameraSerial != "" {
+			camera += " #" + group.CameraSerial
+		}
+		fmt.Printf("\n--- Same Shot %d (taken %s, %dx%d, %s) ---\n", n+1, group.TakenAt.Format("2006-01-02 15:04:05.000"), group.Width, group.Height, camera)
+		if group.Identical {
+			fmt.Printf("Files: %d, %s (identical copies, also found by checksum)\n", len(group.Files), models.FormatSize(group.TotalSize))
+		} else {
+			fmt.Printf("Files: %d, %s\n", len(group.Files), models.FormatSize(group.TotalSize))
+		}
+		for _, file := range group.Files {
+			fmt.Printf("  %s (%dx%d, %s)\n", c.location(file), file.ImageWidth, file.ImageHeight, models.FormatSize(file.FileSize))
+		}
+	}
+	fmt.Println("\n=== SUMMARY ===")
+	fmt.Printf("Groups of photos of the same shot: %d\n", len(groups))
+	fmt.Printf("Photos in groups: %d\n", files)
+	return nil
+}
+
+// printDuplicates writes duplicate groups as text, with paths as seen on
+// host, noting the duplicates that keep rules of policy keep
+func printDuplicates(out io.Writer, groups []models.DuplicateGroup, policy indexer.OriginalPolicy, host string) {
+	var duplicateFiles, hardlinks, candidates int
+	var totalWasted int64
+	for n, group := range groups {
+		duplicateFiles += len(group.Files)
+		totalWasted += group.WastedSpace
+
+		checksum := group.Checksum
+		if len(checksum) > 16 {
+			checksum = checksum[:16] + "..."
+		}
+		if group.Sampled {
+			candidates++
+			fmt.Fprintf(out, "\n--- Candidate Group %d (Sampled hash: %s, not confirmed) ---\n", n+1, checksum)
+		} else {
+			fmt.Fprintf(out, "\n--- Duplicate Group %d (Checksum: %s) ---\n", n+1, checksum)
+		}
+		fmt.Fprintf(out, "Files: %d, Wasted space: %s\n", len(group.Files), models.FormatSize(group.WastedSpace))
+		if len(group.Tags) > 0 {
+			fmt.Fprintf(out, "Tags: %s\n", strings.Join(group.Tags, ", "))
+		}
+
+		for idx, file := range group.Files {
+			status := duplicateStatus(group, idx)
+			if status == statusHardlink {
+				hardlinks++
+			}
+			fmt.Fprintf(out, "  [%s] %s (%s)\n", strings.ToUpper(status), file.DisplayPath(host), models.FormatSize(file.FileSize))
+			if companion, ok := group.Companions[file.Location()]; ok {
+				fmt.Fprintf(out, "      + AppleDouble %s (%s)\n", companion.Filename, models.FormatSize(companion.FileSize))
+			}
+			for _, alias := range group.Aliases[file.Location()] {
+				fmt.Fprintf(out, "      = also at %s\n", alias)
+			}
+			if rule := policy.KeepRule(file); rule != "" && idx > 0 {
+				fmt.Fprintf(out, "      kept by rule %s\n", rule)
+			}
+		}
+	}
+
+	fmt.Fprintln(out, "\n=== SUMMARY ===")
+	fmt.Fprintf(out, "Duplicate groups found: %d\n", len(groups))
+	if candidates > 0 {
+		fmt.Fprintf(out, "Candidates by sampled hash (confirm with index -confirm-sampled): %d\n", candidates)
+	}
+	fmt.Fprintf(out, "Total duplicate files: %d\n", duplicateFiles)
+	if hardlinks > 0 {
+		fmt.Fprintf(out, "Hardlinks (not counted as wasted space): %d\n", hardlinks)
+	}
+	fmt.Fprintf(out, "Total wasted space: %s\n", models.FormatSize(totalWasted))
+}
+
+// runDuplicateDirs handles the duplicate-dirs command
+func (c *CLI) runDuplicateDirs(args []string) error {
+	fs := c.newFlagSet("duplicate-dirs")
+	output := addOutputFlag(fs)
+	minSimilarity := fs.Float64("min-similarity", 100, "Lowest percentage of the files of two directory trees that must be shared")
+	var directories stringList
+	fs.Var(&directories, "dir", "Directory to search (repeatable; default: the whole index)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if *minSimilarity <= 0 || *minSimilarity > 100 {
+		return fmt.Errorf("-min-similarity must be above 0 and at most 100")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx := context.Background()
+	if len(directories) == 0 {
+		directories = append(directories, "")
+	}
+	pairs := []models.DuplicateDirectory{}
+	for _, dir := range directories {
+		dirPairs, err := c.indexer.DuplicateDirectories(ctx, dir, *minSimilarity)
+		if err != nil {
+			return fmt.Errorf("error finding duplicate directories: %v", err)
+		}
+		pairs = append(pairs, dirPairs...)
+	}
+
+	if *output == outputJSON {
+		return writeJSON(pairs)
+	}
+	if len(pairs) == 0 {
+		fmt.Println("No duplicate directories found")
+		return nil
+	}
+	var shared int64
+	for n, pair := range pairs {
+		shared += pair.SharedSize
+		fmt.Printf("\n--- Duplicate Directories %d (%.1f%% shared) ---\n", n+1, pair.Similarity)
+		fmt.Printf("  %s (%d files)\n", pair.Path, pair.Files)
+		fmt.Printf("  %s (%d files)\n", pair.Other, pair.OtherFiles)
+		fmt.Printf("Shared: %d files, %s\n", pair.SharedFiles, models.FormatSize(pair.SharedSize))
+	}
+	fmt.Println("\n=== SUMMARY ===")
+	fmt.Printf("Duplicate directory pairs found: %d\n", len(pairs))
+	fmt.Printf("Space shared by the pairs: %s\n", models.FormatSize(shared))
+	return nil
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// csvHeader lists the exported columns, matching the files table
+var csvHeader = []string{
+	"path", "filename", "checksum", "modification_datetime", "file_size", "indexed_at", "quick_hash", "sample_hash",
+	"link_target", "device", "inode", "mime_type",
+	"taken_at", "camera_model", "camera_serial", "image_width", "image_height",
+	"container", "duration_seconds", "video_width", "video_height", "video_codec", "audio_codec",
+	"source", "host",
+	"uid", "gid", "user_name", "group_name", "mode", "attributes", "finder_tags", "image_hash", "text_hash",
+	"link_count", "label", "language", "line_count", "code_lines", "comment_lines",
+}
+
+// runExport handles the export command
+func (c *CLI) runExport(args []string) error {
+	fs := c.newFlagSet("export")
+	format := fs.String("format", "csv", "Export format: csv, or checksums for a manifest in the format of md5sum and sha256sum")
+	outPath := fs.String("out", "", "Output file (default: stdout)")
+	search := fs.String("search", "", "Only export files whose name or path matches this query")
+	relativeTo := fs.String("relative-to", "", "Write the paths of a checksums manifest relative to this directory, leaving out files outside it")
+	hashName := fs.String("hash", "", "Algorithm of the checksums of a manifest, if not the index's own: one stored with -extra-hash")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "checksums" {
+		return fmt.Errorf("unknown export format %q (supported: csv, checksums)", *format)
+	}
+	if (*relativeTo != "" || *hashName != "") && *format != "checksums" {
+		return fmt.Errorf("-relative-to and -hash only apply to -format checksums")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	var files []models.FileInfo
+	query := models.FileQuery{}
+	if *format == "checksums" {
+		query.Sort = models.SortPath
+	}
+	if *search != "" {
+		files, err = c.indexer.Search(context.Background(), *search, query)
+	} else {
+		files, err = c.indexer.ListFiles(context.Background(), query)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading index: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		file, err := os.Create(*outPath)
+		if err != nil {
+			return fmt.Errorf("error creating export file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if *format == "checksums" {
+		algorithm, err := c.indexer.HashAlgorithm(context.Background())
+		if err != nil {
+			return err
+		}
+		if *hashName != "" {
+			if files, err = c.indexer.ChecksumsOf(context.Background(), files, *hashName); err != nil {
+				return err
+			}
+			algorithm = *hashName
+		}
+		count, err := writeChecksums(out, files, *relativeTo)
+		if err != nil {
+			return err
+		}
+		if *outPath != "" {
+			fmt.Fprintf(os.Stderr, "Exported %d %s checksums to %s\n", count, algorithm, *outPath)
+		}
+		return nil
+	}
+
+	if err := writeCSV(out, files); err != nil {
+		return err
+	}
+	if *outPath != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d files to %s\n", len(files), *outPath)
+	}
+	return nil
+}
+
+// writeChecksums writes a manifest of the checksums of local files, which
+// md5sum -c or sha256sum -c can check, and returns the number of files
+// written. Files without a full checksum, and those inside archives and
+// other sources that those tools cannot read, are left out. With a
+// relativeTo directory, paths are relative to it and files outside it are
+// left out too.
+func writeChecksums(out io.Writer, files []models.FileInfo, relativeTo string) (int, error) {
+	if relativeTo != "" {
+		abs, err := filepath.Abs(relativeTo)
+		if err != nil {
+			return 0, fmt.Errorf("invalid -relative-to: %v", err)
+		}
+		relativeTo = abs
+	}
+
+	writer := bufio.NewWriter(out)
+	count := 0
+	for _, file := range files {
+		if file.Checksum == "" || file.Source != "" {
+			continue
+		}
+		path := file.Path
+		if relativeTo != "" {
+			rel, err := filepath.Rel(relativeTo, path)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			path = filepath.ToSlash(rel)
+		}
+		if err := hasher.WriteManifestLine(writer, file.Checksum, path); err != nil {
+			return count, fmt.Errorf("error writing checksums: %v", err)
+		}
+		count++
+	}
+	if err := writer.Flush(); err != nil {
+		return count, fmt.Errorf("error writing checksums: %v", err)
+	}
+	return count, nil
+}
+
+// writeCSV writes file records as CSV with a header row
+func writeCSV(out io.Writer, files []models.FileInfo) error {
+	writer := csv.NewWriter(out)
+	if err := writer.Write(csvHeader); err != nil {
+		return fmt.Errorf("error writing CSV: %v", err)
+	}
+
+	for _, file := range files {
+		record := []string{
+			file.Path,
+			file.Filename,
+			file.Checksum,
+			file.ModificationDateTime.Format(time.RFC3339Nano),
+			strconv.FormatInt(file.FileSize, 10),
+			file.IndexedAt.Format(time.RFC3339Nano),
+			file.QuickHash,
+			file.SampleHash,
+			file.LinkTarget,
+			formatID(file.Device),
+			formatID(file.Inode),
+			file.MimeType,
+			formatTime(file.TakenAt),
+			file.CameraModel,
+			file.CameraSerial,
+			formatDimension(file.ImageWidth),
+			formatDimension(file.ImageHeight),
+			file.Container,
+			formatDuration(file.DurationSeconds),
+			formatDimension(file.VideoWidth),
+			formatDimension(file.VideoHeight),
+			file.VideoCodec,
+			file.AudioCodec,
+			file.Source,
+			file.Host,
+			formatOwnerID(file.UID),
+			formatOwnerID(file.GID),
+			file.UserName,
+			file.GroupName,
+			formatMode(file),
+			file.Attributes,
+			strings.Join(file.FinderTags, "\n"),
+			file.ImageHash,
+			file.TextHash,
+			formatID(file.LinkCount),
+			file.Label,
+			file.Language,
+			formatLines(file, file.LineCount),
+			formatLines(file, file.CodeLines),
+			formatLines(file, file.CommentLines),
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("error writing CSV: %v", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV: %v", err)
+	}
+	return nil
+}
+
+// formatID formats a device or inode number, leaving unknown (zero) values empty
+func formatID(value uint64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatUint(value, 10)
+}
+
+// formatOwnerID formats a user or group ID, leaving unknown IDs empty
+func formatOwnerID(id *uint32) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// formatMode formats permission bits in octal as chmod takes them, leaving
+// them empty for files without owners
+func formatMode(file models.FileInfo) string {
+	if file.UID == nil {
+		return ""
+	}
+	return fmt.Sprintf("%04o", file.Mode)
+}
+
+// formatTime formats an optional timestamp, leaving the zero time empty
+func formatTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.Format(time.RFC3339Nano)
+}
+
+// formatDimension formats an optional image dimension, leaving zero empty
+func formatDimension(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+// formatLines formats a line count of a source file, leaving it empty for
+// files without code metrics
+func formatLines(file models.FileInfo, lines int) string {
+	if file.Language == "" {
+		return ""
+	}
+	return strconv.Itoa(lines)
+}
+
+// formatDuration formats an optional duration in seconds, leaving zero empty
+func formatDuration(seconds float64) string {
+	if seconds == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
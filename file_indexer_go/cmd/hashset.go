@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runHashSet handles the hash-set command, which imports, lists and removes
+// known hash sets such as the NSRL or blocklists
+func (c *CLI) runHashSet(args []string) error {
+	fs := c.newFlagSet("hash-set")
+	name := fs.String("name", "", "Name of an imported set (default: the file name without its extension)")
+	kind := fs.String("kind", "", "What an imported set lists: allow for known good files such as the NSRL, block for known bad ones")
+	hashName := fs.String("hash", "", "Algorithm of the checksums of a plain list (default: guessed from their length)")
+	output := addOutputFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("the hash-set command requires an action: import, list or remove")
+	}
+
+	action, rest := positional[0], positional[1:]
+	switch action {
+	case "import":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: hash-set import -kind allow|block [-name NAME] FILE")
+		}
+		if *kind == "" {
+			return fmt.Errorf("hash-set import requires -kind allow or block")
+		}
+	case "list":
+		if len(rest) != 0 {
+			return fmt.Errorf("usage: hash-set list")
+		}
+	case "remove":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: hash-set remove NAME")
+		}
+	default:
+		return fmt.Errorf("unknown hash-set action %q (supported: import, list, remove)", action)
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx := context.Background()
+	switch action {
+	case "import":
+		path := rest[0]
+		set := models.HashSet{Name: *name, Kind: models.HashSetKind(*kind), Source: path}
+		if set.Name == "" {
+			set.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening hash set: %v", err)
+		}
+		defer file.Close()
+
+		// An interrupted import removes the set instead of leaving part of it
+		ctx, stop := interruptible()
+		defer stop()
+		set, err = c.indexer.ImportHashSet(ctx, set, file, *hashName)
+		if err != nil {
+			return fmt.Errorf("error importing %s: %v", path, err)
+		}
+		fmt.Printf("Imported %d checksums into the %s set %s\n", set.Hashes, set.Kind, set.Name)
+		return nil
+	case "remove":
+		removed, err := c.indexer.RemoveHashSet(ctx, rest[0])
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("no hash set named %s", rest[0])
+		}
+		fmt.Printf("Removed the hash set %s\n", rest[0])
+		return nil
+	}
+
+	sets, err := c.indexer.HashSets(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading hash sets: %v", err)
+	}
+	if *output == outputJSON {
+		if sets == nil {
+			sets = []models.HashSet{}
+		}
+		return writeJSON(sets)
+	}
+	if len(sets) == 0 {
+		fmt.Println("No hash sets imported.")
+		return nil
+	}
+	for _, set := range sets {
+		fmt.Printf("%s (%s): %d checksums, imported %s", set.Name, set.Kind, set.Hashes, set.ImportedAt.Local().Format("2006-01-02 15:04:05"))
+		if set.Source != "" {
+			fmt.Printf(" from %s", set.Source)
+		}
+		fmt.Println()
+	}
+	return nil
+}
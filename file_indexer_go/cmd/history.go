@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// historyTimeFormat is how scan times are shown in text output
+const historyTimeFormat = "2006-01-02 15:04"
+
+// historyMarkers are the labels printed in front of journal entries, matching
+// those of the diff command
+var historyMarkers = map[string]string{
+	string(indexer.ChangeAdded):    "A",
+	string(indexer.ChangeRemoved):  "D",
+	string(indexer.ChangeModified): "M",
+}
+
+// runHistory handles the history command
+func (c *CLI) runHistory(args []string) error {
+	fs := c.newFlagSet("history")
+	output := addOutputFlag(fs)
+	scanID := fs.Int64("scan", 0, "Show the changes recorded by this scan")
+	after := fs.String("after", "", "Show changes from scans at or after this time (RFC 3339, YYYY-MM-DD, or an age such as 30d)")
+	before := fs.String("before", "", "Show changes from scans before this time (RFC 3339, YYYY-MM-DD, or an age such as 30d)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	query := models.HistoryQuery{ScanID: *scanID}
+	var err error
+	if query.After, err = parseTime(*after); err != nil {
+		return fmt.Errorf("invalid -after: %v", err)
+	}
+	if query.Before, err = parseTime(*before); err != nil {
+		return fmt.Errorf("invalid -before: %v", err)
+	}
+
+	closeIndex, err := c.openIndex(false)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	// Without a selection, give an overview of the recorded scans
+	if query == (models.HistoryQuery{}) {
+		scans, err := c.indexer.Scans(context.Background())
+		if err != nil {
+			return err
+		}
+		return printScans(scans, *output)
+	}
+
+	changes, err := c.indexer.History(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	if *output == outputJSON {
+		if changes == nil {
+			changes = []models.HistoryChange{}
+		}
+		return writeJSON(changes)
+	}
+
+	for _, change := range changes {
+		fmt.Printf("%s scan %d %s %s\n", change.ScannedAt.Local().Format(historyTimeFormat), change.ScanID,
+			historyMarkers[change.Change], change.Path)
+	}
+	fmt.Printf("\n%d changes\n", len(changes))
+	return nil
+}
+
+// printScans prints the recorded scans with their change counts
+func printScans(scans []models.ScanSnapshot, output string) error {
+	if output == outputJSON {
+		if scans == nil {
+			scans = []models.ScanSnapshot{}
+		}
+		return writeJSON(scans)
+	}
+
+	if len(scans) == 0 {
+		fmt.Println("No scans recorded. Index with -db -history to record them.")
+		return nil
+	}
+
+	fmt.Printf("%6s  %-16s  %9s  %10s  %7s  %7s  %8s  %s\n", "Scan", "Date", "Files", "Size", "Added", "Removed", "Modified", "Root")
+	for _, scan := range scans {
+		fmt.Printf("%6d  %-16s  %9d  %10s  %7d  %7d  %8d  %s\n", scan.ID, scan.ScannedAt.Local().Format(historyTimeFormat),
+			scan.FileCount, models.FormatSize(scan.TotalSize), scan.Added, scan.Removed, scan.Modified, scan.Root)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+)
+
+// addHookFlags registers the options of the hooks fired on changes and
+// returns a function that builds the hooks once the flags are parsed. It
+// returns nil hooks if neither a webhook nor a command is given.
+func addHookFlags(fs *flag.FlagSet) func(index string) (*indexer.ChangeHooks, error) {
+	url := fs.String("hook-url", "", "POST a JSON event to this URL, e.g. a Slack incoming webhook, when files change")
+	command := fs.String("hook-command", "", "Run this shell command with a JSON event on stdin when files change")
+	events := fs.String("hook-events", "added,removed,duplicates", "Comma-separated changes that fire the hooks: added, removed, duplicates")
+	minWasted := fs.String("hook-min-wasted", "0", "Wasted space new duplicates must add up to before they fire the hooks, e.g. 50G")
+
+	return func(index string) (*indexer.ChangeHooks, error) {
+		if *url == "" && *command == "" {
+			return nil, nil
+		}
+		kinds, err := indexer.ParseHookEvents(strings.Split(*events, ","))
+		if err != nil {
+			return nil, err
+		}
+		threshold, err := parseSize(*minWasted)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -hook-min-wasted: %v", err)
+		}
+		config := indexer.HookConfig{URL: *url, Command: *command, Events: kinds, MinWasted: threshold, Index: redactIndexPath(index)}
+		return indexer.NewChangeHooks(config), nil
+	}
+}
+
+// checkHooks fires the hooks for the changes since their last check. Errors
+// are only logged, so a failing check does not stop watching or scheduling.
+func (c *CLI) checkHooks(ctx context.Context, hooks *indexer.ChangeHooks) {
+	if hooks == nil {
+		return
+	}
+	if err := hooks.Check(context.WithoutCancel(ctx), c.indexer); err != nil {
+		slog.Error("Error checking the index for hooks", "err", err)
+	}
+}
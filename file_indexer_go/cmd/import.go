@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+)
+
+// runImport handles the import command, which seeds the index with the
+// checksums of an existing manifest so the next scan does not re-hash them
+func (c *CLI) runImport(args []string) error {
+	fs := c.newFlagSet("import")
+	checksums := fs.String("checksums", "", "Checksum manifest written by md5sum, sha256sum and the like, or a CSV file with path and checksum columns")
+	base := fs.String("base", "", "Directory relative paths of -checksums are resolved against (default: the current directory)")
+	algorithm := fs.String("hash", "", "Checksum algorithm of -checksums (default: guessed from its name, columns or checksums)")
+	verifyPercent := fs.Float64("verify-percent", 0, "Re-hash this percentage of the files first and import nothing if any does not match (e.g. 1)")
+	seed := fs.Int64("seed", 0, "Selects which files -verify-percent samples")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *checksums == "" {
+		fs.Usage()
+		return fmt.Errorf("the import command requires -checksums")
+	}
+	if *verifyPercent < 0 || *verifyPercent > 100 {
+		return fmt.Errorf("-verify-percent must be between 0 and 100")
+	}
+
+	entries, err := readChecksums(*checksums)
+	if err != nil {
+		return err
+	}
+	opts := indexer.ChecksumImportOptions{Algorithm: *algorithm, Base: *base, VerifyPercent: *verifyPercent, Seed: *seed}
+	if opts.Algorithm == "" {
+		if opts.Algorithm, err = hasher.ManifestAlgorithm(*checksums, entries); err != nil {
+			return err
+		}
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx, stop := interruptible()
+	defer stop()
+	result, err := c.indexer.ImportChecksums(ctx, entries, opts)
+	for _, path := range result.Mismatched {
+		fmt.Printf("[MISMATCH] %s\n", path)
+	}
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("import interrupted; nothing was imported")
+	}
+	if err != nil {
+		return fmt.Errorf("error importing checksums: %v", err)
+	}
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+
+	fmt.Printf("Imported %d %s checksums: %d files already had one, %d missing", result.Imported, opts.Algorithm, result.Indexed, result.Missing)
+	if opts.VerifyPercent > 0 {
+		fmt.Printf(", %d sampled files verified", result.Verified)
+	}
+	fmt.Println()
+	return nil
+}
+
+// readChecksums reads a checksum manifest, or a CSV file if its name ends in
+// .csv
+func readChecksums(path string) ([]hasher.ManifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checksums: %v", err)
+	}
+	defer file.Close()
+
+	var entries []hasher.ManifestEntry
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		entries, err = hasher.ReadChecksumCSV(file)
+	} else {
+		entries, err = hasher.ReadManifest(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/filter"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/secrets"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// addScanFlags registers the options that control a directory scan and
+// returns a function that assembles them once the flags are parsed
+func addScanFlags(fs *flag.FlagSet) func() (indexer.ScanOptions, error) {
+	minFileSize := fs.Int64("min-size", 0, "Minimum file size to index, e.g. to skip icons and sidecar files (in bytes, 0 = no limit)")
+	maxFileSize := fs.Int64("max-size", 0, "Maximum file size to index (in bytes, 0 = no limit)")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of concurrent checksum workers")
+	var excludes stringList
+	fs.Var(&excludes, "exclude", "Glob pattern of files or directories to skip (repeatable, e.g. '**/node_modules/**' or '*.tmp')")
+	var presets stringList
+	fs.Var(&presets, "preset", "Also skip the junk directories and files of a preset: dev, photos or backups (repeatable or comma-separated; see the presets command)")
+	var includes stringList
+	fs.Var(&includes, "include", "Glob pattern a file must match to be indexed (repeatable, e.g. '*.jpg')")
+	var exts stringList
+	fs.Var(&exts, "ext", "Only index files with these extensions, e.g. jpg,mov,raw (repeatable or comma-separated)")
+	var skipExts stringList
+	fs.Var(&skipExts, "exclude-ext", "Skip files with these extensions, e.g. tmp,log (repeatable or comma-separated)")
+	ignoreFiles := fs.Bool("ignore-files", false, "Honor .gitignore and .indexignore files found in traversed directories")
+	maxDepth := fs.Int("max-depth", 0, "Only index files at most this many levels below each root, 1 = only those directly in it (0 = no limit)")
+	oneFileSystem := fs.Bool("one-file-system", false, "Do not descend into directories on other filesystems than the root, such as /proc or network mounts")
+	followSymlinks := fs.Bool("follow-symlinks", false, "Index the targets of symbolic links, walking each linked directory once")
+	recordSymlinks := fs.Bool("record-symlinks", false, "Index symbolic links themselves, storing their targets in link_target")
+	content := fs.Bool("content", false, "Store the content of text files so that search -content can match inside them")
+	contentMaxSize := fs.Int64("content-max-size", 1<<20, "Largest text file whose content is stored (in bytes, 0 = no limit)")
+	scanSecrets := fs.Bool("secrets", false, "Scan the stored content for API keys, private keys, credit card numbers and other secrets, for check secrets (requires -content)")
+	secretRules := fs.String("secret-rules", "", "File of extra secret detectors (NAME REGEXP per line) and built-in ones to turn off (disable NAME)")
+	secretEntropy := fs.Float64("secret-entropy", secrets.DefaultEntropy, "Report tokens of 32+ letters and digits with at least this entropy in bits per character (0 = off)")
+	exif := fs.Bool("exif", false, "Extract EXIF capture time, camera model, serial number and dimensions of JPEG, HEIC and RAW photos")
+	imageHash := fs.Bool("image-hash", false, "Compute perceptual hashes of JPEG, PNG and GIF images for similar-images")
+	textHash := fs.Bool("text-hash", false, "Compute simhashes of text files for similar-text")
+	textHashMaxSize := fs.Int64("text-hash-max-size", 10<<20, "Largest text file whose simhash is computed (in bytes, 0 = no limit)")
+	codeMetrics := fs.Bool("code-metrics", false, "Record the programming language and the lines of code, comments and blank lines of source files, for languages")
+	mediaInfo := fs.Bool("media", false, "Extract container, duration, resolution and codecs of MP4/MOV/MKV/MP3/FLAC files")
+	xattrs := fs.Bool("xattrs", false, "Record extended attributes such as user.* tags and com.apple.quarantine (Linux and macOS)")
+	streams := fs.Bool("streams", false, "Also index the NTFS alternate data streams of files, such as Zone.Identifier, as file:stream (Windows)")
+	scanArchives := fs.Bool("scan-archives", false, "Also index the files inside .zip, .tar, .tar.gz and .tgz archives as archive.zip!/path/in/archive")
+	label := fs.String("label", "", "Label stored with every indexed file, e.g. the name of a removable disk")
+	hashAlgorithm := fs.String("hash", "", "Checksum algorithm: "+strings.Join(hasher.Names(), ", ")+" (default: the index's algorithm, or "+hasher.Default+")")
+	migrateHash := fs.Bool("migrate-hash", false, "Allow switching an index to a different checksum algorithm, promoting checksums stored with -extra-hash")
+	extraHash := fs.String("extra-hash", "", "Also store checksums of these comma-separated algorithms, e.g. sha256,blake3, computed in the same read")
+	noChecksum := fs.Bool("no-checksum", false, "Only take an inventory of paths, sizes and modification times, opening no files (MIME types are guessed from extensions)")
+	deferChecksums := fs.Bool("defer-checksums", false, "Only record the path, size and modification time of new and changed files, leaving their checksums to index -calculate-checksums")
+	quickHash := fs.Bool("quick-hash", false, "Store a quick hash (size + first/last 64KB) and fully hash only files whose quick hashes collide")
+	sampleHash := fs.String("sample-hash", "", "Hash files larger than this size, e.g. 1G, by sampling their size and 16 regions of 1MB instead of reading them completely")
+	confirmSampled := fs.Bool("confirm-sampled", false, "After the scan, fully hash the files whose sampled hashes collide to confirm they are duplicates")
+	history := fs.Bool("history", false, "Record a snapshot of each scanned root so changes between scans can be queried (requires -db)")
+	paranoid := fs.Bool("paranoid", false, "Re-hash every file, even if its size and mtime match the index")
+	mtimeTolerance := fs.Duration("mtime-tolerance", 0, "Also keep the checksums of files whose size is unchanged and whose mtime moved by at most this much, e.g. 2s for SMB/CIFS mounts")
+	retries := fs.Int("retries", 2, "Times a read failing with a transient I/O error such as EIO is retried")
+	throttleBytes := fs.String("throttle-bytes", "", "Read at most this many bytes per second, with a K, M or G suffix (e.g. 20M)")
+	throttleFiles := fs.Float64("throttle-files", 0, "Index at most this many files per second (0 = no limit)")
+	idlePriority := fs.Bool("idle-priority", false, "Run with the lowest CPU and I/O priority, like nice and ionice -c 3")
+	keepDeleted := fs.String("keep-deleted", "", "Purge files found gone longer ago than this age, e.g. 90d, from the deletion journal (default: keep them)")
+	backup := fs.String("backup", "", "After the scan, back up the index to this file, e.g. index.backup.zst, for db restore (requires -db)")
+	backupInterval := fs.Duration("backup-interval", 0, "Also back up the index this often during the scan, e.g. 1h, so a long scan survives a damaged index (requires -backup)")
+	progress := fs.Bool("progress", false, "Report files/s, bytes/s, processed vs. discovered files and ETA while indexing")
+
+	return func() (indexer.ScanOptions, error) {
+		if *maxDepth < 0 {
+			return indexer.ScanOptions{}, fmt.Errorf("-max-depth must not be negative")
+		}
+		if *maxFileSize > 0 && *minFileSize > *maxFileSize {
+			return indexer.ScanOptions{}, fmt.Errorf("-min-size is larger than -max-size")
+		}
+		maxBytes, err := parseSize(*throttleBytes)
+		if err != nil {
+			return indexer.ScanOptions{}, fmt.Errorf("invalid -throttle-bytes: %v", err)
+		}
+		if *backupInterval < 0 {
+			return indexer.ScanOptions{}, fmt.Errorf("-backup-interval must not be negative")
+		}
+		if *backupInterval > 0 && *backup == "" {
+			return indexer.ScanOptions{}, fmt.Errorf("-backup-interval requires -backup")
+		}
+		if *throttleFiles < 0 {
+			return indexer.ScanOptions{}, fmt.Errorf("-throttle-files must not be negative")
+		}
+		sampleSize, err := parseSize(*sampleHash)
+		if err != nil {
+			return indexer.ScanOptions{}, fmt.Errorf("invalid -sample-hash: %v", err)
+		}
+		var extraHashes []string
+		if *extraHash != "" {
+			extraHashes = strings.Split(*extraHash, ",")
+		}
+		keep, err := parseAge(*keepDeleted)
+		if err != nil {
+			return indexer.ScanOptions{}, fmt.Errorf("invalid -keep-deleted: %v", err)
+		}
+		var secretScanner *secrets.Scanner
+		if *scanSecrets {
+			if !*content {
+				return indexer.ScanOptions{}, fmt.Errorf("-secrets requires -content")
+			}
+			if secretScanner, err = secrets.NewScanner(*secretRules, *secretEntropy); err != nil {
+				return indexer.ScanOptions{}, err
+			}
+		}
+		presetPatterns, err := filter.PresetPatterns(commaList(presets))
+		if err != nil {
+			return indexer.ScanOptions{}, err
+		}
+		symlinks := indexer.SymlinkSkip
+		switch {
+		case *followSymlinks && *recordSymlinks:
+			return indexer.ScanOptions{}, fmt.Errorf("-follow-symlinks and -record-symlinks cannot be combined")
+		case *followSymlinks:
+			symlinks = indexer.SymlinkFollow
+		case *recordSymlinks:
+			symlinks = indexer.SymlinkRecord
+		}
+
+		return indexer.ScanOptions{
+			MinFileSize:   *minFileSize,
+			MaxFileSize:   *maxFileSize,
+			Workers:       *workers,
+			Excludes:      append(append([]string{}, excludes...), presetPatterns...),
+			Includes:      includes,
+			IgnoreFiles:   *ignoreFiles,
+			Symlinks:      symlinks,
+			MaxDepth:      *maxDepth,
+			OneFileSystem: *oneFileSystem,
+
+			Extensions:        commaList(exts),
+			ExcludeExtensions: commaList(skipExts),
+
+			Content:        *content,
+			ContentMaxSize: *contentMaxSize,
+			EXIF:           *exif,
+			ImageHash:      *imageHash,
+			TextHash:       *textHash,
+			TextHashLimit:  *textHashMaxSize,
+			CodeMetrics:    *codeMetrics,
+			Media:          *mediaInfo,
+			XAttrs:         *xattrs,
+			Streams:        *streams,
+			ScanArchives:   *scanArchives,
+
+			Secrets: secretScanner,
+
+			HashAlgorithm: *hashAlgorithm,
+			Label:         *label,
+			MigrateHash:   *migrateHash,
+			QuickHash:     *quickHash,
+			History:       *history,
+
+			SampleHash:     sampleSize,
+			ConfirmSampled: *confirmSampled,
+
+			ExtraHashes:    extraHashes,
+			DeferChecksums: *deferChecksums,
+			NoChecksum:     *noChecksum,
+
+			Paranoid:       *paranoid,
+			MtimeTolerance: *mtimeTolerance,
+			Retries:        *retries,
+
+			ThrottleFiles: *throttleFiles,
+			ThrottleBytes: maxBytes,
+			IdlePriority:  *idlePriority,
+
+			KeepDeleted: keep,
+
+			Backup:         *backup,
+			BackupInterval: *backupInterval,
+
+			Progress: *progress,
+		}, nil
+	}
+}
+
+// runIndex handles the index command
+func (c *CLI) runIndex(args []string) error {
+	fs := c.newFlagSet("index")
+	var directories stringList
+	fs.Var(&directories, "dir", "Directory or s3://bucket/prefix URL to index (repeatable; each root is re-indexed independently)")
+	scanOptions := addScanFlags(fs)
+	s3Endpoint := fs.String("s3-endpoint", "", "S3-compatible service for s3:// roots, e.g. http://localhost:9000 for MinIO (default: $AWS_ENDPOINT_URL or AWS)")
+	s3Region := fs.String("s3-region", "", "Region of the S3 buckets (default: $AWS_REGION)")
+	s3DownloadHash := fs.Bool("s3-download-hash", false, "Download S3 objects to hash them instead of using ETags that are MD5 checksums")
+	calculateChecksums := fs.Bool("calculate-checksums", false, "Hash the indexed files without a checksum, such as those of -defer-checksums, sizes shared by several files first (after the scan of -dir, if any)")
+	duplicateSizes := fs.Bool("only-duplicate-sizes", false, "With -calculate-checksums, only hash files whose size another indexed file shares, as only those can be duplicates")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if len(directories) == 0 && !*calculateChecksums {
+		fs.Usage()
+		return fmt.Errorf("the index command requires -dir or -calculate-checksums")
+	}
+	if *duplicateSizes && !*calculateChecksums {
+		return fmt.Errorf("-only-duplicate-sizes requires -calculate-checksums")
+	}
+	opts, err := scanOptions()
+	if err != nil {
+		return err
+	}
+	opts.S3DownloadHash = *s3DownloadHash
+
+	// Load the existing index so its checksum algorithm is respected
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	for _, directory := range directories {
+		if source.IsS3URL(directory) {
+			s3, err := source.NewS3(source.S3Config{Endpoint: *s3Endpoint, Region: *s3Region})
+			if err != nil {
+				return err
+			}
+			c.indexer.SetS3(s3)
+			break
+		}
+	}
+
+	// An interrupted scan is saved with the files stored so far and a marker
+	// that stats reports until the root is indexed again
+	ctx, stop := interruptible()
+	defer stop()
+	if len(directories) > 0 {
+		err = c.indexer.IndexDirectories(ctx, directories, opts)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("error indexing directory: %v", err)
+		}
+	}
+	// The checksums are stored in batches, so an interrupted run resumes
+	// with the files it did not get to
+	var hashErr error
+	if err == nil && *calculateChecksums {
+		rehashOptions := indexer.RehashOptions{MissingOnly: true, Dirs: directories, Workers: opts.Workers, Retries: opts.Retries,
+			DuplicateSizes: *duplicateSizes, DuplicatesFirst: true}
+		if hashErr = c.calculateChecksums(ctx, rehashOptions); errors.Is(hashErr, context.Canceled) {
+			err, hashErr = hashErr, nil
+		}
+	}
+
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+	if hashErr != nil {
+		return fmt.Errorf("error calculating checksums: %v", hashErr)
+	}
+	if err != nil {
+		return fmt.Errorf("indexing interrupted; run the index command again to complete it")
+	}
+	return nil
+}
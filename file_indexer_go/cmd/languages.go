@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runLanguages handles the languages command, which sums up the lines of
+// source files per programming language
+func (c *CLI) runLanguages(args []string) error {
+	fs := c.newFlagSet("languages")
+	output := addOutputFlag(fs)
+	dir := fs.String("dir", "", "Only count the source files below this directory (default: the whole index)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	usage, err := c.indexer.LanguageUsage(context.Background(), *dir)
+	if err != nil {
+		return fmt.Errorf("error summing up languages: %v", err)
+	}
+	if *output == outputJSON {
+		if usage == nil {
+			usage = []models.LanguageUsage{}
+		}
+		return writeJSON(usage)
+	}
+
+	if len(usage) == 0 {
+		fmt.Println("No source files found; index with -code-metrics to count them.")
+		return nil
+	}
+	var total models.LanguageUsage
+	fmt.Printf("%-16s %8s %10s %10s %10s %10s %10s\n", "Language", "Files", "Lines", "Code", "Comments", "Blank", "Size")
+	for _, entry := range usage {
+		printLanguageUsage(entry.Language, entry)
+		total.FileCount += entry.FileCount
+		total.Lines += entry.Lines
+		total.CodeLines += entry.CodeLines
+		total.CommentLines += entry.CommentLines
+		total.BlankLines += entry.BlankLines
+		total.TotalSize += entry.TotalSize
+	}
+	printLanguageUsage("Total", total)
+	return nil
+}
+
+// printLanguageUsage prints a row of the languages table
+func printLanguageUsage(name string, entry models.LanguageUsage) {
+	fmt.Printf("%-16s %8d %10d %10d %10d %10d %10s\n", name, entry.FileCount, entry.Lines, entry.CodeLines,
+		entry.CommentLines, entry.BlankLines, models.FormatSize(entry.TotalSize))
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logLevel is the minimum level of messages written to the log. It is shared
+// by all handlers, so changing it takes effect immediately.
+var logLevel = new(slog.LevelVar)
+
+// logFormat and logOutput are the format and destination of the current
+// handler, kept so either can be changed without the other
+var (
+	logFormat           = "text"
+	logOutput io.Writer = os.Stderr
+)
+
+// setLogLevel parses the value of the -log-level flag
+func setLogLevel(value string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return fmt.Errorf("unknown log level %q (supported: debug, info, warn, error)", value)
+	}
+	logLevel.Set(level)
+	return nil
+}
+
+// setLogFormat parses the value of the -log-format flag and installs the
+// matching handler as the default logger. Logs go to stderr, and not stdout,
+// so that command output can be piped.
+func setLogFormat(value string) error {
+	format := strings.ToLower(value)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q (supported: text, json)", value)
+	}
+	logFormat = format
+	installLogger()
+	return nil
+}
+
+// appendLogFile writes the log to a file as well as stderr, appending to it
+// if it exists. The returned function closes the file and restores logging
+// to stderr only.
+func appendLogFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file: %v", err)
+	}
+	logOutput = io.MultiWriter(os.Stderr, file)
+	installLogger()
+	return func() {
+		logOutput = os.Stderr
+		installLogger()
+		file.Close()
+	}, nil
+}
+
+// installLogger installs the handler of the current format and output as
+// the default logger
+func installLogger() {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if logFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, opts)))
+		return
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(logOutput, opts)))
+}
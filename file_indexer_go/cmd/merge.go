@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+)
+
+// runMerge handles the merge command
+func (c *CLI) runMerge(args []string) error {
+	fs := c.newFlagSet("merge")
+	out := fs.String("out", "", "Target index (.json or .db)")
+	force := fs.Bool("force", false, "Overwrite the target if it already exists")
+	sources, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" || len(sources) == 0 {
+		fs.Usage()
+		return fmt.Errorf("the merge command requires -out and at least one source index")
+	}
+	if _, err := os.Stat(*out); err == nil && !*force {
+		return fmt.Errorf("target %s already exists (use -force to overwrite)", *out)
+	}
+
+	ctx := context.Background()
+	var merge []indexer.MergeSource
+	for _, arg := range sources {
+		host, path := mergeSourceHost(arg)
+		if _, err := os.Stat(path); err != nil && !isDatabasePath(path) {
+			return fmt.Errorf("source index not found: %v", err)
+		}
+		source, closeSource, err := openIndexAt(path)
+		if err != nil {
+			return err
+		}
+		index, err := source.ExportIndex(ctx)
+		closeSource()
+		if err != nil {
+			return fmt.Errorf("error reading source index %s: %v", path, err)
+		}
+		merge = append(merge, indexer.MergeSource{Host: host, Index: index})
+	}
+
+	result, err := indexer.MergeIndexes(merge)
+	if err != nil {
+		return fmt.Errorf("error merging indexes: %v", err)
+	}
+
+	target, closeTarget, err := createIndexAt(*out)
+	if err != nil {
+		return err
+	}
+	defer closeTarget()
+
+	if err := target.ImportIndex(ctx, result.Index); err != nil {
+		return fmt.Errorf("error writing target index: %v", err)
+	}
+	if err := target.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving target index: %v", err)
+	}
+
+	slog.Info("Merged indexes", "sources", len(merge), "files", len(result.Index.Files),
+		"superseded", result.Superseded, "to", *out)
+	return nil
+}
+
+// mergeSourceHost splits a merge source given as HOST=INDEX. Without a host,
+// the files are tagged with the name of the index file, e.g. laptop for
+// laptop.db.
+func mergeSourceHost(arg string) (host, path string) {
+	if name, index, ok := strings.Cut(arg, "="); ok && name != "" && index != "" && !strings.ContainsAny(name, ":/\\") {
+		return name, index
+	}
+	base := filepath.Base(arg)
+	return strings.TrimSuffix(base, filepath.Ext(base)), arg
+}
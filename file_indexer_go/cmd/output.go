@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// Output formats supported by commands that print results
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputCSV  = "csv"  // only for duplicate reports
+	outputHTML = "html" // only for duplicate reports
+)
+
+// addOutputFlag registers the -output flag on a command
+func addOutputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", outputText, "Output format: text or json")
+}
+
+// checkOutputFormat validates the value of the -output flag
+func checkOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q (supported: text, json)", format)
+	}
+}
+
+// location returns the path of a file as printed: the bare path of the files
+// of this machine, and the path prefixed with its host for those of others
+func (c *CLI) location(file models.FileInfo) string {
+	return file.DisplayPath(c.indexer.Host())
+}
+
+// writeJSON prints a value as indented JSON on stdout
+func writeJSON(v interface{}) error {
+	return encodeJSON(os.Stdout, v)
+}
+
+// encodeJSON writes a value as indented JSON
+func encodeJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("error encoding JSON output: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/filter"
+)
+
+// runPresets handles the presets command, which lists the exclude presets
+// that index, watch and daemon accept with -preset
+func (c *CLI) runPresets(args []string) error {
+	fs := c.newFlagSet("presets")
+	output := addOutputFlag(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	presets := filter.Presets()
+	if *output == outputJSON {
+		return writeJSON(presets)
+	}
+	for n, preset := range presets {
+		if n > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s: %s\n", preset.Name, preset.Description)
+		fmt.Printf("  %s\n", strings.Join(preset.Patterns, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// addQueryFlags registers the filters shared by search and list and returns
+// a function that assembles them once the flags are parsed
+func addQueryFlags(fs *flag.FlagSet) func() (models.FileQuery, error) {
+	minSize := fs.String("min-size", "", "Only files of at least this size (bytes, or with a K, M, G or T suffix, e.g. 100M)")
+	maxSize := fs.String("max-size", "", "Only files of at most this size (bytes, or with a K, M, G or T suffix)")
+	modifiedAfter := fs.String("modified-after", "", "Only files modified at or after this time (RFC 3339, YYYY-MM-DD, or an age such as 30d or 12h)")
+	modifiedBefore := fs.String("modified-before", "", "Only files modified before this time (RFC 3339, YYYY-MM-DD, or an age such as 30d or 12h)")
+	xattr := fs.String("xattr", "", "Only files with this extended attribute, as name or name=value (e.g. user.tag=red)")
+	tag := fs.String("tag", "", "Only files with this macOS Finder tag, color label or tag added with the tag command (case-insensitive)")
+	sortField := fs.String("sort", string(models.SortName), "Order results by: name, path, size or mtime")
+	desc := fs.Bool("desc", false, "Reverse the sort order (e.g. largest or newest first)")
+	limit := fs.Int("limit", 0, "Maximum number of files to show (0 = no limit)")
+	offset := fs.Int("offset", 0, "Number of matching files to skip, for paging with -limit")
+
+	return func() (models.FileQuery, error) {
+		if *limit < 0 || *offset < 0 {
+			return models.FileQuery{}, fmt.Errorf("-limit and -offset must not be negative")
+		}
+		query := models.FileQuery{XAttr: *xattr, Tag: *tag, Desc: *desc, Limit: *limit, Offset: *offset}
+		var err error
+		if query.Sort, err = models.ParseSortField(*sortField); err != nil {
+			return query, err
+		}
+		if query.MinSize, err = parseSize(*minSize); err != nil {
+			return query, fmt.Errorf("invalid -min-size: %v", err)
+		}
+		if query.MaxSize, err = parseSize(*maxSize); err != nil {
+			return query, fmt.Errorf("invalid -max-size: %v", err)
+		}
+		if query.ModifiedAfter, err = parseTime(*modifiedAfter); err != nil {
+			return query, fmt.Errorf("invalid -modified-after: %v", err)
+		}
+		if query.ModifiedBefore, err = parseTime(*modifiedBefore); err != nil {
+			return query, fmt.Errorf("invalid -modified-before: %v", err)
+		}
+		if query.MaxSize > 0 && query.MinSize > query.MaxSize {
+			return query, fmt.Errorf("-min-size is larger than -max-size")
+		}
+		return query, nil
+	}
+}
+
+// parseSize parses a byte count with an optional binary K, M, G or T suffix.
+// An empty value means no limit.
+func parseSize(input string) (int64, error) {
+	value := strings.TrimSpace(strings.ToUpper(input))
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	for n, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(value, suffix) {
+			multiplier = int64(1) << (10 * (n + 1))
+			value = strings.TrimSuffix(value, suffix)
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%q is not a size", input)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// parseTime parses an absolute time (RFC 3339 or YYYY-MM-DD in local time) or
+// an age relative to now, such as 30d, 12h or 90m. An empty value means no
+// limit.
+func parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	// Days are not understood by time.ParseDuration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	} else if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+		return time.Now().Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, time or age", value)
+}
+
+// formatAge formats an age as parseAge accepts it, in days if it is whole
+// days
+func formatAge(age time.Duration) string {
+	if age >= 24*time.Hour && age%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(age/(24*time.Hour))) + "d"
+	}
+	return age.String()
+}
+
+// parseAge parses an age such as 30d or 12h. An empty value means none.
+func parseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+		return age, nil
+	}
+	return 0, fmt.Errorf("%q is not an age", value)
+}
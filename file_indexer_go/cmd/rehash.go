@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+)
+
+// rehashFailure is a file that still could not be hashed
+type rehashFailure struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// rehashReport is the outcome of the rehash command
+type rehashReport struct {
+	Hashed  int             `json:"hashed"`
+	Skipped int             `json:"skipped"`
+	Failed  []rehashFailure `json:"failed"`
+}
+
+// runRehash handles the rehash command, which recomputes the checksums of
+// indexed files without a scan, e.g. of those whose hashing failed
+func (c *CLI) runRehash(args []string) error {
+	fs := c.newFlagSet("rehash")
+	missingOnly := fs.Bool("missing-only", false, "Only re-hash files stored without a checksum, such as those whose hashing failed during a scan")
+	var dirs stringList
+	fs.Var(&dirs, "dir", "Only re-hash files below this directory (repeatable; default: the whole index)")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of concurrent checksum workers")
+	retries := fs.Int("retries", 2, "Times a read failing with a transient I/O error such as EIO is retried")
+	output := addOutputFlag(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if *retries < 0 {
+		return fmt.Errorf("-retries must not be negative")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx, stop := interruptible()
+	defer stop()
+	report := rehashReport{Failed: []rehashFailure{}}
+	opts := indexer.RehashOptions{MissingOnly: *missingOnly, Dirs: dirs, Workers: *workers, Retries: *retries}
+	err = c.indexer.Rehash(ctx, opts, func(result indexer.RehashResult) {
+		switch result.Status {
+		case indexer.RehashOK:
+			report.Hashed++
+		case indexer.RehashSkipped:
+			report.Skipped++
+		case indexer.RehashFailed:
+			failure := rehashFailure{Path: result.File.Path, Reason: result.Reason}
+			if result.Err != nil {
+				failure.Error = result.Err.Error()
+			}
+			report.Failed = append(report.Failed, failure)
+		}
+	})
+	interrupted := errors.Is(err, context.Canceled)
+	if err != nil && !interrupted {
+		return fmt.Errorf("error re-hashing files: %v", err)
+	}
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+	if interrupted {
+		return fmt.Errorf("re-hashing interrupted; the checksums computed so far were saved")
+	}
+	sort.Slice(report.Failed, func(a, b int) bool { return report.Failed[a].Path < report.Failed[b].Path })
+
+	if *output == outputJSON {
+		if err := writeJSON(report); err != nil {
+			return err
+		}
+	} else {
+		printRehashReport(report)
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d files still could not be hashed", len(report.Failed))
+	}
+	return nil
+}
+
+// calculateChecksums re-hashes files as the second phase of a two-phase scan,
+// logging the files that could not be hashed
+func (c *CLI) calculateChecksums(ctx context.Context, opts indexer.RehashOptions) error {
+	var hashed, failed int
+	err := c.indexer.Rehash(ctx, opts, func(result indexer.RehashResult) {
+		switch result.Status {
+		case indexer.RehashOK:
+			hashed++
+		case indexer.RehashFailed:
+			failed++
+			slog.Warn("Error calculating checksum", "path", result.File.Path, "reason", result.Reason, "err", result.Err)
+		}
+	})
+	slog.Info("Calculated checksums", "files", hashed, "failed", failed)
+	return err
+}
+
+// printRehashReport lists the files that still failed and sums them up by
+// reason
+func printRehashReport(report rehashReport) {
+	reasons := make(map[string]int)
+	for _, failure := range report.Failed {
+		reasons[failure.Reason]++
+		if failure.Error != "" {
+			fmt.Printf("[%s] %s: %s\n", failure.Reason, failure.Path, failure.Error)
+		} else {
+			fmt.Printf("[%s] %s\n", failure.Reason, failure.Path)
+		}
+	}
+	if len(report.Failed) > 0 {
+		fmt.Println()
+	}
+
+	fmt.Printf("Re-hashed %d files, %d still failed, %d skipped (only sampled or quick hashes)\n", report.Hashed, len(report.Failed), report.Skipped)
+	names := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		return reasons[names[a]] > reasons[names[b]] || reasons[names[a]] == reasons[names[b]] && names[a] < names[b]
+	})
+	for _, reason := range names {
+		fmt.Printf("  %s: %d\n", reason, reasons[reason])
+	}
+}
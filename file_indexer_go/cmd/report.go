@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// Statuses of the files of a duplicate group
+const (
+	statusOriginal  = "original"
+	statusDuplicate = "duplicate"
+	statusHardlink  = "hardlink"
+)
+
+// duplicateReport is the report of the duplicates command in the json, csv
+// and html output formats
+type duplicateReport struct {
+	GeneratedAt       time.Time              `json:"generated_at"`
+	Index             string                 `json:"index"`
+	OriginalRules     []indexer.OriginalRule `json:"original_rules"` // what the originals were chosen by
+	PreferredPrefixes []string               `json:"preferred_prefixes,omitempty"`
+	KeptPrefixes      []string               `json:"kept_prefixes,omitempty"` // keep and protect rules of -rules
+	ProtectedPrefixes []string               `json:"protected_prefixes,omitempty"`
+	GroupCount        int                    `json:"group_count"`
+	DuplicateFiles    int                    `json:"duplicate_files"` // files of all groups, originals included
+	WastedSpace       int64                  `json:"wasted_space"`
+	Groups            []reportGroup          `json:"groups"`
+}
+
+// reportGroup is a duplicate group of a report. Its members start with the
+// original.
+type reportGroup struct {
+	ID          int            `json:"id"` // position in the report, from 1
+	Checksum    string         `json:"checksum"`
+	FileSize    int64          `json:"file_size"`
+	Copies      int            `json:"copies"`
+	WastedSpace int64          `json:"wasted_space"`
+	Original    string         `json:"original"` // Location of the chosen original
+	Tags        []string       `json:"tags,omitempty"`
+	Sampled     bool           `json:"sampled,omitempty"` // Checksum is a sampled hash of candidate duplicates
+	Members     []reportMember `json:"members"`
+}
+
+// reportMember is a file of a duplicate group
+type reportMember struct {
+	Path                 string    `json:"path"`
+	Host                 string    `json:"host,omitempty"`
+	Status               string    `json:"status"` // statusOriginal, statusDuplicate or statusHardlink
+	FileSize             int64     `json:"file_size"`
+	ModificationDateTime time.Time `json:"modification_datetime"`
+	AppleDouble          string    `json:"apple_double,omitempty"` // path of the file's AppleDouble file
+	KeptBy               string    `json:"kept_by,omitempty"`      // keep rule that keeps a duplicate from being changed
+	Aliases              []string  `json:"aliases,omitempty"`      // other paths of the same directory entry
+}
+
+// newDuplicateReport builds the report of the duplicate groups of an index,
+// whose originals were chosen by policy, with paths as seen on host
+func newDuplicateReport(index, host string, policy indexer.OriginalPolicy, groups []models.DuplicateGroup) duplicateReport {
+	report := duplicateReport{
+		GeneratedAt:       time.Now(),
+		Index:             redactIndexPath(index),
+		OriginalRules:     policy.Rules,
+		PreferredPrefixes: policy.Prefixes,
+		KeptPrefixes:      policy.Keep,
+		ProtectedPrefixes: policy.Protect,
+		GroupCount:        len(groups),
+		Groups:            []reportGroup{},
+	}
+	if len(report.OriginalRules) == 0 {
+		report.OriginalRules = []indexer.OriginalRule{indexer.OriginalPath}
+	}
+	for n, group := range groups {
+		report.DuplicateFiles += len(group.Files)
+		report.WastedSpace += group.WastedSpace
+
+		reported := reportGroup{
+			ID:          n + 1,
+			Checksum:    group.Checksum,
+			FileSize:    group.FileSize,
+			Copies:      group.Copies,
+			WastedSpace: group.WastedSpace,
+			Original:    group.Files[0].DisplayPath(host),
+			Tags:        group.Tags,
+			Sampled:     group.Sampled,
+		}
+		for idx, file := range group.Files {
+			member := reportMember{
+				Path:                 file.Path,
+				Host:                 file.Host,
+				Status:               duplicateStatus(group, idx),
+				FileSize:             file.FileSize,
+				ModificationDateTime: file.ModificationDateTime,
+			}
+			if companion, ok := group.Companions[file.Location()]; ok {
+				member.AppleDouble = companion.Path
+			}
+			member.Aliases = group.Aliases[file.Location()]
+			if idx > 0 {
+				member.KeptBy = policy.KeepRule(file)
+			}
+			reported.Members = append(reported.Members, member)
+		}
+		report.Groups = append(report.Groups, reported)
+	}
+	return report
+}
+
+// redactIndexPath hides the password of a PostgreSQL URL, so reports can be
+// shared
+func redactIndexPath(path string) string {
+	if !db.IsPostgresURL(path) {
+		return path
+	}
+	parsed, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	return parsed.Redacted()
+}
+
+// duplicateStatus returns the status of the file at position idx of a group
+func duplicateStatus(group models.DuplicateGroup, idx int) string {
+	if idx == 0 {
+		return statusOriginal
+	}
+	if models.HardlinkIndex(group.Files[:idx], group.Files[idx]) >= 0 {
+		// Hardlinks share storage with an earlier file and waste nothing
+		return statusHardlink
+	}
+	return statusDuplicate
+}
+
+// reportCSVHeader lists the columns of the CSV report, one row per file
+var reportCSVHeader = []string{
+	"group_id", "checksum", "file_size", "copies", "wasted_space", "original",
+	"status", "path", "host", "modification_datetime", "apple_double", "kept_by", "aliases", "sampled",
+}
+
+// writeReportCSV writes a duplicate report as CSV with one row per file
+func writeReportCSV(out io.Writer, report duplicateReport) error {
+	writer := csv.NewWriter(out)
+	if err := writer.Write(reportCSVHeader); err != nil {
+		return fmt.Errorf("error writing CSV: %v", err)
+	}
+
+	for _, group := range report.Groups {
+		for _, member := range group.Members {
+			record := []string{
+				strconv.Itoa(group.ID),
+				group.Checksum,
+				strconv.FormatInt(group.FileSize, 10),
+				strconv.Itoa(group.Copies),
+				strconv.FormatInt(group.WastedSpace, 10),
+				group.Original,
+				member.Status,
+				member.Path,
+				member.Host,
+				member.ModificationDateTime.Format(time.RFC3339Nano),
+				member.AppleDouble,
+				member.KeptBy,
+				strings.Join(member.Aliases, "\n"),
+				strconv.FormatBool(group.Sampled),
+			}
+			if err := writer.Write(record); err != nil {
+				return fmt.Errorf("error writing CSV: %v", err)
+			}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV: %v", err)
+	}
+	return nil
+}
+
+//go:embed templates/duplicates_report.html
+var reportTemplateText string
+
+// reportTemplate renders a duplicate report as a self-contained HTML page
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": models.FormatSize,
+}).Parse(reportTemplateText))
+
+// writeReportHTML writes a duplicate report as an HTML page
+func writeReportHTML(out io.Writer, report duplicateReport) error {
+	if err := reportTemplate.Execute(out, report); err != nil {
+		return fmt.Errorf("error writing HTML report: %v", err)
+	}
+	return nil
+}
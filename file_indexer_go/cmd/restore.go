@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runRestore handles the restore command, which moves the duplicates that
+// dedupe -move-to put into a quarantine directory back
+func (c *CLI) runRestore(args []string) error {
+	fs := c.newFlagSet("restore")
+	from := fs.String("from", "", "Quarantine directory that dedupe -move-to moved duplicates into")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be restored")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *from == "" {
+		fs.Usage()
+		return fmt.Errorf("the restore command requires -from")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx, stop := interruptible()
+	defer stop()
+	results, err := c.indexer.RestoreQuarantine(ctx, *from, *dryRun)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("error restoring from quarantine: %v", err)
+	}
+
+	if !printRestoreResults(results, *dryRun) {
+		return nil
+	}
+
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("restore interrupted")
+	}
+	return nil
+}
+
+// printRestoreResults lists the files that were or would be moved back with a
+// summary, and reports whether they were moved, which needs saving the index
+func printRestoreResults(results []indexer.RestoreResult, dryRun bool) bool {
+	var done, skipped int
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			skipped++
+			fmt.Printf("[SKIPPED] %s: %v\n", result.QuarantinePath, result.Err)
+		case dryRun:
+			fmt.Printf("[DRY RUN] would restore %s -> %s (%s)\n", result.QuarantinePath, result.Path, models.FormatSize(result.Size))
+		default:
+			done++
+			fmt.Printf("[DONE] restore %s -> %s (%s)\n", result.QuarantinePath, result.Path, models.FormatSize(result.Size))
+		}
+	}
+
+	fmt.Println("\n=== SUMMARY ===")
+	if dryRun {
+		fmt.Printf("Files that would be restored: %d\n", len(results)-skipped)
+		fmt.Printf("Skipped: %d\n", skipped)
+		return false
+	}
+	fmt.Printf("Files restored: %d\n", done)
+	fmt.Printf("Skipped: %d\n", skipped)
+	return true
+}
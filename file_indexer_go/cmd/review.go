@@ -0,0 +1,360 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// reviewHelp lists the key bindings of the duplicate review
+const reviewHelp = "↑/↓ move · space mark/unmark for deletion · o keep only this · u unmark all · ←/→ group · e execute · q quit"
+
+// runReview handles the review command
+func (c *CLI) runReview(args []string) error {
+	fs := c.newFlagSet("review")
+	originalPolicy := addOriginalFlags(fs)
+	trashOptions := c.addTrashFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	policy, err := originalPolicy()
+	if err != nil {
+		return err
+	}
+	trash, err := trashOptions()
+	if err != nil {
+		return err
+	}
+	if !isTerminal(os.Stdout) {
+		return fmt.Errorf("the review command needs a terminal")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	if err := c.checkHashed(context.Background(), "duplicates cannot be found"); err != nil {
+		return err
+	}
+	groups, err := c.indexer.FindDuplicates(context.Background(), policy)
+	if err != nil {
+		return fmt.Errorf("error finding duplicates: %v", err)
+	}
+	if len(groups) == 0 {
+		fmt.Println("No duplicate files found")
+		return nil
+	}
+
+	final, err := tea.NewProgram(newReviewModel(groups, policy), tea.WithAltScreen()).Run()
+	if err != nil {
+		return fmt.Errorf("error running review: %v", err)
+	}
+	model := final.(*reviewModel)
+	if !model.confirmed {
+		fmt.Println("Review cancelled; no files were changed")
+		return nil
+	}
+	c.indexer.SetProtected(policy)
+
+	ctx, stop := interruptible()
+	defer stop()
+	results, err := c.indexer.DeleteDuplicates(ctx, model.deletions(), trash)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("error deleting duplicates: %v", err)
+	}
+
+	var done, skipped int
+	var reclaimed int64
+	for _, result := range results {
+		if result.Err != nil {
+			skipped++
+			fmt.Printf("[SKIPPED] %s: %v\n", result.Duplicate, result.Err)
+			continue
+		}
+		done++
+		reclaimed += result.Size
+		fmt.Printf("[DONE] delete %s (kept %s, %s)\n", result.Duplicate, result.Original, models.FormatSize(result.Size))
+	}
+
+	fmt.Println("\n=== SUMMARY ===")
+	fmt.Printf("Files deleted: %d\n", done)
+	fmt.Printf("Skipped: %d\n", skipped)
+	if trash.Dir != "" {
+		printTrashSummary(results, reclaimed, trash)
+	} else {
+		fmt.Printf("Space reclaimed: %s\n", models.FormatSize(reclaimed))
+	}
+
+	// Save the deletions made before an interruption too
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("deletion interrupted")
+	}
+	return nil
+}
+
+// reviewModel is the state of the duplicate review. Files are only marked
+// while reviewing; nothing is deleted until the queue is confirmed.
+type reviewModel struct {
+	groups []models.DuplicateGroup
+	marked []map[int]bool // Per group, the indexes of files queued for deletion
+	policy indexer.OriginalPolicy
+
+	group  int // Group shown
+	cursor int // Selected file of the group
+
+	confirming bool   // The confirmation screen is shown
+	confirmed  bool   // The queued deletions were confirmed
+	message    string // Feedback on the last key press
+	height     int
+}
+
+// newReviewModel creates a review of the given groups with nothing marked.
+// Files kept by the keep rules of policy cannot be marked.
+func newReviewModel(groups []models.DuplicateGroup, policy indexer.OriginalPolicy) *reviewModel {
+	marked := make([]map[int]bool, len(groups))
+	for n := range marked {
+		marked[n] = make(map[int]bool)
+	}
+	return &reviewModel{groups: groups, marked: marked, policy: policy, height: 24}
+}
+
+// Init implements tea.Model
+func (m *reviewModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model
+func (m *reviewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		if msg.Height > 0 { // Terminals that report no size keep the default
+			m.height = msg.Height
+		}
+	case tea.KeyMsg:
+		m.message = ""
+		if m.confirming {
+			return m.updateConfirm(msg)
+		}
+		return m.updateReview(msg)
+	}
+	return m, nil
+}
+
+// updateReview handles a key press while walking through the groups
+func (m *reviewModel) updateReview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	files := m.groups[m.group].Files
+	marked := m.marked[m.group]
+
+	switch msg.String() {
+	case "q", "ctrl+c", "esc":
+		return m, tea.Quit
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(files)-1 {
+			m.cursor++
+		}
+	case " ", "x", "d":
+		switch {
+		case marked[m.cursor]:
+			delete(marked, m.cursor)
+		case m.keptBy(m.cursor) != "":
+			m.message = "Kept by rule " + m.keptBy(m.cursor)
+		case len(marked) == len(files)-1:
+			m.message = "At least one copy of each group is kept"
+		default:
+			marked[m.cursor] = true
+		}
+	case "o":
+		for n := range files {
+			if n != m.cursor && m.keptBy(n) == "" {
+				marked[n] = true
+			}
+		}
+		delete(marked, m.cursor)
+	case "u":
+		m.marked[m.group] = make(map[int]bool)
+	case "right", "l", "n", "enter":
+		m.showGroup(m.group + 1)
+	case "left", "h", "p":
+		m.showGroup(m.group - 1)
+	case "e":
+		if len(m.deletions()) == 0 {
+			m.message = "No files are marked for deletion"
+			break
+		}
+		m.confirming = true
+	}
+	return m, nil
+}
+
+// updateConfirm handles a key press on the confirmation screen
+func (m *reviewModel) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "y", "Y":
+		m.confirmed = true
+		return m, tea.Quit
+	case "ctrl+c":
+		return m, tea.Quit
+	case "n", "N", "esc", "q":
+		m.confirming = false
+	}
+	return m, nil
+}
+
+// showGroup moves to a group if it exists
+func (m *reviewModel) showGroup(group int) {
+	if group < 0 || group >= len(m.groups) {
+		return
+	}
+	m.group = group
+	m.cursor = 0
+}
+
+// keptBy returns the keep rule that keeps a file of the shown group from
+// being deleted, or "" if there is none. The original is never deleted
+// anyway, so rules are only reported for the other files.
+func (m *reviewModel) keptBy(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return m.policy.KeepRule(m.groups[m.group].Files[n])
+}
+
+// deletions returns the queued deletions in group order. The first unmarked
+// file of a group, which is the original unless it was marked, is recorded as
+// the copy that is kept.
+func (m *reviewModel) deletions() []indexer.DuplicateDeletion {
+	var deletions []indexer.DuplicateDeletion
+	for g, group := range m.groups {
+		if len(m.marked[g]) == 0 {
+			continue
+		}
+		var kept models.FileInfo
+		for n, file := range group.Files {
+			if !m.marked[g][n] {
+				kept = file
+				break
+			}
+		}
+		for n, file := range group.Files {
+			if m.marked[g][n] {
+				deletions = append(deletions, indexer.DuplicateDeletion{Checksum: group.Checksum, Kept: kept, Duplicate: file})
+			}
+		}
+	}
+	return deletions
+}
+
+// queuedSize returns the number and total size of the queued deletions
+func (m *reviewModel) queuedSize() (int, int64) {
+	deletions := m.deletions()
+	var size int64
+	for _, deletion := range deletions {
+		size += deletion.Duplicate.FileSize
+	}
+	return len(deletions), size
+}
+
+// View implements tea.Model
+func (m *reviewModel) View() string {
+	if m.confirming {
+		return m.viewConfirm()
+	}
+
+	group := m.groups[m.group]
+	var b strings.Builder
+	fmt.Fprintf(&b, "Duplicate group %d of %d · %d files · %s each · wasted %s\n\n",
+		m.group+1, len(m.groups), len(group.Files), models.FormatSize(group.FileSize), models.FormatSize(group.WastedSpace))
+
+	first, last := visibleRange(len(group.Files), m.cursor, m.height-7)
+	for n := first; n < last; n++ {
+		file := group.Files[n]
+		cursor := "  "
+		if n == m.cursor {
+			cursor = "> "
+		}
+		mark := "[keep]  "
+		if m.marked[m.group][n] {
+			mark = "[DELETE]"
+		}
+		var notes []string
+		if n == 0 {
+			notes = append(notes, "original")
+		}
+		if models.HardlinkIndex(group.Files[:n], file) >= 0 {
+			notes = append(notes, "hardlink")
+		}
+		if rule := m.keptBy(n); rule != "" {
+			notes = append(notes, "kept by rule "+rule)
+		}
+		if aliases := len(group.Aliases[file.Location()]); aliases > 0 {
+			notes = append(notes, fmt.Sprintf("also at %d other paths", aliases))
+		}
+		note := ""
+		if len(notes) > 0 {
+			note = " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Fprintf(&b, "%s%s %s  %s%s\n", cursor, mark, file.ModificationDateTime.Format("2006-01-02 15:04"), file.Path, note)
+	}
+	if last-first < len(group.Files) {
+		fmt.Fprintf(&b, "  ... files %d-%d of %d\n", first+1, last, len(group.Files))
+	}
+
+	count, size := m.queuedSize()
+	fmt.Fprintf(&b, "\nQueued for deletion: %d (%s)\n", count, models.FormatSize(size))
+	if m.message != "" {
+		fmt.Fprintf(&b, "%s\n", m.message)
+	}
+	b.WriteString(reviewHelp + "\n")
+	return b.String()
+}
+
+// viewConfirm shows the queued deletions and asks for confirmation
+func (m *reviewModel) viewConfirm() string {
+	deletions := m.deletions()
+	count, size := m.queuedSize()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Files to delete: %d (%s)\n\n", count, models.FormatSize(size))
+	shown := len(deletions)
+	if limit := m.height - 5; shown > limit {
+		shown = max(limit-1, 1)
+	}
+	for _, deletion := range deletions[:shown] {
+		fmt.Fprintf(&b, "  %s\n", deletion.Duplicate.Path)
+	}
+	if shown < len(deletions) {
+		fmt.Fprintf(&b, "  ... and %d more\n", len(deletions)-shown)
+	}
+	b.WriteString("\nDelete these files? y to confirm, n to go back\n")
+	return b.String()
+}
+
+// visibleRange returns the files of a group that fit on screen, scrolled so
+// that the cursor is visible
+func visibleRange(total, cursor, lines int) (int, int) {
+	if lines < 1 {
+		lines = 1
+	}
+	if total <= lines {
+		return 0, total
+	}
+	first := cursor - lines/2
+	first = max(0, min(first, total-lines))
+	return first, first + lines
+}
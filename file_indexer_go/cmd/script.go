@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// scriptCommands are the shell commands that replace a duplicate ($2) with
+// what the action makes of it, given its original ($1). cmp makes sure the
+// files are still identical when the script is run.
+var scriptCommands = map[indexer.DedupeAction]string{
+	indexer.DedupeDelete:   "rm -f -- %[2]s",
+	indexer.DedupeHardlink: "ln -f -- %[1]s %[2]s",
+	indexer.DedupeSymlink:  "ln -sf -- %[1]s %[2]s",
+	indexer.DedupeReflink:  "cp --reflink=always -- %[1]s %[2]s",
+}
+
+// writeScriptFile writes the shell script of a dedupe dry run to path and
+// makes it executable
+func writeScriptFile(path string, action indexer.DedupeAction, index string, results []indexer.DedupeResult) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("error creating script: %v", err)
+	}
+	defer file.Close()
+
+	if err := writeDedupeScript(file, action, index, results); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing script: %v", err)
+	}
+
+	commands := 0
+	for _, result := range results {
+		if result.Err == nil {
+			commands++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d %s commands to %s; review it, then run it with sh\n", commands, action, path)
+	return nil
+}
+
+// writeDedupeScript writes a POSIX shell script that carries out a dedupe
+// action on the duplicates of a dry run. Each command only runs if the
+// duplicate still matches its original byte for byte, and skipped
+// duplicates are listed as comments with the reason.
+func writeDedupeScript(out io.Writer, action indexer.DedupeAction, index string, results []indexer.DedupeResult) error {
+	command, ok := scriptCommands[action]
+	if !ok {
+		return fmt.Errorf("the %s action cannot be written as a script", action)
+	}
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintf(w, "# Dedupe script generated by file_indexer_go on %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "# Index: %s\n", redactIndexPath(index))
+	fmt.Fprintf(w, "# Action: %s\n", action)
+	if action == indexer.DedupeReflink {
+		fmt.Fprintln(w, "# Reflinks need GNU cp and btrfs or XFS; on macOS use cp -c instead.")
+	}
+	fmt.Fprintln(w, "#")
+	fmt.Fprintln(w, "# Review the commands before running the script. A duplicate is only")
+	fmt.Fprintln(w, "# changed if it is still identical to the original kept next to it.")
+	fmt.Fprintln(w, "# Index the directories again afterwards to update the index.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "set -u")
+	fmt.Fprintln(w, "skipped=0")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "dedupe() {")
+	fmt.Fprintln(w, "\tif cmp -s -- \"$1\" \"$2\"; then")
+	fmt.Fprintf(w, "\t\t"+command+"\n", `"$1"`, `"$2"`)
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, "\t\techo \"skipped $2: no longer identical to $1\" >&2")
+	fmt.Fprintln(w, "\t\tskipped=$((skipped + 1))")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+
+	var original string
+	var reclaimable int64
+	for _, result := range results {
+		if result.Original != original {
+			original = result.Original
+			fmt.Fprintf(w, "\n# Keep %s (checksum %s)\n", commentLine(original), result.Checksum)
+		}
+		if result.Err != nil {
+			fmt.Fprintf(w, "# skipped %s: %s\n", commentLine(result.Duplicate), commentLine(result.Err.Error()))
+			continue
+		}
+		reclaimable += result.Size
+		fmt.Fprintf(w, "dedupe %s %s  # %s\n", shellQuote(result.Original), shellQuote(result.Duplicate), models.FormatSize(result.Size))
+	}
+
+	fmt.Fprintf(w, "\n# Space reclaimed if every command runs: %s\n", models.FormatSize(reclaimable))
+	fmt.Fprintln(w, "[ \"$skipped\" -eq 0 ] || echo \"$skipped duplicates skipped\" >&2")
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing script: %v", err)
+	}
+	return nil
+}
+
+// shellQuote quotes a string as a single word for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// commentLine keeps a path or message on its comment line, as file names may
+// contain line breaks
+func commentLine(s string) string {
+	return strings.NewReplacer("\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runSearch handles the search command
+func (c *CLI) runSearch(args []string) error {
+	fs := c.newFlagSet("search")
+	output := addOutputFlag(fs)
+	searchContent := fs.Bool("content", false, "Also match text inside the contents of files indexed with -content")
+	rank := fs.Bool("rank", false, "Match the query's keywords, stemmed, against the contents of files and list the best matches first (requires -db)")
+	fileQuery := addQueryFlags(fs)
+	countOnly := addCountOnlyFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	filters, err := fileQuery()
+	if err != nil {
+		return err
+	}
+
+	if len(positional) == 0 {
+		fs.Usage()
+		return fmt.Errorf("the search command requires a query")
+	}
+	query := strings.Join(positional, " ")
+	if *rank {
+		sorted := false
+		fs.Visit(func(f *flag.Flag) {
+			sorted = sorted || f.Name == "sort" || f.Name == "desc"
+		})
+		if sorted {
+			return fmt.Errorf("-sort and -desc cannot be combined with -rank, which orders by relevance")
+		}
+	}
+
+	closeIndex, err := c.openQueryIndex()
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	if *rank {
+		return c.rankedSearch(query, filters, *output, *countOnly)
+	}
+
+	if *countOnly {
+		count, err := c.indexer.CountMatches(context.Background(), query, *searchContent, filters)
+		if err != nil {
+			return fmt.Errorf("error counting files: %v", err)
+		}
+		return printCount(count, *output)
+	}
+
+	var results []models.FileInfo
+	if *searchContent {
+		results, err = c.indexer.SearchContent(context.Background(), query, filters)
+	} else {
+		results, err = c.indexer.Search(context.Background(), query, filters)
+	}
+	if err != nil {
+		return fmt.Errorf("error searching index: %v", err)
+	}
+	if *output == outputJSON {
+		return writeJSON(nonNilFiles(results))
+	}
+
+	fmt.Printf("Search results for '%s':\n", query)
+	if paged(filters) {
+		fmt.Printf("Showing files %s:\n\n", pageRange(filters, len(results)))
+	} else {
+		fmt.Printf("Found %d files:\n\n", len(results))
+	}
+
+	for i, file := range results {
+		fmt.Printf("%d. %s", filters.Offset+i+1, c.location(file))
+		fmt.Printf(" (%d bytes)", file.FileSize)
+		fmt.Println()
+	}
+	return nil
+}
+
+// rankedSearch lists the files whose contents best match the keywords of a
+// query, with their scores
+func (c *CLI) rankedSearch(query string, filters models.FileQuery, output string, countOnly bool) error {
+	if countOnly {
+		count, err := c.indexer.CountRanked(context.Background(), query, filters)
+		if err != nil {
+			return fmt.Errorf("error counting files: %v", err)
+		}
+		return printCount(count, output)
+	}
+
+	results, err := c.indexer.RankedSearch(context.Background(), query, filters)
+	if err != nil {
+		return fmt.Errorf("error searching index: %v", err)
+	}
+	if output == outputJSON {
+		if results == nil {
+			results = []models.RankedFile{}
+		}
+		return writeJSON(results)
+	}
+
+	fmt.Printf("Best matches for '%s':\n", query)
+	if paged(filters) {
+		fmt.Printf("Showing files %s:\n\n", pageRange(filters, len(results)))
+	} else {
+		fmt.Printf("Found %d files:\n\n", len(results))
+	}
+	for i, result := range results {
+		fmt.Printf("%d. %s (%d bytes, score %.2f)\n", filters.Offset+i+1, c.location(result.File), result.File.FileSize, result.Score)
+	}
+	return nil
+}
+
+// runList handles the list command
+func (c *CLI) runList(args []string) error {
+	fs := c.newFlagSet("list")
+	output := addOutputFlag(fs)
+	fileQuery := addQueryFlags(fs)
+	countOnly := addCountOnlyFlag(fs)
+	deleted := fs.Bool("deleted", false, "List the files scans and watches found gone, from the deletion journal, instead of the indexed files")
+	deletedIn := fs.String("deleted-in", "", "With -deleted, only files that were below this directory")
+	deletedAfter := fs.String("deleted-after", "", "With -deleted, only files found gone at or after this time (RFC 3339, YYYY-MM-DD, or an age such as 30d)")
+	deletedBefore := fs.String("deleted-before", "", "With -deleted, only files found gone before this time (RFC 3339, YYYY-MM-DD, or an age such as 30d)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	filters, err := fileQuery()
+	if err != nil {
+		return err
+	}
+	deletions := models.DeletionQuery{Dir: *deletedIn}
+	if deletions.After, err = parseTime(*deletedAfter); err != nil {
+		return fmt.Errorf("invalid -deleted-after: %v", err)
+	}
+	if deletions.Before, err = parseTime(*deletedBefore); err != nil {
+		return fmt.Errorf("invalid -deleted-before: %v", err)
+	}
+	if !*deleted && (deletions != models.DeletionQuery{}) {
+		return fmt.Errorf("-deleted-in, -deleted-after and -deleted-before require -deleted")
+	}
+	if *deleted && (filters.Tag != "" || filters.XAttr != "") {
+		return fmt.Errorf("-tag and -xattr cannot be combined with -deleted")
+	}
+
+	closeIndex, err := c.openQueryIndex()
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	if *deleted {
+		return c.listDeleted(deletions, filters, *output, *countOnly)
+	}
+	if *countOnly {
+		count, err := c.indexer.CountMatches(context.Background(), "", false, filters)
+		if err != nil {
+			return fmt.Errorf("error counting files: %v", err)
+		}
+		return printCount(count, *output)
+	}
+
+	files, err := c.indexer.ListFiles(context.Background(), filters)
+	if err != nil {
+		return fmt.Errorf("error listing files: %v", err)
+	}
+	if *output == outputJSON {
+		return writeJSON(nonNilFiles(files))
+	}
+
+	if paged(filters) {
+		fmt.Printf("Indexed files %s:\n\n", pageRange(filters, len(files)))
+	} else {
+		fmt.Printf("Indexed files (%d total):\n\n", len(files))
+	}
+
+	for i, file := range files {
+		fmt.Printf("%d. %s", filters.Offset+i+1, c.location(file))
+		fmt.Printf(" (%d bytes)", file.FileSize)
+		fmt.Println()
+	}
+	return nil
+}
+
+// listDeleted prints the entries of the deletion journal that match both
+// queries, in the order the files were found gone
+func (c *CLI) listDeleted(deletions models.DeletionQuery, filters models.FileQuery, output string, countOnly bool) error {
+	journal, err := c.indexer.Deletions(context.Background(), deletions)
+	if err != nil {
+		return fmt.Errorf("error listing deleted files: %v", err)
+	}
+	var matches []models.DeletedFile
+	for _, deleted := range journal {
+		if filters.Matches(deleted.File()) {
+			matches = append(matches, deleted)
+		}
+	}
+	if countOnly {
+		return printCount(int64(len(matches)), output)
+	}
+	total := len(matches)
+	matches = matches[min(filters.Offset, len(matches)):]
+	if filters.Limit > 0 {
+		matches = matches[:min(filters.Limit, len(matches))]
+	}
+	if output == outputJSON {
+		if matches == nil {
+			matches = []models.DeletedFile{}
+		}
+		return writeJSON(matches)
+	}
+
+	if paged(filters) {
+		fmt.Printf("Deleted files %s of %d:\n\n", pageRange(filters, len(matches)), total)
+	} else {
+		fmt.Printf("Deleted files (%d total):\n\n", total)
+	}
+	for i, deleted := range matches {
+		fmt.Printf("%d. %s (%d bytes), gone between %s and %s\n", filters.Offset+i+1, c.location(deleted.File()), deleted.FileSize,
+			deleted.LastSeen.Local().Format(time.DateTime), deleted.DeletedAt.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+// addCountOnlyFlag registers the -count-only flag on a command
+func addCountOnlyFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("count-only", false, "Only print the number of matching files (ignores -limit and -offset)")
+}
+
+// paged reports whether a query selects only part of its matches
+func paged(query models.FileQuery) bool {
+	return query.Limit > 0 || query.Offset > 0
+}
+
+// pageRange describes the positions of the files shown for a paged query
+func pageRange(query models.FileQuery, shown int) string {
+	if shown == 0 {
+		return fmt.Sprintf("after %d (none left)", query.Offset)
+	}
+	return fmt.Sprintf("%d-%d", query.Offset+1, query.Offset+shown)
+}
+
+// printCount prints the number of matching files for -count-only
+func printCount(count int64, output string) error {
+	if output == outputJSON {
+		return writeJSON(map[string]int64{"count": count})
+	}
+	fmt.Println(count)
+	return nil
+}
+
+// nonNilFiles makes sure empty results are encoded as [] rather than null
+func nonNilFiles(files []models.FileInfo) []models.FileInfo {
+	if files == nil {
+		return []models.FileInfo{}
+	}
+	return files
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/web"
+)
+
+// runServe handles the serve command
+func (c *CLI) runServe(args []string) error {
+	fs := c.newFlagSet("serve")
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on (use :8080 to accept connections from other hosts)")
+	tokensFile := fs.String("tokens", "", "File of API tokens clients must authenticate with, SCOPE TOKEN [NAME] per line, where SCOPE is read or write")
+	tlsCert := fs.String("tls-cert", "", "Certificate file to serve HTTPS with")
+	tlsKey := fs.String("tls-key", "", "Private key file of -tls-cert")
+	clientCA := fs.String("client-ca", "", "Require clients to present a certificate signed by the CAs in this file (requires -tls-cert)")
+	clientScope := fs.String("client-scope", "read", "Scope of clients with a certificate of -client-ca, read or write (a token may raise it)")
+	scanOptions := addScanFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	scan, err := scanOptions()
+	if err != nil {
+		return err
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		return fmt.Errorf("-client-ca requires -tls-cert")
+	}
+
+	var auth web.Auth
+	if *tokensFile != "" {
+		if auth.Tokens, err = web.LoadTokens(*tokensFile); err != nil {
+			return fmt.Errorf("error reading tokens: %v", err)
+		}
+	}
+	httpServer := &http.Server{Addr: *addr, ReadHeaderTimeout: 10 * time.Second}
+	if *clientCA != "" {
+		if auth.CertScope, err = web.ParseScope(*clientScope); err != nil {
+			return fmt.Errorf("invalid -client-scope: %v", err)
+		}
+		pem, err := os.ReadFile(*clientCA)
+		if err != nil {
+			return fmt.Errorf("error reading client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", *clientCA)
+		}
+		httpServer.TLSConfig = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	if len(auth.Tokens) > 0 && *tlsCert == "" && !isLoopback(*addr) {
+		slog.Warn("Clients send their tokens unencrypted without -tls-cert", "addr", *addr)
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	// Serve until interrupted, then let running requests and rescans finish
+	ctx, stop := interruptible()
+	defer stop()
+	opts := web.Options{Auth: auth, Scan: scan}
+	if c.global.UseDB && !c.global.ReadOnly {
+		opts.ScanContext = ctx
+	}
+	server, err := web.NewServer(c.indexer, opts)
+	if err != nil {
+		return err
+	}
+	httpServer.Handler = server.Handler()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
+	slog.Info("Serving index", "index", c.indexPath(), "url", scheme+"://"+*addr)
+	if *tlsCert != "" {
+		err = httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	stop()
+	server.Wait()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving: %v", err)
+	}
+	slog.Info("Stopped serving", "index", c.indexPath())
+	return nil
+}
+
+// isLoopback reports whether an address to listen on only accepts
+// connections from this machine
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+
+	"github.com/chzyer/readline"
+)
+
+// Prompts of the SQL shell for the first and the following lines of a statement
+const (
+	shellPrompt             = "sql> "
+	shellContinuationPrompt = "...> "
+)
+
+// shellHelp describes the dot commands of the SQL shell
+const shellHelp = `Enter SQL statements terminated by ";". Statements may span several lines.
+
+.tables         List the tables of the database
+.schema [TABLE] Show the CREATE statements of all tables or of one table
+.mode FORMAT    Show results as table, csv, json or markdown
+.pager on|off   Page long results through $PAGER (default: less -FRX)
+.help           Show this help
+.quit           Leave the shell (or press Ctrl-D)
+`
+
+// sqlShell holds the state of an interactive SQL session
+type sqlShell struct {
+	cli    *CLI
+	output db.SQLOutput
+	pager  bool
+}
+
+// runSQLShell reads SQL statements and dot commands from the terminal until
+// the user quits, showing results in the output format until .mode changes it.
+// The statement history is kept in ~/.file_indexer_sql_history.
+func (c *CLI) runSQLShell(output db.SQLOutput) error {
+	config := &readline.Config{
+		Prompt:                 shellPrompt,
+		HistoryFile:            shellHistoryFile(),
+		DisableAutoSaveHistory: true, // multi-line statements are saved as a whole
+		InterruptPrompt:        "^C",
+		EOFPrompt:              ".quit",
+	}
+	rl, err := readline.NewEx(config)
+	if err != nil {
+		return fmt.Errorf("error starting SQL shell: %v", err)
+	}
+	defer rl.Close()
+
+	shell := &sqlShell{cli: c, output: output, pager: isTerminal(os.Stdout)}
+	fmt.Printf("Connected to %s. Enter .help for help.\n", c.indexPath())
+
+	var statement []string
+	for {
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			// Ctrl-C discards the statement being typed
+			statement = nil
+			rl.SetPrompt(shellPrompt)
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading input: %v", err)
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if len(statement) == 0 {
+			if trimmed == "" {
+				continue
+			}
+			if strings.HasPrefix(trimmed, ".") {
+				rl.SaveHistory(trimmed)
+				if quit := shell.runDotCommand(trimmed); quit {
+					return nil
+				}
+				continue
+			}
+		}
+
+		statement = append(statement, line)
+		if !strings.HasSuffix(trimmed, ";") {
+			rl.SetPrompt(shellContinuationPrompt)
+			continue
+		}
+
+		query := strings.Join(statement, "\n")
+		statement = nil
+		rl.SetPrompt(shellPrompt)
+		rl.SaveHistory(query)
+		shell.execute(query)
+	}
+}
+
+// runDotCommand handles a shell command starting with "." and reports
+// whether the shell should exit
+func (s *sqlShell) runDotCommand(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ".quit", ".exit":
+		return true
+	case ".help":
+		fmt.Print(shellHelp)
+	case ".tables":
+		s.execute("SELECT table_name FROM duckdb_tables() ORDER BY table_name")
+	case ".schema":
+		query := "SELECT sql FROM duckdb_tables()"
+		if len(fields) > 1 {
+			query += " WHERE table_name = '" + strings.ReplaceAll(fields[1], "'", "''") + "'"
+		}
+		s.execute(query + " ORDER BY table_name")
+	case ".mode":
+		if len(fields) != 2 {
+			fmt.Printf("Usage: .mode table|csv|json|markdown (now %s)\n", s.output)
+			break
+		}
+		output, err := db.ParseSQLOutput(fields[1])
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		s.output = output
+	case ".pager":
+		if len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+			fmt.Println("Usage: .pager on|off")
+			break
+		}
+		s.pager = fields[1] == "on"
+	default:
+		fmt.Printf("Unknown command %s. Enter .help for help.\n", fields[0])
+	}
+	return false
+}
+
+// execute runs a statement and shows its result, through the pager if
+// enabled. Ctrl-C cancels the statement but not the shell.
+func (s *sqlShell) execute(query string) {
+	ctx, stop := interruptible()
+	defer stop()
+
+	var output bytes.Buffer
+	if err := s.cli.indexer.ExecuteSQL(ctx, &output, s.output, query); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !s.pager || !showInPager(output.Bytes()) {
+		os.Stdout.Write(output.Bytes())
+	}
+}
+
+// showInPager displays output through $PAGER and reports whether it did. It
+// fails if no pager is available, in which case the caller prints directly.
+func showInPager(output []byte) bool {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -FRX" // quit immediately if the output fits on one screen
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = bytes.NewReader(output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run() == nil
+}
+
+// shellHistoryFile returns where the statement history is kept, or "" to keep
+// no history if there is no home directory
+func shellHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".file_indexer_sql_history")
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
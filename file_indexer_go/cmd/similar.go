@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// defaultImageDistance is the Hamming distance up to which image hashes are
+// similar by default. Resized and recompressed copies of a photo usually
+// differ in a few bits, unrelated photos in about half of the 64.
+const defaultImageDistance = 10
+
+// runSimilarImages handles the similar-images command
+func (c *CLI) runSimilarImages(args []string) error {
+	fs := c.newFlagSet("similar-images")
+	output := addOutputFlag(fs)
+	distance := fs.Int("distance", defaultImageDistance, "Largest number of differing bits (0-64) of the perceptual hashes of similar images")
+	var directories stringList
+	fs.Var(&directories, "dir", "Directory to search (repeatable; default: the whole index)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if *distance < 0 || *distance > 64 {
+		return fmt.Errorf("-distance must be between 0 and 64")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx := context.Background()
+	if len(directories) == 0 {
+		directories = append(directories, "")
+	}
+	groups := []models.SimilarImageGroup{}
+	for _, dir := range directories {
+		dirGroups, err := c.indexer.SimilarImages(ctx, dir, *distance)
+		if err != nil {
+			return fmt.Errorf("error finding similar images: %v", err)
+		}
+		groups = append(groups, dirGroups...)
+	}
+
+	if *output == outputJSON {
+		return writeJSON(groups)
+	}
+	if len(groups) == 0 {
+		fmt.Println("No similar images found (only images indexed with -image-hash are compared)")
+		return nil
+	}
+	var files int
+	for n, group := range groups {
+		files += len(group.Files)
+		fmt.Printf("\n--- Similar Images %d (%d files, %s) ---\n", n+1, len(group.Files), models.FormatSize(group.TotalSize))
+		for idx, file := range group.Files {
+			resolution := "unknown size"
+			if file.ImageWidth > 0 && file.ImageHeight > 0 {
+				resolution = fmt.Sprintf("%dx%d", file.ImageWidth, file.ImageHeight)
+			}
+			fmt.Printf("  [distance %2d] %s (%s, %s)\n", group.Distances[idx], c.location(file), resolution, models.FormatSize(file.FileSize))
+		}
+	}
+	fmt.Println("\n=== SUMMARY ===")
+	fmt.Printf("Groups of similar images: %d\n", len(groups))
+	fmt.Printf("Images in groups: %d\n", files)
+	return nil
+}
+
+// defaultTextSimilarity is the similarity in percent from which text files
+// are reported as edited copies of each other by default
+const defaultTextSimilarity = 90
+
+// runSimilarText handles the similar-text command
+func (c *CLI) runSimilarText(args []string) error {
+	fs := c.newFlagSet("similar-text")
+	output := addOutputFlag(fs)
+	minSimilarity := fs.Float64("min-similarity", defaultTextSimilarity, "Lowest estimated similarity in percent of the text files reported together")
+	var directories stringList
+	fs.Var(&directories, "dir", "Directory to search (repeatable; default: the whole index)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if *minSimilarity <= 0 || *minSimilarity > 100 {
+		return fmt.Errorf("-min-similarity must be above 0 and at most 100")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx := context.Background()
+	if len(directories) == 0 {
+		directories = append(directories, "")
+	}
+	groups := []models.SimilarTextGroup{}
+	for _, dir := range directories {
+		dirGroups, err := c.indexer.SimilarTexts(ctx, dir, *minSimilarity)
+		if err != nil {
+			return fmt.Errorf("error finding similar text files: %v", err)
+		}
+		groups = append(groups, dirGroups...)
+	}
+
+	if *output == outputJSON {
+		return writeJSON(groups)
+	}
+	if len(groups) == 0 {
+		fmt.Println("No similar text files found (only files indexed with -text-hash are compared)")
+		return nil
+	}
+	var files int
+	for n, group := range groups {
+		files += len(group.Files)
+		fmt.Printf("\n--- Similar Text %d (%d files, %s) ---\n", n+1, len(group.Files), models.FormatSize(group.TotalSize))
+		for idx, file := range group.Files {
+			fmt.Printf("  [%5.1f%%] %s (%s, modified %s)\n", group.Similarity[idx], c.location(file), models.FormatSize(file.FileSize),
+				file.ModificationDateTime.Format("2006-01-02 15:04"))
+		}
+	}
+	fmt.Println("\n=== SUMMARY ===")
+	fmt.Printf("Groups of similar text files: %d\n", len(groups))
+	fmt.Printf("Files in groups: %d\n", files)
+	return nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+)
+
+// runSQL handles the sql command
+func (c *CLI) runSQL(args []string) error {
+	fs := c.newFlagSet("sql")
+	var queryArgs stringList
+	fs.Var(&queryArgs, "arg", "Value bound to the next ? placeholder of the query (repeatable, in order)")
+	outputName := fs.String("sql-output", string(db.SQLOutputTable), "Output format of results: table, csv, json or markdown")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	output, err := db.ParseSQLOutput(*outputName)
+	if err != nil {
+		return err
+	}
+	if !c.global.UseDB {
+		return fmt.Errorf("SQL queries are only available in database mode (-db)")
+	}
+	if len(positional) == 0 && len(queryArgs) > 0 {
+		return fmt.Errorf("-arg requires a query")
+	}
+
+	closeIndex, err := c.openIndex(false)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	// Without a query, read statements interactively
+	if len(positional) == 0 {
+		return c.runSQLShell(output)
+	}
+
+	if err := c.indexer.ExecuteSQL(context.Background(), os.Stdout, output, strings.Join(positional, " "), bindArgs(queryArgs)...); err != nil {
+		return fmt.Errorf("error executing SQL: %v", err)
+	}
+	return nil
+}
+
+// bindArgs turns the values of -arg into query arguments. They are bound as
+// strings, which the database casts to the type the placeholder needs.
+func bindArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for n, value := range values {
+		args[n] = value
+	}
+	return args
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// runStats handles the stats command
+func (c *CLI) runStats(args []string) error {
+	fs := c.newFlagSet("stats")
+	refresh := fs.Bool("refresh", false, "Recompute the statistics from the files and store them as a new snapshot (requires -db)")
+	history := fs.Bool("history", false, "List a figure of every statistics snapshot, oldest first, for charting (requires -db)")
+	series := fs.String("series", indexer.StatsTotal, "Figure listed by -history: KIND or KIND:NAME, with KIND one of "+strings.Join(indexer.StatsKinds, ", ")+" (e.g. extension:.jpg, directory:/data/photos)")
+	output := addOutputFlag(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	kind, name, _ := strings.Cut(*series, ":")
+	if !slices.Contains(indexer.StatsKinds, kind) {
+		return fmt.Errorf("unknown -series kind %q; use one of %s", kind, strings.Join(indexer.StatsKinds, ", "))
+	}
+	if *refresh && *history {
+		return fmt.Errorf("-refresh and -history cannot be combined")
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	ctx := context.Background()
+	if *history {
+		entries, err := c.indexer.StatsHistory(ctx, kind, name)
+		if err != nil {
+			return fmt.Errorf("error getting statistics history: %v", err)
+		}
+		if *output == outputJSON {
+			return writeJSON(entries)
+		}
+		printStatsHistory(entries, *series)
+		return nil
+	}
+
+	var stats map[string]interface{}
+	if *refresh {
+		stats, err = c.indexer.RefreshStats(ctx)
+	} else {
+		stats, err = c.indexer.GetStats(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting statistics: %v", err)
+	}
+	if *output == outputJSON {
+		return writeJSON(stats)
+	}
+
+	fmt.Println("Index Statistics:")
+	fmt.Println("=================")
+	if takenAt, ok := stats["stats_time"].(time.Time); ok {
+		fmt.Printf("Statistics as of: %s (stats -refresh recomputes them)\n", takenAt.Format(time.RFC3339))
+	}
+	fmt.Printf("Total files: %v\n", stats["total_files"])
+	fmt.Printf("Total size: %v bytes\n", stats["total_size"])
+	fmt.Printf("Indexed time: %v\n", stats["indexed_time"])
+	fmt.Printf("Root path: %v\n", stats["root_path"])
+	if host, ok := stats["host"].(string); ok && host != "" {
+		fmt.Printf("Scanned on: %s\n", host)
+	}
+	if label, ok := stats["label"].(string); ok && label != "" {
+		fmt.Printf("Label: %s\n", label)
+	}
+	if hashAlgorithm, ok := stats["hash_algorithm"]; ok {
+		fmt.Printf("Hash algorithm: %v\n", hashAlgorithm)
+	}
+	if sampleAlgorithm, ok := stats["sample_hash_algorithm"]; ok {
+		fmt.Printf("Sampled hashes: %v files without a full checksum (%v)\n", stats["sampled_files"], sampleAlgorithm)
+	}
+	if scan, ok := stats["interrupted_scan"].(models.InterruptedScan); ok {
+		fmt.Printf("Last scan interrupted: %s at %s (run index again to complete it)\n",
+			scan.Root, scan.InterruptedAt.Format(time.RFC3339))
+	}
+	if roots, ok := stats["roots"].([]models.RootInfo); ok && len(roots) > 0 {
+		fmt.Println("\nRoots:")
+		for _, root := range roots {
+			fmt.Printf("  %s: %d files, %s", root.Path, root.FileCount, models.FormatSize(root.TotalSize))
+			if !root.IndexedAt.IsZero() {
+				fmt.Printf(", indexed %s", root.IndexedAt.Format(time.RFC3339))
+			}
+			fmt.Println()
+		}
+	}
+
+	if dirs, ok := stats["directories"].([]models.DirectoryUsage); ok && len(dirs) > 0 {
+		fmt.Println("\nTop-level directories:")
+		for _, dir := range dirs {
+			fmt.Printf("  %s: %d files, %s\n", dir.Path, dir.FileCount, models.FormatSize(dir.TotalSize))
+		}
+	}
+
+	if fileTypes, ok := stats["file_types"].(map[string]int); ok {
+		fmt.Println("\nFile types:")
+		for ext, count := range fileTypes {
+			if ext == "" {
+				fmt.Printf("  No extension: %d\n", count)
+			} else {
+				fmt.Printf("  %s: %d\n", ext, count)
+			}
+		}
+	}
+
+	if mimeTypes, ok := stats["mime_types"].(map[string]int); ok {
+		fmt.Println("\nMIME types:")
+		for mimeType, count := range mimeTypes {
+			fmt.Printf("  %s: %d\n", mimeType, count)
+		}
+	}
+
+	if histogram, ok := stats["size_histogram"].([]models.SizeBucket); ok {
+		fmt.Println("\nFile sizes:")
+		printSizeHistogram(histogram)
+	}
+
+	if duplicates, ok := stats["duplicates"].(models.DuplicateSummary); ok {
+		fmt.Println("\nDuplicates:")
+		fmt.Printf("  Duplicate groups: %d\n", duplicates.GroupCount)
+		fmt.Printf("  Files in duplicate groups: %d\n", duplicates.DuplicateFiles)
+		fmt.Printf("  Reclaimable space: %s\n", models.FormatSize(duplicates.ReclaimableSpace))
+		if len(duplicates.TopGroups) > 0 {
+			fmt.Printf("  Top %d groups by wasted space:\n", len(duplicates.TopGroups))
+			for n, group := range duplicates.TopGroups {
+				fmt.Printf("  %2d. %s wasted by %d copies of %s (%s)\n", n+1, models.FormatSize(group.WastedSpace),
+					group.Copies, c.location(group.Files[0]), models.FormatSize(group.FileSize))
+			}
+		}
+	}
+	return nil
+}
+
+// printStatsHistory lists a figure of every statistics snapshot
+func printStatsHistory(entries []models.StatsEntry, series string) {
+	if len(entries) == 0 {
+		fmt.Printf("No statistics snapshots have %s; they are taken at the end of every scan\n", series)
+		return
+	}
+	fmt.Printf("Statistics history of %s:\n", series)
+	for _, entry := range entries {
+		fmt.Printf("  %s  %d files, %s\n", entry.TakenAt.Format(time.RFC3339), entry.FileCount, models.FormatSize(entry.TotalSize))
+	}
+}
+
+// histogramWidth is the length of the bar of the fullest histogram bucket
+const histogramWidth = 40
+
+// printSizeHistogram prints the size distribution as a bar chart of file
+// counts, with the bytes stored in each bucket
+func printSizeHistogram(histogram []models.SizeBucket) {
+	var maxCount int64
+	for _, bucket := range histogram {
+		maxCount = max(maxCount, bucket.FileCount)
+	}
+
+	for _, bucket := range histogram {
+		bar := 0
+		if maxCount > 0 {
+			bar = int(bucket.FileCount * histogramWidth / maxCount)
+			if bar == 0 && bucket.FileCount > 0 {
+				bar = 1 // keep non-empty buckets visible
+			}
+		}
+		fmt.Printf("  %-14s %-*s %d files, %s\n", bucket.Label, histogramWidth, strings.Repeat("#", bar),
+			bucket.FileCount, models.FormatSize(bucket.TotalSize))
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// syncMarkers are the short labels printed in front of each step of a plan
+var syncMarkers = map[indexer.SyncActionKind]string{
+	indexer.SyncCopy:   "+",
+	indexer.SyncUpdate: "~",
+	indexer.SyncMove:   ">",
+	indexer.SyncExtra:  "-",
+}
+
+// runSyncPlan handles the sync-plan command, which lists what copying a
+// source directory to a target would do, computed from their indexes
+func (c *CLI) runSyncPlan(args []string) error {
+	fs := c.newFlagSet("sync-plan")
+	sourceDir := fs.String("source-dir", "", "Directory of the source index to compare (default: its only root)")
+	targetDir := fs.String("target-dir", "", "Directory of the target index to compare (default: its only root)")
+	output := addOutputFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	if len(positional) != 2 {
+		fs.Usage()
+		return fmt.Errorf("the sync-plan command requires a source and a target index")
+	}
+	for _, path := range positional {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("index not found: %v", err)
+		}
+	}
+
+	source, closeSource, err := openIndexAt(positional[0])
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	target, closeTarget, err := openIndexAt(positional[1])
+	if err != nil {
+		return err
+	}
+	defer closeTarget()
+
+	plan, err := indexer.PlanSync(context.Background(), source, target, *sourceDir, *targetDir)
+	if err != nil {
+		return fmt.Errorf("error planning sync: %v", err)
+	}
+	if *output == outputJSON {
+		if plan.Actions == nil {
+			plan.Actions = []indexer.SyncAction{}
+		}
+		return writeJSON(plan)
+	}
+
+	counts := make(map[indexer.SyncActionKind]int)
+	var transfer int64
+	for _, action := range plan.Actions {
+		counts[action.Action]++
+		switch action.Action {
+		case indexer.SyncCopy:
+			transfer += action.Source.FileSize
+			fmt.Printf("%s %s (%s)\n", syncMarkers[action.Action], action.Path, models.FormatSize(action.Source.FileSize))
+		case indexer.SyncUpdate:
+			transfer += action.Source.FileSize
+			fmt.Printf("%s %s (%s -> %s)\n", syncMarkers[action.Action], action.Path,
+				models.FormatSize(action.Target.FileSize), models.FormatSize(action.Source.FileSize))
+		case indexer.SyncMove:
+			fmt.Printf("%s %s (in the target as %s)\n", syncMarkers[action.Action], action.Path, strings.Join(action.TargetPaths, ", "))
+		default:
+			fmt.Printf("%s %s\n", syncMarkers[action.Action], action.Path)
+		}
+	}
+
+	if len(plan.Actions) > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%s -> %s: %d to copy, %d to update (%s to transfer), %d moved, %d only in the target, %d in sync\n",
+		plan.SourceRoot, plan.TargetRoot, counts[indexer.SyncCopy], counts[indexer.SyncUpdate], models.FormatSize(transfer),
+		counts[indexer.SyncMove], counts[indexer.SyncExtra], plan.InSync)
+	return nil
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// tagListing is a user tag with the indexed files of its checksum
+type tagListing struct {
+	models.FileTag
+	Files []string `json:"files"` // Locations of the files, none if the checksum is not indexed
+}
+
+// runTag handles the tag command, which adds, removes and lists the user
+// tags of indexed files
+func (c *CLI) runTag(args []string) error {
+	fs := c.newFlagSet("tag")
+	output := addOutputFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("the tag command requires an action: add, remove or list")
+	}
+
+	action, rest := positional[0], positional[1:]
+	switch action {
+	case "add", "remove":
+		if len(rest) < 2 {
+			return fmt.Errorf("usage: tag %s TAG PATH...", action)
+		}
+	case "list":
+		if len(rest) > 1 {
+			return fmt.Errorf("usage: tag list [TAG]")
+		}
+	default:
+		return fmt.Errorf("unknown tag action %q (supported: add, remove, list)", action)
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	if action == "list" {
+		return c.listTags(rest, *output)
+	}
+
+	ctx := context.Background()
+	tag, paths := rest[0], rest[1:]
+	for _, path := range paths {
+		if action == "add" {
+			file, err := c.indexer.TagFile(ctx, path, tag)
+			if err != nil {
+				return fmt.Errorf("error tagging file: %v", err)
+			}
+			fmt.Printf("Tagged %s as %s\n", c.location(file), models.NormalizeTag(tag))
+			continue
+		}
+
+		removed, err := c.indexer.UntagFile(ctx, path, tag)
+		if err != nil {
+			return fmt.Errorf("error untagging file: %v", err)
+		}
+		if removed {
+			fmt.Printf("Removed %s from %s\n", models.NormalizeTag(tag), path)
+		} else {
+			fmt.Printf("%s is not tagged as %s\n", path, models.NormalizeTag(tag))
+		}
+	}
+
+	if err := c.indexer.SaveIndex(); err != nil {
+		return fmt.Errorf("error saving index: %v", err)
+	}
+	return nil
+}
+
+// listTags prints the user tags, or those named in filter, with the files
+// they are on
+func (c *CLI) listTags(filter []string, output string) error {
+	ctx := context.Background()
+	tags, err := c.indexer.Tags(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading tags: %v", err)
+	}
+
+	listings := []tagListing{}
+	files := make(map[string][]string) // Locations by checksum, for the current tag
+	current := ""
+	for _, tag := range tags {
+		if len(filter) > 0 && tag.Tag != models.NormalizeTag(filter[0]) {
+			continue
+		}
+		if tag.Tag != current {
+			current = tag.Tag
+			tagged, err := c.indexer.ListFiles(ctx, models.FileQuery{Tag: tag.Tag, Sort: models.SortPath})
+			if err != nil {
+				return fmt.Errorf("error listing tagged files: %v", err)
+			}
+			files = make(map[string][]string)
+			for _, file := range tagged {
+				files[file.Checksum] = append(files[file.Checksum], c.location(file))
+			}
+		}
+		listings = append(listings, tagListing{FileTag: tag, Files: append([]string{}, files[tag.Checksum]...)})
+	}
+
+	if output == outputJSON {
+		return writeJSON(listings)
+	}
+	if len(listings) == 0 {
+		fmt.Println("No tags found.")
+		return nil
+	}
+	current = ""
+	for _, listing := range listings {
+		if listing.Tag != current {
+			current = listing.Tag
+			fmt.Printf("\n%s\n", current)
+		}
+		checksum := listing.Checksum
+		if len(checksum) > 16 {
+			checksum = checksum[:16] + "..."
+		}
+		fmt.Printf("  %s tagged %s\n", checksum, listing.TaggedAt.Local().Format("2006-01-02 15:04:05"))
+		if len(listing.Files) == 0 {
+			fmt.Println("    (no indexed file)")
+		}
+		for _, path := range listing.Files {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+	return nil
+}
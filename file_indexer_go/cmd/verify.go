@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+)
+
+// runVerify handles the verify command
+func (c *CLI) runVerify(args []string) error {
+	fs := c.newFlagSet("verify")
+	percent := fs.Float64("percent", 100, "Percentage of the indexed files to check (e.g. 10)")
+	seed := fs.Int64("seed", 0, "Selects which files -percent samples; use a different seed to check other files")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of concurrent checksum workers")
+	resume := fs.String("resume", "", "File recording verified paths, so an interrupted run continues where it stopped")
+	manifest := fs.String("manifest", "", "Check the files of a checksum manifest, such as one written by md5sum or sha256sum, instead of the index")
+	against := fs.String("against", "files", "What -manifest is checked against: files (re-hash them) or index (the stored checksums)")
+	base := fs.String("base", "", "Directory relative paths of -manifest are resolved against (default: the current directory)")
+	algorithm := fs.String("hash", "", "Checksum algorithm of -manifest (default: guessed from its name or checksums)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *percent <= 0 || *percent > 100 {
+		return fmt.Errorf("-percent must be greater than 0 and at most 100")
+	}
+	if *against != "files" && *against != "index" {
+		return fmt.Errorf("unknown -against %q (supported: files, index)", *against)
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	if *manifest != "" {
+		if *resume != "" || *percent != 100 {
+			return fmt.Errorf("-manifest cannot be combined with -resume or -percent")
+		}
+		opts := indexer.ManifestOptions{Algorithm: *algorithm, Base: *base, Index: *against == "index", Workers: *workers}
+		return c.verifyManifest(*manifest, opts)
+	}
+
+	if err := c.checkHashed(context.Background(), "there is nothing to verify"); err != nil {
+		return err
+	}
+	opts := indexer.VerifyOptions{Workers: *workers, Percent: *percent, Seed: *seed}
+	var state *os.File
+	if *resume != "" {
+		if opts.Done, err = readVerifyState(*resume); err != nil {
+			return err
+		}
+		if len(opts.Done) > 0 {
+			slog.Info("Resuming verification", "checked", len(opts.Done))
+		}
+		state, err = os.OpenFile(*resume, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %v", *resume, err)
+		}
+		defer state.Close()
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	counts := make(map[indexer.VerifyStatus]int)
+	err = c.indexer.Verify(ctx, opts, func(result indexer.VerifyResult) {
+		counts[result.Status]++
+		switch result.Status {
+		case indexer.VerifyOK, indexer.VerifySkipped:
+		case indexer.VerifyFailed:
+			fmt.Printf("[FAILED] %s: %v\n", result.File.Path, result.Err)
+		default:
+			fmt.Printf("[%s] %s\n", strings.ToUpper(string(result.Status)), result.File.Path)
+		}
+
+		if state != nil {
+			if _, err := fmt.Fprintln(state, result.File.Path); err != nil {
+				slog.Error("Error recording progress", "path", *resume, "err", err)
+			}
+		}
+	})
+	if errors.Is(err, context.Canceled) {
+		if state != nil {
+			return fmt.Errorf("verification interrupted; run again with -resume %s to continue", *resume)
+		}
+		return fmt.Errorf("verification interrupted")
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying index: %v", err)
+	}
+
+	fmt.Printf("\nVerified %d files: %d ok, %d corrupted, %d changed, %d missing, %d failed, %d skipped (no checksum or not local)\n",
+		counts[indexer.VerifyOK]+counts[indexer.VerifyCorrupted]+counts[indexer.VerifyChanged],
+		counts[indexer.VerifyOK], counts[indexer.VerifyCorrupted], counts[indexer.VerifyChanged],
+		counts[indexer.VerifyMissing], counts[indexer.VerifyFailed], counts[indexer.VerifySkipped])
+
+	// The run is complete, so the next one starts from scratch
+	if state != nil {
+		state.Close()
+		if err := os.Remove(*resume); err != nil {
+			slog.Error("Error removing progress file", "path", *resume, "err", err)
+		}
+	}
+
+	if problems := counts[indexer.VerifyCorrupted] + counts[indexer.VerifyMissing] + counts[indexer.VerifyFailed]; problems > 0 {
+		return fmt.Errorf("%d files failed verification", problems)
+	}
+	return nil
+}
+
+// verifyManifest checks the files of a checksum manifest and reports those
+// that do not match
+func (c *CLI) verifyManifest(path string, opts indexer.ManifestOptions) error {
+	entries, err := readChecksums(path)
+	if err != nil {
+		return err
+	}
+	if opts.Algorithm == "" {
+		if opts.Algorithm, err = hasher.ManifestAlgorithm(path, entries); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	counts := make(map[indexer.VerifyStatus]int)
+	err = c.indexer.VerifyManifest(ctx, entries, opts, func(result indexer.VerifyResult) {
+		counts[result.Status]++
+		switch result.Status {
+		case indexer.VerifyOK:
+		case indexer.VerifyFailed:
+			fmt.Printf("[FAILED] %s: %v\n", result.File.Path, result.Err)
+		default:
+			fmt.Printf("[%s] %s\n", strings.ToUpper(string(result.Status)), result.File.Path)
+		}
+	})
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("verification interrupted")
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying manifest: %v", err)
+	}
+
+	missing := "missing"
+	if opts.Index {
+		missing = "not indexed"
+	}
+	fmt.Printf("\nChecked %d %s checksums: %d ok, %d mismatched, %d %s, %d failed, %d skipped (indexed without checksum)\n",
+		len(entries), opts.Algorithm, counts[indexer.VerifyOK], counts[indexer.VerifyMismatch], counts[indexer.VerifyMissing],
+		missing, counts[indexer.VerifyFailed], counts[indexer.VerifySkipped])
+	if problems := len(entries) - counts[indexer.VerifyOK] - counts[indexer.VerifySkipped]; problems > 0 {
+		return fmt.Errorf("%d files failed verification", problems)
+	}
+	return nil
+}
+
+// readVerifyState loads the paths recorded by an earlier, interrupted run
+func readVerifyState(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			done[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return done, nil
+}
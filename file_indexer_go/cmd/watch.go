@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+)
+
+// runWatch handles the watch command
+func (c *CLI) runWatch(args []string) error {
+	fs := c.newFlagSet("watch")
+	directory := fs.String("dir", "", "Directory to watch")
+	debounce := fs.Duration("debounce", 2*time.Second, "Quiet period before accumulated changes are applied")
+	scanOptions := addScanFlags(fs)
+	hookOptions := addHookFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *directory == "" {
+		fs.Usage()
+		return fmt.Errorf("the watch command requires -dir")
+	}
+	scan, err := scanOptions()
+	if err != nil {
+		return err
+	}
+	hooks, err := hookOptions(c.indexPath())
+	if err != nil {
+		return err
+	}
+
+	closeIndex, err := c.openIndex(true)
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+
+	// Keep watching until interrupted
+	ctx, stop := interruptible()
+	defer stop()
+
+	// Hooks compare each batch with the index as it was loaded
+	c.checkHooks(ctx, hooks)
+	opts := indexer.WatchOptions{
+		ScanOptions: scan,
+		Debounce:    *debounce,
+		Applied:     func(ctx context.Context) { c.checkHooks(ctx, hooks) },
+	}
+	if err := c.indexer.Watch(ctx, *directory, opts); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("initial scan interrupted; run the command again to complete it")
+		}
+		return fmt.Errorf("error watching directory: %v", err)
+	}
+	return nil
+}
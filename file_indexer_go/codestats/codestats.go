@@ -0,0 +1,237 @@
+// Package codestats classifies source files by programming language and
+// counts their lines of code, comments and blank lines
+package codestats
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// Language is a programming language and how it writes comments
+type Language struct {
+	Name          string
+	LineComments  []string    // markers of comments that run to the end of the line
+	BlockComments [][2]string // start and end markers of comments that may span lines
+}
+
+// Comment styles shared by several languages
+var (
+	cStyle    = Language{LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}}}
+	hashStyle = Language{LineComments: []string{"#"}}
+	markup    = Language{BlockComments: [][2]string{{"<!--", "-->"}}}
+)
+
+// named returns a comment style with a language name
+func named(name string, style Language) Language {
+	style.Name = name
+	return style
+}
+
+// extensions maps lowercase file extensions to their languages
+var extensions = map[string]Language{
+	".go":     named("Go", cStyle),
+	".c":      named("C", cStyle),
+	".h":      named("C", cStyle),
+	".cpp":    named("C++", cStyle),
+	".cc":     named("C++", cStyle),
+	".cxx":    named("C++", cStyle),
+	".hpp":    named("C++", cStyle),
+	".hh":     named("C++", cStyle),
+	".cs":     named("C#", cStyle),
+	".java":   named("Java", cStyle),
+	".kt":     named("Kotlin", cStyle),
+	".kts":    named("Kotlin", cStyle),
+	".scala":  named("Scala", cStyle),
+	".groovy": named("Groovy", cStyle),
+	".js":     named("JavaScript", cStyle),
+	".mjs":    named("JavaScript", cStyle),
+	".cjs":    named("JavaScript", cStyle),
+	".jsx":    named("JavaScript", cStyle),
+	".ts":     named("TypeScript", cStyle),
+	".tsx":    named("TypeScript", cStyle),
+	".swift":  named("Swift", cStyle),
+	".m":      named("Objective-C", cStyle),
+	".mm":     named("Objective-C", cStyle),
+	".rs":     named("Rust", cStyle),
+	".dart":   named("Dart", cStyle),
+	".css":    {Name: "CSS", BlockComments: [][2]string{{"/*", "*/"}}},
+	".scss":   named("SCSS", cStyle),
+	".less":   named("Less", cStyle),
+	".php":    {Name: "PHP", LineComments: []string{"//", "#"}, BlockComments: [][2]string{{"/*", "*/"}}},
+	".py":     {Name: "Python", LineComments: []string{"#"}, BlockComments: [][2]string{{`"""`, `"""`}, {"'''", "'''"}}},
+	".rb":     {Name: "Ruby", LineComments: []string{"#"}, BlockComments: [][2]string{{"=begin", "=end"}}},
+	".pl":     named("Perl", hashStyle),
+	".pm":     named("Perl", hashStyle),
+	".sh":     named("Shell", hashStyle),
+	".bash":   named("Shell", hashStyle),
+	".zsh":    named("Shell", hashStyle),
+	".ps1":    {Name: "PowerShell", LineComments: []string{"#"}, BlockComments: [][2]string{{"<#", "#>"}}},
+	".r":      named("R", hashStyle),
+	".ex":     named("Elixir", hashStyle),
+	".exs":    named("Elixir", hashStyle),
+	".yaml":   named("YAML", hashStyle),
+	".yml":    named("YAML", hashStyle),
+	".toml":   named("TOML", hashStyle),
+	".sql":    {Name: "SQL", LineComments: []string{"--"}, BlockComments: [][2]string{{"/*", "*/"}}},
+	".lua":    {Name: "Lua", LineComments: []string{"--"}, BlockComments: [][2]string{{"--[[", "]]"}}},
+	".hs":     {Name: "Haskell", LineComments: []string{"--"}, BlockComments: [][2]string{{"{-", "-}"}}},
+	".erl":    {Name: "Erlang", LineComments: []string{"%"}},
+	".clj":    {Name: "Clojure", LineComments: []string{";"}},
+	".el":     {Name: "Emacs Lisp", LineComments: []string{";"}},
+	".html":   named("HTML", markup),
+	".htm":    named("HTML", markup),
+	".xml":    named("XML", markup),
+	".vue":    {Name: "Vue", LineComments: []string{"//"}, BlockComments: [][2]string{{"<!--", "-->"}, {"/*", "*/"}}},
+}
+
+// filenames maps the names of build files without an extension to their
+// languages
+var filenames = map[string]Language{
+	"makefile":       named("Makefile", hashStyle),
+	"gnumakefile":    named("Makefile", hashStyle),
+	"dockerfile":     named("Dockerfile", hashStyle),
+	"cmakelists.txt": named("CMake", hashStyle),
+	"rakefile":       {Name: "Ruby", LineComments: []string{"#"}, BlockComments: [][2]string{{"=begin", "=end"}}},
+	"gemfile":        {Name: "Ruby", LineComments: []string{"#"}, BlockComments: [][2]string{{"=begin", "=end"}}},
+}
+
+// Detect returns the language of a file by its name, such as Go for
+// main.go or Makefile for Makefile, and false for files that are not
+// recognized as source code
+func Detect(filename string) (Language, bool) {
+	base := strings.ToLower(filepath.Base(filename))
+	if language, ok := filenames[base]; ok {
+		return language, true
+	}
+	language, ok := extensions[filepath.Ext(base)]
+	return language, ok
+}
+
+// Counts are the lines of a source file. Lines with code and a comment
+// count as code; Blank is Lines - Code - Comments.
+type Counts struct {
+	Lines    int
+	Code     int
+	Comments int
+}
+
+// Count counts the lines of a source file in a language. Comment markers
+// inside string literals are not told apart from real comments, so the
+// counts are close estimates, like those of cloc.
+func Count(r io.Reader, language Language) (Counts, error) {
+	var counts Counts
+	reader := bufio.NewReader(r)
+	blockEnd := "" // end marker of the block comment the line starts in
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			counts.Lines++
+			var code, comment bool
+			code, comment, blockEnd = classify(line, language, blockEnd)
+			switch {
+			case code:
+				counts.Code++
+			case comment:
+				counts.Comments++
+			}
+		}
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return counts, err
+		}
+	}
+}
+
+// CountFile counts the lines of a source file of a filesystem
+func CountFile(fsys source.FS, path string, language Language) (Counts, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return Counts{}, err
+	}
+	defer file.Close()
+	return Count(file, language)
+}
+
+// classify reports whether a line has code and comments, given the end
+// marker of the block comment it starts in, if any, and returns the end
+// marker of the block comment it leaves open
+func classify(line string, language Language, blockEnd string) (code, comment bool, openEnd string) {
+	rest := line
+	for {
+		if blockEnd != "" {
+			comment = true
+			n := strings.Index(rest, blockEnd)
+			if n < 0 {
+				return code, comment, blockEnd
+			}
+			rest, blockEnd = rest[n+len(blockEnd):], ""
+		}
+
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			return code, comment, ""
+		}
+		// Block comments go first, as Lua's --[[ starts like its line comments
+		if start, end, ok := blockStart(rest, language); ok {
+			rest, blockEnd = rest[len(start):], end
+			continue
+		}
+		if hasAnyPrefix(rest, language.LineComments) {
+			return code, true, ""
+		}
+
+		// Code runs up to the first comment that follows it on the line
+		code = true
+		next, lineComment := nextComment(rest, language)
+		if next < 0 {
+			return code, comment, ""
+		}
+		if lineComment {
+			return code, true, ""
+		}
+		rest = rest[next:]
+	}
+}
+
+// hasAnyPrefix reports whether s starts with one of prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockStart returns the markers of the block comment s starts with
+func blockStart(s string, language Language) (start, end string, ok bool) {
+	for _, markers := range language.BlockComments {
+		if strings.HasPrefix(s, markers[0]) {
+			return markers[0], markers[1], true
+		}
+	}
+	return "", "", false
+}
+
+// nextComment returns the position of the first comment marker in s, or -1,
+// and whether it starts a line comment
+func nextComment(s string, language Language) (int, bool) {
+	first, lineComment := -1, false
+	for _, marker := range language.LineComments {
+		if n := strings.Index(s, marker); n >= 0 && (first < 0 || n < first) {
+			first, lineComment = n, true
+		}
+	}
+	for _, markers := range language.BlockComments {
+		if n := strings.Index(s, markers[0]); n >= 0 && (first < 0 || n <= first) {
+			first, lineComment = n, false
+		}
+	}
+	return first, lineComment
+}
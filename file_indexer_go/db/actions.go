@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// actionTablesSQL creates the audit log of dedupe, with one row per
+// duplicate that was deleted or replaced with a link. The run and trash
+// columns are added by migrations.
+const actionTablesSQL = `
+	CREATE TABLE IF NOT EXISTS actions (
+		performed_at TIMESTAMP NOT NULL,
+		action VARCHAR NOT NULL,
+		path VARCHAR NOT NULL,
+		original VARCHAR NOT NULL,
+		checksum VARCHAR NOT NULL,
+		file_size BIGINT NOT NULL,
+		host VARCHAR
+	);
+`
+
+// RecordAction appends an entry to the audit log of dedupe, under the host
+// of the record
+func (d *Database) RecordAction(ctx context.Context, record models.ActionRecord) error {
+	_, err := d.exec(ctx, "INSERT INTO actions (performed_at, action, path, original, checksum, file_size, host, run_id, trash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		record.PerformedAt, record.Action, record.Path, record.Original, record.Checksum, record.FileSize, nullIfEmpty(record.Host),
+		nullIfEmpty(record.RunID), nullIfEmpty(record.Trash))
+	if err != nil {
+		return fmt.Errorf("error recording %s of %s: %v", record.Action, record.Path, err)
+	}
+	return nil
+}
+
+// ListActions returns the audit log of dedupe on the host, oldest first
+func (d *Database) ListActions(ctx context.Context) ([]models.ActionRecord, error) {
+	condition, args := d.hostScope("", nil)
+	rows, err := d.query(ctx, "SELECT performed_at, action, path, original, checksum, file_size, COALESCE(host, ''), "+
+		"COALESCE(run_id, ''), COALESCE(trash, '') FROM actions"+
+		whereCondition(condition)+" ORDER BY performed_at, path", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing actions: %v", err)
+	}
+	defer rows.Close()
+
+	var records []models.ActionRecord
+	for rows.Next() {
+		var record models.ActionRecord
+		err := rows.Scan(&record.PerformedAt, &record.Action, &record.Path, &record.Original, &record.Checksum,
+			&record.FileSize, &record.Host, &record.RunID, &record.Trash)
+		if err != nil {
+			return nil, fmt.Errorf("error reading actions: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
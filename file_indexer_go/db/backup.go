@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Export writes a copy of the database to the empty directory dir with
+// DuckDB's EXPORT DATABASE: a Parquet file per table and the SQL that
+// recreates them. The copy is of one transaction, so it is consistent even
+// while a scan of the same process writes to the database.
+func (d *Database) Export(ctx context.Context, dir string) error {
+	if d.postgres {
+		return fmt.Errorf("PostgreSQL indexes are backed up with pg_dump")
+	}
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The export would include the temporary staging table of bulk inserts
+	// that ran on this connection, which the import cannot recreate
+	if _, err := conn.ExecContext(ctx, "DROP TABLE IF EXISTS temp.files_staging"); err != nil {
+		return fmt.Errorf("error exporting database: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "EXPORT DATABASE "+quoteLiteral(dir)+" (FORMAT PARQUET)"); err != nil {
+		return fmt.Errorf("error exporting database: %v", err)
+	}
+	return nil
+}
+
+// ImportDatabase creates the DuckDB database at path, which must not exist,
+// from a directory written by Export
+func ImportDatabase(ctx context.Context, path, dir string) error {
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		return fmt.Errorf("error creating database: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "IMPORT DATABASE "+quoteLiteral(dir)); err != nil {
+		db.Close()
+		return fmt.Errorf("error importing database: %v", err)
+	}
+	return db.Close()
+}
+
+// quoteLiteral quotes a string as an SQL literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
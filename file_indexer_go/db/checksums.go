@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// checksumTablesSQL creates the table of the checksums of other algorithms
+// than the index's, with one row per algorithm of a file. The checksum of
+// the index's algorithm stays in the checksum column of files.
+const checksumTablesSQL = `
+	CREATE TABLE IF NOT EXISTS file_checksums (
+		path VARCHAR NOT NULL,
+		algorithm VARCHAR NOT NULL,
+		checksum VARCHAR NOT NULL,
+		host VARCHAR
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_file_checksums_path ON file_checksums(path);
+	CREATE INDEX IF NOT EXISTS idx_file_checksums_checksum ON file_checksums(algorithm, checksum);
+`
+
+// replaceChecksums replaces the stored checksums of other algorithms of
+// files whose content was read. Files whose Checksums are nil keep their
+// stored checksums. It returns the error of the driver.
+func (d *Database) replaceChecksums(ctx context.Context, q execer, files []models.FileInfo) error {
+	pathsByHost := make(map[string][]interface{})
+	var rows [][]interface{}
+	for _, file := range files {
+		if file.Checksums == nil {
+			continue
+		}
+		host := d.fileHost(file)
+		pathsByHost[host] = append(pathsByHost[host], file.Path)
+		for algorithm, checksum := range file.Checksums {
+			rows = append(rows, []interface{}{file.Path, algorithm, checksum, nullIfEmpty(host)})
+		}
+	}
+
+	for host, paths := range pathsByHost {
+		for start := 0; start < len(paths); start += xattrBatchRows {
+			batch := paths[start:min(start+xattrBatchRows, len(paths))]
+			deleteSQL := "DELETE FROM file_checksums WHERE host IS NOT DISTINCT FROM ? AND path IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+			if _, err := q.ExecContext(ctx, d.rebind(deleteSQL), append([]interface{}{nullIfEmpty(host)}, batch...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	for start := 0; start < len(rows); start += xattrBatchRows {
+		batch := rows[start:min(start+xattrBatchRows, len(rows))]
+		values := make([]string, len(batch))
+		var args []interface{}
+		for n, row := range batch {
+			values[n] = "(?, ?, ?, ?)"
+			args = append(args, row...)
+		}
+		insertSQL := "INSERT INTO file_checksums (path, algorithm, checksum, host) VALUES " + strings.Join(values, ", ")
+		if _, err := q.ExecContext(ctx, d.rebind(insertSQL), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileChecksums returns the stored checksums of other algorithms than the
+// index's, keyed by location (see models.FileInfo.Location) and algorithm
+func (d *Database) FileChecksums(ctx context.Context) (map[string]map[string]string, error) {
+	condition, args := d.hostScope("", nil)
+	rows, err := d.query(ctx, "SELECT host, path, algorithm, checksum FROM file_checksums"+whereCondition(condition), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checksums: %v", err)
+	}
+	defer rows.Close()
+
+	checksums := make(map[string]map[string]string)
+	for rows.Next() {
+		var host sql.NullString
+		var path, algorithm, checksum string
+		if err := rows.Scan(&host, &path, &algorithm, &checksum); err != nil {
+			return nil, fmt.Errorf("error reading checksums: %v", err)
+		}
+		key := location(host, path)
+		if checksums[key] == nil {
+			checksums[key] = make(map[string]string)
+		}
+		checksums[key][algorithm] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+// UpdateChecksums stores the checksums of files re-read outside of a scan,
+// along with their size, modification time and indexing time, leaving their
+// other columns alone. Files whose Checksums are not nil also get their
+// checksums of other algorithms replaced.
+func (d *Database) UpdateChecksums(ctx context.Context, files []models.FileInfo) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	condition, hostArgs := d.hostScope("path = ?", nil)
+	stmt, err := tx.PrepareContext(ctx, d.rebind("UPDATE files SET checksum = ?, file_size = ?, modification_datetime = ?, indexed_at = ?"+whereCondition(condition)))
+	if err != nil {
+		return fmt.Errorf("error preparing update: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, file := range files {
+		args := append([]interface{}{nullIfEmpty(file.Checksum), file.FileSize, file.ModificationDateTime, file.IndexedAt, file.Path}, hostArgs...)
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("error updating checksum of %s: %v", file.Path, err)
+		}
+	}
+	if err := d.replaceChecksums(ctx, tx, files); err != nil {
+		return fmt.Errorf("error storing checksums: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing checksums: %v", err)
+	}
+	return nil
+}
+
+// PromoteChecksums makes the stored checksums of the algorithm to those of
+// the files of the host, keeping their current checksums as those of the
+// algorithm from. Files without a checksum of to are left without one, so
+// the next scan hashes them. It returns the number of files that have a
+// checksum afterwards.
+func (d *Database) PromoteChecksums(ctx context.Context, from, to string) (int64, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	condition, args := d.hostScope("algorithm = ?", []interface{}{from})
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM file_checksums"+whereCondition(condition)), args...); err != nil {
+		return 0, fmt.Errorf("error keeping %s checksums: %v", from, err)
+	}
+	condition, args = d.hostScope("checksum IS NOT NULL AND checksum <> ''", []interface{}{from})
+	if _, err := tx.ExecContext(ctx, d.rebind("INSERT INTO file_checksums (path, algorithm, checksum, host) SELECT path, ?, checksum, host FROM files"+whereCondition(condition)), args...); err != nil {
+		return 0, fmt.Errorf("error keeping %s checksums: %v", from, err)
+	}
+
+	condition, args = d.hostScope("", []interface{}{to})
+	if _, err := tx.ExecContext(ctx, d.rebind(`
+		UPDATE files SET checksum = (
+			SELECT c.checksum FROM file_checksums c
+			WHERE c.path = files.path AND c.host IS NOT DISTINCT FROM files.host AND c.algorithm = ?
+		)`+whereCondition(condition)), args...); err != nil {
+		return 0, fmt.Errorf("error promoting %s checksums: %v", to, err)
+	}
+	condition, args = d.hostScope("algorithm = ?", []interface{}{to})
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM file_checksums"+whereCondition(condition)), args...); err != nil {
+		return 0, fmt.Errorf("error promoting %s checksums: %v", to, err)
+	}
+
+	var promoted int64
+	condition, args = d.hostScope("checksum IS NOT NULL", nil)
+	if err := tx.QueryRowContext(ctx, d.rebind("SELECT COUNT(*) FROM files"+whereCondition(condition)), args...).Scan(&promoted); err != nil {
+		return 0, fmt.Errorf("error counting promoted checksums: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing checksums: %v", err)
+	}
+	return promoted, nil
+}
@@ -1,36 +1,223 @@
+// Package db stores a file index in DuckDB or PostgreSQL. It is the
+// database backend of package indexer, which most callers should use
+// instead.
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"log"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
-	"file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
 
-	_ "github.com/marcboeker/go-duckdb/v2"
+	"github.com/marcboeker/go-duckdb/v2"
 )
 
-// Database handles all database operations
+// migrations upgrade databases created before a column or index existed.
+// They must be idempotent, as they run every time a database is opened, and
+// are only ever appended to: their number is the schema version.
+var migrations = []string{
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS quick_hash VARCHAR",
+	"CREATE INDEX IF NOT EXISTS idx_files_quick_hash ON files(quick_hash)",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS link_target VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS device UBIGINT",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS inode UBIGINT",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS mime_type VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS content VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS taken_at TIMESTAMP",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS camera_model VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS image_width INTEGER",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS image_height INTEGER",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS container VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS duration_seconds DOUBLE",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS video_width INTEGER",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS video_height INTEGER",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS video_codec VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS audio_codec VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS source VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS host VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS uid BIGINT",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS gid BIGINT",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS user_name VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS group_name VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS mode INTEGER",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS attributes VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS finder_tags VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS image_hash VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS text_hash VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS link_count UBIGINT",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS label VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS camera_serial VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS sample_hash VARCHAR",
+	"CREATE INDEX IF NOT EXISTS idx_files_sample_hash ON files(sample_hash)",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS language VARCHAR",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS line_count INTEGER",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS code_lines INTEGER",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS comment_lines INTEGER",
+	"ALTER TABLE roots ADD COLUMN IF NOT EXISTS host VARCHAR",
+	"ALTER TABLE scans ADD COLUMN IF NOT EXISTS host VARCHAR",
+	"ALTER TABLE actions ADD COLUMN IF NOT EXISTS run_id VARCHAR",
+	"ALTER TABLE actions ADD COLUMN IF NOT EXISTS trash VARCHAR",
+}
+
+// tagSeparator separates the Finder tags of a file in the finder_tags column
+const tagSeparator = "\n"
+
+// fileColumns lists the files table columns in the order scanFile expects.
+// File contents are left out, so listing files does not load their bodies.
+var fileColumns = []string{
+	"path", "filename", "checksum", "modification_datetime", "file_size", "indexed_at", "quick_hash", "sample_hash",
+	"link_target", "device", "inode", "mime_type",
+	"taken_at", "camera_model", "camera_serial", "image_width", "image_height",
+	"container", "duration_seconds", "video_width", "video_height", "video_codec", "audio_codec",
+	"source", "host",
+	"uid", "gid", "user_name", "group_name", "mode", "attributes", "finder_tags",
+	"image_hash", "text_hash", "link_count", "label",
+	"language", "line_count", "code_lines", "comment_lines",
+}
+
+// storedColumns lists the columns written for a file, in insertFileArgs order
+var storedColumns = append(append([]string{}, fileColumns...), "content")
+
+// selectColumns returns the file columns for a SELECT list, optionally
+// qualified with a table alias
+func selectColumns(alias string) string {
+	if alias == "" {
+		return strings.Join(fileColumns, ", ")
+	}
+	qualified := make([]string, len(fileColumns))
+	for i, column := range fileColumns {
+		qualified[i] = alias + "." + column
+	}
+	return strings.Join(qualified, ", ")
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanFile reads a row selected with selectColumns into a FileInfo
+func scanFile(row rowScanner) (models.FileInfo, error) {
+	var file models.FileInfo
+	var checksum, quickHash, sampleHash, linkTarget, mimeType, cameraModel, cameraSerial sql.NullString
+	var container, videoCodec, audioCodec, source, host sql.NullString
+	var device, inode, linkCount sql.Null[uint64]
+	var uid, gid sql.Null[int64]
+	var userName, groupName, attributes, finderTags, imageHash, textHash, label, language sql.NullString
+	var lineCount, codeLines, commentLines sql.NullInt64
+	var mode sql.NullInt64
+	var takenAt sql.NullTime
+	var imageWidth, imageHeight, videoWidth, videoHeight sql.NullInt64
+	var duration sql.NullFloat64
+	err := row.Scan(&file.Path, &file.Filename, &checksum, &file.ModificationDateTime, &file.FileSize, &file.IndexedAt, &quickHash, &sampleHash,
+		&linkTarget, &device, &inode, &mimeType,
+		&takenAt, &cameraModel, &cameraSerial, &imageWidth, &imageHeight,
+		&container, &duration, &videoWidth, &videoHeight, &videoCodec, &audioCodec,
+		&source, &host,
+		&uid, &gid, &userName, &groupName, &mode, &attributes, &finderTags,
+		&imageHash, &textHash, &linkCount, &label,
+		&language, &lineCount, &codeLines, &commentLines)
+	if err != nil {
+		return file, err
+	}
+
+	// Handle nullable columns
+	file.Checksum = checksum.String
+	file.QuickHash = quickHash.String
+	file.SampleHash = sampleHash.String
+	file.LinkTarget = linkTarget.String
+	file.Device = device.V
+	file.Inode = inode.V
+	file.LinkCount = linkCount.V
+	file.MimeType = mimeType.String
+	file.TakenAt = takenAt.Time
+	file.CameraModel = cameraModel.String
+	file.CameraSerial = cameraSerial.String
+	file.ImageWidth = int(imageWidth.Int64)
+	file.ImageHeight = int(imageHeight.Int64)
+	file.ImageHash = imageHash.String
+	file.TextHash = textHash.String
+	file.Container = container.String
+	file.DurationSeconds = duration.Float64
+	file.VideoWidth = int(videoWidth.Int64)
+	file.VideoHeight = int(videoHeight.Int64)
+	file.VideoCodec = videoCodec.String
+	file.AudioCodec = audioCodec.String
+	file.Source = source.String
+	file.Host = host.String
+	file.Label = label.String
+	file.UID = nullableID(uid)
+	file.GID = nullableID(gid)
+	file.UserName = userName.String
+	file.GroupName = groupName.String
+	file.Mode = uint32(mode.Int64)
+	file.Attributes = attributes.String
+	file.Language = language.String
+	file.LineCount = int(lineCount.Int64)
+	file.CodeLines = int(codeLines.Int64)
+	file.CommentLines = int(commentLines.Int64)
+	if finderTags.Valid {
+		file.FinderTags = strings.Split(finderTags.String, tagSeparator)
+	}
+	return file, nil
+}
+
+// Database handles all database operations. Its SQL is written for DuckDB
+// and adapted to PostgreSQL where the two differ.
 type Database struct {
-	db *sql.DB
+	db       *sql.DB
+	driver   string
+	postgres bool
+	host     string // machine whose files are written to an index shared by several
+	readOnly bool
+	tuning   Tuning
+	fullText bool // the fts extension is loaded
+
+	// DuckDB files created since merges keep the files of several hosts key
+	// them by host; files without one are stored for localHost
+	localHost string
+	hostKeyed bool
 }
 
-// NewDatabase creates a new database instance
+// NewDatabase creates a new DuckDB database instance
 func NewDatabase() *Database {
-	return &Database{}
+	return &Database{driver: "duckdb"}
 }
 
-// Init initializes the DuckDB database and creates tables
-func (d *Database) Init(dbPath string) error {
+// Init opens the database and creates tables. Read-only databases are
+// opened as they are, without creating or migrating tables.
+func (d *Database) Init(ctx context.Context, dbPath string) error {
 	var err error
-	d.db, err = sql.Open("duckdb", dbPath)
+	if d.readOnly {
+		d.db, err = sql.Open(d.driver, d.dsn(dbPath))
+		if err == nil {
+			err = d.db.PingContext(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("error opening database read-only: %v", err)
+		}
+		d.loadFullText(ctx)
+		return d.loadFileKey(ctx)
+	}
+
+	d.db, err = sql.Open(d.driver, d.dsn(dbPath))
 	if err != nil {
 		return fmt.Errorf("error opening database: %v", err)
 	}
+	if err := d.loadFileKey(ctx); err != nil {
+		return err
+	}
 
-	// No special extensions needed for this schema
+	// Ranked content search queries the full-text index of the fts extension
+	d.loadFullText(ctx)
 
 	// Create tables
 	createTablesSQL := `
@@ -41,25 +228,191 @@ func (d *Database) Init(dbPath string) error {
 		modification_datetime TIMESTAMP NOT NULL,
 		file_size BIGINT NOT NULL,
 		indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		PRIMARY KEY (path, filename)
+		quick_hash VARCHAR,
+		sample_hash VARCHAR,
+		link_target VARCHAR,
+		device UBIGINT,
+		inode UBIGINT,
+		mime_type VARCHAR,
+		content VARCHAR,
+		taken_at TIMESTAMP,
+		camera_model VARCHAR,
+		camera_serial VARCHAR,
+		image_width INTEGER,
+		image_height INTEGER,
+		container VARCHAR,
+		duration_seconds DOUBLE,
+		video_width INTEGER,
+		video_height INTEGER,
+		video_codec VARCHAR,
+		audio_codec VARCHAR,
+		source VARCHAR,
+		host VARCHAR,
+		uid BIGINT,
+		gid BIGINT,
+		user_name VARCHAR,
+		group_name VARCHAR,
+		mode INTEGER,
+		attributes VARCHAR,
+		finder_tags VARCHAR,
+		image_hash VARCHAR,
+		text_hash VARCHAR,
+		link_count UBIGINT,
+		label VARCHAR,
+		language VARCHAR,
+		line_count INTEGER,
+		code_lines INTEGER,
+		comment_lines INTEGER,
+		PRIMARY KEY (` + d.fileKey() + `)
 	);
 	
 	CREATE TABLE IF NOT EXISTS index_metadata (
 		key VARCHAR PRIMARY KEY,
 		value VARCHAR
 	);
+
+	CREATE TABLE IF NOT EXISTS roots (
+		path VARCHAR NOT NULL,
+		indexed_at TIMESTAMP NOT NULL,
+		file_count BIGINT NOT NULL,
+		total_size BIGINT NOT NULL,
+		host VARCHAR,
+		PRIMARY KEY (` + d.rootKey() + `)
+	);
 	
 	CREATE INDEX IF NOT EXISTS idx_files_filename ON files(filename);
 	CREATE INDEX IF NOT EXISTS idx_files_checksum ON files(checksum);
 	`
 
-	_, err = d.db.Exec(createTablesSQL)
+	_, err = d.db.ExecContext(ctx, d.schema(createTablesSQL))
 	if err != nil {
 		return fmt.Errorf("error creating tables: %v", err)
 	}
 
-	log.Printf("Database initialized: %s", dbPath)
-	return nil
+	_, err = d.db.ExecContext(ctx, d.schema(historyTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating history tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(xattrTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating extended attribute tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(checksumTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating checksum tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(secretTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating secret tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(actionTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating action tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(tagTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating tag tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(deletionTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating deletion tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(hashSetTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating hash set tables: %v", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, d.schema(statsTablesSQL))
+	if err != nil {
+		return fmt.Errorf("error creating statistics tables: %v", err)
+	}
+
+	// Bring databases created by older versions up to the current schema
+	for _, migration := range migrations {
+		if _, err := d.db.ExecContext(ctx, d.schema(migration)); err != nil {
+			return fmt.Errorf("error migrating schema: %v", err)
+		}
+	}
+
+	// Views read the migrated columns
+	_, err = d.db.ExecContext(ctx, d.schema(viewsSQL))
+	if err != nil {
+		return fmt.Errorf("error creating views: %v", err)
+	}
+	return d.recordSchemaVersion(ctx)
+}
+
+// SchemaVersion returns the version of the schema Init brings databases to
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// recordSchemaVersion stores the schema version as schema_version, unless a
+// newer version of the program recorded a later one
+func (d *Database) recordSchemaVersion(ctx context.Context) error {
+	stored, _, err := d.GetMetadata(ctx, "schema_version")
+	if err != nil {
+		return err
+	}
+	if version, err := strconv.Atoi(stored); err == nil && version >= SchemaVersion() {
+		return nil
+	}
+	return d.SetMetadata(ctx, "schema_version", strconv.Itoa(SchemaVersion()))
+}
+
+// exec runs a statement written with ? placeholders
+func (d *Database) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.db.ExecContext(ctx, d.rebind(query), args...)
+}
+
+// query runs a query written with ? placeholders
+func (d *Database) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db.QueryContext(ctx, d.rebind(query), args...)
+}
+
+// queryRow runs a single-row query written with ? placeholders
+func (d *Database) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.db.QueryRowContext(ctx, d.rebind(query), args...)
+}
+
+// hostScope restricts a condition on files, roots or scans to the rows of
+// the database's host in an index shared by several hosts. An empty
+// condition matches all rows of the host.
+func (d *Database) hostScope(condition string, args []interface{}) (string, []interface{}) {
+	if d.host == "" {
+		return condition, args
+	}
+	args = append(args, d.host)
+	if condition == "" {
+		return "host = ?", args
+	}
+	return "(" + condition + ") AND host = ?", args
+}
+
+// whereCondition returns a WHERE clause for a condition, or nothing for an
+// empty one
+func whereCondition(condition string) string {
+	if condition == "" {
+		return ""
+	}
+	return " WHERE " + condition
+}
+
+// metadataKey returns the stored key of a metadata entry. In an index shared
+// by several hosts, entries describe the scans of one host, except for the
+// checksum algorithm, which all of them must use, and the schema version.
+func (d *Database) metadataKey(key string) string {
+	if d.host == "" || key == "hash_algorithm" || key == "schema_version" {
+		return key
+	}
+	return d.host + ":" + key
 }
 
 // Close closes the database connection
@@ -70,121 +423,884 @@ func (d *Database) Close() error {
 	return nil
 }
 
-// ClearData clears all existing data from the database
-func (d *Database) ClearData() error {
-	_, err := d.db.Exec("DELETE FROM files")
+// ClearData clears all existing data from the database. The scan history
+// is kept, so the scans after a checksum migration show up as modifications.
+func (d *Database) ClearData(ctx context.Context) error {
+	condition, args := d.hostScope("", nil)
+	_, err := d.exec(ctx, "DELETE FROM files"+whereCondition(condition), args...)
 	if err != nil {
 		return fmt.Errorf("error clearing existing data: %v", err)
 	}
+	_, err = d.exec(ctx, "DELETE FROM file_xattrs"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error clearing extended attributes: %v", err)
+	}
+	_, err = d.exec(ctx, "DELETE FROM file_checksums"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error clearing checksums: %v", err)
+	}
+	_, err = d.exec(ctx, "DELETE FROM file_secrets"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error clearing secrets: %v", err)
+	}
 
-	_, err = d.db.Exec("DELETE FROM index_metadata")
+	condition, args = "key <> 'schema_version'", nil
+	if d.host != "" {
+		condition, args = "key = 'hash_algorithm' OR starts_with(key, ?)", []interface{}{d.metadataKey("")}
+	}
+	_, err = d.exec(ctx, "DELETE FROM index_metadata"+whereCondition(condition), args...)
 	if err != nil {
 		return fmt.Errorf("error clearing metadata: %v", err)
 	}
 
+	condition, args = d.hostScope("", nil)
+	_, err = d.exec(ctx, "DELETE FROM roots"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error clearing roots: %v", err)
+	}
+
 	return nil
 }
 
 // SetMetadata sets metadata key-value pairs
-func (d *Database) SetMetadata(key, value string) error {
-	_, err := d.db.Exec("INSERT INTO index_metadata (key, value) VALUES (?, ?)", key, value)
+func (d *Database) SetMetadata(ctx context.Context, key, value string) error {
+	_, err := d.exec(ctx, `
+		INSERT INTO index_metadata (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, d.metadataKey(key), value)
 	if err != nil {
 		return fmt.Errorf("error setting %s: %v", key, err)
 	}
 	return nil
 }
 
-// InsertFile inserts a file record into the database
-func (d *Database) InsertFile(file models.FileInfo) error {
-	_, err := d.db.Exec(`
-		INSERT INTO files (path, filename, checksum, modification_datetime, file_size, indexed_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-		ON CONFLICT(path, filename) DO UPDATE SET
-		checksum = excluded.checksum,
-		modification_datetime = excluded.modification_datetime,
-		file_size = excluded.file_size,
-		indexed_at = excluded.indexed_at
-	`, file.Path, file.Filename, file.Checksum, file.ModificationDateTime, file.FileSize, file.IndexedAt)
+// GetMetadata returns the value of a metadata key and whether it is set
+func (d *Database) GetMetadata(ctx context.Context, key string) (string, bool, error) {
+	var value sql.NullString
+	err := d.queryRow(ctx, "SELECT value FROM index_metadata WHERE key = ?", d.metadataKey(key)).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error getting %s: %v", key, err)
+	}
+	return value.String, true, nil
+}
+
+// DeleteMetadata removes a metadata key
+func (d *Database) DeleteMetadata(ctx context.Context, key string) error {
+	if _, err := d.exec(ctx, "DELETE FROM index_metadata WHERE key = ?", d.metadataKey(key)); err != nil {
+		return fmt.Errorf("error deleting %s: %v", key, err)
+	}
+	return nil
+}
+
+// CountFiles returns the number of indexed files of the host
+func (d *Database) CountFiles(ctx context.Context) (int, error) {
+	var count int
+	condition, args := d.hostScope("", nil)
+	if err := d.queryRow(ctx, "SELECT COUNT(*) FROM files"+whereCondition(condition), args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error getting file count: %v", err)
+	}
+	return count, nil
+}
+
+// upsertClause updates every non-key column when a file is inserted again
+func (d *Database) upsertClause() string {
+	return `
+	ON CONFLICT(` + d.fileKey() + `) DO UPDATE SET
+	checksum = excluded.checksum,
+	modification_datetime = excluded.modification_datetime,
+	file_size = excluded.file_size,
+	indexed_at = excluded.indexed_at,
+	quick_hash = excluded.quick_hash,
+	sample_hash = excluded.sample_hash,
+	link_target = excluded.link_target,
+	device = excluded.device,
+	inode = excluded.inode,
+	mime_type = excluded.mime_type,
+	content = excluded.content,
+	taken_at = excluded.taken_at,
+	camera_model = excluded.camera_model,
+	camera_serial = excluded.camera_serial,
+	image_width = excluded.image_width,
+	image_height = excluded.image_height,
+	container = excluded.container,
+	duration_seconds = excluded.duration_seconds,
+	video_width = excluded.video_width,
+	video_height = excluded.video_height,
+	video_codec = excluded.video_codec,
+	audio_codec = excluded.audio_codec,
+	source = excluded.source,
+	host = excluded.host,
+	uid = excluded.uid,
+	gid = excluded.gid,
+	user_name = excluded.user_name,
+	group_name = excluded.group_name,
+	mode = excluded.mode,
+	attributes = excluded.attributes,
+	finder_tags = excluded.finder_tags,
+	image_hash = excluded.image_hash,
+	text_hash = excluded.text_hash,
+	link_count = excluded.link_count,
+	label = excluded.label,
+	language = excluded.language,
+	line_count = excluded.line_count,
+	code_lines = excluded.code_lines,
+	comment_lines = excluded.comment_lines
+`
+}
+
+// fileValues is the VALUES tuple of a file record in an INSERT
+var fileValues = "(?" + strings.Repeat(", ?", len(storedColumns)-1) + ")"
+
+// insertFileSQL upserts a file record; arguments come from insertFileArgs
+func (d *Database) insertFileSQL() string {
+	return "INSERT INTO files (" + strings.Join(storedColumns, ", ") + ") VALUES " + fileValues + d.upsertClause()
+}
+
+// insertFileArgs returns the arguments of insertFileSQL for a file. Files
+// without a host are stored under the database's host.
+func (d *Database) insertFileArgs(file models.FileInfo) []interface{} {
+	host := d.fileHost(file)
+	return []interface{}{
+		file.Path, file.Filename, nullIfEmpty(file.Checksum), file.ModificationDateTime,
+		file.FileSize, file.IndexedAt, nullIfEmpty(file.QuickHash), nullIfEmpty(file.SampleHash),
+		nullIfEmpty(file.LinkTarget), nullIfZero(file.Device), nullIfZero(file.Inode), nullIfEmpty(file.MimeType),
+		nullIfZeroTime(file.TakenAt), nullIfEmpty(file.CameraModel), nullIfEmpty(file.CameraSerial),
+		nullIfZero(file.ImageWidth), nullIfZero(file.ImageHeight),
+		nullIfEmpty(file.Container), nullIfZero(file.DurationSeconds), nullIfZero(file.VideoWidth), nullIfZero(file.VideoHeight),
+		nullIfEmpty(file.VideoCodec), nullIfEmpty(file.AudioCodec),
+		nullIfEmpty(file.Source), nullIfEmpty(host),
+		nullIfNil(file.UID), nullIfNil(file.GID), nullIfEmpty(file.UserName), nullIfEmpty(file.GroupName), nullIfUnowned(file), nullIfEmpty(file.Attributes),
+		nullIfEmpty(strings.Join(file.FinderTags, tagSeparator)),
+		nullIfEmpty(file.ImageHash), nullIfEmpty(file.TextHash), nullIfZero(file.LinkCount),
+		nullIfEmpty(file.Label),
+		nullIfEmpty(file.Language), nullIfZero(file.LineCount), nullIfZero(file.CodeLines), nullIfZero(file.CommentLines),
+		nullIfEmpty(file.Content),
+	}
+}
 
+// fileHost returns the host a file is stored under
+func (d *Database) fileHost(file models.FileInfo) string {
+	switch {
+	case file.Host != "":
+		return file.Host
+	case d.hostKeyed:
+		return d.localHost
+	}
+	return d.host
+}
+
+// location returns the location of a file read from a table with its host
+// and path
+func location(host sql.NullString, path string) string {
+	return models.FileInfo{Host: host.String, Path: path}.Location()
+}
+
+// loadFileKey reads whether the files table of a DuckDB file is keyed by
+// host. New files are, if the name of this machine is known to store files
+// without a host for; older ones keep their key of path and filename.
+func (d *Database) loadFileKey(ctx context.Context) error {
+	if d.postgres {
+		return nil
+	}
+	var keyed sql.NullBool
+	err := d.db.QueryRowContext(ctx, `
+	SELECT list_contains(constraint_column_names, 'host')
+	FROM duckdb_constraints()
+	WHERE database_name = current_database() AND table_name = 'files' AND constraint_type = 'PRIMARY KEY'
+	`).Scan(&keyed)
+	switch {
+	case err == sql.ErrNoRows:
+		d.hostKeyed = d.localHost != "" && !d.readOnly
+	case err != nil:
+		return fmt.Errorf("error reading the key of the files table: %v", err)
+	default:
+		d.hostKeyed = keyed.Bool
+	}
+	return nil
+}
+
+// UpsertRoot records the state of an indexed root directory
+func (d *Database) UpsertRoot(ctx context.Context, root models.RootInfo) error {
+	_, err := d.exec(ctx, `
+		INSERT INTO roots (path, indexed_at, file_count, total_size, host) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(`+d.rootKey()+`) DO UPDATE SET
+		indexed_at = excluded.indexed_at,
+		file_count = excluded.file_count,
+		total_size = excluded.total_size
+	`, root.Path, root.IndexedAt, root.FileCount, root.TotalSize, nullIfEmpty(d.host))
 	if err != nil {
-		return fmt.Errorf("error inserting file %s: %v", file.Path, err)
+		return fmt.Errorf("error recording root %s: %v", root.Path, err)
 	}
 	return nil
 }
 
-// SearchFiles searches for files in the database
-func (d *Database) SearchFiles(query string) ([]models.FileInfo, error) {
-	rows, err := d.db.Query(`
-		SELECT path, filename, checksum, modification_datetime, file_size, indexed_at
-		FROM files
-		WHERE filename ILIKE ? OR path ILIKE ?
-		ORDER BY filename
-	`, "%"+query+"%", "%"+query+"%")
+// ListRoots returns all indexed root directories of the host
+func (d *Database) ListRoots(ctx context.Context) ([]models.RootInfo, error) {
+	condition, args := d.hostScope("", nil)
+	rows, err := d.query(ctx, "SELECT path, indexed_at, file_count, total_size FROM roots"+whereCondition(condition)+" ORDER BY path", args...)
 	if err != nil {
-		return nil, fmt.Errorf("error searching files: %v", err)
+		return nil, fmt.Errorf("error listing roots: %v", err)
 	}
 	defer rows.Close()
 
-	var files []models.FileInfo
+	var roots []models.RootInfo
 	for rows.Next() {
-		var file models.FileInfo
-		var checksumNullable sql.NullString
-		err := rows.Scan(&file.Path, &file.Filename, &checksumNullable, &file.ModificationDateTime, &file.FileSize, &file.IndexedAt)
+		var root models.RootInfo
+		if err := rows.Scan(&root.Path, &root.IndexedAt, &root.FileCount, &root.TotalSize); err != nil {
+			return nil, fmt.Errorf("error reading roots: %v", err)
+		}
+		roots = append(roots, root)
+	}
+	return roots, rows.Err()
+}
+
+// SummarizePath returns the number and total size of files below a path
+func (d *Database) SummarizePath(ctx context.Context, path string) (int64, int64, error) {
+	prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	condition, args := d.hostScope("path = ? OR starts_with(path, ?)", []interface{}{path, prefix})
+	var count, size int64
+	err := d.queryRow(ctx, "SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM files"+whereCondition(condition), args...).Scan(&count, &size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error summarizing %s: %v", path, err)
+	}
+	return count, size, nil
+}
+
+// InsertFile inserts a file record into the database, retrying after
+// transient failures
+func (d *Database) InsertFile(ctx context.Context, file models.FileInfo) error {
+	err := retryTransient(ctx, func() error {
+		if file.XAttrs == nil && file.Checksums == nil && file.Secrets == nil {
+			_, err := d.exec(ctx, d.insertFileSQL(), d.insertFileArgs(file)...)
+			return err
+		}
+
+		tx, err := d.db.BeginTx(ctx, nil)
 		if err != nil {
-			log.Printf("Error scanning file row: %v", err)
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, d.rebind(d.insertFileSQL()), d.insertFileArgs(file)...); err != nil {
+			return err
+		}
+		if err := d.replaceXAttrs(ctx, tx, []models.FileInfo{file}); err != nil {
+			return err
+		}
+		if err := d.replaceChecksums(ctx, tx, []models.FileInfo{file}); err != nil {
+			return err
+		}
+		if err := d.replaceSecrets(ctx, tx, []models.FileInfo{file}); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return fmt.Errorf("error inserting file %s: %v", file.Path, err)
+	}
+	return nil
+}
+
+// InsertFiles upserts many file records at once. In DuckDB, rows are
+// bulk-loaded into a temporary staging table with the appender, which is
+// much faster than one INSERT per row, and then merged into the files table.
+// Batches failing with transient errors, such as failed commits, are retried
+// and then split; the error names the files that could not be stored.
+func (d *Database) InsertFiles(ctx context.Context, files []models.FileInfo) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	// An upsert may not touch the same row twice, so keep the last record per
+	// key of the files table
+	type rowKey struct{ host, path, filename string }
+	unique := make([]models.FileInfo, 0, len(files))
+	position := make(map[rowKey]int, len(files))
+	for _, file := range files {
+		key := rowKey{path: file.Path, filename: file.Filename}
+		if d.postgres || d.hostKeyed {
+			key.host = d.fileHost(file)
+		}
+		if pos, ok := position[key]; ok {
+			unique[pos] = file
 			continue
 		}
+		position[key] = len(unique)
+		unique = append(unique, file)
+	}
 
-		// Handle nullable checksum
-		if checksumNullable.Valid {
-			file.Checksum = checksumNullable.String
+	failed, err := d.insertChunks(ctx, unique, insertSplits)
+	if err != nil {
+		return fmt.Errorf("error storing %d of %d files (%s): %v", len(failed), len(unique), describePaths(failed), err)
+	}
+	return nil
+}
+
+// insertBatch upserts files in a single attempt, returning the error of the
+// database driver
+func (d *Database) insertBatch(ctx context.Context, files []models.FileInfo) error {
+	if d.postgres {
+		return d.insertFilesPostgres(ctx, files)
+	}
+
+	// Temporary tables are per connection, so pin one for the whole operation
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	columns := strings.Join(storedColumns, ", ")
+	if _, err := conn.ExecContext(ctx, "CREATE TEMP TABLE IF NOT EXISTS files_staging AS SELECT "+columns+" FROM files LIMIT 0"); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "DELETE FROM files_staging"); err != nil {
+		return err
+	}
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		appender, err := duckdb.NewAppenderFromConn(driverConn.(driver.Conn), "", "files_staging")
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := appender.AppendRow(d.appenderArgs(file)...); err != nil {
+				appender.Close()
+				return err
+			}
 		}
+		return appender.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, "INSERT INTO files ("+columns+") SELECT "+columns+" FROM files_staging"+d.upsertClause())
+	if err != nil {
+		return err
+	}
+	if err := d.replaceXAttrs(ctx, conn, files); err != nil {
+		return err
+	}
+	if err := d.replaceChecksums(ctx, conn, files); err != nil {
+		return err
+	}
+	if err := d.replaceSecrets(ctx, conn, files); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "DELETE FROM files_staging")
+	return err
+}
+
+// appenderArgs returns a file record as appender values, which need typed
+// NULLs to be passed as nil
+func (d *Database) appenderArgs(file models.FileInfo) []driver.Value {
+	args := d.insertFileArgs(file)
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return values
+}
+
+// DeleteFiles removes the file with the given absolute path, or all files
+// below it if the path was a directory, along with the entries of an archive
+// at the path
+func (d *Database) DeleteFiles(ctx context.Context, path string) error {
+	prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	condition, args := "path = ? OR starts_with(path, ?)", []interface{}{path, prefix}
+	for _, entries := range models.EntryPrefixes(path) {
+		condition += " OR starts_with(path, ?)"
+		args = append(args, entries)
+	}
+	condition, args = d.hostScope(condition, args)
+	_, err := d.exec(ctx, "DELETE FROM files"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error deleting files under %s: %v", path, err)
+	}
+	_, err = d.exec(ctx, "DELETE FROM file_xattrs"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error deleting extended attributes under %s: %v", path, err)
+	}
+	_, err = d.exec(ctx, "DELETE FROM file_checksums"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error deleting checksums under %s: %v", path, err)
+	}
+	_, err = d.exec(ctx, "DELETE FROM file_secrets"+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error deleting secrets under %s: %v", path, err)
+	}
+	return nil
+}
 
+// scanFiles reads all rows of a file query
+func scanFiles(rows *sql.Rows) ([]models.FileInfo, error) {
+	var files []models.FileInfo
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
 		files = append(files, file)
 	}
+	return files, rows.Err()
+}
 
+// nullIfEmpty maps empty strings to NULL for nullable columns
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// nullIfZero maps zero to NULL for numeric columns that may be unknown
+func nullIfZero[T int | uint64 | float64](value T) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// nullIfNil maps a missing user or group ID to NULL
+func nullIfNil(id *uint32) interface{} {
+	if id == nil {
+		return nil
+	}
+	return int64(*id)
+}
+
+// nullIfUnowned maps the mode of a file to NULL where the platform records
+// no owners and permissions. A mode of 0 is kept for files that have owners.
+func nullIfUnowned(file models.FileInfo) interface{} {
+	if file.UID == nil {
+		return nil
+	}
+	return int(file.Mode)
+}
+
+// nullableID returns a user or group ID read from a nullable column
+func nullableID(id sql.Null[int64]) *uint32 {
+	if !id.Valid {
+		return nil
+	}
+	value := uint32(id.V)
+	return &value
+}
+
+// nullIfZeroTime maps the zero time to NULL for optional timestamps
+func nullIfZeroTime(value time.Time) interface{} {
+	if value.IsZero() {
+		return nil
+	}
+	return value
+}
+
+// FindSampleHashCollisions returns files of the host without a full checksum
+// whose sampled hash is shared by other files
+func (d *Database) FindSampleHashCollisions(ctx context.Context) ([]models.FileInfo, error) {
+	condition, args := d.hostScope("checksum IS NULL OR checksum = ''", nil)
+	rows, err := d.query(ctx, `
+		SELECT `+selectColumns("")+`
+		FROM files
+		WHERE (`+condition+`)
+		AND sample_hash IN (
+			SELECT sample_hash
+			FROM files
+			WHERE sample_hash IS NOT NULL
+			GROUP BY sample_hash
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY path
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error finding sampled hash collisions: %v", err)
+	}
+	defer rows.Close()
+
+	files, err := scanFiles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sampled hash collisions: %v", err)
+	}
 	return files, nil
 }
 
-// ListFiles retrieves all files from the database
-func (d *Database) ListFiles() ([]models.FileInfo, error) {
-	rows, err := d.db.Query(`
-		SELECT path, filename, checksum, modification_datetime, file_size, indexed_at
+// FindQuickHashCollisions returns files of the host without a full checksum
+// whose quick hash is shared with at least one other file
+func (d *Database) FindQuickHashCollisions(ctx context.Context) ([]models.FileInfo, error) {
+	condition, args := d.hostScope("checksum IS NULL OR checksum = ''", nil)
+	rows, err := d.query(ctx, `
+		SELECT `+selectColumns("")+`
 		FROM files
-		ORDER BY filename
-	`)
+		WHERE (`+condition+`)
+		AND quick_hash IN (
+			SELECT quick_hash
+			FROM files
+			WHERE quick_hash IS NOT NULL
+			GROUP BY quick_hash
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY path
+	`, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error listing files: %v", err)
+		return nil, fmt.Errorf("error finding quick hash collisions: %v", err)
 	}
 	defer rows.Close()
 
-	var files []models.FileInfo
+	files, err := scanFiles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading quick hash collisions: %v", err)
+	}
+	return files, nil
+}
+
+// UpdateFileIdentity stores the inode, link count, owner and permissions of
+// an indexed file whose path was replaced by a link, keeping everything else
+// recorded about it
+func (d *Database) UpdateFileIdentity(ctx context.Context, file models.FileInfo) error {
+	condition, args := d.hostScope("path = ?", []interface{}{
+		nullIfZero(file.Device), nullIfZero(file.Inode), nullIfZero(file.LinkCount),
+		nullIfNil(file.UID), nullIfNil(file.GID), nullIfEmpty(file.UserName), nullIfEmpty(file.GroupName), nullIfUnowned(file),
+		file.Path,
+	})
+	_, err := d.exec(ctx, `UPDATE files SET device = ?, inode = ?, link_count = ?,
+		uid = ?, gid = ?, user_name = ?, group_name = ?, mode = ?`+whereCondition(condition), args...)
+	if err != nil {
+		return fmt.Errorf("error updating %s: %v", file.Path, err)
+	}
+	return nil
+}
+
+// StalePaths returns the files below a path that were last indexed before
+// the given time
+func (d *Database) StalePaths(ctx context.Context, path string, before time.Time) ([]string, error) {
+	prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	condition, args := d.hostScope("(path = ? OR starts_with(path, ?)) AND indexed_at < ?", []interface{}{path, prefix, before})
+	rows, err := d.query(ctx, "SELECT path FROM files"+whereCondition(condition)+" ORDER BY path", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error finding stale files under %s: %v", path, err)
+	}
+	defer rows.Close()
+
+	var paths []string
 	for rows.Next() {
-		var file models.FileInfo
-		var checksumNullable sql.NullString
-		err := rows.Scan(&file.Path, &file.Filename, &checksumNullable, &file.ModificationDateTime, &file.FileSize, &file.IndexedAt)
-		if err != nil {
-			log.Printf("Error scanning file row: %v", err)
-			continue
+		var stale string
+		if err := rows.Scan(&stale); err != nil {
+			return nil, fmt.Errorf("error reading stale files under %s: %v", path, err)
+		}
+		paths = append(paths, stale)
+	}
+	return paths, rows.Err()
+}
+
+// ListFilesBelow returns the files at or below a path
+func (d *Database) ListFilesBelow(ctx context.Context, path string) ([]models.FileInfo, error) {
+	prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	condition, args := d.hostScope("path = ? OR starts_with(path, ?)", []interface{}{path, prefix})
+	files, err := d.queryFiles(ctx, condition, args, models.FileQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing files under %s: %v", path, err)
+	}
+	return files, nil
+}
+
+// DeletePaths removes the files with the given paths in a single transaction
+func (d *Database) DeletePaths(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	condition, hostArgs := d.hostScope("path = ?", nil)
+	stmt, err := tx.PrepareContext(ctx, d.rebind("DELETE FROM files"+whereCondition(condition)))
+	if err != nil {
+		return fmt.Errorf("error preparing delete: %v", err)
+	}
+	defer stmt.Close()
+	xattrStmt, err := tx.PrepareContext(ctx, d.rebind("DELETE FROM file_xattrs"+whereCondition(condition)))
+	if err != nil {
+		return fmt.Errorf("error preparing delete: %v", err)
+	}
+	defer xattrStmt.Close()
+	checksumStmt, err := tx.PrepareContext(ctx, d.rebind("DELETE FROM file_checksums"+whereCondition(condition)))
+	if err != nil {
+		return fmt.Errorf("error preparing delete: %v", err)
+	}
+	defer checksumStmt.Close()
+	secretStmt, err := tx.PrepareContext(ctx, d.rebind("DELETE FROM file_secrets"+whereCondition(condition)))
+	if err != nil {
+		return fmt.Errorf("error preparing delete: %v", err)
+	}
+	defer secretStmt.Close()
+
+	for _, path := range paths {
+		args := append([]interface{}{path}, hostArgs...)
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("error deleting file %s: %v", path, err)
 		}
+		if _, err := xattrStmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("error deleting extended attributes of %s: %v", path, err)
+		}
+		if _, err := checksumStmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("error deleting checksums of %s: %v", path, err)
+		}
+		if _, err := secretStmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("error deleting secrets of %s: %v", path, err)
+		}
+	}
 
-		// Handle nullable checksum
-		if checksumNullable.Valid {
-			file.Checksum = checksumNullable.String
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing deletions: %v", err)
+	}
+	return nil
+}
+
+// whereClause combines a condition with the filters of a query into a WHERE
+// clause. condition may be empty to match all files.
+func whereClause(condition string, args []interface{}, query models.FileQuery) (string, []interface{}) {
+	var conditions []string
+	if condition != "" {
+		conditions = append(conditions, "("+condition+")")
+	}
+	if query.Dir != "" {
+		conditions = append(conditions, "path = ? || filename")
+		args = append(args, strings.TrimSuffix(query.Dir, string(filepath.Separator))+string(filepath.Separator))
+	}
+	if query.MinSize > 0 {
+		conditions = append(conditions, "file_size >= ?")
+		args = append(args, query.MinSize)
+	}
+	if query.MaxSize > 0 {
+		conditions = append(conditions, "file_size <= ?")
+		args = append(args, query.MaxSize)
+	}
+	if !query.ModifiedAfter.IsZero() {
+		conditions = append(conditions, "modification_datetime >= ?")
+		args = append(args, query.ModifiedAfter)
+	}
+	if !query.ModifiedBefore.IsZero() {
+		conditions = append(conditions, "modification_datetime < ?")
+		args = append(args, query.ModifiedBefore)
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, "(position(? IN lower(chr(10) || finder_tags || chr(10))) > 0"+
+			" OR checksum IN (SELECT checksum FROM tags WHERE tag = ?))")
+		args = append(args, tagSeparator+strings.ToLower(query.Tag)+tagSeparator, models.NormalizeTag(query.Tag))
+	}
+	if query.XAttr != "" {
+		name, value, hasValue := query.XAttrFilter()
+		if hasValue {
+			conditions = append(conditions, xattrCondition("x.name = ? AND x.value = ?"))
+			args = append(args, name, value)
+		} else {
+			conditions = append(conditions, xattrCondition("x.name = ?"))
+			args = append(args, name)
 		}
+	}
 
-		files = append(files, file)
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// textCondition matches files whose name, path or MIME type (and optionally
+// content) contains text. An empty text matches all files.
+func textCondition(text string, content bool) (string, []interface{}) {
+	if text == "" {
+		return "", nil
+	}
+	pattern := "%" + text + "%"
+	if content {
+		return "filename ILIKE ? OR path ILIKE ? OR mime_type ILIKE ? OR content ILIKE ?",
+			[]interface{}{pattern, pattern, pattern, pattern}
 	}
+	return "filename ILIKE ? OR path ILIKE ? OR mime_type ILIKE ?",
+		[]interface{}{pattern, pattern, pattern}
+}
+
+// sortColumns maps sort fields to the columns they order by
+var sortColumns = map[models.SortField]string{
+	models.SortName:     "filename",
+	models.SortPath:     "path",
+	models.SortSize:     "file_size",
+	models.SortModified: "modification_datetime",
+}
 
+// orderClause returns the ORDER BY clause for a query, breaking ties by path
+// so that pages are stable
+func orderClause(query models.FileQuery) string {
+	column, ok := sortColumns[query.Sort]
+	if !ok {
+		column = "filename"
+	}
+	if query.Desc {
+		column += " DESC"
+	}
+	return " ORDER BY " + column + ", path"
+}
+
+// queryFiles selects the files matching a condition and the query, applying
+// the query's order, limit and offset
+func (d *Database) queryFiles(ctx context.Context, condition string, args []interface{}, query models.FileQuery) ([]models.FileInfo, error) {
+	where, args := whereClause(condition, args, query)
+	sqlQuery := "SELECT " + selectColumns("") + " FROM files" + where + orderClause(query)
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	if query.Offset > 0 {
+		sqlQuery += " OFFSET ?"
+		args = append(args, query.Offset)
+	}
+
+	rows, err := d.query(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFiles(rows)
+}
+
+// SearchFiles searches for files by name, path or MIME type
+func (d *Database) SearchFiles(ctx context.Context, text string, query models.FileQuery) ([]models.FileInfo, error) {
+	condition, args := textCondition(text, false)
+	files, err := d.queryFiles(ctx, condition, args, query)
+	if err != nil {
+		return nil, fmt.Errorf("error searching files: %v", err)
+	}
 	return files, nil
 }
 
-// GetFileByPathAndFilename retrieves a file by its path and filename.
-func (d *Database) GetFileByPathAndFilename(path, filename string) (*models.FileInfo, error) {
-	row := d.db.QueryRow("SELECT path, filename, checksum, modification_datetime, file_size, indexed_at FROM files WHERE path = ? AND filename = ?", path, filename)
+// SearchContent searches for files by name, path, MIME type or content
+func (d *Database) SearchContent(ctx context.Context, text string, query models.FileQuery) ([]models.FileInfo, error) {
+	condition, args := textCondition(text, true)
+	files, err := d.queryFiles(ctx, condition, args, query)
+	if err != nil {
+		return nil, fmt.Errorf("error searching file contents: %v", err)
+	}
+	return files, nil
+}
 
-	var file models.FileInfo
-	err := row.Scan(&file.Path, &file.Filename, &file.Checksum, &file.ModificationDateTime, &file.FileSize, &file.IndexedAt)
+// CountMatches counts the files that SearchFiles (or SearchContent, if content
+// is set) would return, ignoring the query's limit and offset. An empty text
+// counts all files matching the query's filters.
+func (d *Database) CountMatches(ctx context.Context, text string, content bool, query models.FileQuery) (int64, error) {
+	condition, args := textCondition(text, content)
+	where, args := whereClause(condition, args, query)
+
+	var count int64
+	if err := d.queryRow(ctx, "SELECT COUNT(*) FROM files"+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting files: %v", err)
+	}
+	return count, nil
+}
+
+// DirectoryUsage aggregates the files below root by directory. Each file
+// counts towards every ancestor directory up to depth levels below root; a
+// negative depth means no limit.
+func (d *Database) DirectoryUsage(ctx context.Context, root string, depth int) ([]models.DirectoryUsage, error) {
+	if depth < 0 {
+		depth = math.MaxInt32
+	}
+	separator := string(filepath.Separator)
+	prefix := strings.TrimSuffix(root, separator) + separator
+
+	// Split each path below root into its components; a file at depth n
+	// contributes to the directories formed by its first 0..n components
+	usageSQL := `
+		SELECT coalesce(array_to_string(parts[1:level], ?), '') AS dir, level, COUNT(*), SUM(file_size)
+		FROM (
+			SELECT file_size, parts, UNNEST(range(0, least(len(parts) - 1, ?) + 1)) AS level
+			FROM (
+				SELECT file_size, string_split(substr(path, ?), ?) AS parts
+				FROM files
+				WHERE %s
+			)
+		)
+		GROUP BY dir, level
+		ORDER BY dir
+	`
+	if d.postgres {
+		usageSQL = postgresUsageSQL
+	}
+	condition, args := d.hostScope("starts_with(path, ?)", []interface{}{separator, depth, len(prefix) + 1, separator, prefix})
+	rows, err := d.query(ctx, fmt.Sprintf(usageSQL, condition), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error aggregating directory usage: %v", err)
+	}
+	defer rows.Close()
+
+	var usage []models.DirectoryUsage
+	for rows.Next() {
+		var dir string
+		var entry models.DirectoryUsage
+		if err := rows.Scan(&dir, &entry.Depth, &entry.FileCount, &entry.TotalSize); err != nil {
+			return nil, fmt.Errorf("error reading directory usage: %v", err)
+		}
+		entry.Path = filepath.Join(root, dir)
+		usage = append(usage, entry)
+	}
+	return usage, rows.Err()
+}
+
+// FileContents returns the stored contents of all files indexed with
+// content, keyed by location (see models.FileInfo.Location)
+func (d *Database) FileContents(ctx context.Context) (map[string]string, error) {
+	rows, err := d.query(ctx, "SELECT host, path, content FROM files WHERE content IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("error reading file contents: %v", err)
+	}
+	defer rows.Close()
+
+	contents := make(map[string]string)
+	for rows.Next() {
+		var host sql.NullString
+		var path, content string
+		if err := rows.Scan(&host, &path, &content); err != nil {
+			return nil, fmt.Errorf("error reading file contents: %v", err)
+		}
+		contents[location(host, path)] = content
+	}
+	return contents, rows.Err()
+}
+
+// FileContent returns the stored content of a file of the host, or "" if it
+// was indexed without one
+func (d *Database) FileContent(ctx context.Context, path string) (string, error) {
+	condition, args := d.hostScope("path = ?", []interface{}{path})
+	var content sql.NullString
+	err := d.queryRow(ctx, "SELECT content FROM files"+whereCondition(condition), args...).Scan(&content)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("error reading content of %s: %v", path, err)
+	}
+	return content.String, nil
+}
+
+// ListFiles retrieves the files matching a query from the database
+func (d *Database) ListFiles(ctx context.Context, query models.FileQuery) ([]models.FileInfo, error) {
+	files, err := d.queryFiles(ctx, "", nil, query)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files: %v", err)
+	}
+	return files, nil
+}
+
+// GetFileByPathAndFilename retrieves a file of the host by its path and
+// filename.
+func (d *Database) GetFileByPathAndFilename(ctx context.Context, path, filename string) (*models.FileInfo, error) {
+	condition, args := d.hostScope("path = ? AND filename = ?", []interface{}{path, filename})
+	row := d.queryRow(ctx, "SELECT "+selectColumns("")+" FROM files"+whereCondition(condition), args...)
+
+	file, err := scanFile(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Not found
@@ -195,13 +1311,215 @@ func (d *Database) GetFileByPathAndFilename(path, filename string) (*models.File
 	return &file, nil
 }
 
+// notAppleDouble excludes AppleDouble files, which are attached to the
+// duplicate groups of their data files instead of being grouped themselves
+const notAppleDouble = "substr(filename, 1, 2) <> '" + models.AppleDoublePrefix + "'"
+
+// CountUnhashed counts the files that have no checksum, sampled hash or
+// quick hash to be compared by, such as those of scans without checksums,
+// and all files, over all hosts. Recorded symlinks count as neither.
+func (d *Database) CountUnhashed(ctx context.Context) (int64, int64, error) {
+	var unhashed, total int64
+	err := d.queryRow(ctx, `SELECT
+		COALESCE(SUM(CASE WHEN COALESCE(checksum, '') = '' AND COALESCE(sample_hash, '') = '' AND COALESCE(quick_hash, '') = '' THEN 1 ELSE 0 END), 0),
+		COUNT(*)
+		FROM files WHERE link_target IS NULL`).Scan(&unhashed, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error counting files without checksum: %v", err)
+	}
+	return unhashed, total, nil
+}
+
+// FindDuplicates finds groups of files with identical size and checksum that
+// pass filter. Files are first narrowed down to sizes that occur often
+// enough, so only potential duplicates take part in the checksum grouping.
+// The thresholds of filter are applied to both groupings; as hardlinks and
+// aliases only show once the groups are built, they are checked again then.
+func (d *Database) FindDuplicates(ctx context.Context, filter models.DuplicateFilter) ([]models.DuplicateGroup, error) {
+	minFiles := max(2, filter.MinGroupSize)
+	rows, err := d.query(ctx, `
+		WITH size_candidates AS (
+			SELECT file_size
+			FROM files
+			WHERE `+notAppleDouble+` AND file_size >= ?
+			GROUP BY file_size
+			HAVING COUNT(*) >= ? AND (COUNT(*) - 1) * file_size >= ?
+		),
+		checksum_groups AS (
+			SELECT file_size, checksum
+			FROM files
+			WHERE file_size IN (SELECT file_size FROM size_candidates)
+			AND checksum IS NOT NULL AND checksum <> ''
+			AND `+notAppleDouble+`
+			GROUP BY file_size, checksum
+			HAVING COUNT(*) >= ? AND (COUNT(*) - 1) * file_size >= ?
+		)
+		SELECT `+selectColumns("f")+`
+		FROM files f
+		JOIN checksum_groups g ON f.file_size = g.file_size AND f.checksum = g.checksum
+		WHERE `+notAppleDouble+`
+		ORDER BY f.file_size DESC, f.checksum, f.path
+	`, filter.MinFileSize, minFiles, filter.MinWasted, minFiles, filter.MinWasted)
+	if err != nil {
+		return nil, fmt.Errorf("error finding duplicates: %v", err)
+	}
+	defer rows.Close()
+
+	var groups []models.DuplicateGroup
+	var current []models.FileInfo
+	flush := func() {
+		if len(current) > 1 {
+			// Groups made up only of hardlinks to one file waste no space
+			if group := models.NewDuplicateGroup(current[0].Checksum, current[0].FileSize, current); group.Copies > 1 && filter.Matches(group) {
+				groups = append(groups, group)
+			}
+		}
+		current = nil
+	}
+
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading duplicates: %v", err)
+		}
+
+		if len(current) > 0 && (current[0].Checksum != file.Checksum || current[0].FileSize != file.FileSize) {
+			flush()
+		}
+		current = append(current, file)
+	}
+	flush()
+	if err := rows.Err(); err != nil || len(groups) == 0 {
+		return groups, err
+	}
+
+	companions, err := d.queryFiles(ctx, "NOT ("+notAppleDouble+")", nil, models.FileQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("error finding AppleDouble files: %v", err)
+	}
+	models.AttachCompanions(groups, companions)
+	return groups, nil
+}
+
+// FindSampledDuplicates groups the files that have only a sampled hash by
+// that hash. These are candidate duplicates until full checksums confirm
+// them; the Checksum of each group is the sampled hash.
+func (d *Database) FindSampledDuplicates(ctx context.Context) ([]models.DuplicateGroup, error) {
+	rows, err := d.query(ctx, `
+		WITH sampled AS (
+			SELECT *
+			FROM files
+			WHERE sample_hash IS NOT NULL AND (checksum IS NULL OR checksum = '')
+			AND `+notAppleDouble+`
+		)
+		SELECT `+selectColumns("")+`
+		FROM sampled
+		WHERE sample_hash IN (SELECT sample_hash FROM sampled GROUP BY sample_hash HAVING COUNT(*) > 1)
+		ORDER BY file_size DESC, sample_hash, path
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error finding sampled duplicates: %v", err)
+	}
+	defer rows.Close()
+
+	files, err := scanFiles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sampled duplicates: %v", err)
+	}
+	return models.GroupSampled(files), nil
+}
+
+// ListImageHashes returns the files of the host below root that have a
+// perceptual image hash. An empty root covers the whole index.
+func (d *Database) ListImageHashes(ctx context.Context, root string) ([]models.FileInfo, error) {
+	files, err := d.listHashed(ctx, root, "image_hash")
+	if err != nil {
+		return nil, fmt.Errorf("error listing image hashes: %v", err)
+	}
+	return files, nil
+}
+
+// ListTextHashes returns the files of the host below root that have a text
+// simhash. An empty root covers the whole index.
+func (d *Database) ListTextHashes(ctx context.Context, root string) ([]models.FileInfo, error) {
+	files, err := d.listHashed(ctx, root, "text_hash")
+	if err != nil {
+		return nil, fmt.Errorf("error listing text hashes: %v", err)
+	}
+	return files, nil
+}
+
+// ListPhotos returns the files of the host that have an EXIF capture time
+func (d *Database) ListPhotos(ctx context.Context) ([]models.FileInfo, error) {
+	files, err := d.listHashed(ctx, "", "taken_at")
+	if err != nil {
+		return nil, fmt.Errorf("error listing photos: %v", err)
+	}
+	return files, nil
+}
+
+// listHashed returns the files of the host below root whose similarity hash
+// column is set, in path order
+func (d *Database) listHashed(ctx context.Context, root, column string) ([]models.FileInfo, error) {
+	condition, args := column+" IS NOT NULL", []interface{}{}
+	if root != "" {
+		condition += " AND starts_with(path, ?)"
+		args = append(args, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+	}
+	condition, args = d.hostScope(condition, args)
+	rows, err := d.query(ctx, "SELECT "+selectColumns("")+" FROM files WHERE "+condition+" ORDER BY path", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanFiles(rows)
+}
+
+// FindCaseCollisions finds the files of the host below root whose paths
+// differ only by case. An empty root covers the whole index. Entries of
+// archives and alternate data streams are left out, as they are not files of
+// the directories they are listed in.
+func (d *Database) FindCaseCollisions(ctx context.Context, root string) ([]models.CaseCollision, error) {
+	condition, args := "(source IS NULL OR source NOT IN (?, ?))", []interface{}{models.SourceArchive, models.SourceStream}
+	if root != "" {
+		condition += " AND starts_with(path, ?)"
+		args = append(args, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+	}
+	condition, args = d.hostScope(condition, args)
+	rows, err := d.query(ctx, `
+		SELECT `+selectColumns("")+`
+		FROM files
+		WHERE `+condition+`
+		AND lower(path) IN (
+			SELECT lower(path)
+			FROM files
+			WHERE `+condition+`
+			GROUP BY lower(path)
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY path
+	`, append(args, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error finding case collisions: %v", err)
+	}
+	defer rows.Close()
+
+	files, err := scanFiles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading case collisions: %v", err)
+	}
+	return models.GroupCaseCollisions(files), nil
+}
+
 // GetStats retrieves statistics from the database
-func (d *Database) GetStats() (map[string]interface{}, error) {
-	stats := make(map[string]interface{})
+func (d *Database) GetStats(ctx context.Context) (map[string]interface{}, error) {
+	stats := d.StatsMetadata(ctx)
+	condition, args := d.hostScope("", nil)
+	where := whereCondition(condition)
 
 	// Get total files count
 	var totalFiles int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM files").Scan(&totalFiles)
+	err := d.queryRow(ctx, "SELECT COUNT(*) FROM files"+where, args...).Scan(&totalFiles)
 	if err != nil {
 		return nil, fmt.Errorf("error getting file count: %v", err)
 	}
@@ -209,86 +1527,190 @@ func (d *Database) GetStats() (map[string]interface{}, error) {
 
 	// Get total size
 	var totalSize int64
-	err = d.db.QueryRow("SELECT COALESCE(SUM(file_size), 0) FROM files").Scan(&totalSize)
+	err = d.queryRow(ctx, "SELECT COALESCE(SUM(file_size), 0) FROM files"+where, args...).Scan(&totalSize)
 	if err != nil {
 		return nil, fmt.Errorf("error getting total size: %v", err)
 	}
 	stats["total_size"] = totalSize
 
+	// Count the large files that only have a sampled hash
+	if _, ok := stats["sample_hash_algorithm"]; ok {
+		var sampled int
+		condition, args := d.hostScope("sample_hash IS NOT NULL AND (checksum IS NULL OR checksum = '')", nil)
+		err := d.queryRow(ctx, "SELECT COUNT(*) FROM files"+whereCondition(condition), args...).Scan(&sampled)
+		if err != nil {
+			return nil, fmt.Errorf("error counting sampled files: %v", err)
+		}
+		stats["sampled_files"] = sampled
+	}
+
+	// Get file types distribution (extract extension from filename)
+	rows, err := d.query(ctx, `
+		SELECT `+extensionExpression+` as extension,
+			COUNT(*) as count
+		FROM files`+where+`
+		GROUP BY extension
+		ORDER BY count DESC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file types: %v", err)
+	}
+	defer rows.Close()
+	fileTypes := make(map[string]int)
+	for rows.Next() {
+		var ext string
+		var count int
+		if err := rows.Scan(&ext, &count); err != nil {
+			return nil, fmt.Errorf("error reading file types: %v", err)
+		}
+		if ext == "" {
+			fileTypes["no_extension"] = count
+		} else {
+			fileTypes[ext] = count
+		}
+	}
+	stats["file_types"] = fileTypes
+
+	// Get MIME type distribution
+	rows, err = d.query(ctx, `
+		SELECT COALESCE(mime_type, '') AS mime_type, COUNT(*) AS count
+		FROM files`+where+`
+		GROUP BY 1
+		ORDER BY count DESC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting MIME types: %v", err)
+	}
+	defer rows.Close()
+	mimeTypes := make(map[string]int)
+	for rows.Next() {
+		var mimeType string
+		var count int
+		if err := rows.Scan(&mimeType, &count); err != nil {
+			return nil, fmt.Errorf("error reading MIME types: %v", err)
+		}
+		if mimeType == "" {
+			mimeType = "unknown"
+		}
+		mimeTypes[mimeType] = count
+	}
+	stats["mime_types"] = mimeTypes
+
+	// Get file size distribution
+	histogram, err := d.sizeHistogram(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting size histogram: %v", err)
+	}
+	stats["size_histogram"] = histogram
+
+	return stats, nil
+}
+
+// StatsMetadata returns the statistics kept as metadata of the index, which
+// take no aggregation of its files
+func (d *Database) StatsMetadata(ctx context.Context) map[string]interface{} {
+	stats := make(map[string]interface{})
+
 	// Get indexed time
-	var indexedTimeStr string
-	err = d.db.QueryRow("SELECT value FROM index_metadata WHERE key = 'indexed'").Scan(&indexedTimeStr)
-	if err == nil {
+	if indexedTimeStr, ok, err := d.GetMetadata(ctx, "indexed"); err == nil && ok {
 		if indexedTime, err := time.Parse(time.RFC3339, indexedTimeStr); err == nil {
 			stats["indexed_time"] = indexedTime
 		}
 	}
 
+	// Get hash algorithm
+	if hashAlgorithm, ok, err := d.GetMetadata(ctx, "hash_algorithm"); err == nil && ok {
+		stats["hash_algorithm"] = hashAlgorithm
+	}
+
+	// Get the algorithm of the sampled hashes of large files
+	if sampleAlgorithm, ok, err := d.GetMetadata(ctx, "sample_hash_algorithm"); err == nil && ok {
+		stats["sample_hash_algorithm"] = sampleAlgorithm
+	}
+
 	// Get root path
-	var rootPath string
-	err = d.db.QueryRow("SELECT value FROM index_metadata WHERE key = 'root_path'").Scan(&rootPath)
-	if err == nil {
+	if rootPath, ok, err := d.GetMetadata(ctx, "root_path"); err == nil && ok {
 		stats["root_path"] = rootPath
 	}
 
-	// Get file types distribution (extract extension from filename)
-	rows, err := d.db.Query(`
-		SELECT 
-			CASE 
-				WHEN filename LIKE '%.%' THEN SUBSTRING(filename, INSTR(filename, '.'))
-				ELSE ''
-			END as extension, 
-			COUNT(*) as count
-		FROM files
-		GROUP BY extension
-		ORDER BY count DESC
-	`)
-	if err != nil {
-		log.Printf("Error getting file types: %v", err)
-	} else {
-		defer rows.Close()
-		fileTypes := make(map[string]int)
-		for rows.Next() {
-			var ext string
-			var count int
-			if err := rows.Scan(&ext, &count); err == nil {
-				if ext == "" {
-					fileTypes["no_extension"] = count
-				} else {
-					fileTypes[ext] = count
-				}
-			}
+	// Get the machine and label of the last scan
+	if host, ok, err := d.GetMetadata(ctx, "host"); err == nil && ok {
+		stats["host"] = host
+	}
+	if label, ok, err := d.GetMetadata(ctx, "label"); err == nil && ok {
+		stats["label"] = label
+	}
+	return stats
+}
+
+// sizeHistogram counts files and bytes per bucket of NewSizeHistogram
+func (d *Database) sizeHistogram(ctx context.Context) ([]models.SizeBucket, error) {
+	histogram := models.NewSizeHistogram()
+
+	// Number each file's bucket with a CASE over the bucket bounds
+	var bucketCase strings.Builder
+	var args []interface{}
+	bucketCase.WriteString("CASE")
+	for n, bucket := range histogram {
+		if bucket.MaxSize == 0 {
+			break
 		}
-		stats["file_types"] = fileTypes
+		fmt.Fprintf(&bucketCase, " WHEN file_size < ? THEN %d", n)
+		args = append(args, bucket.MaxSize)
 	}
+	fmt.Fprintf(&bucketCase, " ELSE %d END", len(histogram)-1)
+	condition, args := d.hostScope("", args)
 
-	return stats, nil
+	rows, err := d.query(ctx, `
+		SELECT `+bucketCase.String()+` AS bucket, COUNT(*), SUM(file_size)
+		FROM files`+whereCondition(condition)+`
+		GROUP BY bucket
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket int
+		var count, size int64
+		if err := rows.Scan(&bucket, &count, &size); err != nil {
+			return nil, err
+		}
+		histogram[bucket].FileCount = count
+		histogram[bucket].TotalSize = size
+	}
+	return histogram, rows.Err()
 }
 
-// ExecuteSQL executes a custom SQL query and prints results
-func (d *Database) ExecuteSQL(sqlQuery string) error {
-	rows, err := d.db.Query(sqlQuery)
+// ExecuteSQL executes a custom SQL query, with args bound to its ?
+// placeholders, and writes the results to w in the output format
+func (d *Database) ExecuteSQL(ctx context.Context, w io.Writer, output SQLOutput, sqlQuery string, args ...interface{}) error {
+	// Queries without arguments are run as written, so a ? in a PostgreSQL
+	// operator is not taken for a placeholder
+	query := d.db.QueryContext
+	if len(args) > 0 {
+		query = d.query
+	}
+	rows, err := query(ctx, sqlQuery, args...)
 	if err != nil {
 		return fmt.Errorf("error executing SQL: %v", err)
 	}
 	defer rows.Close()
 
-	columns, err := rows.Columns()
+	types, err := rows.ColumnTypes()
 	if err != nil {
 		return fmt.Errorf("error getting columns: %v", err)
 	}
-
-	// Print header
-	fmt.Println(strings.Join(columns, " | "))
-
-	// Print separator
-	separator := ""
-	for range columns {
-		separator += "--- | "
+	columns := make([]sqlColumn, len(types))
+	for i, columnType := range types {
+		columns[i] = sqlColumn{name: columnType.Name(), typeName: columnType.DatabaseTypeName()}
+	}
+	result, err := newSQLResult(w, output, columns)
+	if err != nil {
+		return err
 	}
-	fmt.Println(separator[:len(separator)-3])
 
-	// Print data
 	values := make([]interface{}, len(columns))
 	valuePtrs := make([]interface{}, len(columns))
 	for i := range values {
@@ -296,22 +1718,49 @@ func (d *Database) ExecuteSQL(sqlQuery string) error {
 	}
 
 	for rows.Next() {
-		err := rows.Scan(valuePtrs...)
-		if err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return fmt.Errorf("error reading row: %v", err)
 		}
-
-		row := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				row[i] = "NULL"
-			} else {
-				row[i] = fmt.Sprintf("%v", val)
-			}
+		if err := result.writeRow(values); err != nil {
+			return fmt.Errorf("error writing row: %v", err)
 		}
-		fmt.Println(strings.Join(row, " | "))
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return result.close()
+}
 
-	return nil
+// LanguageUsage sums up the source files of the host below root, or of all
+// its files if root is empty, per language, languages with the most lines of
+// code first
+func (d *Database) LanguageUsage(ctx context.Context, root string) ([]models.LanguageUsage, error) {
+	condition, args := "language IS NOT NULL", []interface{}(nil)
+	if root != "" {
+		condition += " AND (path = ? OR starts_with(path, ?))"
+		args = append(args, root, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+	}
+	condition, args = d.hostScope(condition, args)
+	rows, err := d.query(ctx, `
+		SELECT language, COUNT(*), COALESCE(SUM(line_count), 0), COALESCE(SUM(code_lines), 0),
+			COALESCE(SUM(comment_lines), 0), COALESCE(SUM(file_size), 0)
+		FROM files`+whereCondition(condition)+`
+		GROUP BY language
+		ORDER BY 4 DESC, language
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error summing up languages: %v", err)
+	}
+	defer rows.Close()
+
+	var usage []models.LanguageUsage
+	for rows.Next() {
+		var entry models.LanguageUsage
+		if err := rows.Scan(&entry.Language, &entry.FileCount, &entry.Lines, &entry.CodeLines, &entry.CommentLines, &entry.TotalSize); err != nil {
+			return nil, fmt.Errorf("error reading languages: %v", err)
+		}
+		entry.BlankLines = entry.Lines - entry.CodeLines - entry.CommentLines
+		usage = append(usage, entry)
+	}
+	return usage, rows.Err()
 }
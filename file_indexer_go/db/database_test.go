@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// testTime is the modification and indexing time of the files of the tests
+var testTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// openTestDatabase creates a DuckDB index in a temporary directory, which
+// stores files without a host for the machine "local"
+func openTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	d := NewDatabase()
+	d.localHost = "local"
+	if err := d.Init(context.Background(), filepath.Join(t.TempDir(), "index.db")); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// testFile returns the record of an indexed file of a host
+func testFile(host, path string, size int64) models.FileInfo {
+	return models.FileInfo{
+		Path:                 path,
+		Filename:             filepath.Base(path),
+		Checksum:             "checksum of " + path,
+		ModificationDateTime: testTime,
+		FileSize:             size,
+		IndexedAt:            testTime,
+		Host:                 host,
+	}
+}
+
+func TestHostScope(t *testing.T) {
+	ctx := context.Background()
+	d := openTestDatabase(t)
+	files := []models.FileInfo{
+		testFile("a", "/data/x.txt", 10),
+		testFile("b", "/data/x.txt", 20),
+		testFile("a", "/data/y.jpg", 5),
+	}
+	if err := d.InsertFiles(ctx, files); err != nil {
+		t.Fatalf("InsertFiles: %v", err)
+	}
+	if count, err := d.CountFiles(ctx); err != nil || count != 3 {
+		t.Errorf("CountFiles over all hosts = %d, %v, want 3", count, err)
+	}
+
+	// Queries of a shared index are scoped to the host it was opened for
+	d.host = "a"
+	if count, err := d.CountFiles(ctx); err != nil || count != 2 {
+		t.Errorf("CountFiles of host a = %d, %v, want 2", count, err)
+	}
+	stats, err := d.GetStats(ctx)
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats["total_files"] != 2 || stats["total_size"] != int64(15) {
+		t.Errorf("GetStats of host a counted %v files of %v bytes, want 2 of 15", stats["total_files"], stats["total_size"])
+	}
+	if types, _ := stats["file_types"].(map[string]int); types[".txt"] != 1 {
+		t.Errorf("GetStats of host a has file types %v, want one text file", stats["file_types"])
+	}
+	file, err := d.GetFileByPathAndFilename(ctx, "/data/x.txt", "x.txt")
+	if err != nil || file == nil || file.Host != "a" || file.FileSize != 10 {
+		t.Errorf("GetFileByPathAndFilename on host a = %+v, %v", file, err)
+	}
+}
+
+func TestInsertFileHost(t *testing.T) {
+	ctx := context.Background()
+	d := openTestDatabase(t)
+	if err := d.InsertFile(ctx, testFile("", "/data/x.txt", 1)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	// Inserting a file again replaces its record
+	if err := d.InsertFile(ctx, testFile("", "/data/x.txt", 2)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	files, err := d.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 1 || files[0].Host != "local" || files[0].FileSize != 2 {
+		t.Errorf("ListFiles = %+v, want the file of the local host", files)
+	}
+}
+
+func TestUpdateFileIdentity(t *testing.T) {
+	ctx := context.Background()
+	d := openTestDatabase(t)
+	file := testFile("", "/data/x.txt", 6)
+	file.Content = "hello\n"
+	file.MimeType = "text/plain"
+	file.Inode = 1
+	if err := d.InsertFile(ctx, file); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+
+	file.Inode, file.LinkCount = 2, 2
+	file.Content, file.MimeType, file.Checksum = "", "", ""
+	if err := d.UpdateFileIdentity(ctx, file); err != nil {
+		t.Fatalf("UpdateFileIdentity: %v", err)
+	}
+	stored, err := d.GetFileByPathAndFilename(ctx, file.Path, file.Filename)
+	if err != nil || stored == nil {
+		t.Fatalf("GetFileByPathAndFilename = %v, %v", stored, err)
+	}
+	if stored.Inode != 2 || stored.LinkCount != 2 {
+		t.Errorf("stored inode %d and link count %d, want 2 and 2", stored.Inode, stored.LinkCount)
+	}
+	if stored.Checksum != "checksum of /data/x.txt" || stored.MimeType != "text/plain" {
+		t.Errorf("UpdateFileIdentity changed the rest of the record: %+v", stored)
+	}
+	if content, err := d.FileContent(ctx, file.Path); err != nil || content != "hello\n" {
+		t.Errorf("FileContent = %q, %v, want the content kept", content, err)
+	}
+}
+
+func TestFileContent(t *testing.T) {
+	ctx := context.Background()
+	d := openTestDatabase(t)
+	withContent := testFile("", "/data/x.txt", 6)
+	withContent.Content = "hello\n"
+	if err := d.InsertFiles(ctx, []models.FileInfo{withContent, testFile("", "/data/y.bin", 6)}); err != nil {
+		t.Fatalf("InsertFiles: %v", err)
+	}
+	for path, want := range map[string]string{"/data/x.txt": "hello\n", "/data/y.bin": "", "/data/missing": ""} {
+		if content, err := d.FileContent(ctx, path); err != nil || content != want {
+			t.Errorf("FileContent(%s) = %q, %v, want %q", path, content, err, want)
+		}
+	}
+	contents, err := d.FileContents(ctx)
+	if err != nil || len(contents) != 1 || contents["local:/data/x.txt"] != "hello\n" {
+		t.Errorf("FileContents = %v, %v, want the content of x.txt", contents, err)
+	}
+}
+
+func TestActions(t *testing.T) {
+	ctx := context.Background()
+	d := openTestDatabase(t)
+	records := []models.ActionRecord{
+		{PerformedAt: testTime.Add(time.Minute), Action: "delete", Path: "/data/b.txt", Original: "/data/a.txt", Checksum: "c", FileSize: 1, Host: "a", RunID: "run", Trash: "/trash/run"},
+		{PerformedAt: testTime, Action: "hardlink", Path: "/data/c.txt", Original: "/data/a.txt", Checksum: "c", FileSize: 1, Host: "a", RunID: "run"},
+		{PerformedAt: testTime, Action: "symlink", Path: "/data/d.txt", Original: "/data/a.txt", Checksum: "c", FileSize: 1, Host: "b"},
+	}
+	for _, record := range records {
+		if err := d.RecordAction(ctx, record); err != nil {
+			t.Fatalf("RecordAction: %v", err)
+		}
+	}
+	all, err := d.ListActions(ctx)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListActions = %+v, %v, want all three", all, err)
+	}
+
+	d.host = "a"
+	listed, err := d.ListActions(ctx)
+	if err != nil {
+		t.Fatalf("ListActions: %v", err)
+	}
+	if len(listed) != 2 || listed[0].Path != "/data/c.txt" || listed[1].Path != "/data/b.txt" {
+		t.Fatalf("ListActions of host a = %+v, want its two records, oldest first", listed)
+	}
+	if got := listed[1]; !got.PerformedAt.Equal(records[0].PerformedAt) || got.RunID != "run" || got.Trash != "/trash/run" || got.Original != "/data/a.txt" || got.Host != "a" {
+		t.Errorf("ListActions read %+v, want %+v", got, records[0])
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// deletionTablesSQL creates the deletion journal, with one row each time an
+// indexed file was found gone and pruned
+const deletionTablesSQL = `
+	CREATE TABLE IF NOT EXISTS deleted_files (
+		path VARCHAR NOT NULL,
+		filename VARCHAR NOT NULL,
+		checksum VARCHAR,
+		file_size BIGINT NOT NULL,
+		modification_datetime TIMESTAMP NOT NULL,
+		last_seen TIMESTAMP,
+		deleted_at TIMESTAMP NOT NULL,
+		host VARCHAR
+	);
+`
+
+// RecordDeletions journals the files of the host with the given paths as
+// found gone at deletedAt. Call it before the files are deleted.
+func (d *Database) RecordDeletions(ctx context.Context, paths []string, deletedAt time.Time) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	condition, hostArgs := d.hostScope("path = ?", nil)
+	stmt, err := tx.PrepareContext(ctx, d.rebind(`
+		INSERT INTO deleted_files (path, filename, checksum, file_size, modification_datetime, last_seen, deleted_at, host)
+		SELECT path, filename, checksum, file_size, modification_datetime, indexed_at, ?, host FROM files`+whereCondition(condition)))
+	if err != nil {
+		return fmt.Errorf("error preparing deletion journal: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, path := range paths {
+		args := append([]interface{}{deletedAt, path}, hostArgs...)
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("error journaling deletion of %s: %v", path, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing deletion journal: %v", err)
+	}
+	return nil
+}
+
+// ListDeletions returns the journaled deletions of the host that match a
+// query, oldest first
+func (d *Database) ListDeletions(ctx context.Context, query models.DeletionQuery) ([]models.DeletedFile, error) {
+	var conditions []string
+	var args []interface{}
+	if query.Dir != "" {
+		prefix := strings.TrimSuffix(query.Dir, string(filepath.Separator)) + string(filepath.Separator)
+		conditions = append(conditions, "(path = ? OR starts_with(path, ?))")
+		args = append(args, query.Dir, prefix)
+	}
+	if !query.After.IsZero() {
+		conditions = append(conditions, "deleted_at >= ?")
+		args = append(args, query.After)
+	}
+	if !query.Before.IsZero() {
+		conditions = append(conditions, "deleted_at < ?")
+		args = append(args, query.Before)
+	}
+	condition, args := d.hostScope(strings.Join(conditions, " AND "), args)
+
+	rows, err := d.query(ctx, `
+		SELECT path, filename, COALESCE(checksum, ''), file_size, modification_datetime,
+			COALESCE(last_seen, deleted_at), deleted_at, COALESCE(host, '')
+		FROM deleted_files`+whereCondition(condition)+" ORDER BY deleted_at, path", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deleted files: %v", err)
+	}
+	defer rows.Close()
+
+	var deleted []models.DeletedFile
+	for rows.Next() {
+		var file models.DeletedFile
+		err := rows.Scan(&file.Path, &file.Filename, &file.Checksum, &file.FileSize, &file.ModificationDateTime,
+			&file.LastSeen, &file.DeletedAt, &file.Host)
+		if err != nil {
+			return nil, fmt.Errorf("error reading deleted files: %v", err)
+		}
+		deleted = append(deleted, file)
+	}
+	return deleted, rows.Err()
+}
+
+// AddDeletion appends an entry to the deletion journal, as when converting
+// an index
+func (d *Database) AddDeletion(ctx context.Context, file models.DeletedFile) error {
+	_, err := d.exec(ctx, `
+		INSERT INTO deleted_files (path, filename, checksum, file_size, modification_datetime, last_seen, deleted_at, host)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		file.Path, file.Filename, nullIfEmpty(file.Checksum), file.FileSize, file.ModificationDateTime,
+		file.LastSeen, file.DeletedAt, nullIfEmpty(file.Host))
+	if err != nil {
+		return fmt.Errorf("error journaling deletion of %s: %v", file.Path, err)
+	}
+	return nil
+}
+
+// PurgeDeletions removes the journaled deletions of the host found before a
+// time and returns how many there were
+func (d *Database) PurgeDeletions(ctx context.Context, before time.Time) (int64, error) {
+	condition, args := d.hostScope("deleted_at < ?", []interface{}{before})
+	result, err := d.exec(ctx, "DELETE FROM deleted_files"+whereCondition(condition), args...)
+	if err != nil {
+		return 0, fmt.Errorf("error purging deleted files: %v", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error purging deleted files: %v", err)
+	}
+	return purged, nil
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// ftsSchema is the schema in which DuckDB's fts extension keeps the
+// full-text index of the files table
+const ftsSchema = "fts_main_files"
+
+// Errors of ranked content searches
+var (
+	ErrNoFullText     = errors.New("ranked content search requires DuckDB's fts extension, which is not installed; run a scan with -content while online to install it")
+	ErrNoContentIndex = errors.New("the index has no full-text index of file contents; run index -content to build it")
+)
+
+// loadFullText loads the fts extension if it is installed, so full-text
+// indexes built before can be queried. Nothing is downloaded, so opening an
+// index works offline; installing is left to BuildContentIndex.
+func (d *Database) loadFullText(ctx context.Context) {
+	if d.postgres {
+		return
+	}
+	_, err := d.db.ExecContext(ctx, "LOAD fts")
+	d.fullText = err == nil
+}
+
+// BuildContentIndex rebuilds the full-text index of the stored contents of
+// files, with Porter stemming and ignoring English stop words, installing the
+// fts extension first if needed. DuckDB's full-text indexes do not follow
+// changes to their table, so the index is rebuilt after scans that store
+// contents. It returns ErrNoFullText if the extension cannot be installed.
+func (d *Database) BuildContentIndex(ctx context.Context) error {
+	if d.postgres {
+		return fmt.Errorf("ranked content search requires the DuckDB backend")
+	}
+	if !d.fullText {
+		if _, err := d.db.ExecContext(ctx, "INSTALL fts"); err != nil {
+			return ErrNoFullText
+		}
+		if _, err := d.db.ExecContext(ctx, "LOAD fts"); err != nil {
+			return ErrNoFullText
+		}
+		d.fullText = true
+	}
+	// Paths are unique in DuckDB indexes, which have a single host
+	_, err := d.db.ExecContext(ctx, "PRAGMA create_fts_index('files', 'path', 'content', stemmer = 'porter', stopwords = 'english', strip_accents = 1, lower = 1, overwrite = 1)")
+	if err != nil {
+		return fmt.Errorf("error building the full-text index: %v", err)
+	}
+	return nil
+}
+
+// HasContentIndex reports whether the full-text index of file contents has
+// been built and can be queried
+func (d *Database) HasContentIndex(ctx context.Context) (bool, error) {
+	if d.postgres || !d.fullText {
+		return false, nil
+	}
+	var count int
+	if err := d.queryRow(ctx, "SELECT COUNT(*) FROM duckdb_schemas() WHERE schema_name = ?", ftsSchema).Scan(&count); err != nil {
+		return false, fmt.Errorf("error looking for the full-text index: %v", err)
+	}
+	return count > 0, nil
+}
+
+// checkContentIndex fails unless the full-text index can be queried
+func (d *Database) checkContentIndex(ctx context.Context) error {
+	if !d.postgres && !d.fullText {
+		return ErrNoFullText
+	}
+	ok, err := d.HasContentIndex(ctx)
+	if err == nil && !ok {
+		err = ErrNoContentIndex
+	}
+	return err
+}
+
+// rankedFiles is the files table with the BM25 score of their contents for
+// a keyword query, NULL for files that do not match it
+const rankedFiles = "(SELECT *, " + ftsSchema + ".match_bm25(path, ?) AS score FROM files) files"
+
+// RankedSearch returns the files whose contents match the keywords of text,
+// best matches first, with the filters, limit and offset of the query.
+// Keywords are stemmed, so "indexing" also finds "indexed", and a file
+// matches if it contains any of them. Ties and files of equal score are
+// ordered by path.
+func (d *Database) RankedSearch(ctx context.Context, text string, query models.FileQuery) ([]models.RankedFile, error) {
+	if err := d.checkContentIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	where, args := whereClause("score IS NOT NULL", []interface{}{text}, query)
+	sqlQuery := "SELECT " + selectColumns("") + ", score FROM " + rankedFiles + where + " ORDER BY score DESC, path"
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	if query.Offset > 0 {
+		sqlQuery += " OFFSET ?"
+		args = append(args, query.Offset)
+	}
+
+	rows, err := d.query(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching file contents: %v", err)
+	}
+	defer rows.Close()
+
+	var files []models.RankedFile
+	for rows.Next() {
+		var ranked models.RankedFile
+		file, err := scanFile(extraColumns{rows, []interface{}{&ranked.Score}})
+		if err != nil {
+			return nil, fmt.Errorf("error reading search results: %v", err)
+		}
+		ranked.File = file
+		files = append(files, ranked)
+	}
+	return files, rows.Err()
+}
+
+// CountRanked counts the files that RankedSearch would return, ignoring the
+// query's limit and offset
+func (d *Database) CountRanked(ctx context.Context, text string, query models.FileQuery) (int64, error) {
+	if err := d.checkContentIndex(ctx); err != nil {
+		return 0, err
+	}
+
+	where, args := whereClause("score IS NOT NULL", []interface{}{text}, query)
+	var count int64
+	if err := d.queryRow(ctx, "SELECT COUNT(*) FROM "+rankedFiles+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting files: %v", err)
+	}
+	return count, nil
+}
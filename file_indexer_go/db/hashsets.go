@@ -0,0 +1,252 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+// hashSetTablesSQL creates the known hash sets, such as the NSRL or a
+// blocklist, and their checksums. They are shared by all hosts and kept when
+// the files are cleared.
+const hashSetTablesSQL = `
+	CREATE TABLE IF NOT EXISTS hash_sets (
+		name VARCHAR PRIMARY KEY,
+		kind VARCHAR NOT NULL,
+		source VARCHAR,
+		imported_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS known_hashes (
+		set_name VARCHAR NOT NULL,
+		algorithm VARCHAR NOT NULL,
+		checksum VARCHAR NOT NULL,
+		PRIMARY KEY (algorithm, checksum, set_name)
+	);
+`
+
+// knownHashBatchRows is the number of checksums inserted per statement in
+// PostgreSQL, which keeps statements below its limit of 65535 parameters
+const knownHashBatchRows = 1000
+
+// CreateHashSet records a known hash set, replacing the set of the same name
+// and its checksums
+func (d *Database) CreateHashSet(ctx context.Context, set models.HashSet) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM known_hashes WHERE set_name = ?"), set.Name); err != nil {
+		return fmt.Errorf("error replacing hash set %s: %v", set.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM hash_sets WHERE name = ?"), set.Name); err != nil {
+		return fmt.Errorf("error replacing hash set %s: %v", set.Name, err)
+	}
+	_, err = tx.ExecContext(ctx, d.rebind("INSERT INTO hash_sets (name, kind, source, imported_at) VALUES (?, ?, ?, ?)"),
+		set.Name, string(set.Kind), nullIfEmpty(set.Source), set.ImportedAt)
+	if err != nil {
+		return fmt.Errorf("error creating hash set %s: %v", set.Name, err)
+	}
+	return tx.Commit()
+}
+
+// AddKnownHashes adds checksums to a hash set created with CreateHashSet.
+// Checksums the set already has are skipped. In DuckDB they are bulk-loaded
+// with the appender, like scanned files.
+func (d *Database) AddKnownHashes(ctx context.Context, set string, hashes []models.KnownHash) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	var err error
+	if d.postgres {
+		err = d.addKnownHashesPostgres(ctx, set, hashes)
+	} else {
+		err = d.addKnownHashesDuckDB(ctx, set, hashes)
+	}
+	if err != nil {
+		return fmt.Errorf("error adding checksums to hash set %s: %v", set, err)
+	}
+	return nil
+}
+
+// addKnownHashesDuckDB appends checksums to a staging table and moves the
+// new ones to known_hashes
+func (d *Database) addKnownHashesDuckDB(ctx context.Context, set string, hashes []models.KnownHash) error {
+	// Temporary tables are per connection, so pin one for the whole operation
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "CREATE TEMP TABLE IF NOT EXISTS known_hashes_staging AS SELECT * FROM known_hashes LIMIT 0"); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "DELETE FROM known_hashes_staging"); err != nil {
+		return err
+	}
+	err = conn.Raw(func(driverConn interface{}) error {
+		appender, err := duckdb.NewAppenderFromConn(driverConn.(driver.Conn), "", "known_hashes_staging")
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := appender.AppendRow(set, hash.Algorithm, hash.Checksum); err != nil {
+				appender.Close()
+				return err
+			}
+		}
+		return appender.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, `INSERT INTO known_hashes (set_name, algorithm, checksum)
+		SELECT DISTINCT set_name, algorithm, checksum FROM known_hashes_staging
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "DELETE FROM known_hashes_staging")
+	return err
+}
+
+// addKnownHashesPostgres inserts checksums with multi-row INSERTs in a single
+// transaction
+func (d *Database) addKnownHashesPostgres(ctx context.Context, set string, hashes []models.KnownHash) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(hashes); start += knownHashBatchRows {
+		batch := hashes[start:min(start+knownHashBatchRows, len(hashes))]
+		values := make([]string, len(batch))
+		var args []interface{}
+		for n, hash := range batch {
+			values[n] = "(?, ?, ?)"
+			args = append(args, set, hash.Algorithm, hash.Checksum)
+		}
+		insertSQL := "INSERT INTO known_hashes (set_name, algorithm, checksum) VALUES " + strings.Join(values, ", ") + " ON CONFLICT DO NOTHING"
+		if _, err := tx.ExecContext(ctx, d.rebind(insertSQL), args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListHashSets returns the known hash sets with their numbers of checksums,
+// ordered by name
+func (d *Database) ListHashSets(ctx context.Context) ([]models.HashSet, error) {
+	rows, err := d.query(ctx, `
+		SELECT s.name, s.kind, s.source, s.imported_at, COUNT(k.checksum)
+		FROM hash_sets s
+		LEFT JOIN known_hashes k ON k.set_name = s.name
+		GROUP BY s.name, s.kind, s.source, s.imported_at
+		ORDER BY s.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing hash sets: %v", err)
+	}
+	defer rows.Close()
+
+	var sets []models.HashSet
+	for rows.Next() {
+		var set models.HashSet
+		var kind string
+		var source sql.NullString
+		if err := rows.Scan(&set.Name, &kind, &source, &set.ImportedAt, &set.Hashes); err != nil {
+			return nil, fmt.Errorf("error reading hash sets: %v", err)
+		}
+		set.Kind, set.Source = models.HashSetKind(kind), source.String
+		sets = append(sets, set)
+	}
+	return sets, rows.Err()
+}
+
+// RemoveHashSet removes a known hash set and its checksums and reports
+// whether it existed
+func (d *Database) RemoveHashSet(ctx context.Context, name string) (bool, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM known_hashes WHERE set_name = ?"), name); err != nil {
+		return false, fmt.Errorf("error removing hash set %s: %v", name, err)
+	}
+	result, err := tx.ExecContext(ctx, d.rebind("DELETE FROM hash_sets WHERE name = ?"), name)
+	if err != nil {
+		return false, fmt.Errorf("error removing hash set %s: %v", name, err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error removing hash set %s: %v", name, err)
+	}
+	return removed > 0, tx.Commit()
+}
+
+// KnownHashMatches returns the files whose checksum, of the index's
+// algorithm or another one stored besides it, is in a known hash set of a
+// kind (empty = any), ordered by path and set. A file in several sets is
+// returned once per set.
+func (d *Database) KnownHashMatches(ctx context.Context, algorithm string, kind models.HashSetKind) ([]models.KnownHashMatch, error) {
+	rows, err := d.query(ctx, `
+		WITH file_hashes AS (
+			SELECT path, host, CAST(? AS VARCHAR) AS algorithm, checksum FROM files WHERE checksum IS NOT NULL AND checksum <> ''
+			UNION ALL
+			SELECT path, host, algorithm, checksum FROM file_checksums
+		),
+		matches AS (
+			SELECT h.path, h.host, s.name, s.kind, MIN(h.algorithm) AS algorithm
+			FROM file_hashes h
+			JOIN known_hashes k ON k.algorithm = h.algorithm AND k.checksum = h.checksum
+			JOIN hash_sets s ON s.name = k.set_name
+			WHERE ? = '' OR s.kind = ?
+			GROUP BY h.path, h.host, s.name, s.kind
+		)
+		SELECT `+selectColumns("f")+`, m.name, m.kind, m.algorithm
+		FROM files f
+		JOIN matches m ON m.path = f.path AND m.host IS NOT DISTINCT FROM f.host
+		ORDER BY f.path, m.name
+	`, algorithm, string(kind), string(kind))
+	if err != nil {
+		return nil, fmt.Errorf("error matching known hashes: %v", err)
+	}
+	defer rows.Close()
+
+	var matches []models.KnownHashMatch
+	for rows.Next() {
+		var match models.KnownHashMatch
+		var matchKind string
+		file, err := scanFile(extraColumns{rows, []interface{}{&match.Set, &matchKind, &match.Algorithm}})
+		if err != nil {
+			return nil, fmt.Errorf("error reading known hash matches: %v", err)
+		}
+		match.File, match.Kind = file, models.HashSetKind(matchKind)
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// extraColumns scans the columns selected after those of a file into dest
+type extraColumns struct {
+	row  rowScanner
+	dest []interface{}
+}
+
+// Scan implements rowScanner
+func (e extraColumns) Scan(dest ...interface{}) error {
+	return e.row.Scan(append(dest, e.dest...)...)
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// historyTablesSQL creates the tables used in history mode. Each scan of a
+// root gets a row in scans, and file_changes journals the files it added,
+// removed or modified, so the state after any scan can be reconstructed.
+const historyTablesSQL = `
+	CREATE TABLE IF NOT EXISTS scans (
+		scan_id BIGINT PRIMARY KEY,
+		root VARCHAR NOT NULL,
+		scanned_at TIMESTAMP NOT NULL,
+		file_count BIGINT NOT NULL,
+		total_size BIGINT NOT NULL,
+		host VARCHAR
+	);
+
+	CREATE TABLE IF NOT EXISTS file_changes (
+		scan_id BIGINT NOT NULL,
+		path VARCHAR NOT NULL,
+		change VARCHAR NOT NULL,
+		checksum VARCHAR,
+		file_size BIGINT,
+		modification_datetime TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_file_changes_path ON file_changes(path);
+`
+
+// recordChangesSQL journals the differences between the files under a root
+// and their state after the previous scans, as replayed from file_changes.
+// The conditions select the journal entries and the files below the root.
+const recordChangesSQL = `
+	INSERT INTO file_changes (scan_id, path, change, checksum, file_size, modification_datetime)
+	WITH previous AS (
+		SELECT path, checksum, file_size, modification_datetime
+		FROM (
+			SELECT *, row_number() OVER (PARTITION BY path ORDER BY scan_id DESC) AS latest
+			FROM file_changes
+			WHERE %s
+		) AS journal
+		WHERE latest = 1 AND change <> 'removed'
+	), scanned AS (
+		SELECT path, checksum, file_size, modification_datetime
+		FROM files
+		WHERE %s
+	)
+	SELECT CAST(? AS BIGINT), c.path, CASE WHEN p.path IS NULL THEN 'added' ELSE 'modified' END,
+		c.checksum, c.file_size, c.modification_datetime
+	FROM scanned c LEFT JOIN previous p ON p.path = c.path
+	WHERE p.path IS NULL
+		OR p.file_size <> c.file_size
+		OR p.modification_datetime <> c.modification_datetime
+		OR p.checksum IS DISTINCT FROM c.checksum
+	UNION ALL
+	SELECT CAST(? AS BIGINT), p.path, 'removed', p.checksum, p.file_size, p.modification_datetime
+	FROM previous p LEFT JOIN scanned c ON c.path = p.path
+	WHERE c.path IS NULL
+`
+
+// RecordScan stores a snapshot of the files under root: a new scan entry and
+// journal entries for everything that changed since the root's previous scan
+func (d *Database) RecordScan(ctx context.Context, root string, scannedAt time.Time) (models.ScanSnapshot, error) {
+	snapshot := models.ScanSnapshot{Root: root, ScannedAt: scannedAt}
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return snapshot, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Hosts sharing an index take turns allocating scan ids
+	if d.postgres {
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE scans IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return snapshot, fmt.Errorf("error locking scans: %v", err)
+		}
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(scan_id), 0) + 1 FROM scans").Scan(&snapshot.ID); err != nil {
+		return snapshot, fmt.Errorf("error allocating scan id: %v", err)
+	}
+
+	journal, journalArgs := "path = ? OR starts_with(path, ?)", []interface{}{root, prefix}
+	if d.host != "" {
+		journal = "(" + journal + ") AND scan_id IN (SELECT scan_id FROM scans WHERE host = ?)"
+		journalArgs = append(journalArgs, d.host)
+	}
+	files, fileArgs := d.hostScope("path = ? OR starts_with(path, ?)", []interface{}{root, prefix})
+	args := append(append(journalArgs, fileArgs...), snapshot.ID, snapshot.ID)
+	_, err = tx.ExecContext(ctx, d.rebind(fmt.Sprintf(recordChangesSQL, journal, files)), args...)
+	if err != nil {
+		return snapshot, fmt.Errorf("error recording changes of scan %d: %v", snapshot.ID, err)
+	}
+
+	err = tx.QueryRowContext(ctx, d.rebind("SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM files"+whereCondition(files)), fileArgs...).
+		Scan(&snapshot.FileCount, &snapshot.TotalSize)
+	if err != nil {
+		return snapshot, fmt.Errorf("error summarizing %s: %v", root, err)
+	}
+
+	_, err = tx.ExecContext(ctx, d.rebind("INSERT INTO scans (scan_id, root, scanned_at, file_count, total_size, host) VALUES (?, ?, ?, ?, ?, ?)"),
+		snapshot.ID, root, scannedAt, snapshot.FileCount, snapshot.TotalSize, nullIfEmpty(d.host))
+	if err != nil {
+		return snapshot, fmt.Errorf("error recording scan %d: %v", snapshot.ID, err)
+	}
+
+	err = tx.QueryRowContext(ctx, d.rebind(`
+		SELECT COUNT(*) FILTER (WHERE change = 'added'),
+			COUNT(*) FILTER (WHERE change = 'removed'),
+			COUNT(*) FILTER (WHERE change = 'modified')
+		FROM file_changes
+		WHERE scan_id = ?
+	`), snapshot.ID).Scan(&snapshot.Added, &snapshot.Removed, &snapshot.Modified)
+	if err != nil {
+		return snapshot, fmt.Errorf("error counting changes of scan %d: %v", snapshot.ID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return snapshot, fmt.Errorf("error committing scan %d: %v", snapshot.ID, err)
+	}
+	return snapshot, nil
+}
+
+// ListScans returns all recorded scans of the host with their change counts
+func (d *Database) ListScans(ctx context.Context) ([]models.ScanSnapshot, error) {
+	where, args := "", []interface{}{}
+	if d.host != "" {
+		where, args = " WHERE s.host = ?", append(args, d.host)
+	}
+	rows, err := d.query(ctx, `
+		SELECT s.scan_id, s.root, s.scanned_at, s.file_count, s.total_size,
+			COUNT(*) FILTER (WHERE c.change = 'added'),
+			COUNT(*) FILTER (WHERE c.change = 'removed'),
+			COUNT(*) FILTER (WHERE c.change = 'modified')
+		FROM scans s
+		LEFT JOIN file_changes c ON c.scan_id = s.scan_id`+where+`
+		GROUP BY s.scan_id, s.root, s.scanned_at, s.file_count, s.total_size
+		ORDER BY s.scan_id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing scans: %v", err)
+	}
+	defer rows.Close()
+
+	var scans []models.ScanSnapshot
+	for rows.Next() {
+		var scan models.ScanSnapshot
+		err := rows.Scan(&scan.ID, &scan.Root, &scan.ScannedAt, &scan.FileCount, &scan.TotalSize,
+			&scan.Added, &scan.Removed, &scan.Modified)
+		if err != nil {
+			return nil, fmt.Errorf("error reading scans: %v", err)
+		}
+		scans = append(scans, scan)
+	}
+	return scans, rows.Err()
+}
+
+// ListChanges returns the journal entries of the host selected by a query,
+// oldest first
+func (d *Database) ListChanges(ctx context.Context, query models.HistoryQuery) ([]models.HistoryChange, error) {
+	var conditions []string
+	var args []interface{}
+	if d.host != "" {
+		conditions = append(conditions, "s.host = ?")
+		args = append(args, d.host)
+	}
+	if query.ScanID > 0 {
+		conditions = append(conditions, "s.scan_id = ?")
+		args = append(args, query.ScanID)
+	}
+	if !query.After.IsZero() {
+		conditions = append(conditions, "s.scanned_at >= ?")
+		args = append(args, query.After)
+	}
+	if !query.Before.IsZero() {
+		conditions = append(conditions, "s.scanned_at < ?")
+		args = append(args, query.Before)
+	}
+
+	sqlQuery := `
+		SELECT c.scan_id, s.scanned_at, c.path, c.change, c.checksum, c.file_size, c.modification_datetime
+		FROM file_changes c
+		JOIN scans s ON s.scan_id = c.scan_id`
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY c.scan_id, c.path"
+
+	rows, err := d.query(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing changes: %v", err)
+	}
+	defer rows.Close()
+
+	var changes []models.HistoryChange
+	for rows.Next() {
+		var change models.HistoryChange
+		var checksum sql.NullString
+		var size sql.NullInt64
+		var mtime sql.NullTime
+		err := rows.Scan(&change.ScanID, &change.ScannedAt, &change.Path, &change.Change, &checksum, &size, &mtime)
+		if err != nil {
+			return nil, fmt.Errorf("error reading changes: %v", err)
+		}
+		change.Checksum = checksum.String
+		change.FileSize = size.Int64
+		change.ModificationDateTime = mtime.Time
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
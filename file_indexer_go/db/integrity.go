@@ -0,0 +1,334 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// expectedTables are the tables Init creates
+var expectedTables = []string{
+	"files", "index_metadata", "roots", "scans", "file_changes", "file_xattrs", "file_checksums",
+	"file_secrets", "actions", "tags", "deleted_files", "hash_sets", "known_hashes", "stats_snapshots",
+}
+
+// integrityExamples is the number of rows a failed check lists
+const integrityExamples = 5
+
+// futureSlack is how far in the future a time may be before it counts as
+// impossible, which tolerates clocks of scanning machines that are a bit off
+const futureSlack = 24 * time.Hour
+
+// rowCheck is an integrity check that counts the rows of a table matching a
+// condition, listing some of them by the example column
+type rowCheck struct {
+	name      string
+	table     string
+	condition string
+	example   string
+	status    models.HealthStatus // of a check that finds rows
+	ok        string
+	found     string // with the number of rows found
+	repair    string
+}
+
+// CheckIntegrity checks the schema and the data of the whole database, of
+// all hosts: tables and columns, the schema version, NULLs and impossible
+// values, rows left behind by deleted files and metadata that does not match
+// the files. checksumLengths maps the known checksum algorithms to the length
+// of their hex checksums. Problems are reported as checks, each with repair
+// guidance; the error is only for a database that cannot be read at all.
+func (d *Database) CheckIntegrity(ctx context.Context, checksumLengths map[string]int) (models.HealthReport, error) {
+	report := models.HealthReport{SchemaVersion: SchemaVersion(), Healthy: true}
+	add := func(check models.HealthCheck) {
+		if check.Status == models.HealthError {
+			report.Healthy = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	stored, _, err := d.GetMetadata(ctx, "schema_version")
+	if err != nil {
+		return report, err
+	}
+	report.StoredSchemaVersion, _ = strconv.Atoi(stored)
+	add(schemaVersionCheck(report.StoredSchemaVersion))
+
+	tables, err := d.columnNames(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()")
+	if err != nil {
+		return report, err
+	}
+	var missing []string
+	for _, table := range expectedTables {
+		if !slices.Contains(tables, table) {
+			missing = append(missing, table)
+		}
+	}
+	add(missingCheck("tables", "table", missing, "Open the database once without -read-only, which creates the missing tables"))
+	if !slices.Contains(tables, "files") {
+		return report, nil
+	}
+
+	columns, err := d.columnNames(ctx, "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'files'")
+	if err != nil {
+		return report, err
+	}
+	missing = nil
+	for _, column := range append(slices.Clone(fileColumns), "content") {
+		if !slices.Contains(columns, column) {
+			missing = append(missing, column)
+		}
+	}
+	add(missingCheck("file_columns", "column of the files table", missing, "Open the database once without -read-only, which migrates the files table"))
+	if len(missing) > 0 {
+		return report, nil
+	}
+
+	future := time.Now().Add(futureSlack)
+	separator := string(filepath.Separator)
+	below := "(f.path = r.path OR starts_with(f.path, rtrim(r.path, '" + separator + "') || '" + separator + "')) AND (r.host IS NULL OR f.host = r.host)"
+	for _, check := range []rowCheck{
+		{
+			name: "file_values", table: "files", example: "COALESCE(path, '(no path)')", status: models.HealthError,
+			condition: "path IS NULL OR path = '' OR filename IS NULL OR filename = '' OR modification_datetime IS NULL OR file_size IS NULL OR indexed_at IS NULL",
+			ok:        "Every file has its path, name, size and times",
+			found:     "%d files lack their path, name, size, modification or index time",
+			repair:    "Delete them with sql, e.g. DELETE FROM files WHERE file_size IS NULL, and index their roots again",
+		},
+		{
+			name: "duplicate_paths", table: "files", example: "path", status: models.HealthError,
+			condition: "path IN (SELECT path FROM files GROUP BY path, host HAVING COUNT(*) > 1)",
+			ok:        "Every path is indexed once per host",
+			found:     "%d rows share their path with another row of the same host",
+			repair:    "Delete the rows of those paths with sql and index their roots again",
+		},
+		{
+			name: "negative_sizes", table: "files", example: "path", status: models.HealthError,
+			condition: "file_size < 0",
+			ok:        "No file has a negative size",
+			found:     "%d files have a negative size",
+			repair:    "Index their roots again: scans re-read files whose stored size differs from the one on disk",
+		},
+		{
+			name: "future_modification_times", table: "files", example: "path", status: models.HealthWarning,
+			condition: "modification_datetime > ?",
+			ok:        "No file was modified in the future",
+			found:     "%d files have a modification time more than a day in the future",
+			repair:    "Correct the clock of the machine that wrote them, then fix their times with touch and index them again",
+		},
+		{
+			name: "future_index_times", table: "files", example: "path", status: models.HealthWarning,
+			condition: "indexed_at > ?",
+			ok:        "No file was indexed in the future",
+			found:     "%d files have an index time more than a day in the future",
+			repair:    "Correct the clock of the scanning machine and index the roots again",
+		},
+		{
+			name: "root_totals", table: "roots r", example: "path", status: models.HealthWarning,
+			condition: "file_count < 0 OR total_size < 0 OR file_count <> (SELECT COUNT(*) FROM files f WHERE " + below + ")" +
+				" OR total_size <> (SELECT COALESCE(SUM(file_size), 0) FROM files f WHERE " + below + ")",
+			ok:     "The totals of every root match its files",
+			found:  "%d roots have file counts or sizes that differ from their files, e.g. after dedupe or watch",
+			repair: "Index the roots again to recount them",
+		},
+		{
+			name: "orphaned_checksums", table: "file_checksums t", example: "path", status: models.HealthWarning,
+			condition: "NOT EXISTS (SELECT 1 FROM files f WHERE f.path = t.path AND f.host IS NOT DISTINCT FROM t.host)",
+			ok:        "Every extra checksum belongs to an indexed file",
+			found:     "%d extra checksums belong to files that are no longer indexed",
+			repair:    "Delete them with sql \"DELETE FROM file_checksums t WHERE NOT EXISTS (SELECT 1 FROM files f WHERE f.path = t.path AND f.host IS NOT DISTINCT FROM t.host)\"",
+		},
+		{
+			name: "orphaned_xattrs", table: "file_xattrs t", example: "path", status: models.HealthWarning,
+			condition: "NOT EXISTS (SELECT 1 FROM files f WHERE f.path = t.path AND f.host IS NOT DISTINCT FROM t.host)",
+			ok:        "Every extended attribute belongs to an indexed file",
+			found:     "%d extended attributes belong to files that are no longer indexed",
+			repair:    "Delete them with sql \"DELETE FROM file_xattrs t WHERE NOT EXISTS (SELECT 1 FROM files f WHERE f.path = t.path AND f.host IS NOT DISTINCT FROM t.host)\"",
+		},
+		{
+			name: "orphaned_secrets", table: "file_secrets t", example: "path", status: models.HealthWarning,
+			condition: "NOT EXISTS (SELECT 1 FROM files f WHERE f.path = t.path AND f.host IS NOT DISTINCT FROM t.host)",
+			ok:        "Every secrets finding belongs to an indexed file",
+			found:     "%d secrets findings belong to files that are no longer indexed",
+			repair:    "Delete them with sql \"DELETE FROM file_secrets t WHERE NOT EXISTS (SELECT 1 FROM files f WHERE f.path = t.path AND f.host IS NOT DISTINCT FROM t.host)\"",
+		},
+		{
+			name: "orphaned_changes", table: "file_changes", example: "path", status: models.HealthWarning,
+			condition: "scan_id NOT IN (SELECT scan_id FROM scans)",
+			ok:        "Every journaled change belongs to a recorded scan",
+			found:     "%d journaled changes belong to scans that are not recorded",
+			repair:    "Delete them with sql \"DELETE FROM file_changes WHERE scan_id NOT IN (SELECT scan_id FROM scans)\"",
+		},
+	} {
+		// Checks of missing tables would only fail
+		if !slices.Contains(tables, strings.Fields(check.table)[0]) {
+			continue
+		}
+		var args []interface{}
+		if check.name == "future_modification_times" || check.name == "future_index_times" {
+			args = append(args, future)
+		}
+		add(d.runRowCheck(ctx, check, args...))
+	}
+
+	for _, check := range d.checksumChecks(ctx, checksumLengths) {
+		add(check)
+	}
+	return report, nil
+}
+
+// schemaVersionCheck compares the schema version recorded in a database
+// with the one of this program
+func schemaVersionCheck(stored int) models.HealthCheck {
+	check := models.HealthCheck{Name: "schema_version", Status: models.HealthOK, Message: fmt.Sprintf("The schema is at version %d", stored)}
+	switch {
+	case stored == 0:
+		check.Status, check.Message = models.HealthWarning, "No schema version is recorded"
+		check.Repair = "Open the database once without -read-only, which records it"
+	case stored < SchemaVersion():
+		check.Status, check.Message = models.HealthWarning, fmt.Sprintf("The schema is at version %d, older than version %d of this program", stored, SchemaVersion())
+		check.Repair = "Open the database once without -read-only, which migrates it"
+	case stored > SchemaVersion():
+		check.Status, check.Message = models.HealthError, fmt.Sprintf("The schema is at version %d, newer than version %d of this program", stored, SchemaVersion())
+		check.Repair = "Upgrade file-indexer before writing to this database"
+	}
+	return check
+}
+
+// missingCheck reports the tables or columns a database lacks
+func missingCheck(name, what string, missing []string, repair string) models.HealthCheck {
+	check := models.HealthCheck{Name: name, Status: models.HealthOK, Message: fmt.Sprintf("No %s is missing", what)}
+	if len(missing) > 0 {
+		check.Status, check.Count, check.Repair, check.Examples = models.HealthError, int64(len(missing)), repair, missing
+		check.Message = fmt.Sprintf("%d %s are missing", len(missing), what+"s")
+		if len(missing) == 1 {
+			check.Message = fmt.Sprintf("1 %s is missing", what)
+		}
+	}
+	return check
+}
+
+// runRowCheck counts and lists the rows a check finds. A failing query is
+// reported as an error of the check.
+func (d *Database) runRowCheck(ctx context.Context, check rowCheck, args ...interface{}) models.HealthCheck {
+	result := models.HealthCheck{Name: check.name, Status: models.HealthOK, Message: check.ok}
+	err := d.queryRow(ctx, "SELECT COUNT(*) FROM "+check.table+" WHERE "+check.condition, args...).Scan(&result.Count)
+	if err == nil && result.Count > 0 {
+		result.Status, result.Message, result.Repair = check.status, fmt.Sprintf(check.found, result.Count), check.repair
+		result.Examples, err = d.columnNames(ctx, "SELECT "+check.example+" FROM "+check.table+" WHERE "+check.condition+
+			" ORDER BY 1 LIMIT "+strconv.Itoa(integrityExamples), args...)
+	}
+	if err != nil {
+		result.Status, result.Message = models.HealthError, fmt.Sprintf("The check failed: %v", err)
+	}
+	return result
+}
+
+// checksumChecks checks that the checksum algorithms are recorded and known
+// and that the checksums have their lengths
+func (d *Database) checksumChecks(ctx context.Context, checksumLengths map[string]int) []models.HealthCheck {
+	failed := func(name string, err error) models.HealthCheck {
+		return models.HealthCheck{Name: name, Status: models.HealthError, Message: fmt.Sprintf("The check failed: %v", err)}
+	}
+
+	algorithm := models.HealthCheck{Name: "hash_algorithm", Status: models.HealthOK}
+	var hashed int64
+	if err := d.queryRow(ctx, "SELECT COUNT(*) FROM files WHERE checksum IS NOT NULL AND checksum <> ''").Scan(&hashed); err != nil {
+		return []models.HealthCheck{failed(algorithm.Name, err)}
+	}
+	name, ok, err := d.GetMetadata(ctx, "hash_algorithm")
+	if err != nil {
+		return []models.HealthCheck{failed(algorithm.Name, err)}
+	}
+	length, known := checksumLengths[name]
+	switch {
+	case hashed > 0 && !ok:
+		algorithm.Status, algorithm.Count = models.HealthError, hashed
+		algorithm.Message = fmt.Sprintf("%d files have a checksum, but the index does not record its algorithm", hashed)
+		algorithm.Repair = "Record it with sql \"INSERT INTO index_metadata VALUES ('hash_algorithm', 'md5')\", naming the algorithm of the scans"
+	case ok && !known:
+		algorithm.Status = models.HealthError
+		algorithm.Message = fmt.Sprintf("The index records the unknown checksum algorithm %q", name)
+		algorithm.Repair = "Upgrade file-indexer, or correct hash_algorithm in the index_metadata table"
+	case ok:
+		algorithm.Message = fmt.Sprintf("The checksums are %s", name)
+	default:
+		algorithm.Message = "No file has a checksum yet"
+	}
+	checks := []models.HealthCheck{algorithm}
+
+	if known {
+		checks = append(checks, d.runRowCheck(ctx, rowCheck{
+			name: "checksum_lengths", table: "files", example: "path", status: models.HealthError,
+			condition: "checksum IS NOT NULL AND checksum <> '' AND length(checksum) <> " + strconv.Itoa(length),
+			ok:        fmt.Sprintf("Every checksum has the length of a %s checksum", name),
+			found:     "%d files have a checksum whose length is not that of a " + name + " checksum",
+			repair:    fmt.Sprintf("Clear them with sql \"UPDATE files SET checksum = NULL WHERE length(checksum) <> %d\" and hash them again with rehash -missing-only", length),
+		}))
+	}
+
+	sampled := d.runRowCheck(ctx, rowCheck{
+		name: "sample_hash_algorithm", table: "files f", example: "path", status: models.HealthError,
+		condition: "sample_hash IS NOT NULL AND NOT EXISTS (SELECT 1 FROM index_metadata m WHERE m.key = 'sample_hash_algorithm' OR m.key = f.host || ':sample_hash_algorithm')",
+		ok:        "The algorithm of every sampled hash is recorded",
+		found:     "%d files have a sampled hash, but the index does not record its algorithm",
+		repair:    "Index their roots again with the -sample-hash of their scans, which records it",
+	})
+	checks = append(checks, sampled)
+
+	rows, err := d.query(ctx, "SELECT algorithm, length(checksum), COUNT(*) FROM file_checksums GROUP BY 1, 2")
+	if err != nil {
+		return append(checks, failed("extra_checksums", err))
+	}
+	defer rows.Close()
+	extra := models.HealthCheck{Name: "extra_checksums", Status: models.HealthOK, Message: "Every extra checksum is of a known algorithm and has its length"}
+	for rows.Next() {
+		var algorithm string
+		var length int
+		var count int64
+		if err := rows.Scan(&algorithm, &length, &count); err != nil {
+			return append(checks, failed(extra.Name, err))
+		}
+		if expected, ok := checksumLengths[algorithm]; !ok || expected != length {
+			extra.Count += count
+			extra.Examples = append(extra.Examples, algorithm)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return append(checks, failed(extra.Name, err))
+	}
+	if extra.Count > 0 {
+		sort.Strings(extra.Examples)
+		extra.Examples = slices.Compact(extra.Examples)
+		extra.Status = models.HealthError
+		extra.Message = fmt.Sprintf("%d extra checksums are of an unknown algorithm or do not have its length", extra.Count)
+		extra.Repair = "Delete them with sql, e.g. DELETE FROM file_checksums WHERE algorithm = 'NAME', and index with -extra-hash again"
+	}
+	return append(checks, extra)
+}
+
+// columnNames returns the first column of the rows of a query
+func (d *Database) columnNames(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := d.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
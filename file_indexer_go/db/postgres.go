@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the pgx driver
+)
+
+// newPostgres creates the database for a PostgreSQL index shared by several
+// hosts, writing the files of host
+func newPostgres(host string) *Database {
+	return &Database{driver: "pgx", postgres: true, host: host}
+}
+
+// postgresTypes maps the column types of the DuckDB schema to PostgreSQL.
+// Timestamps keep their time zone, as the hosts sharing an index may be in
+// different ones.
+var postgresTypes = strings.NewReplacer(
+	// Kept as is, so that its TIMESTAMP is not rewritten below
+	"CURRENT_TIMESTAMP", "CURRENT_TIMESTAMP",
+	"TIMESTAMP", "TIMESTAMPTZ",
+	"UBIGINT", "BIGINT",
+	"DOUBLE", "DOUBLE PRECISION",
+)
+
+// schema adapts a statement of the DuckDB schema to the database
+func (d *Database) schema(ddl string) string {
+	if !d.postgres {
+		return ddl
+	}
+	return postgresTypes.Replace(ddl)
+}
+
+// rebind numbers the ? placeholders of a query as PostgreSQL expects.
+// Question marks in string literals are left alone.
+func (d *Database) rebind(query string) string {
+	if !d.postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var rebound strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
+
+// fileKey returns the primary key of the files table. Shared and merged
+// indexes may hold the same path for several hosts.
+func (d *Database) fileKey() string {
+	if d.postgres || d.hostKeyed {
+		return "host, path, filename"
+	}
+	return "path, filename"
+}
+
+// rootKey returns the primary key of the roots table
+func (d *Database) rootKey() string {
+	if d.postgres {
+		return "host, path"
+	}
+	return "path"
+}
+
+// postgresBatchRows is the number of files upserted per INSERT, which keeps
+// the statement below PostgreSQL's limit of 65535 parameters
+const postgresBatchRows = 1000
+
+// insertFilesPostgres upserts files with multi-row INSERTs in a single
+// transaction, as the appender is specific to DuckDB. It returns the error of
+// the driver.
+func (d *Database) insertFilesPostgres(ctx context.Context, files []models.FileInfo) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(files); start += postgresBatchRows {
+		batch := files[start:min(start+postgresBatchRows, len(files))]
+		values := make([]string, len(batch))
+		var args []interface{}
+		for n, file := range batch {
+			values[n] = fileValues
+			args = append(args, d.insertFileArgs(file)...)
+		}
+		insertSQL := "INSERT INTO files (" + strings.Join(storedColumns, ", ") + ") VALUES " + strings.Join(values, ", ") + d.upsertClause()
+		if _, err := tx.ExecContext(ctx, d.rebind(insertSQL), args...); err != nil {
+			return err
+		}
+	}
+	if err := d.replaceXAttrs(ctx, tx, files); err != nil {
+		return err
+	}
+	if err := d.replaceChecksums(ctx, tx, files); err != nil {
+		return err
+	}
+	if err := d.replaceSecrets(ctx, tx, files); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// postgresUsageSQL is the DirectoryUsage query written with PostgreSQL's
+// array functions
+const postgresUsageSQL = `
+	SELECT coalesce(array_to_string(parts[1:level], ?), '') AS dir, level, COUNT(*), SUM(file_size)
+	FROM (
+		SELECT file_size, parts, generate_series(0, least(cardinality(parts) - 1, ?)) AS level
+		FROM (
+			SELECT file_size, string_to_array(substr(path, ?), ?) AS parts
+			FROM files
+			WHERE %s
+		) AS split
+	) AS levels
+	GROUP BY dir, level
+	ORDER BY dir
+`
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+// insertRetries is the number of times a write failing with a transient
+// error is retried
+const insertRetries = 3
+
+// insertRetryDelay is the wait before the first retry, which doubles with
+// every further retry
+const insertRetryDelay = 100 * time.Millisecond
+
+// insertSplits is the number of times a batch that keeps failing is split in
+// halves, so at least 1/8 of a batch is written at once
+const insertSplits = 3
+
+// isTransient reports whether a database error may go away when the write is
+// retried, such as a failed commit, a transaction conflict or a lost
+// connection
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var duckErr *duckdb.Error
+	if errors.As(err, &duckErr) {
+		switch duckErr.Type {
+		case duckdb.ErrorTypeTransaction, duckdb.ErrorTypeIO, duckdb.ErrorTypeConnection, duckdb.ErrorTypeNetwork:
+			return true
+		}
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Transaction rollbacks such as serialization failures and
+		// deadlocks, connection exceptions, insufficient resources and
+		// server shutdowns
+		return strings.HasPrefix(pgErr.Code, "40") || strings.HasPrefix(pgErr.Code, "08") ||
+			strings.HasPrefix(pgErr.Code, "53") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// retryTransient runs write until it succeeds, fails with an error that is
+// not transient or has been retried insertRetries times, waiting twice as
+// long before each retry. Cancelling ctx stops the retries.
+func retryTransient(ctx context.Context, write func() error) error {
+	delay := insertRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := write()
+		if err == nil || attempt > insertRetries || !isTransient(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// insertChunks upserts files with retries. A batch still failing with a
+// transient error is split in halves, down to splits times, so the files
+// that can be stored are and the failure is narrowed down to the others. It
+// returns the files that could not be stored with the last error.
+func (d *Database) insertChunks(ctx context.Context, files []models.FileInfo, splits int) ([]models.FileInfo, error) {
+	err := retryTransient(ctx, func() error { return d.insertBatch(ctx, files) })
+	if err == nil {
+		return nil, nil
+	}
+	if splits == 0 || len(files) < 2 || !isTransient(err) || ctx.Err() != nil {
+		return files, err
+	}
+
+	half := len(files) / 2
+	failed, firstErr := d.insertChunks(ctx, files[:half], splits-1)
+	failedSecond, secondErr := d.insertChunks(ctx, files[half:], splits-1)
+	if secondErr != nil {
+		return append(failed, failedSecond...), secondErr
+	}
+	return failed, firstErr
+}
+
+// describePaths names the first few of a list of files
+func describePaths(files []models.FileInfo) string {
+	const shown = 5
+	paths := make([]string, 0, shown)
+	for _, file := range files[:min(shown, len(files))] {
+		paths = append(paths, file.Path)
+	}
+	description := strings.Join(paths, ", ")
+	if len(files) > shown {
+		description += fmt.Sprintf(" and %d more", len(files)-shown)
+	}
+	return description
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// secretTablesSQL creates the table of the secrets found in the content of
+// files, with one row per finding
+const secretTablesSQL = `
+	CREATE TABLE IF NOT EXISTS file_secrets (
+		path VARCHAR NOT NULL,
+		detector VARCHAR NOT NULL,
+		line INTEGER NOT NULL,
+		redacted VARCHAR NOT NULL,
+		host VARCHAR
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_file_secrets_path ON file_secrets(path);
+`
+
+// replaceSecrets replaces the stored findings of files whose content was
+// scanned for secrets. Files whose Secrets are nil keep their stored
+// findings. It returns the error of the driver.
+func (d *Database) replaceSecrets(ctx context.Context, q execer, files []models.FileInfo) error {
+	pathsByHost := make(map[string][]interface{})
+	var rows [][]interface{}
+	for _, file := range files {
+		if file.Secrets == nil {
+			continue
+		}
+		host := d.fileHost(file)
+		pathsByHost[host] = append(pathsByHost[host], file.Path)
+		for _, finding := range file.Secrets {
+			rows = append(rows, []interface{}{file.Path, finding.Detector, finding.Line, finding.Match, nullIfEmpty(host)})
+		}
+	}
+
+	for host, paths := range pathsByHost {
+		for start := 0; start < len(paths); start += xattrBatchRows {
+			batch := paths[start:min(start+xattrBatchRows, len(paths))]
+			deleteSQL := "DELETE FROM file_secrets WHERE host IS NOT DISTINCT FROM ? AND path IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+			if _, err := q.ExecContext(ctx, d.rebind(deleteSQL), append([]interface{}{nullIfEmpty(host)}, batch...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	for start := 0; start < len(rows); start += xattrBatchRows {
+		batch := rows[start:min(start+xattrBatchRows, len(rows))]
+		values := make([]string, len(batch))
+		var args []interface{}
+		for n, row := range batch {
+			values[n] = "(?, ?, ?, ?, ?)"
+			args = append(args, row...)
+		}
+		insertSQL := "INSERT INTO file_secrets (path, detector, line, redacted, host) VALUES " + strings.Join(values, ", ")
+		if _, err := q.ExecContext(ctx, d.rebind(insertSQL), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileSecrets returns the stored findings of the files of the host, keyed by
+// location (see models.FileInfo.Location) and in the order of their lines
+func (d *Database) FileSecrets(ctx context.Context) (map[string][]models.SecretFinding, error) {
+	condition, args := d.hostScope("", nil)
+	rows, err := d.query(ctx, "SELECT host, path, detector, line, redacted FROM file_secrets"+whereCondition(condition)+" ORDER BY path, line, detector", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets: %v", err)
+	}
+	defer rows.Close()
+
+	secrets := make(map[string][]models.SecretFinding)
+	for rows.Next() {
+		var host sql.NullString
+		var path string
+		var finding models.SecretFinding
+		if err := rows.Scan(&host, &path, &finding.Detector, &finding.Line, &finding.Match); err != nil {
+			return nil, fmt.Errorf("error reading secrets: %v", err)
+		}
+		key := location(host, path)
+		secrets[key] = append(secrets[key], finding)
+	}
+	return secrets, rows.Err()
+}
+
+// ListSecrets returns the files with findings, ordered by path, with their
+// findings in the order of their lines
+func (d *Database) ListSecrets(ctx context.Context) ([]models.FileSecrets, error) {
+	rows, err := d.query(ctx, `
+		SELECT `+selectColumns("f")+`, s.detector, s.line, s.redacted
+		FROM files f
+		JOIN file_secrets s ON s.path = f.path AND s.host IS NOT DISTINCT FROM f.host
+		ORDER BY f.host, f.path, s.line, s.detector
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing secrets: %v", err)
+	}
+	defer rows.Close()
+
+	var files []models.FileSecrets
+	for rows.Next() {
+		var finding models.SecretFinding
+		file, err := scanFile(extraColumns{rows, []interface{}{&finding.Detector, &finding.Line, &finding.Match}})
+		if err != nil {
+			return nil, fmt.Errorf("error reading secrets: %v", err)
+		}
+		if n := len(files) - 1; n >= 0 && files[n].File.Path == file.Path && files[n].File.Host == file.Host {
+			files[n].Findings = append(files[n].Findings, finding)
+			continue
+		}
+		files = append(files, models.FileSecrets{File: file, Findings: []models.SecretFinding{finding}})
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,377 @@
+package db
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+// SQLOutput is the format ExecuteSQL writes the result of a query in
+type SQLOutput string
+
+const (
+	SQLOutputTable    SQLOutput = "table"    // Aligned columns, for reading in a terminal
+	SQLOutputCSV      SQLOutput = "csv"      // RFC 4180 CSV with a header row; NULL is an empty field
+	SQLOutputJSON     SQLOutput = "json"     // An array with an object per row; NULL is null
+	SQLOutputMarkdown SQLOutput = "markdown" // A Markdown table
+)
+
+// ParseSQLOutput parses the name of an output format of ExecuteSQL
+func ParseSQLOutput(name string) (SQLOutput, error) {
+	switch output := SQLOutput(name); output {
+	case SQLOutputTable, SQLOutputCSV, SQLOutputJSON, SQLOutputMarkdown:
+		return output, nil
+	}
+	return "", fmt.Errorf("unknown SQL output format %q (supported: table, csv, json, markdown)", name)
+}
+
+// sqlColumn is a column of a query result
+type sqlColumn struct {
+	name     string
+	typeName string // the type of the database, e.g. DATE or TIMESTAMPTZ
+}
+
+// sqlResult writes the rows of a query result in an output format
+type sqlResult interface {
+	writeRow(values []interface{}) error
+	// close writes what is left after the last row
+	close() error
+}
+
+// newSQLResult returns the writer of a query result with the columns
+func newSQLResult(w io.Writer, output SQLOutput, columns []sqlColumn) (sqlResult, error) {
+	switch output {
+	case SQLOutputTable, "":
+		return &tableResult{w: w, columns: columns}, nil
+	case SQLOutputMarkdown:
+		return &tableResult{w: w, columns: columns, markdown: true}, nil
+	case SQLOutputCSV:
+		result := &csvResult{w: csv.NewWriter(w), columns: columns}
+		names := make([]string, len(columns))
+		for n, column := range columns {
+			names[n] = column.name
+		}
+		return result, result.w.Write(names)
+	case SQLOutputJSON:
+		return &jsonResult{w: bufio.NewWriter(w), columns: columns}, nil
+	}
+	return nil, fmt.Errorf("unknown SQL output format %q", output)
+}
+
+// tableResult aligns the columns of all rows, so it keeps them until close.
+// Numbers are aligned to the right.
+type tableResult struct {
+	w        io.Writer
+	columns  []sqlColumn
+	markdown bool
+	rows     [][]string
+	numbers  []bool // columns with numbers
+	text     []bool // columns with values other than numbers
+}
+
+// markdownEscaper keeps cells of Markdown tables on their line and in their
+// column
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+// tableEscaper keeps the lines of cells of tables in their column. Cells of
+// several lines take several lines of the table.
+var tableEscaper = strings.NewReplacer("\r", `\r`, "\t", `\t`)
+
+func (t *tableResult) writeRow(values []interface{}) error {
+	if t.text == nil {
+		t.numbers, t.text = make([]bool, len(t.columns)), make([]bool, len(t.columns))
+	}
+	row := make([]string, len(values))
+	for n, value := range values {
+		text, null := textValue(value, t.columns[n].typeName)
+		switch {
+		case null:
+			text = "NULL"
+		case isNumber(value, t.columns[n].typeName):
+			t.numbers[n] = true
+		default:
+			t.text[n] = true
+		}
+		row[n] = t.escape(text)
+	}
+	t.rows = append(t.rows, row)
+	return nil
+}
+
+// escape makes a value fit into a cell
+func (t *tableResult) escape(text string) string {
+	if t.markdown {
+		return markdownEscaper.Replace(text)
+	}
+	return tableEscaper.Replace(text)
+}
+
+func (t *tableResult) close() error {
+	if len(t.columns) == 0 {
+		return nil
+	}
+	if t.text == nil {
+		t.numbers, t.text = make([]bool, len(t.columns)), make([]bool, len(t.columns))
+	}
+	header := make([]string, len(t.columns))
+	widths := make([]int, len(t.columns))
+	for n, column := range t.columns {
+		header[n] = t.escape(column.name)
+		widths[n] = utf8.RuneCountInString(header[n])
+		if t.markdown {
+			// The shortest rule Markdown accepts, with the colon of numbers
+			widths[n] = max(widths[n], 4)
+		}
+	}
+	for _, row := range t.rows {
+		for n, cell := range row {
+			for _, line := range strings.Split(cell, "\n") {
+				widths[n] = max(widths[n], utf8.RuneCountInString(line))
+			}
+		}
+	}
+
+	w := bufio.NewWriter(t.w)
+	t.writeLine(w, header, widths)
+	rule := make([]string, len(t.columns))
+	for n, width := range widths {
+		switch {
+		case !t.markdown:
+			rule[n] = strings.Repeat("-", width)
+		case t.rightAligned(n):
+			rule[n] = strings.Repeat("-", width-1) + ":"
+		default:
+			rule[n] = strings.Repeat("-", width)
+		}
+	}
+	if t.markdown {
+		t.writeLine(w, rule, widths)
+	} else {
+		w.WriteString(strings.Join(rule, "-+-") + "\n")
+	}
+	for _, row := range t.rows {
+		t.writeLine(w, row, widths)
+	}
+	return w.Flush()
+}
+
+// rightAligned reports whether a column holds numbers only, besides NULL
+func (t *tableResult) rightAligned(column int) bool {
+	return t.numbers[column] && !t.text[column]
+}
+
+// writeLine writes the cells of a row padded to the widths of their columns,
+// on as many lines as its cell with the most lines has
+func (t *tableResult) writeLine(w *bufio.Writer, cells []string, widths []int) {
+	lines := make([][]string, len(cells))
+	height := 1
+	for n, cell := range cells {
+		lines[n] = strings.Split(cell, "\n")
+		height = max(height, len(lines[n]))
+	}
+	for k := 0; k < height; k++ {
+		var line strings.Builder
+		if t.markdown {
+			line.WriteString("| ")
+		}
+		for n := range cells {
+			if n > 0 {
+				line.WriteString(" | ")
+			}
+			cell := ""
+			if k < len(lines[n]) {
+				cell = lines[n][k]
+			}
+			padding := strings.Repeat(" ", widths[n]-utf8.RuneCountInString(cell))
+			if t.rightAligned(n) {
+				line.WriteString(padding + cell)
+			} else {
+				line.WriteString(cell + padding)
+			}
+		}
+		if t.markdown {
+			line.WriteString(" |")
+		}
+		w.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+	}
+}
+
+// csvResult writes the rows of a query result as CSV
+type csvResult struct {
+	w       *csv.Writer
+	columns []sqlColumn
+}
+
+func (c *csvResult) writeRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for n, value := range values {
+		record[n], _ = textValue(value, c.columns[n].typeName)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvResult) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonResult writes the rows of a query result as a JSON array of objects,
+// with the keys in the order of the columns
+type jsonResult struct {
+	w       *bufio.Writer
+	columns []sqlColumn
+	rows    int
+}
+
+func (j *jsonResult) writeRow(values []interface{}) error {
+	if j.rows == 0 {
+		j.w.WriteString("[\n  {")
+	} else {
+		j.w.WriteString(",\n  {")
+	}
+	j.rows++
+	for n, value := range values {
+		if n > 0 {
+			j.w.WriteString(", ")
+		}
+		name, _ := json.Marshal(j.columns[n].name)
+		j.w.Write(name)
+		j.w.WriteString(": ")
+		j.w.Write(jsonValue(value, j.columns[n].typeName))
+	}
+	j.w.WriteString("}")
+	return nil
+}
+
+func (j *jsonResult) close() error {
+	if len(j.columns) == 0 {
+		return nil
+	}
+	if j.rows == 0 {
+		j.w.WriteString("[]\n")
+	} else {
+		j.w.WriteString("\n]\n")
+	}
+	return j.w.Flush()
+}
+
+// textValue formats a value of a query result as text and reports whether
+// it is NULL. Timestamps are written as the database writes them, and binary
+// values that are not UTF-8 in hex.
+func textValue(value interface{}, typeName string) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, false
+	case []byte:
+		if typeName == "UUID" && len(v) == len(duckdb.UUID{}) {
+			uuid := duckdb.UUID(v)
+			return uuid.String(), false
+		}
+		if utf8.Valid(v) {
+			return string(v), false
+		}
+		return `\x` + hex.EncodeToString(v), false
+	case time.Time:
+		switch typeName {
+		case "DATE":
+			return v.Format(time.DateOnly), false
+		case "TIME":
+			return v.Format("15:04:05.999999"), false
+		case "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE":
+			return v.Format("2006-01-02 15:04:05.999999-07:00"), false
+		}
+		return v.Format("2006-01-02 15:04:05.999999"), false
+	case float64:
+		return formatFloat(v, 64), false
+	case float32:
+		return formatFloat(float64(v), 32), false
+	case fmt.Stringer:
+		// Decimals and HUGEINT sums
+		return v.String(), false
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		// Lists, maps and structs as JSON, if they have no keys JSON lacks
+		if encoded, err := json.Marshal(value); err == nil {
+			return string(encoded), false
+		}
+	}
+	return fmt.Sprint(value), false
+}
+
+// formatFloat writes floating-point numbers without an exponent, unless they
+// are very large or small
+func formatFloat(f float64, bits int) string {
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(f, 'g', -1, bits)
+	}
+	return strconv.FormatFloat(f, 'f', -1, bits)
+}
+
+// isNumber reports whether a value of a query result is a number, such as
+// the decimals PostgreSQL returns as text
+func isNumber(value interface{}, typeName string) bool {
+	switch v := value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, *big.Int, duckdb.Decimal:
+		return true
+	case string:
+		// NaN is a numeric of PostgreSQL, but no JSON number
+		_, err := strconv.ParseFloat(v, 64)
+		return typeName == "NUMERIC" && err == nil && json.Valid([]byte(v))
+	}
+	return false
+}
+
+// jsonValue encodes a value of a query result as JSON: numbers and booleans
+// as themselves, and other values as textValue formats them
+func jsonValue(value interface{}, typeName string) []byte {
+	switch v := value.(type) {
+	case nil:
+		return []byte("null")
+	case bool:
+		return []byte(strconv.FormatBool(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			break
+		}
+		return []byte(formatFloat(v, 64))
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			break
+		}
+		return []byte(formatFloat(float64(v), 32))
+	case string:
+		if isNumber(value, typeName) {
+			return []byte(v)
+		}
+	case []byte, time.Time:
+	default:
+		if isNumber(value, typeName) {
+			text, _ := textValue(value, typeName)
+			return []byte(text)
+		}
+		switch reflect.ValueOf(value).Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+			if encoded, err := json.Marshal(value); err == nil {
+				return encoded
+			}
+		}
+	}
+	text, _ := textValue(value, typeName)
+	encoded, _ := json.Marshal(text)
+	return encoded
+}
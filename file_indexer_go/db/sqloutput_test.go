@@ -0,0 +1,166 @@
+package db
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// renderSQL writes rows of a query result with the columns in an output
+// format
+func renderSQL(t *testing.T, output SQLOutput, columns []sqlColumn, rows ...[]interface{}) string {
+	t.Helper()
+	var out strings.Builder
+	result, err := newSQLResult(&out, output, columns)
+	if err != nil {
+		t.Fatalf("newSQLResult: %v", err)
+	}
+	for _, row := range rows {
+		if err := result.writeRow(row); err != nil {
+			t.Fatalf("writeRow: %v", err)
+		}
+	}
+	if err := result.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return out.String()
+}
+
+var testColumns = []sqlColumn{{name: "name", typeName: "VARCHAR"}, {name: "size", typeName: "BIGINT"}, {name: "ratio", typeName: "NUMERIC"}}
+
+func TestSQLOutputNulls(t *testing.T) {
+	rows := [][]interface{}{
+		{"a.txt", int64(10), "0.5"},
+		{nil, nil, nil},
+	}
+	tests := []struct {
+		output SQLOutput
+		want   string
+	}{
+		{SQLOutputCSV, "name,size,ratio\na.txt,10,0.5\n,,\n"},
+		{SQLOutputJSON, "[\n  {\"name\": \"a.txt\", \"size\": 10, \"ratio\": 0.5},\n  {\"name\": null, \"size\": null, \"ratio\": null}\n]\n"},
+		{SQLOutputTable, "name  | size | ratio\n------+------+------\na.txt |   10 |   0.5\nNULL  | NULL |  NULL\n"},
+	}
+	for _, tt := range tests {
+		if got := renderSQL(t, tt.output, testColumns, rows...); got != tt.want {
+			t.Errorf("%s output:\n%s\nwant:\n%s", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestSQLOutputEmpty(t *testing.T) {
+	if got, want := renderSQL(t, SQLOutputJSON, testColumns), "[]\n"; got != want {
+		t.Errorf("JSON of no rows = %q, want %q", got, want)
+	}
+	if got, want := renderSQL(t, SQLOutputCSV, testColumns), "name,size,ratio\n"; got != want {
+		t.Errorf("CSV of no rows = %q, want %q", got, want)
+	}
+	if got := renderSQL(t, SQLOutputTable, nil); got != "" {
+		t.Errorf("table of a statement without columns = %q", got)
+	}
+}
+
+func TestSQLOutputMarkdown(t *testing.T) {
+	got := renderSQL(t, SQLOutputMarkdown, []sqlColumn{{name: "a|b", typeName: "VARCHAR"}, {name: "n", typeName: "INTEGER"}},
+		[]interface{}{"one\ntwo", int32(1)},
+		[]interface{}{"x | y", int32(22)},
+	)
+	want := "| a\\|b       |    n |\n" +
+		"| ---------- | ---: |\n" +
+		"| one<br>two |    1 |\n" +
+		"| x \\| y     |   22 |\n"
+	if got != want {
+		t.Errorf("markdown output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSQLOutputMultilineCells(t *testing.T) {
+	got := renderSQL(t, SQLOutputTable, []sqlColumn{{name: "text", typeName: "VARCHAR"}, {name: "n", typeName: "INTEGER"}, {name: "tab", typeName: "VARCHAR"}},
+		[]interface{}{"first\nsecond line\r", int64(7), "a\tb"},
+	)
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("table output:\n%s\nwant a header, a rule and a row of two lines", got)
+	}
+	if !strings.HasPrefix(lines[2], "first ") || !strings.HasPrefix(lines[3], `second line\r`) {
+		t.Errorf("the lines of a cell are not on successive lines:\n%s", got)
+	}
+	// Every line has its separators at the same columns
+	separator := strings.Index(lines[0], "|")
+	for _, line := range lines[1:] {
+		if index := strings.IndexAny(line, "|+"); index != separator {
+			t.Errorf("misaligned line %q in:\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "\t") || strings.Contains(got, "\r") {
+		t.Errorf("control characters were not escaped:\n%q", got)
+	}
+}
+
+func TestSQLOutputNumbers(t *testing.T) {
+	columns := []sqlColumn{{name: "value", typeName: "NUMERIC"}}
+	tests := []struct {
+		value interface{}
+		json  string
+	}{
+		{"12.50", "12.50"},
+		{"NaN", `"NaN"`},
+		{float64(1.5), "1.5"},
+		{math.Inf(1), `"+Inf"`},
+		{int64(3), "3"},
+		{true, "true"},
+	}
+	for _, tt := range tests {
+		got := renderSQL(t, SQLOutputJSON, columns, []interface{}{tt.value})
+		if want := "[\n  {\"value\": " + tt.json + "}\n]\n"; got != want {
+			t.Errorf("JSON of %#v = %q, want %q", tt.value, got, want)
+		}
+	}
+	// Numbers in a string column stay strings
+	got := renderSQL(t, SQLOutputJSON, []sqlColumn{{name: "value", typeName: "VARCHAR"}}, []interface{}{"12"})
+	if want := "[\n  {\"value\": \"12\"}\n]\n"; got != want {
+		t.Errorf("JSON of a VARCHAR number = %q, want %q", got, want)
+	}
+	// NUMERIC strings are aligned to the right, unless the column has others
+	if got, want := renderSQL(t, SQLOutputTable, columns, []interface{}{"1.5"}, []interface{}{"10"}), "value\n-----\n  1.5\n   10\n"; got != want {
+		t.Errorf("table of numbers:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := renderSQL(t, SQLOutputTable, columns, []interface{}{"1.5"}, []interface{}{"NaN"}), "value\n-----\n1.5\nNaN\n"; got != want {
+		t.Errorf("table of numbers and text:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTextValue(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 15, 250000000, time.FixedZone("", 2*60*60))
+	tests := []struct {
+		value    interface{}
+		typeName string
+		want     string
+	}{
+		{at, "DATE", "2024-05-01"},
+		{at, "TIMESTAMP", "2024-05-01 12:30:15.25"},
+		{at, "TIMESTAMPTZ", "2024-05-01 12:30:15.25+02:00"},
+		{[]byte("text"), "BLOB", "text"},
+		{[]byte{0xff, 0x00}, "BLOB", `\xff00`},
+		{float64(1e-9), "DOUBLE", "1e-09"},
+		{float64(123456789), "DOUBLE", "123456789"},
+		{[]interface{}{"a", int32(1)}, "LIST", `["a",1]`},
+	}
+	for _, tt := range tests {
+		if got, null := textValue(tt.value, tt.typeName); got != tt.want || null {
+			t.Errorf("textValue(%#v, %s) = %q, %v, want %q", tt.value, tt.typeName, got, null, tt.want)
+		}
+	}
+}
+
+func TestParseSQLOutput(t *testing.T) {
+	for _, name := range []string{"table", "csv", "json", "markdown"} {
+		if output, err := ParseSQLOutput(name); err != nil || string(output) != name {
+			t.Errorf("ParseSQLOutput(%q) = %q, %v", name, output, err)
+		}
+	}
+	if _, err := ParseSQLOutput("xml"); err == nil {
+		t.Errorf("ParseSQLOutput accepted xml")
+	}
+}
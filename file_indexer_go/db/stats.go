@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// statsTablesSQL creates the statistics snapshots. Each refresh, which runs
+// at the end of every scan, adds the figures of the whole index taken at one
+// time, so the latest ones are shown without aggregating the files table and
+// the earlier ones can be charted.
+const statsTablesSQL = `
+	CREATE TABLE IF NOT EXISTS stats_snapshots (
+		taken_at TIMESTAMP NOT NULL,
+		kind VARCHAR NOT NULL,
+		name VARCHAR NOT NULL,
+		file_count BIGINT NOT NULL,
+		total_size BIGINT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_stats_snapshots_taken_at ON stats_snapshots(taken_at);
+`
+
+// RecordStats stores a statistics snapshot taken at takenAt
+func (d *Database) RecordStats(ctx context.Context, takenAt time.Time, entries []models.StatsEntry) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, d.rebind("INSERT INTO stats_snapshots (taken_at, kind, name, file_count, total_size) VALUES (?, ?, ?, ?, ?)"))
+	if err != nil {
+		return fmt.Errorf("error preparing statistics snapshot: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		if _, err := stmt.ExecContext(ctx, takenAt, entry.Kind, entry.Name, entry.FileCount, entry.TotalSize); err != nil {
+			return fmt.Errorf("error recording statistics snapshot: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing statistics snapshot: %v", err)
+	}
+	return nil
+}
+
+// LatestStats returns the entries of the latest statistics snapshot, or none
+// if no snapshot was taken yet
+func (d *Database) LatestStats(ctx context.Context) ([]models.StatsEntry, error) {
+	return d.listStats(ctx, `
+		SELECT taken_at, kind, name, file_count, total_size
+		FROM stats_snapshots
+		WHERE taken_at = (SELECT MAX(taken_at) FROM stats_snapshots)
+		ORDER BY kind, name
+	`)
+}
+
+// StatsHistory returns one figure of every statistics snapshot, oldest first
+func (d *Database) StatsHistory(ctx context.Context, kind, name string) ([]models.StatsEntry, error) {
+	return d.listStats(ctx, `
+		SELECT taken_at, kind, name, file_count, total_size
+		FROM stats_snapshots
+		WHERE kind = ? AND name = ?
+		ORDER BY taken_at
+	`, kind, name)
+}
+
+// listStats runs a query of statistics snapshot entries
+func (d *Database) listStats(ctx context.Context, query string, args ...interface{}) ([]models.StatsEntry, error) {
+	rows, err := d.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading statistics snapshots: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []models.StatsEntry
+	for rows.Next() {
+		var entry models.StatsEntry
+		if err := rows.Scan(&entry.TakenAt, &entry.Kind, &entry.Name, &entry.FileCount, &entry.TotalSize); err != nil {
+			return nil, fmt.Errorf("error reading statistics snapshots: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
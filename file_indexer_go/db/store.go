@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// Store is a database holding a file index. Open returns the store for a
+// DuckDB file or a PostgreSQL server.
+type Store interface {
+	Close() error
+	ClearData(ctx context.Context) error
+
+	SetMetadata(ctx context.Context, key, value string) error
+	GetMetadata(ctx context.Context, key string) (string, bool, error)
+	DeleteMetadata(ctx context.Context, key string) error
+
+	UpsertRoot(ctx context.Context, root models.RootInfo) error
+	ListRoots(ctx context.Context) ([]models.RootInfo, error)
+	SummarizePath(ctx context.Context, path string) (int64, int64, error)
+
+	InsertFile(ctx context.Context, file models.FileInfo) error
+	InsertFiles(ctx context.Context, files []models.FileInfo) error
+	UpdateFileIdentity(ctx context.Context, file models.FileInfo) error
+	DeleteFiles(ctx context.Context, path string) error
+	DeletePaths(ctx context.Context, paths []string) error
+	StalePaths(ctx context.Context, path string, before time.Time) ([]string, error)
+	ListFilesBelow(ctx context.Context, path string) ([]models.FileInfo, error)
+	FindQuickHashCollisions(ctx context.Context) ([]models.FileInfo, error)
+	FindSampleHashCollisions(ctx context.Context) ([]models.FileInfo, error)
+
+	CountFiles(ctx context.Context) (int, error)
+	ListFiles(ctx context.Context, query models.FileQuery) ([]models.FileInfo, error)
+	SearchFiles(ctx context.Context, text string, query models.FileQuery) ([]models.FileInfo, error)
+	SearchContent(ctx context.Context, text string, query models.FileQuery) ([]models.FileInfo, error)
+	BuildContentIndex(ctx context.Context) error
+	HasContentIndex(ctx context.Context) (bool, error)
+	RankedSearch(ctx context.Context, text string, query models.FileQuery) ([]models.RankedFile, error)
+	CountRanked(ctx context.Context, text string, query models.FileQuery) (int64, error)
+	CountMatches(ctx context.Context, text string, content bool, query models.FileQuery) (int64, error)
+	GetFileByPathAndFilename(ctx context.Context, path, filename string) (*models.FileInfo, error)
+	FileContent(ctx context.Context, path string) (string, error)
+	FileContents(ctx context.Context) (map[string]string, error)
+	FileXAttrs(ctx context.Context) (map[string]map[string]string, error)
+	FileChecksums(ctx context.Context) (map[string]map[string]string, error)
+	UpdateChecksums(ctx context.Context, files []models.FileInfo) error
+	PromoteChecksums(ctx context.Context, from, to string) (int64, error)
+	FileSecrets(ctx context.Context) (map[string][]models.SecretFinding, error)
+	ListSecrets(ctx context.Context) ([]models.FileSecrets, error)
+	FindDuplicates(ctx context.Context, filter models.DuplicateFilter) ([]models.DuplicateGroup, error)
+	CountUnhashed(ctx context.Context) (int64, int64, error)
+	FindSampledDuplicates(ctx context.Context) ([]models.DuplicateGroup, error)
+	FindCaseCollisions(ctx context.Context, root string) ([]models.CaseCollision, error)
+	ListImageHashes(ctx context.Context, root string) ([]models.FileInfo, error)
+	ListTextHashes(ctx context.Context, root string) ([]models.FileInfo, error)
+	ListPhotos(ctx context.Context) ([]models.FileInfo, error)
+	DirectoryUsage(ctx context.Context, root string, depth int) ([]models.DirectoryUsage, error)
+	LanguageUsage(ctx context.Context, root string) ([]models.LanguageUsage, error)
+	GetStats(ctx context.Context) (map[string]interface{}, error)
+	StatsMetadata(ctx context.Context) map[string]interface{}
+	RecordStats(ctx context.Context, takenAt time.Time, entries []models.StatsEntry) error
+	LatestStats(ctx context.Context) ([]models.StatsEntry, error)
+	StatsHistory(ctx context.Context, kind, name string) ([]models.StatsEntry, error)
+	ExecuteSQL(ctx context.Context, w io.Writer, output SQLOutput, sqlQuery string, args ...interface{}) error
+	CheckIntegrity(ctx context.Context, checksumLengths map[string]int) (models.HealthReport, error)
+	Export(ctx context.Context, dir string) error
+
+	RecordScan(ctx context.Context, root string, scannedAt time.Time) (models.ScanSnapshot, error)
+	ListScans(ctx context.Context) ([]models.ScanSnapshot, error)
+	ListChanges(ctx context.Context, query models.HistoryQuery) ([]models.HistoryChange, error)
+
+	RecordAction(ctx context.Context, record models.ActionRecord) error
+	ListActions(ctx context.Context) ([]models.ActionRecord, error)
+
+	AddTag(ctx context.Context, tag models.FileTag) error
+	RemoveTag(ctx context.Context, checksum, tag string) (bool, error)
+	ListTags(ctx context.Context) ([]models.FileTag, error)
+
+	RecordDeletions(ctx context.Context, paths []string, deletedAt time.Time) error
+	AddDeletion(ctx context.Context, file models.DeletedFile) error
+	ListDeletions(ctx context.Context, query models.DeletionQuery) ([]models.DeletedFile, error)
+	PurgeDeletions(ctx context.Context, before time.Time) (int64, error)
+
+	CreateHashSet(ctx context.Context, set models.HashSet) error
+	AddKnownHashes(ctx context.Context, set string, hashes []models.KnownHash) error
+	ListHashSets(ctx context.Context) ([]models.HashSet, error)
+	RemoveHashSet(ctx context.Context, name string) (bool, error)
+	KnownHashMatches(ctx context.Context, algorithm string, kind models.HashSetKind) ([]models.KnownHashMatch, error)
+}
+
+// Open opens the index at a DuckDB file path or a postgres:// URL and
+// creates its tables if needed. A PostgreSQL index is shared by several
+// machines, and host names the machine whose files are written, pruned and
+// re-hashed; queries such as searches and duplicate detection span all of
+// them. A read-only index must already exist and rejects all writes.
+// Tuning only applies to DuckDB files.
+func Open(ctx context.Context, path, host string, readOnly bool, tuning Tuning) (Store, error) {
+	d := NewDatabase()
+	if IsPostgresURL(path) {
+		if tuning != (Tuning{}) {
+			return nil, fmt.Errorf("threads, memory limit and temp directory can only be set for DuckDB indexes")
+		}
+		d = newPostgres(host)
+	} else {
+		d.localHost = host
+	}
+	d.readOnly = readOnly
+	d.tuning = tuning
+	if err := d.Init(ctx, path); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Tuning limits the resources DuckDB uses for queries, such as the large
+// GROUP BY of duplicate detection. Zero values keep DuckDB's defaults.
+type Tuning struct {
+	Threads     int    // worker threads; DuckDB's default is one per CPU core
+	MemoryLimit string // e.g. "4GB"; DuckDB's default is 80% of the RAM
+	TempDir     string // where queries over the memory limit spill to disk
+}
+
+// dsn returns the connection string that opens a database with its options:
+// read-only, with DuckDB's read-only access mode or read-only transactions
+// in PostgreSQL, and with the tuning of DuckDB
+func (d *Database) dsn(path string) string {
+	var options []string
+	if d.readOnly {
+		if d.postgres {
+			options = append(options, "default_transaction_read_only=on")
+		} else {
+			options = append(options, "access_mode=read_only")
+		}
+	}
+	if d.tuning.Threads > 0 {
+		options = append(options, "threads="+strconv.Itoa(d.tuning.Threads))
+	}
+	if d.tuning.MemoryLimit != "" {
+		options = append(options, "memory_limit="+url.QueryEscape(d.tuning.MemoryLimit))
+	}
+	if d.tuning.TempDir != "" {
+		options = append(options, "temp_directory="+url.QueryEscape(d.tuning.TempDir))
+	}
+	if len(options) == 0 {
+		return path
+	}
+	if strings.Contains(path, "?") {
+		return path + "&" + strings.Join(options, "&")
+	}
+	return path + "?" + strings.Join(options, "&")
+}
+
+// IsPostgresURL reports whether an index path is a PostgreSQL connection URL
+func IsPostgresURL(path string) bool {
+	return strings.HasPrefix(path, "postgres://") || strings.HasPrefix(path, "postgresql://")
+}
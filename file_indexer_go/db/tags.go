@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// tagTablesSQL creates the tags the user put on files. They are keyed by
+// checksum, so they survive moves and rescans, and are shared by all hosts.
+const tagTablesSQL = `
+	CREATE TABLE IF NOT EXISTS tags (
+		checksum VARCHAR NOT NULL,
+		tag VARCHAR NOT NULL,
+		tagged_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (checksum, tag)
+	);
+`
+
+// AddTag tags the files with a checksum. Tagging them again keeps the time
+// they were first tagged.
+func (d *Database) AddTag(ctx context.Context, tag models.FileTag) error {
+	_, err := d.exec(ctx, "INSERT INTO tags (checksum, tag, tagged_at) VALUES (?, ?, ?) ON CONFLICT (checksum, tag) DO NOTHING",
+		tag.Checksum, tag.Tag, tag.TaggedAt)
+	if err != nil {
+		return fmt.Errorf("error adding tag %s: %v", tag.Tag, err)
+	}
+	return nil
+}
+
+// RemoveTag removes a tag from the files with a checksum and reports whether
+// they had it
+func (d *Database) RemoveTag(ctx context.Context, checksum, tag string) (bool, error) {
+	result, err := d.exec(ctx, "DELETE FROM tags WHERE checksum = ? AND tag = ?", checksum, tag)
+	if err != nil {
+		return false, fmt.Errorf("error removing tag %s: %v", tag, err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error removing tag %s: %v", tag, err)
+	}
+	return removed > 0, nil
+}
+
+// ListTags returns all tags, ordered by tag and checksum
+func (d *Database) ListTags(ctx context.Context) ([]models.FileTag, error) {
+	rows, err := d.query(ctx, "SELECT checksum, tag, tagged_at FROM tags ORDER BY tag, checksum")
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags: %v", err)
+	}
+	defer rows.Close()
+
+	var tags []models.FileTag
+	for rows.Next() {
+		var tag models.FileTag
+		if err := rows.Scan(&tag.Checksum, &tag.Tag, &tag.TaggedAt); err != nil {
+			return nil, fmt.Errorf("error reading tags: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
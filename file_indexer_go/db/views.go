@@ -0,0 +1,51 @@
+package db
+
+// extensionExpression is the extension of a file as statistics count it: the
+// part of the name from its first dot, or empty for names without one
+const extensionExpression = "CASE WHEN filename LIKE '%.%' THEN SUBSTRING(filename, STRPOS(filename, '.')) ELSE '' END"
+
+// recentChangesDays is the age of the oldest changes recent_changes lists
+const recentChangesDays = "30"
+
+// viewsSQL creates the views offered to SQL queries and to the tools reading
+// the database, over all hosts. They are replaced each time the database is
+// opened for writing, so they follow the schema of the program.
+const viewsSQL = `
+	CREATE OR REPLACE VIEW duplicate_groups AS
+	SELECT checksum, file_size,
+		COUNT(*) AS file_count,
+		COUNT(DISTINCT CASE WHEN inode IS NULL THEN COALESCE(host, '') || ':' || path
+			ELSE COALESCE(host, '') || ':' || CAST(device AS VARCHAR) || ':' || CAST(inode AS VARCHAR) END) AS copies,
+		(COUNT(DISTINCT CASE WHEN inode IS NULL THEN COALESCE(host, '') || ':' || path
+			ELSE COALESCE(host, '') || ':' || CAST(device AS VARCHAR) || ':' || CAST(inode AS VARCHAR) END) - 1) * file_size AS wasted_space,
+		MIN(path) AS first_path
+	FROM files
+	WHERE checksum IS NOT NULL AND checksum <> '' AND ` + notAppleDouble + `
+	GROUP BY checksum, file_size
+	HAVING COUNT(*) > 1;
+
+	CREATE OR REPLACE VIEW largest_files AS
+	SELECT path, filename, file_size, mime_type, modification_datetime, host
+	FROM files
+	ORDER BY file_size DESC, path;
+
+	CREATE OR REPLACE VIEW files_by_extension AS
+	SELECT ` + extensionExpression + ` AS extension,
+		COUNT(*) AS file_count,
+		SUM(file_size) AS total_size,
+		MAX(file_size) AS largest_size
+	FROM files
+	GROUP BY 1;
+
+	CREATE OR REPLACE VIEW recent_changes AS
+	SELECT * FROM (
+		SELECT s.scanned_at AS changed_at, c.change, c.path, c.file_size, c.checksum, s.root, s.host
+		FROM file_changes c JOIN scans s ON s.scan_id = c.scan_id
+		WHERE c.change <> 'removed'
+		UNION ALL
+		SELECT deleted_at, 'removed', path, file_size, checksum, NULL, host
+		FROM deleted_files
+	) AS changes
+	WHERE changed_at >= CURRENT_TIMESTAMP - INTERVAL '` + recentChangesDays + ` days'
+	ORDER BY changed_at DESC, path;
+`
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// xattrTablesSQL creates the table of extended attributes, with one row per
+// attribute of a file
+const xattrTablesSQL = `
+	CREATE TABLE IF NOT EXISTS file_xattrs (
+		path VARCHAR NOT NULL,
+		name VARCHAR NOT NULL,
+		value VARCHAR NOT NULL,
+		host VARCHAR
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_file_xattrs_path ON file_xattrs(path);
+	CREATE INDEX IF NOT EXISTS idx_file_xattrs_name ON file_xattrs(name);
+`
+
+// xattrBatchRows is the number of paths or attributes written per statement,
+// which keeps statements below PostgreSQL's limit of 65535 parameters
+const xattrBatchRows = 1000
+
+// execer is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// xattrCondition matches the attributes of the files matched by a condition
+// on the files table
+func xattrCondition(condition string) string {
+	return "EXISTS (SELECT 1 FROM file_xattrs x WHERE x.path = files.path AND x.host IS NOT DISTINCT FROM files.host AND " + condition + ")"
+}
+
+// replaceXAttrs replaces the stored extended attributes of files that were
+// indexed with them. Files whose XAttrs are nil keep their stored attributes.
+// It returns the error of the driver.
+func (d *Database) replaceXAttrs(ctx context.Context, q execer, files []models.FileInfo) error {
+	pathsByHost := make(map[string][]interface{})
+	var rows [][]interface{}
+	for _, file := range files {
+		if file.XAttrs == nil {
+			continue
+		}
+		host := d.fileHost(file)
+		pathsByHost[host] = append(pathsByHost[host], file.Path)
+		for name, value := range file.XAttrs {
+			rows = append(rows, []interface{}{file.Path, name, value, nullIfEmpty(host)})
+		}
+	}
+
+	for host, paths := range pathsByHost {
+		for start := 0; start < len(paths); start += xattrBatchRows {
+			batch := paths[start:min(start+xattrBatchRows, len(paths))]
+			deleteSQL := "DELETE FROM file_xattrs WHERE host IS NOT DISTINCT FROM ? AND path IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+			if _, err := q.ExecContext(ctx, d.rebind(deleteSQL), append([]interface{}{nullIfEmpty(host)}, batch...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	for start := 0; start < len(rows); start += xattrBatchRows {
+		batch := rows[start:min(start+xattrBatchRows, len(rows))]
+		values := make([]string, len(batch))
+		var args []interface{}
+		for n, row := range batch {
+			values[n] = "(?, ?, ?, ?)"
+			args = append(args, row...)
+		}
+		insertSQL := "INSERT INTO file_xattrs (path, name, value, host) VALUES " + strings.Join(values, ", ")
+		if _, err := q.ExecContext(ctx, d.rebind(insertSQL), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileXAttrs returns the stored extended attributes of all files indexed
+// with them, keyed by location (see models.FileInfo.Location) and attribute
+// name
+func (d *Database) FileXAttrs(ctx context.Context) (map[string]map[string]string, error) {
+	rows, err := d.query(ctx, "SELECT host, path, name, value FROM file_xattrs")
+	if err != nil {
+		return nil, fmt.Errorf("error reading extended attributes: %v", err)
+	}
+	defer rows.Close()
+
+	xattrs := make(map[string]map[string]string)
+	for rows.Next() {
+		var host sql.NullString
+		var path, name, value string
+		if err := rows.Scan(&host, &path, &name, &value); err != nil {
+			return nil, fmt.Errorf("error reading extended attributes: %v", err)
+		}
+		key := location(host, path)
+		if xattrs[key] == nil {
+			xattrs[key] = make(map[string]string)
+		}
+		xattrs[key][name] = value
+	}
+	return xattrs, rows.Err()
+}
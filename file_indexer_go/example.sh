@@ -21,32 +21,32 @@ echo
 
 # Index the test directory
 echo "1. Indexing test_files directory..."
-./file-indexer -dir test_files -content
+./file-indexer index -dir test_files -content
 echo
 
 # Show statistics
 echo "2. Showing index statistics..."
-./file-indexer -stats
+./file-indexer stats
 echo
 
 # List all indexed files
 echo "3. Listing all indexed files..."
-./file-indexer -list
+./file-indexer list
 echo
 
 # Search for files containing "TODO"
 echo "4. Searching for files containing 'TODO'..."
-./file-indexer -search "TODO"
+./file-indexer search "TODO"
 echo
 
 # Search for Python files
 echo "5. Searching for Python files..."
-./file-indexer -search ".py"
+./file-indexer search ".py"
 echo
 
 # Search for files with "test" in the name
 echo "6. Searching for files with 'test' in the name..."
-./file-indexer -search "test"
+./file-indexer search "test"
 echo
 
 echo "Example completed!"
@@ -0,0 +1,31 @@
+package filter
+
+import "strings"
+
+// Extensions is a set of file name extensions, such as jpg or tar.gz,
+// matched without regard to case and without the glob engine
+type Extensions []string
+
+// ParseExtensions normalizes a list of extensions, which may be written with
+// or without a leading dot
+func ParseExtensions(extensions []string) Extensions {
+	var parsed Extensions
+	for _, extension := range extensions {
+		extension = strings.ToLower(strings.TrimLeft(strings.TrimSpace(extension), "."))
+		if extension != "" {
+			parsed = append(parsed, "."+extension)
+		}
+	}
+	return parsed
+}
+
+// Match reports whether a file name ends in one of the extensions
+func (e Extensions) Match(name string) bool {
+	name = strings.ToLower(name)
+	for _, extension := range e {
+		if strings.HasSuffix(name, extension) && len(name) > len(extension) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package filter
+
+import "testing"
+
+func TestExtensionsMatch(t *testing.T) {
+	exts := ParseExtensions([]string{"JPG", ".tar.gz", " png ", ""})
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"photo.jpg", true},
+		{"PHOTO.JPG", true},
+		{"backup.tar.gz", true},
+		{"archive.gz", false},
+		{"icon.png", true},
+		{".jpg", false},
+		{"jpg", false},
+		{"notes.txt", false},
+	}
+	for _, tt := range tests {
+		if got := exts.Match(tt.name); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Pattern is a compiled glob pattern.
+//
+// Patterns use path.Match syntax per path segment, plus "**" which matches
+// any number of segments (including none). A pattern without a slash, such
+// as "*.tmp", is matched against the file name at any depth. Other patterns
+// are matched against the slash-separated path relative to the scan root,
+// or against the absolute path if the pattern itself is absolute.
+type Pattern struct {
+	raw      string
+	segments []string
+	basename bool
+	absolute bool
+}
+
+// Compile parses a glob pattern
+func Compile(pattern string) (*Pattern, error) {
+	raw := pattern
+	pattern = filepath.ToSlash(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	p := &Pattern{raw: raw}
+	if strings.HasPrefix(pattern, "/") || filepath.IsAbs(raw) {
+		p.absolute = true
+	}
+	pattern = strings.Trim(pattern, "/")
+	if !strings.Contains(pattern, "/") && !p.absolute {
+		p.basename = true
+	}
+
+	p.segments = strings.Split(pattern, "/")
+	for _, segment := range p.segments {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", raw, err)
+		}
+	}
+	return p, nil
+}
+
+// String returns the pattern as it was given
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Match reports whether the pattern matches a path. relPath is relative to
+// the scan root, absPath is the absolute path of the same entry.
+func (p *Pattern) Match(relPath, absPath string, isDir bool) bool {
+	target := relPath
+	if p.absolute {
+		target = absPath
+	}
+	target = strings.Trim(filepath.ToSlash(target), "/")
+
+	if p.basename {
+		ok, _ := path.Match(p.segments[0], path.Base(target))
+		return ok
+	}
+
+	parts := strings.Split(target, "/")
+	if matchSegments(p.segments, parts) {
+		return true
+	}
+
+	// "dir/**" also matches the directory itself, so whole subtrees can be
+	// pruned before they are walked
+	if isDir && len(p.segments) > 1 && p.segments[len(p.segments)-1] == "**" {
+		return matchSegments(p.segments[:len(p.segments)-1], parts)
+	}
+	return false
+}
+
+// matchSegments matches pattern segments against path segments
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**" and try every possible split
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		ok, _ := path.Match(pattern[0], parts[0])
+		if !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		parts = parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// Set is a list of patterns where any match counts as a match
+type Set []*Pattern
+
+// CompileSet compiles a list of glob patterns
+func CompileSet(patterns []string) (Set, error) {
+	var set Set
+	for _, pattern := range patterns {
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, p)
+	}
+	return set, nil
+}
+
+// Match reports whether any pattern in the set matches the path
+func (s Set) Match(relPath, absPath string, isDir bool) bool {
+	for _, p := range s {
+		if p.Match(relPath, absPath, isDir) {
+			return true
+		}
+	}
+	return false
+}
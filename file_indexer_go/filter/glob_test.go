@@ -0,0 +1,71 @@
+package filter
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		abs     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.tmp", "a.tmp", "/r/a.tmp", false, true},
+		{"*.tmp", "deep/down/a.tmp", "/r/deep/down/a.tmp", false, true},
+		{"*.tmp", "a.tmp.txt", "/r/a.tmp.txt", false, false},
+		{"build/*.o", "build/main.o", "/r/build/main.o", false, true},
+		{"build/*.o", "src/build/main.o", "/r/src/build/main.o", false, false},
+		{"**/cache", "a/b/cache", "/r/a/b/cache", true, true},
+		{"**/cache", "cache", "/r/cache", true, true},
+		{"node_modules/**", "node_modules", "/r/node_modules", true, true},
+		{"node_modules/**", "node_modules/x/y.js", "/r/node_modules/x/y.js", false, true},
+		{"a/**/z.txt", "a/z.txt", "/r/a/z.txt", false, true},
+		{"a/**/z.txt", "a/b/c/z.txt", "/r/a/b/c/z.txt", false, true},
+		{"a/**/z.txt", "b/z.txt", "/r/b/z.txt", false, false},
+		{"/r/private/*", "private/key", "/r/private/key", false, true},
+		{"/r/private/*", "private/key", "/other/private/key", false, false},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.rel, tt.abs, tt.isDir); got != tt.want {
+			t.Errorf("%q.Match(%q, %q, %v) = %v, want %v", tt.pattern, tt.rel, tt.abs, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range []string{"", "  ", "[", "a/[b/c"} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", pattern)
+		}
+	}
+	if _, err := CompileSet([]string{"*.tmp", "["}); err == nil {
+		t.Errorf("CompileSet with an invalid pattern succeeded")
+	}
+}
+
+func TestSetMatch(t *testing.T) {
+	set, err := CompileSet([]string{"*.tmp", "cache/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"x.tmp", true},
+		{"cache/a.bin", true},
+		{"src/main.go", false},
+	}
+	for _, tt := range tests {
+		if got := set.Match(tt.rel, "/r/"+tt.rel, false); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+	if Set(nil).Match("anything", "/r/anything", false) {
+		t.Errorf("an empty set matched")
+	}
+}
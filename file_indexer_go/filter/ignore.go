@@ -0,0 +1,168 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// IgnoreFileNames are the ignore files honored in traversed directories
+var IgnoreFileNames = []string{".gitignore", ".indexignore"}
+
+// ignoreRule is a single rule of a .gitignore-style file
+type ignoreRule struct {
+	pattern *Pattern
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreFile holds the rules of one ignore file. Rules are evaluated
+// relative to the directory containing the file.
+type IgnoreFile struct {
+	base  string
+	rules []ignoreRule
+}
+
+// LoadIgnoreFile reads an ignore file located in the directory base, given
+// as a slash-separated path relative to the scan root ("" for the root)
+func LoadIgnoreFile(filename, base string) (*IgnoreFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseIgnore(file, base)
+}
+
+// ParseIgnore parses .gitignore syntax: comments, negation with "!",
+// directory-only rules with a trailing "/", rules anchored to the ignore
+// file's directory when they contain a slash, and "**" wildcards
+func ParseIgnore(r io.Reader, base string) (*IgnoreFile, error) {
+	base = strings.Trim(base, "/")
+	if base == "." {
+		base = ""
+	}
+	f := &IgnoreFile{base: base}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := trimTrailingSpaces(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// A slash at the beginning or in the middle anchors the rule to the
+		// directory of the ignore file; otherwise it matches at any depth
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		segments := strings.Split(line, "/")
+		for _, segment := range segments {
+			if segment == "**" {
+				continue
+			}
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("line %d: invalid pattern %q: %v", lineNo, line, err)
+			}
+		}
+		rule.pattern = &Pattern{raw: line, segments: segments, basename: !anchored}
+		f.rules = append(f.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// trimTrailingSpaces removes trailing spaces unless they are escaped
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return strings.ReplaceAll(line, `\ `, " ")
+}
+
+// Match evaluates the rules against a slash-separated path relative to the
+// scan root. matched reports whether any rule applied; ignored is the
+// outcome of the last matching rule.
+func (f *IgnoreFile) Match(relPath string, isDir bool) (matched, ignored bool) {
+	rel := strings.Trim(relPath, "/")
+	if f.base != "" {
+		if !strings.HasPrefix(rel, f.base+"/") {
+			return false, false
+		}
+		rel = strings.TrimPrefix(rel, f.base+"/")
+	}
+
+	for _, rule := range f.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.Match(rel, "", isDir) {
+			matched = true
+			ignored = !rule.negate
+		}
+	}
+	return matched, ignored
+}
+
+// IgnoreStack applies ignore files hierarchically: files in deeper
+// directories take precedence over files closer to the scan root
+type IgnoreStack struct {
+	files map[string][]*IgnoreFile
+}
+
+// NewIgnoreStack creates an empty ignore stack
+func NewIgnoreStack() *IgnoreStack {
+	return &IgnoreStack{files: make(map[string][]*IgnoreFile)}
+}
+
+// Add registers the ignore files of a directory (relative to the scan root)
+func (s *IgnoreStack) Add(relDir string, files ...*IgnoreFile) {
+	relDir = strings.Trim(relDir, "/")
+	if relDir == "." {
+		relDir = ""
+	}
+	s.files[relDir] = append(s.files[relDir], files...)
+}
+
+// Ignored reports whether a path relative to the scan root is ignored
+func (s *IgnoreStack) Ignored(relPath string, isDir bool) bool {
+	rel := strings.Trim(relPath, "/")
+
+	// Collect the ancestor directories from the root downwards
+	dirs := []string{""}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+
+	ignored := false
+	for _, dir := range dirs {
+		for _, f := range s.files[dir] {
+			if matched, result := f.Match(rel, isDir); matched {
+				ignored = result
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnoreFileMatch(t *testing.T) {
+	rules := `# build output
+*.log
+!keep.log
+build/
+/top.txt
+docs/*.pdf
+\#hash
+trailing.txt   
+`
+	tests := []struct {
+		rel         string
+		isDir       bool
+		wantMatched bool
+		wantIgnored bool
+	}{
+		{"app.log", false, true, true},
+		{"sub/app.log", false, true, true},
+		{"keep.log", false, true, false},
+		{"build", true, true, true},
+		{"build", false, false, false},
+		{"src/build", true, true, true},
+		{"top.txt", false, true, true},
+		{"sub/top.txt", false, false, false},
+		{"docs/manual.pdf", false, true, true},
+		{"sub/docs/manual.pdf", false, false, false},
+		{"#hash", false, true, true},
+		{"trailing.txt", false, true, true},
+		{"main.go", false, false, false},
+	}
+	f, err := ParseIgnore(strings.NewReader(rules), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		matched, ignored := f.Match(tt.rel, tt.isDir)
+		if matched != tt.wantMatched || ignored != tt.wantIgnored {
+			t.Errorf("Match(%q, %v) = %v, %v, want %v, %v", tt.rel, tt.isDir, matched, ignored, tt.wantMatched, tt.wantIgnored)
+		}
+	}
+}
+
+func TestParseIgnoreError(t *testing.T) {
+	if _, err := ParseIgnore(strings.NewReader("ok\n[broken\n"), ""); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ParseIgnore = %v, want an error on line 2", err)
+	}
+}
+
+func TestIgnoreStack(t *testing.T) {
+	parse := func(rules, base string) *IgnoreFile {
+		t.Helper()
+		f, err := ParseIgnore(strings.NewReader(rules), base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	stack := NewIgnoreStack()
+	stack.Add(".", parse("*.tmp\n/local.txt\n", "."))
+	stack.Add("sub", parse("!special.tmp\nlocal.txt\n", "sub"))
+
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"a.tmp", true},
+		{"sub/a.tmp", true},
+		{"special.tmp", true},
+		{"sub/special.tmp", false},
+		{"sub/deeper/special.tmp", false},
+		{"local.txt", true},
+		{"other/local.txt", false},
+		{"sub/local.txt", true},
+		{"main.go", false},
+	}
+	for _, tt := range tests {
+		if got := stack.Ignored(tt.rel, false); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
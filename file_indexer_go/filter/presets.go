@@ -0,0 +1,86 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Preset is a named bundle of exclude patterns for directories and files
+// that are rarely worth indexing
+type Preset struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Patterns    []string `json:"patterns"`
+}
+
+// desktopJunk are the metadata files desktops leave in every directory
+var desktopJunk = []string{".DS_Store", "._*", "Thumbs.db", "desktop.ini"}
+
+// presets are the built-in presets by name
+var presets = map[string]Preset{
+	"dev": {
+		Name:        "dev",
+		Description: "dependency, virtualenv, build cache and VCS directories of source trees",
+		Patterns: append([]string{
+			"node_modules", "bower_components", ".venv", "venv", "__pycache__", "*.pyc",
+			".cache", ".gradle", ".tox", ".mypy_cache", ".pytest_cache", ".terraform", ".next",
+			".git", ".hg", ".svn",
+		}, desktopJunk...),
+	},
+	"photos": {
+		Name:        "photos",
+		Description: "thumbnail caches and catalog previews of photo libraries and NAS indexers",
+		Patterns: append([]string{
+			".thumbnails", "@eaDir", ".@__thumb", "*.lrdata", ".picasaoriginals", ".Picasa.ini", "ZbThumbnail.info",
+		}, desktopJunk...),
+	},
+	"backups": {
+		Name:        "backups",
+		Description: "trash, recycle bins, snapshots and system directories of disks and NAS shares",
+		Patterns: append([]string{
+			".Trash", ".Trash-*", ".Trashes", "$RECYCLE.BIN", "#recycle", "@Recycle", "#snapshot", ".snapshot",
+			"System Volume Information", "lost+found", ".Spotlight-V100", ".fseventsd", ".cache", "~$*",
+		}, desktopJunk...),
+	},
+}
+
+// Presets returns the built-in presets in order of their names
+func Presets() []Preset {
+	list := make([]Preset, 0, len(presets))
+	for _, preset := range presets {
+		list = append(list, preset)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return list
+}
+
+// PresetPatterns returns the exclude patterns of the named presets, without
+// duplicates and in order
+func PresetPatterns(names []string) ([]string, error) {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		preset, ok := presets[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q (supported: %s)", name, strings.Join(presetNames(), ", "))
+		}
+		for _, pattern := range preset.Patterns {
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// presetNames returns the names of the built-in presets in order
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package fsmeta
+
+import "io/fs"
+
+func fileAttributes(info fs.FileInfo) (Attributes, bool) {
+	return Attributes{}, false
+}
+
+func isHidden(d fs.DirEntry) bool {
+	return false
+}
+
+func listStreams(path string) ([]Stream, error) {
+	return nil, nil
+}
@@ -0,0 +1,77 @@
+package fsmeta
+
+import (
+	"io/fs"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func fileAttributes(info fs.FileInfo) (Attributes, bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return Attributes{}, false
+	}
+	return Attributes{
+		ReadOnly: data.FileAttributes&windows.FILE_ATTRIBUTE_READONLY != 0,
+		Hidden:   data.FileAttributes&windows.FILE_ATTRIBUTE_HIDDEN != 0,
+		System:   data.FileAttributes&windows.FILE_ATTRIBUTE_SYSTEM != 0,
+	}, true
+}
+
+func isHidden(d fs.DirEntry) bool {
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	attrs, _ := fileAttributes(info)
+	return attrs.Hidden
+}
+
+var (
+	kernel32             = windows.NewLazySystemDLL("kernel32.dll")
+	procFindFirstStreamW = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = kernel32.NewProc("FindNextStreamW")
+)
+
+// findStreamData is WIN32_FIND_STREAM_DATA
+type findStreamData struct {
+	StreamSize int64
+	StreamName [windows.MAX_PATH + 36]uint16
+}
+
+func listStreams(path string) ([]Stream, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var data findStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(name)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(handle) == windows.InvalidHandle {
+		// No streams, or a filesystem such as FAT without them
+		if err == windows.ERROR_HANDLE_EOF || err == windows.ERROR_INVALID_FUNCTION {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer windows.FindClose(windows.Handle(handle))
+
+	var streams []Stream
+	for {
+		// Names look like ":Zone.Identifier:$DATA"; the unnamed stream,
+		// "::$DATA", is the file's content
+		streamName := windows.UTF16ToString(data.StreamName[:])
+		streamName = strings.TrimSuffix(strings.TrimPrefix(streamName, ":"), ":$DATA")
+		if streamName != "" {
+			streams = append(streams, Stream{Name: streamName, Size: data.StreamSize})
+		}
+		if ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data))); ok == 0 {
+			if err == windows.ERROR_HANDLE_EOF {
+				return streams, nil
+			}
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package fsmeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+// Extended attributes in which macOS keeps Finder tags and, before tags,
+// color labels
+const (
+	userTagsXAttr   = "com.apple.metadata:_kMDItemUserTags"
+	finderInfoXAttr = "com.apple.FinderInfo"
+)
+
+// labelNames are the Finder color labels by their index in FinderInfo
+var labelNames = []string{"", "Gray", "Green", "Purple", "Blue", "Yellow", "Red", "Orange"}
+
+// FinderTags returns the Finder tags of a file on macOS, including its color
+// label, or nil if it has none or the platform has no Finder
+func FinderTags(path string) ([]string, error) {
+	return readFinderTags(path)
+}
+
+// parseUserTags decodes the tags attribute, a binary property list holding
+// an array of strings such as "Red\n6", where the number is the tag's color
+func parseUserTags(data []byte) ([]string, error) {
+	values, err := parseStringArray(data)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, value := range values {
+		name, _, _ := strings.Cut(value, "\n")
+		if name != "" {
+			tags = append(tags, name)
+		}
+	}
+	return tags, nil
+}
+
+// finderLabel returns the name of the color label set in a FinderInfo
+// attribute, or ""
+func finderLabel(info []byte) string {
+	if len(info) < 10 {
+		return ""
+	}
+	return labelNames[info[9]>>1&7]
+}
+
+var errBadPlist = errors.New("malformed binary property list")
+
+// parseStringArray decodes a binary property list whose top object is an
+// array of strings, the only shape Finder tags take
+func parseStringArray(data []byte) ([]string, error) {
+	if len(data) < 8+32 || string(data[:8]) != "bplist00" {
+		return nil, errBadPlist
+	}
+	trailer := data[len(data)-32:]
+	offsetSize, refSize := int(trailer[6]), int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:16])
+	topObject := binary.BigEndian.Uint64(trailer[16:24])
+	offsetTable := binary.BigEndian.Uint64(trailer[24:32])
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 || topObject >= numObjects ||
+		offsetTable > uint64(len(data)) || numObjects > (uint64(len(data))-offsetTable)/uint64(offsetSize) {
+		return nil, errBadPlist
+	}
+
+	// object returns the marker of an object and the data following it
+	object := func(ref uint64) (byte, []byte, error) {
+		if ref >= numObjects {
+			return 0, nil, errBadPlist
+		}
+		offset := readUint(data[offsetTable+ref*uint64(offsetSize):], offsetSize)
+		if offset >= offsetTable {
+			return 0, nil, errBadPlist
+		}
+		return data[offset], data[offset+1 : offsetTable], nil
+	}
+	// length returns the element count of an object, which is stored in an
+	// integer object following the marker when it does not fit in 4 bits
+	length := func(marker byte, rest []byte) (int, []byte, error) {
+		if marker&0xf != 0xf {
+			return int(marker & 0xf), rest, nil
+		}
+		if len(rest) == 0 || rest[0]>>4 != 0x1 {
+			return 0, nil, errBadPlist
+		}
+		size := 1 << (rest[0] & 0xf)
+		if size > 8 || len(rest) < 1+size {
+			return 0, nil, errBadPlist
+		}
+		n := readUint(rest[1:], size)
+		if n > uint64(len(data)) {
+			return 0, nil, errBadPlist
+		}
+		return int(n), rest[1+size:], nil
+	}
+
+	marker, rest, err := object(topObject)
+	if err != nil || marker>>4 != 0xa {
+		return nil, errBadPlist
+	}
+	count, rest, err := length(marker, rest)
+	if err != nil || len(rest) < count*refSize {
+		return nil, errBadPlist
+	}
+
+	values := make([]string, 0, count)
+	for n := 0; n < count; n++ {
+		marker, body, err := object(readUint(rest[n*refSize:], refSize))
+		if err != nil {
+			return nil, err
+		}
+		chars, body, err := length(marker, body)
+		if err != nil {
+			return nil, err
+		}
+		switch marker >> 4 {
+		case 0x5: // ASCII
+			if len(body) < chars {
+				return nil, errBadPlist
+			}
+			values = append(values, string(body[:chars]))
+		case 0x6: // UTF-16BE
+			if len(body) < 2*chars {
+				return nil, errBadPlist
+			}
+			units := make([]uint16, chars)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(body[2*i:])
+			}
+			values = append(values, string(utf16.Decode(units)))
+		default:
+			return nil, errBadPlist
+		}
+	}
+	return values, nil
+}
+
+// readUint reads a big-endian unsigned integer of size bytes
+func readUint(b []byte, size int) uint64 {
+	var n uint64
+	for _, c := range b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
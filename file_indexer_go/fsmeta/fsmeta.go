@@ -0,0 +1,155 @@
+// Package fsmeta exposes platform-specific file system metadata
+package fsmeta
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ID identifies a file on a device, independently of the paths leading to it
+type ID struct {
+	Device uint64
+	Inode  uint64
+}
+
+// FileID returns the device and inode of a file, and whether the platform
+// provides them for the given info
+func FileID(info fs.FileInfo) (ID, bool) {
+	return fileID(info)
+}
+
+// LinkCount returns the number of hardlinks to a file, and whether the
+// platform provides it for the given info
+func LinkCount(info fs.FileInfo) (uint64, bool) {
+	return linkCount(info)
+}
+
+// Owner holds the owning user and group and the permission bits of a file
+type Owner struct {
+	UID  uint32
+	GID  uint32
+	Mode uint32 // permission, setuid, setgid and sticky bits, as in chmod
+}
+
+// FileOwner returns the owner and permission bits of a file, and whether the
+// platform provides them for the given info
+func FileOwner(info fs.FileInfo) (Owner, bool) {
+	return fileOwner(info)
+}
+
+var (
+	namesMu    sync.Mutex
+	userNames  = make(map[uint32]string)
+	groupNames = make(map[uint32]string)
+)
+
+// UserName returns the name of the user with the given ID, or "" if it
+// cannot be resolved, e.g. because the account was deleted. Lookups are
+// cached, as files of the same few users are seen over and over.
+func UserName(uid uint32) string {
+	return lookupName(userNames, uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+// GroupName returns the name of the group with the given ID, or "" if it
+// cannot be resolved
+func GroupName(gid uint32) string {
+	return lookupName(groupNames, gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+// lookupName resolves an ID through lookup, remembering failed lookups too
+func lookupName(cache map[uint32]string, id uint32, lookup func(string) (string, error)) string {
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	if name, ok := cache[id]; ok {
+		return name
+	}
+	name, _ := lookup(strconv.FormatUint(uint64(id), 10))
+	cache[id] = name
+	return name
+}
+
+// XAttrs returns the extended attributes of a file by name, following
+// symlinks. Values that are not text are hex-encoded with a 0x prefix. The
+// map is empty if the file has no attributes or the filesystem does not
+// support them, and nil on platforms without extended attributes.
+func XAttrs(path string) (map[string]string, error) {
+	return readXAttrs(path)
+}
+
+// encodeXAttr returns an attribute value as text. Many text values end with
+// a NUL byte, which is dropped.
+func encodeXAttr(value []byte) string {
+	text := strings.TrimSuffix(string(value), "\x00")
+	if utf8.ValidString(text) && !strings.ContainsRune(text, 0) {
+		return text
+	}
+	return "0x" + hex.EncodeToString(value)
+}
+
+// Attributes are the Windows attributes of a file
+type Attributes struct {
+	ReadOnly bool
+	Hidden   bool
+	System   bool
+}
+
+// String lists the set attributes, e.g. "hidden,system"
+func (a Attributes) String() string {
+	var names []string
+	for _, attr := range []struct {
+		set  bool
+		name string
+	}{{a.ReadOnly, "readonly"}, {a.Hidden, "hidden"}, {a.System, "system"}} {
+		if attr.set {
+			names = append(names, attr.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// FileAttributes returns the Windows attributes of a file, and whether the
+// platform has them
+func FileAttributes(info fs.FileInfo) (Attributes, bool) {
+	return fileAttributes(info)
+}
+
+// IsHidden reports whether a directory entry has the Windows hidden
+// attribute. Names starting with a dot are left to the caller.
+func IsHidden(d fs.DirEntry) bool {
+	return isHidden(d)
+}
+
+// Stream is a named NTFS alternate data stream of a file
+type Stream struct {
+	Name string // e.g. Zone.Identifier
+	Size int64
+}
+
+// Streams returns the alternate data streams of a file, without its unnamed
+// main stream. There are none on other platforms and filesystems.
+func Streams(path string) ([]Stream, error) {
+	return listStreams(path)
+}
+
+// IsTransient reports whether an I/O error may go away when the operation is
+// retried, as happens on network filesystems such as SMB/CIFS mounts
+func IsTransient(err error) bool {
+	return err != nil && isTransient(err)
+}
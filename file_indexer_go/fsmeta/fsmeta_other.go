@@ -0,0 +1,21 @@
+//go:build !unix
+
+package fsmeta
+
+import "io/fs"
+
+func fileID(info fs.FileInfo) (ID, bool) {
+	return ID{}, false
+}
+
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+func fileOwner(info fs.FileInfo) (Owner, bool) {
+	return Owner{}, false
+}
+
+func isTransient(err error) bool {
+	return false
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package fsmeta
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+func fileID(info fs.FileInfo) (ID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ID{}, false
+	}
+	return ID{Device: uint64(stat.Dev), Inode: uint64(stat.Ino)}, true
+}
+
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
+
+func fileOwner(info fs.FileInfo) (Owner, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Owner{}, false
+	}
+	return Owner{UID: stat.Uid, GID: stat.Gid, Mode: uint32(stat.Mode) & 0o7777}, true
+}
+
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE, syscall.ECONNRESET, syscall.EHOSTDOWN} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+//go:build linux || darwin
+
+package fsmeta
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func readXAttrs(path string) (map[string]string, error) {
+	list, err := readXAttr(func(dest []byte) (int, error) { return unix.Listxattr(path, dest) })
+	if errors.Is(err, unix.ENOTSUP) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string)
+	for _, name := range strings.Split(string(list), "\x00") {
+		if name == "" {
+			continue
+		}
+		value, err := readXAttr(func(dest []byte) (int, error) { return unix.Getxattr(path, name, dest) })
+		if errors.Is(err, errNoAttr) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		attrs[name] = encodeXAttr(value)
+	}
+	return attrs, nil
+}
+
+// readXAttr calls get first to learn the size of a value and then to read
+// it, starting over if the value grew in between
+func readXAttr(get func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := get(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		dest := make([]byte, size)
+		n, err := get(dest)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dest[:n], nil
+	}
+}
@@ -0,0 +1,33 @@
+package fsmeta
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// errNoAttr is returned for attributes that do not exist
+const errNoAttr = unix.ENOATTR
+
+func readFinderTags(path string) ([]string, error) {
+	data, err := readXAttr(func(dest []byte) (int, error) { return unix.Getxattr(path, userTagsXAttr, dest) })
+	if err == nil && len(data) > 0 {
+		return parseUserTags(data)
+	}
+	if err != nil && !errors.Is(err, errNoAttr) && !errors.Is(err, unix.ENOTSUP) {
+		return nil, err
+	}
+
+	// Files labeled before tags existed only carry the label color
+	info, err := readXAttr(func(dest []byte) (int, error) { return unix.Getxattr(path, finderInfoXAttr, dest) })
+	if err != nil {
+		if errors.Is(err, errNoAttr) || errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if label := finderLabel(info); label != "" {
+		return []string{label}, nil
+	}
+	return nil, nil
+}
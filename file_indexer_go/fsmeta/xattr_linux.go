@@ -0,0 +1,10 @@
+package fsmeta
+
+import "golang.org/x/sys/unix"
+
+// errNoAttr is returned for attributes that do not exist
+const errNoAttr = unix.ENODATA
+
+func readFinderTags(path string) ([]string, error) {
+	return nil, nil
+}
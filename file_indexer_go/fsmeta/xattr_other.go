@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package fsmeta
+
+func readXAttrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func readFinderTags(path string) ([]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,229 @@
+package hasher
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// Default is the algorithm used when none is configured. It matches the
+// checksums stored by indexes created before the algorithm was selectable.
+const Default = "md5"
+
+// Hasher computes file checksums with a specific algorithm
+type Hasher interface {
+	// Name returns the algorithm name stored in the index metadata
+	Name() string
+	// New returns a fresh hash state
+	New() hash.Hash
+}
+
+// algorithm is a Hasher backed by a hash constructor
+type algorithm struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+// Name returns the algorithm name
+func (a algorithm) Name() string {
+	return a.name
+}
+
+// New returns a fresh hash state
+func (a algorithm) New() hash.Hash {
+	return a.newHash()
+}
+
+// registry holds all supported algorithms by name
+var registry = map[string]Hasher{
+	"md5":    algorithm{"md5", md5.New},
+	"sha1":   algorithm{"sha1", sha1.New},
+	"sha256": algorithm{"sha256", sha256.New},
+	"xxhash": algorithm{"xxhash", func() hash.Hash { return xxhash.New() }},
+	"blake3": algorithm{"blake3", func() hash.Hash { return blake3.New() }},
+}
+
+// Get returns the hasher for an algorithm name
+func Get(name string) (Hasher, error) {
+	h, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	return h, nil
+}
+
+// Names returns the names of all supported algorithms
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HashFile calculates the checksum of a file of fsys and returns it hex-encoded
+func HashFile(h Hasher, fsys source.FS, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	checksum, err := HashReader(h, file)
+
+	// Now, close the file and capture the error.
+	closeErr := file.Close()
+
+	// The error from the primary operation (hashing) is more important.
+	if err != nil {
+		return "", err
+	}
+
+	// If hashing succeeded, return the error from closing the file, if any.
+	if closeErr != nil {
+		return "", closeErr
+	}
+
+	return checksum, nil
+}
+
+// HashFileAll calculates the checksums of a file of fsys with several
+// algorithms in a single read and returns them hex-encoded, in the order of hs
+func HashFileAll(hs []Hasher, fsys source.FS, path string) ([]string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	states := make([]io.Writer, len(hs))
+	for n, h := range hs {
+		states[n] = h.New()
+	}
+	if _, err := io.Copy(io.MultiWriter(states...), file); err != nil {
+		return nil, err
+	}
+	checksums := make([]string, len(hs))
+	for n, state := range states {
+		checksums[n] = hex.EncodeToString(state.(hash.Hash).Sum(nil))
+	}
+	return checksums, nil
+}
+
+// HashReader calculates the checksum of everything read from r and returns
+// it hex-encoded
+func HashReader(h Hasher, r io.Reader) (string, error) {
+	state := h.New()
+	if _, err := io.Copy(state, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(state.Sum(nil)), nil
+}
+
+// QuickHashChunk is the number of bytes read from each end of a file for a quick hash
+const QuickHashChunk = 64 * 1024
+
+// QuickHashFile calculates a cheap fingerprint of a file from its size and
+// its first and last QuickHashChunk bytes. Files with different quick hashes
+// cannot be identical; files with equal quick hashes need a full checksum.
+func QuickHashFile(h Hasher, fsys source.FS, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	state := h.New()
+	fmt.Fprintf(state, "%d:", size)
+
+	head := make([]byte, QuickHashChunk)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	state.Write(head[:n])
+
+	if size > 2*QuickHashChunk {
+		tail := make([]byte, QuickHashChunk)
+		if _, err := file.ReadAt(tail, size-QuickHashChunk); err != nil && err != io.EOF {
+			return "", err
+		}
+		state.Write(tail)
+	} else if size > QuickHashChunk {
+		// The tail overlaps the head; the rest of the file completes the content
+		if _, err := io.Copy(state, file); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(state.Sum(nil)), nil
+}
+
+// SampleHashRegions and SampleHashChunk are the number and size of the
+// regions a sampled hash reads, spread evenly from the start to the end of
+// the file
+const (
+	SampleHashRegions = 16
+	SampleHashChunk   = 1024 * 1024
+)
+
+// SampleHashName returns the name of the sampled hash built on h, which is
+// recorded in the index so sampled hashes are never taken for checksums
+func SampleHashName(h Hasher) string {
+	return fmt.Sprintf("%s-sampled-%dx%dMiB", h.Name(), SampleHashRegions, SampleHashChunk>>20)
+}
+
+// SampleHashFile calculates a fingerprint of a large file from its size and
+// SampleHashRegions regions of SampleHashChunk bytes, reading a fraction of
+// it. Files with different sampled hashes differ; files with equal ones
+// are likely identical, but only a full checksum proves it. Files too small
+// to be sampled are hashed completely.
+func SampleHashFile(h Hasher, fsys source.FS, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	state := h.New()
+	fmt.Fprintf(state, "%d:", size)
+	if size <= SampleHashRegions*SampleHashChunk {
+		if _, err := io.Copy(state, file); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(state.Sum(nil)), nil
+	}
+
+	chunk := make([]byte, SampleHashChunk)
+	for n := int64(0); n < SampleHashRegions; n++ {
+		offset := n * (size - SampleHashChunk) / (SampleHashRegions - 1)
+		if _, err := file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return "", err
+		}
+		state.Write(chunk)
+	}
+	return hex.EncodeToString(state.Sum(nil)), nil
+}
@@ -0,0 +1,126 @@
+package hasher
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// hashSetColumns maps the header names of the checksum columns of hash set
+// files, such as those of NSRL RDS and hashdeep files, to algorithm names
+var hashSetColumns = map[string]string{
+	"md5":     "md5",
+	"sha-1":   "sha1",
+	"sha1":    "sha1",
+	"sha-256": "sha256",
+	"sha256":  "sha256",
+	"blake3":  "blake3",
+	"xxhash":  "xxhash",
+}
+
+// ReadHashSet reads the checksums of a known hash set and passes them to add
+// one at a time, so sets of millions of files are never held in memory. It
+// reads the text format of the NSRL RDS (NSRLFile.txt), hashdeep files, CSV
+// files whose header names the algorithms of their columns, and lists with
+// one checksum per line, optionally followed by a path as md5sum writes it.
+// The checksums of such lists are of the algorithm named by BSD-style lines,
+// else of algorithm, else of the one their length suggests. Empty lines and
+// lines starting with # are skipped.
+func ReadHashSet(r io.Reader, algorithm string, add func(algorithm, checksum string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var columns map[int]string // algorithms by column, for files with a header
+	last := 0                  // the last checksum column
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// hashdeep files start with "%%%% HASHDEEP-1.0" and
+		// "%%%% size,md5,sha256,filename"
+		if fields, ok := strings.CutPrefix(trimmed, "%%%%"); ok {
+			if header, n := hashSetHeader(fields); len(header) > 0 {
+				columns, last = header, n
+			}
+			continue
+		}
+		if line == 1 && strings.Contains(text, ",") {
+			if header, n := hashSetHeader(text); len(header) > 0 {
+				columns, last = header, n
+				continue
+			}
+		}
+
+		if columns != nil {
+			// Checksums come before file names, which may contain commas
+			fields := strings.SplitN(text, ",", last+2)
+			if len(fields) <= last {
+				return fmt.Errorf("line %d: expected at least %d columns", line, last+1)
+			}
+			for n, name := range columns {
+				checksum := strings.Trim(strings.TrimSpace(fields[n]), `"`)
+				if checksum == "" {
+					continue
+				}
+				if err := addKnownHash(add, name, checksum); err != nil {
+					return fmt.Errorf("line %d: %v", line, err)
+				}
+			}
+			continue
+		}
+
+		entry := ManifestEntry{Checksum: strings.ToLower(trimmed)}
+		if strings.Contains(trimmed, " ") {
+			var err error
+			if entry, err = parseManifestLine(text); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+		} else if _, err := hex.DecodeString(entry.Checksum); err != nil {
+			return fmt.Errorf("line %d: invalid checksum %q", line, entry.Checksum)
+		}
+		name := entry.Algorithm
+		if name == "" {
+			name = algorithm
+		}
+		if name == "" {
+			name = lengthAlgorithm(entry.Checksum)
+		}
+		if name == "" {
+			return fmt.Errorf("line %d: cannot tell the algorithm of %q; pass -hash", line, entry.Checksum)
+		}
+		if err := addKnownHash(add, name, entry.Checksum); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// hashSetHeader returns the algorithms of the checksum columns of a header
+// line by column and the last of those columns
+func hashSetHeader(text string) (map[int]string, int) {
+	columns := make(map[int]string)
+	last := 0
+	for n, field := range strings.Split(text, ",") {
+		name := strings.ToLower(strings.Trim(strings.TrimSpace(field), `"`))
+		if algorithm, ok := hashSetColumns[name]; ok {
+			columns[n] = algorithm
+			last = n
+		}
+	}
+	return columns, last
+}
+
+// addKnownHash checks a checksum of a hash set and passes it to add
+func addKnownHash(add func(algorithm, checksum string) error, algorithm, checksum string) error {
+	checksum = strings.ToLower(checksum)
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return fmt.Errorf("invalid checksum %q", checksum)
+	}
+	return add(algorithm, checksum)
+}
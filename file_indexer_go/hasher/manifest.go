@@ -0,0 +1,250 @@
+package hasher
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ManifestEntry is a line of a checksum manifest, such as those written by
+// md5sum and sha256sum
+type ManifestEntry struct {
+	Checksum  string // lowercase hex
+	Path      string // as written in the manifest, relative or absolute
+	Algorithm string // set by BSD-style lines, which name their algorithm
+	Line      int
+}
+
+// bsdTags maps the algorithm tags of BSD-style lines to algorithm names
+var bsdTags = map[string]string{
+	"MD5":    "md5",
+	"SHA1":   "sha1",
+	"SHA256": "sha256",
+	"BLAKE3": "blake3",
+	"XXHASH": "xxhash",
+}
+
+// manifestExtensions maps the usual manifest file names to their algorithms
+var manifestExtensions = map[string]string{
+	".md5":    "md5",
+	".sha1":   "sha1",
+	".sha256": "sha256",
+	".b3":     "blake3",
+	".blake3": "blake3",
+	".xxh64":  "xxhash",
+	".xxhash": "xxhash",
+}
+
+// WriteManifestLine writes a checksum and path in the format of md5sum and
+// sha256sum. Paths with a newline or backslash are escaped with a leading
+// backslash on the line, as coreutils does.
+func WriteManifestLine(w io.Writer, checksum, path string) error {
+	prefix := ""
+	if strings.ContainsAny(path, "\\\n\r") {
+		prefix = "\\"
+		path = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(path)
+	}
+	_, err := fmt.Fprintf(w, "%s%s  %s\n", prefix, checksum, path)
+	return err
+}
+
+// ReadManifest reads a checksum manifest in the format of md5sum and
+// sha256sum, in text ("hash  path") or binary ("hash *path") mode, or in the
+// BSD style of "md5sum --tag" ("MD5 (path) = hash"). Empty lines and lines
+// starting with # are skipped.
+func ReadManifest(r io.Reader) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entry, err := parseManifestLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		entry.Line = line
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseManifestLine parses one line of a manifest
+func parseManifestLine(text string) (ManifestEntry, error) {
+	escaped := strings.HasPrefix(text, "\\")
+	if escaped {
+		text = text[1:]
+	}
+
+	var entry ManifestEntry
+	if tag, rest, ok := strings.Cut(text, " ("); ok && bsdTags[tag] != "" {
+		path, checksum, ok := cutLast(rest, ") = ")
+		if !ok {
+			return entry, fmt.Errorf("malformed %s line", tag)
+		}
+		entry = ManifestEntry{Checksum: checksum, Path: path, Algorithm: bsdTags[tag]}
+	} else {
+		checksum, path, ok := strings.Cut(text, " ")
+		if !ok || path == "" {
+			return entry, fmt.Errorf("expected a checksum and a path")
+		}
+		// The second character is a space in text mode and * in binary mode
+		path = path[1:]
+		entry = ManifestEntry{Checksum: checksum, Path: path}
+	}
+
+	entry.Checksum = strings.ToLower(entry.Checksum)
+	if _, err := hex.DecodeString(entry.Checksum); err != nil || entry.Checksum == "" {
+		return entry, fmt.Errorf("invalid checksum %q", entry.Checksum)
+	}
+	if escaped {
+		entry.Path = unescapeManifestPath(entry.Path)
+	}
+	return entry, nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if n := strings.LastIndex(s, sep); n >= 0 {
+		return s[:n], s[n+len(sep):], true
+	}
+	return s, "", false
+}
+
+// unescapeManifestPath undoes the escaping of WriteManifestLine
+func unescapeManifestPath(path string) string {
+	var out strings.Builder
+	for n := 0; n < len(path); n++ {
+		if path[n] != '\\' || n+1 == len(path) {
+			out.WriteByte(path[n])
+			continue
+		}
+		n++
+		switch path[n] {
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		default:
+			out.WriteByte(path[n])
+		}
+	}
+	return out.String()
+}
+
+// ManifestAlgorithm guesses the algorithm of a manifest from the algorithm
+// named by its entries, the extension or name of its file (sums.md5,
+// SHA256SUMS) or else the length of its checksums. SHA-256 and BLAKE3 have
+// the same length, so such manifests are taken to be SHA-256.
+func ManifestAlgorithm(path string, entries []ManifestEntry) (string, error) {
+	if len(entries) > 0 && entries[0].Algorithm != "" {
+		return entries[0].Algorithm, nil
+	}
+	if algorithm, ok := manifestExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return algorithm, nil
+	}
+	base := strings.ToLower(filepath.Base(path))
+	for _, name := range Names() {
+		if strings.HasPrefix(base, name+"sum") {
+			return name, nil
+		}
+	}
+	if len(entries) > 0 {
+		if algorithm := lengthAlgorithm(entries[0].Checksum); algorithm != "" {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("cannot tell the checksum algorithm of %s; pass -hash", path)
+}
+
+// lengthAlgorithm returns the algorithm whose checksums have the length of
+// checksum, or an empty string. SHA-256 and BLAKE3 have the same length, so
+// such checksums are taken to be SHA-256.
+func lengthAlgorithm(checksum string) string {
+	switch len(checksum) {
+	case 16:
+		return "xxhash"
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	}
+	return ""
+}
+
+// csvPathColumns and csvChecksumColumns are the header names recognized by
+// ReadChecksumCSV, in order of preference
+var (
+	csvPathColumns     = []string{"path", "full_path", "filepath", "file"}
+	csvChecksumColumns = []string{"checksum", "hash", "digest", "md5", "sha1", "sha256", "blake3", "xxhash"}
+)
+
+// ReadChecksumCSV reads checksums from a CSV file with a header row, such as
+// one written by the export command: the path is taken from a column named
+// path, full_path, filepath or file and the checksum from one named checksum,
+// hash, digest or after an algorithm, which then names the algorithm of the
+// entries. Rows without a checksum are skipped.
+func ReadChecksumCSV(r io.Reader) ([]ManifestEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %v", err)
+	}
+	for n := range header {
+		// Spreadsheets may start the file with a byte order mark
+		header[n] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[n], "\ufeff")))
+	}
+	pathColumn, checksumColumn := csvColumn(header, csvPathColumns), csvColumn(header, csvChecksumColumns)
+	if pathColumn < 0 || checksumColumn < 0 {
+		return nil, fmt.Errorf("the header must have a path and a checksum column")
+	}
+	algorithm := ""
+	if _, err := Get(header[checksumColumn]); err == nil {
+		algorithm = header[checksumColumn]
+	}
+
+	var entries []ManifestEntry
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) <= max(pathColumn, checksumColumn) {
+			return nil, fmt.Errorf("line %d: expected at least %d columns", line, max(pathColumn, checksumColumn)+1)
+		}
+		checksum := strings.ToLower(strings.TrimSpace(record[checksumColumn]))
+		if checksum == "" {
+			continue
+		}
+		if _, err := hex.DecodeString(checksum); err != nil {
+			return nil, fmt.Errorf("line %d: invalid checksum %q", line, checksum)
+		}
+		entries = append(entries, ManifestEntry{Checksum: checksum, Path: record[pathColumn], Algorithm: algorithm, Line: line})
+	}
+}
+
+// csvColumn returns the index of the first of names in header, or -1
+func csvColumn(header, names []string) int {
+	for _, name := range names {
+		if n := slices.Index(header, name); n >= 0 {
+			return n
+		}
+	}
+	return -1
+}
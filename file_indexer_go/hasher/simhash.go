@@ -0,0 +1,91 @@
+package hasher
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// ErrTooFewWords is returned by SimHash for texts too short to have a
+// meaningful fingerprint
+var ErrTooFewWords = errors.New("too few words for a text fingerprint")
+
+// shingleWords is the number of consecutive words hashed together, so that
+// texts with the same words in another order differ
+const shingleWords = 3
+
+// minSimHashWords is the number of words a text needs for a fingerprint
+const minSimHashWords = 10
+
+// SimHash returns the 64-bit simhash of a text as 16 hex digits. Words are
+// lowercased and hashed in overlapping runs of three; each bit of the
+// fingerprint is the majority of that bit over all runs. Slightly edited
+// copies of a text get fingerprints that differ in a few bits, see
+// models.SimHashSimilarity.
+func SimHash(r io.Reader) (string, error) {
+	var weights [64]int
+	var window []string
+	words := 0
+	shingle := fnv.New64a()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimFunc(scanner.Text(), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}))
+		if word == "" {
+			continue
+		}
+		words++
+		window = append(window, word)
+		if len(window) > shingleWords {
+			window = window[1:]
+		}
+		if len(window) < shingleWords {
+			continue
+		}
+
+		shingle.Reset()
+		shingle.Write([]byte(strings.Join(window, " ")))
+		sum := shingle.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if words < minSimHashWords {
+		return "", ErrTooFewWords
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fmt.Sprintf("%016x", fingerprint), nil
+}
+
+// SimHashFile returns the simhash of the text in a file
+func SimHashFile(fsys source.FS, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return SimHash(file)
+}
@@ -0,0 +1,47 @@
+package indexer
+
+import (
+	"context"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// recordAction appends a dedupe action that was carried out to the audit log
+// of the index, with the trash or quarantine directory of duplicates that
+// were moved. The action cannot be taken back, so failing to record it is
+// only logged.
+func (i *Indexer) recordAction(ctx context.Context, opts DedupeOptions, original, duplicate models.FileInfo, checksum string) {
+	record := models.ActionRecord{
+		PerformedAt: time.Now(),
+		Action:      string(opts.Action),
+		Path:        duplicate.Path,
+		Original:    original.Path,
+		Checksum:    checksum,
+		FileSize:    duplicate.FileSize,
+		Host:        i.host,
+		RunID:       opts.runID,
+	}
+	switch {
+	case opts.Action == DedupeMove:
+		record.Trash = opts.MoveTo
+	case opts.Action == DedupeDelete && opts.Trash.Dir != "":
+		record.Trash = opts.trashRun()
+	}
+	if !i.useDB {
+		i.index.Actions = append(i.index.Actions, record)
+		return
+	}
+	if err := i.db.RecordAction(ctx, record); err != nil {
+		i.logger.Warn("Error recording dedupe action", "path", duplicate.Path, "action", opts.Action, "err", err)
+	}
+}
+
+// Actions returns the audit log of the duplicates that dedupe deleted or
+// replaced with links, oldest first
+func (i *Indexer) Actions(ctx context.Context) ([]models.ActionRecord, error) {
+	if i.useDB {
+		return i.db.ListActions(ctx)
+	}
+	return i.index.Actions, nil
+}
@@ -0,0 +1,156 @@
+package indexer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// archiveFormat returns the format of an archive whose entries can be
+// indexed, judged by its file name, or "" for other files
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// scanArchive indexes the regular files inside an archive, passing a record
+// for each to emit. Entries are hashed while they are read, so tar archives
+// are read once from start to end. Archives nested in archives are indexed
+// as files only.
+func (i *Indexer) scanArchive(job scanJob, archivePath string, emit func(models.FileInfo)) {
+	var err error
+	switch archiveFormat(job.path) {
+	case "zip":
+		err = i.scanZip(job, archivePath, emit)
+	case "tar":
+		err = i.scanTar(job, archivePath, false, emit)
+	case "tar.gz":
+		err = i.scanTar(job, archivePath, true, emit)
+	}
+	if err != nil {
+		i.logger.Warn("Error reading archive", "path", job.path, "err", err)
+	}
+}
+
+// scanZip indexes the entries of a zip archive
+func (i *Indexer) scanZip(job scanJob, archivePath string, emit func(models.FileInfo)) error {
+	file, err := i.readFS(job).Open(job.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := zip.NewReader(file, job.info.Size())
+	if err != nil {
+		return err
+	}
+	for _, entry := range reader.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			i.logger.Warn("Error reading archive entry", "path", archivePath, "entry", entry.Name, "err", err)
+			continue
+		}
+		i.emitEntry(job, archivePath, entry.Name, int64(entry.UncompressedSize64), entry.Modified, content, emit)
+		content.Close()
+	}
+	return nil
+}
+
+// scanTar indexes the entries of a tar archive, optionally gzip-compressed
+func (i *Indexer) scanTar(job scanJob, archivePath string, gzipped bool, emit func(models.FileInfo)) error {
+	file, err := i.readFS(job).Open(job.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if gzipped {
+		decompressed, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		r = decompressed
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		i.emitEntry(job, archivePath, header.Name, header.Size, header.ModTime, reader, emit)
+	}
+}
+
+// emitEntry hashes an archive entry from r and emits its record, unless it
+// exceeds the size limit
+func (i *Indexer) emitEntry(job scanJob, archivePath, name string, size int64, modTime time.Time, r io.Reader, emit func(models.FileInfo)) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if job.maxFileSize > 0 && size > job.maxFileSize {
+		return
+	}
+
+	fileInfo := models.FileInfo{
+		Path:                 models.ArchiveEntryPath(archivePath, filepath.FromSlash(name)),
+		Filename:             path.Base(name),
+		ModificationDateTime: modTime,
+		FileSize:             size,
+		IndexedAt:            time.Now(),
+		Source:               models.SourceArchive,
+		Host:                 i.host,
+		Label:                job.label,
+	}
+
+	// The head of the entry is sniffed for its MIME type and then hashed
+	// with the rest, as entries cannot be read twice
+	state := i.hasher.New()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err == nil || err == io.ErrUnexpectedEOF || err == io.EOF {
+		state.Write(head[:n])
+		_, err = io.Copy(state, r)
+	}
+	if err != nil {
+		i.logger.Warn("Error calculating checksum", "path", fileInfo.Path, "err", fmt.Errorf("error reading archive entry: %v", err))
+	} else {
+		fileInfo.Checksum = hex.EncodeToString(state.Sum(nil))
+	}
+
+	if n == 0 {
+		fileInfo.MimeType = "inode/x-empty"
+	} else if mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n])); err == nil {
+		fileInfo.MimeType = mediaType
+	}
+
+	emit(fileInfo)
+}
@@ -0,0 +1,194 @@
+package indexer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+)
+
+// Backup writes a consistent copy of a DuckDB index to path, a tar archive
+// of the tables as exported by DuckDB, compressed according to the
+// extension as JSON indexes are: zstd for .zst and .zstd, gzip for .gz. It
+// can be taken while a scan of this indexer writes to the index. The archive
+// is written next to path and moved into place once complete, so an earlier
+// backup is only replaced by a complete one.
+func (i *Indexer) Backup(ctx context.Context, path string) error {
+	if !i.useDB {
+		return fmt.Errorf("backups are only available in database mode; copy the JSON index file instead")
+	}
+	start := time.Now()
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := i.db.Export(ctx, dir); err != nil {
+		return err
+	}
+
+	archive, err := createIndexFile(path)
+	if err != nil {
+		return err
+	}
+	if err := writeBackupArchive(archive, dir); err != nil {
+		archive.Discard()
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	i.logger.Info("Backed up index", "path", path, "duration", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// backupEvery backs up the index to path every interval until ctx is
+// cancelled or the returned function is called, which waits for a backup
+// being written
+func (i *Indexer) backupEvery(ctx context.Context, path string, interval time.Duration) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := i.Backup(ctx, path); err != nil {
+					i.logger.Warn("Could not back up the index during the scan", "path", path, "err", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+// writeBackupArchive writes the files of an export directory to a tar archive
+func writeBackupArchive(w io.Writer, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewWriter(w)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(archive, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// RestoreBackup creates the DuckDB index at indexPath from a backup written
+// by Backup. An existing index is only replaced with force, and only once the
+// backup was restored completely.
+func RestoreBackup(ctx context.Context, backupPath, indexPath string, force bool) error {
+	if db.IsPostgresURL(indexPath) {
+		return fmt.Errorf("PostgreSQL indexes are restored with pg_restore")
+	}
+	if _, err := os.Stat(indexPath); err == nil && !force {
+		return fmt.Errorf("%s already exists; pass -force to replace it", indexPath)
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(indexPath), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	exportDir := filepath.Join(dir, "export")
+	if err := extractBackup(backupPath, exportDir); err != nil {
+		return fmt.Errorf("error reading backup %s: %v", backupPath, err)
+	}
+	restored := filepath.Join(dir, filepath.Base(indexPath))
+	if err := db.ImportDatabase(ctx, restored, exportDir); err != nil {
+		return err
+	}
+
+	// The write-ahead log of the replaced index would be replayed into the
+	// restored one
+	if err := os.Remove(indexPath + ".wal"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Rename(restored, indexPath)
+}
+
+// extractBackup unpacks a backup archive into dir
+func extractBackup(path, dir string) error {
+	file, err := openIndexFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return err
+	}
+
+	archive := tar.NewReader(file)
+	found := make(map[string]bool)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Exports are flat, so anything else is not one
+		name := header.Name
+		if header.Typeflag != tar.TypeReg || name != filepath.Base(name) || name == "." || name == ".." {
+			return fmt.Errorf("unexpected entry %q; not a backup of an index", header.Name)
+		}
+		out, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, archive)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		found[name] = true
+	}
+	if !found["schema.sql"] || !found["load.sql"] {
+		return fmt.Errorf("not a backup of an index")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// SimilarImages returns the groups of indexed images below root whose
+// perceptual hashes are at most maxDistance bits apart. Only images indexed
+// with ImageHash have hashes. An empty root covers the whole index.
+func (i *Indexer) SimilarImages(ctx context.Context, root string, maxDistance int) ([]models.SimilarImageGroup, error) {
+	if root != "" {
+		root = filepath.Clean(absolutePath(root))
+	}
+	if !i.useDB {
+		files := i.hashedFiles(root, func(file models.FileInfo) string { return file.ImageHash })
+		return models.GroupSimilarImages(files, maxDistance), nil
+	}
+	files, err := i.db.ListImageHashes(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	return models.GroupSimilarImages(files, maxDistance), nil
+}
+
+// SimilarTexts returns the groups of indexed text files below root whose
+// simhashes are at least minSimilarity percent similar. Only files indexed
+// with TextHash have simhashes. An empty root covers the whole index.
+func (i *Indexer) SimilarTexts(ctx context.Context, root string, minSimilarity float64) ([]models.SimilarTextGroup, error) {
+	if root != "" {
+		root = filepath.Clean(absolutePath(root))
+	}
+	if !i.useDB {
+		files := i.hashedFiles(root, func(file models.FileInfo) string { return file.TextHash })
+		return models.GroupSimilarTexts(files, minSimilarity), nil
+	}
+	files, err := i.db.ListTextHashes(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	return models.GroupSimilarTexts(files, minSimilarity), nil
+}
+
+// SamePhotos returns the groups of indexed photos of the same shot, with the
+// same EXIF capture time, pixel dimensions and camera, whatever their bytes.
+// Only files indexed with EXIF have capture times.
+func (i *Indexer) SamePhotos(ctx context.Context) ([]models.PhotoGroup, error) {
+	if !i.useDB {
+		files := i.hashedFiles("", func(file models.FileInfo) string {
+			if file.TakenAt.IsZero() {
+				return ""
+			}
+			return file.TakenAt.String()
+		})
+		return models.GroupPhotos(files), nil
+	}
+	files, err := i.db.ListPhotos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return models.GroupPhotos(files), nil
+}
+
+// hashedFiles returns the files of a JSON index below root that have the
+// similarity hash returned by hashOf, in path order
+func (i *Indexer) hashedFiles(root string, hashOf func(models.FileInfo) string) []models.FileInfo {
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	var files []models.FileInfo
+	for _, file := range i.index.Files {
+		if hashOf(file) != "" && (root == "" || strings.HasPrefix(file.Path, prefix)) {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(a, b int) bool { return files[a].Path < files[b].Path })
+	return files
+}
+
+// CaseCollisions returns the indexed files below root whose paths differ
+// only by case, and so would overwrite each other when copied to a
+// case-insensitive filesystem. An empty root covers the whole index. Entries
+// of archives and alternate data streams are not checked.
+func (i *Indexer) CaseCollisions(ctx context.Context, root string) ([]models.CaseCollision, error) {
+	if root != "" {
+		root = filepath.Clean(absolutePath(root))
+	}
+	if i.useDB {
+		return i.db.FindCaseCollisions(ctx, root)
+	}
+
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	var files []models.FileInfo
+	for _, file := range i.index.Files {
+		if file.Source == models.SourceArchive || file.Source == models.SourceStream {
+			continue
+		}
+		if root == "" || strings.HasPrefix(file.Path, prefix) {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(a, b int) bool { return files[a].Path < files[b].Path })
+	return models.GroupCaseCollisions(files), nil
+}
@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// indexCompression returns the compression of a JSON index file judged by
+// its extension, "gzip" for .gz, "zstd" for .zst and .zstd or "" for plain
+// JSON
+func indexCompression(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz":
+		return "gzip"
+	case ".zst", ".zstd":
+		return "zstd"
+	}
+	return ""
+}
+
+// createIndexFile creates a JSON index file, compressing what is written to
+// it according to its extension. The index is written to a temporary file
+// next to it that Close moves into place, so readers never see a partly
+// written index and a failed save keeps the previous one; Discard drops the
+// temporary file instead.
+func createIndexFile(path string) (*indexFile, error) {
+	// Indexes reached through a symlink are replaced at the link's target
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	index := &indexFile{Writer: file, file: file, path: path}
+	switch indexCompression(path) {
+	case "gzip":
+		index.compressor = gzip.NewWriter(file)
+	case "zstd":
+		index.compressor, err = zstd.NewWriter(file)
+		if err != nil {
+			index.Discard()
+			return nil, err
+		}
+	}
+	if index.compressor != nil {
+		index.Writer = index.compressor
+	}
+	return index, nil
+}
+
+// indexFile is a JSON index being written to a temporary file
+type indexFile struct {
+	io.Writer
+	compressor io.WriteCloser // nil for plain JSON
+	file       *os.File
+	path       string
+}
+
+// Close flushes the compressor, closes the file and replaces the index with it
+func (f *indexFile) Close() error {
+	var err error
+	if f.compressor != nil {
+		err = f.compressor.Close()
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.file.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.file.Name())
+	}
+	return err
+}
+
+// Discard closes and removes the temporary file, keeping the index as it was
+func (f *indexFile) Discard() {
+	if f.compressor != nil {
+		f.compressor.Close()
+	}
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+// openIndexFile opens a JSON index file, decompressing it according to its
+// extension
+func openIndexFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch indexCompression(path) {
+	case "gzip":
+		decompressor, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressedFile{Reader: decompressor, close: decompressor.Close, file: file}, nil
+	case "zstd":
+		decompressor, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressedFile{Reader: decompressor, close: func() error { decompressor.Close(); return nil }, file: file}, nil
+	}
+	return file, nil
+}
+
+// decompressedFile is a decompressing reader from a file
+type decompressedFile struct {
+	io.Reader
+	close func() error
+	file  *os.File
+}
+
+// Close releases the decompressor and closes the file
+func (d *decompressedFile) Close() error {
+	err := d.close()
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,439 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/fsmeta"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// DedupeAction selects what is done with the duplicates of a group
+type DedupeAction string
+
+const (
+	DedupeDelete   DedupeAction = "delete"   // Remove duplicates
+	DedupeHardlink DedupeAction = "hardlink" // Replace duplicates with hardlinks to the original
+	DedupeSymlink  DedupeAction = "symlink"  // Replace duplicates with symlinks to the original
+	DedupeReflink  DedupeAction = "reflink"  // Make duplicates share the original's storage (copy-on-write)
+	DedupeMove     DedupeAction = "move"     // Move duplicates into a quarantine directory
+)
+
+// DedupeOptions controls a deduplication run
+type DedupeOptions struct {
+	Action DedupeAction
+	DryRun bool           // Only report what would be done
+	Policy OriginalPolicy // Which file of each group is kept
+	MoveTo string         // Quarantine directory of the move action
+	Trash  TrashOptions   // Where the delete action moves duplicates instead of removing them
+
+	runID string // ID of the run in the audit log, set by Dedupe
+}
+
+// DedupeResult describes what happened to one duplicate
+type DedupeResult struct {
+	Original  string
+	Duplicate string
+	Checksum  string
+	Size      int64
+	RunID     string // The run in the audit log, which UndoRun takes (empty in a dry run)
+	Done      bool   // The action was carried out (always false in a dry run)
+	Err       error  // Why the duplicate was skipped or the action failed
+}
+
+// ParseDedupeAction validates the name of a dedupe action
+func ParseDedupeAction(name string) (DedupeAction, error) {
+	switch action := DedupeAction(name); action {
+	case DedupeDelete, DedupeHardlink, DedupeSymlink, DedupeReflink, DedupeMove:
+		return action, nil
+	}
+	return "", fmt.Errorf("unknown dedupe action %q (supported: delete, hardlink, symlink, reflink, move)", name)
+}
+
+// Dedupe applies an action to the duplicates of every duplicate group, keeping
+// the group's original. Before a file is touched, the original and the
+// duplicate are re-hashed, so files that changed since indexing are skipped.
+// Hardlinks are only made to originals on the same filesystem. The index is
+// updated to reflect the changes, which are also appended to its audit log
+// (see Actions) under the ID of the run, which UndoRun takes for deletions
+// moved to the trash and moves to quarantine; call SaveIndex afterwards. If
+// ctx is cancelled, the results so far are returned with ctx's error.
+func (i *Indexer) Dedupe(ctx context.Context, opts DedupeOptions) ([]DedupeResult, error) {
+	if i.readOnly && !opts.DryRun {
+		return nil, ErrReadOnly
+	}
+	if opts.Action == DedupeMove {
+		if opts.MoveTo == "" {
+			return nil, fmt.Errorf("the move action requires a quarantine directory")
+		}
+		opts.MoveTo = filepath.Clean(absolutePath(opts.MoveTo))
+	}
+	if err := i.useStoredHasher(ctx); err != nil {
+		return nil, err
+	}
+	if opts.Action != DedupeDelete {
+		opts.Trash = TrashOptions{}
+	}
+	if !opts.DryRun {
+		if opts.Trash.Dir != "" {
+			opts.Trash.Dir = absolutePath(opts.Trash.Dir)
+		}
+		i.expireTrash(opts.Trash)
+		opts.runID = newRunID()
+	}
+	groups, err := i.FindDuplicates(ctx, opts.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DedupeResult
+	for _, group := range groups {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		original := group.Files[0]
+
+		// Dry runs skip re-hashing, but still report originals that are not local
+		var originalErr error
+		if !opts.DryRun || !i.isLocal(original) {
+			originalErr = i.verifyChecksum(original, group.Checksum)
+		}
+
+		for _, duplicate := range group.Files[1:] {
+			result := DedupeResult{Original: original.Path, Duplicate: duplicate.Path, Checksum: group.Checksum, Size: group.FileSize,
+				RunID: opts.runID}
+			keepRule := opts.Policy.KeepRule(duplicate)
+			switch {
+			case keepRule != "":
+				result.Err = fmt.Errorf("kept by rule %s", keepRule)
+			case i.checkProtectedAliases(group, duplicate) != nil:
+				result.Err = i.checkProtectedAliases(group, duplicate)
+			case (opts.Action == DedupeHardlink || opts.Action == DedupeReflink) && duplicate.IsHardlinkOf(original):
+				result.Err = fmt.Errorf("already a hardlink of the original")
+			case opts.Action == DedupeHardlink && original.Device != 0 && duplicate.Device != 0 && original.Device != duplicate.Device:
+				result.Err = fmt.Errorf("on a different filesystem than the original")
+			case originalErr != nil:
+				result.Err = fmt.Errorf("original failed verification: %v", originalErr)
+			case !i.isLocal(duplicate):
+				result.Err = fmt.Errorf("not a local file")
+			case sameEntry(original.Path, duplicate.Path):
+				result.Err = errSameEntry
+			case opts.Action == DedupeMove && inQuarantine(opts.MoveTo, duplicate.Path):
+				result.Err = fmt.Errorf("already in the quarantine directory")
+			case opts.Trash.Dir != "" && inQuarantine(opts.Trash.Dir, duplicate.Path):
+				result.Err = fmt.Errorf("already in the trash")
+			case opts.DryRun:
+			default:
+				result.Err = i.dedupeFile(ctx, original, duplicate, group.Checksum, opts)
+				result.Done = result.Err == nil
+				if companion, ok := group.Companions[duplicate.Location()]; ok && result.Done {
+					switch {
+					case opts.Action == DedupeDelete && opts.Trash.Dir != "":
+						i.quarantineCompanion(ctx, opts.trashRun(), companion, duplicate)
+					case opts.Action == DedupeDelete:
+						i.removeCompanion(ctx, companion)
+					case opts.Action == DedupeMove:
+						i.quarantineCompanion(ctx, opts.MoveTo, companion, duplicate)
+					}
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// removeCompanion deletes the AppleDouble file of a deleted duplicate, which
+// describes a file that no longer exists
+func (i *Indexer) removeCompanion(ctx context.Context, companion models.FileInfo) {
+	if !i.isLocal(companion) {
+		return
+	}
+	if err := i.checkProtected(companion.Path); err != nil {
+		i.logger.Warn("Not deleting AppleDouble file", "path", companion.Path, "err", err)
+		return
+	}
+	if err := os.Remove(companion.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		i.logger.Warn("Error deleting AppleDouble file", "path", companion.Path, "err", err)
+		return
+	}
+	if err := i.removePath(ctx, companion.Path); err != nil {
+		i.logger.Warn("Error removing AppleDouble file from index", "path", companion.Path, "err", err)
+		return
+	}
+	i.logger.Info("Deleted AppleDouble file", "path", companion.Path)
+}
+
+// quarantineCompanion moves the AppleDouble file of a quarantined duplicate
+// along with it
+func (i *Indexer) quarantineCompanion(ctx context.Context, dir string, companion, duplicate models.FileInfo) {
+	if !i.isLocal(companion) {
+		return
+	}
+	if err := i.checkProtected(companion.Path); err != nil {
+		i.logger.Warn("Not moving AppleDouble file to quarantine", "path", companion.Path, "err", err)
+		return
+	}
+	if err := i.quarantineFile(ctx, dir, companion, duplicate.Path); err != nil {
+		i.logger.Warn("Error moving AppleDouble file to quarantine", "path", companion.Path, "err", err)
+	}
+}
+
+// verifyChecksum checks that a local file still has the checksum it was
+// indexed with. Files of other sources or hosts cannot be verified or
+// changed.
+func (i *Indexer) verifyChecksum(file models.FileInfo, checksum string) error {
+	if !i.isLocal(file) {
+		return fmt.Errorf("%s is not a local file", file.Path)
+	}
+	current, err := hasher.HashFile(i.hasher, source.OS, file.Path)
+	if err != nil {
+		return err
+	}
+	if current != checksum {
+		return fmt.Errorf("checksum of %s changed since indexing", file.Path)
+	}
+	return nil
+}
+
+// dedupeFile applies an action to a single verified duplicate and records it
+// in the audit log of the index
+func (i *Indexer) dedupeFile(ctx context.Context, original, duplicate models.FileInfo, checksum string, opts DedupeOptions) error {
+	if err := i.verifyChecksum(duplicate, checksum); err != nil {
+		return fmt.Errorf("duplicate failed verification: %v", err)
+	}
+	if err := i.applyDedupe(ctx, original, duplicate, opts); err != nil {
+		return err
+	}
+	i.recordAction(ctx, opts, original, duplicate, checksum)
+	return nil
+}
+
+// trashRun returns the directory of the run in the trash, where its deleted
+// duplicates are moved
+func (opts DedupeOptions) trashRun() string {
+	return filepath.Join(opts.Trash.Dir, opts.runID)
+}
+
+// checkProtected refuses changes to the files of the safelist set by
+// SetProtected
+func (i *Indexer) checkProtected(path string) error {
+	if rule := i.protected.protectRule(path); rule != "" {
+		return fmt.Errorf("protected by rule %s", rule)
+	}
+	return nil
+}
+
+// checkProtectedAliases refuses changes to a duplicate that is on the
+// safelist under any of its paths
+func (i *Indexer) checkProtectedAliases(group models.DuplicateGroup, duplicate models.FileInfo) error {
+	for _, path := range append([]string{duplicate.Path}, group.Aliases[duplicate.Location()]...) {
+		if err := i.checkProtected(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDedupe deletes, moves or links a duplicate. Files of the safelist, and
+// duplicates that are the original itself under another path, are refused
+// here, right before they would be changed, whatever the caller checked.
+func (i *Indexer) applyDedupe(ctx context.Context, original, duplicate models.FileInfo, opts DedupeOptions) error {
+	if err := i.checkProtected(duplicate.Path); err != nil {
+		return err
+	}
+	if sameEntry(original.Path, duplicate.Path) {
+		return errSameEntry
+	}
+	switch opts.Action {
+	case DedupeDelete:
+		if opts.Trash.Dir != "" {
+			if opts.Trash.SameFilesystem {
+				if err := checkTrashFilesystem(opts.Trash.Dir, duplicate.Path); err != nil {
+					return err
+				}
+			}
+			return i.quarantineFile(ctx, opts.trashRun(), duplicate, original.Path)
+		}
+		if err := os.Remove(duplicate.Path); err != nil {
+			return err
+		}
+		i.logger.Info("Deleted duplicate", "path", duplicate.Path, "original", original.Path)
+		return i.removePath(ctx, duplicate.Path)
+
+	case DedupeHardlink:
+		if err := checkSameDevice(original.Path, duplicate.Path); err != nil {
+			return err
+		}
+		if err := replaceFile(duplicate.Path, func(tmp string) error { return os.Link(original.Path, tmp) }); err != nil {
+			return err
+		}
+		i.logger.Info("Hardlinked duplicate", "path", duplicate.Path, "original", original.Path)
+
+		// The path now shares the original's inode, owner and permissions
+		if info, err := os.Lstat(duplicate.Path); err == nil {
+			if id, ok := fsmeta.FileID(info); ok {
+				duplicate.Device, duplicate.Inode = id.Device, id.Inode
+			}
+			if links, ok := fsmeta.LinkCount(info); ok {
+				duplicate.LinkCount = links
+			}
+			setOwner(&duplicate, info)
+			// The original has gained the link as well
+			if links, ok := fsmeta.LinkCount(info); ok {
+				original.LinkCount = links
+				if err := i.storeFileIdentity(ctx, original); err != nil {
+					return err
+				}
+			}
+		}
+		return i.storeFileIdentity(ctx, duplicate)
+
+	case DedupeSymlink:
+		if err := replaceFile(duplicate.Path, func(tmp string) error { return os.Symlink(original.Path, tmp) }); err != nil {
+			return err
+		}
+		i.logger.Info("Symlinked duplicate", "path", duplicate.Path, "original", original.Path)
+
+		// Symlinks are not indexed as regular files
+		return i.removePath(ctx, duplicate.Path)
+
+	case DedupeReflink:
+		if err := reflink(original.Path, duplicate.Path); err != nil {
+			return err
+		}
+		i.logger.Info("Reflinked duplicate", "path", duplicate.Path, "original", original.Path)
+
+		// Clones made under a temporary name are new inodes
+		if info, err := os.Lstat(duplicate.Path); err == nil {
+			if id, ok := fsmeta.FileID(info); ok {
+				duplicate.Device, duplicate.Inode = id.Device, id.Inode
+			}
+		}
+		return i.storeFileIdentity(ctx, duplicate)
+
+	case DedupeMove:
+		return i.quarantineFile(ctx, opts.MoveTo, duplicate, original.Path)
+	}
+	return fmt.Errorf("unknown dedupe action %q", opts.Action)
+}
+
+// storeFileIdentity stores the inode, link count, owner and permissions of a
+// duplicate replaced by a link. Only those are written, as duplicates are
+// read without their content.
+func (i *Indexer) storeFileIdentity(ctx context.Context, file models.FileInfo) error {
+	if i.useDB {
+		return i.db.UpdateFileIdentity(ctx, file)
+	}
+	stored, ok := i.index.Files[file.Path]
+	if !ok {
+		return nil
+	}
+	stored.Device, stored.Inode, stored.LinkCount = file.Device, file.Inode, file.LinkCount
+	stored.UID, stored.GID, stored.UserName, stored.GroupName, stored.Mode = file.UID, file.GID, file.UserName, file.GroupName, file.Mode
+	i.index.Files[file.Path] = stored
+	return nil
+}
+
+// checkSameDevice checks that a duplicate is on the filesystem of its
+// original, which hardlinks cannot leave. Platforms without device numbers
+// leave the check to the link itself.
+func checkSameDevice(original, duplicate string) error {
+	originalInfo, err := os.Stat(original)
+	if err != nil {
+		return err
+	}
+	duplicateInfo, err := os.Lstat(duplicate)
+	if err != nil {
+		return err
+	}
+	originalID, ok := fsmeta.FileID(originalInfo)
+	duplicateID, duplicateOK := fsmeta.FileID(duplicateInfo)
+	if ok && duplicateOK && originalID.Device != duplicateID.Device {
+		return fmt.Errorf("on a different filesystem than the original")
+	}
+	return nil
+}
+
+// errSameEntry refuses duplicates whose removal would remove the original
+var errSameEntry = errors.New("the same file as the original, reached through another path")
+
+// sameEntry reports whether two paths lead to one directory entry, as through
+// a bind mount or a symlinked directory, so that changing one changes the
+// other: their names match and their directories are the same directory
+func sameEntry(original, duplicate string) bool {
+	if filepath.Base(original) != filepath.Base(duplicate) {
+		return false
+	}
+	originalDir, err := os.Stat(filepath.Dir(original))
+	if err != nil {
+		return false
+	}
+	duplicateDir, err := os.Stat(filepath.Dir(duplicate))
+	return err == nil && os.SameFile(originalDir, duplicateDir)
+}
+
+// replaceFile atomically replaces path with a link created by create. The
+// link is made under a temporary name in the same directory and renamed over
+// path, so path is never missing if linking fails.
+func replaceFile(path string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.dedupe-%d", filepath.Base(path), os.Getpid()))
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// DuplicateDeletion is a file chosen for deletion during a duplicate review,
+// together with a copy of it that is kept
+type DuplicateDeletion struct {
+	Checksum  string
+	Kept      models.FileInfo
+	Duplicate models.FileInfo
+}
+
+// DeleteDuplicates deletes files chosen during a duplicate review. Like Dedupe,
+// it re-hashes the kept copy and the duplicate first and skips files that
+// changed since indexing, and moves the duplicates into the trash unless its
+// directory is empty. Call SaveIndex afterwards. If ctx is cancelled, the
+// results so far are returned with ctx's error.
+func (i *Indexer) DeleteDuplicates(ctx context.Context, deletions []DuplicateDeletion, trash TrashOptions) ([]DedupeResult, error) {
+	if i.readOnly {
+		return nil, ErrReadOnly
+	}
+	if err := i.useStoredHasher(ctx); err != nil {
+		return nil, err
+	}
+	opts := DedupeOptions{Action: DedupeDelete, Trash: trash}
+	if opts.Trash.Dir != "" {
+		opts.Trash.Dir = absolutePath(opts.Trash.Dir)
+	}
+	i.expireTrash(opts.Trash)
+	opts.runID = newRunID()
+	results := make([]DedupeResult, 0, len(deletions))
+	for _, deletion := range deletions {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		result := DedupeResult{Original: deletion.Kept.Path, Duplicate: deletion.Duplicate.Path, Checksum: deletion.Checksum,
+			Size: deletion.Duplicate.FileSize, RunID: opts.runID}
+		if err := i.verifyChecksum(deletion.Kept, deletion.Checksum); err != nil {
+			result.Err = fmt.Errorf("kept copy failed verification: %v", err)
+		} else {
+			result.Err = i.dedupeFile(ctx, deletion.Kept, deletion.Duplicate, deletion.Checksum, opts)
+			result.Done = result.Err == nil
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,214 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dedupeFixture writes two identical text files and another one below a
+// temporary directory, and indexes them with their content
+func dedupeFixture(t *testing.T, useDB bool) (*Indexer, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a/original.txt":  "duplicated content\n",
+		"b/duplicate.txt": "duplicated content\n",
+		"c/other.txt":     "other content\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx := newTestIndexer(t, useDB, nil)
+	if err := idx.IndexDirectory(context.Background(), root, ScanOptions{Content: true}); err != nil {
+		t.Fatalf("IndexDirectory: %v", err)
+	}
+	return idx, root
+}
+
+func TestDedupeActions(t *testing.T) {
+	tests := []struct {
+		action      DedupeAction
+		trash       bool
+		wantOnDisk  string // what is left at the path of the duplicate
+		wantIndexed bool   // the duplicate is still indexed, with its content
+		wantUndo    bool   // UndoRun brings the duplicate back
+	}{
+		{action: DedupeDelete, wantOnDisk: "missing"},
+		{action: DedupeDelete, trash: true, wantOnDisk: "missing", wantUndo: true},
+		{action: DedupeHardlink, wantOnDisk: "hardlink", wantIndexed: true},
+		{action: DedupeSymlink, wantOnDisk: "symlink"},
+		{action: DedupeMove, wantOnDisk: "missing", wantUndo: true},
+	}
+	for _, tt := range tests {
+		name := string(tt.action)
+		if tt.trash {
+			name += " to trash"
+		}
+		t.Run(name, func(t *testing.T) {
+			backends(t, func(t *testing.T, useDB bool) {
+				ctx := context.Background()
+				idx, root := dedupeFixture(t, useDB)
+				original := filepath.Join(root, "a/original.txt")
+				duplicate := filepath.Join(root, "b/duplicate.txt")
+
+				opts := DedupeOptions{Action: tt.action}
+				if tt.action == DedupeMove {
+					opts.MoveTo = filepath.Join(t.TempDir(), "quarantine")
+				}
+				if tt.trash {
+					opts.Trash = TrashOptions{Dir: filepath.Join(t.TempDir(), "trash")}
+				}
+
+				results, err := idx.Dedupe(ctx, opts)
+				if err != nil {
+					t.Fatalf("Dedupe: %v", err)
+				}
+				if len(results) != 1 || !results[0].Done || results[0].Err != nil {
+					t.Fatalf("Dedupe = %+v, want one result done", results)
+				}
+				if results[0].Original != original || results[0].Duplicate != duplicate {
+					t.Fatalf("deduplicated %s against %s, want %s against %s", results[0].Duplicate, results[0].Original, duplicate, original)
+				}
+
+				checkOnDisk(t, original, duplicate, tt.wantOnDisk)
+				records, err := idx.Actions(ctx)
+				if err != nil {
+					t.Fatalf("Actions: %v", err)
+				}
+				if len(records) != 1 || records[0].Action != string(tt.action) || records[0].Path != duplicate || records[0].Original != original {
+					t.Errorf("audit log %+v, want the %s of the duplicate", records, tt.action)
+				}
+				found, err := idx.GetFileByPathAndFilename(ctx, duplicate, filepath.Base(duplicate))
+				if err != nil {
+					t.Fatalf("GetFileByPathAndFilename: %v", err)
+				}
+				if (found != nil) != tt.wantIndexed {
+					t.Errorf("duplicate indexed: %v, want %v", found != nil, tt.wantIndexed)
+				}
+				if tt.wantIndexed {
+					checkContent(t, idx, duplicate, "duplicated content\n")
+				}
+				checkContent(t, idx, original, "duplicated content\n")
+				if tt.action == DedupeHardlink {
+					stored, err := idx.GetFileByPathAndFilename(ctx, original, filepath.Base(original))
+					if err != nil || stored == nil {
+						t.Fatalf("original not found: %v", err)
+					}
+					if stored.LinkCount != 2 || found.LinkCount != 2 || stored.Inode != found.Inode {
+						t.Errorf("linked files stored with link counts %d and %d and inodes %d and %d",
+							stored.LinkCount, found.LinkCount, stored.Inode, found.Inode)
+					}
+				}
+
+				if !tt.wantUndo {
+					return
+				}
+				restored, err := idx.UndoRun(ctx, results[0].RunID, false)
+				if err != nil {
+					t.Fatalf("UndoRun: %v", err)
+				}
+				if len(restored) != 1 || !restored[0].Done {
+					t.Fatalf("UndoRun = %+v, want the duplicate restored", restored)
+				}
+				checkOnDisk(t, original, duplicate, "file")
+				checkContent(t, idx, duplicate, "duplicated content\n")
+			})
+		})
+	}
+}
+
+func TestDedupeDryRun(t *testing.T) {
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		idx, root := dedupeFixture(t, useDB)
+		results, err := idx.Dedupe(ctx, DedupeOptions{Action: DedupeDelete, DryRun: true})
+		if err != nil {
+			t.Fatalf("Dedupe: %v", err)
+		}
+		if len(results) != 1 || results[0].Done || results[0].Err != nil {
+			t.Fatalf("Dedupe = %+v, want one result not done", results)
+		}
+		if records, err := idx.Actions(ctx); err != nil || len(records) != 0 {
+			t.Errorf("a dry run was logged: %+v, %v", records, err)
+		}
+		for _, name := range []string{"a/original.txt", "b/duplicate.txt"} {
+			if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+				t.Errorf("a dry run changed %s: %v", name, err)
+			}
+		}
+	})
+}
+
+func TestDedupeSkipsChangedDuplicates(t *testing.T) {
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		idx, root := dedupeFixture(t, useDB)
+		if err := os.WriteFile(filepath.Join(root, "b/duplicate.txt"), []byte("changed content\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "a/original.txt"), []byte("changed content\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		results, err := idx.Dedupe(ctx, DedupeOptions{Action: DedupeDelete})
+		if err != nil {
+			t.Fatalf("Dedupe: %v", err)
+		}
+		if len(results) != 1 || results[0].Done || results[0].Err == nil {
+			t.Fatalf("Dedupe = %+v, want the changed files skipped", results)
+		}
+		for _, name := range []string{"a/original.txt", "b/duplicate.txt"} {
+			if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+				t.Errorf("a changed file was deleted: %v", err)
+			}
+		}
+	})
+}
+
+// checkOnDisk checks what is left at the path of a deduplicated file
+func checkOnDisk(t *testing.T, original, duplicate, want string) {
+	t.Helper()
+	info, err := os.Lstat(duplicate)
+	switch want {
+	case "missing":
+		if !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", duplicate, err)
+		}
+		return
+	case "symlink":
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s is not a symlink: %v", duplicate, err)
+		} else if target, _ := os.Readlink(duplicate); target != original {
+			t.Errorf("%s links to %s, want %s", duplicate, target, original)
+		}
+		return
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("%s is not a regular file: %v", duplicate, err)
+	}
+	originalInfo, err := os.Stat(original)
+	if err != nil {
+		t.Fatalf("the original is gone: %v", err)
+	}
+	if linked := os.SameFile(info, originalInfo); linked != (want == "hardlink") {
+		t.Errorf("%s is a hardlink of the original: %v, want %v", duplicate, linked, want == "hardlink")
+	}
+}
+
+// checkContent checks the content stored for an indexed file
+func checkContent(t *testing.T, idx *Indexer, path, want string) {
+	t.Helper()
+	content, err := idx.storedContent(context.Background(), path)
+	if err != nil {
+		t.Fatalf("storedContent: %v", err)
+	}
+	if content != want {
+		t.Errorf("%s has stored content %q, want %q", path, content, want)
+	}
+}
@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// journalDeletions records the indexed files at paths in the deletion
+// journal before they are pruned
+func (i *Indexer) journalDeletions(ctx context.Context, paths []string) error {
+	deletedAt := time.Now()
+	if i.useDB {
+		return i.db.RecordDeletions(ctx, paths, deletedAt)
+	}
+	for _, path := range paths {
+		if file, ok := i.index.Files[path]; ok {
+			i.index.Deletions = append(i.index.Deletions, models.NewDeletedFile(file, deletedAt))
+		}
+	}
+	return nil
+}
+
+// journalRemoval records a vanished file, or all files below a vanished
+// directory, in the deletion journal before they are removed from the index
+func (i *Indexer) journalRemoval(ctx context.Context, absPath string) error {
+	below, err := i.filesBelow(ctx, absPath)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(below))
+	for path := range below {
+		paths = append(paths, path)
+	}
+	return i.journalDeletions(ctx, paths)
+}
+
+// purgeDeletions drops the entries of the deletion journal older than keep
+func (i *Indexer) purgeDeletions(ctx context.Context, keep time.Duration) error {
+	before := time.Now().Add(-keep)
+	var purged int64
+	if i.useDB {
+		var err error
+		if purged, err = i.db.PurgeDeletions(ctx, before); err != nil {
+			return err
+		}
+	} else {
+		kept := i.index.Deletions[:0]
+		for _, deleted := range i.index.Deletions {
+			if deleted.DeletedAt.Before(before) {
+				purged++
+				continue
+			}
+			kept = append(kept, deleted)
+		}
+		i.index.Deletions = kept
+	}
+	if purged > 0 {
+		i.logger.Info("Purged old entries of the deletion journal", "entries", purged, "before", before.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Deletions returns the files that scans and watches found gone, oldest
+// first. A streamed index has its journal read from the start of its file.
+func (i *Indexer) Deletions(ctx context.Context, query models.DeletionQuery) ([]models.DeletedFile, error) {
+	if query.Dir != "" {
+		query.Dir = filepath.Clean(absolutePath(query.Dir))
+	}
+	if i.useDB {
+		return i.db.ListDeletions(ctx, query)
+	}
+	journal := i.index.Deletions
+	if i.streamed {
+		header, err := i.streamedHeader()
+		if err != nil {
+			return nil, err
+		}
+		journal = header.Deletions
+	}
+
+	var deleted []models.DeletedFile
+	for _, file := range journal {
+		if query.Matches(file) {
+			deleted = append(deleted, file)
+		}
+	}
+	sort.SliceStable(deleted, func(a, b int) bool {
+		if !deleted[a].DeletedAt.Equal(deleted[b].DeletedAt) {
+			return deleted[a].DeletedAt.Before(deleted[b].DeletedAt)
+		}
+		return deleted[a].Path < deleted[b].Path
+	})
+	return deleted, nil
+}
+
+// streamedHeader reads the fields of a streamed JSON index other than its
+// files, such as its tags and deletion journal
+func (i *Indexer) streamedHeader() (models.Index, error) {
+	var header models.Index
+	file, err := openIndexFile(i.indexPath)
+	if err != nil {
+		return header, fmt.Errorf("error reading index file: %v", err)
+	}
+	defer file.Close()
+	if err := readIndexHeader(file, &header); err != nil {
+		return header, fmt.Errorf("error reading index file: %v", err)
+	}
+	return header, nil
+}
@@ -0,0 +1,153 @@
+package indexer
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// ChangeKind classifies how a file differs between two indexes
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"    // Only in the new index
+	ChangeRemoved  ChangeKind = "removed"  // Only in the old index
+	ChangeModified ChangeKind = "modified" // Same path, different size, mtime or checksum
+	ChangeMoved    ChangeKind = "moved"    // Same content, different path
+)
+
+// FileChange describes one difference between two indexes. Old is nil for
+// added files and New is nil for removed files.
+type FileChange struct {
+	Kind   ChangeKind       `json:"change"`
+	Path   string           `json:"path"`             // Path in the new index, or the old one for removed files
+	Fields []string         `json:"fields,omitempty"` // What changed for modified files: size, mtime, checksum
+	Old    *models.FileInfo `json:"old,omitempty"`
+	New    *models.FileInfo `json:"new,omitempty"`
+}
+
+// DiffIndexes compares two indexes of either backend. A removed and an added
+// file with the same size and checksum are reported as a move, preferring
+// pairs with the same filename. Checksums are only compared if both indexes
+// use the same algorithm. Changes are returned in path order.
+func DiffIndexes(ctx context.Context, oldIndex, newIndex *Indexer) ([]FileChange, error) {
+	oldList, err := oldIndex.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return nil, err
+	}
+	oldFiles := make(map[string]models.FileInfo, len(oldList))
+	for _, file := range oldList {
+		oldFiles[file.Path] = file
+	}
+	newList, err := newIndex.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return nil, err
+	}
+	newFiles := make(map[string]models.FileInfo, len(newList))
+	for _, file := range newList {
+		newFiles[file.Path] = file
+	}
+
+	oldAlgorithm, err := oldIndex.HashAlgorithm(ctx)
+	if err != nil {
+		return nil, err
+	}
+	newAlgorithm, err := newIndex.HashAlgorithm(ctx)
+	if err != nil {
+		return nil, err
+	}
+	compareChecksums := oldAlgorithm == newAlgorithm
+
+	var changes, added []FileChange
+	removed := make(map[moveKey][]models.FileInfo)
+	var unmatched []models.FileInfo
+
+	for path, oldFile := range oldFiles {
+		newFile, ok := newFiles[path]
+		if !ok {
+			if key, ok := contentKey(oldFile, compareChecksums); ok {
+				removed[key] = append(removed[key], oldFile)
+			} else {
+				unmatched = append(unmatched, oldFile)
+			}
+			continue
+		}
+		if fields := changedFields(oldFile, newFile, compareChecksums); len(fields) > 0 {
+			changes = append(changes, FileChange{Kind: ChangeModified, Path: path, Fields: fields, Old: &oldFile, New: &newFile})
+		}
+	}
+
+	for path, newFile := range newFiles {
+		if _, ok := oldFiles[path]; !ok {
+			added = append(added, FileChange{Kind: ChangeAdded, Path: path, New: &newFile})
+		}
+	}
+
+	// Pair added files with removed files of the same content. Added files are
+	// visited in path order so the pairing does not depend on map order.
+	sort.Slice(added, func(a, b int) bool { return added[a].Path < added[b].Path })
+	for _, change := range added {
+		key, ok := contentKey(*change.New, compareChecksums)
+		candidates := removed[key]
+		if !ok || len(candidates) == 0 {
+			changes = append(changes, change)
+			continue
+		}
+
+		match := 0
+		for n, candidate := range candidates {
+			if candidate.Filename == change.New.Filename {
+				match = n
+				break
+			}
+		}
+		oldFile := candidates[match]
+		removed[key] = append(candidates[:match], candidates[match+1:]...)
+		changes = append(changes, FileChange{Kind: ChangeMoved, Path: change.Path, Old: &oldFile, New: change.New})
+	}
+
+	for _, files := range removed {
+		unmatched = append(unmatched, files...)
+	}
+	for _, file := range unmatched {
+		changes = append(changes, FileChange{Kind: ChangeRemoved, Path: file.Path, Old: &file})
+	}
+
+	sort.Slice(changes, func(a, b int) bool { return changes[a].Path < changes[b].Path })
+	return changes, nil
+}
+
+// moveKey identifies file contents for move detection
+type moveKey struct {
+	checksum string
+	size     int64
+}
+
+// contentKey returns the key used to match moved files, if the file's
+// checksum can be compared
+func contentKey(file models.FileInfo, compareChecksums bool) (moveKey, bool) {
+	if !compareChecksums || file.Checksum == "" {
+		return moveKey{}, false
+	}
+	return moveKey{checksum: file.Checksum, size: file.FileSize}, true
+}
+
+// changedFields lists the properties that differ between two records of the
+// same path
+func changedFields(oldFile, newFile models.FileInfo, compareChecksums bool) []string {
+	var fields []string
+	if oldFile.FileSize != newFile.FileSize {
+		fields = append(fields, "size")
+	}
+	// DuckDB stores timestamps with microsecond precision, so JSON and
+	// database records of the same file only agree down to microseconds
+	if !oldFile.ModificationDateTime.Truncate(time.Microsecond).Equal(newFile.ModificationDateTime.Truncate(time.Microsecond)) {
+		fields = append(fields, "mtime")
+	}
+	if compareChecksums && oldFile.Checksum != "" && newFile.Checksum != "" && oldFile.Checksum != newFile.Checksum {
+		fields = append(fields, "checksum")
+	}
+	return fields
+}
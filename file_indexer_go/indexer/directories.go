@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// maxPairedCopies bounds the copies of a file that are paired up when
+// looking for duplicate directories. Files repeated more often under one
+// name, such as a common LICENSE, would pair thousands of unrelated
+// directories.
+const maxPairedCopies = 100
+
+// DuplicateDirectories returns the pairs of directories below root whose
+// trees hold the same files, at the same relative paths with the same
+// checksums. Similarity is the percentage of the files of both trees that are
+// shared. Pairs at least minSimilarity percent similar are returned, most
+// shared space first; pairs of subdirectories of a returned pair are left
+// out, so each tree is reported once. Only local files are compared, and
+// hardlinks and empty files are not counted as shared, as removing them
+// reclaims no space. An empty root covers the whole index.
+func (i *Indexer) DuplicateDirectories(ctx context.Context, root string, minSimilarity float64) ([]models.DuplicateDirectory, error) {
+	if root == "" {
+		root = string(filepath.Separator)
+	}
+	root = filepath.Clean(absolutePath(root))
+	groups, err := i.FindDuplicates(ctx, OriginalPolicy{})
+	if err != nil {
+		return nil, err
+	}
+	usage, err := i.DirectoryUsage(ctx, root, -1)
+	if err != nil {
+		return nil, err
+	}
+	fileCounts := make(map[string]int64, len(usage))
+	for _, dir := range usage {
+		fileCounts[dir.Path] = dir.FileCount
+	}
+
+	pairs := make(map[[2]string]*models.DuplicateDirectory)
+	for _, group := range groups {
+		if group.FileSize == 0 {
+			continue
+		}
+		byName := make(map[string][]models.FileInfo)
+		for _, file := range group.Files {
+			if i.isLocal(file) && below(root, file.Path) {
+				byName[file.Filename] = append(byName[file.Filename], file)
+			}
+		}
+		for _, copies := range byName {
+			if len(copies) > maxPairedCopies {
+				continue
+			}
+			for a := range copies {
+				for b := a + 1; b < len(copies); b++ {
+					if !copies[a].IsHardlinkOf(copies[b]) {
+						shareAncestors(pairs, root, copies[a].Path, copies[b].Path, group.FileSize)
+					}
+				}
+			}
+		}
+	}
+
+	var result []models.DuplicateDirectory
+	for key, pair := range pairs {
+		pair.Files, pair.OtherFiles = fileCounts[pair.Path], fileCounts[pair.Other]
+		pair.Similarity = models.DirectorySimilarity(pair.SharedFiles, pair.Files, pair.OtherFiles)
+		if pair.Similarity < minSimilarity {
+			delete(pairs, key)
+		}
+	}
+	for _, pair := range pairs {
+		if _, ok := pairs[dirPair(filepath.Dir(pair.Path), filepath.Dir(pair.Other))]; !ok {
+			result = append(result, *pair)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].SharedSize != result[b].SharedSize {
+			return result[a].SharedSize > result[b].SharedSize
+		}
+		return result[a].Path < result[b].Path
+	})
+	return result, nil
+}
+
+// shareAncestors counts two copies of a file as shared by each pair of their
+// ancestor directories below root under which they have the same relative
+// path. Directories containing each other are not paired.
+func shareAncestors(pairs map[[2]string]*models.DuplicateDirectory, root, pathA, pathB string, size int64) {
+	dirA, dirB := filepath.Dir(pathA), filepath.Dir(pathB)
+	for below(root, dirA) && below(root, dirB) && !below(dirA, dirB) && !below(dirB, dirA) {
+		key := dirPair(dirA, dirB)
+		pair, ok := pairs[key]
+		if !ok {
+			pair = &models.DuplicateDirectory{Path: key[0], Other: key[1]}
+			pairs[key] = pair
+		}
+		pair.SharedFiles++
+		pair.SharedSize += size
+
+		if filepath.Base(dirA) != filepath.Base(dirB) {
+			return
+		}
+		dirA, dirB = filepath.Dir(dirA), filepath.Dir(dirB)
+	}
+}
+
+// dirPair returns two directories in path order, as a key of a pair
+func dirPair(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// below reports whether path lies strictly below dir
+func below(dir, path string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,316 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/filter"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/fsmeta"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// walkFilter decides which entries of a directory walk are indexed
+type walkFilter struct {
+	fsys     source.FS
+	rootPath string
+	excludes filter.Set
+	includes filter.Set
+	exts     filter.Extensions // extensions of the indexed files, empty for all
+	skipExts filter.Extensions
+	ignores  *filter.IgnoreStack // nil unless ignore files are honored
+	symlinks SymlinkMode
+
+	// visited holds the directories already walked when following symlinks
+	visited map[string]bool
+
+	// unreadable collects absolute paths the walk failed to access
+	unreadable []string
+
+	// maxDepth is the deepest level of files below the root that are walked
+	// (0 = no limit), and device, unless nil, the device the walk stays on.
+	// kept collects the absolute paths of the directories left out because of
+	// them, whose indexed files are kept.
+	maxDepth int
+	device   *uint64
+	kept     []string
+
+	// previous holds the stored records of the walked files by absolute
+	// path unless every file is re-hashed
+	previous  map[string]models.FileInfo
+	tolerance time.Duration
+
+	logger *slog.Logger
+}
+
+// newWalkFilter compiles the filtering options for a walk of rootPath
+func newWalkFilter(fsys source.FS, rootPath string, opts ScanOptions, logger *slog.Logger) (*walkFilter, error) {
+	excludes, err := filter.CompileSet(opts.Excludes)
+	if err != nil {
+		return nil, err
+	}
+	includes, err := filter.CompileSet(opts.Includes)
+	if err != nil {
+		return nil, err
+	}
+	f := &walkFilter{
+		fsys:     fsys,
+		rootPath: rootPath,
+		excludes: excludes,
+		includes: includes,
+		exts:     filter.ParseExtensions(opts.Extensions),
+		skipExts: filter.ParseExtensions(opts.ExcludeExtensions),
+		symlinks: opts.Symlinks,
+		maxDepth: opts.MaxDepth,
+		logger:   logger,
+	}
+	if f.symlinks == SymlinkFollow {
+		f.visited = make(map[string]bool)
+	}
+	if opts.IgnoreFiles {
+		f.ignores = filter.NewIgnoreStack()
+	}
+	if opts.OneFileSystem {
+		// Roots that cannot be read are reported by the walk itself
+		if info, err := fsys.Stat(rootPath); err == nil {
+			if id, ok := fsmeta.FileID(info); ok {
+				f.device = &id.Device
+			}
+		}
+	}
+	return f, nil
+}
+
+// enterDir loads the ignore files of a directory that is about to be walked
+func (f *walkFilter) enterDir(path string) {
+	if f.ignores == nil {
+		return
+	}
+
+	rel, _ := f.paths(path)
+	for _, name := range filter.IgnoreFileNames {
+		ignoreFile, err := f.loadIgnoreFile(filepath.Join(path, name), rel)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				f.logger.Warn("Error reading ignore file", "path", filepath.Join(path, name), "err", err)
+			}
+			continue
+		}
+		f.ignores.Add(rel, ignoreFile)
+	}
+}
+
+// loadIgnoreFile reads an ignore file of the directory rel
+func (f *walkFilter) loadIgnoreFile(path, rel string) (*filter.IgnoreFile, error) {
+	file, err := f.fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return filter.ParseIgnore(file, rel)
+}
+
+// firstVisit reports whether a directory is walked for the first time. It
+// always does when symlinks are not followed; otherwise directories are
+// identified by device and inode, so symlink cycles end after one pass.
+func (f *walkFilter) firstVisit(path string) bool {
+	if f.visited == nil {
+		return true
+	}
+
+	info, err := f.fsys.Stat(path)
+	if err != nil {
+		return true
+	}
+	var key string
+	if id, ok := fsmeta.FileID(info); ok {
+		key = fmt.Sprintf("%d:%d", id.Device, id.Inode)
+	} else if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		key = resolved
+	} else {
+		return true
+	}
+
+	if f.visited[key] {
+		return false
+	}
+	f.visited[key] = true
+	return true
+}
+
+// followSymlink resolves a symlink when symlinks are followed, returning an
+// entry describing its target. ok is false for any other entry.
+func (f *walkFilter) followSymlink(path string, d fs.DirEntry) (fs.DirEntry, bool) {
+	if f.symlinks != SymlinkFollow || d.Type()&fs.ModeSymlink == 0 {
+		return d, false
+	}
+	info, err := f.fsys.Stat(path)
+	if err != nil {
+		f.logger.Warn("Error following symlink", "path", path, "err", err)
+		return d, false
+	}
+	return fs.FileInfoToDirEntry(info), true
+}
+
+// unchanged returns the stored record of a file whose size is unchanged and
+// whose modification time moved by at most the tolerance, or nil. Times are
+// compared at microsecond precision, the precision of database timestamps.
+func (f *walkFilter) unchanged(path string, info fs.FileInfo) *models.FileInfo {
+	if f.previous == nil {
+		return nil
+	}
+	stored, ok := f.previous[absolutePath(path)]
+	if !ok || stored.FileSize != info.Size() {
+		return nil
+	}
+	modified := info.ModTime().Truncate(time.Microsecond)
+	if drift := modified.Sub(stored.ModificationDateTime.Truncate(time.Microsecond)).Abs(); drift > f.tolerance {
+		return nil
+	}
+	return &stored
+}
+
+// paths returns the slash-separated path relative to the scan root and the
+// absolute path of a walked entry
+func (f *walkFilter) paths(path string) (string, string) {
+	rel, err := filepath.Rel(f.rootPath, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.ToSlash(rel), absolutePath(path)
+}
+
+// shouldSkipDir reports whether a directory should be pruned from the walk
+func (f *walkFilter) shouldSkipDir(path string, d fs.DirEntry) bool {
+	// Never prune the root itself, even if it is "." or a hidden directory
+	if path == f.rootPath {
+		return false
+	}
+
+	// Skip hidden directories
+	if strings.HasPrefix(d.Name(), ".") || fsmeta.IsHidden(d) {
+		return true
+	}
+
+	rel, abs := f.paths(path)
+	if f.excludes.Match(rel, abs, true) {
+		return true
+	}
+	return f.ignores != nil && f.ignores.Ignored(rel, true)
+}
+
+// outOfReach reports whether a directory is left out of the walk because it
+// is below the depth limit or on another filesystem than the root. Unlike
+// excluded directories, the files indexed below it are kept.
+func (f *walkFilter) outOfReach(path string, d fs.DirEntry) bool {
+	return f.beyondDepth(path) || f.otherDevice(d)
+}
+
+// otherDevice reports whether a directory is a mount point of another
+// filesystem than that of the root, when the walk stays on one filesystem
+func (f *walkFilter) otherDevice(d fs.DirEntry) bool {
+	if f.device == nil {
+		return false
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	id, ok := fsmeta.FileID(info)
+	return ok && id.Device != *f.device
+}
+
+// beyondDepth reports whether the files of a directory are deeper below the
+// root than the depth limit allows
+func (f *walkFilter) beyondDepth(path string) bool {
+	if f.maxDepth <= 0 {
+		return false
+	}
+	rel, _ := f.paths(path)
+	if rel == "." {
+		return false
+	}
+	return strings.Count(rel, "/")+1 >= f.maxDepth
+}
+
+// shouldSkipFile reports whether a regular walk entry should not be indexed
+func (f *walkFilter) shouldSkipFile(path string, d fs.DirEntry) (bool, error) {
+	// Skip hidden files
+	if strings.HasPrefix(d.Name(), ".") || fsmeta.IsHidden(d) {
+		return true, nil
+	}
+
+	// Extensions are checked first, as they are cheaper than glob patterns
+	if f.skipExts.Match(d.Name()) || (len(f.exts) > 0 && !f.exts.Match(d.Name())) {
+		return true, nil
+	}
+
+	rel, abs := f.paths(path)
+	if f.excludes.Match(rel, abs, false) {
+		return true, nil
+	}
+	if f.ignores != nil && f.ignores.Ignored(rel, false) {
+		return true, nil
+	}
+
+	// With include patterns, only matching files are indexed. Directories
+	// are not subject to includes, so matching files at any depth are found.
+	if len(f.includes) > 0 && !f.includes.Match(rel, abs, false) {
+		return true, nil
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		f.logger.Warn("Error getting file info", "path", path, "err", err)
+		return true, err
+	}
+
+	// Symlinks are only indexed as entries of their own when they are recorded
+	if info.Mode()&fs.ModeSymlink != 0 && f.symlinks == SymlinkRecord {
+		return false, nil
+	}
+
+	// Skip special files (symlinks, etc.)
+	if !info.Mode().IsRegular() {
+		f.logger.Debug("Skipping special file", "path", path)
+		return true, nil
+	}
+	return false, nil
+}
+
+// accept applies the file filters and the size limits to a walked file and
+// returns its info if the file should be indexed
+func (f *walkFilter) accept(path string, d fs.DirEntry, minFileSize, maxFileSize int64) (fs.FileInfo, bool) {
+	// Check if the file should be skipped
+	skip, err := f.shouldSkipFile(path, d)
+	if err != nil {
+		f.logger.Warn("Error during file filtering", "path", path, "err", err)
+		return nil, false
+	}
+	if skip {
+		f.logger.Debug("Skipping file", "path", path)
+		return nil, false
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		f.logger.Warn("Error getting file info", "path", path, "err", err)
+		return nil, false
+	}
+
+	// Skip files larger than maxFileSize
+	if maxFileSize > 0 && info.Size() > maxFileSize {
+		f.logger.Debug("Skipping large file", "path", path, "size", info.Size())
+		return nil, false
+	}
+	if info.Size() < minFileSize {
+		f.logger.Debug("Skipping small file", "path", path, "size", info.Size())
+		return nil, false
+	}
+	return info, true
+}
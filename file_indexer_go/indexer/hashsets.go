@@ -0,0 +1,151 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// knownHashBatch is the number of checksums of a hash set stored at a time
+const knownHashBatch = 100000
+
+// errNoHashSets is returned by the hash set operations of JSON indexes
+var errNoHashSets = errors.New("known hash sets are only available in database mode")
+
+// ImportHashSet reads a known hash set in one of the formats of
+// hasher.ReadHashSet from r and stores it under set.Name, replacing the set
+// of that name. Checksums of lists that do not name their algorithm are of
+// algorithm, or else of the one their length suggests. A set that fails to
+// import is removed. It returns the set with its number of checksums.
+func (i *Indexer) ImportHashSet(ctx context.Context, set models.HashSet, r io.Reader, algorithm string) (models.HashSet, error) {
+	if !i.useDB {
+		return set, errNoHashSets
+	}
+	if i.readOnly {
+		return set, ErrReadOnly
+	}
+	if set.Kind != models.HashSetAllow && set.Kind != models.HashSetBlock {
+		return set, fmt.Errorf("unknown hash set kind %q (supported: allow, block)", set.Kind)
+	}
+	if algorithm != "" {
+		h, err := hasher.Get(algorithm)
+		if err != nil {
+			return set, err
+		}
+		algorithm = h.Name()
+	}
+	set.ImportedAt = time.Now()
+	if err := i.db.CreateHashSet(ctx, set); err != nil {
+		return set, err
+	}
+
+	batch := make([]models.KnownHash, 0, knownHashBatch)
+	err := hasher.ReadHashSet(r, algorithm, func(algorithm, checksum string) error {
+		batch = append(batch, models.KnownHash{Algorithm: algorithm, Checksum: checksum})
+		if len(batch) < knownHashBatch {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := i.db.AddKnownHashes(ctx, set.Name, batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil {
+		err = i.db.AddKnownHashes(ctx, set.Name, batch)
+	}
+	if err != nil {
+		if _, removeErr := i.db.RemoveHashSet(context.WithoutCancel(ctx), set.Name); removeErr != nil {
+			i.logger.Warn("Error removing partly imported hash set", "name", set.Name, "err", removeErr)
+		}
+		return set, err
+	}
+
+	sets, err := i.db.ListHashSets(ctx)
+	if err != nil {
+		return set, err
+	}
+	for _, stored := range sets {
+		if stored.Name == set.Name {
+			set = stored
+		}
+	}
+	i.logger.Info("Imported hash set", "name", set.Name, "kind", set.Kind, "hashes", set.Hashes)
+	return set, nil
+}
+
+// HashSets returns the known hash sets of the index, ordered by name
+func (i *Indexer) HashSets(ctx context.Context) ([]models.HashSet, error) {
+	if !i.useDB {
+		return nil, errNoHashSets
+	}
+	return i.db.ListHashSets(ctx)
+}
+
+// RemoveHashSet removes a known hash set and reports whether it existed
+func (i *Indexer) RemoveHashSet(ctx context.Context, name string) (bool, error) {
+	if !i.useDB {
+		return false, errNoHashSets
+	}
+	if i.readOnly {
+		return false, ErrReadOnly
+	}
+	return i.db.RemoveHashSet(ctx, name)
+}
+
+// KnownHashMatches returns the indexed files below root whose checksum is in
+// a known hash set of a kind (empty = any), ordered by path. Checksums of the
+// index's algorithm and those stored with ScanOptions.ExtraHashes are
+// matched, so a set of another algorithm needs a scan with that algorithm.
+// An empty root covers the whole index.
+func (i *Indexer) KnownHashMatches(ctx context.Context, kind models.HashSetKind, root string) ([]models.KnownHashMatch, error) {
+	if !i.useDB {
+		return nil, errNoHashSets
+	}
+	algorithm, err := i.HashAlgorithm(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := i.db.KnownHashMatches(ctx, algorithm, kind)
+	if err != nil || root == "" {
+		return matches, err
+	}
+
+	root = filepath.Clean(absolutePath(root))
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	var below []models.KnownHashMatch
+	for _, match := range matches {
+		if match.File.Path == root || strings.HasPrefix(match.File.Path, prefix) {
+			below = append(below, match)
+		}
+	}
+	return below, nil
+}
+
+// WithoutKnownGood leaves out the duplicate groups whose content is in an
+// allow hash set, such as files of the operating system listed by the NSRL
+func (i *Indexer) WithoutKnownGood(ctx context.Context, groups []models.DuplicateGroup) ([]models.DuplicateGroup, error) {
+	matches, err := i.KnownHashMatches(ctx, models.HashSetAllow, "")
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(matches))
+	for _, match := range matches {
+		known[match.File.Checksum] = true
+	}
+	var kept []models.DuplicateGroup
+	for _, group := range groups {
+		if !known[group.Checksum] {
+			kept = append(kept, group)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,264 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// HookEventKind is a kind of change that fires hooks
+type HookEventKind string
+
+const (
+	HookFilesAdded   HookEventKind = "added"      // Files appeared in the index
+	HookFilesRemoved HookEventKind = "removed"    // Files vanished from the index
+	HookDuplicates   HookEventKind = "duplicates" // Duplicates added at least HookConfig.MinWasted of wasted space
+)
+
+// HookEventKinds lists the kinds of events in the order they are fired
+var HookEventKinds = []HookEventKind{HookFilesAdded, HookFilesRemoved, HookDuplicates}
+
+// maxHookPaths bounds the paths and groups listed in one event; Count
+// always has the full number
+const maxHookPaths = 100
+
+// hookTimeout bounds how long a webhook or command may take
+const hookTimeout = 30 * time.Second
+
+// HookConfig chooses the changes that fire hooks and where they are sent
+type HookConfig struct {
+	URL       string          // Receives each event as a JSON POST request
+	Command   string          // Run through the shell with the event as JSON on stdin
+	Events    []HookEventKind // Kinds of changes that fire the hooks (empty = all)
+	MinWasted int64           // Wasted space new duplicates must add up to before they fire an event
+	Index     string          // Name of the index in the events, e.g. its path
+}
+
+// HookEvent is the payload sent to hooks. Text summarizes the event, so the
+// payload can be posted to Slack-compatible incoming webhooks unchanged.
+type HookEvent struct {
+	Event       HookEventKind `json:"event"`
+	Text        string        `json:"text"`
+	Host        string        `json:"host,omitempty"`
+	Index       string        `json:"index"`
+	Time        time.Time     `json:"time"`
+	Count       int           `json:"count"`                  // files added or removed, or duplicate groups that grew
+	Paths       []string      `json:"paths,omitempty"`        // the first files added or removed
+	WastedSpace int64         `json:"wasted_space,omitempty"` // wasted space the duplicates added
+	Groups      []HookGroup   `json:"groups,omitempty"`       // the groups that grew most
+}
+
+// HookGroup is a duplicate group that is new or has more copies than before
+type HookGroup struct {
+	Checksum    string   `json:"checksum"`
+	FileSize    int64    `json:"file_size"`
+	Copies      int      `json:"copies"`
+	AddedWaste  int64    `json:"added_wasted_space"`
+	WastedSpace int64    `json:"wasted_space"`
+	Paths       []string `json:"paths"`
+}
+
+// ChangeHooks fires hooks for the changes between successive states of an
+// index, such as the scans of a daemon or the batches of a watch. It keeps
+// the paths of the last state and, if duplicates fire events, the wasted
+// space of every duplicate group.
+type ChangeHooks struct {
+	config HookConfig
+	client *http.Client
+	primed bool
+	paths  map[string]bool
+	wasted map[string]int64 // by checksum
+}
+
+// NewChangeHooks returns hooks for the configured events
+func NewChangeHooks(config HookConfig) *ChangeHooks {
+	if len(config.Events) == 0 {
+		config.Events = HookEventKinds
+	}
+	return &ChangeHooks{config: config, client: &http.Client{Timeout: hookTimeout}}
+}
+
+// ParseHookEvents parses the names of kinds of hook events
+func ParseHookEvents(names []string) ([]HookEventKind, error) {
+	var kinds []HookEventKind
+	for _, name := range names {
+		kind := HookEventKind(name)
+		if !slices.Contains(HookEventKinds, kind) {
+			return nil, fmt.Errorf("unknown hook event %q (supported: added, removed, duplicates)", name)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// Primed reports whether Check has recorded a state to compare with
+func (h *ChangeHooks) Primed() bool {
+	return h.primed
+}
+
+// Check compares the index with the state of the previous call and fires the
+// hooks for the changes. The first call only records the state. Hooks that
+// fail are logged; errors reading the index are returned.
+func (h *ChangeHooks) Check(ctx context.Context, i *Indexer) error {
+	files, err := i.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]bool, len(files))
+	for _, file := range files {
+		paths[file.DisplayPath(i.host)] = true
+	}
+
+	var wasted map[string]int64
+	var grown []HookGroup
+	var addedWaste int64
+	if h.wants(HookDuplicates) {
+		groups, err := i.FindDuplicates(ctx, OriginalPolicy{})
+		if err != nil {
+			return err
+		}
+		wasted = make(map[string]int64, len(groups))
+		for _, group := range groups {
+			wasted[group.Checksum] = group.WastedSpace
+			if growth := group.WastedSpace - h.wasted[group.Checksum]; growth > 0 {
+				grown = append(grown, hookGroup(group, growth, i.host))
+				addedWaste += growth
+			}
+		}
+	}
+
+	previous, primed := h.paths, h.primed
+	h.paths, h.wasted, h.primed = paths, wasted, true
+	if !primed {
+		return nil
+	}
+
+	var added, removed []string
+	for path := range paths {
+		if !previous[path] {
+			added = append(added, path)
+		}
+	}
+	for path := range previous {
+		if !paths[path] {
+			removed = append(removed, path)
+		}
+	}
+
+	event := HookEvent{Host: i.host, Index: h.config.Index, Time: time.Now()}
+	if len(added) > 0 && h.wants(HookFilesAdded) {
+		event := event
+		event.Event, event.Count, event.Paths = HookFilesAdded, len(added), firstPaths(added)
+		event.Text = fmt.Sprintf("%d new files in %s", len(added), event.Index)
+		h.fire(ctx, i, event)
+	}
+	if len(removed) > 0 && h.wants(HookFilesRemoved) {
+		event := event
+		event.Event, event.Count, event.Paths = HookFilesRemoved, len(removed), firstPaths(removed)
+		event.Text = fmt.Sprintf("%d files vanished from %s", len(removed), event.Index)
+		h.fire(ctx, i, event)
+	}
+	if len(grown) > 0 && addedWaste >= h.config.MinWasted {
+		sort.Slice(grown, func(a, b int) bool { return grown[a].AddedWaste > grown[b].AddedWaste })
+		event := event
+		event.Event, event.Count, event.WastedSpace = HookDuplicates, len(grown), addedWaste
+		event.Groups = grown[:min(len(grown), maxHookPaths)]
+		event.Text = fmt.Sprintf("New duplicates in %s waste %s in %d groups", event.Index, models.FormatSize(addedWaste), len(grown))
+		h.fire(ctx, i, event)
+	}
+	return nil
+}
+
+// wants reports whether events of a kind fire the hooks
+func (h *ChangeHooks) wants(kind HookEventKind) bool {
+	return slices.Contains(h.config.Events, kind)
+}
+
+// fire sends an event to the webhook and runs the command
+func (h *ChangeHooks) fire(ctx context.Context, i *Indexer, event HookEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		i.logger.Error("Error encoding hook event", "event", event.Event, "err", err)
+		return
+	}
+	i.logger.Info("Firing hooks", "event", event.Event, "count", event.Count)
+
+	if h.config.URL != "" {
+		if err := h.post(ctx, payload); err != nil {
+			i.logger.Error("Webhook failed", "event", event.Event, "err", err)
+		}
+	}
+	if h.config.Command != "" {
+		if err := h.run(ctx, event.Event, payload); err != nil {
+			i.logger.Error("Hook command failed", "event", event.Event, "err", err)
+		}
+	}
+}
+
+// post sends an event to the webhook
+func (h *ChangeHooks) post(ctx context.Context, payload []byte) error {
+	request, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodPost, h.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := h.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", h.config.URL, response.Status)
+	}
+	return nil
+}
+
+// run runs the hook command with the event on stdin and its kind in
+// $FILE_INDEXER_EVENT
+func (h *ChangeHooks) run(ctx context.Context, kind HookEventKind, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), hookTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.config.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.config.Command)
+	}
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(), "FILE_INDEXER_EVENT="+string(kind))
+	return cmd.Run()
+}
+
+// hookGroup describes a duplicate group that added wasted space, with the
+// paths of its files as seen on host
+func hookGroup(group models.DuplicateGroup, growth int64, host string) HookGroup {
+	paths := make([]string, 0, len(group.Files))
+	for _, file := range group.Files {
+		paths = append(paths, file.DisplayPath(host))
+	}
+	return HookGroup{
+		Checksum:    group.Checksum,
+		FileSize:    group.FileSize,
+		Copies:      group.Copies,
+		AddedWaste:  growth,
+		WastedSpace: group.WastedSpace,
+		Paths:       firstPaths(paths),
+	}
+}
+
+// firstPaths returns up to maxHookPaths of paths, in path order
+func firstPaths(paths []string) []string {
+	sort.Strings(paths)
+	return paths[:min(len(paths), maxHookPaths)]
+}
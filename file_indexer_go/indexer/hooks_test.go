@@ -0,0 +1,132 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// hookRecorder returns the URL of a webhook that records the events it
+// receives
+func hookRecorder(t *testing.T) (string, *[]HookEvent) {
+	t.Helper()
+	var events []HookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event HookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding hook event: %v", err)
+		}
+		events = append(events, event)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &events
+}
+
+func TestChangeHooksCheck(t *testing.T) {
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		fsys := fstest.MapFS{
+			"kept.txt":    {Data: []byte("kept"), ModTime: testModTime},
+			"removed.txt": {Data: []byte("removed"), ModTime: testModTime},
+		}
+		idx := newTestIndexer(t, useDB, source.FromFS(fsys, testRoot))
+		url, events := hookRecorder(t)
+		hooks := NewChangeHooks(HookConfig{URL: url, Index: "test index"})
+		scan := func() {
+			t.Helper()
+			if err := idx.IndexDirectory(ctx, testRoot, ScanOptions{}); err != nil {
+				t.Fatalf("IndexDirectory: %v", err)
+			}
+			if err := hooks.Check(ctx, idx); err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+		}
+
+		scan()
+		if !hooks.Primed() || len(*events) != 0 {
+			t.Fatalf("the first check fired %+v, want it to only record the state", *events)
+		}
+		scan()
+		if len(*events) != 0 {
+			t.Fatalf("an unchanged index fired %+v", *events)
+		}
+
+		delete(fsys, "removed.txt")
+		fsys["added.txt"] = &fstest.MapFile{Data: []byte("added"), ModTime: testModTime}
+		fsys["copy.txt"] = &fstest.MapFile{Data: []byte("kept"), ModTime: testModTime}
+		scan()
+		var kinds []HookEventKind
+		for _, event := range *events {
+			kinds = append(kinds, event.Event)
+		}
+		if !slices.Equal(kinds, HookEventKinds) {
+			t.Fatalf("fired %v, want %v", kinds, HookEventKinds)
+		}
+		added, removed, duplicates := (*events)[0], (*events)[1], (*events)[2]
+		if added.Count != 2 || !slices.Equal(added.Paths, []string{"/mem/added.txt", "/mem/copy.txt"}) {
+			t.Errorf("added event %+v", added)
+		}
+		if removed.Count != 1 || !slices.Equal(removed.Paths, []string{"/mem/removed.txt"}) {
+			t.Errorf("removed event %+v", removed)
+		}
+		if duplicates.Count != 1 || duplicates.WastedSpace != 4 || len(duplicates.Groups) != 1 || duplicates.Groups[0].Copies != 2 {
+			t.Errorf("duplicates event %+v", duplicates)
+		}
+		if added.Index != "test index" || added.Host != testHost || added.Text == "" {
+			t.Errorf("event without its index, host or text: %+v", added)
+		}
+
+		*events = nil
+		scan()
+		if len(*events) != 0 {
+			t.Errorf("duplicates fired again without growing: %+v", *events)
+		}
+	})
+}
+
+func TestChangeHooksFilters(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("same"), ModTime: testModTime}}
+	idx := newTestIndexer(t, false, source.FromFS(fsys, testRoot))
+	url, events := hookRecorder(t)
+	hooks := NewChangeHooks(HookConfig{URL: url, Events: []HookEventKind{HookDuplicates}, MinWasted: 10})
+	check := func() {
+		t.Helper()
+		if err := idx.IndexDirectory(ctx, testRoot, ScanOptions{}); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		if err := hooks.Check(ctx, idx); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+
+	check()
+	fsys["b.txt"] = &fstest.MapFile{Data: []byte("same"), ModTime: testModTime}
+	check()
+	if len(*events) != 0 {
+		t.Fatalf("fired %+v for less wasted space than the minimum, or for added files", *events)
+	}
+	fsys["c.txt"] = &fstest.MapFile{Data: []byte("same"), ModTime: testModTime}
+	fsys["d.txt"] = &fstest.MapFile{Data: []byte("same"), ModTime: testModTime}
+	fsys["e.txt"] = &fstest.MapFile{Data: []byte("same"), ModTime: testModTime}
+	check()
+	if len(*events) != 1 || (*events)[0].Event != HookDuplicates || (*events)[0].WastedSpace != 12 {
+		t.Errorf("fired %+v, want one duplicates event for the 12 bytes added", *events)
+	}
+}
+
+func TestParseHookEvents(t *testing.T) {
+	kinds, err := ParseHookEvents([]string{"removed", "added"})
+	if err != nil || !slices.Equal(kinds, []HookEventKind{HookFilesRemoved, HookFilesAdded}) {
+		t.Errorf("ParseHookEvents = %v, %v", kinds, err)
+	}
+	if _, err := ParseHookEvents([]string{"changed"}); err == nil {
+		t.Errorf("ParseHookEvents accepted an unknown event")
+	}
+}
@@ -1,31 +1,71 @@
+// Package indexer scans directories into a file index stored as JSON or in
+// DuckDB and answers queries about it: search, duplicates, directory usage,
+// verification and deduplication.
+//
+// All operations take a context.Context and return errors. Problems with
+// single files during a scan, such as unreadable files, do not fail the scan;
+// they are logged to the logger set with SetLogger.
+//
+//	idx := indexer.NewIndexer("files.db", true)
+//	if err := idx.InitDatabase(ctx); err != nil {
+//		return err
+//	}
+//	defer idx.CloseDatabase()
+//	if err := idx.IndexDirectory(ctx, "/data", indexer.ScanOptions{Workers: 4}); err != nil {
+//		return err
+//	}
+//	files, err := idx.Search(ctx, "report", models.FileQuery{Limit: 10})
 package indexer
 
 import (
-	"crypto/md5"
+	"context"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
-	"file_indexer_go/db"
-	"file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/secrets"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
 )
 
-// Indexer handles file indexing operations
+// Indexer handles file indexing operations on a JSON or DuckDB index. Methods
+// that only read the index may be called concurrently; methods that modify it
+// may not run concurrently with any other method.
 type Indexer struct {
 	index     *models.Index
 	indexPath string
-	db        *db.Database
+	db        db.Store
 	useDB     bool
+	hasher    hasher.Hasher
+	extra     []hasher.Hasher // other algorithms whose checksums a scan also stores
+	logger    *slog.Logger
+	fsys      source.FS
+	s3        *source.S3 // nil unless s3:// roots can be indexed
+	streamed  bool       // queries read the files of the JSON index from its file
+	host      string     // machine whose files are written to a shared PostgreSQL index
+	readOnly  bool
+	dbTuning  db.Tuning
+	protected OriginalPolicy // protect rules checked before dedupe changes a file
+	lock      *os.File       // held while a JSON index is open for writing
 }
 
-// NewIndexer creates a new file indexer
+// ErrReadOnly is returned by operations that would change an index opened
+// read-only
+var ErrReadOnly = errors.New("index is opened read-only")
+
+// NewIndexer creates a new file indexer for the index at indexPath, stored
+// in DuckDB if useDB is set and as JSON otherwise. It logs to slog's default
+// logger at the time of the call; use SetLogger to change that.
 func NewIndexer(indexPath string, useDB bool) *Indexer {
 	return &Indexer{
 		index: &models.Index{
@@ -33,276 +73,854 @@ func NewIndexer(indexPath string, useDB bool) *Indexer {
 		},
 		indexPath: indexPath,
 		useDB:     useDB,
-		db:        db.NewDatabase(),
+		hasher:    algorithmOrDefault(hasher.Default),
+		logger:    slog.Default(),
+		fsys:      source.OS,
 	}
 }
 
-// InitDatabase initializes the database if using DB mode
-func (i *Indexer) InitDatabase() error {
+// SetLogger sets the logger for progress and per-file problems, such as files
+// that cannot be read during a scan. Such problems do not fail an operation.
+func (i *Indexer) SetLogger(logger *slog.Logger) {
+	i.logger = logger
+}
+
+// SetFS sets the filesystem that directories are indexed and verified from.
+// Indexes use the local filesystem by default; paths of other filesystems
+// are the names they give their files.
+func (i *Indexer) SetFS(fsys source.FS) {
+	i.fsys = fsys
+}
+
+// SetHost sets the name of this machine, which is stored with the files it
+// indexes and scopes a PostgreSQL index shared by several machines. It
+// defaults to the hostname and must be set before InitDatabase.
+func (i *Indexer) SetHost(host string) {
+	i.host = host
+}
+
+// Host returns the name of this machine, which its files are stored with
+func (i *Indexer) Host() string {
+	return i.host
+}
+
+// fileKey returns the key of a file in JSON indexes: the path of the files of
+// this host, which scans look up by path, and the location of those of other
+// hosts, so the same path of several hosts is kept for each
+func (i *Indexer) fileKey(file models.FileInfo) string {
+	return file.DisplayPath(i.host)
+}
+
+// SetReadOnly opens the index read-only, for queries on an index that other
+// processes may be writing to or on backup copies. Operations that would
+// change the index or the indexed files then fail with ErrReadOnly, and a
+// database index must already exist. It must be set before InitDatabase.
+func (i *Indexer) SetReadOnly(readOnly bool) {
+	i.readOnly = readOnly
+}
+
+// SetDBTuning limits the threads, memory and temporary directory that DuckDB
+// uses for queries. It has no effect on JSON indexes and must be set before
+// InitDatabase.
+func (i *Indexer) SetDBTuning(tuning db.Tuning) {
+	i.dbTuning = tuning
+}
+
+// SetProtected sets the protect rules of the safelist of files that Dedupe
+// and DeleteDuplicates never delete, move or replace with links, whatever
+// policy the changes were planned with. The keep rules and the rules that
+// choose originals are ignored.
+func (i *Indexer) SetProtected(policy OriginalPolicy) {
+	i.protected = OriginalPolicy{Protect: policy.Protect, ProtectPatterns: policy.ProtectPatterns}
+}
+
+// InitDatabase initializes the database if using DB mode. The index path is
+// a DuckDB file or a postgres:// URL. JSON indexes are locked instead, so no
+// other process can open them for writing until CloseDatabase.
+func (i *Indexer) InitDatabase(ctx context.Context) error {
+	// Files are stored with the hostname, so merged indexes can tell the
+	// machines apart; only shared indexes cannot do without one
+	if i.host == "" {
+		host, err := os.Hostname()
+		if err != nil && db.IsPostgresURL(i.indexPath) {
+			return fmt.Errorf("error getting hostname: %v", err)
+		}
+		i.host = host
+	}
 	if !i.useDB {
-		return nil
+		if i.readOnly {
+			return nil
+		}
+		return i.lockIndex()
 	}
-	return i.db.Init(i.indexPath)
+	store, err := db.Open(ctx, i.indexPath, i.host, i.readOnly, i.dbTuning)
+	if err != nil {
+		return err
+	}
+	i.db = store
+	return nil
 }
 
-// CloseDatabase closes the database connection
+// CloseDatabase closes the database connection, or releases the lock of a
+// JSON index
 func (i *Indexer) CloseDatabase() error {
-	if i.useDB {
+	if i.useDB && i.db != nil {
 		return i.db.Close()
 	}
-	return nil
+	return i.unlockIndex()
+}
+
+// isLocal reports whether a file can be read and changed on this machine,
+// which is not the case for objects in buckets, archive entries and files
+// of other hosts sharing the index
+func (i *Indexer) isLocal(file models.FileInfo) bool {
+	return file.Source == "" && (file.Host == "" || file.Host == i.host)
 }
 
+// ScanOptions controls how a directory is indexed
+type ScanOptions struct {
+	MinFileSize       int64       // Minimum file size to index (0 = no limit)
+	MaxFileSize       int64       // Maximum file size to index (0 = no limit)
+	Workers           int         // Number of concurrent checksum workers
+	Excludes          []string    // Glob patterns of files and directories to skip
+	Includes          []string    // Glob patterns a file must match to be indexed (empty = all files)
+	Extensions        []string    // Extensions a file must have to be indexed, e.g. jpg (empty = all files)
+	ExcludeExtensions []string    // Extensions of files to skip, e.g. tmp
+	IgnoreFiles       bool        // Honor .gitignore and .indexignore files in traversed directories
+	Symlinks          SymlinkMode // How symbolic links are treated (empty = skip them)
+	MaxDepth          int         // Deepest level of files indexed below a root, 1 = only those directly in it (0 = no limit)
+	OneFileSystem     bool        // Do not descend into directories on other filesystems than the root, like find -xdev
+
+	Content        bool  // Store the content of text files for content search
+	ContentMaxSize int64 // Largest file whose content is stored (0 = no limit)
+	EXIF           bool  // Extract EXIF capture time, camera model and dimensions of photos
+	Media          bool  // Extract container, duration, resolution and codecs of audio and video
+	ImageHash      bool  // Compute perceptual hashes of JPEG, PNG and GIF images
+	TextHash       bool  // Compute simhashes of text files for near-duplicate detection
+	TextHashLimit  int64 // Largest text file whose simhash is computed (0 = no limit)
+	CodeMetrics    bool  // Record the language and line counts of source files
+	XAttrs         bool  // Record extended attributes of local files (Linux and macOS)
+	Streams        bool  // Also index the NTFS alternate data streams of local files (Windows)
+	ScanArchives   bool  // Also index the files inside zip, tar and tar.gz archives
+
+	// Secrets scans the stored content of text files for secrets and
+	// personal data, such as keys and credit card numbers, and records what
+	// it finds (nil = no scan). It requires Content.
+	Secrets *secrets.Scanner
+
+	HashAlgorithm string // Checksum algorithm (empty = the one already used by the index)
+	Label         string // Stored with every file of the scan, e.g. the name of a removable disk
+	MigrateHash   bool   // Allow re-hashing an index that uses a different algorithm
+	QuickHash     bool   // Store quick hashes and fully hash only quick-hash collisions
+	History       bool   // Record a snapshot of each scanned root (database mode only)
+
+	// Files larger than SampleHash (0 = none) get a sampled hash of their
+	// size and some regions instead of a full checksum. ConfirmSampled fully
+	// hashes the files whose sampled hashes collide after the scan.
+	SampleHash     int64
+	ConfirmSampled bool
+
+	ExtraHashes []string // Other algorithms whose checksums are also stored, computed in the same read
+
+	// DeferChecksums records new and changed files with their size and
+	// modification time but without reading them for a checksum, the first
+	// phase of a two-phase scan. Rehash with RehashOptions.MissingOnly is the
+	// second phase that fills the checksums in.
+	DeferChecksums bool
+
+	// NoChecksum takes an inventory without opening any file: checksums are
+	// left empty as with DeferChecksums, and MIME types are guessed from the
+	// extensions. It cannot be combined with options that read content.
+	NoChecksum bool
+
+	S3DownloadHash bool // Download S3 objects to hash them even if their ETags are MD5 checksums
+
+	// Files whose size and modification time are unchanged keep their stored
+	// checksums instead of being read again, unless Paranoid is set.
+	// MtimeTolerance is how far the modification time may move and still
+	// count as unchanged. Retries is how often a read failing with a
+	// transient I/O error is repeated. Both help with network filesystems.
+	Paranoid       bool
+	MtimeTolerance time.Duration
+	Retries        int
+
+	// ThrottleFiles and ThrottleBytes limit the files indexed and the bytes
+	// read per second (0 = no limit), and IdlePriority lowers the CPU and I/O
+	// priority of the process, so background scans leave the machine to
+	// other programs
+	ThrottleFiles float64
+	ThrottleBytes int64
+	IdlePriority  bool
+
+	KeepDeleted time.Duration // Purge entries of the deletion journal older than this after the scan (0 = keep them)
+
+	// Backup is a file a backup of the index is written to after the scan,
+	// as by Backup, and every BackupInterval during it, so a long scan
+	// survives a damaged index (database mode only)
+	Backup         string
+	BackupInterval time.Duration
+
+	Progress bool // Periodically report counts, throughput and ETA
+}
+
+// SymlinkMode selects how symbolic links found during a scan are handled
+type SymlinkMode string
+
+const (
+	SymlinkSkip   SymlinkMode = "skip"   // Ignore symbolic links
+	SymlinkFollow SymlinkMode = "follow" // Index link targets, walking linked directories once
+	SymlinkRecord SymlinkMode = "record" // Index the links themselves with their targets
+)
+
 // IndexDirectory recursively indexes all files in the given directory
-func (i *Indexer) IndexDirectory(rootPath string, maxFileSize int64) error {
-	if i.useDB {
-		return i.indexDirectoryDB(rootPath, maxFileSize)
-	}
-	return i.indexDirectoryJSON(rootPath, maxFileSize)
+func (i *Indexer) IndexDirectory(ctx context.Context, rootPath string, opts ScanOptions) error {
+	return i.IndexDirectories(ctx, []string{rootPath}, opts)
 }
 
-// indexDirectoryDB indexes files using DuckDB
-func (i *Indexer) indexDirectoryDB(rootPath string, maxFileSize int64) error {
-	// Clear existing data
-	if err := i.db.ClearData(); err != nil {
-		return err
+// IndexDirectories indexes several root directories into the same index.
+// Each root replaces only its own previously indexed files, so roots can be
+// re-indexed independently of each other. If ctx is cancelled, the scan
+// stops and ctx's error is returned; files of a cancelled scan that were
+// already stored remain in the index, but no files are pruned.
+func (i *Indexer) IndexDirectories(ctx context.Context, rootPaths []string, opts ScanOptions) error {
+	if i.readOnly {
+		return ErrReadOnly
 	}
-
-	// Set metadata
-	if err := i.db.SetMetadata("root_path", rootPath); err != nil {
-		return err
+	if opts.History && !i.useDB {
+		return fmt.Errorf("history mode requires the DuckDB backend (-db)")
+	}
+	if opts.Secrets != nil && !opts.Content {
+		return fmt.Errorf("scanning for secrets requires content mode")
+	}
+	if opts.NoChecksum {
+		if opts.Content || opts.EXIF || opts.Media || opts.ImageHash || opts.TextHash || opts.CodeMetrics {
+			return fmt.Errorf("an inventory without checksums cannot be combined with options that read the content of files")
+		}
+		opts.DeferChecksums = true
 	}
-	if err := i.db.SetMetadata("indexed", time.Now().Format(time.RFC3339)); err != nil {
+	if opts.DeferChecksums && (opts.QuickHash || opts.SampleHash > 0 || len(opts.ExtraHashes) > 0 || opts.ScanArchives || opts.Streams) {
+		return fmt.Errorf("deferred checksums cannot be combined with quick or sampled hashes, extra hashes, archives or streams")
+	}
+	if opts.Backup != "" && !i.useDB {
+		return fmt.Errorf("backups require the DuckDB backend (-db)")
+	}
+	rootPaths = i.canonicalRoots(rootPaths)
+	if err := i.selectHasher(ctx, opts, rootPaths); err != nil {
 		return err
 	}
+	stopBackups := func() {}
+	if opts.Backup != "" && opts.BackupInterval > 0 {
+		stopBackups = i.backupEvery(ctx, opts.Backup, opts.BackupInterval)
+		defer stopBackups()
+	}
+	if opts.IdlePriority {
+		if err := lowerPriority(); err != nil {
+			i.logger.Warn("Could not lower the priority of the scan", "err", err)
+		}
+	}
 
-	log.Printf("Starting to index directory: %s", rootPath)
-
-	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			log.Printf("Error accessing path %s: %v", path, err)
-			return nil // Continue with other files
+	for _, rootPath := range rootPaths {
+		var err error
+		if i.useDB {
+			err = i.indexDirectoryDB(ctx, rootPath, opts)
+		} else {
+			err = i.indexDirectoryJSON(ctx, rootPath, opts)
 		}
-		info, err := d.Info()
 		if err != nil {
-			log.Printf("Error getting file info for %s: %v", path, err)
-			return nil // Continue with other files
+			if ctx.Err() != nil {
+				return i.recordInterruption(ctx, rootPath)
+			}
+			return err
 		}
-
-		// Check if the file should be skipped
-		skip, err := shouldSkipFile(path, d)
-		if err != nil {
-			log.Printf("Error during file filtering for %s: %v", path, err)
-			return nil // Continue with other files
+		if err := i.clearInterruption(ctx, rootPath); err != nil {
+			return err
 		}
-		if skip {
-			log.Printf("Skipping file: %s:", path)
-			return nil
+	}
+	if opts.Content {
+		if err := i.buildContentIndex(ctx); err != nil {
+			return err
 		}
-
-		// Skip files larger than maxFileSize
-		if maxFileSize > 0 && info.Size() > maxFileSize {
-			log.Printf("Skipping large file: %s (size: %d bytes)", path, info.Size())
-			return nil
+	}
+	if i.useDB {
+		if _, err := i.RefreshStats(ctx); err != nil {
+			return err
 		}
-
-		// Get absolute path
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			log.Printf("Error getting absolute path for %s: %v", path, err)
-			absPath = path // fallback to original path
+	}
+	if opts.Backup != "" {
+		// A periodic backup still being written must not replace this one
+		stopBackups()
+		if err := i.Backup(ctx, opts.Backup); err != nil {
+			return fmt.Errorf("error backing up the index: %v", err)
 		}
+	}
+	return nil
+}
 
-		// Calculate checksum
-		log.Printf("Adding file: %s, size: %d", absPath, info.Size())
-		checksum, err := i.calculateChecksum(path)
-		if err != nil {
-			log.Printf("Error calculating checksum for %s: %v", path, err)
-			checksum = "" // empty checksum on error
-		}
+// canonicalRoots resolves the symlinks in local root directories, so that a
+// tree reached through a symlinked directory is indexed under the same paths
+// as when it is scanned directly, instead of a second time
+func (i *Indexer) canonicalRoots(rootPaths []string) []string {
+	resolved := make([]string, len(rootPaths))
+	for n, rootPath := range rootPaths {
+		resolved[n] = i.canonicalRoot(rootPath)
+	}
+	return resolved
+}
 
-		fileInfo := models.FileInfo{
-			Path:                 absPath,
-			Filename:             filepath.Base(path),
-			Checksum:             checksum,
-			ModificationDateTime: info.ModTime(),
-			FileSize:             info.Size(),
-			IndexedAt:            time.Now(),
-		}
+// canonicalRoot resolves the symlinks in a local root directory. Roots that
+// cannot be resolved are kept, so the scan reports why they are unreadable.
+func (i *Indexer) canonicalRoot(rootPath string) string {
+	if i.fsys != source.OS || source.IsS3URL(rootPath) {
+		return rootPath
+	}
+	resolved, err := filepath.EvalSymlinks(rootPath)
+	if err != nil || absolutePath(resolved) == absolutePath(rootPath) {
+		return rootPath
+	}
+	i.logger.Info("Indexing root under its resolved path", "dir", rootPath, "resolved", resolved)
+	return resolved
+}
 
-		// Insert into database
-		if err := i.db.InsertFile(fileInfo); err != nil {
-			log.Printf("Error inserting file %s: %v", path, err)
-			return nil
+// indexDirectoryDB indexes files using DuckDB
+func (i *Indexer) indexDirectoryDB(ctx context.Context, rootPath string, opts ScanOptions) error {
+	absRoot := absolutePath(rootPath)
+	scanStart := time.Now().Truncate(time.Microsecond)
+
+	// Set metadata
+	if err := i.db.SetMetadata(ctx, "root_path", rootPath); err != nil {
+		return err
+	}
+	if err := i.db.SetMetadata(ctx, "indexed", time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if err := i.db.SetMetadata(ctx, "hash_algorithm", i.hasher.Name()); err != nil {
+		return err
+	}
+	if opts.SampleHash > 0 {
+		if err := i.db.SetMetadata(ctx, "sample_hash_algorithm", hasher.SampleHashName(i.hasher)); err != nil {
+			return err
 		}
+	}
+	if err := i.db.SetMetadata(ctx, "host", i.host); err != nil {
+		return err
+	}
+	if err := i.db.SetMetadata(ctx, "label", opts.Label); err != nil {
+		return err
+	}
 
-		log.Printf("Indexed file: %s (size: %d bytes)", path, info.Size())
+	i.logger.Info("Starting to index directory", "dir", rootPath, "workers", opts.Workers)
 
-		return nil
-	})
+	// Files hashed before a cancellation are still stored
+	writer := i.newFileWriter(context.WithoutCancel(ctx))
+	unreadable, err := i.scanRoot(ctx, rootPath, opts, writer.Write)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
 
+	// Reconcile: drop files under this root that the completed walk did not see
+	stale, err := i.db.StalePaths(ctx, absRoot, scanStart)
 	if err != nil {
-		return fmt.Errorf("error walking directory: %v", err)
+		return err
+	}
+	stale = prunablePaths(stale, unreadable)
+	if err := i.journalDeletions(ctx, stale); err != nil {
+		return err
+	}
+	if err := i.db.DeletePaths(ctx, stale); err != nil {
+		return err
+	}
+	i.logger.Info("Pruned files no longer present", "dir", absRoot, "files", len(stale))
+	if opts.KeepDeleted > 0 {
+		if err := i.purgeDeletions(ctx, opts.KeepDeleted); err != nil {
+			return err
+		}
 	}
 
-	// Get count of indexed files
-	stats, err := i.db.GetStats()
+	if opts.QuickHash {
+		if err := i.resolveQuickHashCollisions(ctx, opts); err != nil {
+			return err
+		}
+	}
+	if opts.ConfirmSampled {
+		if err := i.confirmSampleHashCollisions(ctx, opts); err != nil {
+			return err
+		}
+	}
+
+	// Record per-root metadata
+	count, size, err := i.db.SummarizePath(ctx, absRoot)
 	if err != nil {
-		log.Printf("Error getting file count: %v", err)
-	} else {
-		log.Printf("Indexing completed. Total files indexed: %v", stats["total_files"])
+		return err
+	}
+	root := models.RootInfo{Path: absRoot, IndexedAt: time.Now(), FileCount: count, TotalSize: size}
+	if err := i.db.UpsertRoot(ctx, root); err != nil {
+		return err
 	}
 
+	if opts.History {
+		snapshot, err := i.db.RecordScan(ctx, absRoot, root.IndexedAt)
+		if err != nil {
+			return err
+		}
+		i.logger.Info("Recorded scan", "scan_id", snapshot.ID, "dir", absRoot,
+			"added", snapshot.Added, "removed", snapshot.Removed, "modified", snapshot.Modified)
+	}
+
+	i.logger.Info("Indexing completed", "dir", absRoot, "files", count)
 	return nil
 }
 
 // indexDirectoryJSON indexes files using JSON storage (original method)
-func (i *Indexer) indexDirectoryJSON(rootPath string, maxFileSize int64) error {
+func (i *Indexer) indexDirectoryJSON(ctx context.Context, rootPath string, opts ScanOptions) error {
+	absRoot := absolutePath(rootPath)
+	scanStart := time.Now()
+
 	i.index.RootPath = rootPath
 	i.index.Indexed = time.Now()
+	i.index.HashAlgorithm = i.hasher.Name()
+	i.index.Host, i.index.Label = i.host, opts.Label
+	if opts.SampleHash > 0 {
+		i.index.SampleHashAlgorithm = hasher.SampleHashName(i.hasher)
+	}
 
-	log.Printf("Starting to index directory: %s", rootPath)
+	i.logger.Info("Starting to index directory", "dir", rootPath, "workers", opts.Workers)
 
-	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			log.Printf("Error accessing path %s: %v", path, err)
-			return nil // Continue with other files
+	writer := i.newFileWriter(ctx)
+	unreadable, err := i.scanRoot(ctx, rootPath, opts, writer.Write)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Reconcile: drop files under this root that the completed walk did not see
+	prefix := strings.TrimSuffix(absRoot, string(filepath.Separator)) + string(filepath.Separator)
+	var stale []string
+	for path, file := range i.index.Files {
+		if (path == absRoot || strings.HasPrefix(path, prefix)) && file.IndexedAt.Before(scanStart) {
+			stale = append(stale, path)
 		}
+	}
+	stale = prunablePaths(stale, unreadable)
+	if err := i.journalDeletions(ctx, stale); err != nil {
+		return err
+	}
+	for _, path := range stale {
+		delete(i.index.Files, path)
+	}
+	i.logger.Info("Pruned files no longer present", "dir", absRoot, "files", len(stale))
+	if opts.KeepDeleted > 0 {
+		if err := i.purgeDeletions(ctx, opts.KeepDeleted); err != nil {
+			return err
+		}
+	}
 
-		info, err := d.Info()
-		if err != nil {
-			log.Printf("Error during accsssing file %s: %v", path, err)
-			return nil
+	if opts.QuickHash {
+		if err := i.resolveQuickHashCollisions(ctx, opts); err != nil {
+			return err
 		}
-		skip, err := shouldSkipFile(path, d)
-		if err != nil {
-			log.Printf("Error during file filtering for %s: %v", path, err)
-			return nil
+	}
+	if opts.ConfirmSampled {
+		if err := i.confirmSampleHashCollisions(ctx, opts); err != nil {
+			return err
 		}
-		if skip {
-			log.Printf("Skipping file: %s:", path)
-			return nil // skip file
+	}
+
+	// Record per-root metadata
+	root := models.RootInfo{Path: absRoot, IndexedAt: time.Now()}
+	for path, file := range i.index.Files {
+		if path == absRoot || strings.HasPrefix(path, prefix) {
+			root.FileCount++
+			root.TotalSize += file.FileSize
 		}
+	}
+	if i.index.Roots == nil {
+		i.index.Roots = make(map[string]models.RootInfo)
+	}
+	i.index.Roots[absRoot] = root
 
-		// Skip files larger than maxFileSize
-		if maxFileSize > 0 && info.Size() > maxFileSize {
-			log.Printf("Skipping large file: %s (size: %d bytes)", path, info.Size())
-			return nil
+	i.logger.Info("Indexing completed", "dir", absRoot, "files", root.FileCount)
+	return nil
+}
+
+// prunablePaths filters stale paths down to those that may be removed from
+// the index. Files below paths the walk could not read or left out are kept,
+// since their absence from the scan says nothing about whether they still
+// exist.
+func prunablePaths(stale, unreadable []string) []string {
+	if len(unreadable) == 0 {
+		return stale
+	}
+
+	var prunable []string
+	for _, path := range stale {
+		keep := false
+		for _, failed := range unreadable {
+			if path == failed || strings.HasPrefix(path, strings.TrimSuffix(failed, string(filepath.Separator))+string(filepath.Separator)) {
+				keep = true
+				break
+			}
+		}
+		if !keep {
+			prunable = append(prunable, path)
 		}
+	}
+	return prunable
+}
 
-		// Get absolute path
-		absPath, err := filepath.Abs(path)
+// Roots returns the root directories covered by the index. Indexes created
+// before roots were tracked report their single root path.
+func (i *Indexer) Roots(ctx context.Context) ([]models.RootInfo, error) {
+	var roots []models.RootInfo
+	if i.useDB {
+		roots, err := i.db.ListRoots(ctx)
 		if err != nil {
-			log.Printf("Error getting absolute path for %s: %v", path, err)
-			absPath = path // fallback to original path
+			return nil, err
 		}
+		if len(roots) == 0 {
+			rootPath, ok, err := i.db.GetMetadata(ctx, "root_path")
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				roots = append(roots, models.RootInfo{Path: absolutePath(rootPath)})
+			}
+		}
+		return roots, nil
+	}
+
+	for _, root := range i.index.Roots {
+		roots = append(roots, root)
+	}
+	sort.Slice(roots, func(a, b int) bool { return roots[a].Path < roots[b].Path })
+	if len(roots) == 0 && i.index.RootPath != "" {
+		roots = append(roots, models.RootInfo{Path: absolutePath(i.index.RootPath), IndexedAt: i.index.Indexed})
+	}
+	return roots, nil
+}
 
-		// Calculate checksum
-		checksum, err := i.calculateChecksum(path)
+// clearIndex removes all files and metadata from the index
+func (i *Indexer) clearIndex(ctx context.Context) error {
+	if i.useDB {
+		return i.db.ClearData(ctx)
+	}
+	i.index.Files = make(map[string]models.FileInfo)
+	i.index.Roots = nil
+	i.index.Interrupted = nil
+	return nil
+}
+
+// storeFunc returns the function that stores an indexed file in the backend
+func (i *Indexer) storeFunc(ctx context.Context) func(models.FileInfo) error {
+	if i.useDB {
+		return func(fileInfo models.FileInfo) error {
+			return i.db.InsertFile(ctx, fileInfo)
+		}
+	}
+	return func(fileInfo models.FileInfo) error {
+		i.index.Files[fileInfo.Path] = fileInfo
+		return nil
+	}
+}
+
+// filesBelow returns the indexed files at or below a path by path
+func (i *Indexer) filesBelow(ctx context.Context, absPath string) (map[string]models.FileInfo, error) {
+	below := make(map[string]models.FileInfo)
+	if i.useDB {
+		files, err := i.db.ListFilesBelow(ctx, absPath)
 		if err != nil {
-			log.Printf("Error calculating checksum for %s: %v", path, err)
-			checksum = "" // empty checksum on error
+			return nil, err
 		}
+		// Checksums of other algorithms are only needed to tell whether a
+		// scan has to compute them
+		var checksums map[string]map[string]string
+		if len(i.extra) > 0 {
+			if checksums, err = i.db.FileChecksums(ctx); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			file.Checksums = checksums[file.Location()]
+			below[file.Path] = file
+		}
+		return below, nil
+	}
 
-		fileInfo := models.FileInfo{
-			Path:                 absPath,
-			Filename:             filepath.Base(path),
-			Checksum:             checksum,
-			ModificationDateTime: info.ModTime(),
-			FileSize:             info.Size(),
-			IndexedAt:            time.Now(),
+	prefix := strings.TrimSuffix(absPath, string(filepath.Separator)) + string(filepath.Separator)
+	for path, file := range i.index.Files {
+		if path == absPath || strings.HasPrefix(path, prefix) {
+			below[path] = file
 		}
+	}
+	return below, nil
+}
 
-		i.index.Files[absPath] = fileInfo
+// removePath removes a file, or all files below a directory, from the
+// index, along with the entries of an archive at the path
+func (i *Indexer) removePath(ctx context.Context, absPath string) error {
+	if i.useDB {
+		return i.db.DeleteFiles(ctx, absPath)
+	}
+
+	prefix := strings.TrimSuffix(absPath, string(filepath.Separator)) + string(filepath.Separator)
+	entries := models.EntryPrefixes(absPath)
+	for path := range i.index.Files {
+		if path == absPath || strings.HasPrefix(path, prefix) || hasAnyPrefix(path, entries) {
+			delete(i.index.Files, path)
+		}
+	}
+	return nil
+}
 
-		log.Printf("Indexed file: %s (size: %d bytes)", path, info.Size())
+// hasAnyPrefix reports whether path starts with one of the prefixes
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
 
-		return nil
-	})
+// calculateChecksum calculates the checksum of a file with the index's algorithm
+func (i *Indexer) calculateChecksum(path string) (string, error) {
+	return hasher.HashFile(i.hasher, i.fsys, path)
+}
 
+// algorithmOrDefault returns the named hasher, falling back to the default
+func algorithmOrDefault(name string) hasher.Hasher {
+	h, err := hasher.Get(name)
 	if err != nil {
-		return fmt.Errorf("error walking directory: %v", err)
+		h, _ = hasher.Get(hasher.Default)
 	}
+	return h
+}
 
-	log.Printf("Indexing completed. Total files indexed: %d", len(i.index.Files))
+// useStoredHasher switches to the checksum algorithm recorded in the index,
+// so that stored checksums can be recomputed outside of a scan
+func (i *Indexer) useStoredHasher(ctx context.Context) error {
+	stored, _, err := i.storedHashAlgorithm(ctx)
+	if err != nil {
+		return err
+	}
+	if stored != "" {
+		i.hasher = algorithmOrDefault(stored)
+	}
 	return nil
 }
 
-func shouldSkipFile(path string, d fs.DirEntry) (bool, error) {
-	// Skip hidden files and directories
-	if strings.HasPrefix(filepath.Base(path), ".") {
-		if d.IsDir() {
-			return true, nil
+// HashAlgorithm returns the checksum algorithm used by the index
+func (i *Indexer) HashAlgorithm(ctx context.Context) (string, error) {
+	stored, _, err := i.storedHashAlgorithm(ctx)
+	if err != nil {
+		return "", err
+	}
+	if stored != "" {
+		return stored, nil
+	}
+	return i.hasher.Name(), nil
+}
+
+// storedHashAlgorithm returns the algorithm recorded in the index and
+// whether the index contains any files. Indexes created before the
+// algorithm was recorded use MD5.
+func (i *Indexer) storedHashAlgorithm(ctx context.Context) (string, bool, error) {
+	var stored string
+	var fileCount int
+
+	if i.useDB {
+		value, ok, err := i.db.GetMetadata(ctx, "hash_algorithm")
+		if err != nil {
+			return "", false, err
 		}
-		return true, nil
+		if ok {
+			stored = value
+		}
+		if fileCount, err = i.db.CountFiles(ctx); err != nil {
+			return "", false, err
+		}
+	} else {
+		stored = i.index.HashAlgorithm
+		fileCount = len(i.index.Files)
 	}
 
-	// Skip directories - we only index files
-	if d.IsDir() {
-		return true, nil
+	if stored == "" && fileCount > 0 {
+		stored = hasher.Default
 	}
+	return stored, fileCount > 0, nil
+}
 
-	info, err := d.Info()
+// selectHasher picks the checksum algorithm for a scan and refuses to mix
+// algorithms within one index unless a migration was requested. A migration
+// must re-index every root of the index. Stored checksums of the new
+// algorithm are promoted and the current ones kept as checksums of another
+// algorithm, so only the files without one are hashed again.
+func (i *Indexer) selectHasher(ctx context.Context, opts ScanOptions, rootPaths []string) error {
+	stored, hasFiles, err := i.storedHashAlgorithm(ctx)
 	if err != nil {
-		log.Printf("Error getting file info for %s: %v", path, err)
-		return true, err
+		return err
 	}
 
-	// Skip special files (symlinks, etc.)
-	if !info.Mode().IsRegular() {
-		log.Printf("Skipping special file: %s", path)
-		return true, nil
+	name := opts.HashAlgorithm
+	if name == "" {
+		name = stored
+	}
+	if name == "" {
+		name = hasher.Default
 	}
-	return false, nil
-}
 
-// calculateChecksum calculates MD5 checksum of a file
-func (i *Indexer) calculateChecksum(path string) (string, error) {
-	file, err := os.Open(path)
+	h, err := hasher.Get(name)
 	if err != nil {
-		return "", err
+		return err
+	}
+	extra, err := extraHashers(h, opts.ExtraHashes)
+	if err != nil {
+		return err
 	}
 
-	hash := md5.New()
-	_, err = io.Copy(hash, file)
+	if hasFiles && stored != h.Name() {
+		if !opts.MigrateHash {
+			return fmt.Errorf("index uses %s checksums; re-run with -migrate-hash to re-hash all files with %s", stored, h.Name())
+		}
 
-	// Now, close the file and capture the error.
-	closeErr := file.Close()
+		requested := make(map[string]bool)
+		for _, rootPath := range rootPaths {
+			requested[absolutePath(rootPath)] = true
+		}
+		roots, err := i.Roots(ctx)
+		if err != nil {
+			return err
+		}
+		for _, root := range roots {
+			if !requested[root.Path] {
+				return fmt.Errorf("migrating to %s requires re-indexing all roots; %s is missing", h.Name(), root.Path)
+			}
+		}
 
-	// The error from the primary operation (copying) is more important.
-	if err != nil {
-		return "", err
+		i.logger.Info("Migrating index checksums", "from", stored, "to", h.Name())
+		if err := i.promoteChecksums(ctx, stored, h.Name()); err != nil {
+			return err
+		}
 	}
 
-	// If copying succeeded, return the error from closing the file, if any.
-	if closeErr != nil {
-		return "", closeErr
+	i.hasher, i.extra = h, extra
+	return nil
+}
+
+// extraHashers returns the hashers of the other algorithms a scan stores
+// checksums of, leaving out the index's own
+func extraHashers(primary hasher.Hasher, names []string) ([]hasher.Hasher, error) {
+	var extra []hasher.Hasher
+	for _, name := range names {
+		h, err := hasher.Get(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		duplicate := slices.ContainsFunc(extra, func(other hasher.Hasher) bool { return other.Name() == h.Name() })
+		if h.Name() != primary.Name() && !duplicate {
+			extra = append(extra, h)
+		}
+	}
+	return extra, nil
+}
+
+// promoteChecksums makes the stored checksums of the algorithm to those of
+// the index, keeping the current ones as checksums of the algorithm from.
+// Quick and sampled hashes are computed with the index's algorithm and are
+// dropped.
+func (i *Indexer) promoteChecksums(ctx context.Context, from, to string) error {
+	if i.useDB {
+		promoted, err := i.db.PromoteChecksums(ctx, from, to)
+		if err != nil {
+			return err
+		}
+		i.logger.Info("Promoted stored checksums", "algorithm", to, "files", promoted)
+		return nil
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	promoted := 0
+	for path, file := range i.index.Files {
+		checksums := make(map[string]string, len(file.Checksums)+1)
+		for algorithm, checksum := range file.Checksums {
+			if algorithm != to {
+				checksums[algorithm] = checksum
+			}
+		}
+		if file.Checksum != "" {
+			checksums[from] = file.Checksum
+		}
+		file.Checksum, file.Checksums = file.Checksums[to], checksums
+		file.QuickHash, file.SampleHash = "", ""
+		if file.Checksum != "" {
+			promoted++
+		}
+		i.index.Files[path] = file
+	}
+	i.logger.Info("Promoted stored checksums", "algorithm", to, "files", promoted)
+	return nil
+}
+
+// hashFile calculates the checksum of a file and, in the same read, those of
+// the other algorithms of the scan, which are nil if there are none
+func (i *Indexer) hashFile(fsys source.FS, path string) (string, map[string]string, error) {
+	if len(i.extra) == 0 {
+		checksum, err := hasher.HashFile(i.hasher, fsys, path)
+		return checksum, nil, err
+	}
+	checksums, err := hasher.HashFileAll(append([]hasher.Hasher{i.hasher}, i.extra...), fsys, path)
+	if err != nil {
+		return "", nil, err
+	}
+	extra := make(map[string]string, len(i.extra))
+	for n, h := range i.extra {
+		extra[h.Name()] = checksums[n+1]
+	}
+	return checksums[0], extra, nil
+}
+
+// hasExtraChecksums reports whether a stored file has the checksums of all
+// other algorithms of the scan
+func (i *Indexer) hasExtraChecksums(file models.FileInfo) bool {
+	for _, h := range i.extra {
+		if file.Checksums[h.Name()] == "" {
+			return false
+		}
+	}
+	return true
 }
 
 // SaveIndex saves the index to storage
 func (i *Indexer) SaveIndex() error {
+	if i.readOnly {
+		return ErrReadOnly
+	}
 	if i.useDB {
 		return nil // Database is already saved during indexing
 	}
 	return i.saveIndexJSON()
 }
 
-// saveIndexJSON saves the index to a JSON file
+// saveIndexJSON saves the index to a JSON file. Compressed indexes are
+// written without indentation, as they are not meant to be read directly.
 func (i *Indexer) saveIndexJSON() error {
-	data, err := json.MarshalIndent(i.index, "", "  ")
+	file, err := createIndexFile(i.indexPath)
 	if err != nil {
-		return fmt.Errorf("error marshaling index: %v", err)
+		return fmt.Errorf("error writing index file: %v", err)
 	}
 
-	err = os.WriteFile(i.indexPath, data, 0644)
-	if err != nil {
+	if err := writeIndexJSON(file, i.index, indexCompression(i.indexPath) == ""); err != nil {
+		file.Discard()
+		return fmt.Errorf("error marshaling index: %v", err)
+	}
+	if err := file.Close(); err != nil {
 		return fmt.Errorf("error writing index file: %v", err)
 	}
 
-	log.Printf("Index saved to: %s", i.indexPath)
+	i.logger.Info("Index saved", "path", i.indexPath)
 	return nil
 }
 
@@ -314,6 +932,41 @@ func (i *Indexer) LoadIndex() error {
 	return i.loadIndexJSON()
 }
 
+// StreamIndex prepares a JSON index for queries without loading it. Search,
+// SearchContent, ListFiles and CountMatches then read the index file one
+// record at a time and hold only the matching files in memory; other
+// methods see an empty index. In DuckDB mode it does nothing.
+func (i *Indexer) StreamIndex() {
+	i.streamed = !i.useDB
+}
+
+// eachFile calls fn for every file of the JSON index, reading them from the
+// index file if the index is streamed
+func (i *Indexer) eachFile(fn func(models.FileInfo)) error {
+	if !i.streamed {
+		for _, file := range i.index.Files {
+			fn(file)
+		}
+		return nil
+	}
+
+	file, err := openIndexFile(i.indexPath)
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	defer file.Close()
+
+	var header models.Index
+	err = readIndexJSON(file, &header, func(_ string, file models.FileInfo) error {
+		fn(file)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	return nil
+}
+
 // loadIndexDB loads the index from database (not needed for DB mode)
 func (i *Indexer) loadIndexDB() error {
 	// For database mode, we don't need to load anything into memory
@@ -321,115 +974,239 @@ func (i *Indexer) loadIndexDB() error {
 	return nil
 }
 
-// loadIndexJSON loads the index from a JSON file
+// loadIndexJSON loads the index from a JSON file, decompressing it if its
+// extension names a compression
 func (i *Indexer) loadIndexJSON() error {
-	data, err := os.ReadFile(i.indexPath)
+	file, err := openIndexFile(i.indexPath)
 	if err != nil {
 		return fmt.Errorf("error reading index file: %v", err)
 	}
+	defer file.Close()
 
-	err = json.Unmarshal(data, i.index)
+	if i.index.Files == nil {
+		i.index.Files = make(map[string]models.FileInfo)
+	}
+	err = readIndexJSON(file, i.index, func(path string, file models.FileInfo) error {
+		i.index.Files[path] = file
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("error unmarshaling index: %v", err)
 	}
 
-	log.Printf("Index loaded from: %s", i.indexPath)
+	i.logger.Debug("Index loaded", "path", i.indexPath)
 	return nil
 }
 
-// Search searches for files matching the query
-func (i *Indexer) Search(query string) []models.FileInfo {
+// Search searches for files whose name, path or MIME type contains the text
+// and that match the query's filters
+func (i *Indexer) Search(ctx context.Context, text string, query models.FileQuery) ([]models.FileInfo, error) {
 	if i.useDB {
-		return i.searchDB(query)
+		return i.db.SearchFiles(ctx, text, query)
 	}
-	return i.searchJSON(query)
+	return i.queryJSON(text, false, query)
 }
 
-// searchDB searches for files in the database
-func (i *Indexer) searchDB(query string) []models.FileInfo {
-	files, err := i.db.SearchFiles(query)
-	if err != nil {
-		log.Printf("Error searching database: %v", err)
-		return []models.FileInfo{}
+// SearchContent searches for files by name, path, MIME type or, for files
+// indexed with content, by text inside the file
+func (i *Indexer) SearchContent(ctx context.Context, text string, query models.FileQuery) ([]models.FileInfo, error) {
+	if i.useDB {
+		return i.db.SearchContent(ctx, text, query)
 	}
-	return files
+	return i.queryJSON(text, true, query)
 }
 
-// searchJSON searches for files in the JSON index
-func (i *Indexer) searchJSON(query string) []models.FileInfo {
-	var results []models.FileInfo
-	query = strings.ToLower(query)
+// RankedSearch searches the contents of files indexed with content for the
+// keywords of text and returns the matches best first, ranked by BM25 with
+// stemming. It needs the full-text index that DuckDB scans with content
+// build.
+func (i *Indexer) RankedSearch(ctx context.Context, text string, query models.FileQuery) ([]models.RankedFile, error) {
+	if err := i.checkRankedSearch(); err != nil {
+		return nil, err
+	}
+	return i.db.RankedSearch(ctx, text, query)
+}
 
-	for _, file := range i.index.Files {
-		if strings.Contains(strings.ToLower(file.Filename), query) ||
-			strings.Contains(strings.ToLower(file.Path), query) {
-			results = append(results, file)
-		}
+// CountRanked returns how many files RankedSearch would return without the
+// query's limit and offset
+func (i *Indexer) CountRanked(ctx context.Context, text string, query models.FileQuery) (int64, error) {
+	if err := i.checkRankedSearch(); err != nil {
+		return 0, err
 	}
+	return i.db.CountRanked(ctx, text, query)
+}
+
+// checkRankedSearch fails unless the index is a DuckDB database, the only
+// backend with full-text indexes
+func (i *Indexer) checkRankedSearch() error {
+	if !i.useDB || db.IsPostgresURL(i.indexPath) {
+		return fmt.Errorf("ranked content search requires the DuckDB backend (-db)")
+	}
+	return nil
+}
 
-	return results
+// buildContentIndex rebuilds the full-text index of a DuckDB index after a
+// scan stored contents. Without the fts extension, which DuckDB downloads,
+// the scan still succeeds and only ranked search is unavailable.
+func (i *Indexer) buildContentIndex(ctx context.Context) error {
+	if i.checkRankedSearch() != nil {
+		return nil
+	}
+	start := time.Now()
+	err := i.db.BuildContentIndex(context.WithoutCancel(ctx))
+	if errors.Is(err, db.ErrNoFullText) {
+		i.logger.Warn("Could not build the full-text index for ranked content search", "err", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	i.logger.Info("Built the full-text index of file contents", "duration", time.Since(start).Round(time.Millisecond))
+	return nil
 }
 
-// ListFiles returns all indexed files
-func (i *Indexer) ListFiles() []models.FileInfo {
+// ListFiles returns the indexed files that match the query's filters
+func (i *Indexer) ListFiles(ctx context.Context, query models.FileQuery) ([]models.FileInfo, error) {
 	if i.useDB {
-		return i.listFilesDB()
+		return i.db.ListFiles(ctx, query)
 	}
-	return i.listFilesJSON()
+	return i.queryJSON("", false, query)
 }
 
-// listFilesDB lists all files from the database
-func (i *Indexer) listFilesDB() []models.FileInfo {
-	files, err := i.db.ListFiles()
+// CountMatches returns how many files Search (or SearchContent, if content is
+// set) would return without the query's limit and offset. An empty text
+// counts all files matching the query's filters.
+func (i *Indexer) CountMatches(ctx context.Context, text string, content bool, query models.FileQuery) (int64, error) {
+	if i.useDB {
+		return i.db.CountMatches(ctx, text, content, query)
+	}
+
+	query, err := i.withUserTags(query)
 	if err != nil {
-		log.Printf("Error listing files from database: %v", err)
-		return []models.FileInfo{}
+		return 0, err
 	}
-	return files
+	var count int64
+	text = strings.ToLower(text)
+	err = i.eachFile(func(file models.FileInfo) {
+		if query.Matches(file) && matchesText(file, text, content) {
+			count++
+		}
+	})
+	return count, err
 }
 
-// listFilesJSON lists all files from the JSON index
-func (i *Indexer) listFilesJSON() []models.FileInfo {
-	var files []models.FileInfo
-	for _, file := range i.index.Files {
-		files = append(files, file)
+// queryJSON returns the page of JSON index files that contain text and match
+// the query, ordered like the database results
+func (i *Indexer) queryJSON(text string, content bool, query models.FileQuery) ([]models.FileInfo, error) {
+	query, err := i.withUserTags(query)
+	if err != nil {
+		return nil, err
 	}
-	return files
+	var results []models.FileInfo
+	text = strings.ToLower(text)
+	err = i.eachFile(func(file models.FileInfo) {
+		if !query.Matches(file) || !matchesText(file, text, content) {
+			return
+		}
+		file.Content = "" // match the database, which does not return contents
+		results = append(results, file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return query.Page(results), nil
+}
+
+// matchesText reports whether a file's name, path or MIME type (and
+// optionally content) contains the lowercase text
+func matchesText(file models.FileInfo, text string, content bool) bool {
+	return strings.Contains(strings.ToLower(file.Filename), text) ||
+		strings.Contains(strings.ToLower(file.Path), text) ||
+		strings.Contains(strings.ToLower(file.MimeType), text) ||
+		(content && strings.Contains(strings.ToLower(file.Content), text))
 }
 
-// GetStats returns statistics about the index
-func (i *Indexer) GetStats() map[string]interface{} {
+// liveStats computes statistics about the index from its files, including
+// the space wasted by duplicates and the usage of top-level directories
+func (i *Indexer) liveStats(ctx context.Context) (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	var err error
 	if i.useDB {
-		return i.getStatsDB()
+		stats, err = i.getStatsDB(ctx)
+	} else {
+		stats, err = i.getStatsJSON(ctx)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return i.getStatsJSON()
+
+	groups, err := i.FindDuplicates(ctx, OriginalPolicy{})
+	if err != nil {
+		return nil, err
+	}
+	stats["duplicates"] = models.SummarizeDuplicates(groups, topDuplicateGroups)
+
+	if stats["directories"], err = i.topLevelDirectories(ctx); err != nil {
+		return nil, err
+	}
+
+	scan, err := i.Interruption(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scan != nil {
+		stats["interrupted_scan"] = *scan
+	}
+	return stats, nil
 }
 
+// topDuplicateGroups is the number of duplicate groups wasting the most space
+// that statistics list
+const topDuplicateGroups = 10
+
 // getStatsDB gets statistics from the database
-func (i *Indexer) getStatsDB() map[string]interface{} {
-	stats, err := i.db.GetStats()
+func (i *Indexer) getStatsDB(ctx context.Context) (map[string]interface{}, error) {
+	stats, err := i.db.GetStats(ctx)
 	if err != nil {
-		log.Printf("Error getting database stats: %v", err)
-		return map[string]interface{}{
-			"error": "Failed to get database statistics",
-		}
+		return nil, err
 	}
-	return stats
+	if stats["roots"], err = i.Roots(ctx); err != nil {
+		return nil, err
+	}
+	return stats, nil
 }
 
 // getStatsJSON gets statistics from the JSON index
-func (i *Indexer) getStatsJSON() map[string]interface{} {
+func (i *Indexer) getStatsJSON(ctx context.Context) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 	stats["total_files"] = len(i.index.Files)
 	stats["indexed_time"] = i.index.Indexed
 	stats["root_path"] = i.index.RootPath
+	stats["host"], stats["label"] = i.index.Host, i.index.Label
+	stats["roots"], _ = i.Roots(ctx)
+	stats["hash_algorithm"], _ = i.HashAlgorithm(ctx)
+	if i.index.SampleHashAlgorithm != "" {
+		sampled := 0
+		for _, file := range i.index.Files {
+			if file.SampleHash != "" && file.Checksum == "" {
+				sampled++
+			}
+		}
+		stats["sample_hash_algorithm"], stats["sampled_files"] = i.index.SampleHashAlgorithm, sampled
+	}
 
 	var totalSize int64
 	fileTypes := make(map[string]int)
+	mimeTypes := make(map[string]int)
+	histogram := models.NewSizeHistogram()
 
 	for _, file := range i.index.Files {
 		totalSize += file.FileSize
 
+		bucket := &histogram[models.SizeBucketIndex(histogram, file.FileSize)]
+		bucket.FileCount++
+		bucket.TotalSize += file.FileSize
+
 		// Extract extension from filename
 		ext := strings.ToLower(filepath.Ext(file.Filename))
 		if ext == "" {
@@ -437,18 +1214,160 @@ func (i *Indexer) getStatsJSON() map[string]interface{} {
 		} else {
 			fileTypes[ext]++
 		}
+
+		if file.MimeType == "" {
+			mimeTypes["unknown"]++
+		} else {
+			mimeTypes[file.MimeType]++
+		}
 	}
 
 	stats["total_size"] = totalSize
 	stats["file_types"] = fileTypes
+	stats["mime_types"] = mimeTypes
+	stats["size_histogram"] = histogram
+
+	return stats, nil
+}
+
+// FindDuplicates returns groups of files with identical size and checksum,
+// with the original chosen by policy listed first in each group
+func (i *Indexer) FindDuplicates(ctx context.Context, policy OriginalPolicy) ([]models.DuplicateGroup, error) {
+	return i.FindFilteredDuplicates(ctx, policy, models.DuplicateFilter{})
+}
+
+// FindFilteredDuplicates returns the duplicate groups that pass filter, like
+// FindDuplicates. Databases apply the filter while grouping files, so the
+// files of small groups are not even read.
+func (i *Indexer) FindFilteredDuplicates(ctx context.Context, policy OriginalPolicy, filter models.DuplicateFilter) ([]models.DuplicateGroup, error) {
+	var groups []models.DuplicateGroup
+	if i.useDB {
+		var err error
+		if groups, err = i.db.FindDuplicates(ctx, filter); err != nil {
+			return nil, err
+		}
+	} else {
+		groups = i.findDuplicatesJSON(filter)
+	}
+
+	tags, err := i.Tags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byChecksum := make(map[string][]string)
+	for _, tag := range tags {
+		byChecksum[tag.Checksum] = append(byChecksum[tag.Checksum], tag.Tag)
+	}
+	for n := range groups {
+		policy.Apply(&groups[n])
+		groups[n].Tags = byChecksum[groups[n].Checksum]
+	}
+	return groups, nil
+}
+
+// Unhashed counts the files that have no checksum, sampled hash or quick hash
+// to be compared by, such as those of scans with ScanOptions.NoChecksum, and
+// all files. Duplicates are not found among the former, nor are they
+// verified. Recorded symlinks count as neither.
+func (i *Indexer) Unhashed(ctx context.Context) (int64, int64, error) {
+	if i.useDB {
+		return i.db.CountUnhashed(ctx)
+	}
+	var unhashed, total int64
+	for _, file := range i.index.Files {
+		if file.LinkTarget != "" {
+			continue
+		}
+		total++
+		if file.Checksum == "" && file.SampleHash == "" && file.QuickHash == "" {
+			unhashed++
+		}
+	}
+	return unhashed, total, nil
+}
+
+// FindSampledDuplicates returns the groups of files that share a sampled
+// hash but have no full checksum, choosing the original of each group with
+// policy. They are only candidates: scanning with ScanOptions.ConfirmSampled
+// hashes them fully, so they become duplicates or are told apart.
+func (i *Indexer) FindSampledDuplicates(ctx context.Context, policy OriginalPolicy) ([]models.DuplicateGroup, error) {
+	var groups []models.DuplicateGroup
+	if i.useDB {
+		var err error
+		if groups, err = i.db.FindSampledDuplicates(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		var files []models.FileInfo
+		for _, file := range i.index.Files {
+			if file.Checksum == "" && !models.IsAppleDouble(file.Filename) {
+				files = append(files, file)
+			}
+		}
+		groups = models.GroupSampled(files)
+	}
+	for n := range groups {
+		policy.Apply(&groups[n])
+	}
+	return groups, nil
+}
+
+// findDuplicatesJSON finds the duplicate groups of the JSON index that pass
+// filter
+func (i *Indexer) findDuplicatesJSON(filter models.DuplicateFilter) []models.DuplicateGroup {
+	// Pre-filter by size: only sizes shared by several files can be duplicates
+	bySize := make(map[int64][]models.FileInfo)
+	var appleDoubles []models.FileInfo
+	for _, file := range i.index.Files {
+		if models.IsAppleDouble(file.Filename) {
+			appleDoubles = append(appleDoubles, file)
+			continue
+		}
+		bySize[file.FileSize] = append(bySize[file.FileSize], file)
+	}
+
+	var groups []models.DuplicateGroup
+	for size, files := range bySize {
+		if len(files) < 2 || size < filter.MinFileSize {
+			continue
+		}
 
-	return stats
+		byChecksum := make(map[string][]models.FileInfo)
+		for _, file := range files {
+			if file.Checksum == "" {
+				continue
+			}
+			byChecksum[file.Checksum] = append(byChecksum[file.Checksum], file)
+		}
+
+		for checksum, members := range byChecksum {
+			if len(members) < 2 {
+				continue
+			}
+			sort.Slice(members, func(a, b int) bool { return members[a].Path < members[b].Path })
+			// Groups made up only of hardlinks to one file waste no space
+			if group := models.NewDuplicateGroup(checksum, size, members); group.Copies > 1 && filter.Matches(group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	// Match the database ordering: largest files first
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].FileSize != groups[b].FileSize {
+			return groups[a].FileSize > groups[b].FileSize
+		}
+		return groups[a].Checksum < groups[b].Checksum
+	})
+
+	models.AttachCompanions(groups, appleDoubles)
+	return groups
 }
 
 // GetFileByPathAndFilename retrieves a file by its path and filename.
-func (i *Indexer) GetFileByPathAndFilename(path, filename string) (*models.FileInfo, error) {
+func (i *Indexer) GetFileByPathAndFilename(ctx context.Context, path, filename string) (*models.FileInfo, error) {
 	if i.useDB {
-		return i.db.GetFileByPathAndFilename(path, filename)
+		return i.db.GetFileByPathAndFilename(ctx, path, filename)
 	}
 
 	// For JSON index, search through the files
@@ -461,10 +1380,222 @@ func (i *Indexer) GetFileByPathAndFilename(path, filename string) (*models.FileI
 	return nil, nil // Not found
 }
 
-// ExecuteSQL executes a custom SQL query (database mode only)
-func (i *Indexer) ExecuteSQL(sqlQuery string) error {
+// ExportIndex returns the complete index contents, including metadata, in
+// the backend-independent JSON representation
+func (i *Indexer) ExportIndex(ctx context.Context) (*models.Index, error) {
+	if !i.useDB {
+		return i.index, nil
+	}
+
+	files, err := i.db.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := i.db.FileContents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs, err := i.db.FileXAttrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := i.db.FileChecksums(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	findings, err := i.db.FileSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &models.Index{
+		Files: make(map[string]models.FileInfo, len(files)),
+	}
+	for _, file := range files {
+		file.Content = contents[file.Location()]
+		file.XAttrs = xattrs[file.Location()]
+		file.Checksums = checksums[file.Location()]
+		file.Secrets = findings[file.Location()]
+		index.Files[i.fileKey(file)] = file
+	}
+
+	if value, ok, err := i.db.GetMetadata(ctx, "root_path"); err != nil {
+		return nil, err
+	} else if ok {
+		index.RootPath = value
+	}
+	if value, ok, err := i.db.GetMetadata(ctx, "host"); err != nil {
+		return nil, err
+	} else if ok {
+		index.Host = value
+	}
+	if value, ok, err := i.db.GetMetadata(ctx, "label"); err != nil {
+		return nil, err
+	} else if ok {
+		index.Label = value
+	}
+	if value, ok, err := i.db.GetMetadata(ctx, "indexed"); err != nil {
+		return nil, err
+	} else if ok {
+		if indexed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			index.Indexed = indexed
+		}
+	}
+	if index.HashAlgorithm, _, err = i.storedHashAlgorithm(ctx); err != nil {
+		return nil, err
+	}
+	if index.SampleHashAlgorithm, _, err = i.db.GetMetadata(ctx, "sample_hash_algorithm"); err != nil {
+		return nil, err
+	}
+	if index.Interrupted, err = i.Interruption(ctx); err != nil {
+		return nil, err
+	}
+
+	roots, err := i.db.ListRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) > 0 {
+		index.Roots = make(map[string]models.RootInfo, len(roots))
+		for _, root := range roots {
+			index.Roots[root.Path] = root
+		}
+	}
+
+	if index.Actions, err = i.db.ListActions(ctx); err != nil {
+		return nil, err
+	}
+	if index.Tags, err = i.db.ListTags(ctx); err != nil {
+		return nil, err
+	}
+	if index.Deletions, err = i.db.ListDeletions(ctx, models.DeletionQuery{}); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// ImportIndex replaces the contents of the index with the given files and
+// metadata. Call SaveIndex afterwards to persist a JSON index.
+func (i *Indexer) ImportIndex(ctx context.Context, index *models.Index) error {
+	if i.readOnly {
+		return ErrReadOnly
+	}
+	if !i.useDB {
+		files := make(map[string]models.FileInfo, len(index.Files))
+		for _, file := range index.Files {
+			files[i.fileKey(file)] = file
+		}
+		i.index = index
+		i.index.Files = files
+		return nil
+	}
+
+	if err := i.db.ClearData(ctx); err != nil {
+		return err
+	}
+	if err := i.db.SetMetadata(ctx, "root_path", index.RootPath); err != nil {
+		return err
+	}
+	if err := i.db.SetMetadata(ctx, "indexed", index.Indexed.Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+	if err := i.db.SetMetadata(ctx, "host", index.Host); err != nil {
+		return err
+	}
+	if err := i.db.SetMetadata(ctx, "label", index.Label); err != nil {
+		return err
+	}
+	if index.HashAlgorithm != "" {
+		if err := i.db.SetMetadata(ctx, "hash_algorithm", index.HashAlgorithm); err != nil {
+			return err
+		}
+	}
+	if index.SampleHashAlgorithm != "" {
+		if err := i.db.SetMetadata(ctx, "sample_hash_algorithm", index.SampleHashAlgorithm); err != nil {
+			return err
+		}
+	}
+
+	if index.Interrupted != nil {
+		if err := i.setInterruption(ctx, *index.Interrupted); err != nil {
+			return err
+		}
+	}
+
+	for _, root := range index.Roots {
+		if err := i.db.UpsertRoot(ctx, root); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range index.Actions {
+		if err := i.db.RecordAction(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range index.Tags {
+		if err := i.db.AddTag(ctx, tag); err != nil {
+			return err
+		}
+	}
+
+	for _, deleted := range index.Deletions {
+		if err := i.db.AddDeletion(ctx, deleted); err != nil {
+			return err
+		}
+	}
+
+	files := make([]models.FileInfo, 0, len(index.Files))
+	for _, file := range index.Files {
+		files = append(files, file)
+	}
+	return i.db.InsertFiles(ctx, files)
+}
+
+// Scans returns the scans recorded in history mode
+func (i *Indexer) Scans(ctx context.Context) ([]models.ScanSnapshot, error) {
+	if !i.useDB {
+		return nil, fmt.Errorf("scan history is only available in database mode")
+	}
+	return i.db.ListScans(ctx)
+}
+
+// History returns the file changes recorded in history mode
+func (i *Indexer) History(ctx context.Context, query models.HistoryQuery) ([]models.HistoryChange, error) {
+	if !i.useDB {
+		return nil, fmt.Errorf("scan history is only available in database mode")
+	}
+	return i.db.ListChanges(ctx, query)
+}
+
+// ExecuteSQL executes a custom SQL query, with args bound to its ?
+// placeholders, and writes its result in the output format (database mode
+// only)
+func (i *Indexer) ExecuteSQL(ctx context.Context, w io.Writer, output db.SQLOutput, sqlQuery string, args ...interface{}) error {
 	if !i.useDB {
 		return fmt.Errorf("SQL queries are only available in database mode")
 	}
-	return i.db.ExecuteSQL(sqlQuery)
+	return i.db.ExecuteSQL(ctx, w, output, sqlQuery, args...)
+}
+
+// CheckDatabase checks the schema and data of the database for problems,
+// each reported with repair guidance (database mode only)
+func (i *Indexer) CheckDatabase(ctx context.Context) (models.HealthReport, error) {
+	if !i.useDB {
+		return models.HealthReport{}, fmt.Errorf("database checks are only available in database mode")
+	}
+	lengths := make(map[string]int)
+	for _, name := range hasher.Names() {
+		h, err := hasher.Get(name)
+		if err != nil {
+			return models.HealthReport{}, err
+		}
+		lengths[name] = hex.EncodedLen(h.New().Size())
+	}
+	return i.db.CheckIntegrity(ctx, lengths)
 }
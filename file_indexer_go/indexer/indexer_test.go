@@ -0,0 +1,257 @@
+package indexer
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// testRoot is the mount point of the in-memory filesystems of the tests
+const testRoot = "/mem"
+
+// testHost is the name of the machine of the indexes of the tests
+const testHost = "test"
+
+// testModTime is the modification time of the files of the tests
+var testModTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// memFS returns an in-memory filesystem mounted at testRoot with files of the
+// given contents, keyed by their slash-separated paths below the root
+func memFS(files map[string]string) source.FS {
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(content), Mode: 0o644, ModTime: testModTime}
+	}
+	return source.FromFS(fsys, testRoot)
+}
+
+// newTestIndexer opens an index in a temporary directory, in DuckDB if
+// useDB is set and as JSON otherwise, that indexes from fsys
+func newTestIndexer(t *testing.T, useDB bool, fsys source.FS) *Indexer {
+	t.Helper()
+	name := "index.json"
+	if useDB {
+		name = "index.db"
+	}
+	idx := NewIndexer(filepath.Join(t.TempDir(), name), useDB)
+	idx.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	idx.SetHost(testHost)
+	if fsys != nil {
+		idx.SetFS(fsys)
+	}
+	if err := idx.InitDatabase(context.Background()); err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	t.Cleanup(func() { idx.CloseDatabase() })
+	return idx
+}
+
+// indexedFiles returns the indexed files by their path below testRoot
+func indexedFiles(t *testing.T, idx *Indexer) map[string]models.FileInfo {
+	t.Helper()
+	files, err := idx.ListFiles(context.Background(), models.FileQuery{})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	byPath := make(map[string]models.FileInfo, len(files))
+	for _, file := range files {
+		rel, err := filepath.Rel(testRoot, file.Path)
+		if err != nil {
+			t.Fatalf("indexed file outside of %s: %s", testRoot, file.Path)
+		}
+		byPath[filepath.ToSlash(rel)] = file
+	}
+	return byPath
+}
+
+// sortedKeys returns the keys of the indexed files in order
+func sortedKeys(files map[string]models.FileInfo) []string {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// backends runs a test against a JSON and a DuckDB index
+func backends(t *testing.T, test func(t *testing.T, useDB bool)) {
+	for _, backend := range []struct {
+		name  string
+		useDB bool
+	}{{"json", false}, {"duckdb", true}} {
+		t.Run(backend.name, func(t *testing.T) { test(t, backend.useDB) })
+	}
+}
+
+func TestIndexDirectoryFilters(t *testing.T) {
+	files := map[string]string{
+		"a.txt":              "a",
+		"b.jpg":              "b",
+		"c.tmp":              "c",
+		"big.bin":            "0123456789",
+		".hidden":            "h",
+		".git/config":        "g",
+		"docs/guide.md":      "guide",
+		"docs/draft.tmp":     "draft",
+		"docs/deep/note.txt": "note",
+		"build/out.o":        "o",
+		"logs/app.log":       "log",
+		"logs/keep.log":      "keep",
+		".gitignore":         "build/\n*.log\n!keep.log\n",
+		"docs/.indexignore":  "deep/\n",
+	}
+	tests := []struct {
+		name string
+		opts ScanOptions
+		want []string
+	}{
+		{
+			name: "everything but hidden files",
+			want: []string{"a.txt", "b.jpg", "big.bin", "build/out.o", "c.tmp", "docs/deep/note.txt", "docs/draft.tmp",
+				"docs/guide.md", "logs/app.log", "logs/keep.log"},
+		},
+		{
+			name: "ignore files",
+			opts: ScanOptions{IgnoreFiles: true},
+			want: []string{"a.txt", "b.jpg", "big.bin", "c.tmp", "docs/draft.tmp", "docs/guide.md", "logs/keep.log"},
+		},
+		{
+			name: "excludes",
+			opts: ScanOptions{Excludes: []string{"*.tmp", "logs/**", "build"}},
+			want: []string{"a.txt", "b.jpg", "big.bin", "docs/deep/note.txt", "docs/guide.md"},
+		},
+		{
+			name: "includes",
+			opts: ScanOptions{Includes: []string{"*.txt", "docs/*.md"}},
+			want: []string{"a.txt", "docs/deep/note.txt", "docs/guide.md"},
+		},
+		{
+			name: "extensions",
+			opts: ScanOptions{Extensions: []string{"TXT", ".jpg"}},
+			want: []string{"a.txt", "b.jpg", "docs/deep/note.txt"},
+		},
+		{
+			name: "excluded extensions",
+			opts: ScanOptions{ExcludeExtensions: []string{"tmp", "log", "o"}},
+			want: []string{"a.txt", "b.jpg", "big.bin", "docs/deep/note.txt", "docs/guide.md"},
+		},
+		{
+			name: "sizes",
+			opts: ScanOptions{MinFileSize: 2, MaxFileSize: 5},
+			want: []string{"docs/draft.tmp", "docs/guide.md", "logs/app.log", "logs/keep.log", "docs/deep/note.txt"},
+		},
+		{
+			name: "depth",
+			opts: ScanOptions{MaxDepth: 1},
+			want: []string{"a.txt", "b.jpg", "big.bin", "c.tmp"},
+		},
+		{
+			name: "depth and ignore files",
+			opts: ScanOptions{MaxDepth: 2, IgnoreFiles: true},
+			want: []string{"a.txt", "b.jpg", "big.bin", "c.tmp", "docs/draft.tmp", "docs/guide.md", "logs/keep.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends(t, func(t *testing.T, useDB bool) {
+				idx := newTestIndexer(t, useDB, memFS(files))
+				if err := idx.IndexDirectory(context.Background(), testRoot, tt.opts); err != nil {
+					t.Fatalf("IndexDirectory: %v", err)
+				}
+				want := append([]string(nil), tt.want...)
+				sort.Strings(want)
+				if got := sortedKeys(indexedFiles(t, idx)); !slices.Equal(got, want) {
+					t.Errorf("indexed %v, want %v", got, want)
+				}
+			})
+		})
+	}
+}
+
+func TestIndexDirectoryRescan(t *testing.T) {
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		fsys := fstest.MapFS{
+			"kept.txt":    {Data: []byte("kept"), ModTime: testModTime},
+			"changed.txt": {Data: []byte("before"), ModTime: testModTime},
+			"removed.txt": {Data: []byte("removed"), ModTime: testModTime},
+		}
+		idx := newTestIndexer(t, useDB, source.FromFS(fsys, testRoot))
+		if err := idx.IndexDirectory(ctx, testRoot, ScanOptions{}); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		before := indexedFiles(t, idx)
+
+		delete(fsys, "removed.txt")
+		fsys["changed.txt"] = &fstest.MapFile{Data: []byte("after!"), ModTime: testModTime.Add(time.Hour)}
+		fsys["added.txt"] = &fstest.MapFile{Data: []byte("added"), ModTime: testModTime}
+		if err := idx.IndexDirectory(ctx, testRoot, ScanOptions{}); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		after := indexedFiles(t, idx)
+
+		if got, want := sortedKeys(after), []string{"added.txt", "changed.txt", "kept.txt"}; !slices.Equal(got, want) {
+			t.Fatalf("indexed %v after the rescan, want %v", got, want)
+		}
+		if after["kept.txt"].Checksum != before["kept.txt"].Checksum {
+			t.Errorf("the checksum of an unchanged file changed")
+		}
+		if after["changed.txt"].Checksum == before["changed.txt"].Checksum || after["changed.txt"].FileSize != 6 {
+			t.Errorf("a changed file kept its record: %+v", after["changed.txt"])
+		}
+	})
+}
+
+func TestSearchContent(t *testing.T) {
+	files := map[string]string{
+		"notes.txt":  "remember the needle\n",
+		"long.txt":   "a needle in a longer haystack of text\n",
+		"binary.bin": "\x00\x01needle\x02\x03",
+		"other.txt":  "nothing to see\n",
+	}
+	tests := []struct {
+		name string
+		opts ScanOptions
+		want []string
+	}{
+		{name: "without content", want: nil},
+		{name: "with content", opts: ScanOptions{Content: true}, want: []string{"long.txt", "notes.txt"}},
+		{name: "with content up to a size", opts: ScanOptions{Content: true, ContentMaxSize: 20}, want: []string{"notes.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends(t, func(t *testing.T, useDB bool) {
+				ctx := context.Background()
+				idx := newTestIndexer(t, useDB, memFS(files))
+				if err := idx.IndexDirectory(ctx, testRoot, tt.opts); err != nil {
+					t.Fatalf("IndexDirectory: %v", err)
+				}
+				files, err := idx.SearchContent(ctx, "NEEDLE", models.FileQuery{})
+				if err != nil {
+					t.Fatalf("SearchContent: %v", err)
+				}
+				var got []string
+				for _, file := range files {
+					got = append(got, file.Filename)
+					if file.Content != "" {
+						t.Errorf("search returned the content of %s", file.Filename)
+					}
+				}
+				sort.Strings(got)
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("found %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
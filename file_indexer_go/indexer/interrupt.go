@@ -0,0 +1,72 @@
+package indexer
+
+import (
+	"context"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// Interruption returns the last scan that was cancelled before it completed,
+// or nil if its root has been indexed completely since
+func (i *Indexer) Interruption(ctx context.Context) (*models.InterruptedScan, error) {
+	if !i.useDB {
+		return i.index.Interrupted, nil
+	}
+
+	root, ok, err := i.db.GetMetadata(ctx, "interrupted_root")
+	if err != nil || !ok {
+		return nil, err
+	}
+	scan := &models.InterruptedScan{Root: root}
+	value, _, err := i.db.GetMetadata(ctx, "interrupted_at")
+	if err != nil {
+		return nil, err
+	}
+	if at, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		scan.InterruptedAt = at
+	}
+	return scan, nil
+}
+
+// setInterruption stores the interrupted scan marker
+func (i *Indexer) setInterruption(ctx context.Context, scan models.InterruptedScan) error {
+	if !i.useDB {
+		i.index.Interrupted = &scan
+		return nil
+	}
+	if err := i.db.SetMetadata(ctx, "interrupted_root", scan.Root); err != nil {
+		return err
+	}
+	return i.db.SetMetadata(ctx, "interrupted_at", scan.InterruptedAt.Format(time.RFC3339Nano))
+}
+
+// recordInterruption marks the scan of rootPath as interrupted after its
+// context was cancelled and returns the cancellation error. The marker is
+// written even though the context is done.
+func (i *Indexer) recordInterruption(ctx context.Context, rootPath string) error {
+	scan := models.InterruptedScan{Root: absolutePath(rootPath), InterruptedAt: time.Now()}
+	i.logger.Warn("Scan interrupted; files stored so far are kept", "dir", scan.Root)
+	if err := i.setInterruption(context.WithoutCancel(ctx), scan); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// clearInterruption removes the interrupted scan marker once its root has
+// been indexed completely
+func (i *Indexer) clearInterruption(ctx context.Context, rootPath string) error {
+	scan, err := i.Interruption(ctx)
+	if err != nil || scan == nil || scan.Root != absolutePath(rootPath) {
+		return err
+	}
+	i.logger.Info("Completed previously interrupted scan", "dir", scan.Root)
+	if !i.useDB {
+		i.index.Interrupted = nil
+		return nil
+	}
+	if err := i.db.DeleteMetadata(ctx, "interrupted_root"); err != nil {
+		return err
+	}
+	return i.db.DeleteMetadata(ctx, "interrupted_at")
+}
@@ -0,0 +1,209 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// writeIndexJSON writes a JSON index one file record at a time, so memory
+// use does not grow with the size of the output. The fields other than the
+// files come first, then the files in path order.
+func writeIndexJSON(w io.Writer, index *models.Index, indent bool) error {
+	out := bufio.NewWriter(w)
+
+	header, err := indexHeader(index)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	newline, prefix, space := "", "", ""
+	if indent {
+		newline, prefix, space = "\n", "  ", " "
+	}
+
+	out.WriteString("{")
+	for _, key := range keys {
+		value := header[key]
+		if indent {
+			value, err = indentJSON(value, prefix)
+			if err != nil {
+				return err
+			}
+		}
+		name, _ := json.Marshal(key)
+		fmt.Fprintf(out, "%s%s%s:%s%s,", newline, prefix, name, space, value)
+	}
+	fmt.Fprintf(out, `%s%s"files":%s{`, newline, prefix, space)
+
+	paths := make([]string, 0, len(index.Files))
+	for path := range index.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for n, path := range paths {
+		var record []byte
+		if indent {
+			record, err = json.MarshalIndent(index.Files[path], prefix+prefix, "  ")
+		} else {
+			record, err = json.Marshal(index.Files[path])
+		}
+		if err != nil {
+			return fmt.Errorf("error marshaling %s: %v", path, err)
+		}
+		name, _ := json.Marshal(path)
+		if n > 0 {
+			out.WriteString(",")
+		}
+		fmt.Fprintf(out, "%s%s%s%s:%s%s", newline, prefix, prefix, name, space, record)
+	}
+	if len(paths) > 0 {
+		out.WriteString(newline + prefix)
+	}
+	out.WriteString("}" + newline + "}" + newline)
+	return out.Flush()
+}
+
+// indexHeader returns the encoded fields of an index other than its files
+func indexHeader(index *models.Index) (map[string]json.RawMessage, error) {
+	withoutFiles := *index
+	withoutFiles.Files = nil
+	data, err := json.Marshal(withoutFiles)
+	if err != nil {
+		return nil, err
+	}
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	delete(header, "files")
+	return header, nil
+}
+
+// indentJSON indents an encoded value that starts after prefix
+func indentJSON(value json.RawMessage, prefix string) (json.RawMessage, error) {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, value, prefix, "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
+
+// readIndexJSON reads a JSON index written by writeIndexJSON or by earlier
+// versions, in any field order. The fields other than the files are stored
+// in index and each file record is passed to visit as soon as it is read,
+// so the files are never all held in memory by the reader itself.
+func readIndexJSON(r io.Reader, index *models.Index, visit func(path string, file models.FileInfo) error) error {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	header := make(map[string]json.RawMessage)
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key != "files" {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return err
+			}
+			header[key.(string)] = value
+			continue
+		}
+
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if token == nil {
+			continue // "files": null
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '{' {
+			return fmt.Errorf("files is not an object")
+		}
+		for decoder.More() {
+			path, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			var file models.FileInfo
+			if err := decoder.Decode(&file); err != nil {
+				return fmt.Errorf("error decoding %v: %v", path, err)
+			}
+			if err := visit(path.(string), file); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, '}'); err != nil {
+			return err
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return err
+	}
+
+	// The header is small, so it is decoded by the standard rules
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, index)
+}
+
+// readIndexHeader reads the fields of a JSON index that precede its files,
+// which are all of them in indexes written by writeIndexJSON, without reading
+// the files
+func readIndexHeader(r io.Reader, index *models.Index) error {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	header := make(map[string]json.RawMessage)
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key == "files" {
+			break
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		header[key.(string)] = value
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, index)
+}
+
+// expectDelim reads the next token and fails unless it is the delimiter
+func expectDelim(decoder *json.Decoder, want json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %v, got %v", want, token)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package indexer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// lockIndex takes the lock that keeps other processes from opening a JSON
+// index for writing while this one has it open, as the last of two
+// processes saving the index would silently drop the changes of the other.
+// The lock is held on a .lock file next to the index until CloseDatabase.
+// DuckDB locks its files itself, and PostgreSQL indexes are shared by
+// design. Indexes in directories that cannot be written are not locked, as
+// they cannot be saved either.
+func (i *Indexer) lockIndex() error {
+	lockPath := i.indexPath + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		i.logger.Debug("Not locking index", "path", i.indexPath, "err", err)
+		return nil
+	}
+
+	locked, err := lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return fmt.Errorf("error locking index: %v", err)
+	}
+	if !locked {
+		owner, _ := io.ReadAll(lock)
+		lock.Close()
+		return fmt.Errorf("index %s is in use by another process (pid %s); open it read-only to query it meanwhile", i.indexPath, strings.TrimSpace(string(owner)))
+	}
+
+	// The process ID tells others who holds the lock
+	if err := lock.Truncate(0); err == nil {
+		fmt.Fprintf(lock, "%d\n", os.Getpid())
+	}
+	i.lock = lock
+	return nil
+}
+
+// unlockIndex releases the lock taken by lockIndex
+func (i *Indexer) unlockIndex() error {
+	if i.lock == nil {
+		return nil
+	}
+	err := i.lock.Close()
+	i.lock = nil
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package indexer
+
+import "os"
+
+// lockFile does not lock on platforms without flock
+func lockFile(file *os.File) (bool, error) {
+	return true, nil
+}
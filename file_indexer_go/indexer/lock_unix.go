@@ -0,0 +1,20 @@
+//go:build unix
+
+package indexer
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on a file without waiting, reporting
+// whether it was free. The lock is released when the file is closed, also
+// when the process dies.
+func lockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
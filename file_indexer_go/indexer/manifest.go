@@ -0,0 +1,292 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// ManifestOptions controls a verification against a checksum manifest
+type ManifestOptions struct {
+	Algorithm string // Algorithm of the manifest's checksums
+	Base      string // Directory relative paths of the manifest are resolved against (empty = current directory)
+	Index     bool   // Compare with the checksums stored in the index instead of re-reading the files
+	Workers   int    // Number of concurrent checksum workers
+}
+
+// VerifyManifest checks the files of a checksum manifest, such as one
+// written by sha256sum, by re-hashing them or, with opts.Index, against the
+// checksums of the manifest's algorithm stored in the index. The File
+// of each result has the resolved path and the manifest's checksum. Results
+// are passed to report one at a time, in no particular order. If ctx is
+// cancelled, no further files are checked and ctx's error is returned.
+func (i *Indexer) VerifyManifest(ctx context.Context, entries []hasher.ManifestEntry, opts ManifestOptions, report func(VerifyResult)) error {
+	h, err := hasher.Get(opts.Algorithm)
+	if err != nil {
+		return err
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	var indexed map[string]models.FileInfo
+	if opts.Index {
+		files, err := i.ListFiles(ctx, models.FileQuery{})
+		if err != nil {
+			return err
+		}
+		if files, err = i.ChecksumsOf(ctx, files, h.Name()); err != nil {
+			return err
+		}
+		indexed = make(map[string]models.FileInfo, len(files))
+		for _, file := range files {
+			if i.isLocal(file) {
+				indexed[file.Path] = file
+			}
+		}
+	}
+
+	jobs := make(chan models.FileInfo)
+	results := make(chan VerifyResult)
+	var wg sync.WaitGroup
+	for n := 0; n < opts.Workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for expected := range jobs {
+				if opts.Index {
+					results <- checkIndexed(expected, indexed)
+				} else {
+					results <- i.checkManifestFile(h, expected)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				break
+			}
+			path := filepath.FromSlash(entry.Path)
+			if !filepath.IsAbs(path) && opts.Base != "" {
+				path = filepath.Join(opts.Base, path)
+			}
+			jobs <- models.FileInfo{Path: filepath.Clean(absolutePath(path)), Checksum: entry.Checksum}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		report(result)
+	}
+	return ctx.Err()
+}
+
+// ChecksumsOf returns files with Checksum set to their checksum of an
+// algorithm, which is the index's own or one a scan stored besides it with
+// ScanOptions.ExtraHashes. Files without a checksum of that algorithm get an
+// empty one.
+func (i *Indexer) ChecksumsOf(ctx context.Context, files []models.FileInfo, algorithm string) ([]models.FileInfo, error) {
+	h, err := hasher.Get(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := i.HashAlgorithm(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if h.Name() == primary {
+		return files, nil
+	}
+
+	var stored map[string]map[string]string
+	if i.useDB {
+		if stored, err = i.db.FileChecksums(ctx); err != nil {
+			return nil, err
+		}
+	}
+	result := make([]models.FileInfo, len(files))
+	for n, file := range files {
+		checksums := file.Checksums
+		if i.useDB {
+			checksums = stored[file.Location()]
+		}
+		file.Checksum = checksums[h.Name()]
+		result[n] = file
+	}
+	return result, nil
+}
+
+// checkManifestFile re-hashes a file of a manifest and compares its checksum
+func (i *Indexer) checkManifestFile(h hasher.Hasher, expected models.FileInfo) VerifyResult {
+	result := VerifyResult{File: expected}
+	if _, err := i.fsys.Stat(expected.Path); errors.Is(err, fs.ErrNotExist) {
+		result.Status = VerifyMissing
+		return result
+	} else if err != nil {
+		result.Status, result.Err = VerifyFailed, err
+		return result
+	}
+
+	checksum, err := hasher.HashFile(h, i.fsys, expected.Path)
+	switch {
+	case err != nil:
+		result.Status, result.Err = VerifyFailed, err
+	case checksum == expected.Checksum:
+		result.Status = VerifyOK
+	default:
+		result.Status = VerifyMismatch
+	}
+	return result
+}
+
+// checkIndexed compares the checksum of a manifest with the one in the index
+func checkIndexed(expected models.FileInfo, indexed map[string]models.FileInfo) VerifyResult {
+	result := VerifyResult{File: expected}
+	file, ok := indexed[expected.Path]
+	switch {
+	case !ok:
+		result.Status = VerifyMissing
+	case file.Checksum == "":
+		result.Status = VerifySkipped
+	case file.Checksum == expected.Checksum:
+		result.Status = VerifyOK
+	default:
+		result.Status = VerifyMismatch
+	}
+	return result
+}
+
+// ChecksumImportOptions controls seeding an index from a checksum manifest
+type ChecksumImportOptions struct {
+	Algorithm     string  // Algorithm of the manifest's checksums
+	Base          string  // Directory relative paths of the manifest are resolved against (empty = current directory)
+	VerifyPercent float64 // Percentage of the files to re-hash before trusting the manifest (0 = none)
+	Seed          int64   // Selects which files VerifyPercent samples
+}
+
+// ChecksumImportResult counts the outcome of ImportChecksums
+type ChecksumImportResult struct {
+	Imported   int      // files recorded with the manifest's checksum
+	Indexed    int      // files that already had a checksum in the index and were left alone
+	Missing    int      // files of the manifest that are missing or not regular files
+	Verified   int      // sampled files whose checksum matched
+	Mismatched []string // sampled files whose checksum did not match
+}
+
+// ImportChecksums seeds the index with the checksums of a manifest, such as
+// one written by sha256sum, so the next scan of their directories trusts them
+// instead of reading the files: each file is only stat'ed and recorded with
+// its current size and modification time. Files already indexed with a
+// checksum are left alone. The index must be empty or use the manifest's
+// algorithm. With opts.VerifyPercent, a sample of the files is re-hashed
+// first and nothing is imported if any of them does not match.
+func (i *Indexer) ImportChecksums(ctx context.Context, entries []hasher.ManifestEntry, opts ChecksumImportOptions) (ChecksumImportResult, error) {
+	var result ChecksumImportResult
+	h, err := hasher.Get(opts.Algorithm)
+	if err != nil {
+		return result, err
+	}
+	stored, hasFiles, err := i.storedHashAlgorithm(ctx)
+	if err != nil {
+		return result, err
+	}
+	if hasFiles && stored != h.Name() {
+		return result, fmt.Errorf("the manifest has %s checksums but the index uses %s", h.Name(), stored)
+	}
+	indexed, err := i.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return result, err
+	}
+	byPath := make(map[string]models.FileInfo, len(indexed))
+	for _, file := range indexed {
+		if i.isLocal(file) {
+			byPath[file.Path] = file
+		}
+	}
+
+	var files []models.FileInfo
+	sample := 0
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		path := filepath.FromSlash(entry.Path)
+		if !filepath.IsAbs(path) && opts.Base != "" {
+			path = filepath.Join(opts.Base, path)
+		}
+		path = filepath.Clean(absolutePath(path))
+
+		info, err := i.fsys.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			i.logger.Debug("Skipping file of the manifest", "path", path, "err", err)
+			result.Missing++
+			continue
+		}
+		existing, ok := byPath[path]
+		if existing.Checksum != "" {
+			result.Indexed++
+			continue
+		}
+
+		if opts.VerifyPercent > 0 && sampled(path, opts.VerifyPercent, opts.Seed) {
+			sample++
+			checksum, err := hasher.HashFile(h, i.fsys, path)
+			if err != nil {
+				return result, fmt.Errorf("error verifying %s: %v", path, err)
+			}
+			if checksum != entry.Checksum {
+				result.Mismatched = append(result.Mismatched, path)
+				continue
+			}
+			result.Verified++
+		}
+
+		file := models.FileInfo{Path: path, Filename: filepath.Base(path), Host: i.host}
+		if ok {
+			file = existing
+		}
+		file.Checksum = entry.Checksum
+		file.FileSize = info.Size()
+		file.ModificationDateTime = info.ModTime()
+		file.IndexedAt = time.Now()
+		files = append(files, file)
+	}
+	if len(result.Mismatched) > 0 {
+		return result, fmt.Errorf("%d of %d sampled files do not match the manifest; nothing was imported", len(result.Mismatched), sample)
+	}
+
+	if len(files) > 0 {
+		if err := i.storeFiles(ctx, files); err != nil {
+			return result, err
+		}
+		if !hasFiles {
+			if err := i.recordHashAlgorithm(ctx, h); err != nil {
+				return result, err
+			}
+		}
+	}
+	result.Imported = len(files)
+	i.logger.Info("Imported checksums", "algorithm", h.Name(), "files", result.Imported)
+	return result, nil
+}
+
+// recordHashAlgorithm records the checksum algorithm of an index
+func (i *Indexer) recordHashAlgorithm(ctx context.Context, h hasher.Hasher) error {
+	i.hasher = h
+	if i.useDB {
+		return i.db.SetMetadata(ctx, "hash_algorithm", h.Name())
+	}
+	i.index.HashAlgorithm = h.Name()
+	return nil
+}
@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"fmt"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// MergeSource is an index to be merged with others
+type MergeSource struct {
+	Host  string // stored for the files and actions of the index that have no host
+	Index *models.Index
+}
+
+// MergeResult is a merged index and how the files of its sources were combined
+type MergeResult struct {
+	Index      *models.Index
+	Files      int // files read from all sources
+	Superseded int // files dropped for a more recently indexed record of the same host and path
+}
+
+// MergeIndexes unions the files, roots, audit logs and tags of several indexes.
+// Files without a host are tagged with their source's host, so they are
+// never mistaken for files of this machine, and are keyed by their location,
+// so the same path of several hosts is kept for each. When several indexes
+// hold the same path of a host, the most recently indexed record wins, and
+// on ties the one of the later source. All sources must use the same checksum algorithm, as
+// their checksums could not be compared otherwise.
+func MergeIndexes(sources []MergeSource) (MergeResult, error) {
+	merged := &models.Index{Files: make(map[string]models.FileInfo), Roots: make(map[string]models.RootInfo)}
+	result := MergeResult{Index: merged}
+	algorithmHost := ""
+	tagged := make(map[models.FileTag]bool)
+	for _, source := range sources {
+		index := source.Index
+
+		// Indexes that predate the choice of algorithm use the default one
+		algorithm := index.HashAlgorithm
+		if algorithm == "" {
+			algorithm = hasher.Default
+		}
+		if merged.HashAlgorithm == "" {
+			merged.HashAlgorithm, algorithmHost = algorithm, source.Host
+		} else if algorithm != merged.HashAlgorithm {
+			return MergeResult{}, fmt.Errorf("%s uses the %s checksum algorithm, but %s uses %s",
+				source.Host, algorithm, algorithmHost, merged.HashAlgorithm)
+		}
+		// The sampled hash follows from the checksum algorithm
+		if index.SampleHashAlgorithm != "" {
+			merged.SampleHashAlgorithm = index.SampleHashAlgorithm
+		}
+		if !index.Indexed.Before(merged.Indexed) {
+			merged.Indexed, merged.RootPath = index.Indexed, index.RootPath
+		}
+
+		for _, file := range index.Files {
+			result.Files++
+			if file.Host == "" {
+				file.Host = source.Host
+			}
+			if stored, ok := merged.Files[file.Location()]; ok {
+				result.Superseded++
+				if file.IndexedAt.Before(stored.IndexedAt) {
+					continue
+				}
+			}
+			merged.Files[file.Location()] = file
+		}
+		for path, root := range index.Roots {
+			if stored, ok := merged.Roots[path]; !ok || !root.IndexedAt.Before(stored.IndexedAt) {
+				merged.Roots[path] = root
+			}
+		}
+		for _, record := range index.Actions {
+			if record.Host == "" {
+				record.Host = source.Host
+			}
+			merged.Actions = append(merged.Actions, record)
+		}
+		for _, deleted := range index.Deletions {
+			if deleted.Host == "" {
+				deleted.Host = source.Host
+			}
+			merged.Deletions = append(merged.Deletions, deleted)
+		}
+		for _, tag := range index.Tags {
+			key := models.FileTag{Checksum: tag.Checksum, Tag: tag.Tag}
+			if !tagged[key] {
+				tagged[key] = true
+				merged.Tags = append(merged.Tags, tag)
+			}
+		}
+	}
+	return result, nil
+}
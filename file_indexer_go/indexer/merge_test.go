@@ -0,0 +1,155 @@
+package indexer
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// mergeIndex returns an index of files, given as path=checksum, indexed at
+// the time and stored with host unless it is empty
+func mergeIndex(host string, indexedAt time.Time, files ...string) *models.Index {
+	index := &models.Index{Files: make(map[string]models.FileInfo), HashAlgorithm: "md5"}
+	for _, file := range files {
+		path, checksum, _ := strings.Cut(file, "=")
+		index.Files[path] = models.FileInfo{Path: path, Filename: path[strings.LastIndex(path, "/")+1:], Checksum: checksum,
+			Host: host, IndexedAt: indexedAt, ModificationDateTime: indexedAt, FileSize: int64(len(checksum)),
+			Content: "content of " + path}
+	}
+	return index
+}
+
+// mergedFiles returns the merged files as location=checksum, in order
+func mergedFiles(files map[string]models.FileInfo) []string {
+	var merged []string
+	for _, file := range files {
+		merged = append(merged, file.Location()+"="+file.Checksum)
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+func TestMergeIndexes(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	tests := []struct {
+		name           string
+		sources        []MergeSource
+		wantFiles      []string
+		wantSuperseded int
+	}{
+		{
+			name: "same path of several hosts",
+			sources: []MergeSource{
+				{Host: "laptop", Index: mergeIndex("laptop", older, "/tmp/m/d/x.txt=aaa")},
+				{Host: "desktop", Index: mergeIndex("desktop", older, "/tmp/m/d/x.txt=bbb")},
+			},
+			wantFiles: []string{"desktop:/tmp/m/d/x.txt=bbb", "laptop:/tmp/m/d/x.txt=aaa"},
+		},
+		{
+			name: "files without a host take the source's",
+			sources: []MergeSource{
+				{Host: "laptop", Index: mergeIndex("", older, "/data/a=aaa", "/data/b=bbb")},
+				{Host: "nas", Index: mergeIndex("", older, "/data/a=ccc")},
+			},
+			wantFiles: []string{"laptop:/data/a=aaa", "laptop:/data/b=bbb", "nas:/data/a=ccc"},
+		},
+		{
+			name: "same path of a host, the newer record wins",
+			sources: []MergeSource{
+				{Host: "laptop", Index: mergeIndex("laptop", newer, "/data/a=new")},
+				{Host: "backup", Index: mergeIndex("laptop", older, "/data/a=old", "/data/b=bbb")},
+			},
+			wantFiles:      []string{"laptop:/data/a=new", "laptop:/data/b=bbb"},
+			wantSuperseded: 1,
+		},
+		{
+			name: "same path of a host indexed at the same time, the later source wins",
+			sources: []MergeSource{
+				{Host: "first", Index: mergeIndex("laptop", older, "/data/a=first")},
+				{Host: "second", Index: mergeIndex("laptop", older, "/data/a=second")},
+			},
+			wantFiles:      []string{"laptop:/data/a=second"},
+			wantSuperseded: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := MergeIndexes(tt.sources)
+			if err != nil {
+				t.Fatalf("MergeIndexes: %v", err)
+			}
+			if got := mergedFiles(result.Index.Files); !slices.Equal(got, tt.wantFiles) {
+				t.Errorf("merged %v, want %v", got, tt.wantFiles)
+			}
+			if result.Superseded != tt.wantSuperseded {
+				t.Errorf("superseded %d, want %d", result.Superseded, tt.wantSuperseded)
+			}
+		})
+	}
+}
+
+func TestMergeIndexesAlgorithms(t *testing.T) {
+	md5 := mergeIndex("laptop", time.Now(), "/a=aaa")
+	sha := mergeIndex("desktop", time.Now(), "/a=bbb")
+	sha.HashAlgorithm = "sha256"
+	if _, err := MergeIndexes([]MergeSource{{Host: "laptop", Index: md5}, {Host: "desktop", Index: sha}}); err == nil {
+		t.Errorf("merged indexes of different checksum algorithms")
+	}
+
+	// Indexes that predate the choice of algorithm use the default one
+	old := mergeIndex("nas", time.Now(), "/b=ccc")
+	old.HashAlgorithm = ""
+	result, err := MergeIndexes([]MergeSource{{Host: "laptop", Index: md5}, {Host: "nas", Index: old}})
+	if err != nil {
+		t.Fatalf("MergeIndexes: %v", err)
+	}
+	if result.Index.HashAlgorithm != "md5" {
+		t.Errorf("merged algorithm %q, want md5", result.Index.HashAlgorithm)
+	}
+}
+
+// TestMergeIntoIndex stores a merge of the same path of two hosts, as the
+// merge command does, and reads it back
+func TestMergeIntoIndex(t *testing.T) {
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		indexedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		result, err := MergeIndexes([]MergeSource{
+			{Host: "laptop", Index: mergeIndex("laptop", indexedAt, "/tmp/m/d/x.txt=aaa")},
+			{Host: testHost, Index: mergeIndex(testHost, indexedAt, "/tmp/m/d/x.txt=bbb", "/tmp/m/d/y.txt=ccc")},
+		})
+		if err != nil {
+			t.Fatalf("MergeIndexes: %v", err)
+		}
+
+		target := newTestIndexer(t, useDB, nil)
+		if err := target.ImportIndex(ctx, result.Index); err != nil {
+			t.Fatalf("ImportIndex: %v", err)
+		}
+		exported, err := target.ExportIndex(ctx)
+		if err != nil {
+			t.Fatalf("ExportIndex: %v", err)
+		}
+		want := []string{"laptop:/tmp/m/d/x.txt=aaa", testHost + ":/tmp/m/d/x.txt=bbb", testHost + ":/tmp/m/d/y.txt=ccc"}
+		sort.Strings(want)
+		if got := mergedFiles(exported.Files); !slices.Equal(got, want) {
+			t.Fatalf("stored %v, want %v", got, want)
+		}
+		for key, file := range exported.Files {
+			if file.Content != "content of "+file.Path {
+				t.Errorf("%s has content %q", file.Location(), file.Content)
+			}
+			// The files of this host keep their path, those of others are
+			// told apart by their host
+			if want := file.DisplayPath(testHost); key != want {
+				t.Errorf("%s is keyed %q, want %q", file.Location(), key, want)
+			}
+		}
+	})
+}
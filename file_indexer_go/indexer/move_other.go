@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package indexer
+
+import "errors"
+
+// errCrossDevice is not reported by renames on other platforms
+var errCrossDevice = errors.New("cross-device link")
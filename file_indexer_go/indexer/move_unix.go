@@ -0,0 +1,8 @@
+//go:build unix
+
+package indexer
+
+import "syscall"
+
+// errCrossDevice is the error with which renames to other filesystems fail
+var errCrossDevice error = syscall.EXDEV
@@ -0,0 +1,6 @@
+package indexer
+
+import "golang.org/x/sys/windows"
+
+// errCrossDevice is the error with which renames to other volumes fail
+var errCrossDevice error = windows.ERROR_NOT_SAME_DEVICE
@@ -0,0 +1,244 @@
+package indexer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/filter"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// OriginalRule is one criterion for choosing the original of a duplicate group
+type OriginalRule string
+
+const (
+	OriginalOldest       OriginalRule = "oldest"        // Oldest modification time
+	OriginalShortest     OriginalRule = "shortest"      // Shortest path
+	OriginalPrefix       OriginalRule = "prefix"        // Path below a preferred prefix, earlier prefixes first
+	OriginalFirstIndexed OriginalRule = "first-indexed" // Earliest indexed_at
+	OriginalPath         OriginalRule = "path"          // Lexicographically smallest path
+)
+
+// OriginalPolicy orders the files of a duplicate group so that the chosen
+// original comes first. Rules are applied in order, each breaking the ties
+// of the previous ones; the path always breaks the remaining ties, so the
+// selection is deterministic. The zero value selects by path alone.
+type OriginalPolicy struct {
+	Rules    []OriginalRule
+	Prefixes []string // Preferred path prefixes for the prefix rule
+
+	// Keep and Protect list the path prefixes of keep rules, whose files are
+	// never deleted, moved or linked. Protected files are never chosen as
+	// originals either, so no other file is linked to them.
+	Keep    []string
+	Protect []string
+
+	// ProtectPatterns protects the files whose absolute paths match one of
+	// these glob patterns, such as **/*.photoslibrary/**, like Protect
+	ProtectPatterns filter.Set
+}
+
+// ParseOriginalPolicy builds a policy from a comma-separated list of rules.
+// Preferred prefixes imply the prefix rule, which is tried first unless the
+// list places it elsewhere.
+func ParseOriginalPolicy(spec string, prefixes []string) (OriginalPolicy, error) {
+	policy := OriginalPolicy{}
+	for _, prefix := range prefixes {
+		policy.Prefixes = append(policy.Prefixes, absolutePath(prefix))
+	}
+
+	hasPrefix := false
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		switch rule := OriginalRule(name); rule {
+		case OriginalOldest, OriginalShortest, OriginalFirstIndexed, OriginalPath:
+			policy.Rules = append(policy.Rules, rule)
+		case OriginalPrefix:
+			hasPrefix = true
+			policy.Rules = append(policy.Rules, rule)
+		default:
+			return OriginalPolicy{}, fmt.Errorf("unknown original rule %q (supported: oldest, shortest, prefix, first-indexed, path)", name)
+		}
+	}
+
+	if hasPrefix && len(policy.Prefixes) == 0 {
+		return OriginalPolicy{}, fmt.Errorf("the prefix rule requires at least one preferred prefix")
+	}
+	if !hasPrefix && len(policy.Prefixes) > 0 {
+		policy.Rules = append([]OriginalRule{OriginalPrefix}, policy.Rules...)
+	}
+	return policy, nil
+}
+
+// Apply reorders the files of a group so that the original comes first.
+// Protected files come last.
+func (p OriginalPolicy) Apply(group *models.DuplicateGroup) {
+	files := group.Files
+	sort.SliceStable(files, func(a, b int) bool {
+		if protectedA, protectedB := p.protectRule(files[a].Path) != "", p.protectRule(files[b].Path) != ""; protectedA != protectedB {
+			return protectedB
+		}
+		for _, rule := range p.Rules {
+			if less, decided := p.compare(rule, files[a], files[b]); decided {
+				return less
+			}
+		}
+		return files[a].Path < files[b].Path
+	})
+}
+
+// compare reports whether a ranks before b under a rule, and whether the rule
+// tells them apart at all
+func (p OriginalPolicy) compare(rule OriginalRule, a, b models.FileInfo) (bool, bool) {
+	switch rule {
+	case OriginalOldest:
+		if !a.ModificationDateTime.Equal(b.ModificationDateTime) {
+			return a.ModificationDateTime.Before(b.ModificationDateTime), true
+		}
+	case OriginalShortest:
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) < len(b.Path), true
+		}
+	case OriginalPrefix:
+		if rankA, rankB := p.prefixRank(a.Path), p.prefixRank(b.Path); rankA != rankB {
+			return rankA < rankB, true
+		}
+	case OriginalFirstIndexed:
+		if !a.IndexedAt.Equal(b.IndexedAt) {
+			return a.IndexedAt.Before(b.IndexedAt), true
+		}
+	case OriginalPath:
+		if a.Path != b.Path {
+			return a.Path < b.Path, true
+		}
+	}
+	return false, false
+}
+
+// prefixRank returns the position of the first preferred prefix a path lies
+// below, or the number of prefixes if it matches none
+func (p OriginalPolicy) prefixRank(path string) int {
+	if rank := belowAny(path, p.Prefixes); rank >= 0 {
+		return rank
+	}
+	return len(p.Prefixes)
+}
+
+// KeepRule returns the keep rule that keeps a file from being deleted, moved
+// or linked, such as "protect /photos", or "" if no rule keeps it
+func (p OriginalPolicy) KeepRule(file models.FileInfo) string {
+	if rule := p.protectRule(file.Path); rule != "" {
+		return rule
+	}
+	if n := belowAny(file.Path, p.Keep); n >= 0 {
+		return "keep " + p.Keep[n]
+	}
+	return ""
+}
+
+// protectRule returns the protect rule that matches a path, or ""
+func (p OriginalPolicy) protectRule(path string) string {
+	if n := belowAny(path, p.Protect); n >= 0 {
+		return "protect " + p.Protect[n]
+	}
+	for _, pattern := range p.ProtectPatterns {
+		if pattern.Match(path, path, false) {
+			return "protect " + pattern.String()
+		}
+	}
+	return ""
+}
+
+// AddProtect adds a protect rule: a glob pattern if arg contains *, ? or [,
+// and a path prefix otherwise
+func (p *OriginalPolicy) AddProtect(arg string) error {
+	if !strings.ContainsAny(arg, "*?[") {
+		p.Protect = append(p.Protect, filepath.Clean(absolutePath(arg)))
+		return nil
+	}
+	pattern, err := filter.Compile(arg)
+	if err != nil {
+		return err
+	}
+	p.ProtectPatterns = append(p.ProtectPatterns, pattern)
+	return nil
+}
+
+// belowAny returns the position of the first prefix a path is at or below,
+// or -1 if there is none
+func belowAny(path string, prefixes []string) int {
+	for n, prefix := range prefixes {
+		dir := strings.TrimSuffix(prefix, string(filepath.Separator)) + string(filepath.Separator)
+		if path == prefix || strings.HasPrefix(path, dir) {
+			return n
+		}
+	}
+	return -1
+}
+
+// LoadKeepRules reads a policy from a rules file. Each line holds one rule,
+// in priority order:
+//
+//	keep PATH       always keep the files below PATH, preferring them as originals
+//	protect PATH    never touch the files below PATH, nor link other files to them
+//	protect GLOB    never touch the files matching a glob pattern
+//	prefer PATH     prefer the files below PATH as originals
+//	prefer RULE     choose originals by oldest, shortest, first-indexed or path
+//
+// Empty lines and lines starting with # are ignored. The prefixes of keep and
+// prefer lines are ranked in the order they are listed.
+func LoadKeepRules(path string) (OriginalPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return OriginalPolicy{}, fmt.Errorf("error reading rules: %v", err)
+	}
+
+	policy := OriginalPolicy{}
+	prefer := func(prefix string) {
+		if len(policy.Prefixes) == 0 {
+			policy.Rules = append(policy.Rules, OriginalPrefix)
+		}
+		policy.Prefixes = append(policy.Prefixes, prefix)
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		directive, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			return OriginalPolicy{}, fmt.Errorf("%s:%d: %s needs a path", path, n+1, directive)
+		}
+
+		switch directive {
+		case "keep":
+			prefix := filepath.Clean(absolutePath(arg))
+			policy.Keep = append(policy.Keep, prefix)
+			prefer(prefix)
+		case "protect":
+			if err := policy.AddProtect(arg); err != nil {
+				return OriginalPolicy{}, fmt.Errorf("%s:%d: %v", path, n+1, err)
+			}
+		case "prefer":
+			switch rule := OriginalRule(arg); rule {
+			case OriginalOldest, OriginalShortest, OriginalFirstIndexed, OriginalPath:
+				policy.Rules = append(policy.Rules, rule)
+			default:
+				if !filepath.IsAbs(arg) && !strings.HasPrefix(arg, ".") {
+					return OriginalPolicy{}, fmt.Errorf("%s:%d: prefer needs a path or one of oldest, shortest, first-indexed, path", path, n+1)
+				}
+				prefer(filepath.Clean(absolutePath(arg)))
+			}
+		default:
+			return OriginalPolicy{}, fmt.Errorf("%s:%d: unknown rule %q (supported: keep, protect, prefer)", path, n+1, directive)
+		}
+	}
+	return policy, nil
+}
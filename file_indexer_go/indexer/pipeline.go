@@ -0,0 +1,718 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/codestats"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/fsmeta"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/media"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/secrets"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// scanJob is a file discovered by the walker that still needs to be hashed
+type scanJob struct {
+	path      string
+	info      fs.FileInfo
+	quickHash bool
+	sampled   bool // hash the file by sampling instead of reading it completely
+	deferHash bool // leave the checksum of a new or changed file to a later Rehash
+	noRead    bool // do not open the file at all, guessing its MIME type from the extension
+	content   bool // store the file's content if it turns out to be text
+	exif      bool // extract EXIF metadata if the file is a photo
+	media     bool // extract media metadata if the file is audio or video
+	imageHash bool // compute the perceptual hash of the file if it is an image
+	textHash  bool // compute the simhash of the file if it turns out to be text
+	code      bool // record the language and line counts of the file if it is source code
+	xattrs    bool // record the file's extended attributes
+
+	secrets *secrets.Scanner // scans the stored content for secrets, nil if it is not scanned
+
+	linkTarget string // target of a recorded symlink, which is not hashed
+	label      string // label of the scan, stored with the file
+
+	stored  *models.FileInfo // record of an unchanged file whose checksums are kept
+	retries int              // retries of reads failing with transient errors
+
+	bucket       string           // bucket of an S3 object, which is not read from the filesystem
+	object       *source.S3Object // the listed object if bucket is set
+	downloadHash bool             // hash the object even if its ETag is a usable checksum
+
+	archive     bool  // also index the entries of the file if it is a zip or tar archive
+	streams     bool  // also index the alternate data streams of the file
+	maxFileSize int64 // size limit of archive entries and streams
+
+	throttle *throttle // limits the reads of the scan, nil if it is not throttled
+}
+
+// newScanJob creates the job for an accepted file, reading the target of
+// recorded symlinks
+func (i *Indexer) newScanJob(path string, info fs.FileInfo, opts ScanOptions) scanJob {
+	job := scanJob{
+		path:      path,
+		info:      info,
+		quickHash: opts.QuickHash,
+		deferHash: opts.DeferChecksums,
+		noRead:    opts.NoChecksum,
+		sampled:   opts.SampleHash > 0 && info.Size() > opts.SampleHash && info.Size() > hasher.SampleHashRegions*hasher.SampleHashChunk,
+		content:   opts.Content && (opts.ContentMaxSize <= 0 || info.Size() <= opts.ContentMaxSize),
+		exif:      opts.EXIF,
+		media:     opts.Media,
+		imageHash: opts.ImageHash,
+		label:     opts.Label,
+		textHash:  opts.TextHash && (opts.TextHashLimit <= 0 || info.Size() <= opts.TextHashLimit),
+		code:      opts.CodeMetrics,
+		xattrs:    opts.XAttrs,
+		secrets:   opts.Secrets,
+		retries:   opts.Retries,
+	}
+	if opts.ScanArchives && info.Mode().IsRegular() && archiveFormat(path) != "" {
+		job.archive = true
+		job.maxFileSize = opts.MaxFileSize
+	}
+	if opts.Streams && info.Mode().IsRegular() && i.fsys == source.OS {
+		job.streams = true
+		job.maxFileSize = opts.MaxFileSize
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := i.fsys.ReadLink(path)
+		if err != nil {
+			i.logger.Warn("Error reading symlink", "path", path, "err", err)
+		}
+		job.linkTarget = target
+	}
+	return job
+}
+
+// scanDirectory walks rootPath and hashes the discovered files with a pool of
+// workers. Results are handed to store from a single goroutine, so store does
+// not need to be safe for concurrent use. It returns the paths whose files
+// must not be pruned: those that could not be read during the walk, and the
+// directories it left out because of the depth limit or another filesystem.
+func (i *Indexer) scanDirectory(ctx context.Context, rootPath string, opts ScanOptions, store func(models.FileInfo) error) ([]string, error) {
+	walkFilter, err := newWalkFilter(i.fsys, rootPath, opts, i.logger)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Paranoid {
+		if walkFilter.previous, err = i.filesBelow(ctx, absolutePath(rootPath)); err != nil {
+			return nil, err
+		}
+		walkFilter.tolerance = opts.MtimeTolerance
+	}
+	err = i.scanWithFilter(ctx, rootPath, walkFilter, opts, store)
+	return append(walkFilter.unreadable, walkFilter.kept...), err
+}
+
+// scanWithFilter walks walkRoot, which may be a subdirectory of the filter's
+// root, and indexes the files accepted by the filter. The walk stops when ctx
+// is cancelled; files already discovered are still stored.
+func (i *Indexer) scanWithFilter(ctx context.Context, walkRoot string, walkFilter *walkFilter, opts ScanOptions, store func(models.FileInfo) error) error {
+	return i.runPipeline(ctx, opts, store, func(emit func(scanJob)) error {
+		var visit fs.WalkDirFunc
+		visit = func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				i.logger.Warn("Error accessing path", "path", path, "err", err)
+				walkFilter.unreadable = append(walkFilter.unreadable, absolutePath(path))
+				return nil // Continue with other files
+			}
+
+			// WalkDir does not follow symlinks, so linked directories are
+			// walked separately. The trailing separator makes WalkDir treat
+			// the link as the directory it points to.
+			d, followed := walkFilter.followSymlink(path, d)
+			if followed && d.IsDir() {
+				return source.WalkDir(i.fsys, path+string(filepath.Separator), visit)
+			}
+
+			// Prune excluded and hidden directories, descend into the rest
+			if d.IsDir() {
+				if walkFilter.shouldSkipDir(path, d) {
+					i.logger.Debug("Skipping directory", "path", path)
+					return fs.SkipDir
+				}
+				if walkFilter.outOfReach(path, d) {
+					i.logger.Debug("Skipping directory below the depth limit or on another filesystem", "path", path)
+					walkFilter.kept = append(walkFilter.kept, absolutePath(path))
+					return fs.SkipDir
+				}
+				if !walkFilter.firstVisit(path) {
+					i.logger.Debug("Skipping already visited directory", "path", path)
+					return fs.SkipDir
+				}
+				walkFilter.enterDir(path)
+				return nil
+			}
+
+			if info, ok := walkFilter.accept(path, d, opts.MinFileSize, opts.MaxFileSize); ok {
+				job := i.newScanJob(path, info, opts)
+				job.stored = walkFilter.unchanged(path, info)
+				emit(job)
+			}
+			return nil
+		}
+		if err := source.WalkDir(i.fsys, walkRoot, visit); err != nil {
+			return fmt.Errorf("error walking directory: %v", err)
+		}
+		return nil
+	})
+}
+
+// runPipeline hashes the files that discover emits with a pool of workers
+// and hands the results to store from a single goroutine. discover runs in
+// its own goroutine and should stop when ctx is cancelled; files already
+// emitted are still stored.
+func (i *Indexer) runPipeline(ctx context.Context, opts ScanOptions, store func(models.FileInfo) error, discover func(emit func(scanJob)) error) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan scanJob, workers*4)
+	results := make(chan models.FileInfo, workers*4)
+
+	var progress *progressReporter
+	if opts.Progress {
+		progress = newProgressReporter()
+		progress.Start()
+		defer progress.Stop()
+	}
+
+	// Discovery: feeds candidate files into the jobs channel
+	var discoverErr error
+	go func() {
+		defer close(jobs)
+		if progress != nil {
+			defer progress.WalkDone()
+		}
+		discoverErr = discover(func(job scanJob) {
+			if progress != nil {
+				progress.Discovered(job.info.Size())
+			}
+			jobs <- job
+		})
+	}()
+
+	// Workers: compute checksums concurrently, no faster than the throttle
+	// allows. Once ctx is cancelled, the files already emitted are indexed
+	// without waiting.
+	throttle := newThrottle(i.fsys, opts)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if throttle != nil {
+					throttle.files.wait(ctx, 1)
+					job.throttle = throttle
+				}
+				var fileInfo models.FileInfo
+				if job.object != nil {
+					fileInfo = i.buildObjectInfo(job)
+				} else {
+					fileInfo = i.buildFileInfo(job)
+				}
+				if progress != nil {
+					progress.Processed(fileInfo.FileSize)
+				}
+				results <- fileInfo
+				if job.archive {
+					i.scanArchive(job, fileInfo.Path, func(entry models.FileInfo) {
+						results <- entry
+					})
+				}
+				if job.streams {
+					i.scanStreams(job, fileInfo.Path, func(stream models.FileInfo) {
+						results <- stream
+					})
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results are handed to store from this goroutine only. After a
+	// storage error the remaining results are drained but not stored.
+	var storeErr error
+	for fileInfo := range results {
+		if storeErr != nil {
+			continue
+		}
+		if err := store(fileInfo); err != nil {
+			storeErr = err
+			continue
+		}
+		// Per-file lines would drown out the progress report
+		if progress == nil {
+			i.logger.Debug("Indexed file", "path", fileInfo.Path, "size", fileInfo.FileSize)
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case storeErr != nil:
+		return storeErr
+	case discoverErr != nil:
+		return discoverErr
+	}
+	return nil
+}
+
+// setOwner records the owning user and group and the permission bits of a
+// file on platforms that provide them
+func setOwner(file *models.FileInfo, info fs.FileInfo) {
+	owner, ok := fsmeta.FileOwner(info)
+	if !ok {
+		return
+	}
+	file.UID, file.GID = &owner.UID, &owner.GID
+	file.UserName = fsmeta.UserName(owner.UID)
+	file.GroupName = fsmeta.GroupName(owner.GID)
+	file.Mode = owner.Mode
+}
+
+// buildFileInfo hashes a discovered file and assembles its index record
+func (i *Indexer) buildFileInfo(job scanJob) models.FileInfo {
+	// Get absolute path
+	absPath, err := filepath.Abs(job.path)
+	if err != nil {
+		i.logger.Warn("Error getting absolute path", "path", job.path, "err", err)
+		absPath = job.path // fallback to original path
+	}
+
+	fileInfo := models.FileInfo{
+		Path:                 absPath,
+		Filename:             filepath.Base(job.path),
+		ModificationDateTime: job.info.ModTime(),
+		FileSize:             job.info.Size(),
+		IndexedAt:            time.Now(),
+		Host:                 i.host,
+		Label:                job.label,
+	}
+	if id, ok := fsmeta.FileID(job.info); ok {
+		fileInfo.Device = id.Device
+		fileInfo.Inode = id.Inode
+	}
+	if links, ok := fsmeta.LinkCount(job.info); ok {
+		fileInfo.LinkCount = links
+	}
+	setOwner(&fileInfo, job.info)
+	if attrs, ok := fsmeta.FileAttributes(job.info); ok {
+		fileInfo.Attributes = attrs.String()
+	}
+
+	// Recorded symlinks are described by their target, not by content
+	if job.info.Mode()&fs.ModeSymlink != 0 {
+		fileInfo.LinkTarget = job.linkTarget
+		return fileInfo
+	}
+
+	// Extended attributes, including Finder tags, exist only on the local
+	// filesystem
+	if i.fsys == source.OS {
+		if job.xattrs {
+			xattrs, err := fsmeta.XAttrs(job.path)
+			if err != nil {
+				i.logger.Warn("Error reading extended attributes", "path", job.path, "err", err)
+			}
+			fileInfo.XAttrs = xattrs
+		}
+		tags, err := fsmeta.FinderTags(job.path)
+		if err != nil {
+			i.logger.Warn("Error reading Finder tags", "path", job.path, "err", err)
+		}
+		fileInfo.FinderTags = tags
+	}
+
+	fsys := i.readFS(job)
+	var mimeType string
+	if job.stored != nil && job.stored.MimeType != "" {
+		mimeType = job.stored.MimeType
+	} else if job.noRead {
+		mimeType = extensionMimeType(fileInfo.Filename)
+	} else if mimeType, err = detectMimeType(fsys, job.path); err != nil {
+		i.logger.Warn("Error detecting MIME type", "path", job.path, "err", err)
+	}
+	fileInfo.MimeType = mimeType
+
+	if job.content && strings.HasPrefix(mimeType, "text/") {
+		content, err := readTextContent(fsys, job.path)
+		if err != nil {
+			i.logger.Warn("Error reading content", "path", job.path, "err", err)
+		}
+		fileInfo.Content = content
+	}
+
+	// Files without content have no findings, which clears those of files
+	// that are no longer text or were emptied
+	if job.secrets != nil {
+		fileInfo.Secrets = job.secrets.Scan(fileInfo.Content)
+	}
+
+	if job.exif && media.IsPhoto(fileInfo.Filename, mimeType) {
+		photo, err := media.ReadEXIF(fsys, job.path)
+		if err != nil && !errors.Is(err, media.ErrNoEXIF) {
+			i.logger.Warn("Error reading EXIF metadata", "path", job.path, "err", err)
+		}
+		fileInfo.TakenAt = photo.TakenAt
+		fileInfo.CameraModel = photo.CameraModel
+		fileInfo.CameraSerial = photo.CameraSerial
+		fileInfo.ImageWidth = photo.Width
+		fileInfo.ImageHeight = photo.Height
+	}
+
+	// Decoding images is slow, so unchanged files keep their hashes. Images
+	// without EXIF dimensions get those of the decoded image.
+	if job.imageHash && media.IsHashableImage(mimeType) {
+		var hashed media.HashedImage
+		if job.stored != nil && job.stored.ImageHash != "" {
+			hashed = media.HashedImage{Hash: job.stored.ImageHash, Width: job.stored.ImageWidth, Height: job.stored.ImageHeight}
+		} else if hashed, err = media.ImageHash(fsys, job.path); err != nil && !errors.Is(err, media.ErrUnsupportedImage) {
+			i.logger.Warn("Error computing image hash", "path", job.path, "err", err)
+		}
+		fileInfo.ImageHash = hashed.Hash
+		if fileInfo.ImageWidth == 0 && fileInfo.ImageHeight == 0 {
+			fileInfo.ImageWidth, fileInfo.ImageHeight = hashed.Width, hashed.Height
+		}
+	}
+
+	if job.textHash && strings.HasPrefix(mimeType, "text/") {
+		if job.stored != nil && job.stored.TextHash != "" {
+			fileInfo.TextHash = job.stored.TextHash
+		} else if hash, err := hasher.SimHashFile(fsys, job.path); err != nil {
+			if !errors.Is(err, hasher.ErrTooFewWords) {
+				i.logger.Warn("Error computing text hash", "path", job.path, "err", err)
+			}
+		} else {
+			fileInfo.TextHash = hash
+		}
+	}
+
+	// Sniffing keeps binary files with source extensions out, such as
+	// MPEG transport streams named .ts
+	if language, ok := codestats.Detect(fileInfo.Filename); job.code && ok && (strings.HasPrefix(mimeType, "text/") || mimeType == "inode/x-empty") {
+		if job.stored != nil && job.stored.Language == language.Name {
+			fileInfo.Language = language.Name
+			fileInfo.LineCount, fileInfo.CodeLines, fileInfo.CommentLines = job.stored.LineCount, job.stored.CodeLines, job.stored.CommentLines
+		} else if counts, err := codestats.CountFile(fsys, job.path, language); err != nil {
+			i.logger.Warn("Error counting lines of code", "path", job.path, "err", err)
+		} else {
+			fileInfo.Language = language.Name
+			fileInfo.LineCount, fileInfo.CodeLines, fileInfo.CommentLines = counts.Lines, counts.Code, counts.Comments
+		}
+	}
+
+	if job.media && media.IsMedia(fileInfo.Filename, mimeType) {
+		av, err := media.ReadMediaInfo(fsys, job.path)
+		if err != nil && !errors.Is(err, media.ErrUnsupportedMedia) {
+			i.logger.Warn("Error reading media metadata", "path", job.path, "err", err)
+		}
+		fileInfo.Container = av.Container
+		fileInfo.DurationSeconds = av.Duration
+		fileInfo.VideoWidth = av.Width
+		fileInfo.VideoHeight = av.Height
+		fileInfo.VideoCodec = av.VideoCodec
+		fileInfo.AudioCodec = av.AudioCodec
+	}
+
+	// Unchanged files keep their stored modification time and checksums, so
+	// re-indexing does not read them again and a modification time that lost
+	// precision is neither recorded as a change nor re-hashed
+	if job.stored != nil {
+		fileInfo.ModificationDateTime = job.stored.ModificationDateTime
+		if job.stored.Checksum != "" && (!job.quickHash || job.stored.QuickHash != "") && i.hasExtraChecksums(*job.stored) {
+			fileInfo.Checksum = job.stored.Checksum
+			fileInfo.QuickHash = job.stored.QuickHash
+			fileInfo.SampleHash = job.stored.SampleHash
+			return fileInfo
+		}
+		if job.sampled && job.stored.SampleHash != "" {
+			fileInfo.SampleHash = job.stored.SampleHash
+			return fileInfo
+		}
+		// Files too large for a full checksum in quick-hash mode keep their
+		// quick hash, which is all they would get
+		if job.quickHash && job.stored.QuickHash != "" && job.info.Size() > 2*hasher.QuickHashChunk {
+			fileInfo.QuickHash = job.stored.QuickHash
+			fileInfo.SampleHash = job.stored.SampleHash
+			return fileInfo
+		}
+	}
+
+	// The content is read below, so checksums of other algorithms stored
+	// before are replaced by those computed now, if any
+	fileInfo.Checksums = map[string]string{}
+	if job.deferHash {
+		return fileInfo
+	}
+
+	// Large files hashed by sampling get no full checksum unless their
+	// sampled hashes collide and are confirmed
+	if job.sampled {
+		var sampleHash string
+		err := i.retryTransient(job.path, job.retries, func() (err error) {
+			sampleHash, err = hasher.SampleHashFile(i.hasher, fsys, job.path)
+			return err
+		})
+		if err != nil {
+			i.logger.Warn("Error calculating sampled hash", "path", job.path, "err", err)
+		}
+		fileInfo.SampleHash = sampleHash
+		return fileInfo
+	}
+
+	// In quick-hash mode only files small enough to be covered entirely by
+	// the quick hash get a full checksum right away
+	if job.quickHash {
+		var quickHash string
+		err := i.retryTransient(job.path, job.retries, func() (err error) {
+			quickHash, err = hasher.QuickHashFile(i.hasher, fsys, job.path)
+			return err
+		})
+		if err != nil {
+			i.logger.Warn("Error calculating quick hash", "path", job.path, "err", err)
+		}
+		fileInfo.QuickHash = quickHash
+		if job.info.Size() > 2*hasher.QuickHashChunk {
+			return fileInfo
+		}
+	}
+
+	// Calculate checksum
+	var checksum string
+	var extra map[string]string
+	err = i.retryTransient(job.path, job.retries, func() (err error) {
+		checksum, extra, err = i.hashFile(fsys, job.path)
+		return err
+	})
+	if err != nil {
+		i.logger.Warn("Error calculating checksum", "path", job.path, "err", err)
+		checksum = "" // empty checksum on error
+	}
+	fileInfo.Checksum = checksum
+	if extra != nil {
+		fileInfo.Checksums = extra
+	}
+
+	return fileInfo
+}
+
+// retryTransient runs read until it succeeds, fails with an error that is
+// not transient or has been retried the given number of times, waiting
+// twice as long before each retry
+func (i *Indexer) retryTransient(path string, retries int, read func() error) error {
+	delay := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := read()
+		if err == nil || attempt > retries || !fsmeta.IsTransient(err) {
+			return err
+		}
+		i.logger.Debug("Retrying after transient error", "path", path, "attempt", attempt, "err", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// detectMimeType sniffs the content type of a file from its first bytes.
+// Parameters such as the charset are dropped, and empty files get the
+// libmagic-style inode/x-empty, since nothing can be sniffed from them.
+func detectMimeType(fsys source.FS, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// DetectContentType considers at most the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if n == 0 {
+		return "inode/x-empty", nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// extensionMimeType guesses the media type of a file from the extension of
+// its name, for files that are not read
+func extensionMimeType(name string) string {
+	mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name)))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// readTextContent reads a file that was sniffed as text. Files that are
+// not valid UTF-8 are treated as binary and yield no content.
+func readTextContent(fsys source.FS, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", nil
+	}
+	return string(data), nil
+}
+
+// resolveQuickHashCollisions computes full checksums for files whose quick
+// hashes collide, as only those can be duplicates of each other
+func (i *Indexer) resolveQuickHashCollisions(ctx context.Context, opts ScanOptions) error {
+	candidates, err := i.quickHashCollisions(ctx)
+	if err != nil {
+		return err
+	}
+	i.logger.Info("Computing full checksums for colliding quick hashes", "files", len(candidates))
+	return i.fullyHash(ctx, candidates, opts)
+}
+
+// confirmSampleHashCollisions computes full checksums for files whose sampled
+// hashes collide, confirming or refuting that they are duplicates
+func (i *Indexer) confirmSampleHashCollisions(ctx context.Context, opts ScanOptions) error {
+	candidates, err := i.sampleHashCollisions(ctx)
+	if err != nil {
+		return err
+	}
+	i.logger.Info("Computing full checksums for colliding sampled hashes", "files", len(candidates))
+	return i.fullyHash(ctx, candidates, opts)
+}
+
+// fullyHash computes and stores the full checksums of files. Only their
+// checksums are updated, as the candidates are read without their content.
+func (i *Indexer) fullyHash(ctx context.Context, candidates []models.FileInfo, opts ScanOptions) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan models.FileInfo)
+	results := make(chan models.FileInfo)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				checksum, extra, err := i.hashFile(i.fsys, file.Path)
+				if err != nil {
+					i.logger.Warn("Error calculating checksum", "path", file.Path, "err", err)
+					continue
+				}
+				file.Checksum, file.Checksums = checksum, extra
+				results <- file
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range candidates {
+			if ctx.Err() != nil {
+				break
+			}
+			jobs <- file
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Checksums computed before a cancellation are still stored
+	var hashed []models.FileInfo
+	var storeErr error
+	for file := range results {
+		if storeErr != nil {
+			continue
+		}
+		hashed = append(hashed, file)
+		if len(hashed) == rehashBatch {
+			storeErr = i.storeChecksums(context.WithoutCancel(ctx), hashed)
+			hashed = nil
+		}
+	}
+	if storeErr == nil {
+		storeErr = i.storeChecksums(context.WithoutCancel(ctx), hashed)
+	}
+	if storeErr != nil {
+		return fmt.Errorf("error storing checksums: %v", storeErr)
+	}
+	return ctx.Err()
+}
+
+// quickHashCollisions returns files lacking a full checksum whose quick hash
+// is shared with other files
+func (i *Indexer) quickHashCollisions(ctx context.Context) ([]models.FileInfo, error) {
+	if i.useDB {
+		return i.db.FindQuickHashCollisions(ctx)
+	}
+	return i.collisionsJSON(func(file models.FileInfo) string { return file.QuickHash }), nil
+}
+
+// sampleHashCollisions returns files lacking a full checksum whose sampled
+// hash is shared with other files
+func (i *Indexer) sampleHashCollisions(ctx context.Context) ([]models.FileInfo, error) {
+	if i.useDB {
+		return i.db.FindSampleHashCollisions(ctx)
+	}
+	return i.collisionsJSON(func(file models.FileInfo) string { return file.SampleHash }), nil
+}
+
+// collisionsJSON returns the files of the JSON index lacking a full checksum
+// whose fingerprint, as returned by key, is shared with other files
+func (i *Indexer) collisionsJSON(key func(models.FileInfo) string) []models.FileInfo {
+	byKey := make(map[string][]models.FileInfo)
+	for _, file := range i.index.Files {
+		if k := key(file); k != "" {
+			byKey[k] = append(byKey[k], file)
+		}
+	}
+
+	var candidates []models.FileInfo
+	for _, files := range byKey {
+		if len(files) < 2 {
+			continue
+		}
+		for _, file := range files {
+			if file.Checksum == "" {
+				candidates = append(candidates, file)
+			}
+		}
+	}
+	return candidates
+}
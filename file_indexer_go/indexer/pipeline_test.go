@@ -0,0 +1,176 @@
+package indexer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// textOfSize returns text of size bytes made of lines of fill, with the
+// byte at offset replaced by mark unless offset is negative
+func textOfSize(size int, fill string, offset int, mark byte) string {
+	text := []byte(strings.Repeat(fill+"\n", size/(len(fill)+1)+1)[:size])
+	if offset >= 0 {
+		text[offset] = mark
+	}
+	return string(text)
+}
+
+func TestQuickHashResolution(t *testing.T) {
+	large := 2*hasher.QuickHashChunk + 1000
+	middle := hasher.QuickHashChunk + 500 // neither in the head nor the tail
+	files := map[string]string{
+		"copy1.txt":   textOfSize(large, "same", -1, 0),
+		"copy2.txt":   textOfSize(large, "same", -1, 0),
+		"inside.txt":  textOfSize(large, "same", middle, 'X'), // same quick hash, other content
+		"unique.txt":  textOfSize(large, "other", -1, 0),
+		"small1.txt":  "small",
+		"small2.txt":  "small",
+		"lonely.txt":  "lonely",
+		"another.txt": textOfSize(large+1, "same", -1, 0),
+	}
+	tests := []struct {
+		file         string
+		wantChecksum bool
+	}{
+		{"copy1.txt", true},
+		{"copy2.txt", true},
+		{"inside.txt", true},
+		{"unique.txt", false},
+		{"another.txt", false},
+		{"small1.txt", true},
+		{"small2.txt", true},
+		{"lonely.txt", true},
+	}
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		idx := newTestIndexer(t, useDB, memFS(files))
+		opts := ScanOptions{QuickHash: true, Content: true, ContentMaxSize: 0, Workers: 2}
+		if err := idx.IndexDirectory(ctx, testRoot, opts); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		indexed := indexedFiles(t, idx)
+		for _, tt := range tests {
+			file := indexed[tt.file]
+			if file.QuickHash == "" {
+				t.Errorf("%s has no quick hash", tt.file)
+			}
+			if got := file.Checksum != ""; got != tt.wantChecksum {
+				t.Errorf("%s has checksum %q, want one: %v", tt.file, file.Checksum, tt.wantChecksum)
+			}
+			// Resolving collisions stores checksums only, keeping the content
+			content, err := idx.storedContent(ctx, file.Path)
+			if err != nil {
+				t.Fatalf("storedContent: %v", err)
+			}
+			if content != files[tt.file] {
+				t.Errorf("%s has %d bytes of stored content, want %d", tt.file, len(content), len(files[tt.file]))
+			}
+		}
+		if indexed["copy1.txt"].QuickHash != indexed["inside.txt"].QuickHash {
+			t.Errorf("files differing only in the middle have different quick hashes")
+		}
+		if indexed["copy1.txt"].Checksum != indexed["copy2.txt"].Checksum {
+			t.Errorf("identical files have different checksums")
+		}
+		if indexed["copy1.txt"].Checksum == indexed["inside.txt"].Checksum {
+			t.Errorf("files of different content have the same checksum")
+		}
+	})
+}
+
+func TestSampleHashResolution(t *testing.T) {
+	size := hasher.SampleHashRegions*hasher.SampleHashChunk + 4096
+	gap := hasher.SampleHashChunk + 100 // between the first two sampled regions
+	files := map[string]string{
+		"copy1.txt":  textOfSize(size, "sampled", -1, 0),
+		"copy2.txt":  textOfSize(size, "sampled", -1, 0),
+		"gap.txt":    textOfSize(size, "sampled", gap, 'X'), // same sampled hash, other content
+		"unique.txt": textOfSize(size, "sampled", 10, 'X'),
+		"small.txt":  "below the threshold",
+	}
+	tests := []struct {
+		file         string
+		wantSampled  bool
+		wantChecksum bool
+	}{
+		{"copy1.txt", true, true},
+		{"copy2.txt", true, true},
+		{"gap.txt", true, true},
+		{"unique.txt", true, false},
+		{"small.txt", false, true},
+	}
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		idx := newTestIndexer(t, useDB, memFS(files))
+		opts := ScanOptions{SampleHash: 1 << 20, ConfirmSampled: true, Content: true, ContentMaxSize: 0, Workers: 2}
+		if err := idx.IndexDirectory(ctx, testRoot, opts); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		indexed := indexedFiles(t, idx)
+		for _, tt := range tests {
+			file := indexed[tt.file]
+			if got := file.SampleHash != ""; got != tt.wantSampled {
+				t.Errorf("%s has sampled hash %q, want one: %v", tt.file, file.SampleHash, tt.wantSampled)
+			}
+			if got := file.Checksum != ""; got != tt.wantChecksum {
+				t.Errorf("%s has checksum %q, want one: %v", tt.file, file.Checksum, tt.wantChecksum)
+			}
+			// Confirming collisions stores checksums only, keeping the content
+			content, err := idx.storedContent(ctx, file.Path)
+			if err != nil {
+				t.Fatalf("storedContent: %v", err)
+			}
+			if content != files[tt.file] {
+				t.Errorf("%s has %d bytes of stored content, want %d", tt.file, len(content), len(files[tt.file]))
+			}
+		}
+		if indexed["copy1.txt"].SampleHash != indexed["gap.txt"].SampleHash {
+			t.Errorf("files differing only between the sampled regions have different sampled hashes")
+		}
+		if indexed["copy1.txt"].Checksum != indexed["copy2.txt"].Checksum || indexed["copy1.txt"].Checksum == indexed["gap.txt"].Checksum {
+			t.Errorf("confirmed checksums do not tell the identical files from the other: %q, %q, %q",
+				indexed["copy1.txt"].Checksum, indexed["copy2.txt"].Checksum, indexed["gap.txt"].Checksum)
+		}
+	})
+}
+
+func TestQuickHashRescanKeepsStoredHash(t *testing.T) {
+	backends(t, func(t *testing.T, useDB bool) {
+		ctx := context.Background()
+		large := 2*hasher.QuickHashChunk + 1000
+		fsys := fstest.MapFS{"large.txt": {Data: []byte(textOfSize(large, "before", -1, 0)), ModTime: testModTime}}
+		idx := newTestIndexer(t, useDB, source.FromFS(fsys, testRoot))
+		opts := ScanOptions{QuickHash: true}
+		if err := idx.IndexDirectory(ctx, testRoot, opts); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		before := indexedFiles(t, idx)["large.txt"]
+		if before.QuickHash == "" || before.Checksum != "" {
+			t.Fatalf("a unique large file was indexed with quick hash %q and checksum %q", before.QuickHash, before.Checksum)
+		}
+
+		// A file of the same size and modification time is taken as unchanged,
+		// so its stored quick hash is kept without reading it
+		fsys["large.txt"] = &fstest.MapFile{Data: []byte(textOfSize(large, "after!", -1, 0)), ModTime: testModTime}
+		if err := idx.IndexDirectory(ctx, testRoot, opts); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		if after := indexedFiles(t, idx)["large.txt"]; after.QuickHash != before.QuickHash || after.Checksum != "" {
+			t.Errorf("the unchanged file was hashed again: quick hash %q, checksum %q", after.QuickHash, after.Checksum)
+		}
+
+		// Paranoid scans read it again
+		opts.Paranoid = true
+		if err := idx.IndexDirectory(ctx, testRoot, opts); err != nil {
+			t.Fatalf("IndexDirectory: %v", err)
+		}
+		if after := indexedFiles(t, idx)["large.txt"]; after.QuickHash == before.QuickHash {
+			t.Errorf("a paranoid scan kept the stored quick hash")
+		}
+	})
+}
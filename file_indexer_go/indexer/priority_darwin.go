@@ -0,0 +1,15 @@
+package indexer
+
+import "golang.org/x/sys/unix"
+
+// setpriority arguments that move a process to the background band, in
+// which macOS throttles its CPU and disk I/O
+const (
+	prioDarwinProcess = 4
+	prioDarwinBG      = 0x1000
+)
+
+// lowerPriority moves the process to the background, like taskpolicy -b
+func lowerPriority() error {
+	return unix.Setpriority(prioDarwinProcess, 0, prioDarwinBG)
+}
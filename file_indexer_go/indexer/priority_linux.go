@@ -0,0 +1,38 @@
+package indexer
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioprio_set arguments selecting a thread and the idle I/O class, in which
+// a thread only gets disk time no other program wants
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3 << 13
+)
+
+// lowerPriority gives the process the lowest CPU priority and the idle I/O
+// class, like nice -n 19 ionice -c 3. Linux keeps both per thread, so every
+// thread is changed; threads started later inherit them.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, 19); err != nil {
+			return err
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
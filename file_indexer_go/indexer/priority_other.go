@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package indexer
+
+import "errors"
+
+// lowerPriority is not supported on other platforms
+func lowerPriority() error {
+	return errors.New("idle priority is not supported on this platform")
+}
@@ -0,0 +1,9 @@
+package indexer
+
+import "golang.org/x/sys/windows"
+
+// lowerPriority puts the process in background mode, which lowers its CPU,
+// I/O and memory priority
+func lowerPriority() error {
+	return windows.SetPriorityClass(windows.CurrentProcess(), windows.PROCESS_MODE_BACKGROUND_BEGIN)
+}
@@ -0,0 +1,167 @@
+package indexer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressReporter tracks scan counters and periodically reports them. On a
+// terminal it redraws a single progress bar line; otherwise it prints plain
+// status lines at a slower pace so logs stay readable.
+type progressReporter struct {
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+	start    time.Time
+
+	discoveredFiles atomic.Int64
+	discoveredBytes atomic.Int64
+	processedFiles  atomic.Int64
+	processedBytes  atomic.Int64
+	walkDone        atomic.Bool
+
+	stop     chan struct{}
+	finished sync.WaitGroup
+}
+
+// newProgressReporter creates a reporter writing to stderr
+func newProgressReporter() *progressReporter {
+	p := &progressReporter{
+		out:      os.Stderr,
+		tty:      isTerminal(os.Stderr),
+		interval: 10 * time.Second,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+	}
+	if p.tty {
+		p.interval = 500 * time.Millisecond
+	}
+	return p
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start begins periodic reporting
+func (p *progressReporter) Start() {
+	p.finished.Add(1)
+	go func() {
+		defer p.finished.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report(false)
+			case <-p.stop:
+				p.report(true)
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends reporting and prints the final summary
+func (p *progressReporter) Stop() {
+	close(p.stop)
+	p.finished.Wait()
+}
+
+// Discovered records a file found by the walker
+func (p *progressReporter) Discovered(size int64) {
+	p.discoveredFiles.Add(1)
+	p.discoveredBytes.Add(size)
+}
+
+// WalkDone records that no more files will be discovered
+func (p *progressReporter) WalkDone() {
+	p.walkDone.Store(true)
+}
+
+// Processed records a file that has been hashed
+func (p *progressReporter) Processed(size int64) {
+	p.processedFiles.Add(1)
+	p.processedBytes.Add(size)
+}
+
+// report prints the current state
+func (p *progressReporter) report(final bool) {
+	elapsed := time.Since(p.start)
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1e-9
+	}
+
+	processedFiles := p.processedFiles.Load()
+	processedBytes := p.processedBytes.Load()
+	discoveredFiles := p.discoveredFiles.Load()
+	discoveredBytes := p.discoveredBytes.Load()
+	fileRate := float64(processedFiles) / seconds
+	byteRate := float64(processedBytes) / seconds
+
+	if final {
+		if p.tty {
+			fmt.Fprint(p.out, "\r\033[K")
+		}
+		fmt.Fprintf(p.out, "Processed %d files (%s) in %s: %.1f files/s, %s/s\n",
+			processedFiles, humanBytes(processedBytes), elapsed.Round(time.Second), fileRate, humanBytes(int64(byteRate)))
+		return
+	}
+
+	// The ETA is only meaningful once the total amount of work is known
+	eta := "discovering..."
+	if p.walkDone.Load() {
+		remaining := discoveredBytes - processedBytes
+		if byteRate > 0 {
+			eta = (time.Duration(float64(remaining)/byteRate) * time.Second).Round(time.Second).String()
+		} else {
+			eta = "unknown"
+		}
+	}
+
+	status := fmt.Sprintf("%d/%d files, %s/%s, %.1f files/s, %s/s, ETA %s",
+		processedFiles, discoveredFiles, humanBytes(processedBytes), humanBytes(discoveredBytes),
+		fileRate, humanBytes(int64(byteRate)), eta)
+
+	if !p.tty {
+		fmt.Fprintf(p.out, "Progress: %s\n", status)
+		return
+	}
+
+	percent := 0.0
+	if discoveredBytes > 0 {
+		percent = float64(processedBytes) / float64(discoveredBytes)
+	} else if discoveredFiles > 0 {
+		percent = float64(processedFiles) / float64(discoveredFiles)
+	}
+	const width = 30
+	filled := int(percent * width)
+	if filled > width {
+		filled = width
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+	fmt.Fprintf(p.out, "\r\033[K[%s] %5.1f%% %s", bar, percent*100, status)
+}
+
+// humanBytes formats a byte count in human readable form
+func humanBytes(size int64) string {
+	value := float64(size)
+	for _, unit := range []string{"B", "KB", "MB", "GB", "TB"} {
+		if value < 1024.0 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+		value /= 1024.0
+	}
+	return fmt.Sprintf("%.1f PB", value)
+}
@@ -0,0 +1,293 @@
+package indexer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// QuarantineManifest is the name of the file in a quarantine directory that
+// lists the duplicates moved into it, one JSON object per line
+const QuarantineManifest = "file-indexer-manifest.jsonl"
+
+// QuarantineEntry is a line of a quarantine manifest: a duplicate that dedupe
+// moved into the quarantine directory, with the index record to restore
+type QuarantineEntry struct {
+	MovedAt        time.Time       `json:"moved_at"`
+	Path           string          `json:"path"`            // where the duplicate was
+	QuarantinePath string          `json:"quarantine_path"` // where it is now
+	Original       string          `json:"original"`
+	File           models.FileInfo `json:"file"`
+}
+
+// quarantinePath returns where a file is moved in a quarantine directory: the
+// same path below it, with the volume of Windows paths turned into a
+// directory (C:\data\a.txt becomes DIR\C\data\a.txt)
+func quarantinePath(dir, path string) string {
+	volume := filepath.VolumeName(path)
+	rest := strings.TrimPrefix(path, volume)
+	volume = strings.Trim(strings.ReplaceAll(volume, ":", ""), `\/`)
+	return filepath.Join(dir, volume, rest)
+}
+
+// inQuarantine reports whether a path is inside a quarantine directory, so
+// duplicates already moved and indexed again are left alone
+func inQuarantine(dir, path string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// quarantineFile moves a local file into the quarantine directory, appends it
+// to the directory's manifest and removes it from the index
+func (i *Indexer) quarantineFile(ctx context.Context, dir string, file models.FileInfo, original string) error {
+	target := quarantinePath(dir, file.Path)
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	// Duplicates are read without their content, which the manifest keeps
+	// for restoring the index record
+	if file.Content == "" {
+		content, err := i.storedContent(ctx, file.Path)
+		if err != nil {
+			return err
+		}
+		file.Content = content
+	}
+	if err := moveFile(file.Path, target); err != nil {
+		return err
+	}
+
+	entry := QuarantineEntry{MovedAt: time.Now(), Path: file.Path, QuarantinePath: target, Original: original, File: file}
+	if err := appendManifest(dir, entry); err != nil {
+		// Without a manifest entry the file could not be restored, so put it back
+		if restoreErr := moveFile(target, file.Path); restoreErr != nil {
+			return fmt.Errorf("%v; the file was left at %s: %v", err, target, restoreErr)
+		}
+		return err
+	}
+	i.logger.Info("Moved duplicate to quarantine", "path", file.Path, "quarantine", target, "original", original)
+	return i.removePath(ctx, file.Path)
+}
+
+// storedContent returns the content stored for an indexed file
+func (i *Indexer) storedContent(ctx context.Context, path string) (string, error) {
+	if i.useDB {
+		return i.db.FileContent(ctx, path)
+	}
+	return i.index.Files[path].Content, nil
+}
+
+// appendManifest adds an entry to the manifest of a quarantine directory
+func appendManifest(dir string, entry QuarantineEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding manifest entry: %v", err)
+	}
+	manifest, err := os.OpenFile(filepath.Join(dir, QuarantineManifest), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening manifest: %v", err)
+	}
+	if _, err := manifest.Write(append(line, '\n')); err != nil {
+		manifest.Close()
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	if err := manifest.Close(); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// readManifest reads the entries of the manifest of a quarantine directory
+func readManifest(dir string) ([]QuarantineEntry, error) {
+	manifest, err := os.Open(filepath.Join(dir, QuarantineManifest))
+	if err != nil {
+		return nil, fmt.Errorf("error opening manifest: %v", err)
+	}
+	defer manifest.Close()
+
+	var entries []QuarantineEntry
+	scanner := bufio.NewScanner(manifest)
+	scanner.Buffer(nil, 64<<20) // records may carry the content of text files
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry QuarantineEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error reading manifest line %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+	return entries, nil
+}
+
+// writeManifest replaces the manifest of a quarantine directory with entries,
+// or removes it if there are none left
+func writeManifest(dir string, entries []QuarantineEntry) error {
+	path := filepath.Join(dir, QuarantineManifest)
+	if len(entries) == 0 {
+		return os.Remove(path)
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("error writing manifest: %v", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// RestoreResult describes what happened to one file of a quarantine manifest
+type RestoreResult struct {
+	Path           string
+	QuarantinePath string
+	Size           int64
+	Checksum       string
+	Done           bool  // The file was moved back (always false in a dry run)
+	Err            error // Why the file could not be restored
+}
+
+// RestoreQuarantine moves the duplicates listed in the manifest of a
+// quarantine directory back to where they were and adds them to the index
+// again. Files whose old path is taken are left in quarantine. The manifest
+// keeps the files that were not restored; call SaveIndex afterwards.
+func (i *Indexer) RestoreQuarantine(ctx context.Context, dir string, dryRun bool) ([]RestoreResult, error) {
+	if i.readOnly && !dryRun {
+		return nil, ErrReadOnly
+	}
+	return i.restoreQuarantine(ctx, absolutePath(dir), dryRun, func(QuarantineEntry) bool { return true })
+}
+
+// restoreQuarantine restores the entries of the manifest of a quarantine
+// directory that include accepts, keeping the others in the manifest
+func (i *Indexer) restoreQuarantine(ctx context.Context, dir string, dryRun bool, include func(QuarantineEntry) bool) ([]RestoreResult, error) {
+	entries, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []RestoreResult
+	var remaining []QuarantineEntry
+	for n, entry := range entries {
+		if ctx.Err() != nil {
+			remaining = append(remaining, entries[n:]...)
+			break
+		}
+		if !include(entry) {
+			remaining = append(remaining, entry)
+			continue
+		}
+		result := RestoreResult{Path: entry.Path, QuarantinePath: entry.QuarantinePath, Size: entry.File.FileSize,
+			Checksum: entry.File.Checksum}
+		if _, err := os.Lstat(entry.QuarantinePath); err != nil {
+			result.Err = fmt.Errorf("no longer in quarantine: %v", err)
+		} else if _, err := os.Lstat(entry.Path); err == nil {
+			result.Err = fmt.Errorf("%s already exists", entry.Path)
+		} else if !dryRun {
+			result.Err = i.restoreFile(ctx, entry)
+			result.Done = result.Err == nil
+		}
+		if !result.Done {
+			remaining = append(remaining, entry)
+		}
+		results = append(results, result)
+	}
+
+	if !dryRun {
+		if err := writeManifest(dir, remaining); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return results, err
+		}
+	}
+	return results, ctx.Err()
+}
+
+// restoreFile moves a quarantined file back and stores its index record
+func (i *Indexer) restoreFile(ctx context.Context, entry QuarantineEntry) error {
+	if err := os.MkdirAll(filepath.Dir(entry.Path), 0o755); err != nil {
+		return err
+	}
+	if err := moveFile(entry.QuarantinePath, entry.Path); err != nil {
+		return err
+	}
+	i.logger.Info("Restored duplicate from quarantine", "path", entry.Path, "quarantine", entry.QuarantinePath)
+	return i.storeFunc(ctx)(entry.File)
+}
+
+// moveFile renames a file, or copies and removes it when the target is on
+// another filesystem. Copies keep the file's permissions and modification
+// time.
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+
+	info, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("cannot move %s to another filesystem: not a regular file", from)
+	}
+	if err := copyFile(from, to, info.Mode().Perm()); err != nil {
+		os.Remove(to)
+		return err
+	}
+	if err := os.Chtimes(to, time.Time{}, info.ModTime()); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
+// isCrossDevice reports whether a rename failed because the target is on
+// another filesystem
+func isCrossDevice(err error) bool {
+	var linkErr *os.LinkError
+	return errors.As(err, &linkErr) && errors.Is(linkErr.Err, errCrossDevice)
+}
+
+// copyFile copies the content of a file to a new file
+func copyFile(from, to string, perm fs.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
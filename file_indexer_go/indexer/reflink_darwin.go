@@ -0,0 +1,43 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink makes a duplicate share the blocks of its original with
+// clonefile(2), which APFS supports. The clone is made under a temporary
+// name, given the duplicate's owner, permissions and modification time, and
+// renamed over the duplicate.
+func reflink(original, duplicate string) error {
+	info, err := os.Lstat(duplicate)
+	if err != nil {
+		return err
+	}
+	return replaceFile(duplicate, func(tmp string) error {
+		if err := unix.Clonefile(original, tmp, unix.CLONE_NOFOLLOW); err != nil {
+			if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EXDEV) {
+				return fmt.Errorf("the filesystem does not support reflinks between these files: %v", err)
+			}
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			// Only root can give files to other owners, so failures are ignored
+			os.Lchown(tmp, int(stat.Uid), int(stat.Gid))
+		}
+		if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Chtimes(tmp, time.Time{}, info.ModTime()); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink makes a duplicate share the extents of its original with the
+// FICLONE ioctl, which btrfs and XFS support. The duplicate is cloned in
+// place, so it keeps its inode, owner, permissions and modification time.
+func reflink(original, duplicate string) error {
+	src, err := os.Open(original)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := os.Lstat(duplicate)
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(duplicate, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("the filesystem does not support reflinks between these files: %v", err)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(duplicate, time.Time{}, info.ModTime())
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package indexer
+
+import "errors"
+
+// reflink is not supported on other platforms
+func reflink(original, duplicate string) error {
+	return errors.New("reflinks are not supported on this platform")
+}
@@ -0,0 +1,264 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/fsmeta"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// RehashStatus is the outcome of re-hashing one indexed file
+type RehashStatus string
+
+const (
+	RehashOK      RehashStatus = "ok"      // The file was hashed and its checksum stored
+	RehashFailed  RehashStatus = "failed"  // The file still could not be hashed
+	RehashSkipped RehashStatus = "skipped" // The file is not hashed by design, e.g. it only has a sampled hash
+)
+
+// Reasons why a file could not be hashed, as reported by RehashResult
+const (
+	ReasonMissing    = "missing"            // The file no longer exists
+	ReasonPermission = "permission denied"  // The file cannot be opened by this user
+	ReasonNotRegular = "not a regular file" // The path is now a directory, device or the like
+	ReasonIO         = "I/O error"          // Reads kept failing with a transient error such as EIO, after the retries
+	ReasonOther      = "error"              // Any other error, described by Err
+)
+
+// rehashBatch is the number of re-hashed files stored at a time
+const rehashBatch = 1000
+
+// RehashOptions controls a re-hashing run
+type RehashOptions struct {
+	MissingOnly bool     // Only re-hash files stored without a checksum, such as those whose hashing failed
+	Dirs        []string // Only re-hash files below these directories (empty = the whole index)
+	Workers     int      // Number of concurrent checksum workers
+	Retries     int      // Times a read failing with a transient I/O error is retried
+
+	// DuplicateSizes only re-hashes files whose size another indexed file
+	// shares, as only those can have duplicates. DuplicatesFirst hashes
+	// them before the others, largest first, so an interrupted run has
+	// spent its time where duplicates can be.
+	DuplicateSizes  bool
+	DuplicatesFirst bool
+}
+
+// RehashResult describes the outcome for one file
+type RehashResult struct {
+	File   models.FileInfo
+	Status RehashStatus
+	Reason string // Why the file could not be hashed or was skipped
+	Err    error
+}
+
+// Rehash recomputes the checksums of the local indexed files with the index's
+// algorithm and stores them along with the current size and modification
+// time. With opts.MissingOnly, only files stored with an empty checksum are
+// read, which is how files whose hashing failed are recorded; those with only
+// a sampled or quick hash lack a full checksum by design and are skipped.
+// With the DuckDB backend, the statistics are refreshed once files were
+// hashed. Results are passed to report one at a time, in no particular order. If ctx
+// is cancelled, no further files are read, the checksums computed so far are
+// stored and ctx's error is returned.
+func (i *Indexer) Rehash(ctx context.Context, opts RehashOptions, report func(RehashResult)) error {
+	if err := i.useStoredHasher(ctx); err != nil {
+		return err
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	files, err := i.rehashCandidates(ctx, opts)
+	if err != nil {
+		return err
+	}
+	i.logger.Info("Re-hashing files", "files", len(files), "algorithm", i.hasher.Name())
+
+	jobs := make(chan models.FileInfo)
+	results := make(chan RehashResult)
+
+	var wg sync.WaitGroup
+	for n := 0; n < opts.Workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				results <- i.rehashFile(file, opts.Retries)
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			jobs <- file
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Checksums computed before a cancellation are still stored
+	var hashed []models.FileInfo
+	var stored int
+	var storeErr error
+	for result := range results {
+		if result.Status == RehashOK && storeErr == nil {
+			stored++
+			hashed = append(hashed, result.File)
+			if len(hashed) == rehashBatch {
+				storeErr = i.storeChecksums(context.WithoutCancel(ctx), hashed)
+				hashed = nil
+			}
+		}
+		report(result)
+	}
+	if storeErr == nil {
+		storeErr = i.storeChecksums(context.WithoutCancel(ctx), hashed)
+	}
+	if storeErr != nil {
+		return fmt.Errorf("error storing checksums: %v", storeErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// The new checksums change the duplicates of the statistics
+	if i.useDB && stored > 0 {
+		if _, err := i.RefreshStats(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeChecksums stores the checksums, sizes and modification times of
+// re-hashed files, keeping everything else recorded about them
+func (i *Indexer) storeChecksums(ctx context.Context, files []models.FileInfo) error {
+	if i.useDB {
+		return i.db.UpdateChecksums(ctx, files)
+	}
+	for _, file := range files {
+		stored, ok := i.index.Files[file.Path]
+		if !ok {
+			continue
+		}
+		stored.Checksum, stored.FileSize = file.Checksum, file.FileSize
+		stored.ModificationDateTime, stored.IndexedAt = file.ModificationDateTime, file.IndexedAt
+		if file.Checksums != nil {
+			stored.Checksums = file.Checksums
+		}
+		i.index.Files[file.Path] = stored
+	}
+	return nil
+}
+
+// rehashCandidates returns the local files a re-hashing run covers, in path
+// order or, with opts.DuplicatesFirst, in the order they are hashed
+func (i *Indexer) rehashCandidates(ctx context.Context, opts RehashOptions) ([]models.FileInfo, error) {
+	indexed, err := i.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return nil, err
+	}
+	// Files of other hosts and sources count as well, as duplicates can be
+	// anywhere in the index
+	sizes := make(map[int64]int)
+	for _, file := range indexed {
+		if file.LinkTarget == "" {
+			sizes[file.FileSize]++
+		}
+	}
+	var prefixes []string
+	for _, dir := range opts.Dirs {
+		prefixes = append(prefixes, strings.TrimSuffix(filepath.Clean(absolutePath(dir)), string(filepath.Separator))+string(filepath.Separator))
+	}
+
+	var files []models.FileInfo
+	for _, file := range indexed {
+		if !i.isLocal(file) || file.LinkTarget != "" {
+			continue
+		}
+		if opts.MissingOnly && file.Checksum != "" {
+			continue
+		}
+		if opts.DuplicateSizes && sizes[file.FileSize] < 2 {
+			continue
+		}
+		if len(prefixes) > 0 && !hasAnyPrefix(file.Path, prefixes) {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(a, b int) bool {
+		if opts.DuplicatesFirst {
+			shared, otherShared := sizes[files[a].FileSize] > 1, sizes[files[b].FileSize] > 1
+			if shared != otherShared {
+				return shared
+			}
+			if files[a].FileSize != files[b].FileSize {
+				return files[a].FileSize > files[b].FileSize
+			}
+		}
+		return files[a].Path < files[b].Path
+	})
+	return files, nil
+}
+
+// rehashFile hashes a single file and classifies the result
+func (i *Indexer) rehashFile(file models.FileInfo, retries int) RehashResult {
+	result := RehashResult{File: file}
+	if file.Checksum == "" && (file.SampleHash != "" || file.QuickHash != "") {
+		result.Status, result.Reason = RehashSkipped, "only has a sampled or quick hash"
+		return result
+	}
+
+	info, err := i.fsys.Stat(file.Path)
+	if err == nil && !info.Mode().IsRegular() {
+		result.Status, result.Reason = RehashFailed, ReasonNotRegular
+		return result
+	}
+	var checksum string
+	if err == nil {
+		err = i.retryTransient(file.Path, retries, func() (err error) {
+			checksum, err = i.calculateChecksum(file.Path)
+			return err
+		})
+	}
+	if err != nil {
+		result.Status, result.Reason, result.Err = RehashFailed, hashFailureReason(err), err
+		return result
+	}
+
+	// Checksums of other algorithms stored before no longer match a file
+	// that was modified since
+	if info.Size() != file.FileSize || !info.ModTime().Truncate(time.Microsecond).Equal(file.ModificationDateTime.Truncate(time.Microsecond)) {
+		result.File.Checksums = map[string]string{}
+	}
+	result.Status = RehashOK
+	result.File.Checksum = checksum
+	result.File.FileSize = info.Size()
+	result.File.ModificationDateTime = info.ModTime()
+	result.File.IndexedAt = time.Now()
+	return result
+}
+
+// hashFailureReason classifies an error reading a file
+func hashFailureReason(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ReasonMissing
+	case errors.Is(err, fs.ErrPermission):
+		return ReasonPermission
+	case fsmeta.IsTransient(err):
+		return ReasonIO
+	}
+	return ReasonOther
+}
@@ -0,0 +1,152 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// SetS3 sets the connection used to index s3:// roots
+func (i *Indexer) SetS3(s3 *source.S3) {
+	i.s3 = s3
+}
+
+// scanRoot scans a root directory, or the objects below an s3:// URL, and
+// returns the paths whose files must not be pruned
+func (i *Indexer) scanRoot(ctx context.Context, rootPath string, opts ScanOptions, store func(models.FileInfo) error) ([]string, error) {
+	if source.IsS3URL(rootPath) {
+		return i.scanBucket(ctx, rootPath, opts, store)
+	}
+	return i.scanDirectory(ctx, rootPath, opts, store)
+}
+
+// scanBucket indexes the objects below an s3://bucket/prefix URL that are
+// accepted by the scan filters, treating the slash-separated parts of their
+// keys as directories. Ignore files and symlinks do not exist in buckets. It
+// returns the prefixes below the depth limit.
+func (i *Indexer) scanBucket(ctx context.Context, rootURL string, opts ScanOptions, store func(models.FileInfo) error) ([]string, error) {
+	if i.s3 == nil {
+		return nil, fmt.Errorf("indexing %s requires an S3 connection", rootURL)
+	}
+	bucket, prefix, err := source.ParseS3URL(rootURL)
+	if err != nil {
+		return nil, err
+	}
+	opts.IgnoreFiles = false
+	walkFilter, err := newWalkFilter(i.fsys, absolutePath(rootURL), opts, i.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.runPipeline(ctx, opts, store, func(emit func(scanJob)) error {
+		return i.s3.List(ctx, bucket, prefix, func(object source.S3Object) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			job := scanJob{
+				path:         source.S3URL(bucket, object.Key),
+				info:         objectInfo{name: path.Base(object.Key), size: object.Size, modTime: object.ModTime},
+				bucket:       bucket,
+				object:       &object,
+				downloadHash: opts.S3DownloadHash,
+				noRead:       opts.NoChecksum,
+			}
+			if walkFilter.acceptObject(job.path, job.info, opts.MinFileSize, opts.MaxFileSize) {
+				emit(job)
+			}
+			return nil
+		})
+	})
+	return walkFilter.kept, err
+}
+
+// acceptObject applies the filters to an object listed from a bucket. The
+// prefixes of its key are checked like the directories of a walk.
+func (f *walkFilter) acceptObject(objectURL string, info fs.FileInfo, minFileSize, maxFileSize int64) bool {
+	rel, _ := f.paths(objectURL)
+	dir := f.rootPath
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir += "/" + part
+		if f.shouldSkipDir(dir, fs.FileInfoToDirEntry(objectInfo{name: part, dir: true})) {
+			f.logger.Debug("Skipping object in excluded prefix", "path", objectURL)
+			return false
+		}
+		if f.beyondDepth(dir) {
+			// Listings are sorted, so the objects of a prefix arrive together
+			if len(f.kept) == 0 || f.kept[len(f.kept)-1] != dir {
+				f.kept = append(f.kept, dir)
+			}
+			return false
+		}
+	}
+	_, ok := f.accept(objectURL, fs.FileInfoToDirEntry(info), minFileSize, maxFileSize)
+	return ok
+}
+
+// buildObjectInfo assembles the index record of an S3 object. When the index
+// uses MD5, an ETag that is the object's MD5 checksum is stored as is;
+// otherwise the object is downloaded and hashed, unless the scan takes an
+// inventory without checksums. The MIME type is guessed from the key's
+// extension, as listings do not include content types.
+func (i *Indexer) buildObjectInfo(job scanJob) models.FileInfo {
+	fileInfo := models.FileInfo{
+		Path:                 job.path,
+		Filename:             job.info.Name(),
+		ModificationDateTime: job.info.ModTime(),
+		FileSize:             job.info.Size(),
+		IndexedAt:            time.Now(),
+		Source:               models.SourceS3,
+		Host:                 i.host,
+		Label:                job.label,
+	}
+	fileInfo.MimeType = extensionMimeType(job.info.Name())
+
+	if checksum, ok := job.object.MD5(); ok && !job.downloadHash && i.hasher.Name() == "md5" {
+		fileInfo.Checksum = checksum
+		return fileInfo
+	}
+	if job.noRead {
+		return fileInfo
+	}
+
+	// Like local files, objects being hashed when a scan is cancelled are
+	// completed
+	object, err := i.s3.Open(context.Background(), job.bucket, job.object.Key)
+	if err == nil {
+		fileInfo.Checksum, err = hasher.HashReader(i.hasher, job.throttle.reader(object))
+		object.Close()
+	}
+	if err != nil {
+		i.logger.Warn("Error calculating checksum", "path", job.path, "err", err)
+	}
+	return fileInfo
+}
+
+// objectInfo describes an S3 object, or a prefix of its key, as a file
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (o objectInfo) Name() string       { return o.name }
+func (o objectInfo) Size() int64        { return o.size }
+func (o objectInfo) ModTime() time.Time { return o.modTime }
+func (o objectInfo) IsDir() bool        { return o.dir }
+func (o objectInfo) Sys() any           { return nil }
+
+func (o objectInfo) Mode() fs.FileMode {
+	if o.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
@@ -0,0 +1,47 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// Secrets returns the indexed files below root in which a scan with
+// ScanOptions.Secrets found secrets or personal data, ordered by path, with
+// their findings in the order of their lines. An empty root covers the whole
+// index.
+func (i *Indexer) Secrets(ctx context.Context, root string) ([]models.FileSecrets, error) {
+	var files []models.FileSecrets
+	if i.useDB {
+		var err error
+		if files, err = i.db.ListSecrets(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, file := range i.index.Files {
+			if len(file.Secrets) == 0 {
+				continue
+			}
+			findings := file.Secrets
+			file.Content, file.Secrets = "", nil
+			files = append(files, models.FileSecrets{File: file, Findings: findings})
+		}
+		sort.Slice(files, func(a, b int) bool { return files[a].File.Path < files[b].File.Path })
+	}
+	if root == "" {
+		return files, nil
+	}
+
+	root = filepath.Clean(absolutePath(root))
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	var below []models.FileSecrets
+	for _, file := range files {
+		if file.File.Path == root || strings.HasPrefix(file.File.Path, prefix) {
+			below = append(below, file)
+		}
+	}
+	return below, nil
+}
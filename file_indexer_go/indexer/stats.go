@@ -0,0 +1,203 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// Kinds of the figures of a statistics snapshot
+const (
+	StatsTotal        = "total"         // All files
+	StatsExtension    = "extension"     // Files per extension, named as in file_types
+	StatsMime         = "mime"          // Files per MIME type
+	StatsSize         = "size"          // Files per size bucket, named by its label
+	StatsDirectory    = "directory"     // Files below each top-level directory of the roots
+	StatsDuplicates   = "duplicates"    // Duplicate groups (name "groups", size = reclaimable space) and their files (name "files")
+	StatsTopDuplicate = "top_duplicate" // The groups wasting the most space, named by their first file: copies and wasted space
+	StatsSampled      = "sampled"       // Files that only have a sampled hash
+)
+
+// StatsKinds are the kinds of the figures of a statistics snapshot
+var StatsKinds = []string{StatsTotal, StatsExtension, StatsMime, StatsSize, StatsDirectory, StatsDuplicates, StatsTopDuplicate, StatsSampled}
+
+// GetStats returns statistics about the index, including the space wasted by
+// duplicates. With the DuckDB backend, the figures are those of the snapshot
+// taken at the end of the latest scan, stats_time tells when, so they take
+// no aggregation of the files; they are computed from the files when no
+// snapshot was taken yet and always with JSON storage.
+func (i *Indexer) GetStats(ctx context.Context) (map[string]interface{}, error) {
+	if !i.useDB {
+		return i.liveStats(ctx)
+	}
+	entries, err := i.db.LatestStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return i.liveStats(ctx)
+	}
+
+	stats := i.db.StatsMetadata(ctx)
+	applyStatsEntries(stats, entries)
+	stats["stats_time"] = entries[0].TakenAt
+	if stats["roots"], err = i.Roots(ctx); err != nil {
+		return nil, err
+	}
+	scan, err := i.Interruption(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scan != nil {
+		stats["interrupted_scan"] = *scan
+	}
+	return stats, nil
+}
+
+// RefreshStats computes the statistics of the index from its files and
+// stores them as a new snapshot, which GetStats then returns. Scans do this
+// when they complete.
+func (i *Indexer) RefreshStats(ctx context.Context) (map[string]interface{}, error) {
+	if i.readOnly {
+		return nil, ErrReadOnly
+	}
+	if !i.useDB {
+		return nil, fmt.Errorf("statistics snapshots require the DuckDB backend (-db)")
+	}
+	start := time.Now()
+	stats, err := i.liveStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	takenAt := time.Now().Truncate(time.Microsecond)
+	if err := i.db.RecordStats(ctx, takenAt, statsEntries(stats)); err != nil {
+		return nil, err
+	}
+	stats["stats_time"] = takenAt
+	i.logger.Info("Refreshed statistics", "duration", time.Since(start).Round(time.Millisecond))
+	return stats, nil
+}
+
+// StatsHistory returns one figure of every statistics snapshot, oldest
+// first, e.g. the totals (StatsTotal with an empty name) or the files of an
+// extension (StatsExtension and the extension)
+func (i *Indexer) StatsHistory(ctx context.Context, kind, name string) ([]models.StatsEntry, error) {
+	if !i.useDB {
+		return nil, fmt.Errorf("statistics snapshots require the DuckDB backend (-db)")
+	}
+	return i.db.StatsHistory(ctx, kind, name)
+}
+
+// topLevelDirectories returns the usage of the directories directly below
+// the roots of the index
+func (i *Indexer) topLevelDirectories(ctx context.Context) ([]models.DirectoryUsage, error) {
+	roots, err := i.Roots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dirs := []models.DirectoryUsage{}
+	for _, root := range roots {
+		usage, err := i.DirectoryUsage(ctx, root.Path, 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range usage {
+			if dir.Depth == 1 {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// statsEntries turns the aggregated statistics of liveStats into the figures
+// of a snapshot
+func statsEntries(stats map[string]interface{}) []models.StatsEntry {
+	totalFiles, _ := stats["total_files"].(int)
+	totalSize, _ := stats["total_size"].(int64)
+	entries := []models.StatsEntry{{Kind: StatsTotal, FileCount: int64(totalFiles), TotalSize: totalSize}}
+	if sampled, ok := stats["sampled_files"].(int); ok {
+		entries = append(entries, models.StatsEntry{Kind: StatsSampled, FileCount: int64(sampled)})
+	}
+	fileTypes, _ := stats["file_types"].(map[string]int)
+	for ext, count := range fileTypes {
+		entries = append(entries, models.StatsEntry{Kind: StatsExtension, Name: ext, FileCount: int64(count)})
+	}
+	mimeTypes, _ := stats["mime_types"].(map[string]int)
+	for mimeType, count := range mimeTypes {
+		entries = append(entries, models.StatsEntry{Kind: StatsMime, Name: mimeType, FileCount: int64(count)})
+	}
+	histogram, _ := stats["size_histogram"].([]models.SizeBucket)
+	for _, bucket := range histogram {
+		entries = append(entries, models.StatsEntry{Kind: StatsSize, Name: bucket.Label, FileCount: bucket.FileCount, TotalSize: bucket.TotalSize})
+	}
+	dirs, _ := stats["directories"].([]models.DirectoryUsage)
+	for _, dir := range dirs {
+		entries = append(entries, models.StatsEntry{Kind: StatsDirectory, Name: dir.Path, FileCount: dir.FileCount, TotalSize: dir.TotalSize})
+	}
+	if duplicates, ok := stats["duplicates"].(models.DuplicateSummary); ok {
+		entries = append(entries,
+			models.StatsEntry{Kind: StatsDuplicates, Name: "groups", FileCount: int64(duplicates.GroupCount), TotalSize: duplicates.ReclaimableSpace},
+			models.StatsEntry{Kind: StatsDuplicates, Name: "files", FileCount: int64(duplicates.DuplicateFiles)})
+		for _, group := range duplicates.TopGroups {
+			entries = append(entries, models.StatsEntry{Kind: StatsTopDuplicate, Name: group.Files[0].Location(),
+				FileCount: int64(group.Copies), TotalSize: group.WastedSpace})
+		}
+	}
+	return entries
+}
+
+// applyStatsEntries sets the aggregated statistics of liveStats from the
+// figures of a snapshot. The top duplicate groups only have their first
+// file, without a checksum.
+func applyStatsEntries(stats map[string]interface{}, entries []models.StatsEntry) {
+	fileTypes := make(map[string]int)
+	mimeTypes := make(map[string]int)
+	histogram := models.NewSizeHistogram()
+	dirs := []models.DirectoryUsage{}
+	duplicates := models.DuplicateSummary{TopGroups: []models.DuplicateGroup{}}
+	for _, entry := range entries {
+		switch entry.Kind {
+		case StatsTotal:
+			stats["total_files"], stats["total_size"] = int(entry.FileCount), entry.TotalSize
+		case StatsSampled:
+			stats["sampled_files"] = int(entry.FileCount)
+		case StatsExtension:
+			fileTypes[entry.Name] = int(entry.FileCount)
+		case StatsMime:
+			mimeTypes[entry.Name] = int(entry.FileCount)
+		case StatsSize:
+			for n := range histogram {
+				if histogram[n].Label == entry.Name {
+					histogram[n].FileCount, histogram[n].TotalSize = entry.FileCount, entry.TotalSize
+				}
+			}
+		case StatsDirectory:
+			dirs = append(dirs, models.DirectoryUsage{Path: entry.Name, Depth: 1, FileCount: entry.FileCount, TotalSize: entry.TotalSize})
+		case StatsDuplicates:
+			if entry.Name == "groups" {
+				duplicates.GroupCount, duplicates.ReclaimableSpace = int(entry.FileCount), entry.TotalSize
+			} else {
+				duplicates.DuplicateFiles = int(entry.FileCount)
+			}
+		case StatsTopDuplicate:
+			group := models.DuplicateGroup{Files: []models.FileInfo{{Path: entry.Name}}, Copies: int(entry.FileCount), WastedSpace: entry.TotalSize}
+			if group.Copies > 1 {
+				group.FileSize = group.WastedSpace / int64(group.Copies-1)
+			}
+			duplicates.TopGroups = append(duplicates.TopGroups, group)
+		}
+	}
+	sort.SliceStable(duplicates.TopGroups, func(a, b int) bool {
+		return duplicates.TopGroups[a].WastedSpace > duplicates.TopGroups[b].WastedSpace
+	})
+
+	stats["file_types"] = fileTypes
+	stats["mime_types"] = mimeTypes
+	stats["size_histogram"] = histogram
+	stats["directories"] = dirs
+	stats["duplicates"] = duplicates
+}
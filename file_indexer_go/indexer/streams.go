@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/fsmeta"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// scanStreams indexes the NTFS alternate data streams of a file, such as the
+// Zone.Identifier that marks downloads, passing a record for each to emit.
+// Streams are opened under their paths, as Windows allows.
+func (i *Indexer) scanStreams(job scanJob, filePath string, emit func(models.FileInfo)) {
+	streams, err := fsmeta.Streams(job.path)
+	if err != nil {
+		i.logger.Warn("Error listing alternate data streams", "path", job.path, "err", err)
+		return
+	}
+
+	for _, stream := range streams {
+		if job.maxFileSize > 0 && stream.Size > job.maxFileSize {
+			continue
+		}
+		fileInfo := models.FileInfo{
+			Path:                 models.StreamPath(filePath, stream.Name),
+			Filename:             models.StreamPath(filepath.Base(filePath), stream.Name),
+			ModificationDateTime: job.info.ModTime(),
+			FileSize:             stream.Size,
+			IndexedAt:            time.Now(),
+			Source:               models.SourceStream,
+			Host:                 i.host,
+			Label:                job.label,
+		}
+
+		streamPath := models.StreamPath(job.path, stream.Name)
+		mimeType, err := detectMimeType(i.readFS(job), streamPath)
+		if err != nil {
+			i.logger.Warn("Error detecting MIME type", "path", fileInfo.Path, "err", err)
+		}
+		fileInfo.MimeType = mimeType
+
+		err = i.retryTransient(streamPath, job.retries, func() (err error) {
+			fileInfo.Checksum, err = hasher.HashFile(i.hasher, i.readFS(job), streamPath)
+			return err
+		})
+		if err != nil {
+			i.logger.Warn("Error calculating checksum", "path", fileInfo.Path, "err", err)
+		}
+		emit(fileInfo)
+	}
+}
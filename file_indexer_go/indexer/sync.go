@@ -0,0 +1,174 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// SyncActionKind is what a sync plan does with a file
+type SyncActionKind string
+
+const (
+	SyncCopy   SyncActionKind = "copy"   // The content is in the source but nowhere in the target
+	SyncUpdate SyncActionKind = "update" // The same relative path has different content in the target
+	SyncMove   SyncActionKind = "move"   // The content is in the target, but only at other paths
+	SyncExtra  SyncActionKind = "extra"  // The content is only in the target
+)
+
+// SyncAction is one step of a sync plan. Source is nil for files only in the
+// target and Target is nil for files missing from it.
+type SyncAction struct {
+	Action      SyncActionKind   `json:"action"`
+	Path        string           `json:"path"`                   // Slash-separated path relative to the roots
+	TargetPaths []string         `json:"target_paths,omitempty"` // Where the target has the content of a moved file
+	Source      *models.FileInfo `json:"source,omitempty"`
+	Target      *models.FileInfo `json:"target,omitempty"`
+}
+
+// SyncPlan is what it takes to make a target directory a copy of a source
+// directory, as computed from their indexes
+type SyncPlan struct {
+	SourceRoot string       `json:"source_root"`
+	TargetRoot string       `json:"target_root"`
+	Actions    []SyncAction `json:"actions"`
+	InSync     int          `json:"in_sync"` // Files with the same content at the same path
+}
+
+// PlanSync compares the files below a directory of the source index with
+// those below a directory of the target index by relative path and checksum,
+// like a dry run of rsync that reads neither file system. An empty directory
+// selects the only root of its index. Both indexes must use the same checksum
+// algorithm; files without a checksum match only by path, size and
+// modification time. Entries of archives and alternate data streams are left
+// out. Actions are returned in path order.
+func PlanSync(ctx context.Context, source, target *Indexer, sourceDir, targetDir string) (SyncPlan, error) {
+	var plan SyncPlan
+	sourceAlgorithm, err := source.HashAlgorithm(ctx)
+	if err != nil {
+		return plan, err
+	}
+	targetAlgorithm, err := target.HashAlgorithm(ctx)
+	if err != nil {
+		return plan, err
+	}
+	if sourceAlgorithm != targetAlgorithm {
+		return plan, fmt.Errorf("the source uses %s checksums but the target uses %s", sourceAlgorithm, targetAlgorithm)
+	}
+
+	if plan.SourceRoot, err = syncRoot(ctx, source, sourceDir); err != nil {
+		return plan, fmt.Errorf("source: %v", err)
+	}
+	if plan.TargetRoot, err = syncRoot(ctx, target, targetDir); err != nil {
+		return plan, fmt.Errorf("target: %v", err)
+	}
+	sourceFiles, err := relativeFiles(ctx, source, plan.SourceRoot)
+	if err != nil {
+		return plan, err
+	}
+	targetFiles, err := relativeFiles(ctx, target, plan.TargetRoot)
+	if err != nil {
+		return plan, err
+	}
+
+	sourceContent := make(map[moveKey]bool)
+	for _, file := range sourceFiles {
+		if key, ok := contentKey(file, true); ok {
+			sourceContent[key] = true
+		}
+	}
+	targetContent := make(map[moveKey][]string)
+	for rel, file := range targetFiles {
+		if key, ok := contentKey(file, true); ok {
+			targetContent[key] = append(targetContent[key], rel)
+		}
+	}
+
+	for rel, sourceFile := range sourceFiles {
+		targetFile, ok := targetFiles[rel]
+		if ok {
+			if len(changedFields(sourceFile, targetFile, true)) == 0 || sameContent(sourceFile, targetFile) {
+				plan.InSync++
+			} else {
+				plan.Actions = append(plan.Actions, SyncAction{Action: SyncUpdate, Path: rel, Source: &sourceFile, Target: &targetFile})
+			}
+			continue
+		}
+		key, ok := contentKey(sourceFile, true)
+		if elsewhere := targetContent[key]; ok && len(elsewhere) > 0 {
+			sort.Strings(elsewhere)
+			plan.Actions = append(plan.Actions, SyncAction{Action: SyncMove, Path: rel, TargetPaths: elsewhere, Source: &sourceFile})
+			continue
+		}
+		plan.Actions = append(plan.Actions, SyncAction{Action: SyncCopy, Path: rel, Source: &sourceFile})
+	}
+
+	// Target files at paths of the source were compared above, and those
+	// whose content is in the source are the other end of a move
+	for rel, targetFile := range targetFiles {
+		if _, ok := sourceFiles[rel]; ok {
+			continue
+		}
+		if key, ok := contentKey(targetFile, true); ok && sourceContent[key] {
+			continue
+		}
+		plan.Actions = append(plan.Actions, SyncAction{Action: SyncExtra, Path: rel, Target: &targetFile})
+	}
+
+	sort.Slice(plan.Actions, func(a, b int) bool { return plan.Actions[a].Path < plan.Actions[b].Path })
+	return plan, nil
+}
+
+// sameContent reports whether two files of the same relative path have the
+// same checksum, regardless of their modification times
+func sameContent(a, b models.FileInfo) bool {
+	return a.Checksum != "" && a.Checksum == b.Checksum && a.FileSize == b.FileSize
+}
+
+// syncRoot returns the absolute directory of an index a sync plan compares:
+// dir, or the only root of the index if dir is empty
+func syncRoot(ctx context.Context, i *Indexer, dir string) (string, error) {
+	if dir != "" {
+		return filepath.Clean(absolutePath(dir)), nil
+	}
+	roots, err := i.Roots(ctx)
+	if err != nil {
+		return "", err
+	}
+	switch len(roots) {
+	case 0:
+		return "", fmt.Errorf("the index has no root directory")
+	case 1:
+		return roots[0].Path, nil
+	}
+	paths := make([]string, 0, len(roots))
+	for _, root := range roots {
+		paths = append(paths, root.Path)
+	}
+	return "", fmt.Errorf("the index has several roots (%s); choose one", strings.Join(paths, ", "))
+}
+
+// relativeFiles returns the local files below root by slash-separated path
+// relative to it
+func relativeFiles(ctx context.Context, i *Indexer, root string) (map[string]models.FileInfo, error) {
+	files, err := i.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return nil, err
+	}
+	below := make(map[string]models.FileInfo)
+	for _, file := range files {
+		if file.Source != "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, file.Path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		below[filepath.ToSlash(rel)] = file
+	}
+	return below, nil
+}
@@ -0,0 +1,122 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// TagFile tags an indexed file, and with it all files of the same checksum.
+// The tag is stored by checksum, so it survives moves and rescans. Call
+// SaveIndex afterwards to persist a JSON index.
+func (i *Indexer) TagFile(ctx context.Context, path, tag string) (models.FileInfo, error) {
+	if i.readOnly {
+		return models.FileInfo{}, ErrReadOnly
+	}
+	file, tag, err := i.taggedFile(ctx, path, tag)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+
+	record := models.FileTag{Checksum: file.Checksum, Tag: tag, TaggedAt: time.Now()}
+	if i.useDB {
+		return file, i.db.AddTag(ctx, record)
+	}
+	if !slices.ContainsFunc(i.index.Tags, func(stored models.FileTag) bool {
+		return stored.Checksum == record.Checksum && stored.Tag == record.Tag
+	}) {
+		i.index.Tags = append(i.index.Tags, record)
+	}
+	return file, nil
+}
+
+// UntagFile removes a tag from an indexed file and the other files of its
+// checksum, and reports whether they had it. Call SaveIndex afterwards to
+// persist a JSON index.
+func (i *Indexer) UntagFile(ctx context.Context, path, tag string) (bool, error) {
+	if i.readOnly {
+		return false, ErrReadOnly
+	}
+	file, tag, err := i.taggedFile(ctx, path, tag)
+	if err != nil {
+		return false, err
+	}
+
+	if i.useDB {
+		return i.db.RemoveTag(ctx, file.Checksum, tag)
+	}
+	count := len(i.index.Tags)
+	i.index.Tags = slices.DeleteFunc(i.index.Tags, func(stored models.FileTag) bool {
+		return stored.Checksum == file.Checksum && stored.Tag == tag
+	})
+	return len(i.index.Tags) < count, nil
+}
+
+// Tags returns all user tags, ordered by tag and checksum. Tags of checksums
+// that no indexed file has any more are kept, for when the file is indexed
+// again.
+func (i *Indexer) Tags(ctx context.Context) ([]models.FileTag, error) {
+	if i.useDB {
+		return i.db.ListTags(ctx)
+	}
+	tags := slices.Clone(i.index.Tags)
+	sort.Slice(tags, func(a, b int) bool {
+		if tags[a].Tag != tags[b].Tag {
+			return tags[a].Tag < tags[b].Tag
+		}
+		return tags[a].Checksum < tags[b].Checksum
+	})
+	return tags, nil
+}
+
+// taggedFile looks up the indexed file at path and the stored form of a tag
+// to put on it or remove from it
+func (i *Indexer) taggedFile(ctx context.Context, path, tag string) (models.FileInfo, string, error) {
+	tag = models.NormalizeTag(tag)
+	if tag == "" {
+		return models.FileInfo{}, "", fmt.Errorf("empty tag")
+	}
+	path = absolutePath(path)
+	file, err := i.GetFileByPathAndFilename(ctx, path, filepath.Base(path))
+	if err != nil {
+		return models.FileInfo{}, "", err
+	}
+	if file == nil {
+		return models.FileInfo{}, "", fmt.Errorf("%s is not indexed", path)
+	}
+	if file.Checksum == "" {
+		return models.FileInfo{}, "", fmt.Errorf("%s has no checksum to tag", path)
+	}
+	return *file, tag, nil
+}
+
+// withUserTags returns a query of the JSON index whose Tag filter also
+// matches the files tagged by the user. A streamed index has its tags read
+// from the start of its file.
+func (i *Indexer) withUserTags(query models.FileQuery) (models.FileQuery, error) {
+	if query.Tag == "" {
+		return query, nil
+	}
+	tags := i.index.Tags
+	if i.streamed {
+		header, err := i.streamedHeader()
+		if err != nil {
+			return query, err
+		}
+		tags = header.Tags
+	}
+
+	tag := models.NormalizeTag(query.Tag)
+	query.TaggedChecksums = make(map[string]bool)
+	for _, stored := range tags {
+		if stored.Tag == tag {
+			query.TaggedChecksums[stored.Checksum] = true
+		}
+	}
+	return query, nil
+}
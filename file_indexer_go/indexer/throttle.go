@@ -0,0 +1,149 @@
+package indexer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// rateLimiter spaces out units of work, such as bytes or files, so that no
+// more than rate of them are handed out per second on average. Each request
+// waits until the requests before it are paid off, so there are no bursts.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64   // units per second
+	next time.Time // when the units handed out so far are paid off
+}
+
+// newRateLimiter creates a limiter, or returns nil for a rate of 0, which
+// does not limit
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// reserve hands out n units and returns how long to wait before using them
+func (l *rateLimiter) reserve(n int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	return start.Sub(now)
+}
+
+// wait blocks until n units may be used or ctx is cancelled. A nil limiter
+// does not wait.
+func (l *rateLimiter) wait(ctx context.Context, n int64) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	delay := l.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttle limits the files indexed and the bytes read per second by the
+// workers of a scan, so a background scan leaves I/O for other programs
+type throttle struct {
+	files *rateLimiter
+	bytes *rateLimiter
+	fsys  source.FS // the scanned filesystem, with reads limited by bytes
+}
+
+// newThrottle creates the throttle of a scan of fsys, or returns nil if the
+// options set no limits
+func newThrottle(fsys source.FS, opts ScanOptions) *throttle {
+	if opts.ThrottleFiles <= 0 && opts.ThrottleBytes <= 0 {
+		return nil
+	}
+	t := &throttle{
+		files: newRateLimiter(opts.ThrottleFiles),
+		bytes: newRateLimiter(float64(opts.ThrottleBytes)),
+		fsys:  fsys,
+	}
+	if t.bytes != nil {
+		t.fsys = throttledFS{FS: fsys, limiter: t.bytes}
+	}
+	return t
+}
+
+// reader limits the reads from r
+func (t *throttle) reader(r io.Reader) io.Reader {
+	if t == nil || t.bytes == nil {
+		return r
+	}
+	return throttledReader{r: r, limiter: t.bytes}
+}
+
+// readFS returns the filesystem a job reads its file from: the scanned one,
+// throttled if the scan is
+func (i *Indexer) readFS(job scanJob) source.FS {
+	if job.throttle == nil {
+		return i.fsys
+	}
+	return job.throttle.fsys
+}
+
+// throttledFS limits the bytes read from the files it opens. Each read is
+// charged after it returns, so the reads of a file go at most one buffer
+// ahead of the limit.
+type throttledFS struct {
+	source.FS
+	limiter *rateLimiter
+}
+
+func (t throttledFS) Open(name string) (source.File, error) {
+	file, err := t.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return throttledFile{File: file, limiter: t.limiter}, nil
+}
+
+// throttledFile is a file opened by throttledFS
+type throttledFile struct {
+	source.File
+	limiter *rateLimiter
+}
+
+func (f throttledFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.limiter.wait(context.Background(), int64(n))
+	return n, err
+}
+
+func (f throttledFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.limiter.wait(context.Background(), int64(n))
+	return n, err
+}
+
+// throttledReader limits the reads from a stream, like throttledFile
+type throttledReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limiter.wait(context.Background(), int64(n))
+	return n, err
+}
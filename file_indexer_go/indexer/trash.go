@@ -0,0 +1,219 @@
+package indexer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/fsmeta"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// actionUndo is the action of the audit log entries of files put back by
+// UndoRun
+const actionUndo = "undo"
+
+// TrashOptions controls where deleted duplicates go
+type TrashOptions struct {
+	// Dir is the trash directory deleted duplicates are moved into, in a
+	// subdirectory per run with the manifest of a quarantine directory, so
+	// UndoRun can put them back. Empty deletes them for good.
+	Dir string
+	// Expiry is the age after which the runs in the trash are emptied by the
+	// next run that deletes duplicates (0 = keep them)
+	Expiry time.Duration
+	// SameFilesystem refuses duplicates on another filesystem than Dir,
+	// which moving them into it would copy without freeing any space. It
+	// is set for a trash that was not chosen for the duplicates.
+	SameFilesystem bool
+}
+
+// newRunID returns the ID of a dedupe run that starts now, which is also the
+// name of its directory in the trash. The random suffix keeps runs started in
+// the same second apart.
+func newRunID() string {
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// checkTrashFilesystem checks that a duplicate is on the filesystem of the
+// trash. The trash may not exist yet, in which case the closest of its
+// parents that does is checked. Platforms without device numbers leave the
+// duplicate to be moved.
+func checkTrashFilesystem(trash, duplicate string) error {
+	duplicateInfo, err := os.Lstat(duplicate)
+	if err != nil {
+		return err
+	}
+	dir := trash
+	trashInfo, err := os.Stat(dir)
+	for errors.Is(err, fs.ErrNotExist) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+		trashInfo, err = os.Stat(dir)
+	}
+	if err != nil {
+		return err
+	}
+	trashID, ok := fsmeta.FileID(trashInfo)
+	duplicateID, duplicateOK := fsmeta.FileID(duplicateInfo)
+	if ok && duplicateOK && trashID.Device != duplicateID.Device {
+		return fmt.Errorf("on a different filesystem than the trash %s, where moving it would free no space", trash)
+	}
+	return nil
+}
+
+// expireTrash empties the runs in the trash whose files were all moved there
+// longer ago than the expiry. Failures are only logged, as they do not keep
+// duplicates from being deleted.
+func (i *Indexer) expireTrash(trash TrashOptions) {
+	if trash.Dir == "" || trash.Expiry <= 0 {
+		return
+	}
+	runs, err := os.ReadDir(trash.Dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			i.logger.Warn("Error reading the trash", "dir", trash.Dir, "err", err)
+		}
+		return
+	}
+	cutoff := time.Now().Add(-trash.Expiry)
+	for _, run := range runs {
+		dir := filepath.Join(trash.Dir, run.Name())
+		entries, err := readManifest(dir)
+		if err != nil {
+			// Only runs of the trash are emptied, never other directories
+			continue
+		}
+		expired := true
+		var size int64
+		for _, entry := range entries {
+			expired = expired && entry.MovedAt.Before(cutoff)
+			size += entry.File.FileSize
+		}
+		if !expired {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			i.logger.Warn("Error emptying expired run from the trash", "dir", dir, "err", err)
+			continue
+		}
+		i.logger.Info("Emptied expired run from the trash", "run", run.Name(), "files", len(entries), "size", size)
+	}
+}
+
+// UndoRun puts the duplicates that a dedupe or review run moved into the
+// trash or a quarantine directory back where they were, and adds them to the
+// index again. Links cannot be undone, and neither can deletions made without
+// a trash or emptied from it. Call SaveIndex afterwards.
+func (i *Indexer) UndoRun(ctx context.Context, runID string, dryRun bool) ([]RestoreResult, error) {
+	if i.readOnly && !dryRun {
+		return nil, ErrReadOnly
+	}
+	records, err := i.Actions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found, linked bool
+	undone := make(map[string]bool)
+	moved := make(map[string]map[string]bool) // trash directory -> paths moved into it
+	for _, record := range records {
+		switch {
+		case record.RunID != runID:
+		case record.Action == actionUndo:
+			undone[record.Path] = true
+		case record.Trash == "":
+			found, linked = true, true
+		default:
+			found = true
+			if moved[record.Trash] == nil {
+				moved[record.Trash] = make(map[string]bool)
+			}
+			moved[record.Trash][record.Path] = true
+		}
+	}
+	pending := 0
+	for _, paths := range moved {
+		for path := range paths {
+			if undone[path] {
+				delete(paths, path)
+			}
+		}
+		pending += len(paths)
+	}
+	switch {
+	case !found:
+		return nil, fmt.Errorf("no dedupe run %s in the audit log (see the actions command)", runID)
+	case len(moved) == 0 && linked:
+		return nil, fmt.Errorf("run %s linked or deleted its duplicates without a trash, which cannot be undone", runID)
+	case pending == 0:
+		return nil, fmt.Errorf("run %s was already undone", runID)
+	}
+
+	var results []RestoreResult
+	for dir, paths := range moved {
+		if len(paths) == 0 {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, QuarantineManifest)); errors.Is(err, fs.ErrNotExist) {
+			return results, fmt.Errorf("the files of run %s are no longer in %s; the trash may have expired", runID, dir)
+		}
+		restored, err := i.restoreQuarantine(ctx, dir, dryRun, func(entry QuarantineEntry) bool { return paths[entry.Path] })
+		if !dryRun {
+			removeEmptyDirs(dir)
+		}
+		for _, result := range restored {
+			if result.Done {
+				i.recordUndo(ctx, runID, result)
+			}
+		}
+		results = append(results, restored...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// removeEmptyDirs removes the directories of a tree that are left empty,
+// once all files of a run were put back
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	// Subdirectories come after their parents, so remove them first
+	for n := len(dirs) - 1; n >= 0; n-- {
+		os.Remove(dirs[n])
+	}
+}
+
+// recordUndo appends a file put back by UndoRun to the audit log
+func (i *Indexer) recordUndo(ctx context.Context, runID string, result RestoreResult) {
+	record := models.ActionRecord{
+		PerformedAt: time.Now(),
+		Action:      actionUndo,
+		Path:        result.Path,
+		Original:    result.QuarantinePath,
+		Checksum:    result.Checksum,
+		FileSize:    result.Size,
+		Host:        i.host,
+		RunID:       runID,
+	}
+	if !i.useDB {
+		i.index.Actions = append(i.index.Actions, record)
+		return
+	}
+	if err := i.db.RecordAction(ctx, record); err != nil {
+		i.logger.Warn("Error recording undo", "path", result.Path, "err", err)
+	}
+}
@@ -0,0 +1,121 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+func TestUndoRunErrors(t *testing.T) {
+	ctx := context.Background()
+	idx, _ := dedupeFixture(t, false)
+
+	if _, err := idx.UndoRun(ctx, "20240501-120000-0000", false); err == nil || !strings.Contains(err.Error(), "no dedupe run") {
+		t.Errorf("UndoRun of an unknown run = %v", err)
+	}
+
+	results, err := idx.Dedupe(ctx, DedupeOptions{Action: DedupeHardlink})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Dedupe = %+v, %v", results, err)
+	}
+	if _, err := idx.UndoRun(ctx, results[0].RunID, false); err == nil || !strings.Contains(err.Error(), "cannot be undone") {
+		t.Errorf("UndoRun of a linking run = %v", err)
+	}
+}
+
+func TestUndoRunTwice(t *testing.T) {
+	ctx := context.Background()
+	idx, root := dedupeFixture(t, false)
+	trash := filepath.Join(t.TempDir(), "trash")
+	results, err := idx.Dedupe(ctx, DedupeOptions{Action: DedupeDelete, Trash: TrashOptions{Dir: trash}})
+	if err != nil || len(results) != 1 || !results[0].Done {
+		t.Fatalf("Dedupe = %+v, %v", results, err)
+	}
+	runID := results[0].RunID
+	if _, err := os.Stat(filepath.Join(trash, runID, QuarantineManifest)); err != nil {
+		t.Fatalf("the run has no directory in the trash: %v", err)
+	}
+
+	dryRun, err := idx.UndoRun(ctx, runID, true)
+	if err != nil || len(dryRun) != 1 || dryRun[0].Done {
+		t.Fatalf("UndoRun dry run = %+v, %v", dryRun, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b/duplicate.txt")); !os.IsNotExist(err) {
+		t.Fatalf("a dry run put the duplicate back: %v", err)
+	}
+
+	if restored, err := idx.UndoRun(ctx, runID, false); err != nil || len(restored) != 1 || !restored[0].Done {
+		t.Fatalf("UndoRun = %+v, %v", restored, err)
+	}
+	if _, err := os.Stat(filepath.Join(trash, runID)); !os.IsNotExist(err) {
+		t.Errorf("the emptied run is still in the trash: %v", err)
+	}
+	if _, err := idx.UndoRun(ctx, runID, false); err == nil || !strings.Contains(err.Error(), "already undone") {
+		t.Errorf("UndoRun of an undone run = %v", err)
+	}
+}
+
+func TestExpireTrash(t *testing.T) {
+	trash := t.TempDir()
+	now := time.Now()
+	run := func(name string, movedAt ...time.Time) string {
+		dir := filepath.Join(trash, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var entries []QuarantineEntry
+		for n, at := range movedAt {
+			path := filepath.Join(dir, "file"+string(rune('a'+n)))
+			entries = append(entries, QuarantineEntry{MovedAt: at, Path: path, QuarantinePath: path, File: models.FileInfo{FileSize: 1}})
+		}
+		if err := writeManifest(dir, entries); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	expired := run("expired", now.Add(-48*time.Hour), now.Add(-25*time.Hour))
+	partly := run("partly", now.Add(-48*time.Hour), now.Add(-time.Hour))
+	recent := run("recent", now.Add(-time.Hour))
+	other := filepath.Join(trash, "other")
+	if err := os.Mkdir(other, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := newTestIndexer(t, false, nil)
+	idx.expireTrash(TrashOptions{Dir: trash})
+	if _, err := os.Stat(expired); err != nil {
+		t.Fatalf("a trash without an expiry was emptied: %v", err)
+	}
+	idx.expireTrash(TrashOptions{Dir: trash, Expiry: 24 * time.Hour})
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("the expired run is still in the trash: %v", err)
+	}
+	for _, kept := range []string{partly, recent, other} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("%s was emptied: %v", filepath.Base(kept), err)
+		}
+	}
+	// A missing trash has nothing to expire
+	idx.expireTrash(TrashOptions{Dir: filepath.Join(trash, "missing"), Expiry: time.Hour})
+}
+
+func TestCheckTrashFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	duplicate := filepath.Join(dir, "duplicate.txt")
+	if err := os.WriteFile(duplicate, []byte("duplicate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The trash and its parent do not exist yet, so the directory above
+	// them is checked
+	if err := checkTrashFilesystem(filepath.Join(dir, "index.trash", "run"), duplicate); err != nil {
+		t.Errorf("checkTrashFilesystem on the same filesystem: %v", err)
+	}
+	if err := checkTrashFilesystem(dir, filepath.Join(dir, "missing.txt")); err == nil {
+		t.Errorf("checkTrashFilesystem accepted a missing duplicate")
+	}
+}
@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// DirectoryUsage returns the number and total size of the indexed files below
+// root, rolled up per directory down to depth levels (like du --max-depth).
+// A negative depth means no limit. Only the index is consulted, not the
+// filesystem. Directories are returned in path order, starting with root.
+func (i *Indexer) DirectoryUsage(ctx context.Context, root string, depth int) ([]models.DirectoryUsage, error) {
+	root = filepath.Clean(absolutePath(root))
+	if i.useDB {
+		return i.db.DirectoryUsage(ctx, root, depth)
+	}
+	return i.directoryUsageJSON(root, depth), nil
+}
+
+// directoryUsageJSON aggregates directory usage from the JSON index
+func (i *Indexer) directoryUsageJSON(root string, depth int) []models.DirectoryUsage {
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	dirs := make(map[string]*models.DirectoryUsage)
+
+	for _, file := range i.index.Files {
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+
+		// Walk up from the file's directory, counting it towards each
+		// ancestor that is within depth of root
+		parts := strings.Split(strings.TrimPrefix(file.Path, prefix), string(filepath.Separator))
+		levels := len(parts) - 1
+		if depth >= 0 && levels > depth {
+			levels = depth
+		}
+		for level := 0; level <= levels; level++ {
+			dir := filepath.Join(append([]string{root}, parts[:level]...)...)
+			entry, ok := dirs[dir]
+			if !ok {
+				entry = &models.DirectoryUsage{Path: dir, Depth: level}
+				dirs[dir] = entry
+			}
+			entry.FileCount++
+			entry.TotalSize += file.FileSize
+		}
+	}
+
+	usage := make([]models.DirectoryUsage, 0, len(dirs))
+	for _, entry := range dirs {
+		usage = append(usage, *entry)
+	}
+	sort.Slice(usage, func(a, b int) bool { return usage[a].Path < usage[b].Path })
+	return usage
+}
+
+// LanguageUsage sums up the source files below root per programming
+// language, from the line counts recorded by scans with
+// ScanOptions.CodeMetrics. An empty root covers the whole index. Languages
+// with the most lines of code come first.
+func (i *Indexer) LanguageUsage(ctx context.Context, root string) ([]models.LanguageUsage, error) {
+	if root != "" {
+		root = filepath.Clean(absolutePath(root))
+	}
+	if i.useDB {
+		return i.db.LanguageUsage(ctx, root)
+	}
+
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	languages := make(map[string]*models.LanguageUsage)
+	for _, file := range i.index.Files {
+		if file.Language == "" || (root != "" && file.Path != root && !strings.HasPrefix(file.Path, prefix)) {
+			continue
+		}
+		entry, ok := languages[file.Language]
+		if !ok {
+			entry = &models.LanguageUsage{Language: file.Language}
+			languages[file.Language] = entry
+		}
+		entry.FileCount++
+		entry.Lines += int64(file.LineCount)
+		entry.CodeLines += int64(file.CodeLines)
+		entry.CommentLines += int64(file.CommentLines)
+		entry.BlankLines += int64(file.LineCount - file.CodeLines - file.CommentLines)
+		entry.TotalSize += file.FileSize
+	}
+
+	usage := make([]models.LanguageUsage, 0, len(languages))
+	for _, entry := range languages {
+		usage = append(usage, *entry)
+	}
+	sort.Slice(usage, func(a, b int) bool {
+		if usage[a].CodeLines != usage[b].CodeLines {
+			return usage[a].CodeLines > usage[b].CodeLines
+		}
+		return usage[a].Language < usage[b].Language
+	})
+	return usage, nil
+}
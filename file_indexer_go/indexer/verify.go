@@ -0,0 +1,155 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io/fs"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/hasher"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// VerifyStatus is the outcome of re-checking one indexed file
+type VerifyStatus string
+
+const (
+	VerifyOK        VerifyStatus = "ok"        // Content matches the index
+	VerifyCorrupted VerifyStatus = "corrupted" // Same size and mtime, different content: likely bit rot
+	VerifyChanged   VerifyStatus = "changed"   // Size or mtime differ, so the file was modified normally
+	VerifyMissing   VerifyStatus = "missing"   // The file no longer exists
+	VerifyFailed    VerifyStatus = "failed"    // The file could not be read
+	VerifySkipped   VerifyStatus = "skipped"   // The index has no full checksum for the file, or it is not local
+	VerifyMismatch  VerifyStatus = "mismatch"  // The checksum differs from the one of a manifest
+)
+
+// VerifyOptions controls a verification run
+type VerifyOptions struct {
+	Workers int             // Number of concurrent checksum workers
+	Percent float64         // Share of files to check, 0 < Percent <= 100 (0 = all)
+	Seed    int64           // Selects which files are sampled; the same seed picks the same files
+	Done    map[string]bool // Paths already verified by an interrupted run, skipped
+}
+
+// VerifyResult describes the outcome for one file
+type VerifyResult struct {
+	File   models.FileInfo
+	Status VerifyStatus
+	Err    error // Why the file could not be read
+}
+
+// Verify re-reads indexed files and compares their checksums with the index.
+// Results are passed to report one at a time, in no particular order. The
+// index itself is not modified. If ctx is cancelled, no further files are
+// checked and ctx's error is returned.
+func (i *Indexer) Verify(ctx context.Context, opts VerifyOptions, report func(VerifyResult)) error {
+	if err := i.useStoredHasher(ctx); err != nil {
+		return err
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	files, err := i.ListFiles(ctx, models.FileQuery{})
+	if err != nil {
+		return err
+	}
+
+	jobs := make(chan models.FileInfo)
+	results := make(chan VerifyResult)
+
+	var wg sync.WaitGroup
+	for n := 0; n < opts.Workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				results <- i.verifyFile(file)
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			if opts.Done[file.Path] || !sampled(file.Path, opts.Percent, opts.Seed) {
+				continue
+			}
+			jobs <- file
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		report(result)
+	}
+	return ctx.Err()
+}
+
+// verifyFile re-hashes a single file and classifies the result. Files with
+// only a sampled hash are checked by sampling them again.
+func (i *Indexer) verifyFile(file models.FileInfo) VerifyResult {
+	result := VerifyResult{File: file}
+	expected := file.Checksum
+	if expected == "" {
+		expected = file.SampleHash
+	}
+	if expected == "" || !i.isLocal(file) {
+		result.Status = VerifySkipped
+		return result
+	}
+
+	info, err := i.fsys.Stat(file.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		result.Status = VerifyMissing
+		return result
+	}
+	if err != nil {
+		result.Status, result.Err = VerifyFailed, err
+		return result
+	}
+
+	var checksum string
+	if file.Checksum != "" {
+		checksum, err = i.calculateChecksum(file.Path)
+	} else {
+		checksum, err = hasher.SampleHashFile(i.hasher, i.fsys, file.Path)
+	}
+	if err != nil {
+		result.Status, result.Err = VerifyFailed, err
+		return result
+	}
+
+	// DuckDB keeps microseconds, so compare modification times at that precision
+	unchanged := info.Size() == file.FileSize &&
+		info.ModTime().Truncate(time.Microsecond).Equal(file.ModificationDateTime.Truncate(time.Microsecond))
+	switch {
+	case checksum == expected:
+		result.Status = VerifyOK
+	case unchanged:
+		result.Status = VerifyCorrupted
+	default:
+		result.Status = VerifyChanged
+	}
+	return result
+}
+
+// sampled reports whether a path belongs to the sample of percent of all
+// files chosen by seed. The choice depends only on the path and seed, so a
+// resumed run checks the same files.
+func sampled(path string, percent float64, seed int64) bool {
+	if percent <= 0 || percent >= 100 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(seed, 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	return float64(h.Sum64()%10000) < percent*100
+}
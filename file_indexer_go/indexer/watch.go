@@ -0,0 +1,306 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchOptions controls continuous indexing
+type WatchOptions struct {
+	ScanOptions
+	Debounce time.Duration // Quiet period before accumulated changes are applied
+
+	// Applied is called after the initial scan and after each batch of
+	// changes has been applied and saved, e.g. to fire hooks
+	Applied func(ctx context.Context)
+}
+
+// watchSession holds the state of a running watch
+type watchSession struct {
+	indexer *Indexer
+	opts    WatchOptions
+	filter  *walkFilter
+	watcher *fsnotify.Watcher
+	watched map[string]bool
+}
+
+// Watch indexes rootPath and then keeps the index up to date by applying
+// filesystem events until ctx is cancelled. Events are debounced, so bursts
+// of changes are applied as a single batch.
+func (i *Indexer) Watch(ctx context.Context, rootPath string, opts WatchOptions) error {
+	if i.fsys != source.OS || source.IsS3URL(rootPath) {
+		return fmt.Errorf("only the local filesystem can be watched")
+	}
+	rootPath = i.canonicalRoot(rootPath)
+	if opts.Debounce <= 0 {
+		opts.Debounce = 2 * time.Second
+	}
+
+	// Start from a complete index so later events only need to be applied.
+	// An interrupted initial scan is saved before returning.
+	scanErr := i.IndexDirectory(ctx, rootPath, opts.ScanOptions)
+	if scanErr != nil && ctx.Err() == nil {
+		return scanErr
+	}
+	if err := i.SaveIndex(); err != nil {
+		return err
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	if opts.Applied != nil {
+		opts.Applied(ctx)
+	}
+
+	walkFilter, err := newWalkFilter(source.OS, rootPath, opts.ScanOptions, i.logger)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	session := &watchSession{
+		indexer: i,
+		opts:    opts,
+		filter:  walkFilter,
+		watcher: watcher,
+		watched: make(map[string]bool),
+	}
+	if err := session.addTree(rootPath); err != nil {
+		return err
+	}
+	i.logger.Info("Watching for changes", "dir", rootPath, "directories", len(session.watched))
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(opts.Debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Apply what has accumulated, even though ctx is done
+			if len(pending) > 0 {
+				if err := session.apply(context.WithoutCancel(ctx), pending); err != nil {
+					return err
+				}
+			}
+			i.logger.Info("Stopped watching", "dir", rootPath)
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			pending[event.Name] = true
+			timer.Reset(opts.Debounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			i.logger.Error("Watcher error", "err", err)
+
+		case <-timer.C:
+			if err := session.apply(ctx, pending); err != nil {
+				return err
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// addTree registers watches for a directory and all accepted subdirectories
+func (s *watchSession) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			s.indexer.logger.Warn("Error accessing path", "path", path, "err", err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if s.filter.shouldSkipDir(path, d) || s.filter.outOfReach(path, d) {
+			return fs.SkipDir
+		}
+		s.filter.enterDir(path)
+
+		if err := s.watcher.Add(path); err != nil {
+			s.indexer.logger.Warn("Error watching directory", "path", path, "err", err)
+			return nil
+		}
+		s.watched[path] = true
+		return nil
+	})
+}
+
+// forget drops a removed directory and its subdirectories from the watch list
+func (s *watchSession) forget(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path := range s.watched {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(s.watched, path)
+		}
+	}
+}
+
+// apply brings the index in line with the current state of the changed paths.
+// Paths that cannot be read are logged and skipped; storage errors are
+// returned, since the index can no longer be kept up to date.
+func (s *watchSession) apply(ctx context.Context, pending map[string]bool) error {
+	paths := make([]string, 0, len(pending))
+	for path := range pending {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	writer := s.indexer.newFileWriter(ctx)
+	added, removed, err := s.applyPaths(ctx, paths, writer)
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if s.opts.QuickHash {
+		if err := s.indexer.resolveQuickHashCollisions(ctx, s.opts.ScanOptions); err != nil {
+			return err
+		}
+	}
+	if s.opts.ConfirmSampled {
+		if err := s.indexer.confirmSampleHashCollisions(ctx, s.opts.ScanOptions); err != nil {
+			return err
+		}
+	}
+
+	if err := s.indexer.SaveIndex(); err != nil {
+		return err
+	}
+	s.indexer.logger.Info("Applied changes", "changes", len(paths), "updated", added, "removed", removed)
+	if s.opts.Applied != nil {
+		s.opts.Applied(ctx)
+	}
+	return nil
+}
+
+// applyPaths updates the index for the changed paths through writer. Files
+// queued before a removal are stored first, so a path removed after being
+// queued does not come back.
+func (s *watchSession) applyPaths(ctx context.Context, paths []string, writer *fileWriter) (int, int, error) {
+	store := writer.Write
+	removePath := func(path string, vanished bool) error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if vanished {
+			if err := s.indexer.journalRemoval(ctx, absolutePath(path)); err != nil {
+				return err
+			}
+		}
+		return s.indexer.removePath(ctx, absolutePath(path))
+	}
+	var added, removed int
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			// The path is gone: drop it, and everything below it if it was a directory
+			if s.watched[path] {
+				s.forget(path)
+			}
+			if err := removePath(path, true); err != nil {
+				return added, removed, fmt.Errorf("error removing %s from index: %v", path, err)
+			}
+			removed++
+			continue
+		}
+
+		d, _ := s.filter.followSymlink(path, fs.FileInfoToDirEntry(info))
+		if d.IsDir() {
+			// Existing directories are already covered by their own events
+			if s.watched[path] || s.filter.shouldSkipDir(path, d) || s.filter.outOfReach(path, d) {
+				continue
+			}
+			if err := s.addTree(path); err != nil {
+				s.indexer.logger.Warn("Error watching directory", "path", path, "err", err)
+			}
+			err := s.indexer.scanWithFilter(ctx, path, s.filter, s.opts.ScanOptions, func(fileInfo models.FileInfo) error {
+				added++
+				return store(fileInfo)
+			})
+			if err != nil {
+				return added, removed, fmt.Errorf("error indexing new directory %s: %v", path, err)
+			}
+			continue
+		}
+
+		fileInfo, ok := s.filter.accept(path, d, s.opts.MinFileSize, s.opts.MaxFileSize)
+		if !ok {
+			continue
+		}
+		job := s.indexer.newScanJob(path, fileInfo, s.opts.ScanOptions)
+		if job.archive || job.streams {
+			// Entries and streams are indexed afresh, dropping those that are gone
+			if err := removePath(path, false); err != nil {
+				return added, removed, fmt.Errorf("error removing entries of %s from index: %v", path, err)
+			}
+		}
+		stored := s.indexer.buildFileInfo(job)
+		if err := store(stored); err != nil {
+			return added, removed, err
+		}
+		added++
+		if job.archive {
+			var entryErr error
+			s.indexer.scanArchive(job, stored.Path, func(entry models.FileInfo) {
+				if entryErr == nil {
+					entryErr = store(entry)
+				}
+			})
+			if entryErr != nil {
+				return added, removed, entryErr
+			}
+		}
+		if job.streams {
+			var streamErr error
+			s.indexer.scanStreams(job, stored.Path, func(stream models.FileInfo) {
+				if streamErr == nil {
+					streamErr = store(stream)
+				}
+			})
+			if streamErr != nil {
+				return added, removed, streamErr
+			}
+		}
+	}
+
+	return added, removed, nil
+}
+
+// absolutePath returns the absolute form of path, falling back to path itself
+func absolutePath(path string) string {
+	if source.IsS3URL(path) {
+		return strings.TrimSuffix(path, "/")
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return absPath
+}
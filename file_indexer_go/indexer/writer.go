@@ -0,0 +1,142 @@
+package indexer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// writeBatchSize is the largest number of files a fileWriter stores at once
+const writeBatchSize = 1000
+
+// writeQueueSize is the number of files that may wait for the writer before
+// producers block
+const writeQueueSize = 4 * writeBatchSize
+
+// fileWriter stores the files of an operation from a goroutine of its own,
+// so the backend is only ever written from one place. Files are queued on a
+// buffered channel, which blocks producers while the backend falls behind,
+// and stored in batches when they arrive faster than single rows can be
+// written. The backend retries writes that fail transiently; after a storage
+// error, further files are dropped and the error is returned by Write, Flush
+// and Close.
+type fileWriter struct {
+	indexer *Indexer
+	ctx     context.Context
+	queue   chan writeRequest
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// writeRequest is a file to store, or a request to report once all files
+// queued before it are stored
+type writeRequest struct {
+	file    models.FileInfo
+	flushed chan error
+}
+
+// newFileWriter starts the writer of an operation. ctx is used for storing
+// and should not be cancelled before the writer is closed, so files indexed
+// before a cancellation are still stored.
+func (i *Indexer) newFileWriter(ctx context.Context) *fileWriter {
+	w := &fileWriter{
+		indexer: i,
+		ctx:     ctx,
+		queue:   make(chan writeRequest, writeQueueSize),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a file to be stored, blocking while the queue is full
+func (w *fileWriter) Write(file models.FileInfo) error {
+	if err := w.failure(); err != nil {
+		return err
+	}
+	w.queue <- writeRequest{file: file}
+	return nil
+}
+
+// Flush waits until the queued files are stored
+func (w *fileWriter) Flush() error {
+	flushed := make(chan error, 1)
+	w.queue <- writeRequest{flushed: flushed}
+	return <-flushed
+}
+
+// Close stores the queued files and stops the writer
+func (w *fileWriter) Close() error {
+	close(w.queue)
+	<-w.done
+	return w.failure()
+}
+
+// failure returns the storage error that stopped the writer, if any
+func (w *fileWriter) failure() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// run stores queued files until the queue is closed. A batch is stored as
+// soon as no more files are waiting, so files arriving slowly, as when they
+// are hashed, are not held back.
+func (w *fileWriter) run() {
+	defer close(w.done)
+	var batch []models.FileInfo
+	for request := range w.queue {
+		if request.flushed == nil {
+			batch = append(batch, request.file)
+			if len(batch) < writeBatchSize && len(w.queue) > 0 {
+				continue
+			}
+		}
+		w.store(batch)
+		batch = nil
+		if request.flushed != nil {
+			request.flushed <- w.failure()
+		}
+	}
+	w.store(batch)
+}
+
+// store writes a batch to the backend unless an earlier batch failed
+func (w *fileWriter) store(batch []models.FileInfo) {
+	if len(batch) == 0 || w.failure() != nil {
+		return
+	}
+	if err := w.indexer.storeFiles(w.ctx, batch); err != nil {
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+	}
+}
+
+// storeFiles stores indexed files in the backend. Files stored without
+// extended attributes, checksums of other algorithms or a scan for secrets
+// keep those recorded before, as in the database.
+func (i *Indexer) storeFiles(ctx context.Context, files []models.FileInfo) error {
+	if i.useDB {
+		if len(files) == 1 {
+			return i.db.InsertFile(ctx, files[0])
+		}
+		return i.db.InsertFiles(ctx, files)
+	}
+	for _, file := range files {
+		if file.XAttrs == nil {
+			file.XAttrs = i.index.Files[file.Path].XAttrs
+		}
+		if file.Checksums == nil {
+			file.Checksums = i.index.Files[file.Path].Checksums
+		}
+		if file.Secrets == nil {
+			file.Secrets = i.index.Files[file.Path].Secrets
+		}
+		i.index.Files[file.Path] = file
+	}
+	return nil
+}
@@ -1,30 +1,19 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"os"
 
-	"file_indexer_go/cmd"
-	"file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/cmd"
 )
 
 func main() {
-	// Parse command-line flags
-	config := cmd.ParseFlags()
-
-	// If no specific action is requested, show help
-	if config.Directory == "" && config.SearchQuery == "" && !config.ListFiles && !config.ShowStats && config.SQLQuery == "" {
-		cmd.ShowHelp()
-		return
-	}
-
-	// Create indexer
-	indexer := indexer.NewIndexer(config.IndexPath, config.UseDB)
-
 	// Create CLI
-	cli := cmd.NewCLI(indexer)
+	cli := cmd.NewCLI()
 
-	// Run the CLI
-	if err := cli.Run(config); err != nil {
-		log.Fatalf("Error: %v", err)
+	// Run the requested subcommand
+	if err := cli.Run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 }
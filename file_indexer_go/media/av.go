@@ -0,0 +1,528 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// ErrUnsupportedMedia is returned for files in a format the media
+// extractor does not understand
+var ErrUnsupportedMedia = errors.New("unsupported media format")
+
+// mediaExtensions lists the audio and video formats the extractor reads
+var mediaExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".m4a": true, ".mov": true,
+	".mkv": true, ".mka": true, ".webm": true,
+	".mp3": true, ".flac": true,
+}
+
+// MediaInfo holds the technical metadata of an audio or video file
+type MediaInfo struct {
+	Container  string  // mp4, mov, mkv, webm, mp3 or flac
+	Duration   float64 // in seconds
+	Width      int     // of the first video track
+	Height     int
+	VideoCodec string
+	AudioCodec string
+}
+
+// IsMedia reports whether a file is expected to be an audio or video file
+// the extractor can read, judged by its sniffed MIME type or its extension
+func IsMedia(filename, mimeType string) bool {
+	return strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/") ||
+		mediaExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// ReadMediaInfo extracts the container, duration, resolution and codecs of
+// an MP4/MOV, Matroska/WebM, MP3 or FLAC file. The format is recognised by
+// its signature, not by the file extension.
+func ReadMediaInfo(fsys source.FS, path string) (MediaInfo, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return MediaInfo{}, err
+	}
+
+	head := make([]byte, 12)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return MediaInfo{}, ErrUnsupportedMedia
+	}
+	head = head[:n]
+
+	switch {
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return readMP4(file, stat.Size())
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return readMatroska(file, stat.Size())
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return readFLAC(file)
+	case bytes.HasPrefix(head, []byte("ID3")) || (len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0):
+		return readMP3(file, stat.Size())
+	}
+	return MediaInfo{}, ErrUnsupportedMedia
+}
+
+// readAt reads exactly len(buf) bytes at an offset
+func readAt(r io.ReaderAt, buf []byte, offset int64) error {
+	_, err := r.ReadAt(buf, offset)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// mp4Codecs maps ISO/QuickTime sample entry codes to codec names
+var mp4Codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc",
+	"av01": "av1", "vp09": "vp9", "vp08": "vp8", "mp4v": "mpeg4",
+	"apch": "prores", "apcn": "prores", "apcs": "prores", "apco": "prores", "ap4h": "prores",
+	"mp4a": "aac", "ac-3": "ac3", "ec-3": "eac3", "Opus": "opus",
+	"fLaC": "flac", "alac": "alac", ".mp3": "mp3",
+}
+
+// mp4Track collects the properties of one trak box
+type mp4Track struct {
+	handler string
+	codec   string
+	width   int
+	height  int
+}
+
+// readMP4 walks the box structure of an ISO base media (MP4/MOV) file
+func readMP4(r io.ReaderAt, size int64) (MediaInfo, error) {
+	info := MediaInfo{Container: "mp4"}
+	var track *mp4Track
+
+	var walk func(start, end int64) error
+	walk = func(start, end int64) error {
+		for offset := start; offset+8 <= end; {
+			header := make([]byte, 16)
+			if err := readAt(r, header[:8], offset); err != nil {
+				return err
+			}
+			boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+			boxType := string(header[4:8])
+			headerSize := int64(8)
+			switch boxSize {
+			case 0:
+				boxSize = end - offset
+			case 1:
+				if err := readAt(r, header[8:16], offset+8); err != nil {
+					return err
+				}
+				boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+				headerSize = 16
+			}
+			if boxSize < headerSize || offset+boxSize > end {
+				return ErrUnsupportedMedia
+			}
+			payload := offset + headerSize
+			payloadSize := boxSize - headerSize
+
+			switch boxType {
+			case "ftyp":
+				brand := make([]byte, 4)
+				if err := readAt(r, brand, payload); err == nil && string(brand) == "qt  " {
+					info.Container = "mov"
+				}
+			case "moov", "mdia", "minf", "stbl":
+				if err := walk(payload, payload+payloadSize); err != nil {
+					return err
+				}
+			case "trak":
+				track = &mp4Track{}
+				if err := walk(payload, payload+payloadSize); err != nil {
+					return err
+				}
+				switch {
+				case track.handler == "vide" && info.VideoCodec == "":
+					info.VideoCodec = track.codec
+					info.Width, info.Height = track.width, track.height
+				case track.handler == "soun" && info.AudioCodec == "":
+					info.AudioCodec = track.codec
+				}
+				track = nil
+			case "mvhd":
+				if err := readMVHD(r, payload, payloadSize, &info); err != nil {
+					return err
+				}
+			case "tkhd", "hdlr", "stsd":
+				if track != nil {
+					if err := readTrackBox(r, boxType, payload, payloadSize, track); err != nil {
+						return err
+					}
+				}
+			}
+			offset += boxSize
+		}
+		return nil
+	}
+
+	if err := walk(0, size); err != nil {
+		return MediaInfo{}, err
+	}
+	return info, nil
+}
+
+// readMVHD reads the movie duration from an mvhd box
+func readMVHD(r io.ReaderAt, payload, payloadSize int64, info *MediaInfo) error {
+	buf := make([]byte, min(payloadSize, 32))
+	if err := readAt(r, buf, payload); err != nil {
+		return err
+	}
+
+	var timescale, duration uint64
+	if buf[0] == 1 && len(buf) >= 32 {
+		timescale = uint64(binary.BigEndian.Uint32(buf[20:24]))
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else if len(buf) >= 20 {
+		timescale = uint64(binary.BigEndian.Uint32(buf[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	}
+	if timescale > 0 {
+		info.Duration = float64(duration) / float64(timescale)
+	}
+	return nil
+}
+
+// readTrackBox reads the dimensions, handler type or codec of a track
+func readTrackBox(r io.ReaderAt, boxType string, payload, payloadSize int64, track *mp4Track) error {
+	buf := make([]byte, min(payloadSize, 96))
+	if err := readAt(r, buf, payload); err != nil {
+		return err
+	}
+
+	switch boxType {
+	case "tkhd":
+		// Width and height are 16.16 fixed-point values behind the matrix
+		offset := 76
+		if buf[0] == 1 {
+			offset = 88
+		}
+		if len(buf) >= offset+8 {
+			track.width = int(binary.BigEndian.Uint32(buf[offset:offset+4]) >> 16)
+			track.height = int(binary.BigEndian.Uint32(buf[offset+4:offset+8]) >> 16)
+		}
+	case "hdlr":
+		if len(buf) >= 12 {
+			track.handler = string(buf[8:12])
+		}
+	case "stsd":
+		// The first sample entry names the codec; video entries also carry
+		// the coded dimensions, used when tkhd has none
+		if len(buf) >= 16 {
+			code := string(buf[12:16])
+			track.codec = mp4Codecs[code]
+			if track.codec == "" {
+				track.codec = strings.TrimSpace(code)
+			}
+		}
+		if track.handler == "vide" && track.width == 0 && len(buf) >= 44 {
+			track.width = int(binary.BigEndian.Uint16(buf[40:42]))
+			track.height = int(binary.BigEndian.Uint16(buf[42:44]))
+		}
+	}
+	return nil
+}
+
+// Matroska element IDs used by the extractor
+const (
+	mkvDocType       = 0x4282
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549A966
+	mkvTimecodeScale = 0x2AD7B1
+	mkvDuration      = 0x4489
+	mkvTracks        = 0x1654AE6B
+	mkvTrackEntry    = 0xAE
+	mkvTrackType     = 0x83
+	mkvCodecID       = 0x86
+	mkvVideo         = 0xE0
+	mkvPixelWidth    = 0xB0
+	mkvPixelHeight   = 0xBA
+	mkvCluster       = 0x1F43B675
+)
+
+// mkvCodecs maps Matroska codec IDs to codec names
+var mkvCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC": "h264", "V_MPEGH/ISO/HEVC": "hevc", "V_AV1": "av1",
+	"V_VP9": "vp9", "V_VP8": "vp8", "V_MPEG4/ISO/ASP": "mpeg4", "V_MPEG2": "mpeg2",
+	"A_AAC": "aac", "A_OPUS": "opus", "A_VORBIS": "vorbis", "A_FLAC": "flac",
+	"A_AC3": "ac3", "A_EAC3": "eac3", "A_DTS": "dts", "A_MPEG/L3": "mp3", "A_TRUEHD": "truehd",
+}
+
+// readVint reads an EBML variable-length integer. With keepMarker the
+// length marker bit is kept, as element IDs are written that way.
+func readVint(r io.ReaderAt, offset int64, keepMarker bool) (value uint64, length int, unknown bool, err error) {
+	first := make([]byte, 1)
+	if err := readAt(r, first, offset); err != nil {
+		return 0, 0, false, err
+	}
+	length = 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, false, ErrUnsupportedMedia
+	}
+
+	buf := make([]byte, length)
+	if err := readAt(r, buf, offset); err != nil {
+		return 0, 0, false, err
+	}
+	if !keepMarker {
+		buf[0] &= 0xFF >> length
+	}
+	allOnes := buf[0] == 0xFF>>length
+	for i, b := range buf {
+		value = value<<8 | uint64(b)
+		if i > 0 && b != 0xFF {
+			allOnes = false
+		}
+	}
+	return value, length, !keepMarker && allOnes, nil
+}
+
+// readMatroska walks the EBML structure of a Matroska or WebM file up to the
+// first cluster, where the segment info and track list have been seen
+func readMatroska(r io.ReaderAt, size int64) (MediaInfo, error) {
+	info := MediaInfo{Container: "mkv"}
+	timecodeScale := uint64(1000000)
+	var duration float64
+	var trackType uint64
+	var trackCodec string
+	var trackWidth, trackHeight int
+
+	readUint := func(offset, length int64) uint64 {
+		buf := make([]byte, min(length, 8))
+		if readAt(r, buf, offset) != nil {
+			return 0
+		}
+		var value uint64
+		for _, b := range buf {
+			value = value<<8 | uint64(b)
+		}
+		return value
+	}
+	readString := func(offset, length int64) string {
+		buf := make([]byte, min(length, 64))
+		if readAt(r, buf, offset) != nil {
+			return ""
+		}
+		return strings.TrimRight(string(buf), "\x00")
+	}
+
+	var walk func(start, end int64) error
+	walk = func(start, end int64) error {
+		for offset := start; offset < end; {
+			id, idLength, _, err := readVint(r, offset, true)
+			if err != nil {
+				return err
+			}
+			dataSize, sizeLength, unknown, err := readVint(r, offset+int64(idLength), false)
+			if err != nil {
+				return err
+			}
+			data := offset + int64(idLength+sizeLength)
+			dataEnd := data + int64(dataSize)
+			if unknown || dataEnd > end {
+				// Live recordings leave the segment size open
+				dataEnd = end
+			}
+
+			switch id {
+			case mkvCluster:
+				return io.EOF
+			case mkvDocType:
+				if readString(data, dataEnd-data) == "webm" {
+					info.Container = "webm"
+				}
+			case 0x1A45DFA3, mkvSegment, mkvInfo, mkvTracks, mkvVideo:
+				if err := walk(data, dataEnd); err != nil {
+					return err
+				}
+			case mkvTrackEntry:
+				trackType, trackCodec, trackWidth, trackHeight = 0, "", 0, 0
+				if err := walk(data, dataEnd); err != nil {
+					return err
+				}
+				codec := mkvCodecs[trackCodec]
+				if codec == "" {
+					for prefix, name := range mkvCodecs {
+						if strings.HasPrefix(trackCodec, prefix) {
+							codec = name
+						}
+					}
+				}
+				if codec == "" {
+					codec = strings.ToLower(trackCodec)
+				}
+				switch {
+				case trackType == 1 && info.VideoCodec == "":
+					info.VideoCodec = codec
+					info.Width, info.Height = trackWidth, trackHeight
+				case trackType == 2 && info.AudioCodec == "":
+					info.AudioCodec = codec
+				}
+			case mkvTimecodeScale:
+				timecodeScale = readUint(data, dataEnd-data)
+			case mkvDuration:
+				switch dataEnd - data {
+				case 4:
+					duration = float64(math.Float32frombits(uint32(readUint(data, 4))))
+				case 8:
+					duration = math.Float64frombits(readUint(data, 8))
+				}
+			case mkvTrackType:
+				trackType = readUint(data, dataEnd-data)
+			case mkvCodecID:
+				trackCodec = readString(data, dataEnd-data)
+			case mkvPixelWidth:
+				trackWidth = int(readUint(data, dataEnd-data))
+			case mkvPixelHeight:
+				trackHeight = int(readUint(data, dataEnd-data))
+			}
+			offset = dataEnd
+		}
+		return nil
+	}
+
+	if err := walk(0, size); err != nil && err != io.EOF {
+		return MediaInfo{}, err
+	}
+	info.Duration = duration * float64(timecodeScale) / 1e9
+	return info, nil
+}
+
+// readFLAC reads the stream length from the STREAMINFO block of a FLAC file
+func readFLAC(r io.ReaderAt) (MediaInfo, error) {
+	// "fLaC", the 4-byte block header, then the 34-byte STREAMINFO block
+	buf := make([]byte, 42)
+	if err := readAt(r, buf, 0); err != nil {
+		return MediaInfo{}, err
+	}
+	if buf[4]&0x7F != 0 {
+		return MediaInfo{}, ErrUnsupportedMedia
+	}
+
+	streamInfo := buf[8:]
+	sampleRate := uint64(streamInfo[10])<<12 | uint64(streamInfo[11])<<4 | uint64(streamInfo[12])>>4
+	totalSamples := uint64(streamInfo[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(streamInfo[14:18]))
+
+	info := MediaInfo{Container: "flac", AudioCodec: "flac"}
+	if sampleRate > 0 {
+		info.Duration = float64(totalSamples) / float64(sampleRate)
+	}
+	return info, nil
+}
+
+// MPEG audio layer III bitrates in kbit/s, for MPEG-1 and MPEG-2/2.5
+var (
+	mp3BitratesV1 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mp3BitratesV2 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+)
+
+// readMP3 estimates the duration of an MP3 file from its Xing/Info or VBRI
+// header, or from the bitrate of the first frame for constant bitrate files
+func readMP3(r io.ReaderAt, size int64) (MediaInfo, error) {
+	// Skip an ID3v2 tag, whose size is stored as a syncsafe integer
+	var start int64
+	id3 := make([]byte, 10)
+	if err := readAt(r, id3, 0); err == nil && string(id3[:3]) == "ID3" {
+		start = 10 + int64(id3[6])<<21 | int64(id3[7])<<14 | int64(id3[8])<<7 | int64(id3[9])
+		if id3[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	}
+
+	// Find the first frame header
+	buf := make([]byte, 4096)
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return MediaInfo{}, err
+	}
+	buf = buf[:n]
+	frame := -1
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] == 0xFF && buf[i+1]&0xE0 == 0xE0 && buf[i+1]&0x06 == 0x02 {
+			frame = i
+			break
+		}
+	}
+	if frame < 0 {
+		return MediaInfo{}, ErrUnsupportedMedia
+	}
+
+	header := buf[frame:]
+	version := header[1] >> 3 & 0x03 // 3 = MPEG-1, 2 = MPEG-2, 0 = MPEG-2.5
+	bitrateIndex := header[2] >> 4
+	rateIndex := header[2] >> 2 & 0x03
+	mono := header[3]>>6 == 0x03
+	if version == 1 || rateIndex == 3 {
+		return MediaInfo{}, ErrUnsupportedMedia
+	}
+
+	sampleRate := [3]int{44100, 48000, 32000}[rateIndex]
+	bitrate := mp3BitratesV1[bitrateIndex]
+	samplesPerFrame := 1152
+	sideInfo := 32
+	if mono {
+		sideInfo = 17
+	}
+	if version != 3 {
+		sampleRate /= 2
+		if version == 0 {
+			sampleRate /= 2
+		}
+		bitrate = mp3BitratesV2[bitrateIndex]
+		samplesPerFrame = 576
+		sideInfo = 17
+		if mono {
+			sideInfo = 9
+		}
+	}
+
+	info := MediaInfo{Container: "mp3", AudioCodec: "mp3"}
+	frameCount := func(offset, field int) uint32 {
+		if frame+offset+field+4 > len(buf) {
+			return 0
+		}
+		return binary.BigEndian.Uint32(buf[frame+offset+field:])
+	}
+	tag := func(offset int) string {
+		if frame+offset+4 > len(buf) {
+			return ""
+		}
+		return string(buf[frame+offset : frame+offset+4])
+	}
+
+	var frames uint32
+	switch xing := 4 + sideInfo; {
+	case tag(xing) == "Xing" || tag(xing) == "Info":
+		if frameCount(xing, 4)&0x01 != 0 {
+			frames = frameCount(xing, 8)
+		}
+	case tag(36) == "VBRI":
+		frames = frameCount(36, 14)
+	}
+
+	switch {
+	case frames > 0:
+		info.Duration = float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+	case bitrate > 0:
+		info.Duration = float64(size-start-int64(frame)) * 8 / float64(bitrate*1000)
+	}
+	return info, nil
+}
@@ -0,0 +1,219 @@
+// Package media extracts metadata embedded in photo, audio and video files
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the JPEG decoder for image.DecodeConfig
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// ErrNoEXIF is returned for files that carry no EXIF metadata
+var ErrNoEXIF = errors.New("no EXIF metadata")
+
+// exifSearchLimit bounds how far into a container file the EXIF block is
+// searched for when the file is neither a JPEG nor TIFF-based
+const exifSearchLimit = 4 << 20
+
+// exifLayout is the EXIF date and time format
+const exifLayout = "2006:01:02 15:04:05"
+
+// photoExtensions lists JPEG, HEIC and camera RAW formats that carry EXIF
+var photoExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".jpe": true,
+	".heic": true, ".heif": true,
+	".tif": true, ".tiff": true, ".dng": true,
+	".cr2": true, ".cr3": true, ".crw": true,
+	".nef": true, ".nrw": true, ".arw": true, ".srf": true, ".sr2": true,
+	".orf": true, ".rw2": true, ".raf": true, ".pef": true, ".srw": true,
+}
+
+// serialTags are the EXIF tags that hold the serial number of the camera
+// body: BodySerialNumber in the EXIF IFD, and CameraSerialNumber of DNG files
+// in IFD0. goexif does not know them, so they are read by number.
+const (
+	bodySerialTag   = 0xA431
+	cameraSerialTag = 0xC62F
+)
+
+// PhotoInfo holds the EXIF metadata of a photo
+type PhotoInfo struct {
+	TakenAt      time.Time // DateTimeOriginal with SubSecTimeOriginal, the camera's wall-clock time
+	CameraModel  string
+	CameraSerial string // serial number of the camera body, if the camera records it
+	Width        int
+	Height       int
+}
+
+// IsPhoto reports whether a file is expected to carry EXIF metadata, judged
+// by its sniffed MIME type or, for formats that are not sniffed, its extension
+func IsPhoto(filename, mimeType string) bool {
+	return mimeType == "image/jpeg" || photoExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// ReadEXIF extracts the capture time, camera model and dimensions of a photo.
+// JPEG and TIFF-based RAW files are decoded directly; for containers such as
+// HEIC and CR3 the embedded EXIF block is searched for near the file start.
+func ReadEXIF(fsys source.FS, path string) (PhotoInfo, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return PhotoInfo{}, err
+	}
+	defer file.Close()
+
+	x, err := exif.Decode(file)
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return PhotoInfo{}, err
+		}
+		x, err = findEXIF(file)
+		if err != nil {
+			// Without EXIF, plain JPEGs still report their dimensions
+			return jpegDimensions(file, err)
+		}
+	}
+
+	var info PhotoInfo
+	if value, ok := exifString(x, exif.DateTimeOriginal); ok {
+		if takenAt, err := time.Parse(exifLayout, value); err == nil {
+			info.TakenAt = takenAt.Add(subSeconds(x))
+		}
+	}
+	if value, ok := exifString(x, exif.Model); ok {
+		info.CameraModel = value
+	}
+	info.CameraSerial = cameraSerial(x)
+	info.Width = exifInt(x, exif.PixelXDimension, exif.ImageWidth)
+	info.Height = exifInt(x, exif.PixelYDimension, exif.ImageLength)
+
+	if info.Width == 0 || info.Height == 0 {
+		if dims, err := jpegDimensions(file, nil); err == nil {
+			info.Width, info.Height = dims.Width, dims.Height
+		}
+	}
+	return info, nil
+}
+
+// findEXIF locates a raw "Exif" block within the beginning of a file
+func findEXIF(r io.Reader) (*exif.Exif, error) {
+	head, err := io.ReadAll(io.LimitReader(r, exifSearchLimit))
+	if err != nil {
+		return nil, err
+	}
+	offset := bytes.Index(head, []byte("Exif\x00\x00"))
+	if offset < 0 {
+		return nil, ErrNoEXIF
+	}
+
+	x, err := exif.Decode(bytes.NewReader(head[offset:]))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return nil, fmt.Errorf("error decoding EXIF: %v", err)
+	}
+	return x, nil
+}
+
+// jpegDimensions reads the dimensions from a JPEG header, returning fallback
+// as the error if the file is not a decodable JPEG
+func jpegDimensions(file io.ReadSeeker, fallback error) (PhotoInfo, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return PhotoInfo{}, err
+	}
+	config, format, err := image.DecodeConfig(file)
+	if err != nil || format != "jpeg" {
+		if fallback == nil {
+			fallback = ErrNoEXIF
+		}
+		return PhotoInfo{}, fallback
+	}
+	return PhotoInfo{Width: config.Width, Height: config.Height}, nil
+}
+
+// subSeconds returns the fraction of a second of SubSecTimeOriginal, which
+// tells apart the shots of a burst taken within the same second
+func subSeconds(x *exif.Exif) time.Duration {
+	digits, ok := exifString(x, exif.SubSecTimeOriginal)
+	if !ok {
+		return 0
+	}
+	var fraction time.Duration
+	scale := time.Second
+	for _, digit := range digits {
+		if digit < '0' || digit > '9' || scale < 10 {
+			break
+		}
+		scale /= 10
+		fraction += time.Duration(digit-'0') * scale
+	}
+	return fraction
+}
+
+// cameraSerial returns the serial number of the camera body from the EXIF
+// IFD or, for DNG files, from IFD0
+func cameraSerial(x *exif.Exif) string {
+	if x.Tiff == nil || len(x.Tiff.Dirs) == 0 {
+		return ""
+	}
+	dirs := []*tiff.Dir{x.Tiff.Dirs[0]}
+	if pointer, err := x.Get(exif.ExifIFDPointer); err == nil {
+		// Offsets in the IFD are relative to the start of the TIFF data
+		r := bytes.NewReader(x.Raw)
+		if offset, err := pointer.Int64(0); err == nil && offset > 0 && offset < int64(len(x.Raw)) {
+			if _, err := r.Seek(offset, io.SeekStart); err == nil {
+				if dir, _, err := tiff.DecodeDir(r, x.Tiff.Order); err == nil {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+	}
+	for _, dir := range dirs {
+		for _, tag := range dir.Tags {
+			if (tag.Id != bodySerialTag && tag.Id != cameraSerialTag) || tag.Format() != tiff.StringVal {
+				continue
+			}
+			if value, err := tag.StringVal(); err == nil {
+				if value = strings.TrimSpace(strings.TrimRight(value, "\x00")); value != "" {
+					return value
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// exifString returns a string tag without its NUL padding
+func exifString(x *exif.Exif, name exif.FieldName) (string, bool) {
+	tag, err := x.Get(name)
+	if err != nil || tag.Format() != tiff.StringVal {
+		return "", false
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return "", false
+	}
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	return value, value != ""
+}
+
+// exifInt returns the first of the given integer tags that is present
+func exifInt(x *exif.Exif, names ...exif.FieldName) int {
+	for _, name := range names {
+		tag, err := x.Get(name)
+		if err != nil {
+			continue
+		}
+		if value, err := tag.Int(0); err == nil && value > 0 {
+			return value
+		}
+	}
+	return 0
+}
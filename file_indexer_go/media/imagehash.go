@@ -0,0 +1,122 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register the GIF and PNG decoders for image.Decode
+	_ "image/png"
+	"io"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/source"
+)
+
+// ErrUnsupportedImage is returned for files that ImageHash cannot decode
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// The difference hash compares the brightness of neighbouring cells of a grid
+// laid over the image: each row of 9 cells gives 8 bits
+const (
+	hashGridWidth  = 9
+	hashGridHeight = 8
+)
+
+// maxHashedPixels bounds the images that are decoded, so a small file that
+// claims huge dimensions cannot exhaust memory
+const maxHashedPixels = 200_000_000
+
+// hashableTypes lists the MIME types of the images ImageHash decodes
+var hashableTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// HashedImage holds the perceptual hash and dimensions of an image
+type HashedImage struct {
+	Hash   string // difference hash, 16 hex digits
+	Width  int
+	Height int
+}
+
+// IsHashableImage reports whether ImageHash can decode a file of the given
+// sniffed MIME type: JPEG, PNG or GIF
+func IsHashableImage(mimeType string) bool {
+	return hashableTypes[mimeType]
+}
+
+// ImageHash returns the perceptual difference hash (dHash) of an image and
+// its dimensions. Resized, recompressed and re-exported copies of a photo
+// get the same hash or one that differs in a few bits; see
+// models.ImageHashDistance. The EXIF orientation is not applied, so rotated
+// copies do not match.
+func ImageHash(fsys source.FS, path string) (HashedImage, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return HashedImage{}, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return HashedImage{}, ErrUnsupportedImage
+	}
+	if int64(config.Width)*int64(config.Height) > maxHashedPixels {
+		return HashedImage{}, fmt.Errorf("image of %dx%d pixels is too large to hash", config.Width, config.Height)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return HashedImage{}, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return HashedImage{}, fmt.Errorf("error decoding image: %v", err)
+	}
+	return HashedImage{Hash: fmt.Sprintf("%016x", differenceHash(img)), Width: config.Width, Height: config.Height}, nil
+}
+
+// differenceHash sets a bit for each cell of the brightness grid that is
+// darker than its right neighbour, row by row
+func differenceHash(img image.Image) uint64 {
+	grid := brightnessGrid(img)
+	var hash uint64
+	for y := 0; y < hashGridHeight; y++ {
+		for x := 0; x < hashGridWidth-1; x++ {
+			hash <<= 1
+			if grid[y][x] < grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// brightnessGrid averages the brightness of an image over the cells of the
+// hash grid. JPEGs are read from their luma plane; other images are
+// converted to gray pixel by pixel.
+func brightnessGrid(img image.Image) [hashGridHeight][hashGridWidth]float64 {
+	var sums, counts [hashGridHeight][hashGridWidth]float64
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	ycbcr, isYCbCr := img.(*image.YCbCr)
+	for y := 0; y < height; y++ {
+		row := y * hashGridHeight / height
+		for x := 0; x < width; x++ {
+			col := x * hashGridWidth / width
+			var luma uint8
+			if isYCbCr {
+				luma = ycbcr.Y[ycbcr.YOffset(bounds.Min.X+x, bounds.Min.Y+y)]
+			} else {
+				luma = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			}
+			sums[row][col] += float64(luma)
+			counts[row][col]++
+		}
+	}
+
+	// Cells of images narrower or lower than the grid may have no pixels
+	for y := range sums {
+		for x := range sums[y] {
+			if counts[y][x] > 0 {
+				sums[y][x] /= counts[y][x]
+			}
+		}
+	}
+	return sums
+}
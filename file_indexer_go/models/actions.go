@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ActionRecord is an entry of the audit log of dedupe: a duplicate that was
+// deleted or replaced with a link to its original, or put back by an undo
+type ActionRecord struct {
+	PerformedAt time.Time `json:"performed_at"`
+	Action      string    `json:"action"` // delete, hardlink, symlink, reflink, move or undo
+	Path        string    `json:"path"`   // the duplicate
+	Original    string    `json:"original"`
+	Checksum    string    `json:"checksum"` // verified on both files before the action
+	FileSize    int64     `json:"file_size"`
+	Host        string    `json:"host,omitempty"`
+	RunID       string    `json:"run_id,omitempty"` // the dedupe run, which dedupe undo takes; for undo, the run undone
+	Trash       string    `json:"trash,omitempty"`  // the trash or quarantine directory the duplicate was moved into
+}
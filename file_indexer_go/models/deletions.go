@@ -0,0 +1,74 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DeletedFile is an entry of the deletion journal: an indexed file that a
+// scan or watch found gone and removed from the index. The file vanished
+// between LastSeen and DeletedAt.
+type DeletedFile struct {
+	Path                 string    `json:"path"`
+	Filename             string    `json:"filename"`
+	Checksum             string    `json:"checksum,omitempty"`
+	FileSize             int64     `json:"file_size"`
+	ModificationDateTime time.Time `json:"modification_datetime"`
+	LastSeen             time.Time `json:"last_seen"`  // when the file was last indexed
+	DeletedAt            time.Time `json:"deleted_at"` // when it was found gone
+	Host                 string    `json:"host,omitempty"`
+}
+
+// NewDeletedFile returns the journal entry of an indexed file found gone at
+// deletedAt
+func NewDeletedFile(file FileInfo, deletedAt time.Time) DeletedFile {
+	return DeletedFile{
+		Path:                 file.Path,
+		Filename:             file.Filename,
+		Checksum:             file.Checksum,
+		FileSize:             file.FileSize,
+		ModificationDateTime: file.ModificationDateTime,
+		LastSeen:             file.IndexedAt,
+		DeletedAt:            deletedAt,
+		Host:                 file.Host,
+	}
+}
+
+// File returns the parts of the file's last record that the journal keeps,
+// so the filters of a FileQuery can be applied to it
+func (d DeletedFile) File() FileInfo {
+	return FileInfo{
+		Path:                 d.Path,
+		Filename:             d.Filename,
+		Checksum:             d.Checksum,
+		FileSize:             d.FileSize,
+		ModificationDateTime: d.ModificationDateTime,
+		IndexedAt:            d.LastSeen,
+		Host:                 d.Host,
+	}
+}
+
+// DeletionQuery narrows down the entries of the deletion journal
+type DeletionQuery struct {
+	Dir    string    // Only files below this directory (empty = any)
+	After  time.Time // Only files found gone at or after this time (zero = any)
+	Before time.Time // Only files found gone before this time (zero = any)
+}
+
+// Matches reports whether a journal entry passes the query's filters
+func (q DeletionQuery) Matches(deleted DeletedFile) bool {
+	if q.Dir != "" {
+		dir := filepath.Clean(q.Dir)
+		if deleted.Path != dir && !strings.HasPrefix(deleted.Path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+			return false
+		}
+	}
+	if !q.After.IsZero() && deleted.DeletedAt.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !deleted.DeletedAt.Before(q.Before) {
+		return false
+	}
+	return true
+}
@@ -1,20 +1,474 @@
+// Package models defines the records of a file index and the queries on it,
+// shared by the JSON and DuckDB backends.
 package models
 
-import "time"
+import (
+	"math"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
 
 // FileInfo represents information about an indexed file
 type FileInfo struct {
-	Path                 string    `json:"path"`
-	Filename             string    `json:"filename"`
-	Checksum             string    `json:"checksum"`
-	ModificationDateTime time.Time `json:"modification_datetime"`
-	FileSize             int64     `json:"file_size"`
-	IndexedAt            time.Time `json:"indexed_at"`
+	Path                 string            `json:"path"`
+	Filename             string            `json:"filename"`
+	Checksum             string            `json:"checksum"`
+	ModificationDateTime time.Time         `json:"modification_datetime"`
+	FileSize             int64             `json:"file_size"`
+	IndexedAt            time.Time         `json:"indexed_at"`
+	QuickHash            string            `json:"quick_hash,omitempty"`
+	SampleHash           string            `json:"sample_hash,omitempty"` // of large files hashed by sampling, which have no Checksum
+	LinkTarget           string            `json:"link_target,omitempty"`
+	MimeType             string            `json:"mime_type,omitempty"`
+	TakenAt              time.Time         `json:"taken_at,omitzero"` // EXIF DateTimeOriginal
+	CameraModel          string            `json:"camera_model,omitempty"`
+	CameraSerial         string            `json:"camera_serial,omitempty"` // EXIF BodySerialNumber
+	ImageWidth           int               `json:"image_width,omitempty"`
+	ImageHeight          int               `json:"image_height,omitempty"`
+	ImageHash            string            `json:"image_hash,omitempty"` // perceptual dHash of JPEG, PNG and GIF images, 16 hex digits
+	Container            string            `json:"container,omitempty"`
+	DurationSeconds      float64           `json:"duration_seconds,omitempty"`
+	VideoWidth           int               `json:"video_width,omitempty"`
+	VideoHeight          int               `json:"video_height,omitempty"`
+	VideoCodec           string            `json:"video_codec,omitempty"`
+	AudioCodec           string            `json:"audio_codec,omitempty"`
+	Content              string            `json:"content,omitempty"`       // only for text files indexed with content
+	TextHash             string            `json:"text_hash,omitempty"`     // simhash of text files, 16 hex digits
+	Language             string            `json:"language,omitempty"`      // programming language of source files, e.g. Go
+	LineCount            int               `json:"line_count,omitempty"`    // of source files, including comments and blank lines
+	CodeLines            int               `json:"code_lines,omitempty"`    // lines of a source file with code
+	CommentLines         int               `json:"comment_lines,omitempty"` // lines of a source file with only comments
+	Device               uint64            `json:"device,omitempty"`
+	Inode                uint64            `json:"inode,omitempty"`
+	LinkCount            uint64            `json:"link_count,omitempty"`
+	UID                  *uint32           `json:"uid,omitempty"` // nil where the platform has no owners
+	GID                  *uint32           `json:"gid,omitempty"`
+	UserName             string            `json:"user_name,omitempty"` // name of UID, empty if it could not be resolved
+	GroupName            string            `json:"group_name,omitempty"`
+	Mode                 uint32            `json:"mode,omitempty"`        // permission bits as in chmod, e.g. 0o644
+	Attributes           string            `json:"attributes,omitempty"`  // set Windows attributes, e.g. "hidden,system"
+	XAttrs               map[string]string `json:"xattrs,omitempty"`      // extended attributes, nil if not captured
+	Checksums            map[string]string `json:"checksums,omitempty"`   // by algorithm, of other algorithms than the index's
+	Secrets              []SecretFinding   `json:"secrets,omitempty"`     // found in the content, nil if not scanned
+	FinderTags           []string          `json:"finder_tags,omitempty"` // macOS Finder tags and color label
+	Source               string            `json:"source,omitempty"`      // SourceS3, SourceArchive or SourceStream, empty for local files
+	Host                 string            `json:"host,omitempty"`        // machine that indexed the file
+	Label                string            `json:"label,omitempty"`       // user-supplied label of the scan, e.g. a disk name
+}
+
+// SourceS3 is the source of files indexed from S3-compatible object storage
+const SourceS3 = "s3"
+
+// SourceArchive is the source of files indexed from inside zip and tar
+// archives
+const SourceArchive = "archive"
+
+// ArchiveSeparator separates the path of an archive from the path of an
+// entry inside it, as in /backups/old.zip!/docs/report.pdf
+const ArchiveSeparator = "!"
+
+// ArchiveEntryPath returns the virtual path of an entry of an archive
+func ArchiveEntryPath(archivePath, name string) string {
+	return archivePath + ArchiveSeparator + string(filepath.Separator) + name
+}
+
+// SourceStream is the source of NTFS alternate data streams, which are
+// indexed next to the files they belong to
+const SourceStream = "stream"
+
+// StreamSeparator separates the path of a file from the name of one of its
+// alternate data streams, as in C:\Users\me\setup.exe:Zone.Identifier
+const StreamSeparator = ":"
+
+// StreamPath returns the path of an alternate data stream of a file, under
+// which Windows also opens the stream
+func StreamPath(filePath, name string) string {
+	return filePath + StreamSeparator + name
+}
+
+// EntryPrefixes returns the path prefixes of the records that belong to the
+// file at path: the entries of an archive and, on Windows, where file names
+// cannot contain colons, its alternate data streams
+func EntryPrefixes(path string) []string {
+	prefixes := []string{ArchiveEntryPath(path, "")}
+	if runtime.GOOS == "windows" {
+		prefixes = append(prefixes, StreamPath(path, ""))
+	}
+	return prefixes
+}
+
+// Location identifies the file among the files of all hosts: its path,
+// prefixed with its host if it has one
+func (f FileInfo) Location() string {
+	if f.Host == "" {
+		return f.Path
+	}
+	return f.Host + ":" + f.Path
+}
+
+// DisplayPath returns the path of the file as shown on the machine named
+// host: the bare path of the machine's own files, which can be opened there,
+// and the location of the files of other hosts
+func (f FileInfo) DisplayPath(host string) string {
+	if f.Host == host {
+		return f.Path
+	}
+	return f.Location()
+}
+
+// IsHardlinkOf reports whether two records are hardlinks to the same file.
+// Records without an inode number, or of different hosts, are never
+// considered hardlinks.
+func (f FileInfo) IsHardlinkOf(other FileInfo) bool {
+	return f.Inode != 0 && f.Inode == other.Inode && f.Device == other.Device && f.Host == other.Host
+}
+
+// IsAliasOf reports whether two records are one directory entry reached
+// through different paths, such as a bind mount or a symlinked directory:
+// they share an inode that had a single link when both were indexed. Records
+// without link counts are never considered aliases.
+func (f FileInfo) IsAliasOf(other FileInfo) bool {
+	return f.IsHardlinkOf(other) && f.LinkCount == 1 && other.LinkCount == 1
 }
 
 // Index represents the file index (for JSON compatibility)
 type Index struct {
-	Files    map[string]FileInfo `json:"files"`
-	Indexed  time.Time           `json:"indexed"`
-	RootPath string              `json:"root_path"`
+	Files               map[string]FileInfo `json:"files"`
+	Indexed             time.Time           `json:"indexed"`
+	RootPath            string              `json:"root_path"`
+	HashAlgorithm       string              `json:"hash_algorithm,omitempty"`
+	SampleHashAlgorithm string              `json:"sample_hash_algorithm,omitempty"` // of the sampled hashes of large files
+	Host                string              `json:"host,omitempty"`                  // machine of the last scan
+	Label               string              `json:"label,omitempty"`                 // label of the last scan
+	Roots               map[string]RootInfo `json:"roots,omitempty"`
+	Interrupted         *InterruptedScan    `json:"interrupted,omitempty"`
+	Actions             []ActionRecord      `json:"actions,omitempty"` // audit log of dedupe
+	Tags                []FileTag           `json:"tags,omitempty"`
+	Deletions           []DeletedFile       `json:"deletions,omitempty"` // journal of files found gone
+}
+
+// InterruptedScan records a scan that was cancelled before it completed. The
+// files stored until then are kept, but files deleted since the root's
+// previous scan are not pruned until the root is indexed again.
+type InterruptedScan struct {
+	Root          string    `json:"root"`
+	InterruptedAt time.Time `json:"interrupted_at"`
+}
+
+// RootInfo describes one root directory covered by an index
+type RootInfo struct {
+	Path      string    `json:"path"`
+	IndexedAt time.Time `json:"indexed_at"`
+	FileCount int64     `json:"file_count"`
+	TotalSize int64     `json:"total_size"`
+}
+
+// DirectoryUsage is the number and total size of the files below a
+// directory, including all of its subdirectories
+type DirectoryUsage struct {
+	Path      string `json:"path"`
+	Depth     int    `json:"depth"` // Levels below the root of the report (0 = the root)
+	FileCount int64  `json:"file_count"`
+	TotalSize int64  `json:"total_size"`
+}
+
+// StatsEntry is a figure of a statistics snapshot: the number and total size
+// of the files of one kind of breakdown, such as a file extension or a
+// top-level directory
+type StatsEntry struct {
+	TakenAt   time.Time `json:"taken_at"`
+	Kind      string    `json:"kind"` // total, extension, mime, size, directory, duplicates, top_duplicate or sampled
+	Name      string    `json:"name"` // the extension, MIME type, size bucket, directory or file; empty for totals
+	FileCount int64     `json:"file_count"`
+	TotalSize int64     `json:"total_size"`
+}
+
+// LanguageUsage is the number of source files of a programming language
+// and their lines, as recorded by scans with code metrics
+type LanguageUsage struct {
+	Language     string `json:"language"`
+	FileCount    int64  `json:"file_count"`
+	Lines        int64  `json:"lines"`
+	CodeLines    int64  `json:"code_lines"`
+	CommentLines int64  `json:"comment_lines"`
+	BlankLines   int64  `json:"blank_lines"`
+	TotalSize    int64  `json:"total_size"`
+}
+
+// CaseCollision is a set of files whose paths differ only by case, either in
+// their names or in the names of directories above them. They cannot coexist
+// on case-insensitive filesystems such as FAT, exFAT and the default macOS and
+// Windows ones, where copying them makes one overwrite the others.
+type CaseCollision struct {
+	FoldedPath string     `json:"folded_path"` // the lower-case path the files share
+	Files      []FileInfo `json:"files"`
+}
+
+// GroupCaseCollisions groups the files whose paths differ only by case.
+// Collisions are returned in path order, with their files in the order given.
+func GroupCaseCollisions(files []FileInfo) []CaseCollision {
+	byPath := make(map[string][]FileInfo)
+	for _, file := range files {
+		folded := strings.ToLower(file.Path)
+		byPath[folded] = append(byPath[folded], file)
+	}
+
+	var collisions []CaseCollision
+	for folded, paths := range byPath {
+		if len(paths) > 1 {
+			collisions = append(collisions, CaseCollision{FoldedPath: folded, Files: paths})
+		}
+	}
+	sort.Slice(collisions, func(a, b int) bool { return collisions[a].FoldedPath < collisions[b].FoldedPath })
+	return collisions
+}
+
+// SizeBucket counts the files whose size is at least MinSize and below
+// MaxSize
+type SizeBucket struct {
+	Label     string `json:"label"`
+	MinSize   int64  `json:"min_size"`
+	MaxSize   int64  `json:"max_size,omitempty"` // 0 = no upper bound
+	FileCount int64  `json:"file_count"`
+	TotalSize int64  `json:"total_size"`
+}
+
+// NewSizeHistogram returns the empty buckets of the file size distribution
+// reported in statistics, from smallest to largest
+func NewSizeHistogram() []SizeBucket {
+	return []SizeBucket{
+		{Label: "< 4 KB", MinSize: 0, MaxSize: 4 << 10},
+		{Label: "4 KB - 1 MB", MinSize: 4 << 10, MaxSize: 1 << 20},
+		{Label: "1 MB - 100 MB", MinSize: 1 << 20, MaxSize: 100 << 20},
+		{Label: "100 MB - 1 GB", MinSize: 100 << 20, MaxSize: 1 << 30},
+		{Label: ">= 1 GB", MinSize: 1 << 30},
+	}
+}
+
+// SizeBucketIndex returns the position of the bucket a file size falls into
+func SizeBucketIndex(buckets []SizeBucket, size int64) int {
+	for n, bucket := range buckets {
+		if bucket.MaxSize == 0 || size < bucket.MaxSize {
+			return n
+		}
+	}
+	return len(buckets) - 1
+}
+
+// DuplicateGroup represents a set of files with identical size and checksum.
+// The first file in Files is treated as the original. Hardlinks share their
+// storage, so only distinct files (Copies) count towards the wasted space.
+// Records of one directory entry under several paths are listed once.
+type DuplicateGroup struct {
+	Checksum    string     `json:"checksum"`
+	FileSize    int64      `json:"file_size"`
+	Files       []FileInfo `json:"files"`
+	Copies      int        `json:"copies"`
+	WastedSpace int64      `json:"wasted_space"`
+
+	// Companions are the AppleDouble files of the group's files, keyed by
+	// the Location of the file they belong to
+	Companions map[string]FileInfo `json:"companions,omitempty"`
+
+	// Aliases are the other paths of the group's files that lead to the same
+	// directory entry, keyed by the Location of the file listed in Files
+	Aliases map[string][]string `json:"aliases,omitempty"`
+
+	// Tags are the user tags on the group's checksum
+	Tags []string `json:"tags,omitempty"`
+
+	// Sampled groups share a sampled hash, which is their Checksum, and are
+	// only candidates until full checksums confirm them
+	Sampled bool `json:"sampled,omitempty"`
+}
+
+// DuplicateFilter leaves out the duplicate groups that waste little space, so
+// reports focus on the groups worth cleaning up. Zero values do not filter.
+type DuplicateFilter struct {
+	MinFileSize  int64 // smallest size of the files of a group
+	MinGroupSize int   // fewest files of a group, hardlinks included
+	MinWasted    int64 // least space a group must waste
+}
+
+// Matches reports whether a duplicate group passes the filter
+func (f DuplicateFilter) Matches(group DuplicateGroup) bool {
+	return group.FileSize >= f.MinFileSize && len(group.Files) >= f.MinGroupSize && group.WastedSpace >= f.MinWasted
+}
+
+// NewDuplicateGroup creates a duplicate group and computes its wasted space.
+// Aliases of an earlier file are folded into it, so the same physical file
+// is neither counted twice nor offered for deletion as its own duplicate.
+func NewDuplicateGroup(checksum string, fileSize int64, files []FileInfo) DuplicateGroup {
+	group := DuplicateGroup{Checksum: checksum, FileSize: fileSize}
+	for _, file := range files {
+		if n := aliasIndex(group.Files, file); n >= 0 {
+			if group.Aliases == nil {
+				group.Aliases = make(map[string][]string)
+			}
+			location := group.Files[n].Location()
+			group.Aliases[location] = append(group.Aliases[location], file.Path)
+			continue
+		}
+		if HardlinkIndex(group.Files, file) < 0 {
+			group.Copies++
+		}
+		group.Files = append(group.Files, file)
+	}
+	group.WastedSpace = fileSize * int64(group.Copies-1)
+	return group
+}
+
+// GroupSampled groups the files with the same sampled hash into candidate
+// duplicate groups, largest files first
+func GroupSampled(files []FileInfo) []DuplicateGroup {
+	bySample := make(map[string][]FileInfo)
+	for _, file := range files {
+		if file.SampleHash != "" {
+			bySample[file.SampleHash] = append(bySample[file.SampleHash], file)
+		}
+	}
+	var groups []DuplicateGroup
+	for sample, members := range bySample {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(a, b int) bool { return members[a].Path < members[b].Path })
+		// The sampled hash covers the size, so all members have the same one
+		if group := NewDuplicateGroup(sample, members[0].FileSize, members); group.Copies > 1 {
+			group.Sampled = true
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].FileSize != groups[b].FileSize {
+			return groups[a].FileSize > groups[b].FileSize
+		}
+		return groups[a].Checksum < groups[b].Checksum
+	})
+	return groups
+}
+
+// aliasIndex returns the position of the file in files that file is an alias
+// of, or -1 if there is none
+func aliasIndex(files []FileInfo, file FileInfo) int {
+	for n, other := range files {
+		if file.IsAliasOf(other) {
+			return n
+		}
+	}
+	return -1
+}
+
+// DuplicateSummary is the space taken by the duplicates of an index, as
+// shown in its statistics
+type DuplicateSummary struct {
+	GroupCount       int              `json:"group_count"`
+	DuplicateFiles   int              `json:"duplicate_files"`   // files of all groups, originals included
+	ReclaimableSpace int64            `json:"reclaimable_space"` // wasted space of all groups
+	TopGroups        []DuplicateGroup `json:"top_groups"`        // groups wasting the most space, most first
+}
+
+// SummarizeDuplicates sums up duplicate groups, keeping the top groups that
+// waste the most space
+func SummarizeDuplicates(groups []DuplicateGroup, top int) DuplicateSummary {
+	summary := DuplicateSummary{GroupCount: len(groups)}
+	for _, group := range groups {
+		summary.DuplicateFiles += len(group.Files)
+		summary.ReclaimableSpace += group.WastedSpace
+	}
+
+	ranked := append([]DuplicateGroup(nil), groups...)
+	sort.SliceStable(ranked, func(a, b int) bool { return ranked[a].WastedSpace > ranked[b].WastedSpace })
+	summary.TopGroups = ranked[:min(top, len(ranked))]
+	return summary
+}
+
+// DuplicateDirectory is a pair of directories whose trees hold the same
+// files, so one of them can be removed as a whole. Path sorts before Other.
+type DuplicateDirectory struct {
+	Path        string  `json:"path"`
+	Other       string  `json:"other"`
+	Files       int64   `json:"files"`        // files below Path
+	OtherFiles  int64   `json:"other_files"`  // files below Other
+	SharedFiles int64   `json:"shared_files"` // files at the same relative path with the same checksum below both
+	SharedSize  int64   `json:"shared_size"`
+	Similarity  float64 `json:"similarity"` // percent of the files of both trees that are shared
+}
+
+// DirectorySimilarity returns the percentage of the files of two trees with
+// files and otherFiles files that are among the shared ones, rounded to a
+// tenth: 100 for identical trees
+func DirectorySimilarity(shared, files, otherFiles int64) float64 {
+	total := files + otherFiles - shared
+	if total <= 0 {
+		return 0
+	}
+	return math.Round(1000*float64(shared)/float64(total)) / 10
+}
+
+// AppleDoublePrefix starts the names of AppleDouble files, in which macOS
+// keeps the metadata and resource fork of a file on filesystems that cannot
+// store them, such as ._photo.jpg next to photo.jpg
+const AppleDoublePrefix = "._"
+
+// IsAppleDouble reports whether a file name is that of an AppleDouble file
+func IsAppleDouble(name string) bool {
+	return len(name) > len(AppleDoublePrefix) && strings.HasPrefix(name, AppleDoublePrefix)
+}
+
+// AppleDoubleDataPath returns the path of the file an AppleDouble file
+// belongs to. Archives created on macOS keep AppleDouble files in a tree of
+// their own below __MACOSX.
+func AppleDoubleDataPath(path string) string {
+	dir, name := filepath.Split(path)
+	dataPath := dir + strings.TrimPrefix(name, AppleDoublePrefix)
+	entries := ArchiveSeparator + string(filepath.Separator)
+	if archive, entry, ok := strings.Cut(dataPath, entries+"__MACOSX"+string(filepath.Separator)); ok {
+		return archive + entries + entry
+	}
+	return dataPath
+}
+
+// AttachCompanions records in each group the AppleDouble files among files
+// that belong to the group's files, so they can be shown and removed
+// together instead of forming duplicate groups of their own
+func AttachCompanions(groups []DuplicateGroup, files []FileInfo) {
+	byDataFile := make(map[string]FileInfo)
+	for _, file := range files {
+		if IsAppleDouble(file.Filename) {
+			dataFile := file
+			dataFile.Path = AppleDoubleDataPath(file.Path)
+			byDataFile[dataFile.Location()] = file
+		}
+	}
+	if len(byDataFile) == 0 {
+		return
+	}
+
+	for n := range groups {
+		for _, file := range groups[n].Files {
+			companion, ok := byDataFile[file.Location()]
+			if !ok {
+				continue
+			}
+			if groups[n].Companions == nil {
+				groups[n].Companions = make(map[string]FileInfo)
+			}
+			groups[n].Companions[file.Location()] = companion
+		}
+	}
+}
+
+// HardlinkIndex returns the position of the first file in files that file
+// is a hardlink of, or -1 if there is none
+func HardlinkIndex(files []FileInfo, file FileInfo) int {
+	for n, other := range files {
+		if file.IsHardlinkOf(other) {
+			return n
+		}
+	}
+	return -1
 }
@@ -0,0 +1,23 @@
+package models
+
+import "testing"
+
+func TestLocationAndDisplayPath(t *testing.T) {
+	tests := []struct {
+		file         FileInfo
+		wantLocation string
+		wantDisplay  string // as shown on the machine named laptop
+	}{
+		{FileInfo{Path: "/data/a.txt"}, "/data/a.txt", "/data/a.txt"},
+		{FileInfo{Path: "/data/a.txt", Host: "laptop"}, "laptop:/data/a.txt", "/data/a.txt"},
+		{FileInfo{Path: "/data/a.txt", Host: "desktop"}, "desktop:/data/a.txt", "desktop:/data/a.txt"},
+	}
+	for _, tt := range tests {
+		if got := tt.file.Location(); got != tt.wantLocation {
+			t.Errorf("Location() of %+v = %q, want %q", tt.file, got, tt.wantLocation)
+		}
+		if got := tt.file.DisplayPath("laptop"); got != tt.wantDisplay {
+			t.Errorf("DisplayPath(laptop) of %+v = %q, want %q", tt.file, got, tt.wantDisplay)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// HashSetKind tells what it means for a file to be in a known hash set
+type HashSetKind string
+
+const (
+	HashSetAllow HashSetKind = "allow" // Known good files, such as the operating system files of the NSRL
+	HashSetBlock HashSetKind = "block" // Known bad files, which are flagged
+)
+
+// HashSet is a list of known checksums imported into the index, such as the
+// NSRL reference data set or a blocklist
+type HashSet struct {
+	Name       string      `json:"name"`
+	Kind       HashSetKind `json:"kind"`
+	Source     string      `json:"source,omitempty"` // the file it was imported from
+	ImportedAt time.Time   `json:"imported_at"`
+	Hashes     int64       `json:"hashes"` // checksums of all algorithms
+}
+
+// KnownHash is a checksum of a known hash set
+type KnownHash struct {
+	Algorithm string
+	Checksum  string // lowercase hex
+}
+
+// KnownHashMatch is an indexed file whose checksum is in a known hash set
+type KnownHashMatch struct {
+	File      FileInfo    `json:"file"`
+	Set       string      `json:"set"`
+	Kind      HashSetKind `json:"kind"`
+	Algorithm string      `json:"algorithm"` // of the matching checksum
+}
@@ -0,0 +1,28 @@
+package models
+
+// HealthStatus is the outcome of an integrity check of a database
+type HealthStatus string
+
+const (
+	HealthOK      HealthStatus = "ok"      // Nothing was found
+	HealthWarning HealthStatus = "warning" // Suspicious data that the index still works with
+	HealthError   HealthStatus = "error"   // Broken data that commands may misreport or fail on
+)
+
+// HealthCheck is the result of one integrity check of a database
+type HealthCheck struct {
+	Name     string       `json:"name"`
+	Status   HealthStatus `json:"status"`
+	Count    int64        `json:"count"` // rows found by the check
+	Message  string       `json:"message"`
+	Repair   string       `json:"repair,omitempty"`   // how to fix what was found
+	Examples []string     `json:"examples,omitempty"` // some of the rows found, such as their paths
+}
+
+// HealthReport is the result of all integrity checks of a database
+type HealthReport struct {
+	SchemaVersion       int           `json:"schema_version"`        // the version this program brings databases to
+	StoredSchemaVersion int           `json:"stored_schema_version"` // the version recorded in the database (0 = none)
+	Healthy             bool          `json:"healthy"`               // no check found an error
+	Checks              []HealthCheck `json:"checks"`
+}
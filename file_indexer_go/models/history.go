@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// ScanSnapshot describes one scan of a root recorded in history mode
+type ScanSnapshot struct {
+	ID        int64     `json:"scan_id"`
+	Root      string    `json:"root"`
+	ScannedAt time.Time `json:"scanned_at"`
+	FileCount int64     `json:"file_count"`
+	TotalSize int64     `json:"total_size"`
+	Added     int64     `json:"added"`
+	Removed   int64     `json:"removed"`
+	Modified  int64     `json:"modified"`
+}
+
+// HistoryChange is a journal entry: a file that was added, removed or
+// modified by a scan. For removed files the last known state is recorded.
+type HistoryChange struct {
+	ScanID               int64     `json:"scan_id"`
+	ScannedAt            time.Time `json:"scanned_at"`
+	Path                 string    `json:"path"`
+	Change               string    `json:"change"` // added, removed or modified
+	Checksum             string    `json:"checksum,omitempty"`
+	FileSize             int64     `json:"file_size"`
+	ModificationDateTime time.Time `json:"modification_datetime"`
+}
+
+// HistoryQuery selects journal entries. Zero values do not restrict.
+type HistoryQuery struct {
+	ScanID int64     // Only changes recorded by this scan
+	After  time.Time // Only changes from scans at or after this time
+	Before time.Time // Only changes from scans before this time
+}
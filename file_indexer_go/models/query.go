@@ -0,0 +1,139 @@
+package models
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortField selects the order of query results
+type SortField string
+
+const (
+	SortName     SortField = "name"  // By filename (default)
+	SortPath     SortField = "path"  // By full path
+	SortSize     SortField = "size"  // By file size
+	SortModified SortField = "mtime" // By modification time
+)
+
+// ParseSortField validates the name of a sort field. An empty name selects
+// SortName.
+func ParseSortField(name string) (SortField, error) {
+	switch field := SortField(name); field {
+	case "":
+		return SortName, nil
+	case SortName, SortPath, SortSize, SortModified:
+		return field, nil
+	}
+	return "", fmt.Errorf("unknown sort field %q (supported: name, path, size, mtime)", name)
+}
+
+// FileQuery narrows down the files returned by search and list
+type FileQuery struct {
+	Dir            string    // Only files directly inside this directory (empty = any)
+	MinSize        int64     // Smallest file size in bytes (0 = no lower bound)
+	MaxSize        int64     // Largest file size in bytes (0 = no upper bound)
+	ModifiedAfter  time.Time // Only files modified at or after this time (zero = any)
+	ModifiedBefore time.Time // Only files modified before this time (zero = any)
+	XAttr          string    // Only files with this extended attribute, as name or name=value (empty = any)
+	Tag            string    // Only files with this Finder tag or user tag, ignoring case (empty = any)
+	Sort           SortField // Result order (empty = SortName); ties are broken by path
+	Desc           bool      // Reverse the order of Sort
+	Limit          int       // Maximum number of files returned (0 = no limit)
+	Offset         int       // Number of matching files skipped before the first one returned
+
+	// TaggedChecksums are the checksums that carry Tag as a user tag, which
+	// the indexer sets when filtering JSON indexes
+	TaggedChecksums map[string]bool
+}
+
+// Matches reports whether a file passes the query's filters. Limit and Offset
+// are applied separately by Page.
+func (q FileQuery) Matches(file FileInfo) bool {
+	if q.Dir != "" && filepath.Dir(file.Path) != filepath.Clean(q.Dir) {
+		return false
+	}
+	if q.MinSize > 0 && file.FileSize < q.MinSize {
+		return false
+	}
+	if q.MaxSize > 0 && file.FileSize > q.MaxSize {
+		return false
+	}
+	if !q.ModifiedAfter.IsZero() && file.ModificationDateTime.Before(q.ModifiedAfter) {
+		return false
+	}
+	if !q.ModifiedBefore.IsZero() && !file.ModificationDateTime.Before(q.ModifiedBefore) {
+		return false
+	}
+	if q.XAttr != "" {
+		name, value, hasValue := q.XAttrFilter()
+		stored, ok := file.XAttrs[name]
+		if !ok || hasValue && stored != value {
+			return false
+		}
+	}
+	if q.Tag != "" && !q.TaggedChecksums[file.Checksum] &&
+		!slices.ContainsFunc(file.FinderTags, func(tag string) bool { return strings.EqualFold(tag, q.Tag) }) {
+		return false
+	}
+	return true
+}
+
+// XAttrFilter splits the XAttr filter into the attribute name and the
+// value it must have, if any
+func (q FileQuery) XAttrFilter() (name, value string, hasValue bool) {
+	return strings.Cut(q.XAttr, "=")
+}
+
+// Page sorts matching files in the query's order and returns the part
+// selected by its offset and limit
+func (q FileQuery) Page(files []FileInfo) []FileInfo {
+	sort.Slice(files, func(a, b int) bool {
+		if c := q.compare(files[a], files[b]); c != 0 {
+			return c < 0
+		}
+		return files[a].Path < files[b].Path
+	})
+
+	if q.Offset > 0 {
+		if q.Offset >= len(files) {
+			return nil
+		}
+		files = files[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(files) {
+		files = files[:q.Limit]
+	}
+	return files
+}
+
+// compare orders two files by the query's sort field, returning a negative
+// number if a comes first and 0 if they are equal on that field
+func (q FileQuery) compare(a, b FileInfo) int {
+	var c int
+	switch q.Sort {
+	case SortPath:
+		c = strings.Compare(a.Path, b.Path)
+	case SortSize:
+		c = cmp.Compare(a.FileSize, b.FileSize)
+	case SortModified:
+		c = a.ModificationDateTime.Compare(b.ModificationDateTime)
+	default:
+		c = strings.Compare(a.Filename, b.Filename)
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+// RankedFile is a result of a ranked content search with the BM25 score of
+// its content, higher for better matches
+type RankedFile struct {
+	File  FileInfo `json:"file"`
+	Score float64  `json:"score"`
+}
@@ -0,0 +1,15 @@
+package models
+
+// SecretFinding is a credential or piece of personal data found in the
+// content of a file, such as an API key or a credit card number
+type SecretFinding struct {
+	Detector string `json:"detector"` // e.g. aws-access-key
+	Line     int    `json:"line"`
+	Match    string `json:"match"` // redacted, so the index does not hold the secret
+}
+
+// FileSecrets is a file with the secrets found in it
+type FileSecrets struct {
+	File     FileInfo        `json:"file"`
+	Findings []SecretFinding `json:"findings"`
+}
@@ -0,0 +1,361 @@
+package models
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SimilarImageGroup is a cluster of images whose perceptual hashes are within
+// a Hamming distance of each other, such as resized or re-exported copies of
+// a photo. Files start with the one of the highest resolution.
+type SimilarImageGroup struct {
+	Files     []FileInfo `json:"files"`
+	Distances []int      `json:"distances"` // of each file's hash from the first file's
+	TotalSize int64      `json:"total_size"`
+}
+
+// ImageHashDistance returns the number of bits in which two perceptual image
+// hashes differ, or -1 if either is not a valid hash
+func ImageHashDistance(a, b string) int {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// GroupSimilarImages clusters the files whose image hashes are at most
+// maxDistance bits apart. Files are linked into a group through any of its
+// members, so a group may hold images further apart than maxDistance. Groups
+// are returned in the path order of their first files.
+func GroupSimilarImages(files []FileInfo, maxDistance int) []SimilarImageGroup {
+	var groups []SimilarImageGroup
+	for _, cluster := range clusterHashes(files, func(file FileInfo) string { return file.ImageHash }, maxDistance) {
+		sort.SliceStable(cluster, func(a, b int) bool {
+			fa, fb := cluster[a].file, cluster[b].file
+			if pa, pb := fa.ImageWidth*fa.ImageHeight, fb.ImageWidth*fb.ImageHeight; pa != pb {
+				return pa > pb
+			}
+			if fa.FileSize != fb.FileSize {
+				return fa.FileSize > fb.FileSize
+			}
+			return fa.Path < fb.Path
+		})
+		var group SimilarImageGroup
+		for _, member := range cluster {
+			group.Files = append(group.Files, member.file)
+			group.Distances = append(group.Distances, bits.OnesCount64(cluster[0].hash^member.hash))
+			group.TotalSize += member.file.FileSize
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(a, b int) bool { return groups[a].Files[0].Path < groups[b].Files[0].Path })
+	return groups
+}
+
+// SimilarTextGroup is a cluster of text files whose simhashes are within a
+// Hamming distance of each other, such as slightly edited copies of a
+// document. Files start with the most recently modified one.
+type SimilarTextGroup struct {
+	Files      []FileInfo `json:"files"`
+	Similarity []float64  `json:"similarity"` // estimated similarity of each file to the first, in percent
+	TotalSize  int64      `json:"total_size"`
+}
+
+// SimHashSimilarity estimates the similarity in percent of two texts whose
+// simhashes differ in distance bits, as the cosine of the angle between their
+// word runs, which is about distance/64 of a half turn, rounded to a tenth
+func SimHashSimilarity(distance int) float64 {
+	return math.Round(1000*math.Cos(math.Pi*float64(distance)/64)) / 10
+}
+
+// SimHashDistance returns the largest number of differing simhash bits of
+// texts that are at least similarity percent similar
+func SimHashDistance(similarity float64) int {
+	for distance := 0; distance < 64; distance++ {
+		if SimHashSimilarity(distance+1) < similarity {
+			return distance
+		}
+	}
+	return 64
+}
+
+// GroupSimilarTexts clusters the files whose simhashes are at least
+// minSimilarity percent similar, linking them through any member of a group
+// like GroupSimilarImages. Groups of identical files, which share one
+// checksum, are left to duplicate detection. Groups are returned in the path
+// order of their first files.
+func GroupSimilarTexts(files []FileInfo, minSimilarity float64) []SimilarTextGroup {
+	var groups []SimilarTextGroup
+	for _, cluster := range clusterHashes(files, func(file FileInfo) string { return file.TextHash }, SimHashDistance(minSimilarity)) {
+		identical := true
+		for _, member := range cluster {
+			identical = identical && member.file.Checksum != "" && member.file.Checksum == cluster[0].file.Checksum
+		}
+		if identical {
+			continue
+		}
+		sort.SliceStable(cluster, func(a, b int) bool {
+			fa, fb := cluster[a].file, cluster[b].file
+			if !fa.ModificationDateTime.Equal(fb.ModificationDateTime) {
+				return fa.ModificationDateTime.After(fb.ModificationDateTime)
+			}
+			return fa.Path < fb.Path
+		})
+		var group SimilarTextGroup
+		for _, member := range cluster {
+			group.Files = append(group.Files, member.file)
+			group.Similarity = append(group.Similarity, SimHashSimilarity(bits.OnesCount64(cluster[0].hash^member.hash)))
+			group.TotalSize += member.file.FileSize
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(a, b int) bool { return groups[a].Files[0].Path < groups[b].Files[0].Path })
+	return groups
+}
+
+// hashedFile is a file with its parsed 64-bit similarity hash
+type hashedFile struct {
+	file FileInfo
+	hash uint64
+}
+
+// clusterHashes clusters the files whose 64-bit hashes, returned by hashOf as
+// hex digits, are at most maxDistance bits apart, and returns the clusters of
+// at least two files. Files without a valid hash are left out.
+func clusterHashes(files []FileInfo, hashOf func(FileInfo) string, maxDistance int) [][]hashedFile {
+	// Files with the same hash are clustered once
+	byHash := make(map[uint64][]FileInfo)
+	var hashes []uint64
+	for _, file := range files {
+		hash, err := strconv.ParseUint(hashOf(file), 16, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := byHash[hash]; !ok {
+			hashes = append(hashes, hash)
+		}
+		byHash[hash] = append(byHash[hash], file)
+	}
+	sort.Slice(hashes, func(a, b int) bool { return hashes[a] < hashes[b] })
+
+	clusters := newUnionFind(len(hashes))
+	tree := &bkTree{}
+	for n, hash := range hashes {
+		tree.search(hash, maxDistance, func(other int) { clusters.union(n, other) })
+		tree.add(hash, n)
+	}
+
+	members := make(map[int][]hashedFile)
+	var roots []int
+	for n, hash := range hashes {
+		root := clusters.find(n)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		for _, file := range byHash[hash] {
+			members[root] = append(members[root], hashedFile{file: file, hash: hash})
+		}
+	}
+
+	var result [][]hashedFile
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			result = append(result, members[root])
+		}
+	}
+	return result
+}
+
+// bkTree indexes hashes by Hamming distance, so the hashes near one are
+// found without comparing it to all of them
+type bkTree struct {
+	root *bkNode
+}
+
+// bkNode holds a hash and the subtrees of the hashes at each distance from it
+type bkNode struct {
+	hash     uint64
+	item     int
+	children map[int]*bkNode
+}
+
+// add inserts a hash, identified by item
+func (t *bkTree) add(hash uint64, item int) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, item: item}
+		return
+	}
+	node := t.root
+	for {
+		distance := bits.OnesCount64(node.hash ^ hash)
+		child, ok := node.children[distance]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[distance] = &bkNode{hash: hash, item: item}
+			return
+		}
+		node = child
+	}
+}
+
+// search calls found with the items whose hashes are at most maxDistance
+// bits from hash
+func (t *bkTree) search(hash uint64, maxDistance int, found func(item int)) {
+	if t.root == nil {
+		return
+	}
+	pending := []*bkNode{t.root}
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		distance := bits.OnesCount64(node.hash ^ hash)
+		if distance <= maxDistance {
+			found(node.item)
+		}
+		// By the triangle inequality, matches only lie in subtrees at
+		// distance-maxDistance..distance+maxDistance
+		for childDistance, child := range node.children {
+			if childDistance >= distance-maxDistance && childDistance <= distance+maxDistance {
+				pending = append(pending, child)
+			}
+		}
+	}
+}
+
+// unionFind tracks the clusters that items were merged into
+type unionFind []int
+
+// newUnionFind puts each of n items into a cluster of its own
+func newUnionFind(n int) unionFind {
+	parents := make(unionFind, n)
+	for i := range parents {
+		parents[i] = i
+	}
+	return parents
+}
+
+// find returns the item representing the cluster of an item
+func (u unionFind) find(item int) int {
+	for u[item] != item {
+		u[item] = u[u[item]]
+		item = u[item]
+	}
+	return item
+}
+
+// union merges the clusters of two items
+func (u unionFind) union(a, b int) {
+	if rootA, rootB := u.find(a), u.find(b); rootA != rootB {
+		u[rootB] = rootA
+	}
+}
+
+// PhotoGroup is a set of photos of the same shot: the same EXIF capture
+// time, pixel dimensions and camera, such as an original and the copies a
+// photo library exported of it, whose metadata and bytes differ. Files start
+// with the largest one.
+type PhotoGroup struct {
+	TakenAt      time.Time  `json:"taken_at"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	CameraModel  string     `json:"camera_model,omitempty"`
+	CameraSerial string     `json:"camera_serial,omitempty"`
+	Identical    bool       `json:"identical"` // all files have the same checksum
+	Files        []FileInfo `json:"files"`
+	TotalSize    int64      `json:"total_size"`
+}
+
+// photoKey identifies a shot. Dimensions are ordered, so a copy rotated
+// according to its EXIF orientation is the same shot.
+type photoKey struct {
+	takenAt     time.Time
+	short, long int
+	cameraModel string
+}
+
+// GroupPhotos groups the files with a capture time and dimensions by shot.
+// Files with different camera serial numbers are different shots, as of two
+// cameras fired at once; files without one join the only serial number of
+// their shot, or else form their own group. Groups are returned in the order
+// of their capture times.
+func GroupPhotos(files []FileInfo) []PhotoGroup {
+	byKey := make(map[photoKey][]FileInfo)
+	for _, file := range files {
+		if file.TakenAt.IsZero() || file.ImageWidth == 0 || file.ImageHeight == 0 {
+			continue
+		}
+		// DuckDB keeps microseconds, so compare capture times at that precision
+		key := photoKey{
+			takenAt:     file.TakenAt.Truncate(time.Microsecond).UTC(),
+			short:       min(file.ImageWidth, file.ImageHeight),
+			long:        max(file.ImageWidth, file.ImageHeight),
+			cameraModel: file.CameraModel,
+		}
+		byKey[key] = append(byKey[key], file)
+	}
+
+	var groups []PhotoGroup
+	for _, members := range byKey {
+		if len(members) < 2 {
+			continue
+		}
+		bySerial := make(map[string][]FileInfo)
+		for _, file := range members {
+			bySerial[file.CameraSerial] = append(bySerial[file.CameraSerial], file)
+		}
+		if len(bySerial) == 2 && len(bySerial[""]) > 0 {
+			for serial, files := range bySerial {
+				if serial != "" {
+					bySerial[serial] = append(files, bySerial[""]...)
+				}
+			}
+			delete(bySerial, "")
+		}
+		for serial, shot := range bySerial {
+			if len(shot) > 1 {
+				groups = append(groups, newPhotoGroup(shot, serial))
+			}
+		}
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		if !groups[a].TakenAt.Equal(groups[b].TakenAt) {
+			return groups[a].TakenAt.Before(groups[b].TakenAt)
+		}
+		return groups[a].Files[0].Path < groups[b].Files[0].Path
+	})
+	return groups
+}
+
+// newPhotoGroup builds the group of the photos of one shot
+func newPhotoGroup(files []FileInfo, serial string) PhotoGroup {
+	sort.Slice(files, func(a, b int) bool {
+		if files[a].FileSize != files[b].FileSize {
+			return files[a].FileSize > files[b].FileSize
+		}
+		return files[a].Path < files[b].Path
+	})
+	first := files[0]
+	group := PhotoGroup{
+		TakenAt:      first.TakenAt,
+		Width:        first.ImageWidth,
+		Height:       first.ImageHeight,
+		CameraModel:  first.CameraModel,
+		CameraSerial: serial,
+		Identical:    first.Checksum != "",
+		Files:        files,
+	}
+	for _, file := range files {
+		group.TotalSize += file.FileSize
+		if file.Checksum != first.Checksum {
+			group.Identical = false
+		}
+	}
+	return group
+}
@@ -0,0 +1,15 @@
+package models
+
+import "fmt"
+
+// FormatSize formats a byte count in human readable form
+func FormatSize(size int64) string {
+	value := float64(size)
+	for _, unit := range []string{"B", "KB", "MB", "GB", "TB"} {
+		if value < 1024.0 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+		value /= 1024.0
+	}
+	return fmt.Sprintf("%.1f PB", value)
+}
@@ -0,0 +1,23 @@
+package models
+
+import "testing"
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0.0 B"},
+		{1023, "1023.0 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{5 << 20, "5.0 MB"},
+		{3 << 40, "3.0 TB"},
+		{2 << 50, "2.0 PB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.size); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
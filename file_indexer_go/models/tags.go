@@ -0,0 +1,21 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// FileTag is a tag the user put on a file. Tags are keyed by the checksum of
+// the file, so they follow it when it is moved or renamed and apply to all
+// copies of a duplicate group.
+type FileTag struct {
+	Checksum string    `json:"checksum"`
+	Tag      string    `json:"tag"`
+	TaggedAt time.Time `json:"tagged_at"`
+}
+
+// NormalizeTag returns the stored form of a tag, which is matched ignoring
+// case and surrounding spaces
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
@@ -0,0 +1,182 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// macros are the shorthands accepted in place of the five fields
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// monthNames and dayNames may be used instead of numbers, as in crontab
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// maxSearch bounds the search for the next matching time, so schedules that
+// never match, such as February 30th, do not loop forever
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	expr    string
+	minute  uint64 // bit n set if minute n matches
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	anyDay  bool // the day of month is *, so only the day of week restricts days
+	anyWeek bool // the day of week is *, so only the day of month restricts days
+}
+
+// Parse parses a cron expression of five fields, minute, hour, day of month,
+// month and day of week, as in crontab: each field is *, a number, a range
+// such as 1-5, a list such as 1,15 or any of these with a step such as */15.
+// Months and days of the week may be given by their first three letters,
+// and Sunday is 0 or 7. The macros @hourly, @daily, @weekly, @monthly and
+// @yearly are accepted too.
+func Parse(expr string) (*Schedule, error) {
+	fields := strings.Fields(strings.ToLower(expr))
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "@") {
+		macro, ok := macros[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule %q", expr)
+		}
+		fields = strings.Fields(macro)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q must have five fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	s := &Schedule{expr: expr}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in %q: %v", expr, err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in %q: %v", expr, err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in %q: %v", expr, err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid month in %q: %v", expr, err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week in %q: %v", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	s.anyDay, s.anyWeek = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// String returns the expression the schedule was parsed from
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first matching minute after t, in t's location, or the
+// zero time if the schedule matches none in the next five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t matches. As in cron, a day matches
+// either restriction when both the day of month and the day of week are
+// restricted.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeek {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// parseField parses one comma-separated field into a bit set of the values
+// between min and max it matches
+func parseField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		spec, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		low, high := min, max
+		if spec != "*" {
+			lowText, highText, isRange := strings.Cut(spec, "-")
+			var err error
+			if low, err = parseValue(lowText, min, max, names); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseValue(highText, min, max, names); err != nil {
+					return 0, err
+				}
+				if high < low {
+					return 0, fmt.Errorf("range %q ends before it starts", spec)
+				}
+			} else if hasStep {
+				high = max // 5/15 means 5, 20, 35 and 50
+			}
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// parseValue parses a number between min and max, or one of names, which
+// stand for min, min+1 and so on
+func parseValue(text string, min, max int, names []string) (int, error) {
+	for n, name := range names {
+		if text == name {
+			return min + n, nil
+		}
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("%d is not between %d and %d", value, min, max)
+	}
+	return value, nil
+}
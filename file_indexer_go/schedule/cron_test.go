@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@often",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2024-05-01 is a Wednesday
+	from := time.Date(2024, 5, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)},
+		{"5/15 * * * *", time.Date(2024, 5, 1, 10, 20, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * *", time.Date(2024, 5, 1, 13, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * jun *", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * sat", time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC)},
+		// Sunday is 0 or 7
+		{"0 0 * * 0", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		// Either the day of month or the day of week matches when both are
+		// restricted, so Friday the 3rd comes before the 10th
+		{"0 0 10 * fri", time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 10 * *", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		schedule, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Next of %q = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestNextNeverMatches(t *testing.T) {
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		schedule, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", expr, err)
+		}
+		if got := schedule.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+			t.Errorf("Next of %q = %v, want the zero time", expr, got)
+		}
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	schedule, err := Parse("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := schedule.Next(time.Date(2024, 5, 1, 4, 0, 0, 0, loc))
+	if want := time.Date(2024, 5, 2, 3, 0, 0, 0, loc); !got.Equal(want) || got.Location() != loc {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,245 @@
+// Package secrets finds credentials and personal data, such as API keys,
+// private keys and credit card numbers, in the text of files
+package secrets
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+// DefaultEntropy is the lowest Shannon entropy, in bits per character, of
+// the tokens the high-entropy detector reports. Random base64 strings of 32
+// characters and more reach it; words, identifiers and hex checksums do not.
+const DefaultEntropy = 4.5
+
+// entropyDetector is the name of the findings of random-looking tokens
+const entropyDetector = "high-entropy"
+
+// maxFindings bounds the findings recorded per file, so a dump of keys does
+// not flood the index
+const maxFindings = 100
+
+// entropyToken matches the candidates of the high-entropy detector
+var entropyToken = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+
+// Detector finds one kind of secret with a regular expression
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	valid   func(match string) bool // confirms a match, e.g. with a checksum; nil accepts all
+}
+
+// builtinDetectors are the detectors of every scanner
+var builtinDetectors = []Detector{
+	{Name: "private-key", Pattern: regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP|ENCRYPTED) )?PRIVATE KEY( BLOCK)?-----`)},
+	{Name: "aws-access-key", Pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{Name: "google-api-key", Pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{Name: "stripe-key", Pattern: regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`)},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{Name: "password-assignment", Pattern: regexp.MustCompile(`(?i)\b(password|passwd|pwd|secret|api_?key|access_?token)\b["']?\s*[:=]\s*["']?[^\s"'<>$%{}]{6,}`)},
+	{Name: "credit-card", Pattern: regexp.MustCompile(`\b[2-6]\d{3}([ -]?\d{4}){2}[ -]?\d{1,7}\b`), valid: validCardNumber},
+	{Name: "iban", Pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}( ?[A-Z0-9]{4}){2,7}( ?[A-Z0-9]{1,4})?\b`), valid: validIBAN},
+	{Name: "us-ssn", Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), valid: validSSN},
+}
+
+// DetectorNames returns the names of the built-in detectors, including the
+// high-entropy one
+func DetectorNames() []string {
+	names := make([]string, 0, len(builtinDetectors)+1)
+	for _, detector := range builtinDetectors {
+		names = append(names, detector.Name)
+	}
+	return append(names, entropyDetector)
+}
+
+// Scanner runs detectors over texts. It is safe for concurrent use.
+type Scanner struct {
+	detectors []Detector
+	entropy   float64 // of the high-entropy detector, 0 = off
+}
+
+// NewScanner returns a scanner with the built-in detectors, changed by the
+// rules of rulesFile if it is not empty, that reports tokens of at least
+// entropy bits per character (0 = none). Each line of a rules file holds one
+// rule:
+//
+//	NAME REGEXP     also report the matches of a regular expression as NAME
+//	disable NAME    do not run a built-in detector, e.g. us-ssn
+//
+// Empty lines and lines starting with # are ignored.
+func NewScanner(rulesFile string, entropy float64) (*Scanner, error) {
+	s := &Scanner{detectors: slices.Clone(builtinDetectors), entropy: entropy}
+	if rulesFile == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret rules: %v", err)
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			return nil, fmt.Errorf("%s:%d: %s needs a regular expression", rulesFile, n+1, name)
+		}
+		if name == "disable" {
+			if arg == entropyDetector {
+				s.entropy = 0
+				continue
+			}
+			count := len(s.detectors)
+			s.detectors = slices.DeleteFunc(s.detectors, func(detector Detector) bool { return detector.Name == arg })
+			if len(s.detectors) == count {
+				return nil, fmt.Errorf("%s:%d: unknown detector %q", rulesFile, n+1, arg)
+			}
+			continue
+		}
+		pattern, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", rulesFile, n+1, err)
+		}
+		s.detectors = append(s.detectors, Detector{Name: name, Pattern: pattern})
+	}
+	return s, nil
+}
+
+// Scan returns the findings in a text in the order of its lines, with
+// their matches redacted. It returns an empty, non-nil slice if there are
+// none.
+func (s *Scanner) Scan(text string) []models.SecretFinding {
+	findings := []models.SecretFinding{}
+	for n, line := range strings.Split(text, "\n") {
+		var found [][]int // where the detectors matched on the line
+		for _, detector := range s.detectors {
+			for _, loc := range detector.Pattern.FindAllStringIndex(line, -1) {
+				match := line[loc[0]:loc[1]]
+				if detector.valid != nil && !detector.valid(match) {
+					continue
+				}
+				found = append(found, loc)
+				findings = append(findings, models.SecretFinding{Detector: detector.Name, Line: n + 1, Match: redact(match)})
+			}
+		}
+		// Tokens a detector already reported, such as API keys, are random
+		// too but not reported twice
+		if s.entropy > 0 {
+			for _, loc := range entropyToken.FindAllStringIndex(line, -1) {
+				overlaps := slices.ContainsFunc(found, func(other []int) bool { return loc[0] < other[1] && other[0] < loc[1] })
+				if token := line[loc[0]:loc[1]]; !overlaps && randomLooking(token, s.entropy) {
+					findings = append(findings, models.SecretFinding{Detector: entropyDetector, Line: n + 1, Match: redact(token)})
+				}
+			}
+		}
+		if len(findings) >= maxFindings {
+			return findings[:maxFindings]
+		}
+	}
+	return findings
+}
+
+// redact keeps the first and last characters of a match, so the index tells
+// findings apart without holding the secrets themselves
+func redact(match string) string {
+	runes := []rune(match)
+	switch {
+	case len(runes) > 16:
+		return string(runes[:6]) + "..." + string(runes[len(runes)-4:])
+	case len(runes) > 8:
+		return string(runes[:4]) + "..." + string(runes[len(runes)-2:])
+	default:
+		return string(runes[:min(len(runes), 2)]) + "..."
+	}
+}
+
+// randomLooking reports whether a token mixes letters and digits and has at
+// least the given entropy in bits per character
+func randomLooking(token string, entropy float64) bool {
+	if !strings.ContainsAny(token, "0123456789") || strings.IndexFunc(token, isLetter) < 0 {
+		return false
+	}
+	counts := make(map[rune]int)
+	for _, r := range token {
+		counts[r]++
+	}
+	bits := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(len(token))
+		bits -= p * math.Log2(p)
+	}
+	return bits >= entropy
+}
+
+// isLetter reports whether r is an ASCII letter
+func isLetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// digits returns the digits of s
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// validCardNumber reports whether a match has the length and Luhn checksum
+// of a payment card number
+func validCardNumber(match string) bool {
+	number := digits(match)
+	if len(number) < 13 || len(number) > 19 || strings.Count(number, number[:1]) == len(number) {
+		return false
+	}
+	sum := 0
+	for n := range len(number) {
+		digit := int(number[len(number)-1-n] - '0')
+		if n%2 == 1 {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// validIBAN reports whether a match has the ISO 13616 checksum of an IBAN
+func validIBAN(match string) bool {
+	iban := strings.ReplaceAll(match, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	remainder := 0
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			remainder = (remainder*10 + int(r-'0')) % 97
+		case r >= 'A' && r <= 'Z':
+			remainder = (remainder*100 + int(r-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
+
+// validSSN reports whether a match is a US social security number that can
+// be issued: the area is not 000, 666 or 900 and above, and neither the
+// group nor the serial is zero
+func validSSN(match string) bool {
+	area, group, serial := match[:3], match[4:6], match[7:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
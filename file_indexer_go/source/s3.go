@@ -0,0 +1,138 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Scheme prefixes the paths of S3 objects in the index
+const S3Scheme = "s3://"
+
+// md5ETag matches ETags that are the MD5 checksum of the object. Multipart
+// uploads have ETags ending in a part count instead.
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// S3Config describes how to connect to an S3-compatible service
+type S3Config struct {
+	Endpoint string // URL or host of the service, such as http://localhost:9000 for MinIO
+	Region   string
+}
+
+// S3Object is an object listed from a bucket
+type S3Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// MD5 returns the MD5 checksum of the object if its ETag is one
+func (o S3Object) MD5() (string, bool) {
+	etag := strings.ToLower(strings.Trim(o.ETag, `"`))
+	return etag, md5ETag.MatchString(etag)
+}
+
+// S3 lists and reads the objects of an S3-compatible service
+type S3 struct {
+	client *minio.Client
+}
+
+// NewS3 connects to an S3-compatible service. Credentials are taken from the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, the AWS
+// credentials file or MINIO_ACCESS_KEY and MINIO_SECRET_KEY, in that order;
+// without any, requests are anonymous.
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.EnvMinio{},
+	})
+	client, err := minio.New(u.Host, &minio.Options{Creds: creds, Secure: u.Scheme == "https", Region: region})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", endpoint, err)
+	}
+	return &S3{client: client}, nil
+}
+
+// IsS3URL reports whether a path is an s3://bucket/prefix URL
+func IsS3URL(path string) bool {
+	return strings.HasPrefix(path, S3Scheme)
+}
+
+// ParseS3URL splits an s3://bucket/prefix URL into the bucket and the key
+// prefix, which has no leading or trailing slash
+func ParseS3URL(s3URL string) (string, string, error) {
+	if !IsS3URL(s3URL) {
+		return "", "", fmt.Errorf("%s is not an s3:// URL", s3URL)
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(s3URL, S3Scheme), "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%s does not name a bucket", s3URL)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// S3URL returns the s3:// URL of an object
+func S3URL(bucket, key string) string {
+	return S3Scheme + bucket + "/" + key
+}
+
+// List calls fn for every object of bucket below prefix, in key order
+func (s *S3) List(ctx context.Context, bucket, prefix string, fn func(S3Object) error) error {
+	if prefix != "" {
+		prefix += "/"
+	}
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true}
+	for object := range s.client.ListObjects(ctx, bucket, opts) {
+		if object.Err != nil {
+			return fmt.Errorf("error listing s3://%s/%s: %v", bucket, prefix, object.Err)
+		}
+		// Keys ending in a slash are folder placeholders, not files
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		err := fn(S3Object{Key: object.Key, Size: object.Size, ModTime: object.LastModified, ETag: object.ETag})
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Open streams the content of an object
+func (s *S3) Open(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", S3URL(bucket, key), err)
+	}
+	return object, nil
+}
@@ -0,0 +1,198 @@
+// Package source abstracts the filesystems files are indexed from. The
+// indexer walks and reads files only through an FS, so local disks, archives
+// and test fixtures are indexed by the same code.
+package source
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FS is a filesystem files can be indexed from. Names are the paths stored
+// in the index: OS paths for the local filesystem, and paths below the mount
+// point for filesystems adapted with FromFS.
+type FS interface {
+	// Open opens a file for reading
+	Open(name string) (File, error)
+	// Stat returns the info of a file, following symlinks
+	Stat(name string) (fs.FileInfo, error)
+	// Lstat returns the info of a file without following symlinks
+	Lstat(name string) (fs.FileInfo, error)
+	// ReadDir returns the entries of a directory sorted by name
+	ReadDir(name string) ([]fs.DirEntry, error)
+	// ReadLink returns the target of a symlink
+	ReadLink(name string) (string, error)
+}
+
+// File is an open file. Random access is needed for quick hashes and by the
+// metadata extractors.
+type File interface {
+	fs.File
+	io.ReaderAt
+	io.Seeker
+}
+
+// OS is the local filesystem
+var OS FS = osFS{}
+
+// osFS implements FS with the os package
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) ReadLink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// FromFS mounts an io/fs filesystem, such as a zip archive or an
+// fstest.MapFS, at root: the file "a/b.txt" of fsys is indexed as
+// root/a/b.txt. io/fs has no symlinks, so Lstat equals Stat. Files that do
+// not support random access are read into memory when opened.
+func FromFS(fsys fs.FS, root string) FS {
+	return mountFS{fsys: fsys, root: filepath.Clean(root)}
+}
+
+// mountFS implements FS for an io/fs filesystem mounted at root
+type mountFS struct {
+	fsys fs.FS
+	root string
+}
+
+// fsName converts a name below the mount point to an io/fs path
+func (m mountFS) fsName(op, name string) (string, error) {
+	rel, err := filepath.Rel(m.root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (m mountFS) Open(name string) (File, error) {
+	fsName, err := m.fsName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := m.fsys.Open(fsName)
+	if err != nil {
+		return nil, err
+	}
+	if random, ok := file.(File); ok {
+		return random, nil
+	}
+
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+func (m mountFS) Stat(name string) (fs.FileInfo, error) {
+	fsName, err := m.fsName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(m.fsys, fsName)
+}
+
+func (m mountFS) Lstat(name string) (fs.FileInfo, error) {
+	return m.Stat(name)
+}
+
+func (m mountFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsName, err := m.fsName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(m.fsys, fsName)
+}
+
+func (m mountFS) ReadLink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+// memFile is a file read into memory
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// WalkDir walks the tree rooted at root like filepath.WalkDir, calling fn
+// for every file and directory in lexical order. The root is not followed
+// if it is a symlink, unless its name ends with a path separator.
+func WalkDir(fsys FS, root string, fn fs.WalkDirFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkDir recursively descends into the directory entry d at path
+func walkDir(fsys FS, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		// Report the error a second time, letting fn skip the directory
+		if err = fn(path, d, err); err != nil {
+			if err == filepath.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Name() < entries[b].Name() })
+
+	for _, entry := range entries {
+		if err := walkDir(fsys, filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
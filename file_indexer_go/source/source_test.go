@@ -0,0 +1,130 @@
+package source
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// testFS is a tree mounted at /mem with files in nested directories
+func testFS() FS {
+	return FromFS(fstest.MapFS{
+		"b.txt":         {Data: []byte("b")},
+		"a/z.txt":       {Data: []byte("z")},
+		"a/y/x.txt":     {Data: []byte("x")},
+		"a/skip/w.txt":  {Data: []byte("w")},
+		"c/stop.txt":    {Data: []byte("stop")},
+		"c/unseen.txt":  {Data: []byte("unseen")},
+		"d/nested/e.md": {Data: []byte("e")},
+	}, "/mem")
+}
+
+func TestWalkDir(t *testing.T) {
+	tests := []struct {
+		name string
+		root string
+		skip map[string]error // what fn returns for a path
+		want []string
+	}{
+		{
+			name: "all",
+			root: "/mem",
+			want: []string{"/mem", "/mem/a", "/mem/a/skip", "/mem/a/skip/w.txt", "/mem/a/y", "/mem/a/y/x.txt", "/mem/a/z.txt",
+				"/mem/b.txt", "/mem/c", "/mem/c/stop.txt", "/mem/c/unseen.txt", "/mem/d", "/mem/d/nested", "/mem/d/nested/e.md"},
+		},
+		{
+			name: "subtree",
+			root: "/mem/d",
+			want: []string{"/mem/d", "/mem/d/nested", "/mem/d/nested/e.md"},
+		},
+		{
+			name: "skip a directory",
+			root: "/mem/a",
+			skip: map[string]error{"/mem/a/skip": filepath.SkipDir},
+			want: []string{"/mem/a", "/mem/a/skip", "/mem/a/y", "/mem/a/y/x.txt", "/mem/a/z.txt"},
+		},
+		{
+			name: "skip the rest of a directory from a file",
+			root: "/mem/c",
+			skip: map[string]error{"/mem/c/stop.txt": filepath.SkipDir},
+			want: []string{"/mem/c", "/mem/c/stop.txt"},
+		},
+		{
+			name: "skip all",
+			root: "/mem",
+			skip: map[string]error{"/mem/a/y": filepath.SkipAll},
+			want: []string{"/mem", "/mem/a", "/mem/a/skip", "/mem/a/skip/w.txt", "/mem/a/y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := WalkDir(testFS(), tt.root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				got = append(got, path)
+				return tt.skip[path]
+			})
+			if err != nil {
+				t.Fatalf("WalkDir: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("walked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalkDirMissingRoot(t *testing.T) {
+	var reported error
+	err := WalkDir(testFS(), "/mem/missing", func(path string, d fs.DirEntry, err error) error {
+		reported = err
+		return err
+	})
+	if !errors.Is(reported, fs.ErrNotExist) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("walk of a missing root reported %v and returned %v, want fs.ErrNotExist", reported, err)
+	}
+}
+
+func TestFromFS(t *testing.T) {
+	fsys := testFS()
+
+	file, err := fsys.Open("/mem/a/y/x.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer file.Close()
+	buf := make([]byte, 1)
+	if _, err := file.ReadAt(buf, 0); err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if string(buf) != "x" {
+		t.Errorf("read %q, want %q", buf, "x")
+	}
+
+	entries, err := fsys.ReadDir("/mem/a")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"skip", "y", "z.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir = %v, want %v", names, want)
+	}
+
+	for _, name := range []string{"/elsewhere/b.txt", "/mem/../b.txt", "/me"} {
+		if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q) = %v, want fs.ErrNotExist", name, err)
+		}
+	}
+	if _, err := fsys.ReadLink("/mem/b.txt"); err == nil {
+		t.Errorf("ReadLink succeeded on a filesystem without symlinks")
+	}
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/db"
+)
+
+// sqlContentTypes are the content types of the output formats of /api/sql
+var sqlContentTypes = map[db.SQLOutput]string{
+	db.SQLOutputTable:    "text/plain; charset=utf-8",
+	db.SQLOutputCSV:      "text/csv; charset=utf-8",
+	db.SQLOutputJSON:     "application/json",
+	db.SQLOutputMarkdown: "text/markdown; charset=utf-8",
+}
+
+// handleSQL runs the SQL statement of the query parameter, with the arg
+// parameters bound to its placeholders, and writes its result in the format
+// of the output parameter, as the sql command prints it
+func (s *Server) handleSQL(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+	if query == "" {
+		http.Error(w, "missing query", http.StatusBadRequest)
+		return
+	}
+	output := db.SQLOutputTable
+	if name := r.FormValue("output"); name != "" {
+		var err error
+		if output, err = db.ParseSQLOutput(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var args []interface{}
+	for _, arg := range r.Form["arg"] {
+		args = append(args, arg)
+	}
+	_, client := s.auth.authenticate(r)
+	slog.Info("Running SQL for a client", "client", client, "query", query, "args", len(args))
+
+	// Buffer the result, so an error halfway through is not sent as one
+	var out bytes.Buffer
+	if err := s.indexer.ExecuteSQL(r.Context(), &out, output, query, args...); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", sqlContentTypes[output])
+	w.Write(out.Bytes())
+}
+
+// handleRescan starts a scan of the indexed roots, or of the roots given by
+// the dir parameters, with the scan options of the server. Only one scan runs
+// at a time; it continues after the response, until the server stops.
+func (s *Server) handleRescan(w http.ResponseWriter, r *http.Request) {
+	if s.ctx == nil {
+		http.Error(w, "rescans require a database index that is not opened read-only", http.StatusBadRequest)
+		return
+	}
+	roots, err := s.indexer.Roots(r.Context())
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	indexed := make(map[string]bool)
+	var dirs []string
+	for _, root := range roots {
+		indexed[root.Path] = true
+		dirs = append(dirs, root.Path)
+	}
+	if r.ParseForm(); len(r.Form["dir"]) > 0 {
+		// Only indexed roots, so clients cannot make the server read other
+		// directories
+		dirs = nil
+		for _, dir := range r.Form["dir"] {
+			if dir = filepath.Clean(dir); !indexed[dir] {
+				http.Error(w, fmt.Sprintf("%s is not an indexed root", dir), http.StatusBadRequest)
+				return
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		http.Error(w, "the index has no roots to rescan", http.StatusBadRequest)
+		return
+	}
+
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if s.scanning {
+		http.Error(w, "a rescan is already running", http.StatusConflict)
+		return
+	}
+	s.scanning = true
+	s.scans.Add(1)
+	_, client := s.auth.authenticate(r)
+	slog.Info("Rescanning for a client", "client", client, "dirs", dirs)
+	go func() {
+		defer s.scans.Done()
+		start := time.Now()
+		if err := s.indexer.IndexDirectories(s.ctx, dirs, s.scanOptions); err != nil {
+			slog.Error("Error rescanning", "dirs", dirs, "err", err)
+		} else {
+			slog.Info("Rescanned", "dirs", dirs, "duration", time.Since(start).Round(time.Second))
+		}
+		s.scanMu.Lock()
+		s.scanning = false
+		s.scanMu.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Rescanning %d roots\n", len(dirs))
+}
+
+// Wait waits for a running rescan, which stops once the context of the
+// server is cancelled
+func (s *Server) Wait() {
+	s.scans.Wait()
+}
@@ -0,0 +1,187 @@
+package web
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// minTokenLength is the length API tokens must have at least, so that they
+// cannot be guessed
+const minTokenLength = 16
+
+// Scope is what a client of the server is allowed to do
+type Scope int
+
+const (
+	ScopeNone  Scope = iota // Nothing; the client has to authenticate
+	ScopeRead               // Browse, search and view statistics
+	ScopeWrite              // Also run SQL queries and rescans
+)
+
+// ParseScope parses the name of a scope: read or write
+func ParseScope(name string) (Scope, error) {
+	switch name {
+	case "read":
+		return ScopeRead, nil
+	case "write":
+		return ScopeWrite, nil
+	}
+	return ScopeNone, fmt.Errorf("unknown scope %q (supported: read, write)", name)
+}
+
+// String returns the name of the scope
+func (s Scope) String() string {
+	switch s {
+	case ScopeRead:
+		return "read"
+	case ScopeWrite:
+		return "write"
+	}
+	return "none"
+}
+
+// Token is an API token clients authenticate with
+type Token struct {
+	Name   string // shown in the log of the requests made with the token
+	Secret string
+	Scope  Scope
+}
+
+// LoadTokens reads a tokens file, which has a token per line as
+// "SCOPE TOKEN [NAME]". Empty lines and lines starting with # are skipped.
+func LoadTokens(path string) ([]Token, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens []Token
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected SCOPE TOKEN [NAME]", path, line)
+		}
+		scope, err := ParseScope(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		token := Token{Name: fmt.Sprintf("line %d", line), Secret: fields[1], Scope: scope}
+		if len(fields) == 3 {
+			token.Name = fields[2]
+		}
+		if len(token.Secret) < minTokenLength {
+			return nil, fmt.Errorf("%s:%d: tokens must be at least %d characters long", path, line, minTokenLength)
+		}
+		if seen[token.Secret] {
+			return nil, fmt.Errorf("%s:%d: duplicate token", path, line)
+		}
+		seen[token.Secret] = true
+		tokens = append(tokens, token)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s has no tokens", path)
+	}
+	return tokens, nil
+}
+
+// Auth configures how the server authenticates its clients. Without tokens or
+// client certificates every client may read, and nobody may write.
+type Auth struct {
+	Tokens []Token
+	// CertScope is the scope of clients presenting a certificate that the
+	// TLS server verified, which a token may raise
+	CertScope Scope
+}
+
+// enabled reports whether clients have to authenticate
+func (a Auth) enabled() bool {
+	return len(a.Tokens) > 0 || a.CertScope != ScopeNone
+}
+
+// authenticate returns the scope of the client of a request and the name it
+// authenticated with
+func (a Auth) authenticate(r *http.Request) (Scope, string) {
+	if !a.enabled() {
+		return ScopeRead, ""
+	}
+	scope, name := ScopeNone, ""
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && a.CertScope != ScopeNone {
+		scope, name = a.CertScope, "certificate "+r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if secret := requestToken(r); secret != "" {
+		// Compare with every token, so the time taken tells nothing about them
+		for _, token := range a.Tokens {
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(token.Secret)) == 1 && token.Scope > scope {
+				scope, name = token.Scope, token.Name
+			}
+		}
+	}
+	return scope, name
+}
+
+// requestToken returns the token of a request, sent as a bearer token or as
+// the password of basic authentication, which browsers ask for
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// require only passes requests of clients with at least the scope on to the
+// handler
+func (s *Server) require(scope Scope, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		granted, name := s.auth.authenticate(r)
+		if granted == ScopeNone {
+			w.Header().Set("WWW-Authenticate", `Basic realm="file_indexer", charset="UTF-8"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if granted < scope {
+			slog.Warn("Denied request", "url", r.URL.Path, "client", name, "scope", granted, "required", scope)
+			http.Error(w, "this requires the "+scope.String()+" scope", http.StatusForbidden)
+			return
+		}
+		// Browsers send the credentials of basic authentication with requests
+		// that other sites make them send, so writes must come from this one
+		if scope == ScopeWrite && !sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// sameOrigin reports whether a request was made by a page of the server, or
+// not by a browser at all
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == r.Host
+}
@@ -0,0 +1,382 @@
+// Package web serves a browser interface for an index, and for clients
+// allowed to write, SQL queries and rescans
+package web
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/indexer"
+	"github.com/krzysbaranski/file-indexer/file_indexer_go/models"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+//go:embed static
+var staticFiles embed.FS
+
+// pageSize is the number of files or duplicate groups shown per page
+const pageSize = 100
+
+// pages lists the templates that are rendered inside the layout
+var pages = []string{"browse", "search", "duplicates", "stats"}
+
+// Options configures a server
+type Options struct {
+	Auth Auth
+	// ScanContext lets clients with the write scope start rescans, which run
+	// with Scan until it is cancelled; without it rescans are refused
+	ScanContext context.Context
+	Scan        indexer.ScanOptions
+}
+
+// Server renders the pages of the web interface from an open index
+type Server struct {
+	indexer     *indexer.Indexer
+	templates   map[string]*template.Template
+	auth        Auth
+	ctx         context.Context
+	scanOptions indexer.ScanOptions
+
+	scanMu   sync.Mutex
+	scanning bool
+	scans    sync.WaitGroup
+}
+
+// NewServer creates a server for an index that has already been loaded
+func NewServer(idx *indexer.Indexer, opts Options) (*Server, error) {
+	s := &Server{indexer: idx, templates: make(map[string]*template.Template),
+		auth: opts.Auth, ctx: opts.ScanContext, scanOptions: opts.Scan}
+	for _, page := range pages {
+		tmpl, err := template.New("layout.html").Funcs(templateFuncs).ParseFS(templateFiles, "templates/layout.html", "templates/"+page+".html")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s template: %v", page, err)
+		}
+		s.templates[page] = tmpl
+	}
+	return s, nil
+}
+
+// Handler returns the HTTP handler serving all pages
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	static, _ := fs.Sub(staticFiles, "static")
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServerFS(static)))
+	mux.HandleFunc("GET /{$}", s.require(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/browse", http.StatusFound)
+	}))
+	mux.HandleFunc("GET /browse", s.require(ScopeRead, s.handleBrowse))
+	mux.HandleFunc("GET /search", s.require(ScopeRead, s.handleSearch))
+	mux.HandleFunc("GET /duplicates", s.require(ScopeRead, s.handleDuplicates))
+	mux.HandleFunc("GET /stats", s.require(ScopeRead, s.handleStats))
+	mux.HandleFunc("POST /api/sql", s.require(ScopeWrite, s.handleSQL))
+	mux.HandleFunc("POST /api/rescan", s.require(ScopeWrite, s.handleRescan))
+	return mux
+}
+
+// render executes a page template, reporting template errors to the client
+func (s *Server) render(w http.ResponseWriter, page string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.templates[page].Execute(w, data); err != nil {
+		slog.Error("Error rendering page", "page", page, "err", err)
+		http.Error(w, "error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// fail reports an error reading the index to the client
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	slog.Error("Error reading index", "url", r.URL.String(), "err", err)
+	http.Error(w, "error reading index", http.StatusInternalServerError)
+}
+
+// breadcrumb is one link of the path shown above a directory listing
+type breadcrumb struct {
+	Name string
+	Path string
+}
+
+// browsePage is the data of the directory browser
+type browsePage struct {
+	Dir         string
+	Breadcrumbs []breadcrumb
+	Roots       []models.RootInfo
+	Usage       *models.DirectoryUsage
+	Subdirs     []models.DirectoryUsage
+	Files       []models.FileInfo
+	Pager       pager
+}
+
+// handleBrowse lists the subdirectories and files of a directory, or the
+// indexed roots if no directory is given
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	roots, err := s.indexer.Roots(ctx)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	data := browsePage{Dir: r.URL.Query().Get("dir")}
+	if data.Dir == "" {
+		data.Roots = roots
+		s.render(w, "browse", data)
+		return
+	}
+	data.Dir = filepath.Clean(data.Dir)
+
+	usage, err := s.indexer.DirectoryUsage(ctx, data.Dir, 1)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	for _, entry := range usage {
+		if entry.Depth == 0 {
+			data.Usage = &entry
+		} else {
+			data.Subdirs = append(data.Subdirs, entry)
+		}
+	}
+	sort.Slice(data.Subdirs, func(a, b int) bool { return data.Subdirs[a].TotalSize > data.Subdirs[b].TotalSize })
+
+	query := models.FileQuery{Dir: data.Dir, Sort: models.SortSize, Desc: true}
+	count, err := s.indexer.CountMatches(ctx, "", false, query)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	data.Pager = newPager(r, count)
+	query.Limit, query.Offset = pageSize, data.Pager.Offset
+	if data.Files, err = s.indexer.ListFiles(ctx, query); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	// Link every ancestor of the directory up to the indexed root containing it
+	var top string
+	for _, root := range roots {
+		if (data.Dir == root.Path || strings.HasPrefix(data.Dir, root.Path+string(filepath.Separator))) && len(root.Path) > len(top) {
+			top = root.Path
+		}
+	}
+	for dir := data.Dir; ; dir = filepath.Dir(dir) {
+		name := filepath.Base(dir)
+		if dir == top {
+			name = dir
+		}
+		data.Breadcrumbs = append([]breadcrumb{{Name: name, Path: dir}}, data.Breadcrumbs...)
+		if dir == top || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	s.render(w, "browse", data)
+}
+
+// searchPage is the data of the search page
+type searchPage struct {
+	Query   string
+	Content bool
+	Sort    string
+	Desc    bool
+	Files   []models.FileInfo
+	Pager   pager
+	Error   string
+}
+
+// handleSearch searches files by name, path, MIME type and optionally content
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	data := searchPage{
+		Query:   params.Get("q"),
+		Content: params.Get("content") != "",
+		Sort:    params.Get("sort"),
+		Desc:    params.Get("desc") != "",
+	}
+	if data.Query == "" {
+		s.render(w, "search", data)
+		return
+	}
+
+	sortField, err := models.ParseSortField(data.Sort)
+	if err != nil {
+		data.Error = err.Error()
+		s.render(w, "search", data)
+		return
+	}
+	query := models.FileQuery{Sort: sortField, Desc: data.Desc}
+	count, err := s.indexer.CountMatches(r.Context(), data.Query, data.Content, query)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	data.Pager = newPager(r, count)
+	query.Limit, query.Offset = pageSize, data.Pager.Offset
+	if data.Content {
+		data.Files, err = s.indexer.SearchContent(r.Context(), data.Query, query)
+	} else {
+		data.Files, err = s.indexer.Search(r.Context(), data.Query, query)
+	}
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	s.render(w, "search", data)
+}
+
+// duplicatesPage is the data of the duplicate groups page
+type duplicatesPage struct {
+	Groups      []models.DuplicateGroup
+	TotalWasted int64
+	Pager       pager
+}
+
+// handleDuplicates lists duplicate groups, most wasted space first
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.indexer.FindDuplicates(r.Context(), indexer.OriginalPolicy{Rules: []indexer.OriginalRule{indexer.OriginalPath}})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	sort.SliceStable(groups, func(a, b int) bool { return groups[a].WastedSpace > groups[b].WastedSpace })
+
+	data := duplicatesPage{Pager: newPager(r, int64(len(groups)))}
+	for _, group := range groups {
+		data.TotalWasted += group.WastedSpace
+	}
+	end := min(data.Pager.Offset+pageSize, len(groups))
+	data.Groups = groups[data.Pager.Offset:end]
+	s.render(w, "duplicates", data)
+}
+
+// countBar is one row of a bar chart on the statistics page
+type countBar struct {
+	Label   string
+	Count   int64
+	Size    int64
+	Percent float64 // Bar length relative to the largest row
+}
+
+// statsPage is the data of the statistics page
+type statsPage struct {
+	Stats      map[string]interface{}
+	Roots      []models.RootInfo
+	Duplicates models.DuplicateSummary
+	Sizes      []countBar
+	MimeTypes  []countBar
+	FileTypes  []countBar
+}
+
+// handleStats shows index statistics with bar charts
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.indexer.GetStats(r.Context())
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	data := statsPage{Stats: stats}
+	data.Roots, _ = stats["roots"].([]models.RootInfo)
+	data.Duplicates, _ = stats["duplicates"].(models.DuplicateSummary)
+
+	if histogram, ok := stats["size_histogram"].([]models.SizeBucket); ok {
+		for _, bucket := range histogram {
+			data.Sizes = append(data.Sizes, countBar{Label: bucket.Label, Count: bucket.FileCount, Size: bucket.TotalSize})
+		}
+		scaleBars(data.Sizes)
+	}
+	if mimeTypes, ok := stats["mime_types"].(map[string]int); ok {
+		data.MimeTypes = topBars(mimeTypes, 15)
+	}
+	if fileTypes, ok := stats["file_types"].(map[string]int); ok {
+		data.FileTypes = topBars(fileTypes, 15)
+	}
+	s.render(w, "stats", data)
+}
+
+// topBars turns a count map into the n largest bars
+func topBars(counts map[string]int, n int) []countBar {
+	bars := make([]countBar, 0, len(counts))
+	for label, count := range counts {
+		bars = append(bars, countBar{Label: label, Count: int64(count)})
+	}
+	sort.Slice(bars, func(a, b int) bool {
+		if bars[a].Count != bars[b].Count {
+			return bars[a].Count > bars[b].Count
+		}
+		return bars[a].Label < bars[b].Label
+	})
+	if len(bars) > n {
+		bars = bars[:n]
+	}
+	scaleBars(bars)
+	return bars
+}
+
+// scaleBars sets the bar lengths relative to the largest count
+func scaleBars(bars []countBar) {
+	var maxCount int64
+	for _, bar := range bars {
+		maxCount = max(maxCount, bar.Count)
+	}
+	for n := range bars {
+		if maxCount > 0 {
+			bars[n].Percent = float64(bars[n].Count) * 100 / float64(maxCount)
+		}
+	}
+}
+
+// pager tracks the current page of a paginated listing
+type pager struct {
+	Page   int
+	Pages  int
+	Total  int64
+	Offset int
+	query  string // The request's query string without the page parameter
+}
+
+// newPager reads the page parameter of a request for a listing of total items
+func newPager(r *http.Request, total int64) pager {
+	p := pager{Total: total, Pages: int((total + pageSize - 1) / pageSize)}
+	p.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	p.Page = max(1, min(p.Page, p.Pages))
+	p.Offset = (p.Page - 1) * pageSize
+
+	params := r.URL.Query()
+	params.Del("page")
+	p.query = params.Encode()
+	return p
+}
+
+// Link returns the URL query string for another page of the listing
+func (p pager) Link(page int) template.URL {
+	link := "?page=" + strconv.Itoa(page)
+	if p.query != "" {
+		link += "&" + p.query
+	}
+	return template.URL(link)
+}
+
+// templateFuncs are the helpers available in page templates
+var templateFuncs = template.FuncMap{
+	"size": models.FormatSize,
+	"base": filepath.Base,
+	"dir":  filepath.Dir,
+	"join": strings.Join,
+	"inc":  func(n int) int { return n + 1 },
+	"percent": func(part, total int64) string {
+		if total <= 0 {
+			return "0"
+		}
+		return strconv.FormatFloat(float64(part)*100/float64(total), 'f', 1, 64)
+	},
+	"dec": func(n int) int { return n - 1 },
+}